@@ -2,6 +2,8 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net"
@@ -9,79 +11,261 @@ import (
 	http_pprof "net/http/pprof"
 	"runtime/pprof"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/anywherelan/awl/awlevent"
 	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/logview"
 	"github.com/anywherelan/awl/p2p"
-	"github.com/anywherelan/awl/ringbuffer"
 	"github.com/anywherelan/awl/service"
 	"github.com/go-playground/validator/v10"
 	"github.com/ipfs/go-log/v2"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/grpc"
 )
 
 type DNSService interface {
 	AwlDNSAddress() string
 	IsAwlDNSSetAsSystem() bool
+	RefreshConfig()
+}
+
+// FileTransfer and SOCKS5Proxy are interfaces of optional services, so they aren't linked into builds without them,
+// see config.Features.
+type FileTransfer interface {
+	Send(peerID peer.ID, path string) (service.FileTransferStatus, error)
+	Transfers() []service.FileTransferStatus
+}
+
+type SOCKS5Proxy interface {
+	Restart() error
 }
 
 type Handler struct {
-	conf       *config.Config
-	logger     *log.ZapEventLogger
-	p2p        *p2p.P2p
-	authStatus *service.AuthStatus
-	tunnel     *service.Tunnel
-	dns        DNSService
-	logBuffer  *ringbuffer.RingBuffer
+	conf         *config.Config
+	logger       *log.ZapEventLogger
+	p2p          *p2p.P2p
+	authStatus   *service.AuthStatus
+	tunnel       *service.Tunnel
+	shaper       *service.Shaper
+	routing      *service.Routing
+	probe        *service.Probe
+	speedTest    *service.SpeedTest
+	backup       *service.Backup
+	scheduler    *service.Scheduler
+	usage        *service.Usage
+	sharedFolder *service.SharedFolder
+	support      *service.Support
+	clock        *service.Clock
+	power        *service.Power
+	latency      *service.Latency
+	management   *service.Management
+	portScan     *service.PortScan
+	sharedGroups *service.SharedGroups
+	fileTransfer FileTransfer
+	messages     *service.Messages
+	socks5       SOCKS5Proxy
+	streams      *service.StreamRegistry
+	dns          DNSService
+	logs         *logview.Store
+	eventbus     awlevent.Bus
 
 	echo      *echo.Echo
 	echoAdmin *echo.Echo
+	echoKiosk *echo.Echo
+	// echoManagement serves management streams of peers, it isn't affected by Restart
+	echoManagement *echo.Echo
+	// serversLock guards servers above, they are replaced by Restart
+	serversLock sync.RWMutex
+	frontend    fs.FS
+	// failed is set when any web server stopped with error
+	failed atomic.Bool
+
+	// grpcServer is nil if gRPC api is disabled, it isn't affected by Restart
+	grpcServer   *grpc.Server
+	grpcListener net.Listener
 
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 }
 
 func NewHandler(conf *config.Config, p2p *p2p.P2p, authStatus *service.AuthStatus,
-	tunnel *service.Tunnel, logBuffer *ringbuffer.RingBuffer, dns DNSService) *Handler {
+	tunnel *service.Tunnel, shaper *service.Shaper, routing *service.Routing, probe *service.Probe, speedTest *service.SpeedTest, backup *service.Backup, scheduler *service.Scheduler, usage *service.Usage, sharedFolder *service.SharedFolder, support *service.Support, clock *service.Clock, power *service.Power, latency *service.Latency, management *service.Management, portScan *service.PortScan, sharedGroups *service.SharedGroups, fileTransfer FileTransfer, messages *service.Messages, socks5 SOCKS5Proxy, streams *service.StreamRegistry, logs *logview.Store, dns DNSService, eventbus awlevent.Bus) *Handler {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	return &Handler{
-		conf:       conf,
-		p2p:        p2p,
-		authStatus: authStatus,
-		tunnel:     tunnel,
-		dns:        dns,
-		logBuffer:  logBuffer,
-		logger:     log.Logger("awl/api"),
-		ctx:        ctx,
-		ctxCancel:  ctxCancel,
+		conf:         conf,
+		p2p:          p2p,
+		authStatus:   authStatus,
+		tunnel:       tunnel,
+		shaper:       shaper,
+		routing:      routing,
+		probe:        probe,
+		speedTest:    speedTest,
+		backup:       backup,
+		scheduler:    scheduler,
+		usage:        usage,
+		sharedFolder: sharedFolder,
+		support:      support,
+		clock:        clock,
+		power:        power,
+		latency:      latency,
+		management:   management,
+		portScan:     portScan,
+		sharedGroups: sharedGroups,
+		fileTransfer: fileTransfer,
+		messages:     messages,
+		socks5:       socks5,
+		streams:      streams,
+		dns:          dns,
+		logs:         logs,
+		eventbus:     eventbus,
+		logger:       log.Logger("awl/api"),
+		ctx:          ctx,
+		ctxCancel:    ctxCancel,
 	}
 }
 
 func (h *Handler) SetupAPI() error {
-	e1, err := h.setupRouter(h.conf.HttpListenAddress)
+	err := h.setupServers(h.conf.HttpListenAddress)
+	if err != nil {
+		return err
+	}
+	err = h.setupManagementServer()
 	if err != nil {
 		return err
 	}
-	h.echo = e1
+	return h.setupGRPC()
+}
 
+func (h *Handler) setupServers(address string) error {
+	tlsConfig, err := h.apiTLSConfig()
+	if err != nil {
+		return fmt.Errorf("web server tls: %v", err)
+	}
+	_, err = h.conf.AllowsAPIAddress(net.IPv6loopback)
+	if err != nil {
+		return fmt.Errorf("web server networks: %v", err)
+	}
+	e1, err := h.setupRouter(address, false, tlsConfig)
+	if err != nil {
+		return err
+	}
+	h.conf.RLock()
+	authEnabled := len(h.conf.APIAuth.Keys) > 0
+	networksLimited := len(h.conf.APIAuth.AllowedNetworks) > 0
+	h.conf.RUnlock()
+	if host, _, _ := net.SplitHostPort(address); !authEnabled && !networksLimited && !isLoopbackHost(host) {
+		h.logger.Warnf("web server on %s doesn't require api keys, anyone who can connect to it can control awl", address)
+	}
+
+	var echoAdmin *echo.Echo
 	if h.conf.HttpListenOnAdminHost {
-		echoAdmin, err := h.setupRouter(config.AdminHttpServerListenAddress)
+		echoAdmin, err = h.setupRouter(config.AdminHttpServerListenAddress, false, nil)
 		if err != nil {
 			h.logger.Errorf("unable to bind web server on admin host %s: %v", config.AdminHttpServerListenAddress, err)
-		} else {
-			h.echoAdmin = echoAdmin
 		}
 	}
 
+	h.conf.RLock()
+	kioskAddress := h.conf.KioskListenAddress
+	h.conf.RUnlock()
+	var echoKiosk *echo.Echo
+	if kioskAddress != "" {
+		echoKiosk, err = h.setupRouter(kioskAddress, true, nil)
+		if err != nil {
+			h.logger.Errorf("unable to bind kiosk web server on %s: %v", kioskAddress, err)
+		}
+	}
+
+	h.serversLock.Lock()
+	h.echo, h.echoAdmin, h.echoKiosk = e1, echoAdmin, echoKiosk
+	h.serversLock.Unlock()
+
+	return nil
+}
+
+// Failed returns true if any web server stopped with error, e.g. accepting connections failed.
+func (h *Handler) Failed() bool {
+	return h.failed.Load()
+}
+
+// Restart shuts down web servers and starts them again. Main server is started on the same address,
+// even if the port was chosen by the system.
+func (h *Handler) Restart() error {
+	h.serversLock.RLock()
+	address := h.echo.Listener.Addr().String()
+	frontend := h.frontend
+	servers := []*echo.Echo{h.echo, h.echoAdmin, h.echoKiosk}
+	h.serversLock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	err := h.shutdownServers(ctx)
+	cancel()
+	if err != nil {
+		h.logger.Warnf("shutdown web server before restart: %v", err)
+	}
+	// Shutdown doesn't close listeners which aren't served yet, they are needed to bind the same addresses
+	for _, e := range servers {
+		if e != nil {
+			_ = e.Listener.Close()
+		}
+	}
+
+	err = h.setupServers(address)
+	if err != nil {
+		return err
+	}
+	h.failed.Store(false)
+	if frontend != nil {
+		h.SetupFrontend(frontend)
+	}
 	return nil
 }
 
-func (h *Handler) setupRouter(address string) (*echo.Echo, error) {
+// setupRouter starts web server on address. Read-only server serves only kioskPaths, see config.Config.KioskListenAddress.
+// The rest of servers check api keys, TLS is disabled if tlsConfig is nil. All servers check networks of clients.
+func (h *Handler) setupRouter(address string, readOnly bool, tlsConfig *tls.Config) (*echo.Echo, error) {
+	accessMiddleware := h.apiAuthMiddleware
+	if readOnly {
+		accessMiddleware = kioskMiddleware
+	}
+	e, err := h.newRouter(h.apiNetworksMiddleware, accessMiddleware)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to bind address %s: %v", address, err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https"
+	}
+	e.Listener = listener
+	h.logger.Infof("starting web server on %s://%s", scheme, listener.Addr().String())
+	go func() {
+		if err := e.StartServer(e.Server); err != nil && err != http.ErrServerClosed {
+			h.logger.Warnf("shutting down web server %s: %v", address, err)
+			h.failed.Store(true)
+		}
+	}()
+
+	return e, nil
+}
+
+// newRouter returns api router, access to it is checked by middlewares.
+func (h *Handler) newRouter(middlewares ...echo.MiddlewareFunc) (*echo.Echo, error) {
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
-	val := validator.New()
-	err := val.RegisterValidation("trimmed_str_not_empty", validateTrimmedStringNotEmpty, false)
+	val, err := newValidator()
 	if err != nil {
 		return nil, err
 	}
@@ -92,6 +276,7 @@ func (h *Handler) setupRouter(address string) (*echo.Echo, error) {
 	if !h.conf.DevMode() {
 		e.Use(middleware.Recover())
 	}
+	e.Use(middlewares...)
 
 	// Routes
 
@@ -100,19 +285,113 @@ func (h *Handler) setupRouter(address string) (*echo.Echo, error) {
 	e.POST(GetKnownPeerSettingsPath, h.GetKnownPeerSettings)
 	e.POST(SendFriendRequestPath, h.SendFriendRequest)
 	e.POST(AcceptPeerInvitationPath, h.AcceptFriend)
+	e.POST(CreateInvitePath, h.CreateInvite)
+	e.POST(AcceptInvitePath, h.AcceptInvite)
 	e.POST(UpdatePeerSettingsPath, h.UpdatePeerSettings)
+	e.POST(UpdatePeerACLPath, h.UpdatePeerACL)
+	e.POST(UpdatePeerMTUPath, h.UpdatePeerMTU)
+	e.POST(UpdatePeerRoutesPath, h.UpdatePeerRoutes)
+	e.POST(UpdatePeerBandwidthLimitPath, h.UpdatePeerBandwidthLimit)
+	e.POST(UpdatePeerNotesPath, h.UpdatePeerNotes)
+	e.POST(UpdatePeerGroupsPath, h.UpdatePeerGroups)
+	e.GET(GetPeerGroupsPath, h.GetPeerGroups)
+	e.POST(UpdatePeerGroupPath, h.UpdatePeerGroup)
+	e.POST(RemovePeerGroupPath, h.RemovePeerGroup)
 	e.POST(RemovePeerSettingsPath, h.RemovePeer)
 	e.GET(GetAuthRequestsPath, h.GetAuthRequests)
 	e.GET(GetBlockedPeersPath, h.GetBlockedPeers)
+	e.POST(ImportPeersPath, h.ImportPeers)
+	e.POST(EchoPeerPath, h.EchoPeer)
+	e.GET(GetPeerLatencyPath, h.GetPeerLatency)
+	e.POST(RunSpeedTestPath, h.RunSpeedTest)
+	e.GET(GetSpeedTestHistoryPath, h.GetSpeedTestHistory)
+	e.POST(GetSupportReportPath, h.GetSupportReport)
+	e.POST(PortScanPath, h.PortScan)
 
 	// Settings
 	e.GET(GetMyPeerInfoPath, h.GetMyPeerInfo)
 	e.POST(UpdateMyInfoPath, h.UpdateMySettings)
 	e.GET(ExportServerConfigPath, h.ExportServerConfiguration)
+	e.POST(UpdateDNSRecordsPath, h.UpdateDNSRecords)
+	e.POST(UpdateKillSwitchPath, h.UpdateKillSwitch)
+	e.POST(UpdateVPNPausePath, h.UpdateVPNPause)
+	e.POST(UpdateRendezvousPath, h.UpdateRendezvous)
+	e.POST(UpdateSharedFolderPath, h.UpdateSharedFolder)
+	e.POST(UpdateExitNodePath, h.UpdateExitNode)
+	e.POST(UpdateAdvertisedRoutesPath, h.UpdateAdvertisedRoutes)
+	e.GET(GetConnectionGaterPath, h.GetConnectionGater)
+	e.POST(UpdateConnectionGaterPath, h.UpdateConnectionGater)
+	e.GET(GetAPIKeysPath, h.GetAPIKeys)
+	e.POST(CreateAPIKeyPath, h.CreateAPIKey)
+	e.POST(RemoveAPIKeyPath, h.RemoveAPIKey)
+	e.GET(GetBootstrapPeersPath, h.GetBootstrapPeers)
+	e.POST(AddBootstrapPeerPath, h.AddBootstrapPeer)
+	e.POST(RemoveBootstrapPeerPath, h.RemoveBootstrapPeer)
+	e.POST(TestBootstrapPeerPath, h.TestBootstrapPeer)
+	e.POST(RotateIdentityPath, h.RotateIdentity)
+	e.GET(GetIdentityMigrationPath, h.GetIdentityMigration)
+	e.POST(UpdateConfigEncryptionPath, h.UpdateConfigEncryption)
+	e.POST(UpdatePacketFilterPath, h.UpdatePacketFilter)
+	e.POST(UpdateBandwidthLimitPath, h.UpdateBandwidthLimit)
+	e.POST(UpdateQoSPath, h.UpdateQoS)
+	e.POST(UpdateIPAllocationPath, h.UpdateIPAllocation)
+	e.GET(GetSubnetConflictsPath, h.GetSubnetConflicts)
+	e.POST(UpdateAutoRenumberPath, h.UpdateAutoRenumber)
+	e.GET(GetPowerProfilePath, h.GetPowerProfile)
+	e.POST(UpdatePowerModePath, h.UpdatePowerMode)
+
+	// Flows
+	e.GET(GetFlowsPath, h.GetFlows)
+
+	// Usage
+	e.GET(ExportUsagePath, h.ExportUsage)
+
+	// Shared folders
+	e.Match(webDAVMethods, SharedFolderPath, h.ProxySharedFolder)
+	e.Match(webDAVMethods, SharedFolderFilePath, h.ProxySharedFolder)
+
+	// File transfer and SOCKS5 are optional features, they could be excluded with build tags, see config.Features
+	h.setupFileTransferRoutes(e)
+	h.setupSOCKS5Routes(e)
+	h.setupDNSRoutes(e)
+
+	// Messages
+	e.POST(SendMessagePath, h.SendMessage)
+	e.GET(GetMessagesPath, h.GetMessages)
+	e.POST(MarkMessagesReadPath, h.MarkMessagesRead)
+
+	// Management of peers
+	e.Any(ManagementAPIPath, h.ProxyManagement)
+
+	// Backup
+	e.POST(RunBackupPath, h.RunBackup)
+	e.POST(RestoreBackupPath, h.RestoreBackup)
+	e.POST(ExportBackupPath, h.ExportBackup)
+	e.POST(ImportBackupPath, h.ImportBackup)
+
+	// Scheduled jobs
+	e.GET(GetScheduledJobsPath, h.GetScheduledJobs)
+	e.POST(UpdateScheduledJobsPath, h.UpdateScheduledJobs)
+	e.GET(GetScheduledJobReportsPath, h.GetScheduledJobReports)
+
+	// Events
+	e.GET(EventsPath, h.StreamEvents)
+
+	// Search
+	e.GET(SearchPath, h.Search)
 
 	// Debug
 	e.GET(GetP2pDebugInfoPath, h.GetP2pDebugInfo)
 	e.GET(GetDebugLogPath, h.GetLog)
+	e.GET(GetLogEntriesPath, h.GetLogEntries)
+	e.GET(DownloadLogPath, h.DownloadLog)
+	e.GET(GetStatsSnapshotPath, h.GetStatsSnapshot)
+	e.GET(GetDHTRoutingTablePath, h.GetDHTRoutingTable)
+	e.GET(GetStreamHandlersPath, h.GetStreamHandlers)
+	e.GET(GetPacketFilterPath, h.GetPacketFilter)
+	e.GET(GetBandwidthLimitPath, h.GetBandwidthLimit)
+	e.GET(GetQoSPath, h.GetQoS)
+	e.GET(GetPeerDiagnosticsPath, h.GetPeerDiagnostics)
 
 	if h.conf.DevMode() {
 		e.Any(V0Prefix+"debug/pprof/", echo.WrapHandler(http.HandlerFunc(http_pprof.Index)))
@@ -127,43 +406,83 @@ func (h *Handler) setupRouter(address string) (*echo.Echo, error) {
 		}
 	}
 
-	// Start
-	listener, err := net.Listen("tcp", address)
-	if err != nil {
-		return nil, fmt.Errorf("unable to bind address %s: %v", address, err)
-	}
-	e.Listener = listener
-	h.logger.Infof("starting web server on http://%s", listener.Addr().String())
-	go func() {
-		if err := e.StartServer(e.Server); err != nil && err != http.ErrServerClosed {
-			h.logger.Warnf("shutting down web server %s: %v", address, err)
-		}
-	}()
-
 	return e, nil
 }
 
+// SetupFrontend serves web ui, fsys is nil in builds without it.
 func (h *Handler) SetupFrontend(fsys fs.FS) {
+	if fsys == nil {
+		return
+	}
+	h.serversLock.Lock()
+	defer h.serversLock.Unlock()
+	h.frontend = fsys
 	fileServer := http.FileServer(http.FS(fsys))
 	h.echo.GET("/*", echo.WrapHandler(fileServer))
 	if h.echoAdmin != nil {
 		h.echoAdmin.GET("/*", echo.WrapHandler(fileServer))
 	}
+	if h.echoKiosk != nil {
+		h.echoKiosk.GET("/*", echo.WrapHandler(fileServer))
+	}
 }
 
 func (h *Handler) Shutdown(ctx context.Context) error {
-	if h.echoAdmin != nil {
-		err := h.echoAdmin.Server.Shutdown(ctx)
+	h.shutdownGRPC(ctx)
+	h.shutdownManagementServer(ctx)
+	return h.shutdownServers(ctx)
+}
+
+func (h *Handler) shutdownServers(ctx context.Context) error {
+	h.serversLock.RLock()
+	e1, echoAdmin, echoKiosk := h.echo, h.echoAdmin, h.echoKiosk
+	h.serversLock.RUnlock()
+
+	if echoAdmin != nil {
+		err := echoAdmin.Server.Shutdown(ctx)
 		if err != nil {
 			h.logger.Errorf("error shutting down web server on admin host %s: %v", config.AdminHttpServerListenAddress, err)
 		}
 	}
+	if echoKiosk != nil {
+		err := echoKiosk.Server.Shutdown(ctx)
+		if err != nil {
+			h.logger.Errorf("error shutting down kiosk web server: %v", err)
+		}
+	}
 
-	return h.echo.Server.Shutdown(ctx)
+	return e1.Server.Shutdown(ctx)
 }
 
 func (h *Handler) Address() string {
-	address := h.echo.Listener.Addr().String()
+	h.serversLock.RLock()
+	defer h.serversLock.RUnlock()
+	return listenerAddress(h.echo)
+}
+
+// URL returns address of web server with https scheme if TLS is enabled.
+func (h *Handler) URL() string {
+	h.conf.RLock()
+	scheme := "http"
+	if h.conf.APIAuth.CertFile != "" {
+		scheme = "https"
+	}
+	h.conf.RUnlock()
+	return scheme + "://" + h.Address()
+}
+
+// KioskAddress returns address of read-only web server, empty if it is disabled.
+func (h *Handler) KioskAddress() string {
+	h.serversLock.RLock()
+	defer h.serversLock.RUnlock()
+	if h.echoKiosk == nil {
+		return ""
+	}
+	return listenerAddress(h.echoKiosk)
+}
+
+func listenerAddress(e *echo.Echo) string {
+	address := e.Listener.Addr().String()
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		panic(err)
@@ -178,6 +497,15 @@ func (h *Handler) Address() string {
 	return net.JoinHostPort(ip.String(), port)
 }
 
+func newValidator() (*validator.Validate, error) {
+	val := validator.New()
+	err := val.RegisterValidation("trimmed_str_not_empty", validateTrimmedStringNotEmpty, false)
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
 type customValidator struct {
 	validator *validator.Validate
 }
@@ -198,6 +526,28 @@ func ErrorMessage(message string) Error {
 	return Error{Message: message}
 }
 
+// statusError is an error of the operation shared by REST and gRPC handlers, status is HTTP status code of the error.
+type statusError struct {
+	status  int
+	message string
+}
+
+func newStatusError(status int, message string) statusError {
+	return statusError{status: status, message: message}
+}
+
+func (e statusError) Error() string {
+	return e.message
+}
+
+func replyStatusError(c echo.Context, err error) error {
+	var statusErr statusError
+	if errors.As(err, &statusErr) {
+		return c.JSON(statusErr.status, ErrorMessage(statusErr.message))
+	}
+	return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+}
+
 func validateTrimmedStringNotEmpty(fl validator.FieldLevel) bool {
 	str := fl.Field().String()
 	str = strings.TrimSpace(str)
@@ -2,21 +2,45 @@ package apiclient
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/anywherelan/awl/api"
 	"github.com/anywherelan/awl/config"
 	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/p2p"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/service"
 	"github.com/google/go-querystring/query"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	supportReportTimeout = 2 * time.Minute
+	// rotateIdentityTimeout is longer than identity migration is sent to peers
+	rotateIdentityTimeout = 30 * time.Second
+	// speedTestTimeout is longer than service.SpeedTestTimeout
+	speedTestTimeout = 90 * time.Second
+	// portScanTimeout is longer than port scan timeout of the api
+	portScanTimeout = 90 * time.Second
+	// peerDiagnosticsTimeout is longer than p2p.DiagnosticsTimeout
+	peerDiagnosticsTimeout = p2p.DiagnosticsTimeout + 10*time.Second
 )
 
 type Client struct {
 	address string
 	cli     *http.Client
+
+	apiKey    string
+	tlsConfig *tls.Config
+	// pathPrefix is added to api paths, it's set by SetManagedPeer
+	pathPrefix string
 }
 
 func New(address string) *Client {
@@ -29,6 +53,45 @@ func New(address string) *Client {
 	}
 }
 
+// SetAPIKey sets the key which is sent with every request, see config.APIAuthConfig.
+func (c *Client) SetAPIKey(key string) {
+	c.apiKey = key
+	c.updateTransport()
+}
+
+// SetTLSConfig switches the client to https, tlsConfig is used to verify the server.
+func (c *Client) SetTLSConfig(tlsConfig *tls.Config) {
+	c.tlsConfig = tlsConfig
+	c.updateTransport()
+}
+
+// SetManagedPeer sends requests to the api of the known peer, which are proxied by our api over awl network.
+// The peer should allow us to manage it, see config.KnownPeer.ManagementAllowed.
+func (c *Client) SetManagedPeer(peerID string) {
+	c.pathPrefix = protocol.ManagementPathPrefix + peerID
+}
+
+func (c *Client) updateTransport() {
+	c.cli.Transport = &authTransport{
+		base:   &http.Transport{TLSClientConfig: c.tlsConfig},
+		apiKey: c.apiKey,
+	}
+}
+
+// authTransport adds api key to requests.
+type authTransport struct {
+	base   http.RoundTripper
+	apiKey string
+}
+
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.apiKey != "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+	return t.base.RoundTrip(r)
+}
+
 func (c *Client) KnownPeers() ([]entity.KnownPeersResponse, error) {
 	knownPeers := make([]entity.KnownPeersResponse, 0)
 	err := c.sendGetRequest(api.GetKnownPeersPath, &knownPeers)
@@ -38,6 +101,27 @@ func (c *Client) KnownPeers() ([]entity.KnownPeersResponse, error) {
 	return knownPeers, nil
 }
 
+// KnownPeersInGroup returns known peers of the group.
+func (c *Client) KnownPeersInGroup(group string) ([]entity.KnownPeersResponse, error) {
+	reqURL, err := c.getUrl(api.GetKnownPeersPath, entity.KnownPeersRequest{Group: group})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	knownPeers := make([]entity.KnownPeersResponse, 0)
+	err = c.readResponseBody(resp, &knownPeers)
+	if err != nil {
+		return nil, err
+	}
+	return knownPeers, nil
+}
+
 func (c *Client) KnownPeerConfig(peerID string) (*config.KnownPeer, error) {
 	knownPeer := new(config.KnownPeer)
 	request := entity.PeerIDRequest{PeerID: peerID}
@@ -57,19 +141,43 @@ func (c *Client) PeerInfo() (*entity.PeerInfo, error) {
 	return peerInfo, nil
 }
 
-func (c *Client) SendFriendRequest(peerID, alias string) error {
+// SendFriendRequest adds the peer with ipAddr, empty address is allocated by config.VPNConfig.IPAllocation.
+func (c *Client) SendFriendRequest(peerID, alias, ipAddr string) error {
 	request := entity.FriendRequest{
 		PeerID: peerID,
 		Alias:  alias,
+		IPAddr: ipAddr,
 	}
 	return c.sendPostRequest(api.SendFriendRequestPath, request, nil)
 }
 
-func (c *Client) ReplyFriendRequest(peerID, alias string, decline bool) error {
+func (c *Client) CreateInvite(alias string, expiresInHours int) (*entity.CreateInviteResponse, error) {
+	request := entity.CreateInviteRequest{Alias: alias, ExpiresInHours: expiresInHours}
+	response := new(entity.CreateInviteResponse)
+	err := c.sendPostRequest(api.CreateInvitePath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *Client) AcceptInvite(token, alias string) (*entity.AcceptInviteResponse, error) {
+	request := entity.AcceptInviteRequest{Token: token, Alias: alias}
+	response := new(entity.AcceptInviteResponse)
+	err := c.sendPostRequest(api.AcceptInvitePath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// ReplyFriendRequest accepts the peer with ipAddr like SendFriendRequest or declines it.
+func (c *Client) ReplyFriendRequest(peerID, alias, ipAddr string, decline bool) error {
 	request := entity.FriendRequestReply{
 		PeerID:  peerID,
 		Alias:   alias,
 		Decline: decline,
+		IPAddr:  ipAddr,
 	}
 	return c.sendPostRequest(api.AcceptPeerInvitationPath, request, nil)
 }
@@ -87,6 +195,54 @@ func (c *Client) UpdatePeerSettings(request entity.UpdatePeerSettingsRequest) er
 	return c.sendPostRequest(api.UpdatePeerSettingsPath, request, nil)
 }
 
+func (c *Client) UpdatePeerACL(peerID string, rules []config.ACLRule) error {
+	request := entity.UpdatePeerACLRequest{PeerID: peerID, Rules: rules}
+	return c.sendPostRequest(api.UpdatePeerACLPath, request, nil)
+}
+
+func (c *Client) UpdatePeerMTU(peerID string, mtu int) error {
+	request := entity.UpdatePeerMTURequest{PeerID: peerID, MTU: mtu}
+	return c.sendPostRequest(api.UpdatePeerMTUPath, request, nil)
+}
+
+func (c *Client) UpdatePeerRoutes(peerID string, acceptRoutes, acceptAllRoutes bool) error {
+	request := entity.UpdatePeerRoutesRequest{PeerID: peerID, AcceptRoutes: acceptRoutes, AcceptAllRoutes: acceptAllRoutes}
+	return c.sendPostRequest(api.UpdatePeerRoutesPath, request, nil)
+}
+
+func (c *Client) UpdatePeerBandwidthLimit(peerID string, limit config.BandwidthLimitConfig) error {
+	request := entity.UpdatePeerBandwidthLimitRequest{PeerID: peerID, UploadKbps: limit.UploadKbps, DownloadKbps: limit.DownloadKbps}
+	return c.sendPostRequest(api.UpdatePeerBandwidthLimitPath, request, nil)
+}
+
+func (c *Client) UpdatePeerNotes(peerID, notes string, contact config.PeerContact) error {
+	request := entity.UpdatePeerNotesRequest{PeerID: peerID, Notes: notes, Contact: contact}
+	return c.sendPostRequest(api.UpdatePeerNotesPath, request, nil)
+}
+
+func (c *Client) UpdatePeerGroups(peerID string, groups []string) error {
+	request := entity.UpdatePeerGroupsRequest{PeerID: peerID, Groups: groups}
+	return c.sendPostRequest(api.UpdatePeerGroupsPath, request, nil)
+}
+
+func (c *Client) PeerGroups() ([]entity.PeerGroupResponse, error) {
+	groups := make([]entity.PeerGroupResponse, 0)
+	err := c.sendGetRequest(api.GetPeerGroupsPath, &groups)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (c *Client) UpdatePeerGroup(request entity.UpdatePeerGroupRequest) error {
+	return c.sendPostRequest(api.UpdatePeerGroupPath, request, nil)
+}
+
+func (c *Client) RemovePeerGroup(name string) error {
+	request := entity.RemovePeerGroupRequest{Name: name}
+	return c.sendPostRequest(api.RemovePeerGroupPath, request, nil)
+}
+
 func (c *Client) RemovePeer(peerID string) error {
 	request := entity.PeerIDRequest{PeerID: peerID}
 	return c.sendPostRequest(api.RemovePeerSettingsPath, request, nil)
@@ -99,6 +255,368 @@ func (c *Client) UpdateMySettings(name string) error {
 	return c.sendPostRequest(api.UpdateMyInfoPath, request, nil)
 }
 
+func (c *Client) UpdateDNSRecords(records []string) error {
+	request := entity.UpdateDNSRecordsRequest{
+		Records: records,
+	}
+	return c.sendPostRequest(api.UpdateDNSRecordsPath, request, nil)
+}
+
+func (c *Client) UpdateKillSwitch(enabled bool) error {
+	request := entity.UpdateKillSwitchRequest{
+		Enabled: enabled,
+	}
+	return c.sendPostRequest(api.UpdateKillSwitchPath, request, nil)
+}
+
+func (c *Client) UpdateVPNPause(paused bool) error {
+	request := entity.UpdateVPNPauseRequest{
+		Paused: paused,
+	}
+	return c.sendPostRequest(api.UpdateVPNPausePath, request, nil)
+}
+
+func (c *Client) UpdateRendezvous(phrase string) error {
+	request := entity.UpdateRendezvousRequest{
+		Phrase: phrase,
+	}
+	return c.sendPostRequest(api.UpdateRendezvousPath, request, nil)
+}
+
+func (c *Client) UpdateDNSUpstream(peerID, address string) error {
+	request := entity.UpdateDNSUpstreamRequest{
+		PeerID:  peerID,
+		Address: address,
+	}
+	return c.sendPostRequest(api.UpdateDNSUpstreamPath, request, nil)
+}
+
+func (c *Client) UpdatePacketFilter(request entity.UpdatePacketFilterRequest) error {
+	return c.sendPostRequest(api.UpdatePacketFilterPath, request, nil)
+}
+
+// PacketFilter returns packet filter of the interface reader and numbers of dropped packets.
+func (c *Client) PacketFilter() (*entity.PacketFilterResponse, error) {
+	response := new(entity.PacketFilterResponse)
+	err := c.sendGetRequest(api.GetPacketFilterPath, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *Client) UpdateQoS(qos config.QoSConfig) error {
+	request := entity.UpdateQoSRequest{Disabled: qos.Disabled, Rules: qos.Rules}
+	return c.sendPostRequest(api.UpdateQoSPath, request, nil)
+}
+
+// UpdateIPAllocation sets the strategy of addresses of new peers, see config.VPNConfig.IPAllocation.
+func (c *Client) UpdateIPAllocation(strategy string) error {
+	return c.sendPostRequest(api.UpdateIPAllocationPath, entity.UpdateIPAllocationRequest{Strategy: strategy}, nil)
+}
+
+// SubnetConflicts returns the vpn network and routes of peers which overlap local networks.
+func (c *Client) SubnetConflicts() (*entity.SubnetConflictsResponse, error) {
+	response := new(entity.SubnetConflictsResponse)
+	err := c.sendGetRequest(api.GetSubnetConflictsPath, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// UpdateAutoRenumber sets whether the vpn network is moved to a free one on start if it overlaps a local network.
+func (c *Client) UpdateAutoRenumber(enabled bool) error {
+	return c.sendPostRequest(api.UpdateAutoRenumberPath, entity.UpdateAutoRenumberRequest{Enabled: enabled}, nil)
+}
+
+// QoS returns QoS settings and numbers of packets to peers by priority.
+func (c *Client) QoS() (*entity.QoSResponse, error) {
+	response := new(entity.QoSResponse)
+	err := c.sendGetRequest(api.GetQoSPath, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *Client) UpdateBandwidthLimit(limit config.BandwidthLimitConfig) error {
+	request := entity.UpdateBandwidthLimitRequest{UploadKbps: limit.UploadKbps, DownloadKbps: limit.DownloadKbps}
+	return c.sendPostRequest(api.UpdateBandwidthLimitPath, request, nil)
+}
+
+// BandwidthLimit returns global bandwidth limit, limits of peers and delay of traffic by them.
+func (c *Client) BandwidthLimit() (*entity.BandwidthLimitResponse, error) {
+	response := new(entity.BandwidthLimitResponse)
+	err := c.sendGetRequest(api.GetBandwidthLimitPath, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// PowerProfile returns the power mode, detected power source and whether power save mode is enabled.
+func (c *Client) PowerProfile() (*service.PowerProfile, error) {
+	profile := new(service.PowerProfile)
+	err := c.sendGetRequest(api.GetPowerProfilePath, profile)
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (c *Client) UpdatePowerMode(mode string) (*service.PowerProfile, error) {
+	request := entity.UpdatePowerModeRequest{Mode: mode}
+	profile := new(service.PowerProfile)
+	err := c.sendPostRequest(api.UpdatePowerModePath, request, profile)
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (c *Client) UpdateExitNode(peerID string) error {
+	request := entity.UpdateExitNodeRequest{
+		PeerID: peerID,
+	}
+	return c.sendPostRequest(api.UpdateExitNodePath, request, nil)
+}
+
+func (c *Client) UpdateAdvertisedRoutes(routes []string) error {
+	request := entity.UpdateAdvertisedRoutesRequest{
+		Routes: routes,
+	}
+	return c.sendPostRequest(api.UpdateAdvertisedRoutesPath, request, nil)
+}
+
+func (c *Client) UpdateSharedFolder(path string) error {
+	request := entity.UpdateSharedFolderRequest{
+		Path: path,
+	}
+	return c.sendPostRequest(api.UpdateSharedFolderPath, request, nil)
+}
+
+func (c *Client) UpdateFileTransfer(inboxPath string) error {
+	request := entity.UpdateFileTransferRequest{
+		InboxPath: inboxPath,
+	}
+	return c.sendPostRequest(api.UpdateFileTransferPath, request, nil)
+}
+
+func (c *Client) UpdateSOCKS5(listenAddress, peerID string, resumablePorts ...int) error {
+	request := entity.UpdateSOCKS5Request{
+		ListenAddress:  listenAddress,
+		PeerID:         peerID,
+		ResumablePorts: resumablePorts,
+	}
+	return c.sendPostRequest(api.UpdateSOCKS5Path, request, nil)
+}
+
+func (c *Client) ConnectionGater() (*config.ConnectionGaterConfig, error) {
+	gaterConfig := new(config.ConnectionGaterConfig)
+	err := c.sendGetRequest(api.GetConnectionGaterPath, gaterConfig)
+	if err != nil {
+		return nil, err
+	}
+	return gaterConfig, nil
+}
+
+func (c *Client) UpdateConnectionGater(request entity.UpdateConnectionGaterRequest) error {
+	return c.sendPostRequest(api.UpdateConnectionGaterPath, request, nil)
+}
+
+func (c *Client) BootstrapPeers() ([]entity.BootstrapPeerResponse, error) {
+	peers := make([]entity.BootstrapPeerResponse, 0)
+	err := c.sendGetRequest(api.GetBootstrapPeersPath, &peers)
+	if err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+func (c *Client) AddBootstrapPeer(address string) error {
+	return c.sendPostRequest(api.AddBootstrapPeerPath, entity.BootstrapPeerRequest{Address: address}, nil)
+}
+
+func (c *Client) RemoveBootstrapPeer(address string) error {
+	return c.sendPostRequest(api.RemoveBootstrapPeerPath, entity.BootstrapPeerRequest{Address: address}, nil)
+}
+
+func (c *Client) TestBootstrapPeer(address string) (*entity.TestBootstrapPeerResponse, error) {
+	response := new(entity.TestBootstrapPeerResponse)
+	err := c.sendPostRequest(api.TestBootstrapPeerPath, entity.BootstrapPeerRequest{Address: address}, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// RotateIdentity returns the new peer id, it's applied after restart of the app.
+func (c *Client) RotateIdentity() (*entity.IdentityMigrationResponse, error) {
+	// migration is sent to peers before the response
+	client := *c
+	client.cli = &http.Client{Transport: c.cli.Transport, Timeout: rotateIdentityTimeout}
+	response := new(entity.IdentityMigrationResponse)
+	err := client.sendPostRequest(api.RotateIdentityPath, nil, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *Client) IdentityMigration() (*entity.IdentityMigrationResponse, error) {
+	response := new(entity.IdentityMigrationResponse)
+	err := c.sendGetRequest(api.GetIdentityMigrationPath, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// UpdateConfigEncryption encrypts config at rest, mode is config.EncryptionPassphrase, config.EncryptionKeychain or empty.
+func (c *Client) UpdateConfigEncryption(mode, passphrase string) error {
+	request := entity.UpdateConfigEncryptionRequest{
+		Mode:       mode,
+		Passphrase: passphrase,
+	}
+	return c.sendPostRequest(api.UpdateConfigEncryptionPath, request, nil)
+}
+
+func (c *Client) APIKeys() ([]entity.APIKeyResponse, error) {
+	keys := make([]entity.APIKeyResponse, 0)
+	err := c.sendGetRequest(api.GetAPIKeysPath, &keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CreateAPIKey returns the key, it can't be received again.
+func (c *Client) CreateAPIKey(name, permission string) (string, error) {
+	request := entity.CreateAPIKeyRequest{Name: name, Permission: permission}
+	response := new(entity.CreateAPIKeyResponse)
+	err := c.sendPostRequest(api.CreateAPIKeyPath, request, response)
+	if err != nil {
+		return "", err
+	}
+	return response.Key, nil
+}
+
+func (c *Client) RemoveAPIKey(name string) error {
+	return c.sendPostRequest(api.RemoveAPIKeyPath, entity.RemoveAPIKeyRequest{Name: name}, nil)
+}
+
+func (c *Client) EchoPeer(peerID string, payloadSize int) (*entity.EchoResponse, error) {
+	request := entity.EchoRequest{
+		PeerID:      peerID,
+		PayloadSize: payloadSize,
+	}
+	response := new(entity.EchoResponse)
+	err := c.sendPostRequest(api.EchoPeerPath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// PeerLatency returns summary and history of pings of the known peer.
+func (c *Client) PeerLatency(peerID string) (*entity.PeerLatencyResponse, error) {
+	reqURL, err := c.getUrl(api.GetPeerLatencyPath, entity.PeerLatencyRequest{PeerID: peerID})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := new(entity.PeerLatencyResponse)
+	err = c.readResponseBody(resp, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RunSpeedTest measures throughput to the known peer for seconds each way, zero for default. The result is saved to history.
+func (c *Client) RunSpeedTest(peerID string, seconds int) (*service.SpeedTestResult, error) {
+	client := *c
+	client.cli = &http.Client{Transport: c.cli.Transport, Timeout: speedTestTimeout}
+	response := new(service.SpeedTestResult)
+	err := client.sendPostRequest(api.RunSpeedTestPath, entity.SpeedTestRequest{PeerID: peerID, Seconds: seconds}, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// SpeedTestHistory returns speed tests of the peer or of all peers if peerID is empty. Zero from and to are not limited.
+func (c *Client) SpeedTestHistory(peerID string, from, to time.Time) (*entity.SpeedTestHistoryResponse, error) {
+	reqURL, err := c.getUrl(api.GetSpeedTestHistoryPath, entity.SpeedTestHistoryRequest{PeerID: peerID, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := new(entity.SpeedTestHistoryResponse)
+	err = c.readResponseBody(resp, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) SupportReport(peerID string, logMinutes int) (*service.SupportReport, error) {
+	request := entity.SupportReportRequest{
+		PeerID:     peerID,
+		LogMinutes: logMinutes,
+	}
+	// report is fetched from the peer, it takes longer than other requests
+	client := *c
+	client.cli = &http.Client{Transport: c.cli.Transport, Timeout: supportReportTimeout}
+	response := new(service.SupportReport)
+	err := client.sendPostRequest(api.GetSupportReportPath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// PortScan returns listening TCP ports of the peer or a host of its advertised routes if host isn't empty,
+// ports are like "22,80,8000-8100". The peer should grant us management.
+func (c *Client) PortScan(peerID, host, ports string) (*entity.PortScanResponse, error) {
+	request := entity.PortScanRequest{
+		PeerID: peerID,
+		Host:   host,
+		Ports:  ports,
+	}
+	// ports are probed by the peer, it takes longer than other requests
+	client := *c
+	client.cli = &http.Client{Transport: c.cli.Transport, Timeout: portScanTimeout}
+	response := new(entity.PortScanResponse)
+	err := client.sendPostRequest(api.PortScanPath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *Client) ImportPeers(request entity.ImportPeersRequest) (*entity.ImportPeersResponse, error) {
+	response := new(entity.ImportPeersResponse)
+	err := c.sendPostRequest(api.ImportPeersPath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
 func (c *Client) P2pDebugInfo() (*entity.P2pDebugInfo, error) {
 	debugInfo := new(entity.P2pDebugInfo)
 	err := c.sendGetRequest(api.GetP2pDebugInfoPath, debugInfo)
@@ -108,6 +626,196 @@ func (c *Client) P2pDebugInfo() (*entity.P2pDebugInfo, error) {
 	return debugInfo, nil
 }
 
+func (c *Client) StatsSnapshot() (*p2p.StatsSnapshot, error) {
+	stats := new(p2p.StatsSnapshot)
+	err := c.sendGetRequest(api.GetStatsSnapshotPath, stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (c *Client) DHTRoutingTable() (*p2p.DHTRoutingTable, error) {
+	table := new(p2p.DHTRoutingTable)
+	err := c.sendGetRequest(api.GetDHTRoutingTablePath, table)
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// PeerDiagnostics looks up and dials the peer if it's disconnected, so it takes up to p2p.DiagnosticsTimeout.
+func (c *Client) PeerDiagnostics(peerID string) (*p2p.PeerDiagnostics, error) {
+	client := *c
+	client.cli = &http.Client{Transport: c.cli.Transport, Timeout: peerDiagnosticsTimeout}
+	diagnostics := new(p2p.PeerDiagnostics)
+	err := client.sendGetRequest(strings.Replace(api.GetPeerDiagnosticsPath, ":peerID", url.PathEscape(peerID), 1), diagnostics)
+	if err != nil {
+		return nil, err
+	}
+	return diagnostics, nil
+}
+
+func (c *Client) StreamHandlersStats() ([]service.StreamHandlerStats, error) {
+	var stats []service.StreamHandlerStats
+	err := c.sendGetRequest(api.GetStreamHandlersPath, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (c *Client) Flows() ([]entity.FlowResponse, error) {
+	var flows []entity.FlowResponse
+	err := c.sendGetRequest(api.GetFlowsPath, &flows)
+	if err != nil {
+		return nil, err
+	}
+	return flows, nil
+}
+
+func (c *Client) RunBackup() ([]entity.BackupResult, error) {
+	var results []entity.BackupResult
+	err := c.sendPostRequest(api.RunBackupPath, nil, &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (c *Client) RestoreBackup(peerID, ownerPeerID, passphrase string) (*entity.RestoreBackupResponse, error) {
+	request := entity.RestoreBackupRequest{
+		PeerID:      peerID,
+		OwnerPeerID: ownerPeerID,
+		Passphrase:  passphrase,
+	}
+	response := new(entity.RestoreBackupResponse)
+	err := c.sendPostRequest(api.RestoreBackupPath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// ExportBackup returns encrypted archive of config and storage, template for other nodes if withoutIdentity is true.
+func (c *Client) ExportBackup(passphrase string, withoutIdentity bool) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(entity.ExportBackupRequest{Passphrase: passphrase, WithoutIdentity: withoutIdentity})
+	if err != nil {
+		return nil, err
+	}
+	reqURL, err := c.getUrl(api.ExportBackupPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Post(reqURL, "application/json", buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.readResponseBody(resp, nil)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ImportBackup saves archive from ExportBackup to be applied after restart.
+func (c *Client) ImportBackup(passphrase string, data []byte) (*entity.RestoreBackupResponse, error) {
+	request := entity.ImportBackupRequest{
+		Passphrase: passphrase,
+		Data:       data,
+	}
+	response := new(entity.RestoreBackupResponse)
+	err := c.sendPostRequest(api.ImportBackupPath, request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *Client) SendFile(peerID, path string) (*service.FileTransferStatus, error) {
+	request := entity.SendFileRequest{
+		PeerID: peerID,
+		Path:   path,
+	}
+	status := new(service.FileTransferStatus)
+	err := c.sendPostRequest(api.SendFilePath, request, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (c *Client) FileTransfers() ([]service.FileTransferStatus, error) {
+	var transfers []service.FileTransferStatus
+	err := c.sendGetRequest(api.GetFileTransfersPath, &transfers)
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+func (c *Client) SendMessage(peerID, text string) (*service.Message, error) {
+	request := entity.SendMessageRequest{
+		PeerID: peerID,
+		Text:   text,
+	}
+	message := new(service.Message)
+	err := c.sendPostRequest(api.SendMessagePath, request, message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (c *Client) Messages(peerID string, limit int) ([]service.Message, error) {
+	reqURL, err := c.getUrl(api.GetMessagesPath, entity.MessagesRequest{PeerID: peerID, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var messages []service.Message
+	err = c.readResponseBody(resp, &messages)
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (c *Client) MarkMessagesRead(peerID string) error {
+	return c.sendPostRequest(api.MarkMessagesReadPath, entity.MarkMessagesReadRequest{PeerID: peerID}, nil)
+}
+
+func (c *Client) ScheduledJobs() ([]config.ScheduledJob, error) {
+	var jobs []config.ScheduledJob
+	err := c.sendGetRequest(api.GetScheduledJobsPath, &jobs)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (c *Client) UpdateScheduledJobs(jobs []config.ScheduledJob) error {
+	return c.sendPostRequest(api.UpdateScheduledJobsPath, entity.UpdateScheduledJobsRequest{Jobs: jobs}, nil)
+}
+
+func (c *Client) ScheduledJobReports() ([]service.ScheduledJobReport, error) {
+	var reports []service.ScheduledJobReport
+	err := c.sendGetRequest(api.GetScheduledJobReportsPath, &reports)
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
 // ApplicationLog
 // send numberOfLogs = 0 to print all logs
 func (c *Client) ApplicationLog(numberOfLogs int, startFromHead bool) (string, error) {
@@ -131,11 +839,145 @@ func (c *Client) ApplicationLog(numberOfLogs int, startFromHead bool) (string, e
 	return string(b), err
 }
 
+// Search returns known peers and events matching all words of query, limit is the max number of events.
+func (c *Client) Search(query string, limit int) (*entity.SearchResponse, error) {
+	reqURL, err := c.getUrl(api.SearchPath, entity.SearchRequest{Query: query, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := new(entity.SearchResponse)
+	err = c.readResponseBody(resp, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// LogEntries returns parsed log entries matching the request, use Offset and Limit for paging.
+func (c *Client) LogEntries(req entity.LogEntriesRequest) (*entity.LogEntriesResponse, error) {
+	reqURL, err := c.getUrl(api.GetLogEntriesPath, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	logs := new(entity.LogEntriesResponse)
+	err = c.readResponseBody(resp, logs)
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// DownloadLog returns logs matching the query as text.
+func (c *Client) DownloadLog(query entity.LogQuery) ([]byte, error) {
+	reqURL, err := c.getUrl(api.DownloadLogPath, query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.readResponseBody(resp, nil)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ExportUsage returns traffic or connection history as CSV.
+func (c *Client) ExportUsage(req entity.ExportUsageRequest) ([]byte, error) {
+	reqURL, err := c.getUrl(api.ExportUsagePath, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.readResponseBody(resp, nil)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Events connects to the events WebSocket, the channel is closed when ctx is done or the connection is lost.
+func (c *Client) Events(ctx context.Context, types ...string) (<-chan entity.Event, error) {
+	reqURL, err := c.getUrl(api.EventsPath, entity.EventsRequest{Types: types})
+	if err != nil {
+		return nil, err
+	}
+	reqURL = "ws" + strings.TrimPrefix(reqURL, "http")
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = c.tlsConfig
+	header := http.Header{}
+	if c.apiKey != "" {
+		header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	conn, resp, err := dialer.DialContext(ctx, reqURL, header)
+	if err != nil {
+		if resp != nil && resp.Body != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				return nil, c.readResponseBody(resp, nil)
+			}
+		}
+		return nil, err
+	}
+
+	events := make(chan entity.Event)
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		for {
+			var evt entity.Event
+			err := conn.ReadJSON(&evt)
+			if err != nil {
+				return
+			}
+			select {
+			case events <- evt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
 func (c *Client) getUrl(methodPath string, getParamsStruct interface{}) (string, error) {
+	scheme := "http"
+	if c.tlsConfig != nil {
+		scheme = "https"
+	}
 	reqURL := url.URL{
-		Scheme: "http",
+		Scheme: scheme,
 		Host:   c.address,
-		Path:   methodPath,
+		Path:   c.pathPrefix + methodPath,
 	}
 
 	v, err := query.Values(getParamsStruct)
@@ -0,0 +1,457 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: awl.proto
+
+package apipb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Awl_GetMyPeerInfo_FullMethodName      = "/awl.v1.Awl/GetMyPeerInfo"
+	Awl_GetStats_FullMethodName           = "/awl.v1.Awl/GetStats"
+	Awl_ListPeers_FullMethodName          = "/awl.v1.Awl/ListPeers"
+	Awl_GetPeer_FullMethodName            = "/awl.v1.Awl/GetPeer"
+	Awl_UpdatePeerSettings_FullMethodName = "/awl.v1.Awl/UpdatePeerSettings"
+	Awl_RemovePeer_FullMethodName         = "/awl.v1.Awl/RemovePeer"
+	Awl_ListBlockedPeers_FullMethodName   = "/awl.v1.Awl/ListBlockedPeers"
+	Awl_SendFriendRequest_FullMethodName  = "/awl.v1.Awl/SendFriendRequest"
+	Awl_ListAuthRequests_FullMethodName   = "/awl.v1.Awl/ListAuthRequests"
+	Awl_ReplyAuthRequest_FullMethodName   = "/awl.v1.Awl/ReplyAuthRequest"
+)
+
+// AwlClient is the client API for Awl service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AwlClient interface {
+	// GetMyPeerInfo returns info about this peer.
+	GetMyPeerInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*MyPeerInfo, error)
+	// GetStats returns p2p network stats.
+	GetStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Stats, error)
+	// ListPeers returns known peers, connected peers are listed first.
+	ListPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListPeersResponse, error)
+	GetPeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Peer, error)
+	UpdatePeerSettings(ctx context.Context, in *UpdatePeerSettingsRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// RemovePeer removes known peer and blocks it.
+	RemovePeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListBlockedPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListBlockedPeersResponse, error)
+	// SendFriendRequest invites new peer.
+	SendFriendRequest(ctx context.Context, in *FriendRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// ListAuthRequests returns ingoing friend requests.
+	ListAuthRequests(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListAuthRequestsResponse, error)
+	// ReplyAuthRequest accepts or declines ingoing friend request.
+	ReplyAuthRequest(ctx context.Context, in *ReplyAuthRequestRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type awlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAwlClient(cc grpc.ClientConnInterface) AwlClient {
+	return &awlClient{cc}
+}
+
+func (c *awlClient) GetMyPeerInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*MyPeerInfo, error) {
+	out := new(MyPeerInfo)
+	err := c.cc.Invoke(ctx, Awl_GetMyPeerInfo_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) GetStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Awl_GetStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) ListPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListPeersResponse, error) {
+	out := new(ListPeersResponse)
+	err := c.cc.Invoke(ctx, Awl_ListPeers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) GetPeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*Peer, error) {
+	out := new(Peer)
+	err := c.cc.Invoke(ctx, Awl_GetPeer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) UpdatePeerSettings(ctx context.Context, in *UpdatePeerSettingsRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Awl_UpdatePeerSettings_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) RemovePeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Awl_RemovePeer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) ListBlockedPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListBlockedPeersResponse, error) {
+	out := new(ListBlockedPeersResponse)
+	err := c.cc.Invoke(ctx, Awl_ListBlockedPeers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) SendFriendRequest(ctx context.Context, in *FriendRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Awl_SendFriendRequest_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) ListAuthRequests(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListAuthRequestsResponse, error) {
+	out := new(ListAuthRequestsResponse)
+	err := c.cc.Invoke(ctx, Awl_ListAuthRequests_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *awlClient) ReplyAuthRequest(ctx context.Context, in *ReplyAuthRequestRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Awl_ReplyAuthRequest_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AwlServer is the server API for Awl service.
+// All implementations must embed UnimplementedAwlServer
+// for forward compatibility
+type AwlServer interface {
+	// GetMyPeerInfo returns info about this peer.
+	GetMyPeerInfo(context.Context, *emptypb.Empty) (*MyPeerInfo, error)
+	// GetStats returns p2p network stats.
+	GetStats(context.Context, *emptypb.Empty) (*Stats, error)
+	// ListPeers returns known peers, connected peers are listed first.
+	ListPeers(context.Context, *emptypb.Empty) (*ListPeersResponse, error)
+	GetPeer(context.Context, *PeerRequest) (*Peer, error)
+	UpdatePeerSettings(context.Context, *UpdatePeerSettingsRequest) (*emptypb.Empty, error)
+	// RemovePeer removes known peer and blocks it.
+	RemovePeer(context.Context, *PeerRequest) (*emptypb.Empty, error)
+	ListBlockedPeers(context.Context, *emptypb.Empty) (*ListBlockedPeersResponse, error)
+	// SendFriendRequest invites new peer.
+	SendFriendRequest(context.Context, *FriendRequest) (*emptypb.Empty, error)
+	// ListAuthRequests returns ingoing friend requests.
+	ListAuthRequests(context.Context, *emptypb.Empty) (*ListAuthRequestsResponse, error)
+	// ReplyAuthRequest accepts or declines ingoing friend request.
+	ReplyAuthRequest(context.Context, *ReplyAuthRequestRequest) (*emptypb.Empty, error)
+	mustEmbedUnimplementedAwlServer()
+}
+
+// UnimplementedAwlServer must be embedded to have forward compatible implementations.
+type UnimplementedAwlServer struct {
+}
+
+func (UnimplementedAwlServer) GetMyPeerInfo(context.Context, *emptypb.Empty) (*MyPeerInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMyPeerInfo not implemented")
+}
+func (UnimplementedAwlServer) GetStats(context.Context, *emptypb.Empty) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedAwlServer) ListPeers(context.Context, *emptypb.Empty) (*ListPeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPeers not implemented")
+}
+func (UnimplementedAwlServer) GetPeer(context.Context, *PeerRequest) (*Peer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPeer not implemented")
+}
+func (UnimplementedAwlServer) UpdatePeerSettings(context.Context, *UpdatePeerSettingsRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeerSettings not implemented")
+}
+func (UnimplementedAwlServer) RemovePeer(context.Context, *PeerRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePeer not implemented")
+}
+func (UnimplementedAwlServer) ListBlockedPeers(context.Context, *emptypb.Empty) (*ListBlockedPeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBlockedPeers not implemented")
+}
+func (UnimplementedAwlServer) SendFriendRequest(context.Context, *FriendRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendFriendRequest not implemented")
+}
+func (UnimplementedAwlServer) ListAuthRequests(context.Context, *emptypb.Empty) (*ListAuthRequestsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAuthRequests not implemented")
+}
+func (UnimplementedAwlServer) ReplyAuthRequest(context.Context, *ReplyAuthRequestRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplyAuthRequest not implemented")
+}
+func (UnimplementedAwlServer) mustEmbedUnimplementedAwlServer() {}
+
+// UnsafeAwlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AwlServer will
+// result in compilation errors.
+type UnsafeAwlServer interface {
+	mustEmbedUnimplementedAwlServer()
+}
+
+func RegisterAwlServer(s grpc.ServiceRegistrar, srv AwlServer) {
+	s.RegisterService(&Awl_ServiceDesc, srv)
+}
+
+func _Awl_GetMyPeerInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).GetMyPeerInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_GetMyPeerInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).GetMyPeerInfo(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).GetStats(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_ListPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).ListPeers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_ListPeers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).ListPeers(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_GetPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).GetPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_GetPeer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).GetPeer(ctx, req.(*PeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_UpdatePeerSettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePeerSettingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).UpdatePeerSettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_UpdatePeerSettings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).UpdatePeerSettings(ctx, req.(*UpdatePeerSettingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_RemovePeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).RemovePeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_RemovePeer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).RemovePeer(ctx, req.(*PeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_ListBlockedPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).ListBlockedPeers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_ListBlockedPeers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).ListBlockedPeers(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_SendFriendRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FriendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).SendFriendRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_SendFriendRequest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).SendFriendRequest(ctx, req.(*FriendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_ListAuthRequests_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).ListAuthRequests(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_ListAuthRequests_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).ListAuthRequests(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Awl_ReplyAuthRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplyAuthRequestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AwlServer).ReplyAuthRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Awl_ReplyAuthRequest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AwlServer).ReplyAuthRequest(ctx, req.(*ReplyAuthRequestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Awl_ServiceDesc is the grpc.ServiceDesc for Awl service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Awl_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "awl.v1.Awl",
+	HandlerType: (*AwlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetMyPeerInfo",
+			Handler:    _Awl_GetMyPeerInfo_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Awl_GetStats_Handler,
+		},
+		{
+			MethodName: "ListPeers",
+			Handler:    _Awl_ListPeers_Handler,
+		},
+		{
+			MethodName: "GetPeer",
+			Handler:    _Awl_GetPeer_Handler,
+		},
+		{
+			MethodName: "UpdatePeerSettings",
+			Handler:    _Awl_UpdatePeerSettings_Handler,
+		},
+		{
+			MethodName: "RemovePeer",
+			Handler:    _Awl_RemovePeer_Handler,
+		},
+		{
+			MethodName: "ListBlockedPeers",
+			Handler:    _Awl_ListBlockedPeers_Handler,
+		},
+		{
+			MethodName: "SendFriendRequest",
+			Handler:    _Awl_SendFriendRequest_Handler,
+		},
+		{
+			MethodName: "ListAuthRequests",
+			Handler:    _Awl_ListAuthRequests_Handler,
+		},
+		{
+			MethodName: "ReplyAuthRequest",
+			Handler:    _Awl_ReplyAuthRequest_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "awl.proto",
+}
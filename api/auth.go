@@ -0,0 +1,186 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	apiKeySize          = 32
+	authorizationPrefix = "Bearer "
+)
+
+// apiAuthMiddleware checks api keys from config.APIAuthConfig. Keys with read permission have access only to
+// read-only api, the same as kiosk server.
+func (h *Handler) apiAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		h.conf.RLock()
+		authEnabled := len(h.conf.APIAuth.Keys) > 0
+		requireOnLocalhost := h.conf.APIAuth.RequireOnLocalhost
+		h.conf.RUnlock()
+		// web ui files are public, the api is checked when they are used
+		if !authEnabled || c.Path() == "/*" || (!requireOnLocalhost && isLoopbackRequest(c.Request())) {
+			return next(c)
+		}
+
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		if !strings.HasPrefix(header, authorizationPrefix) {
+			return c.JSON(http.StatusUnauthorized, ErrorMessage("api key is required"))
+		}
+		key, exists := h.conf.APIKeyByHash(apiKeyHash(strings.TrimPrefix(header, authorizationPrefix)))
+		if !exists {
+			return c.JSON(http.StatusUnauthorized, ErrorMessage("invalid api key"))
+		}
+		if key.Permission != config.APIKeyPermissionAdmin && !isReadOnlyRequest(c) {
+			return c.JSON(http.StatusForbidden, ErrorMessage("api key is read-only"))
+		}
+		return next(c)
+	}
+}
+
+// apiNetworksMiddleware checks the address of connection by config.APIAuthConfig networks.
+func (h *Handler) apiNetworksMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		allowed, err := h.conf.AllowsAPIAddress(remoteIP(c.Request()))
+		if err != nil {
+			h.logger.Errorf("check api networks: %v", err)
+		}
+		if !allowed {
+			return c.JSON(http.StatusForbidden, ErrorMessage("address is not allowed"))
+		}
+		return next(c)
+	}
+}
+
+// isLoopbackRequest checks the address of connection, since headers like X-Forwarded-For could be spoofed.
+func isLoopbackRequest(r *http.Request) bool {
+	ip := remoteIP(r)
+	return ip != nil && ip.IsLoopback()
+}
+
+// remoteIP returns nil if the address of connection is invalid.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func apiKeyHash(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// apiTLSConfig returns nil if TLS isn't configured for web api.
+func (h *Handler) apiTLSConfig() (*tls.Config, error) {
+	h.conf.RLock()
+	certFile, keyFile := h.conf.APIAuth.CertFile, h.conf.APIAuth.KeyFile
+	h.conf.RUnlock()
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// @Tags Settings
+// @Summary Get api keys
+// @Produce json
+// @Success 200 {array} entity.APIKeyResponse
+// @Router /settings/api_keys [GET]
+func (h *Handler) GetAPIKeys(c echo.Context) (err error) {
+	h.conf.RLock()
+	result := make([]entity.APIKeyResponse, 0, len(h.conf.APIAuth.Keys))
+	for _, key := range h.conf.APIAuth.Keys {
+		result = append(result, entity.APIKeyResponse{
+			Name:       key.Name,
+			Permission: key.Permission,
+			CreatedAt:  key.CreatedAt,
+		})
+	}
+	h.conf.RUnlock()
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// @Tags Settings
+// @Summary Create api key
+// @Description Web api requires keys after the first one is created, except requests from localhost if config.APIAuthConfig.RequireOnLocalhost is false
+// @Accept json
+// @Produce json
+// @Param body body entity.CreateAPIKeyRequest true "Params"
+// @Success 200 {object} entity.CreateAPIKeyResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /settings/create_api_key [POST]
+func (h *Handler) CreateAPIKey(c echo.Context) (err error) {
+	req := entity.CreateAPIKeyRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	secret := make([]byte, apiKeySize)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+	key := hex.EncodeToString(secret)
+	added := h.conf.AddAPIKey(config.APIKey{
+		Name:       strings.TrimSpace(req.Name),
+		Hash:       apiKeyHash(key),
+		Permission: req.Permission,
+		CreatedAt:  time.Now(),
+	})
+	if !added {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("api key with this name already exists"))
+	}
+
+	return c.JSON(http.StatusOK, entity.CreateAPIKeyResponse{Key: key})
+}
+
+// @Tags Settings
+// @Summary Remove api key
+// @Accept json
+// @Produce json
+// @Param body body entity.RemoveAPIKeyRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /settings/remove_api_key [POST]
+func (h *Handler) RemoveAPIKey(c echo.Context) (err error) {
+	req := entity.RemoveAPIKeyRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if !h.conf.RemoveAPIKey(req.Name) {
+		return c.JSON(http.StatusNotFound, ErrorMessage("api key not found"))
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// @Tags Backup
+// @Summary Store backup on configured peers now
+// @Description Backup is encrypted with passphrase from config and stored on peers from config.
+// @Accept json
+// @Produce json
+// @Success 200 {array} entity.BackupResult
+// @Failure 400 {object} api.Error
+// @Router /backup/run [POST]
+func (h *Handler) RunBackup(c echo.Context) (err error) {
+	results, err := h.backup.BackupNow(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	response := make([]entity.BackupResult, 0, len(results))
+	for peerID, err := range results {
+		result := entity.BackupResult{PeerID: peerID}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		response = append(response, result)
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].PeerID < response[j].PeerID
+	})
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Tags Backup
+// @Summary Restore config and storage from backup stored on the peer
+// @Description Backup is applied on the next start, so application should be restarted after this call.
+// @Accept json
+// @Produce json
+// @Param body body entity.RestoreBackupRequest true "Params"
+// @Success 200 {object} entity.RestoreBackupResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /backup/restore [POST]
+func (h *Handler) RestoreBackup(c echo.Context) (err error) {
+	req := entity.RestoreBackupRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	peerID, err := peer.Decode(req.PeerID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			ErrorMessage("Invalid hex-encoded multihash representing of a peer ID"))
+	}
+
+	snapshot, err := h.backup.Restore(c.Request().Context(), peerID, req.OwnerPeerID, req.Passphrase)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, entity.RestoreBackupResponse{
+		CreatedAt: snapshot.CreatedAt,
+		PeerID:    snapshot.PeerID,
+	})
+}
+
+// @Tags Backup
+// @Summary Export config and storage as encrypted archive
+// @Description Archive is imported on another machine to move the node or to recover it.
+// @Description Archive without identity is a template for other nodes, they keep their own identity and storage.
+// @Accept json
+// @Produce application/octet-stream
+// @Param body body entity.ExportBackupRequest true "Params"
+// @Success 200 {file} file "archive"
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /backup/export [POST]
+func (h *Handler) ExportBackup(c echo.Context) (err error) {
+	req := entity.ExportBackupRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	data, err := h.backup.Export(req.Passphrase, req.WithoutIdentity)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	filename := fmt.Sprintf("awl_backup_%s.awlbk", time.Now().Format("2006-01-02"))
+	if req.WithoutIdentity {
+		filename = fmt.Sprintf("awl_template_%s.awlbk", time.Now().Format("2006-01-02"))
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(http.StatusOK, echo.MIMEOctetStream, data)
+}
+
+// @Tags Backup
+// @Summary Import archive exported with backup/export
+// @Description Archive is applied on the next start, so application should be restarted after this call.
+// @Description Template without identity keeps our identity and storage.
+// @Accept json
+// @Produce json
+// @Param body body entity.ImportBackupRequest true "Params"
+// @Success 200 {object} entity.RestoreBackupResponse
+// @Failure 400 {object} api.Error
+// @Router /backup/import [POST]
+func (h *Handler) ImportBackup(c echo.Context) (err error) {
+	req := entity.ImportBackupRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	snapshot, err := h.backup.Import(req.Passphrase, req.Data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, entity.RestoreBackupResponse{
+		CreatedAt: snapshot.CreatedAt,
+		PeerID:    snapshot.PeerID,
+	})
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// @Tags Settings
+// @Summary Get bootstrap peers
+// @Description Bootstrap peers from config and default ones, which can't be removed
+// @Produce json
+// @Success 200 {array} entity.BootstrapPeerResponse
+// @Router /settings/bootstrap_peers [GET]
+func (h *Handler) GetBootstrapPeers(c echo.Context) (err error) {
+	h.conf.RLock()
+	addrs := append([]string(nil), h.conf.P2pNode.BootstrapPeers...)
+	h.conf.RUnlock()
+
+	result := make([]entity.BootstrapPeerResponse, 0, len(addrs)+len(config.DefaultBootstrapPeers))
+	appendPeer := func(addr string, isDefault bool) {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return
+		}
+		result = append(result, entity.BootstrapPeerResponse{
+			Address:   addr,
+			PeerID:    info.ID.String(),
+			Default:   isDefault,
+			Connected: h.p2p.IsConnected(info.ID),
+		})
+	}
+	for _, addr := range addrs {
+		appendPeer(addr, false)
+	}
+	for _, addr := range config.DefaultBootstrapPeers {
+		appendPeer(addr.String(), true)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// @Tags Settings
+// @Summary Add bootstrap peer
+// @Description The peer is used immediately, restart is not needed
+// @Accept json
+// @Produce json
+// @Param body body entity.BootstrapPeerRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/add_bootstrap_peer [POST]
+func (h *Handler) AddBootstrapPeer(c echo.Context) (err error) {
+	req := entity.BootstrapPeerRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	info, err := peer.AddrInfoFromString(strings.TrimSpace(req.Address))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("invalid multiaddr with peer id: "+err.Error()))
+	}
+	if info.ID.String() == h.conf.P2pNode.PeerID {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("You can't add yourself"))
+	}
+
+	if !h.conf.AddBootstrapPeer(strings.TrimSpace(req.Address)) {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("bootstrap peer has already been added"))
+	}
+	h.applyBootstrapPeers()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Remove bootstrap peer
+// @Accept json
+// @Produce json
+// @Param body body entity.BootstrapPeerRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /settings/remove_bootstrap_peer [POST]
+func (h *Handler) RemoveBootstrapPeer(c echo.Context) (err error) {
+	req := entity.BootstrapPeerRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	if !h.conf.RemoveBootstrapPeer(strings.TrimSpace(req.Address)) {
+		return c.JSON(http.StatusNotFound, ErrorMessage("bootstrap peer not found, default ones can't be removed"))
+	}
+	h.applyBootstrapPeers()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Test connectivity with bootstrap peer
+// @Description Connects to the peer and pings it, the peer doesn't have to be added
+// @Accept json
+// @Produce json
+// @Param body body entity.BootstrapPeerRequest true "Params"
+// @Success 200 {object} entity.TestBootstrapPeerResponse
+// @Failure 400 {object} api.Error
+// @Router /settings/test_bootstrap_peer [POST]
+func (h *Handler) TestBootstrapPeer(c echo.Context) (err error) {
+	req := entity.BootstrapPeerRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	info, err := peer.AddrInfoFromString(strings.TrimSpace(req.Address))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("invalid multiaddr with peer id: "+err.Error()))
+	}
+	if info.ID.String() == h.conf.P2pNode.PeerID {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("You can't test yourself"))
+	}
+
+	response := entity.TestBootstrapPeerResponse{PeerID: info.ID.String()}
+	response.Latency, err = h.p2p.TestBootstrapPeer(c.Request().Context(), *info)
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// applyBootstrapPeers makes running host use bootstrap peers from config.
+func (h *Handler) applyBootstrapPeers() {
+	h.p2p.SetBootstrapPeers(h.conf.GetBootstrapPeers())
+	go func() {
+		err := h.p2p.Bootstrap()
+		if err != nil {
+			h.logger.Warnf("bootstrap with changed bootstrap peers: %v", err)
+		}
+	}()
+}
@@ -1,26 +1,127 @@
 package api
 
+import (
+	"github.com/anywherelan/awl/protocol"
+)
+
 const (
 	V0Prefix = "/api/v0/"
 
 	// Peers
-	GetKnownPeersPath        = V0Prefix + "peers/get_known"
-	GetKnownPeerSettingsPath = V0Prefix + "peers/get_known_peer_settings"
-	UpdatePeerSettingsPath   = V0Prefix + "peers/update_settings"
-	RemovePeerSettingsPath   = V0Prefix + "peers/remove"
+	GetKnownPeersPath            = V0Prefix + "peers/get_known"
+	GetKnownPeerSettingsPath     = V0Prefix + "peers/get_known_peer_settings"
+	UpdatePeerSettingsPath       = V0Prefix + "peers/update_settings"
+	UpdatePeerACLPath            = V0Prefix + "peers/update_acl"
+	UpdatePeerMTUPath            = V0Prefix + "peers/update_mtu"
+	UpdatePeerRoutesPath         = V0Prefix + "peers/update_routes"
+	UpdatePeerBandwidthLimitPath = V0Prefix + "peers/update_bandwidth_limit"
+	UpdatePeerNotesPath          = V0Prefix + "peers/update_notes"
+	UpdatePeerGroupsPath         = V0Prefix + "peers/update_groups"
+	GetPeerGroupsPath            = V0Prefix + "peers/groups"
+	UpdatePeerGroupPath          = V0Prefix + "peers/update_group"
+	RemovePeerGroupPath          = V0Prefix + "peers/remove_group"
+	RemovePeerSettingsPath       = V0Prefix + "peers/remove"
 
 	GetBlockedPeersPath = V0Prefix + "peers/get_blocked"
 
 	SendFriendRequestPath    = V0Prefix + "peers/invite_peer"
 	AcceptPeerInvitationPath = V0Prefix + "peers/accept_peer"
+	CreateInvitePath         = V0Prefix + "peers/create_invite"
+	AcceptInvitePath         = V0Prefix + "peers/accept_invite"
 	GetAuthRequestsPath      = V0Prefix + "peers/auth_requests"
+	ImportPeersPath          = V0Prefix + "peers/import"
+	EchoPeerPath             = V0Prefix + "peers/echo"
+	GetPeerLatencyPath       = V0Prefix + "peers/latency"
+	RunSpeedTestPath         = V0Prefix + "peers/speed_test"
+	GetSpeedTestHistoryPath  = V0Prefix + "peers/speed_test_history"
+	GetSupportReportPath     = V0Prefix + "peers/support_report"
+	PortScanPath             = V0Prefix + "peers/port_scan"
 
 	// Settings
-	GetMyPeerInfoPath      = V0Prefix + "settings/peer_info"
-	UpdateMyInfoPath       = V0Prefix + "settings/update"
-	ExportServerConfigPath = V0Prefix + "settings/export_server_config"
+	GetMyPeerInfoPath          = V0Prefix + "settings/peer_info"
+	UpdateMyInfoPath           = V0Prefix + "settings/update"
+	ExportServerConfigPath     = V0Prefix + "settings/export_server_config"
+	UpdateDNSRecordsPath       = V0Prefix + "settings/dns_records"
+	UpdateKillSwitchPath       = V0Prefix + "settings/kill_switch"
+	UpdateSharedFolderPath     = V0Prefix + "settings/shared_folder"
+	UpdateFileTransferPath     = V0Prefix + "settings/file_transfer"
+	UpdateExitNodePath         = V0Prefix + "settings/exit_node"
+	UpdateAdvertisedRoutesPath = V0Prefix + "settings/advertised_routes"
+	UpdateSOCKS5Path           = V0Prefix + "settings/socks5"
+	GetConnectionGaterPath     = V0Prefix + "settings/connection_gater"
+	UpdateConnectionGaterPath  = V0Prefix + "settings/update_connection_gater"
+	GetAPIKeysPath             = V0Prefix + "settings/api_keys"
+	CreateAPIKeyPath           = V0Prefix + "settings/create_api_key"
+	RemoveAPIKeyPath           = V0Prefix + "settings/remove_api_key"
+	GetBootstrapPeersPath      = V0Prefix + "settings/bootstrap_peers"
+	AddBootstrapPeerPath       = V0Prefix + "settings/add_bootstrap_peer"
+	RemoveBootstrapPeerPath    = V0Prefix + "settings/remove_bootstrap_peer"
+	TestBootstrapPeerPath      = V0Prefix + "settings/test_bootstrap_peer"
+	RotateIdentityPath         = V0Prefix + "settings/rotate_identity"
+	GetIdentityMigrationPath   = V0Prefix + "settings/identity_migration"
+	UpdateConfigEncryptionPath = V0Prefix + "settings/config_encryption"
+	UpdatePacketFilterPath     = V0Prefix + "settings/packet_filter"
+	UpdateVPNPausePath         = V0Prefix + "settings/vpn_pause"
+	UpdateRendezvousPath       = V0Prefix + "settings/rendezvous"
+	UpdateDNSUpstreamPath      = V0Prefix + "settings/dns_upstream"
+	UpdateBandwidthLimitPath   = V0Prefix + "settings/bandwidth_limit"
+	UpdateQoSPath              = V0Prefix + "settings/qos"
+	UpdateIPAllocationPath     = V0Prefix + "settings/ip_allocation"
+	GetSubnetConflictsPath     = V0Prefix + "settings/subnet_conflicts"
+	UpdateAutoRenumberPath     = V0Prefix + "settings/auto_renumber"
+	GetPowerProfilePath        = V0Prefix + "settings/power"
+	UpdatePowerModePath        = V0Prefix + "settings/update_power"
+
+	// Flows
+	GetFlowsPath = V0Prefix + "flows"
+
+	// Usage
+	ExportUsagePath = V0Prefix + "usage/export"
+
+	// Backup
+	RunBackupPath     = V0Prefix + "backup/run"
+	RestoreBackupPath = V0Prefix + "backup/restore"
+	ExportBackupPath  = V0Prefix + "backup/export"
+	ImportBackupPath  = V0Prefix + "backup/import"
+
+	// File transfer
+	SendFilePath         = V0Prefix + "file_transfer/send"
+	GetFileTransfersPath = V0Prefix + "file_transfer/transfers"
+
+	// Messages
+	SendMessagePath      = V0Prefix + "messages/send"
+	GetMessagesPath      = V0Prefix + "messages"
+	MarkMessagesReadPath = V0Prefix + "messages/mark_read"
+
+	// Scheduled jobs
+	GetScheduledJobsPath       = V0Prefix + "scheduled_jobs"
+	UpdateScheduledJobsPath    = V0Prefix + "scheduled_jobs/update"
+	GetScheduledJobReportsPath = V0Prefix + "scheduled_jobs/reports"
+
+	// Shared folders of peers are served over WebDAV, paths are not under V0Prefix to keep them short for WebDAV clients
+	SharedFolderPath     = protocol.SharedFolderPathPrefix + ":peerID"
+	SharedFolderFilePath = SharedFolderPath + "/*"
+
+	// Api of managed peers is proxied with its own paths after the peer id, e.g. /management/<peer id>/api/v0/peers/get_known
+	ManagementPath    = protocol.ManagementPathPrefix + ":peerID"
+	ManagementAPIPath = ManagementPath + "/*"
+
+	// Events
+	EventsPath = V0Prefix + "events"
+
+	// Search
+	SearchPath = V0Prefix + "search"
 
 	// Debug
-	GetP2pDebugInfoPath = V0Prefix + "debug/p2p_info"
-	GetDebugLogPath     = V0Prefix + "debug/log"
+	GetP2pDebugInfoPath    = V0Prefix + "debug/p2p_info"
+	GetDebugLogPath        = V0Prefix + "debug/log"
+	GetLogEntriesPath      = V0Prefix + "debug/log/entries"
+	DownloadLogPath        = V0Prefix + "debug/log/download"
+	GetStatsSnapshotPath   = V0Prefix + "debug/stats"
+	GetDHTRoutingTablePath = V0Prefix + "debug/dht"
+	GetStreamHandlersPath  = V0Prefix + "debug/stream_handlers"
+	GetPacketFilterPath    = V0Prefix + "debug/packet_filter"
+	GetBandwidthLimitPath  = V0Prefix + "debug/bandwidth_limit"
+	GetQoSPath             = V0Prefix + "debug/qos"
+	GetPeerDiagnosticsPath = V0Prefix + "debug/peer/:peerID"
 )
@@ -2,53 +2,62 @@ package api
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
+	"time"
 	"unicode/utf8"
 
 	"github.com/anywherelan/awl/config"
 	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/logview"
 	"github.com/labstack/echo/v4"
 	"github.com/libp2p/go-libp2p/core/metrics"
-	ma "github.com/multiformats/go-multiaddr"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"go.uber.org/zap/zapcore"
 )
 
+const defaultLogEntriesLimit = 100
+
+var errInvalidLogLevel = errors.New("invalid log level")
+
 // @Tags Debug
 // @Summary Get p2p debug info
 // @Produce json
 // @Success 200 {object} entity.P2pDebugInfo
 // @Router /debug/p2p_info [GET]
 func (h *Handler) GetP2pDebugInfo(c echo.Context) (err error) {
-	metricsByProtocol := h.p2p.NetworkStatsByProtocol()
-	bandwidthByProtocol := make(map[string]entity.BandwidthInfo, len(metricsByProtocol))
-	for key, val := range metricsByProtocol {
+	stats := h.p2p.StatsSnapshot()
+	bandwidthByProtocol := make(map[string]entity.BandwidthInfo, len(stats.Bandwidth.ByProtocol))
+	for key, val := range stats.Bandwidth.ByProtocol {
 		bandwidthByProtocol[string(key)] = makeBandwidthInfo(val)
 	}
 
 	debugInfo := entity.P2pDebugInfo{
 		General: entity.GeneralDebugInfo{
 			Version: config.Version,
-			Uptime:  h.p2p.Uptime().String(),
+			Uptime:  stats.Uptime.String(),
 		},
 		DHT: entity.DhtDebugInfo{
-			RoutingTableSize:    h.p2p.RoutingTableSize(),
+			RoutingTableSize:    stats.DHT.RoutingTableSize,
 			RoutingTable:        h.p2p.RoutingTablePeers(),
-			Reachability:        h.p2p.Reachability().String(),
-			ListenAddress:       maToStrings(h.p2p.AnnouncedAs()),
-			PeersWithAddrsCount: h.p2p.PeersWithAddrsCount(),
-			ObservedAddrs:       maToStrings(h.p2p.OwnObservedAddrs()),
-			BootstrapPeers:      h.p2p.BootstrapPeersStatsDetailed(),
+			Reachability:        stats.Reachability,
+			ListenAddress:       stats.DHT.ListenAddrs,
+			PeersWithAddrsCount: stats.DHT.PeersWithAddrsCount,
+			ObservedAddrs:       stats.DHT.ObservedAddrs,
+			BootstrapPeers:      stats.Bootstrap.Peers,
 		},
 		Connections: entity.ConnectionsDebugInfo{
-			ConnectedPeersCount:  h.p2p.ConnectedPeersCount(),
-			OpenConnectionsCount: h.p2p.OpenConnectionsCount(),
-			OpenStreamsCount:     h.p2p.OpenStreamsCount(),
-			LastTrimAgo:          h.p2p.ConnectionsLastTrimAgo().String(),
+			ConnectedPeersCount:  stats.Connections.ConnectedPeersCount,
+			OpenConnectionsCount: stats.Connections.OpenConnectionsCount,
+			OpenStreamsCount:     stats.Streams.OpenStreamsCount,
+			LastTrimAgo:          stats.Connections.LastTrimAgo.String(),
+			RefusedCount:         stats.Connections.RefusedCount,
+			Greylist:             stats.Connections.Greylist,
 		},
 		Bandwidth: entity.BandwidthDebugInfo{
-			Total:      makeBandwidthInfo(h.p2p.NetworkStats()),
+			Total:      makeBandwidthInfo(stats.Bandwidth.Total),
 			ByProtocol: bandwidthByProtocol,
 		},
 	}
@@ -56,6 +65,125 @@ func (h *Handler) GetP2pDebugInfo(c echo.Context) (err error) {
 	return c.JSONPretty(http.StatusOK, debugInfo, "    ")
 }
 
+// @Tags Debug
+// @Summary Get p2p stats snapshot
+// @Description Version field is incremented on every incompatible schema change
+// @Produce json
+// @Success 200 {object} p2p.StatsSnapshot
+// @Router /debug/stats [GET]
+func (h *Handler) GetStatsSnapshot(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.p2p.StatsSnapshot())
+}
+
+// @Tags Debug
+// @Summary Get DHT routing table
+// @Description Peers are grouped by buckets, i.e. common prefix length of peer id with ours
+// @Produce json
+// @Success 200 {object} p2p.DHTRoutingTable
+// @Router /debug/dht [GET]
+func (h *Handler) GetDHTRoutingTable(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.p2p.DHTRoutingTable())
+}
+
+// @Tags Debug
+// @Summary Get stream handlers stats
+// @Description Number of accepted, rejected by peer access check, panicked and active streams of each awl protocol
+// @Produce json
+// @Success 200 {array} service.StreamHandlerStats
+// @Router /debug/stream_handlers [GET]
+func (h *Handler) GetStreamHandlers(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.streams.Stats())
+}
+
+// @Tags Debug
+// @Summary Get packet filter
+// @Description Packet filter of the interface reader and numbers of dropped packets since the start
+// @Produce json
+// @Success 200 {object} entity.PacketFilterResponse
+// @Router /debug/packet_filter [GET]
+func (h *Handler) GetPacketFilter(c echo.Context) (err error) {
+	h.conf.RLock()
+	filter := h.conf.VPNConfig.PacketFilter
+	h.conf.RUnlock()
+
+	response := entity.PacketFilterResponse{
+		Filter:  filter,
+		Dropped: h.tunnel.PacketFilterStats(),
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Tags Debug
+// @Summary Get QoS
+// @Description QoS settings and numbers of packets to peers by priority since the start
+// @Produce json
+// @Success 200 {object} entity.QoSResponse
+// @Router /debug/qos [GET]
+func (h *Handler) GetQoS(c echo.Context) (err error) {
+	h.conf.RLock()
+	qosConfig := h.conf.VPNConfig.QoS
+	qosConfig.Rules = append([]config.QoSRule(nil), qosConfig.Rules...)
+	h.conf.RUnlock()
+
+	response := entity.QoSResponse{
+		QoS:     qosConfig,
+		Classes: h.tunnel.QoSStats(),
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Tags Debug
+// @Summary Get bandwidth limits
+// @Description Global limit, limits of peers and total delay of traffic by them since the start
+// @Produce json
+// @Success 200 {object} entity.BandwidthLimitResponse
+// @Router /debug/bandwidth_limit [GET]
+func (h *Handler) GetBandwidthLimit(c echo.Context) (err error) {
+	response := entity.BandwidthLimitResponse{
+		Peers:   []entity.PeerBandwidthLimit{},
+		Delayed: h.shaper.Stats(),
+	}
+	h.conf.RLock()
+	response.Global = h.conf.BandwidthLimit
+	for _, knownPeer := range h.conf.KnownPeers {
+		if knownPeer.BandwidthLimit == (config.BandwidthLimitConfig{}) {
+			continue
+		}
+		response.Peers = append(response.Peers, entity.PeerBandwidthLimit{
+			PeerID:   knownPeer.PeerID,
+			PeerName: knownPeer.DisplayName(),
+			Limit:    knownPeer.BandwidthLimit,
+		})
+	}
+	h.conf.RUnlock()
+	sort.Slice(response.Peers, func(i, j int) bool {
+		return response.Peers[i].PeerName < response.Peers[j].PeerName
+	})
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Tags Debug
+// @Summary Diagnose connection with the peer
+// @Description Looks up the peer in DHT and dials it if it's disconnected, then returns connections, addresses, dial errors,
+// @Description our NAT reachability and recent hole punching attempts. It takes up to 30 seconds
+// @Param peerID path string true "peer id"
+// @Produce json
+// @Success 200 {object} p2p.PeerDiagnostics
+// @Failure 400 {object} api.Error
+// @Router /debug/peer/{peerID} [GET]
+func (h *Handler) GetPeerDiagnostics(c echo.Context) (err error) {
+	peerID, err := peer.Decode(c.Param("peerID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if peerID == h.p2p.PeerID() {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("can't diagnose connection with ourselves"))
+	}
+
+	return c.JSON(http.StatusOK, h.p2p.DiagnosePeer(c.Request().Context(), peerID))
+}
+
 // @Tags Debug
 // @Summary Get logs
 // @Param logs query int false "Define number of rows of logs to output. On default and 0 prints all."
@@ -73,7 +201,7 @@ func (h *Handler) GetLog(c echo.Context) (err error) {
 		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
 	}
 
-	b := h.logBuffer.Bytes()
+	b := h.logs.Buffer().Bytes()
 	if !utf8.Valid(b) {
 		b = bytes.ToValidUTF8(b, []byte(""))
 	}
@@ -93,6 +221,111 @@ func (h *Handler) GetLog(c echo.Context) (err error) {
 	return c.Blob(http.StatusOK, echo.MIMETextPlainCharsetUTF8, b)
 }
 
+// @Tags Debug
+// @Summary Get parsed log entries
+// @Description Entries are taken from log file if it is enabled, otherwise from in-memory buffer. The oldest entries go first.
+// @Param from query string false "RFC3339 time, entries written before it are skipped"
+// @Param to query string false "RFC3339 time, entries written after it are skipped"
+// @Param level query string false "Minimal level: debug, info, warn, error"
+// @Param logger query string false "Logger name prefix, e.g. awl/vpn"
+// @Param at query string false "RFC3339 time, jump to the first entry written at or after it. Overrides offset"
+// @Param offset query int false "Number of matched entries to skip"
+// @Param limit query int false "Max number of entries, default 100, max 1000"
+// @Produce json
+// @Success 200 {object} entity.LogEntriesResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /debug/log/entries [GET]
+func (h *Handler) GetLogEntries(c echo.Context) (err error) {
+	req := entity.LogEntriesRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	entries, err := h.queryLogs(req.LogQuery)
+	if err != nil {
+		if errors.Is(err, errInvalidLogLevel) {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+		}
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultLogEntriesLimit
+	}
+	offset := req.Offset
+	if !req.At.IsZero() {
+		offset = logview.IndexAt(entries, req.At)
+	}
+	offset = min(offset, len(entries))
+	end := min(offset+limit, len(entries))
+
+	return c.JSON(http.StatusOK, entity.LogEntriesResponse{
+		Entries: entries[offset:end],
+		Offset:  offset,
+		Total:   len(entries),
+	})
+}
+
+// @Tags Debug
+// @Summary Download logs for time range
+// @Param from query string false "RFC3339 time, entries written before it are skipped"
+// @Param to query string false "RFC3339 time, entries written after it are skipped"
+// @Param level query string false "Minimal level: debug, info, warn, error"
+// @Param logger query string false "Logger name prefix, e.g. awl/vpn"
+// @Produce plain
+// @Success 200 {string} string "log text"
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /debug/log/download [GET]
+func (h *Handler) DownloadLog(c echo.Context) (err error) {
+	req := entity.LogQuery{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	entries, err := h.queryLogs(req)
+	if err != nil {
+		if errors.Is(err, errInvalidLogLevel) {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+		}
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	buf := new(bytes.Buffer)
+	for _, entry := range entries {
+		buf.WriteString(entry.String())
+		buf.WriteString(zapcore.DefaultLineEnding)
+	}
+	filename := fmt.Sprintf("awl_%s.log", time.Now().Format("2006-01-02_15-04-05"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(http.StatusOK, echo.MIMETextPlainCharsetUTF8, buf.Bytes())
+}
+
+func (h *Handler) queryLogs(req entity.LogQuery) ([]logview.Entry, error) {
+	query := logview.Query{
+		From:     req.From,
+		To:       req.To,
+		MinLevel: zapcore.DebugLevel,
+		Logger:   req.Logger,
+	}
+	if req.Level != "" {
+		err := query.MinLevel.UnmarshalText([]byte(req.Level))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidLogLevel, req.Level)
+		}
+	}
+	entries, err := h.logs.Entries()
+	if err != nil {
+		return nil, err
+	}
+	return query.Filter(entries), nil
+}
+
 func makeBandwidthInfo(stats metrics.Stats) entity.BandwidthInfo {
 	return entity.BandwidthInfo{
 		TotalIn:  byteCountIEC(stats.TotalIn),
@@ -115,13 +348,3 @@ func byteCountIEC(b int64) string {
 	return fmt.Sprintf("%.1f %ciB",
 		float64(b)/float64(div), "KMGTPE"[exp])
 }
-
-func maToStrings(addrs []ma.Multiaddr) []string {
-	res := make([]string, 0, len(addrs))
-	for i := range addrs {
-		res = append(res, addrs[i].String())
-	}
-	sort.Strings(res)
-
-	return res
-}
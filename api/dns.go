@@ -0,0 +1,58 @@
+//go:build !awl_nodns
+
+package api
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) setupDNSRoutes(e *echo.Echo) {
+	e.POST(UpdateDNSUpstreamPath, h.UpdateDNSUpstream)
+}
+
+// @Tags Settings
+// @Summary Update dns upstream
+// @Description DNS queries which aren't awl names are resolved by the upstream peer, e.g. the peer with Pi-hole or
+// @Description corporate dns, the peer should allow using it as exit node. The peer resolves them with its upstream
+// @Description address. Queries are resolved by our upstream address while the peer is unreachable.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateDNSUpstreamRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /settings/dns_upstream [POST]
+func (h *Handler) UpdateDNSUpstream(c echo.Context) (err error) {
+	req := entity.UpdateDNSUpstreamRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.PeerID != "" {
+		knownPeer, exists := h.conf.GetPeer(req.PeerID)
+		if !exists {
+			return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+		} else if !knownPeer.AllowedUsingAsExitNode {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("peer doesn't allow using it as exit node"))
+		}
+	}
+	if req.Address != "" {
+		_, _, err = net.SplitHostPort(req.Address)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+		}
+	}
+
+	h.conf.Lock()
+	h.conf.DNS.UpstreamPeerID = req.PeerID
+	h.conf.DNS.UpstreamAddress = req.Address
+	h.conf.Unlock()
+	h.conf.Save()
+	h.dns.RefreshConfig()
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,10 @@
+//go:build awl_nodns
+
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// setupDNSRoutes doesn't serve dns upstream api in builds without dns.
+func (h *Handler) setupDNSRoutes(_ *echo.Echo) {}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/entity"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+const (
+	eventsBufferSize   = 64
+	eventsWriteTimeout = 10 * time.Second
+	eventsPingInterval = 30 * time.Second
+)
+
+// eventsUpgrader checks that Origin matches Host, so other sites opened in the browser can't subscribe
+var eventsUpgrader = websocket.Upgrader{}
+
+// @Tags Events
+// @Summary Stream events over WebSocket
+// @Description Every WebSocket message is entity.Event in JSON. Type is one of KnownPeerChanged, ConfigChanged,
+// @Description ReceivedAuthRequest, PeerConnected, PeerDisconnected, PeerConnectionTypeChanged, HolePunchFinished,
+// @Description ReachabilityChanged, VPNPathChanged, VPNInterfaceStateChanged, FileTransferProgress,
+// @Description TextMessageReceived, PeerIdentityMigrated, PeerRenamed, SubnetConflictsChanged.
+// @Description Slow clients which don't read events are disconnected, they should reconnect and refresh their state.
+// @Param types query []string false "event types to receive, all by default"
+// @Success 101
+// @Failure 400 {object} api.Error
+// @Router /events [GET]
+func (h *Handler) StreamEvents(c echo.Context) (err error) {
+	req := entity.EventsRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	types := make(map[string]bool, len(req.Types))
+	for _, eventType := range req.Types {
+		types[eventType] = true
+	}
+
+	conn, err := eventsUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// upgrader has already replied with error
+		return nil
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+
+	events := make(chan entity.Event, eventsBufferSize)
+	awlevent.Tap(ctx, func(evt interface{}) {
+		eventType := awlevent.Name(evt)
+		if len(types) > 0 && !types[eventType] {
+			return
+		}
+		data, err := json.Marshal(evt)
+		if err != nil {
+			h.logger.Errorf("events: marshal %s: %v", eventType, err)
+			return
+		}
+		select {
+		case events <- entity.Event{Type: eventType, Time: time.Now(), Data: data}:
+		default:
+			h.logger.Warnf("events: client %s is too slow, disconnecting", c.RealIP())
+			cancel()
+		}
+	}, h.eventbus, eventbus.BufSize(eventsBufferSize))
+
+	// client messages are ignored, reading is needed to process control frames and detect closed connection
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(eventsWriteTimeout))
+			return nil
+		case evt := <-events:
+			_ = conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if err := conn.WriteJSON(evt); err != nil {
+				return nil
+			}
+		case <-ping.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWriteTimeout))
+			if err != nil {
+				return nil
+			}
+		}
+	}
+}
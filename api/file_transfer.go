@@ -0,0 +1,100 @@
+//go:build !awl_nofiletransfer
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) setupFileTransferRoutes(e *echo.Echo) {
+	e.POST(UpdateFileTransferPath, h.UpdateFileTransfer)
+	e.POST(SendFilePath, h.SendFile)
+	e.GET(GetFileTransfersPath, h.GetFileTransfers)
+}
+
+// @Tags File transfer
+// @Summary Send file to the known peer
+// @Description File is sent in background, progress is reported by transfers list and FileTransferProgress events.
+// @Description Interrupted transfers are retried and resumed from the part received by the peer.
+// @Accept json
+// @Produce json
+// @Param body body entity.SendFileRequest true "Params"
+// @Success 200 {object} service.FileTransferStatus
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /file_transfer/send [POST]
+func (h *Handler) SendFile(c echo.Context) (err error) {
+	req := entity.SendFileRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+	if !filepath.IsAbs(req.Path) {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("path should be absolute"))
+	}
+
+	status, err := h.fileTransfer.Send(knownPeer.PeerId(), req.Path)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// @Tags File transfer
+// @Summary Get active and recently finished file transfers
+// @Accept json
+// @Produce json
+// @Success 200 {array} service.FileTransferStatus
+// @Router /file_transfer/transfers [GET]
+func (h *Handler) GetFileTransfers(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.fileTransfer.Transfers())
+}
+
+// @Tags Settings
+// @Summary Update inbox of file transfers
+// @Description Files sent by known peers are saved to the inbox, files are refused if it isn't set.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateFileTransferRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/file_transfer [POST]
+func (h *Handler) UpdateFileTransfer(c echo.Context) (err error) {
+	req := entity.UpdateFileTransferRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.InboxPath != "" {
+		if !filepath.IsAbs(req.InboxPath) {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("path should be absolute"))
+		}
+		info, err := os.Stat(req.InboxPath)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+		} else if !info.IsDir() {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("%s is not a directory", req.InboxPath)))
+		}
+	}
+
+	h.conf.Lock()
+	h.conf.FileTransfer.InboxPath = req.InboxPath
+	h.conf.Unlock()
+	h.conf.Save()
+
+	return c.NoContent(http.StatusOK)
+}
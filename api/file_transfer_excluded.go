@@ -0,0 +1,10 @@
+//go:build awl_nofiletransfer
+
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// setupFileTransferRoutes doesn't serve file transfer api in builds without it.
+func (h *Handler) setupFileTransferRoutes(_ *echo.Echo) {}
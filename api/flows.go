@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+// @Tags Flows
+// @Summary Get active flows through the tunnel
+// @Description Flows are identified by protocol, local and remote address. Idle flows are removed after a few minutes.
+// @Produce json
+// @Success 200 {array} entity.FlowResponse
+// @Router /flows [GET]
+func (h *Handler) GetFlows(c echo.Context) (err error) {
+	flows := h.tunnel.Flows()
+	result := make([]entity.FlowResponse, 0, len(flows))
+	for _, flow := range flows {
+		response := entity.FlowResponse{Flow: flow}
+		if knownPeer, exists := h.conf.GetPeer(flow.PeerID); exists {
+			response.PeerName = knownPeer.DisplayName()
+		}
+		result = append(result, response)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
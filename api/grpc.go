@@ -0,0 +1,439 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/anywherelan/awl/api/apipb"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//go:generate protoc -I apipb --go_out=apipb --go_opt=paths=source_relative --go-grpc_out=apipb --go-grpc_opt=paths=source_relative awl.proto
+
+// setupGRPC starts gRPC api on config.GRPCConfig.ListenAddress, if it is set.
+func (h *Handler) setupGRPC() error {
+	h.conf.RLock()
+	grpcConf := h.conf.GRPC
+	h.conf.RUnlock()
+	if grpcConf.ListenAddress == "" {
+		return nil
+	}
+
+	var opts []grpc.ServerOption
+	if grpcConf.CertFile != "" || grpcConf.KeyFile != "" {
+		tlsConf, err := grpcTLSConfig(grpcConf)
+		if err != nil {
+			return fmt.Errorf("grpc tls: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	} else if grpcConf.ClientCAFile != "" {
+		return errors.New("grpc client certificates can't be verified without tls certificate")
+	}
+	val, err := newValidator()
+	if err != nil {
+		return err
+	}
+
+	h.conf.RLock()
+	apiKeysEnabled := len(h.conf.APIAuth.Keys) > 0
+	h.conf.RUnlock()
+	// otherwise anyone who can connect to the api can control awl
+	if host, _, _ := net.SplitHostPort(grpcConf.ListenAddress); grpcConf.ClientCAFile == "" && !apiKeysEnabled && !isLoopbackHost(host) {
+		return fmt.Errorf("grpc api on %s requires client certificates or api keys", grpcConf.ListenAddress)
+	}
+
+	listener, err := net.Listen("tcp", grpcConf.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("listen grpc on %s: %v", grpcConf.ListenAddress, err)
+	}
+
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(h.grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(h.grpcStreamInterceptor),
+	)
+	server := grpc.NewServer(opts...)
+	apipb.RegisterAwlServer(server, &grpcService{h: h, validator: val})
+	reflection.Register(server)
+
+	h.serversLock.Lock()
+	h.grpcServer, h.grpcListener = server, listener
+	h.serversLock.Unlock()
+	go func() {
+		err := server.Serve(listener)
+		if err != nil {
+			h.logger.Errorf("serve grpc api: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func grpcTLSConfig(grpcConf config.GRPCConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(grpcConf.CertFile, grpcConf.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if grpcConf.ClientCAFile != "" {
+		caData, err := os.ReadFile(grpcConf.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates in %s", grpcConf.ClientCAFile)
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConf, nil
+}
+
+func (h *Handler) grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := h.grpcAuthorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (h *Handler) grpcStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := h.grpcAuthorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// grpcAuthorize checks the address of connection by config.APIAuthConfig networks and api keys from the authorization
+// metadata, the same as apiNetworksMiddleware and apiAuthMiddleware.
+func (h *Handler) grpcAuthorize(ctx context.Context, method string) error {
+	ip := grpcRemoteIP(ctx)
+	allowed, err := h.conf.AllowsAPIAddress(ip)
+	if err != nil {
+		h.logger.Errorf("check api networks: %v", err)
+	}
+	if !allowed {
+		return status.Error(codes.PermissionDenied, "address is not allowed")
+	}
+
+	h.conf.RLock()
+	authEnabled := len(h.conf.APIAuth.Keys) > 0
+	requireOnLocalhost := h.conf.APIAuth.RequireOnLocalhost
+	h.conf.RUnlock()
+	if !authEnabled || (!requireOnLocalhost && ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	if !strings.HasPrefix(header, authorizationPrefix) {
+		return status.Error(codes.Unauthenticated, "api key is required")
+	}
+	key, exists := h.conf.APIKeyByHash(apiKeyHash(strings.TrimPrefix(header, authorizationPrefix)))
+	if !exists {
+		return status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	if key.Permission != config.APIKeyPermissionAdmin && !grpcReadOnlyMethods[method] {
+		return status.Error(codes.PermissionDenied, "api key is read-only")
+	}
+	return nil
+}
+
+// grpcReadOnlyMethods are allowed for api keys with read permission.
+var grpcReadOnlyMethods = map[string]bool{
+	apipb.Awl_GetMyPeerInfo_FullMethodName:    true,
+	apipb.Awl_GetStats_FullMethodName:         true,
+	apipb.Awl_ListPeers_FullMethodName:        true,
+	apipb.Awl_GetPeer_FullMethodName:          true,
+	apipb.Awl_ListBlockedPeers_FullMethodName: true,
+	apipb.Awl_ListAuthRequests_FullMethodName: true,
+	// reflection is used by clients like grpcurl to list services
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": true,
+}
+
+// grpcRemoteIP returns nil if the address of connection is invalid.
+func grpcRemoteIP(ctx context.Context) net.IP {
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	if tcpAddr, ok := p.Addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (h *Handler) shutdownGRPC(ctx context.Context) {
+	h.serversLock.RLock()
+	server := h.grpcServer
+	h.serversLock.RUnlock()
+	if server == nil {
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+// GRPCAddress returns address of gRPC api, empty if it is disabled.
+func (h *Handler) GRPCAddress() string {
+	h.serversLock.RLock()
+	defer h.serversLock.RUnlock()
+	if h.grpcListener == nil {
+		return ""
+	}
+	return h.grpcListener.Addr().String()
+}
+
+// grpcService implements gRPC api with the same logic as REST handlers.
+type grpcService struct {
+	apipb.UnimplementedAwlServer
+
+	h         *Handler
+	validator *validator.Validate
+}
+
+func (s *grpcService) GetMyPeerInfo(context.Context, *emptypb.Empty) (*apipb.MyPeerInfo, error) {
+	h := s.h
+	stats := h.p2p.StatsSnapshot()
+	return &apipb.MyPeerInfo{
+		PeerId:                  h.conf.P2pNode.PeerID,
+		Name:                    h.conf.P2pNode.Name,
+		ServerVersion:           config.Version,
+		Uptime:                  durationpb.New(stats.Uptime),
+		NetworkStats:            networkStatsPb(stats.Bandwidth.Total),
+		Reachability:            stats.Reachability,
+		TotalBootstrapPeers:     int32(stats.Bootstrap.TotalCount),
+		ConnectedBootstrapPeers: int32(stats.Bootstrap.ConnectedCount),
+		AwlDnsAddress:           h.dns.AwlDNSAddress(),
+		IsAwlDnsSetAsSystem:     h.dns.IsAwlDNSSetAsSystem(),
+		NetworkStatsByCategory:  networkStatsByCategoryPb(stats.Bandwidth.ByCategory),
+	}, nil
+}
+
+func (s *grpcService) GetStats(context.Context, *emptypb.Empty) (*apipb.Stats, error) {
+	stats := s.h.p2p.StatsSnapshot()
+	byProtocol := make(map[string]*apipb.NetworkStats, len(stats.Bandwidth.ByProtocol))
+	for proto, protoStats := range stats.Bandwidth.ByProtocol {
+		byProtocol[string(proto)] = networkStatsPb(protoStats)
+	}
+	return &apipb.Stats{
+		Uptime:                  durationpb.New(stats.Uptime),
+		Reachability:            stats.Reachability,
+		ConnectedPeersCount:     int32(stats.Connections.ConnectedPeersCount),
+		OpenConnectionsCount:    int32(stats.Connections.OpenConnectionsCount),
+		OpenStreamsCount:        stats.Streams.OpenStreamsCount,
+		RefusedConnectionsCount: stats.Connections.RefusedCount,
+		DhtRoutingTableSize:     int32(stats.DHT.RoutingTableSize),
+		Total:                   networkStatsPb(stats.Bandwidth.Total),
+		ByProtocol:              byProtocol,
+		ByCategory:              networkStatsByCategoryPb(stats.Bandwidth.ByCategory),
+	}, nil
+}
+
+func (s *grpcService) ListPeers(context.Context, *emptypb.Empty) (*apipb.ListPeersResponse, error) {
+	knownPeers := s.h.knownPeersResponse("")
+	resp := &apipb.ListPeersResponse{Peers: make([]*apipb.Peer, 0, len(knownPeers))}
+	for _, knownPeer := range knownPeers {
+		resp.Peers = append(resp.Peers, peerPb(knownPeer))
+	}
+	return resp, nil
+}
+
+func (s *grpcService) GetPeer(_ context.Context, req *apipb.PeerRequest) (*apipb.Peer, error) {
+	knownPeer, exists := s.h.conf.GetPeer(req.GetPeerId())
+	if !exists {
+		return nil, status.Error(codes.NotFound, "peer not found")
+	}
+	return peerPb(s.h.knownPeerResponse(knownPeer)), nil
+}
+
+func (s *grpcService) UpdatePeerSettings(_ context.Context, req *apipb.UpdatePeerSettingsRequest) (*emptypb.Empty, error) {
+	settings := entity.UpdatePeerSettingsRequest{
+		PeerID:               req.GetPeerId(),
+		Alias:                req.GetAlias(),
+		DomainName:           req.GetDomainName(),
+		AllowUsingAsExitNode: req.GetAllowUsingAsExitNode(),
+		KillSwitch:           req.GetKillSwitch(),
+		SharedFolderAccess:   req.GetSharedFolderAccess(),
+		SupportAccess:        req.GetSupportAccess(),
+		ManagementAllowed:    req.GetManagementAllowed(),
+	}
+	if err := s.validator.Struct(settings); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &emptypb.Empty{}, grpcError(s.h.updatePeerSettings(settings))
+}
+
+func (s *grpcService) RemovePeer(_ context.Context, req *apipb.PeerRequest) (*emptypb.Empty, error) {
+	if err := s.validator.Struct(entity.PeerIDRequest{PeerID: req.GetPeerId()}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &emptypb.Empty{}, grpcError(s.h.removePeer(req.GetPeerId()))
+}
+
+func (s *grpcService) ListBlockedPeers(context.Context, *emptypb.Empty) (*apipb.ListBlockedPeersResponse, error) {
+	blockedPeers := s.h.blockedPeers()
+	resp := &apipb.ListBlockedPeersResponse{Peers: make([]*apipb.BlockedPeer, 0, len(blockedPeers))}
+	for _, blockedPeer := range blockedPeers {
+		resp.Peers = append(resp.Peers, &apipb.BlockedPeer{
+			PeerId:      blockedPeer.PeerID,
+			DisplayName: blockedPeer.DisplayName,
+			CreatedAt:   timestamppb.New(blockedPeer.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcService) SendFriendRequest(_ context.Context, req *apipb.FriendRequest) (*emptypb.Empty, error) {
+	friendRequest := entity.FriendRequest{PeerID: req.GetPeerId(), Alias: req.GetAlias()}
+	if err := s.validator.Struct(friendRequest); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &emptypb.Empty{}, grpcError(s.h.sendFriendRequest(friendRequest))
+}
+
+func (s *grpcService) ListAuthRequests(context.Context, *emptypb.Empty) (*apipb.ListAuthRequestsResponse, error) {
+	authRequestsMap := s.h.authStatus.GetIngoingAuthRequests()
+	resp := &apipb.ListAuthRequestsResponse{Requests: make([]*apipb.AuthRequest, 0, len(authRequestsMap))}
+	for peerID, req := range authRequestsMap {
+		resp.Requests = append(resp.Requests, &apipb.AuthRequest{PeerId: peerID, Name: req.Name})
+	}
+	return resp, nil
+}
+
+func (s *grpcService) ReplyAuthRequest(_ context.Context, req *apipb.ReplyAuthRequestRequest) (*emptypb.Empty, error) {
+	reply := entity.FriendRequestReply{PeerID: req.GetPeerId(), Alias: req.GetAlias(), Decline: req.GetDecline()}
+	if err := s.validator.Struct(reply); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &emptypb.Empty{}, grpcError(s.h.ReplyFriendRequest(reply))
+}
+
+// grpcError converts errors of operations shared with REST handlers to gRPC status errors.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var statusErr statusError
+	if !errors.As(err, &statusErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	switch statusErr.status {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, statusErr.message)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, statusErr.message)
+	default:
+		return status.Error(codes.Internal, statusErr.message)
+	}
+}
+
+func peerPb(knownPeer entity.KnownPeersResponse) *apipb.Peer {
+	return &apipb.Peer{
+		PeerId:                 knownPeer.PeerID,
+		DisplayName:            knownPeer.DisplayName,
+		Alias:                  knownPeer.Alias,
+		Version:                knownPeer.Version,
+		IpAddr:                 knownPeer.IpAddr,
+		Ipv6Addr:               knownPeer.IPv6Addr,
+		DomainName:             knownPeer.DomainName,
+		Connected:              knownPeer.Connected,
+		Confirmed:              knownPeer.Confirmed,
+		Declined:               knownPeer.Declined,
+		WeAllowUsingAsExitNode: knownPeer.WeAllowUsingAsExitNode,
+		AllowedUsingAsExitNode: knownPeer.AllowedUsingAsExitNode,
+		DnsRecords:             knownPeer.DNSRecords,
+		SubnetRoutes:           knownPeer.SubnetRoutes,
+		KillSwitch:             knownPeer.KillSwitch,
+		LastSeen:               timestamppb.New(knownPeer.LastSeen),
+		NetworkStats:           networkStatsPb(knownPeer.NetworkStats),
+		Notes:                  knownPeer.Notes,
+		NetworkStatsByCategory: networkStatsByCategoryPb(knownPeer.NetworkStatsByCategory),
+		Latency:                peerLatencyPb(knownPeer.Latency),
+		ConnectionType:         knownPeer.ConnectionType,
+	}
+}
+
+func peerLatencyPb(latency service.PeerLatency) *apipb.PeerLatency {
+	return &apipb.PeerLatency{
+		Reachable:  latency.Reachable,
+		Relayed:    latency.Relayed,
+		LastRtt:    durationpb.New(latency.LastRTT),
+		AvgRtt:     durationpb.New(latency.AvgRTT),
+		MinRtt:     durationpb.New(latency.MinRTT),
+		MaxRtt:     durationpb.New(latency.MaxRTT),
+		PacketLoss: latency.PacketLoss,
+		Pings:      int32(latency.Pings),
+		CheckedAt:  timestamppb.New(latency.CheckedAt),
+
+		ClockOffsetKnown: latency.ClockOffsetKnown,
+		ClockOffset:      durationpb.New(latency.ClockOffset),
+		DelayAsymmetry:   durationpb.New(latency.DelayAsymmetry),
+	}
+}
+
+func networkStatsPb(stats metrics.Stats) *apipb.NetworkStats {
+	return &apipb.NetworkStats{
+		TotalIn:  stats.TotalIn,
+		TotalOut: stats.TotalOut,
+		RateIn:   stats.RateIn,
+		RateOut:  stats.RateOut,
+	}
+}
+
+func networkStatsByCategoryPb(byCategory map[string]metrics.Stats) map[string]*apipb.NetworkStats {
+	result := make(map[string]*apipb.NetworkStats, len(byCategory))
+	for category, stats := range byCategory {
+		result[category] = networkStatsPb(stats)
+	}
+	return result
+}
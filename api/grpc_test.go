@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupGRPC_RequiresAuthOnPublicAddress(t *testing.T) {
+	a := require.New(t)
+	conf := &config.Config{}
+	conf.GRPC.ListenAddress = "0.0.0.0:0"
+	h := &Handler{conf: conf, logger: log.Logger("awl/api")}
+
+	a.EqualError(h.setupGRPC(), "grpc api on 0.0.0.0:0 requires client certificates or api keys")
+	a.Empty(h.GRPCAddress())
+
+	conf.APIAuth.Keys = []config.APIKey{{Name: "admin", Hash: apiKeyHash("key"), Permission: config.APIKeyPermissionAdmin}}
+	a.NoError(h.setupGRPC())
+	a.NotEmpty(h.GRPCAddress())
+	h.shutdownGRPC(context.Background())
+
+	conf.APIAuth.Keys = nil
+	conf.GRPC.ListenAddress = "127.0.0.1:0"
+	a.NoError(h.setupGRPC())
+	h.shutdownGRPC(context.Background())
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+// @Tags Settings
+// @Summary Rotate identity key
+// @Description New key is applied on the next start, so application should be restarted after this call.
+// @Description After restart statement signed by the old key is sent to trusted peers, so they move us to the new peer id.
+// @Description Peers which are offline receive it later, they should allow our new peer id if OnlyKnownPeers connection gater option is enabled.
+// @Produce json
+// @Success 200 {object} entity.IdentityMigrationResponse
+// @Failure 400 {object} api.Error
+// @Router /settings/rotate_identity [POST]
+func (h *Handler) RotateIdentity(c echo.Context) (err error) {
+	migration, err := h.authStatus.RotateIdentity()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, h.identityMigrationResponse(migration))
+}
+
+// @Tags Settings
+// @Summary Get the last identity rotation
+// @Description OldPeerID is empty if identity was never rotated.
+// @Produce json
+// @Success 200 {object} entity.IdentityMigrationResponse
+// @Router /settings/identity_migration [GET]
+func (h *Handler) GetIdentityMigration(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.identityMigrationResponse(h.authStatus.IdentityMigration()))
+}
+
+func (h *Handler) identityMigrationResponse(migration config.IdentityMigrationConfig) entity.IdentityMigrationResponse {
+	pendingPeers := migration.PendingPeers
+	if pendingPeers == nil {
+		pendingPeers = []string{}
+	}
+	return entity.IdentityMigrationResponse{
+		OldPeerID:       migration.OldPeerID,
+		NewPeerID:       migration.NewPeerID,
+		CreatedAt:       migration.CreatedAt,
+		PendingPeers:    pendingPeers,
+		RestartRequired: h.authStatus.IdentityRotationPending(),
+	}
+}
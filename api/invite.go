@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/invite"
+	"github.com/anywherelan/awl/service"
+	"github.com/labstack/echo/v4"
+)
+
+// @Tags Peers
+// @Summary Create one-time invite token
+// @Description The invited peer accepts the token and sends friend request which is accepted automatically.
+// @Description Peer which uses the token gets the alias. Invites don't work with OnlyKnownPeers connection gater option.
+// @Accept json
+// @Produce json
+// @Param body body entity.CreateInviteRequest true "Params"
+// @Success 200 {object} entity.CreateInviteResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /peers/create_invite [POST]
+func (h *Handler) CreateInvite(c echo.Context) (err error) {
+	req := entity.CreateInviteRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	req.Alias = config.NormalizePeerAlias(req.Alias)
+	if req.Alias != "" && !h.conf.IsUniqPeerAlias("", req.Alias) {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(ErrorPeerAliasIsNotUniq))
+	}
+	ttl := service.DefaultInviteTTL
+	if req.ExpiresInHours != 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+
+	token, expiresAt, err := h.authStatus.CreateInvite(req.Alias, ttl)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, entity.CreateInviteResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// @Tags Peers
+// @Summary Accept invite token
+// @Description Issuer of the token is added to known peers and friend request is sent to it, the request is accepted automatically.
+// @Accept json
+// @Produce json
+// @Param body body entity.AcceptInviteRequest true "Params"
+// @Success 200 {object} entity.AcceptInviteResponse
+// @Failure 400 {object} api.Error
+// @Router /peers/accept_invite [POST]
+func (h *Handler) AcceptInvite(c echo.Context) (err error) {
+	req := entity.AcceptInviteRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	token, err := invite.Decode(req.Token, time.Now())
+	if errors.Is(err, invite.ErrExpired) {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("Invite has expired, ask for a new one"))
+	} else if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if token.PeerID == h.conf.P2pNode.PeerID {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("You can't add yourself"))
+	}
+	if _, exist := h.conf.GetPeer(token.PeerID); exist {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("Peer has already been added"))
+	}
+
+	req.Alias = config.NormalizePeerAlias(req.Alias)
+	if req.Alias != "" && !h.conf.IsUniqPeerAlias("", req.Alias) {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(ErrorPeerAliasIsNotUniq))
+	}
+	alias := h.conf.GenUniqPeerAlias(token.Name, req.Alias)
+
+	err = h.authStatus.AcceptInvite(h.ctx, token, alias)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, entity.AcceptInviteResponse{
+		PeerID: token.PeerID,
+		Name:   token.Name,
+		Alias:  alias,
+	})
+}
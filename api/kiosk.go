@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// kioskPaths are read-only endpoints served by kiosk web server, the rest of api is forbidden there.
+// Exported server config is excluded since it contains our identity.
+var kioskPaths = map[string]bool{
+	GetKnownPeersPath:          true,
+	GetPeerGroupsPath:          true,
+	GetPeerLatencyPath:         true,
+	GetSpeedTestHistoryPath:    true,
+	GetMyPeerInfoPath:          true,
+	GetPowerProfilePath:        true,
+	GetSubnetConflictsPath:     true,
+	GetFlowsPath:               true,
+	EventsPath:                 true,
+	SearchPath:                 true,
+	GetScheduledJobsPath:       true,
+	GetScheduledJobReportsPath: true,
+	GetFileTransfersPath:       true,
+	GetP2pDebugInfoPath:        true,
+	GetDebugLogPath:            true,
+	GetLogEntriesPath:          true,
+	GetStatsSnapshotPath:       true,
+	GetDHTRoutingTablePath:     true,
+	GetPacketFilterPath:        true,
+	GetBandwidthLimitPath:      true,
+	GetQoSPath:                 true,
+	// web ui
+	"/*": true,
+}
+
+func kioskMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !isReadOnlyRequest(c) {
+			return c.JSON(http.StatusForbidden, ErrorMessage("api is read-only"))
+		}
+		return next(c)
+	}
+}
+
+// isReadOnlyRequest reports whether the request is allowed by read-only api, i.e. kiosk server or read api keys.
+func isReadOnlyRequest(c echo.Context) bool {
+	return c.Request().Method == http.MethodGet && kioskPaths[c.Path()]
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/anywherelan/awl/protocol"
+	"github.com/labstack/echo/v4"
+)
+
+// ProxyManagement forwards api requests to the api of the known peer over awl network,
+// the peer should allow us to manage it, see config.KnownPeer.ManagementAllowed.
+func (h *Handler) ProxyManagement(c echo.Context) (err error) {
+	knownPeer, exists := h.conf.GetPeer(c.Param("peerID"))
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+
+	err = h.management.ProxyToPeer(c.Response(), c.Request(), knownPeer.PeerId())
+	if err != nil && !c.Response().Committed {
+		return c.JSON(http.StatusBadGateway, ErrorMessage(fmt.Sprintf("peer api is unavailable: %v", err)))
+	}
+	return nil
+}
+
+// setupManagementServer serves api to peers over management streams.
+func (h *Handler) setupManagementServer() error {
+	e, err := h.newRouter(h.managementMiddleware)
+	if err != nil {
+		return err
+	}
+	e.Listener = h.management.Listener()
+
+	h.serversLock.Lock()
+	h.echoManagement = e
+	h.serversLock.Unlock()
+	go func() {
+		if err := e.StartServer(e.Server); err != nil && err != http.ErrServerClosed {
+			h.logger.Warnf("shutting down management web server: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (h *Handler) shutdownManagementServer(ctx context.Context) {
+	h.serversLock.RLock()
+	e := h.echoManagement
+	h.serversLock.RUnlock()
+	if e == nil {
+		return
+	}
+
+	err := e.Server.Shutdown(ctx)
+	if err != nil {
+		h.logger.Errorf("error shutting down management web server: %v", err)
+	}
+}
+
+// managementMiddleware checks the grant of the peer for each request, since it could be revoked while connection is open.
+// Remote address of management connection is the peer id. Peers can't manage other peers through us.
+func (h *Handler) managementMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		knownPeer, known := h.conf.GetPeer(c.Request().RemoteAddr)
+		if !known || !knownPeer.ManagementAllowed {
+			return c.JSON(http.StatusForbidden, ErrorMessage("management is not allowed"))
+		}
+		if strings.HasPrefix(c.Request().URL.Path, protocol.ManagementPathPrefix) {
+			return c.JSON(http.StatusForbidden, ErrorMessage("managed peers can't be managed through this peer"))
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+const sendMessageTimeout = 30 * time.Second
+
+// @Tags Messages
+// @Summary Send text message to the known peer
+// @Description Message is stored in history after the peer has received it.
+// @Accept json
+// @Produce json
+// @Param body body entity.SendMessageRequest true "Params"
+// @Success 200 {object} service.Message
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /messages/send [POST]
+func (h *Handler) SendMessage(c echo.Context) (err error) {
+	req := entity.SendMessageRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), sendMessageTimeout)
+	defer cancel()
+	message, err := h.messages.Send(ctx, knownPeer.PeerId(), req.Text)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, message)
+}
+
+// @Tags Messages
+// @Summary Get history of text messages
+// @Description Messages are ordered from the oldest, they aren't marked as read.
+// @Param peer_id query string false "Peer id, messages with all peers by default"
+// @Param limit query int false "Number of the latest messages, all by default"
+// @Produce json
+// @Success 200 {array} service.Message
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /messages [GET]
+func (h *Handler) GetMessages(c echo.Context) (err error) {
+	req := entity.MessagesRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	messages, err := h.messages.History(req.PeerID, req.Limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, messages)
+}
+
+// @Tags Messages
+// @Summary Mark received messages as read
+// @Accept json
+// @Produce json
+// @Param body body entity.MarkMessagesReadRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /messages/mark_read [POST]
+func (h *Handler) MarkMessagesRead(c echo.Context) (err error) {
+	req := entity.MarkMessagesReadRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	err = h.messages.MarkRead(req.PeerID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -18,6 +18,14 @@ func getStatsInIECUnits(stats metrics.Stats) entity.StatsInUnits {
 	}
 }
 
+func getStatsByCategoryInIECUnits(byCategory map[string]metrics.Stats) map[string]entity.StatsInUnits {
+	result := make(map[string]entity.StatsInUnits, len(byCategory))
+	for category, stats := range byCategory {
+		result[category] = getStatsInIECUnits(stats)
+	}
+	return result
+}
+
 func convertBytesToIECUnits(bytesSize float64) string {
 	const unit = float64(1024)
 	IECUnits := [9]string{
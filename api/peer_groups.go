@@ -0,0 +1,236 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/service"
+	"github.com/labstack/echo/v4"
+)
+
+// @Tags Peers
+// @Summary Update groups of the peer
+// @Description Groups replace the current ones, policies of the groups are applied to the peer along with its own settings.
+// @Description Members of groups with owner are synced from the owner and can't be changed.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePeerGroupsRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /peers/update_groups [POST]
+func (h *Handler) UpdatePeerGroups(c echo.Context) (err error) {
+	req := entity.UpdatePeerGroupsRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	groups, err := config.NormalizePeerGroups(req.Groups)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+	h.conf.RLock()
+	for _, name := range changedGroups(knownPeer.Groups, groups) {
+		if owner := h.conf.PeerGroups[name].Owner; owner != "" {
+			h.conf.RUnlock()
+			return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("members of group %q are managed by its owner %s", name, owner)))
+		}
+	}
+	knownPeer.Groups = groups
+	err = service.ValidateACLRules(knownPeer.EffectiveACL(h.conf.PeerGroups))
+	h.conf.RUnlock()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("acl of the peer with its groups: %v", err)))
+	}
+	h.conf.UpsertPeer(knownPeer)
+	h.exchangeStatusInfo([]config.KnownPeer{knownPeer})
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Peers
+// @Summary Get peer groups
+// @Description Groups with policies and groups of known peers, with their members
+// @Produce json
+// @Success 200 {array} entity.PeerGroupResponse
+// @Router /peers/groups [GET]
+func (h *Handler) GetPeerGroups(c echo.Context) (err error) {
+	names := h.conf.PeerGroupNames()
+	result := make([]entity.PeerGroupResponse, 0, len(names))
+	h.conf.RLock()
+	for _, name := range names {
+		group, hasPolicy := h.conf.PeerGroups[name]
+		response := entity.PeerGroupResponse{
+			Name:                 name,
+			HasPolicy:            hasPolicy,
+			ACL:                  group.ACL,
+			AllowUsingAsExitNode: group.AllowUsingAsExitNode,
+			Shared:               group.Shared,
+			Owner:                group.Owner,
+			Members:              []string{},
+		}
+		if group.MembershipTime != 0 {
+			membershipTime := time.UnixMilli(group.MembershipTime)
+			response.MembershipTime = &membershipTime
+		}
+		for peerID, knownPeer := range h.conf.KnownPeers {
+			if knownPeer.InGroup(name) {
+				response.Members = append(response.Members, peerID)
+			}
+		}
+		sort.Strings(response.Members)
+		result = append(result, response)
+	}
+	h.conf.RUnlock()
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// @Tags Peers
+// @Summary Update policy of peer group
+// @Description Policy replaces the current one, ACL rules are checked after rules of each member.
+// @Description The group could be created before it's given to peers.
+// @Description Shared group publishes its members signed by us to the members. Members of group with owner are synced
+// @Description from the owner, it should share the group with the same name.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePeerGroupRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /peers/update_group [POST]
+func (h *Handler) UpdatePeerGroup(c echo.Context) (err error) {
+	req := entity.UpdatePeerGroupRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	name, err := config.NormalizePeerGroup(req.Name)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = service.ValidateACLRules(req.ACL); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.Owner != "" {
+		if req.Shared {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("group with owner can't be shared by us"))
+		}
+		if _, exists := h.conf.GetPeer(req.Owner); !exists {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("owner is not a known peer"))
+		}
+	}
+
+	group := config.PeerGroup{
+		ACL:                  req.ACL,
+		AllowUsingAsExitNode: req.AllowUsingAsExitNode,
+		Shared:               req.Shared,
+		Owner:                req.Owner,
+	}
+	if existing, exists := h.conf.GetPeerGroup(name); exists && existing.Owner == group.Owner {
+		group.MembershipTime = existing.MembershipTime
+	}
+	members := h.groupMembers(name)
+	h.conf.RLock()
+	groups := make(map[string]config.PeerGroup, len(h.conf.PeerGroups)+1)
+	for groupName, existing := range h.conf.PeerGroups {
+		groups[groupName] = existing
+	}
+	h.conf.RUnlock()
+	groups[name] = group
+	for _, knownPeer := range members {
+		err = service.ValidateACLRules(knownPeer.EffectiveACL(groups))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest,
+				ErrorMessage(fmt.Sprintf("acl of peer %q with its groups: %v", knownPeer.DisplayName(), err)))
+		}
+	}
+	h.conf.SetPeerGroup(name, group)
+	h.exchangeStatusInfo(members)
+	if group.Owner != "" {
+		go h.sharedGroups.Sync(h.ctx, group.Owner)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Peers
+// @Summary Remove peer group
+// @Description Policy of the group is removed and the group is removed from all peers
+// @Accept json
+// @Produce json
+// @Param body body entity.RemovePeerGroupRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /peers/remove_group [POST]
+func (h *Handler) RemovePeerGroup(c echo.Context) (err error) {
+	req := entity.RemovePeerGroupRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	name, err := config.NormalizePeerGroup(req.Name)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	members := h.conf.RemovePeerGroup(name)
+	h.exchangeStatusInfo(members)
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *Handler) groupMembers(name string) []config.KnownPeer {
+	h.conf.RLock()
+	defer h.conf.RUnlock()
+	var members []config.KnownPeer
+	for _, knownPeer := range h.conf.KnownPeers {
+		if knownPeer.InGroup(name) {
+			members = append(members, knownPeer)
+		}
+	}
+	return members
+}
+
+// changedGroups returns groups which are only in one of the lists.
+func changedGroups(old, groups []string) []string {
+	var changed []string
+	for _, name := range old {
+		if !slices.Contains(groups, name) {
+			changed = append(changed, name)
+		}
+	}
+	for _, name := range groups {
+		if !slices.Contains(old, name) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// exchangeStatusInfo sends new status info to the peers, e.g. after their permission to use us as exit node was changed.
+func (h *Handler) exchangeStatusInfo(peers []config.KnownPeer) {
+	for _, knownPeer := range peers {
+		go func(knownPeer config.KnownPeer) {
+			_ = h.authStatus.ExchangeNewStatusInfo(h.ctx, knownPeer.PeerId(), knownPeer)
+		}(knownPeer)
+	}
+}
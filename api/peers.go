@@ -1,59 +1,78 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/anywherelan/awl/awldns"
 	"github.com/anywherelan/awl/config"
 	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/peerimport"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/service"
+	"github.com/anywherelan/awl/vpn"
 	"github.com/labstack/echo/v4"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
-const ErrorPeerAliasIsNotUniq = "peer name is not unique"
+const (
+	ErrorPeerAliasIsNotUniq      = "peer name is not unique"
+	ErrorPeerDomainNameIsNotUniq = "domain name is used by another peer"
+)
+
+const (
+	defaultEchoPayloadSize   = 1024
+	echoTimeout              = 15 * time.Second
+	defaultSupportLogMinutes = 30
+	supportReportTimeout     = time.Minute
+	portScanTimeout          = time.Minute
+)
 
 // @Tags Peers
 // @Summary Get known peers info
+// @Param group query string false "Group of peers, all peers by default"
 // @Accept json
 // @Produce json
 // @Success 200 {array} entity.KnownPeersResponse
+// @Failure 400 {object} api.Error
 // @Router /peers/get_known [GET]
 func (h *Handler) GetKnownPeers(c echo.Context) (err error) {
+	req := entity.KnownPeersRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.Group != "" {
+		req.Group, err = config.NormalizePeerGroup(req.Group)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+		}
+	}
+
+	return c.JSON(http.StatusOK, h.knownPeersResponse(req.Group))
+}
+
+// knownPeersResponse returns peers of the group, all peers if the group is empty.
+func (h *Handler) knownPeersResponse(group string) []entity.KnownPeersResponse {
 	h.conf.RLock()
 	result := make([]entity.KnownPeersResponse, 0, len(h.conf.KnownPeers))
 	peers := make([]string, 0, len(h.conf.KnownPeers))
-	for peerID := range h.conf.KnownPeers {
-		peers = append(peers, peerID)
+	for peerID, knownPeer := range h.conf.KnownPeers {
+		if group == "" || knownPeer.InGroup(group) {
+			peers = append(peers, peerID)
+		}
 	}
 	h.conf.RUnlock()
 	sort.Strings(peers)
 
 	for _, peerID := range peers {
 		knownPeer, _ := h.conf.GetPeer(peerID)
-
-		id := knownPeer.PeerId()
-		netStats := h.p2p.NetworkStatsForPeer(id)
-		kpr := entity.KnownPeersResponse{
-			PeerID:                 peerID,
-			Name:                   knownPeer.DisplayName(),
-			DisplayName:            knownPeer.DisplayName(),
-			Alias:                  knownPeer.Alias,
-			Version:                config.VersionFromUserAgent(h.p2p.PeerUserAgent(id)),
-			IpAddr:                 knownPeer.IPAddr,
-			DomainName:             knownPeer.DomainName,
-			Connected:              h.p2p.IsConnected(id),
-			Confirmed:              knownPeer.Confirmed,
-			Declined:               knownPeer.Declined,
-			WeAllowUsingAsExitNode: knownPeer.WeAllowUsingAsExitNode,
-			AllowedUsingAsExitNode: knownPeer.AllowedUsingAsExitNode,
-			LastSeen:               knownPeer.LastSeen,
-			Connections:            h.p2p.PeerConnectionsInfo(id),
-			NetworkStats:           netStats,
-			NetworkStatsInIECUnits: getStatsInIECUnits(netStats),
-		}
-		result = append(result, kpr)
+		result = append(result, h.knownPeerResponse(knownPeer))
 	}
 
 	// list connected peers first
@@ -61,7 +80,54 @@ func (h *Handler) GetKnownPeers(c echo.Context) (err error) {
 		return result[i].Connected && !result[j].Connected
 	})
 
-	return c.JSON(http.StatusOK, result)
+	return result
+}
+
+func (h *Handler) knownPeerResponse(knownPeer config.KnownPeer) entity.KnownPeersResponse {
+	id := knownPeer.PeerId()
+	netStats := h.p2p.NetworkStatsForPeer(id)
+	netStatsByCategory := h.p2p.NetworkStatsForPeerByCategory(id)
+	clockSkew, _ := h.clock.PeerSkew(id)
+	latency, _ := h.latency.PeerLatency(id)
+	var ipv6Addr string
+	if ip := h.conf.IPv6FromIPv4(net.ParseIP(knownPeer.IPAddr)); ip != nil {
+		ipv6Addr = ip.String()
+	}
+	return entity.KnownPeersResponse{
+		PeerID:                 knownPeer.PeerID,
+		Name:                   knownPeer.DisplayName(),
+		DisplayName:            knownPeer.DisplayName(),
+		Alias:                  knownPeer.Alias,
+		Version:                config.VersionFromUserAgent(h.p2p.PeerUserAgent(id)),
+		IpAddr:                 knownPeer.IPAddr,
+		IPv6Addr:               ipv6Addr,
+		DomainName:             knownPeer.DomainName,
+		Connected:              h.p2p.IsConnected(id),
+		Confirmed:              knownPeer.Confirmed,
+		Declined:               knownPeer.Declined,
+		WeAllowUsingAsExitNode: knownPeer.WeAllowUsingAsExitNode,
+		AllowedUsingAsExitNode: knownPeer.AllowedUsingAsExitNode,
+		DNSRecords:             knownPeer.DNSRecords,
+		SubnetRoutes:           knownPeer.SubnetRoutes,
+		AcceptRoutes:           knownPeer.AcceptRoutes,
+		AcceptAllRoutes:        knownPeer.AcceptAllRoutes,
+		KillSwitch:             knownPeer.KillSwitch,
+		LastSeen:               knownPeer.LastSeen,
+		Connections:            h.p2p.PeerConnectionsInfo(id),
+		NetworkStats:           netStats,
+		NetworkStatsInIECUnits: getStatsInIECUnits(netStats),
+		ClockSkew:              clockSkew.Offset,
+		Notes:                  knownPeer.Notes,
+		Contact:                knownPeer.Contact,
+		Latency:                latency,
+		ConnectionType:         h.p2p.PeerConnectionType(id),
+		LocalConnection:        h.p2p.PeerIsLocal(id),
+		UnreadMessages:         h.messages.UnreadCount(knownPeer.PeerID),
+		Groups:                 knownPeer.Groups,
+
+		NetworkStatsByCategory:           netStatsByCategory,
+		NetworkStatsByCategoryInIECUnits: getStatsByCategoryInIECUnits(netStatsByCategory),
+	}
 }
 
 // @Tags Peers
@@ -107,23 +173,50 @@ func (h *Handler) UpdatePeerSettings(c echo.Context) (err error) {
 	}
 	if err = c.Validate(req); err != nil {
 		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
-	} else if !awldns.IsValidDomainName(req.DomainName) {
-		return c.JSON(http.StatusBadRequest, ErrorMessage("invalid domain name"))
+	}
+	if err = h.updatePeerSettings(req); err != nil {
+		return replyStatusError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *Handler) updatePeerSettings(req entity.UpdatePeerSettingsRequest) error {
+	// international names are compared in normalized form, e.g. composed and lowercase
+	req.DomainName = awldns.TrimDomainName(req.DomainName)
+	if !awldns.IsValidDomainName(req.DomainName) {
+		return newStatusError(http.StatusBadRequest, "invalid domain name")
+	}
+	if !h.conf.IsUniqDomainName(req.PeerID, req.DomainName) {
+		return newStatusError(http.StatusBadRequest, ErrorPeerDomainNameIsNotUniq)
 	}
 
 	knownPeer, exists := h.conf.GetPeer(req.PeerID)
 	if !exists {
-		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+		return newStatusError(http.StatusNotFound, "peer not found")
 	}
 	peerID := knownPeer.PeerId()
 
-	req.Alias = strings.TrimSpace(req.Alias)
+	req.Alias = config.NormalizePeerAlias(req.Alias)
 	if !h.conf.IsUniqPeerAlias(req.PeerID, req.Alias) {
-		return c.JSON(http.StatusBadRequest, ErrorMessage(ErrorPeerAliasIsNotUniq))
+		return newStatusError(http.StatusBadRequest, ErrorPeerAliasIsNotUniq)
 	}
 	knownPeer.Alias = req.Alias
 	knownPeer.DomainName = req.DomainName
 	knownPeer.WeAllowUsingAsExitNode = req.AllowUsingAsExitNode
+	knownPeer.KillSwitch = req.KillSwitch
+	knownPeer.SharedFolderAccess = req.SharedFolderAccess
+	knownPeer.SupportAccess = req.SupportAccess
+	knownPeer.ManagementAllowed = req.ManagementAllowed
+	if req.IPAddr != "" && req.IPAddr != knownPeer.IPAddr {
+		h.conf.RLock()
+		ipAddr, err := h.conf.AllocateIPAddr(req.PeerID, req.IPAddr)
+		h.conf.RUnlock()
+		if err != nil {
+			return newStatusError(http.StatusBadRequest, err.Error())
+		}
+		knownPeer.IPAddr = ipAddr
+	}
 
 	h.conf.UpsertPeer(knownPeer)
 
@@ -131,6 +224,177 @@ func (h *Handler) UpdatePeerSettings(c echo.Context) (err error) {
 		_ = h.authStatus.ExchangeNewStatusInfo(h.ctx, peerID, knownPeer)
 	}()
 
+	return nil
+}
+
+// @Tags Peers
+// @Summary Update peer access control list
+// @Description Rules replace the current ones, the first matching rule is applied, traffic is allowed if no rule matches
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePeerACLRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /peers/update_acl [POST]
+func (h *Handler) UpdatePeerACL(c echo.Context) (err error) {
+	req := entity.UpdatePeerACLRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = service.ValidateACLRules(req.Rules); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+	knownPeer.ACL = req.Rules
+	h.conf.RLock()
+	err = service.ValidateACLRules(knownPeer.EffectiveACL(h.conf.PeerGroups))
+	h.conf.RUnlock()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("acl of the peer with its groups: %v", err)))
+	}
+	h.conf.UpsertPeer(knownPeer)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Peers
+// @Summary Update MTU of the path to the peer
+// @Description TCP MSS of connections with the peer is clamped to fit MTU, zero MTU removes the limit
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePeerMTURequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /peers/update_mtu [POST]
+func (h *Handler) UpdatePeerMTU(c echo.Context) (err error) {
+	req := entity.UpdatePeerMTURequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.MTU != 0 && (req.MTU < vpn.MinPeerMTU || req.MTU > vpn.InterfaceMTU) {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("mtu should be between %d and %d", vpn.MinPeerMTU, vpn.InterfaceMTU)))
+	}
+
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+	knownPeer.MTU = req.MTU
+	h.conf.UpsertPeer(knownPeer)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Peers
+// @Summary Update accepting subnet routes of the peer
+// @Description Only private networks not wider than /16 are accepted, unless AcceptAllRoutes is set
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePeerRoutesRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /peers/update_routes [POST]
+func (h *Handler) UpdatePeerRoutes(c echo.Context) (err error) {
+	req := entity.UpdatePeerRoutesRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+	knownPeer.AcceptRoutes = req.AcceptRoutes
+	knownPeer.AcceptAllRoutes = req.AcceptAllRoutes
+	h.conf.UpsertPeer(knownPeer)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Peers
+// @Summary Update bandwidth limit of the peer
+// @Description VPN and SOCKS5 traffic with the peer is limited, the global limit is applied as well. Zero is unlimited
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePeerBandwidthLimitRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /peers/update_bandwidth_limit [POST]
+func (h *Handler) UpdatePeerBandwidthLimit(c echo.Context) (err error) {
+	req := entity.UpdatePeerBandwidthLimitRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+	knownPeer.BandwidthLimit = config.BandwidthLimitConfig{
+		UploadKbps:   req.UploadKbps,
+		DownloadKbps: req.DownloadKbps,
+	}
+	h.conf.UpsertPeer(knownPeer)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Peers
+// @Summary Update notes and contact info of the peer
+// @Description Notes and contact are kept only in our config, they replace the current ones
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePeerNotesRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /peers/update_notes [POST]
+func (h *Handler) UpdatePeerNotes(c echo.Context) (err error) {
+	req := entity.UpdatePeerNotesRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+	knownPeer.Notes = strings.TrimSpace(req.Notes)
+	knownPeer.Contact = config.PeerContact{
+		Owner: strings.TrimSpace(req.Contact.Owner),
+		Phone: strings.TrimSpace(req.Contact.Phone),
+		Email: strings.TrimSpace(req.Contact.Email),
+	}
+	h.conf.UpsertPeer(knownPeer)
+
 	return c.NoContent(http.StatusOK)
 }
 
@@ -152,30 +416,42 @@ func (h *Handler) SendFriendRequest(c echo.Context) (err error) {
 	if err = c.Validate(req); err != nil {
 		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
 	}
+	if err = h.sendFriendRequest(req); err != nil {
+		return replyStatusError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *Handler) sendFriendRequest(req entity.FriendRequest) error {
 	peerId, err := peer.Decode(req.PeerID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest,
-			ErrorMessage("Invalid hex-encoded multihash representing of a peer ID"))
+		return newStatusError(http.StatusBadRequest, "Invalid hex-encoded multihash representing of a peer ID")
 	}
 
 	if req.PeerID == h.conf.P2pNode.PeerID {
-		return c.JSON(http.StatusBadRequest,
-			ErrorMessage("You can't add yourself"))
+		return newStatusError(http.StatusBadRequest, "You can't add yourself")
 	}
 
 	_, exist := h.conf.GetPeer(req.PeerID)
 	if exist {
-		return c.JSON(http.StatusBadRequest, ErrorMessage("Peer has already been added"))
+		return newStatusError(http.StatusBadRequest, "Peer has already been added")
 	}
 
-	req.Alias = strings.TrimSpace(req.Alias)
+	req.Alias = config.NormalizePeerAlias(req.Alias)
 	if !h.conf.IsUniqPeerAlias("", req.Alias) {
-		return c.JSON(http.StatusBadRequest, ErrorMessage(ErrorPeerAliasIsNotUniq))
+		return newStatusError(http.StatusBadRequest, ErrorPeerAliasIsNotUniq)
 	}
 
-	h.authStatus.AddPeer(h.ctx, peerId, "", req.Alias, false)
+	if err = h.checkNewPeerIPAddr(req.IPAddr); err != nil {
+		return err
+	}
+	err = h.authStatus.AddPeer(h.ctx, peerId, "", req.Alias, req.IPAddr, false)
+	if err != nil {
+		return newStatusError(http.StatusBadRequest, err.Error())
+	}
 
-	return c.NoContent(http.StatusOK)
+	return nil
 }
 
 // @Tags Peers
@@ -196,41 +472,65 @@ func (h *Handler) AcceptFriend(c echo.Context) (err error) {
 	if err = c.Validate(req); err != nil {
 		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
 	}
+	if err = h.ReplyFriendRequest(req); err != nil {
+		return replyStatusError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ReplyFriendRequest accepts or declines the friend request received from the peer, see AcceptFriend.
+func (h *Handler) ReplyFriendRequest(req entity.FriendRequestReply) error {
 	peerId, err := peer.Decode(req.PeerID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest,
-			ErrorMessage("Invalid hex-encoded multihash representing of a peer ID"))
+		return newStatusError(http.StatusBadRequest, "Invalid hex-encoded multihash representing of a peer ID")
 	}
 
 	if req.PeerID == h.conf.P2pNode.PeerID {
-		return c.JSON(http.StatusBadRequest,
-			ErrorMessage("You can't add yourself"))
+		return newStatusError(http.StatusBadRequest, "You can't add yourself")
 	}
 
 	_, exist := h.conf.GetPeer(req.PeerID)
 	if exist {
-		return c.JSON(http.StatusBadRequest, ErrorMessage("Peer has been already added"))
+		return newStatusError(http.StatusBadRequest, "Peer has been already added")
 	}
 
 	authRequestsMap := h.authStatus.GetIngoingAuthRequests()
 	auth, exist := authRequestsMap[req.PeerID]
 	if !exist {
-		return c.JSON(http.StatusBadRequest, ErrorMessage("Peer did not send you friend request"))
+		return newStatusError(http.StatusBadRequest, "Peer did not send you friend request")
 	}
 
 	if req.Decline {
 		h.authStatus.BlockPeer(peerId, auth.Name)
-		return c.NoContent(http.StatusOK)
+		return nil
 	}
 
-	req.Alias = strings.TrimSpace(req.Alias)
+	req.Alias = config.NormalizePeerAlias(req.Alias)
 	if !h.conf.IsUniqPeerAlias("", req.Alias) {
-		return c.JSON(http.StatusBadRequest, ErrorMessage(ErrorPeerAliasIsNotUniq))
+		return newStatusError(http.StatusBadRequest, ErrorPeerAliasIsNotUniq)
+	}
+
+	if err = h.checkNewPeerIPAddr(req.IPAddr); err != nil {
+		return err
+	}
+	err = h.authStatus.AddPeer(h.ctx, peerId, auth.Name, req.Alias, req.IPAddr, true)
+	if err != nil {
+		return newStatusError(http.StatusBadRequest, err.Error())
 	}
 
-	h.authStatus.AddPeer(h.ctx, peerId, auth.Name, req.Alias, true)
+	return nil
+}
 
-	return c.NoContent(http.StatusOK)
+// checkNewPeerIPAddr requires the address of the new peer when addresses are allocated manually.
+func (h *Handler) checkNewPeerIPAddr(ipAddr string) error {
+	h.conf.RLock()
+	manual := h.conf.VPNConfig.IPAllocation == config.IPAllocationManual
+	h.conf.RUnlock()
+	if manual && ipAddr == "" {
+		return newStatusError(http.StatusBadRequest, "ip address of the peer is required by manual ip allocation")
+	}
+	return nil
 }
 
 // @Tags Peers
@@ -269,21 +569,27 @@ func (h *Handler) RemovePeer(c echo.Context) (err error) {
 	if err = c.Validate(req); err != nil {
 		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
 	}
-	peerId, err := peer.Decode(req.PeerID)
+	if err = h.removePeer(req.PeerID); err != nil {
+		return replyStatusError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *Handler) removePeer(peerID string) error {
+	peerId, err := peer.Decode(peerID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest,
-			ErrorMessage("Invalid hex-encoded multihash representing of a peer ID"))
+		return newStatusError(http.StatusBadRequest, "Invalid hex-encoded multihash representing of a peer ID")
 	}
 
-	knownPeer, exists := h.conf.RemovePeer(req.PeerID)
+	knownPeer, exists := h.conf.RemovePeer(peerID)
 	if !exists {
-		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+		return newStatusError(http.StatusNotFound, "peer not found")
 	}
 
-	h.p2p.UnprotectPeer(peerId)
 	h.authStatus.BlockPeer(peerId, knownPeer.DisplayName())
 
-	return c.NoContent(http.StatusOK)
+	return nil
 }
 
 // @Tags Peers
@@ -293,6 +599,10 @@ func (h *Handler) RemovePeer(c echo.Context) (err error) {
 // @Success 200 {array} config.BlockedPeer
 // @Router /peers/get_blocked [GET]
 func (h *Handler) GetBlockedPeers(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.blockedPeers())
+}
+
+func (h *Handler) blockedPeers() []config.BlockedPeer {
 	h.conf.RLock()
 	result := make([]config.BlockedPeer, 0)
 
@@ -306,5 +616,254 @@ func (h *Handler) GetBlockedPeers(c echo.Context) (err error) {
 
 	h.conf.RUnlock()
 
+	return result
+}
+
+// @Tags Peers
+// @Summary Import peers from other mesh tools
+// @Description Parses peers exported from Tailscale, ZeroTier, Nebula or a generic csv and invites the ones with known awl peer ID.
+// @Description Peers without peer ID are returned with suggested alias and IP address, they should be added manually after awl is installed on them.
+// @Accept json
+// @Produce json
+// @Param body body entity.ImportPeersRequest true "Params"
+// @Success 200 {object} entity.ImportPeersResponse
+// @Failure 400 {object} api.Error
+// @Router /peers/import [POST]
+func (h *Handler) ImportPeers(c echo.Context) (err error) {
+	req := entity.ImportPeersRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	peers, err := peerimport.Parse(req.Format, strings.NewReader(req.Data))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	names := make([]string, 0, len(peers))
+	for _, p := range peers {
+		names = append(names, p.Name)
+	}
+	aliases := h.conf.GenUniqPeerAliases(names)
+
+	result := entity.ImportPeersResponse{Peers: make([]entity.ImportedPeer, 0, len(peers))}
+	usedIPs := make(map[string]struct{})
+	for i, p := range peers {
+		imported := entity.ImportedPeer{
+			Alias:    aliases[i],
+			SourceIP: p.IP,
+			PeerID:   p.PeerID,
+		}
+		h.conf.RLock()
+		suggestedIP := h.conf.SuggestIpAddr(p.IP)
+		h.conf.RUnlock()
+		if _, used := usedIPs[suggestedIP]; !used && suggestedIP != "" {
+			imported.SuggestedIP = suggestedIP
+			usedIPs[suggestedIP] = struct{}{}
+		}
+
+		switch peerID, decodeErr := peer.Decode(p.PeerID); {
+		case p.PeerID == "":
+			imported.Status = entity.ImportStatusNeedsPeerID
+			imported.Message = "install awl on this device, then add it by peer ID with this name"
+		case decodeErr != nil:
+			imported.Status = entity.ImportStatusSkipped
+			imported.Message = "invalid peer ID"
+		case p.PeerID == h.conf.P2pNode.PeerID:
+			imported.Status = entity.ImportStatusSkipped
+			imported.Message = "this is your own peer ID"
+		default:
+			if _, exists := h.conf.GetPeer(p.PeerID); exists {
+				imported.Status = entity.ImportStatusSkipped
+				imported.Message = "peer has already been added"
+				break
+			}
+			imported.Status = entity.ImportStatusInvited
+			imported.Message = "friend request sent, waiting for the peer to accept it"
+			if req.DryRun {
+				break
+			}
+			err = h.authStatus.ImportPeer(h.ctx, peerID, imported.Alias, imported.SuggestedIP)
+			if err != nil {
+				imported.Status = entity.ImportStatusSkipped
+				imported.Message = err.Error()
+				break
+			}
+			knownPeer, _ := h.conf.GetPeer(p.PeerID)
+			imported.SuggestedIP = knownPeer.IPAddr
+		}
+		result.Peers = append(result.Peers, imported)
+	}
+
 	return c.JSON(http.StatusOK, result)
 }
+
+// @Tags Peers
+// @Summary Check connection to the peer end-to-end
+// @Description Sends random payload to the peer and checks that the same payload is received back.
+// @Description Peer should be a known one or run in echo peer mode, known peers answer with their clock as well.
+// @Accept json
+// @Produce json
+// @Param body body entity.EchoRequest true "Params"
+// @Success 200 {object} entity.EchoResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /peers/echo [POST]
+func (h *Handler) EchoPeer(c echo.Context) (err error) {
+	req := entity.EchoRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	peerID, err := peer.Decode(req.PeerID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			ErrorMessage("Invalid hex-encoded multihash representing of a peer ID"))
+	}
+	if req.PayloadSize == 0 {
+		req.PayloadSize = defaultEchoPayloadSize
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), echoTimeout)
+	defer cancel()
+	err = h.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+	result, err := h.probe.Send(ctx, peerID, req.PayloadSize)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	response := entity.EchoResponse{
+		RTT:              result.RTT,
+		Bytes:            result.Bytes,
+		ThroughRelay:     result.Relayed,
+		ClockOffsetKnown: result.Timed,
+		ClockOffset:      result.ClockOffset,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Tags Peers
+// @Summary Get latency history of the peer
+// @Description Known peers are pinged every 30 seconds, lost pings show that the peer is unreachable.
+// @Param peer_id query string true "Peer id"
+// @Produce json
+// @Success 200 {object} entity.PeerLatencyResponse
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /peers/latency [GET]
+func (h *Handler) GetPeerLatency(c echo.Context) (err error) {
+	req := entity.PeerLatencyRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+
+	id := knownPeer.PeerId()
+	latency, _ := h.latency.PeerLatency(id)
+	return c.JSON(http.StatusOK, entity.PeerLatencyResponse{
+		Latency: latency,
+		History: h.latency.History(id),
+	})
+}
+
+// @Tags Peers
+// @Summary Get support report from peer
+// @Description Fetches recent logs and diagnostics report from the peer, it should grant us support access
+// @Accept json
+// @Produce json
+// @Param body body entity.SupportReportRequest true "Params"
+// @Success 200 {object} service.SupportReport
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /peers/support_report [POST]
+func (h *Handler) GetSupportReport(c echo.Context) (err error) {
+	req := entity.SupportReportRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	peerID, err := peer.Decode(req.PeerID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			ErrorMessage("Invalid hex-encoded multihash representing of a peer ID"))
+	}
+	if req.LogMinutes == 0 {
+		req.LogMinutes = defaultSupportLogMinutes
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), supportReportTimeout)
+	defer cancel()
+	report, err := h.support.FetchReport(ctx, peerID, req.LogMinutes)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// @Tags Peers
+// @Summary Scan ports of peer
+// @Description Probes which TCP ports are listening on the peer or a host of its advertised routes, e.g. to set up
+// @Description forwarding without logging in to the peer. The peer should grant us management.
+// @Accept json
+// @Produce json
+// @Param body body entity.PortScanRequest true "Params"
+// @Success 200 {object} entity.PortScanResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /peers/port_scan [POST]
+func (h *Handler) PortScan(c echo.Context) (err error) {
+	req := entity.PortScanRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	peerID, err := peer.Decode(req.PeerID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest,
+			ErrorMessage("Invalid hex-encoded multihash representing of a peer ID"))
+	}
+	if _, err = service.ParsePorts(req.Ports); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), portScanTimeout)
+	defer cancel()
+	open, err := h.portScan.Scan(ctx, peerID, req.Host, req.Ports)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	response := entity.PortScanResponse{
+		PeerID: req.PeerID,
+		Host:   req.Host,
+		Open:   open,
+	}
+	if response.Open == nil {
+		response.Open = []protocol.OpenPort{}
+	}
+	return c.JSON(http.StatusOK, response)
+}
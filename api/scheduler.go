@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/service"
+	"github.com/labstack/echo/v4"
+)
+
+// @Tags Scheduled jobs
+// @Summary Get scheduled jobs
+// @Accept json
+// @Produce json
+// @Success 200 {array} config.ScheduledJob
+// @Router /scheduled_jobs [GET]
+func (h *Handler) GetScheduledJobs(c echo.Context) (err error) {
+	h.conf.RLock()
+	jobs := append([]config.ScheduledJob{}, h.conf.ScheduledJobs...)
+	h.conf.RUnlock()
+
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// @Tags Scheduled jobs
+// @Summary Update scheduled jobs
+// @Description Connection with the peer of the job is established and pinned a few minutes before the job time.
+// @Description Jobs with backup action store our backup on the peer, jobs without action only keep the connection for other programs.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateScheduledJobsRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /scheduled_jobs/update [POST]
+func (h *Handler) UpdateScheduledJobs(c echo.Context) (err error) {
+	req := entity.UpdateScheduledJobsRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = service.ValidateScheduledJobs(req.Jobs); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	for _, job := range req.Jobs {
+		if _, exists := h.conf.GetPeer(job.PeerID); !exists {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("job %s: peer not found", job.Name)))
+		}
+	}
+
+	h.conf.Lock()
+	h.conf.ScheduledJobs = req.Jobs
+	h.conf.Unlock()
+	h.conf.Save()
+	h.scheduler.Refresh()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Scheduled jobs
+// @Summary Get reports of recent scheduled jobs
+// @Description Reports show whether there was a direct connection with the peer during the job window, the newest first.
+// @Accept json
+// @Produce json
+// @Success 200 {array} service.ScheduledJobReport
+// @Router /scheduled_jobs/reports [GET]
+func (h *Handler) GetScheduledJobReports(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.scheduler.Reports())
+}
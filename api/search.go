@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/logview"
+	"github.com/labstack/echo/v4"
+)
+
+const defaultSearchEventsLimit = 100
+
+// @Tags Search
+// @Summary Search known peers and events
+// @Description Peers are matched by id, name, alias, ip addresses, domain name, dns records, subnet routes, notes and contact.
+// @Description Events are log entries matched by message and logger name, the newest first.
+// @Param q query string true "Words to search, case-insensitive"
+// @Param limit query int false "Max number of events, default 100, max 1000"
+// @Produce json
+// @Success 200 {object} entity.SearchResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /search [GET]
+func (h *Handler) Search(c echo.Context) (err error) {
+	req := entity.SearchRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	terms := strings.Fields(strings.ToLower(req.Query))
+	if len(terms) == 0 {
+		return c.JSON(http.StatusBadRequest, ErrorMessage("empty query"))
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultSearchEventsLimit
+	}
+
+	h.conf.RLock()
+	peers := make([]string, 0, len(h.conf.KnownPeers))
+	for peerID := range h.conf.KnownPeers {
+		peers = append(peers, peerID)
+	}
+	h.conf.RUnlock()
+	sort.Strings(peers)
+
+	result := entity.SearchResponse{
+		Peers:  make([]entity.KnownPeersResponse, 0),
+		Events: make([]logview.Entry, 0),
+	}
+	for _, peerID := range peers {
+		knownPeer, _ := h.conf.GetPeer(peerID)
+		kpr := h.knownPeerResponse(knownPeer)
+		fields := []string{kpr.PeerID, knownPeer.Name, kpr.Alias, kpr.IpAddr, kpr.IPv6Addr, kpr.DomainName,
+			kpr.Notes, kpr.Contact.Owner, kpr.Contact.Phone, kpr.Contact.Email}
+		fields = append(fields, kpr.DNSRecords...)
+		fields = append(fields, kpr.SubnetRoutes...)
+		if matchTerms(fields, terms) {
+			result.Peers = append(result.Peers, kpr)
+		}
+	}
+	sort.SliceStable(result.Peers, func(i, j int) bool {
+		return result.Peers[i].Connected && !result.Peers[j].Connected
+	})
+
+	entries, err := h.logs.Entries()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+	for i := len(entries) - 1; i >= 0 && len(result.Events) < limit; i-- {
+		if matchTerms([]string{entries[i].Logger, entries[i].Message}, terms) {
+			result.Events = append(result.Events, entries[i])
+		}
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// matchTerms returns true if every lowercase term is contained in any of fields.
+func matchTerms(fields []string, terms []string) bool {
+	for _, term := range terms {
+		found := false
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,20 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"slices"
 
+	"github.com/anywherelan/awl/awldns"
 	"github.com/anywherelan/awl/config"
 	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/rendezvous"
+	"github.com/anywherelan/awl/service"
+	"github.com/anywherelan/awl/vpn"
 	"github.com/labstack/echo/v4"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 // @Tags Settings
@@ -15,21 +24,56 @@ import (
 // @Success 200 {object} entity.PeerInfo
 // @Router /settings/peer_info [GET]
 func (h *Handler) GetMyPeerInfo(c echo.Context) (err error) {
-	totalBootstraps, connectedBootstraps := h.p2p.BootstrapPeersStats()
-	netStats := h.p2p.NetworkStats()
+	stats := h.p2p.StatsSnapshot()
+	netStats := stats.Bandwidth.Total
+	h.conf.RLock()
+	dnsRecords := append([]string(nil), h.conf.P2pNode.DNSRecords...)
+	killSwitch := h.conf.VPNConfig.KillSwitch
+	sharedFolderPath := h.conf.SharedFolder.Path
+	inboxPath := h.conf.FileTransfer.InboxPath
+	exitNodePeerID := h.conf.VPNConfig.ExitNodePeerID
+	advertisedRoutes := append([]string(nil), h.conf.VPNConfig.AdvertisedRoutes...)
+	socks5Config := h.conf.SOCKS5
+	dnsConfig := h.conf.DNS
+	h.conf.RUnlock()
 
+	ntpSkew, _ := h.clock.NTPSkew()
 	peerInfo := entity.PeerInfo{
 		PeerID:                  h.conf.P2pNode.PeerID,
 		Name:                    h.conf.P2pNode.Name,
-		Uptime:                  h.p2p.Uptime(),
+		Uptime:                  stats.Uptime,
 		ServerVersion:           config.Version,
 		NetworkStats:            netStats,
 		NetworkStatsInIECUnits:  getStatsInIECUnits(netStats),
-		TotalBootstrapPeers:     totalBootstraps,
-		ConnectedBootstrapPeers: connectedBootstraps,
-		Reachability:            h.p2p.Reachability().String(),
+		TotalBootstrapPeers:     stats.Bootstrap.TotalCount,
+		ConnectedBootstrapPeers: stats.Bootstrap.ConnectedCount,
+		Reachability:            stats.Reachability,
 		AwlDNSAddress:           h.dns.AwlDNSAddress(),
 		IsAwlDNSSetAsSystem:     h.dns.IsAwlDNSSetAsSystem(),
+		DNSRecords:              dnsRecords,
+		DNSUpstreamPeerID:       dnsConfig.UpstreamPeerID,
+		DNSUpstreamAddress:      dnsConfig.UpstreamAddress,
+		KillSwitch:              killSwitch,
+		SharedFolderPath:        sharedFolderPath,
+		ExitNodePeerID:          exitNodePeerID,
+		AdvertisedRoutes:        advertisedRoutes,
+		SOCKS5ListenAddress:     socks5Config.ListenAddress,
+		SOCKS5PeerID:            socks5Config.PeerID,
+		SOCKS5ResumablePorts:    append([]int(nil), socks5Config.ResumablePorts...),
+		NTPClockSkew:            ntpSkew.Offset,
+		ClockWarnings:           h.clock.Warnings(),
+
+		NetworkStatsByCategory:           stats.Bandwidth.ByCategory,
+		NetworkStatsByCategoryInIECUnits: getStatsByCategoryInIECUnits(stats.Bandwidth.ByCategory),
+
+		FileTransferInboxPath: inboxPath,
+		UnreadMessages:        h.messages.UnreadTotal(),
+
+		Features:         config.Features(),
+		ConfigEncryption: h.conf.Encryption(),
+		VPNPaused:        h.tunnel.IsPaused(),
+
+		RendezvousEnabled: h.conf.RendezvousPhrase() != "",
 	}
 
 	return c.JSON(http.StatusOK, peerInfo)
@@ -37,6 +81,8 @@ func (h *Handler) GetMyPeerInfo(c echo.Context) (err error) {
 
 // @Tags Settings
 // @Summary Update my peer info
+// @Description The name is sent to friends right away. Their aliases and domain names of this device follow the name,
+// @Description unless they were set by the friends themselves.
 // @Accept json
 // @Produce json
 // @Param body body entity.UpdateMySettingsRequest true "Params"
@@ -53,12 +99,514 @@ func (h *Handler) UpdateMySettings(c echo.Context) (err error) {
 	}
 
 	h.conf.Lock()
-	h.conf.P2pNode.Name = req.Name
+	h.conf.P2pNode.Name = config.NormalizePeerAlias(req.Name)
 	h.conf.Unlock()
+	// status info with new name is sent to peers on config change event
+	h.conf.Save()
 
-	go func() {
-		h.authStatus.ExchangeStatusInfoWithAllKnownPeers(h.ctx)
-	}()
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update config encryption at rest
+// @Description Config holds the identity key and peers, so it could be encrypted with passphrase or with a key stored by OS keychain.
+// @Description Passphrase is required on start in AWL_CONFIG_PASSPHRASE env or in terminal. Empty mode stores config as plain json.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateConfigEncryptionRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/config_encryption [POST]
+func (h *Handler) UpdateConfigEncryption(c echo.Context) (err error) {
+	req := entity.UpdateConfigEncryptionRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	err = h.conf.SetEncryption(req.Mode, req.Passphrase)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update my dns records
+// @Description Records are additional names which friends resolve to this node, e.g. "plex" is resolved as plex.<my domain>.awl.
+// @Description Records are sent to friends with status info, so they are updated right away for connected peers.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateDNSRecordsRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/dns_records [POST]
+func (h *Handler) UpdateDNSRecords(c echo.Context) (err error) {
+	req := entity.UpdateDNSRecordsRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if len(req.Records) > awldns.MaxPeerDNSRecords {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("too many dns records, max is %d", awldns.MaxPeerDNSRecords)))
+	}
+	for _, record := range req.Records {
+		if !awldns.IsValidDomainName(awldns.TrimDomainName(record)) {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("invalid dns record %q", record)))
+		}
+	}
+	records := awldns.ValidDNSRecords(req.Records)
+
+	h.conf.Lock()
+	h.conf.P2pNode.DNSRecords = records
+	h.conf.Unlock()
+	// status info with new records is sent to peers on config change event
+	h.conf.Save()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update global kill switch
+// @Description When enabled, traffic to peers is dropped while they are not confirmed or not connected, instead of waiting for the connection.
+// @Description Kill switch can also be enabled for a single peer in peer settings.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateKillSwitchRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/kill_switch [POST]
+func (h *Handler) UpdateKillSwitch(c echo.Context) (err error) {
+	req := entity.UpdateKillSwitchRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.VPNConfig.KillSwitch = req.Enabled
+	h.conf.Unlock()
+	h.conf.Save()
+	h.tunnel.RefreshPeersList()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Pause or resume vpn
+// @Description Paused vpn interface is brought down and its traffic is dropped, connections to peers and services
+// @Description which don't use the interface keep working. The interface is resumed on restart.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateVPNPauseRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /settings/vpn_pause [POST]
+func (h *Handler) UpdateVPNPause(c echo.Context) (err error) {
+	req := entity.UpdateVPNPauseRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	err = h.tunnel.SetPaused(req.Paused)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update rendezvous phrase
+// @Description Peers which set the same phrase find each other in DHT and become friends without confirmation.
+// @Description Anyone who knows the phrase is accepted, so it should be long, e.g. several random words. Case and
+// @Description extra spaces are ignored, empty phrase disables rendezvous.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateRendezvousRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/rendezvous [POST]
+func (h *Handler) UpdateRendezvous(c echo.Context) (err error) {
+	req := entity.UpdateRendezvousRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = rendezvous.ValidatePhrase(req.Phrase); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.P2pNode.Rendezvous.Phrase = rendezvous.NormalizePhrase(req.Phrase)
+	h.conf.Unlock()
+	h.conf.Save()
+	h.authStatus.RefreshRendezvous()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update packet filter
+// @Description Drops classes of traffic read from the interface before it's routed to peers, e.g. all IPv6 or multicast.
+// @Description Dropped packets are counted, see /debug/packet_filter.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePacketFilterRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/packet_filter [POST]
+func (h *Handler) UpdatePacketFilter(c echo.Context) (err error) {
+	req := entity.UpdatePacketFilterRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.VPNConfig.PacketFilter = config.PacketFilterConfig{
+		DropIPv6:      req.DropIPv6,
+		DropMulticast: req.DropMulticast,
+		DropProtocols: req.DropProtocols,
+	}
+	h.conf.Unlock()
+	h.conf.Save()
+	h.tunnel.RefreshPacketFilter()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update QoS
+// @Description Packets to peers are prioritized when the link is saturated: high priority packets wait for a small share of
+// @Description bulk traffic instead of the whole queue. Rules are checked first, then DSCP marks and ports of interactive
+// @Description protocols: SSH, DNS, NTP, STUN and SIP. Packets are counted by priority, see /debug/qos.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateQoSRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/qos [POST]
+func (h *Handler) UpdateQoS(c echo.Context) (err error) {
+	req := entity.UpdateQoSRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	err = service.ValidateQoSRules(req.Rules)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.VPNConfig.QoS = config.QoSConfig{
+		Disabled: req.Disabled,
+		Rules:    req.Rules,
+	}
+	h.conf.Unlock()
+	h.conf.Save()
+	h.tunnel.RefreshQoS()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update IP allocation strategy
+// @Description Strategy of addresses of new peers: sequential after the highest address, hash of peer id which is the same
+// @Description on every node of the network, manual which requires the address in friend requests, or negotiate which uses
+// @Description the address the peer has for itself if it's free. Addresses of known peers aren't changed.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateIPAllocationRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/ip_allocation [POST]
+func (h *Handler) UpdateIPAllocation(c echo.Context) (err error) {
+	req := entity.UpdateIPAllocationRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.VPNConfig.IPAllocation = req.Strategy
+	h.conf.Unlock()
+	h.conf.Save()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Get subnet conflicts
+// @Description The vpn network and routes of peers which overlap networks of local interfaces, with actions to resolve them.
+// @Description Traffic to the overlapped vpn network partially goes to the local network, routes of peers are not used
+// @Description while they overlap. Changes are sent as SubnetConflictsChanged events.
+// @Produce json
+// @Success 200 {object} entity.SubnetConflictsResponse
+// @Router /settings/subnet_conflicts [GET]
+func (h *Handler) GetSubnetConflicts(c echo.Context) (err error) {
+	h.conf.RLock()
+	autoRenumber := h.conf.VPNConfig.AutoRenumber
+	h.conf.RUnlock()
+
+	response := entity.SubnetConflictsResponse{
+		AutoRenumber: autoRenumber,
+		Conflicts:    h.routing.Conflicts(),
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Tags Settings
+// @Summary Update auto renumber
+// @Description When enabled, the vpn network is moved to a free one on start if it overlaps a local network.
+// @Description Host parts of our address and addresses of peers are kept, e.g. 10.66.0.7 becomes 10.67.0.7.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateAutoRenumberRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/auto_renumber [POST]
+func (h *Handler) UpdateAutoRenumber(c echo.Context) (err error) {
+	req := entity.UpdateAutoRenumberRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.VPNConfig.AutoRenumber = req.Enabled
+	h.conf.Unlock()
+	h.conf.Save()
+	h.routing.Refresh()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update global bandwidth limit
+// @Description VPN and SOCKS5 traffic with all peers in total is limited, limits of peers are applied as well. Zero is unlimited
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateBandwidthLimitRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/bandwidth_limit [POST]
+func (h *Handler) UpdateBandwidthLimit(c echo.Context) (err error) {
+	req := entity.UpdateBandwidthLimitRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.BandwidthLimit = config.BandwidthLimitConfig{
+		UploadKbps:   req.UploadKbps,
+		DownloadKbps: req.DownloadKbps,
+	}
+	h.conf.Unlock()
+	h.conf.Save()
+	h.shaper.Refresh()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Get power profile
+// @Description PowerSave is enabled on battery: keepalives, reconnections and status exchange with peers are less frequent
+// @Description and the DHT doesn't answer queries of other peers.
+// @Accept json
+// @Produce json
+// @Success 200 {object} service.PowerProfile
+// @Router /settings/power [GET]
+func (h *Handler) GetPowerProfile(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, h.power.Profile())
+}
+
+// @Tags Settings
+// @Summary Update power mode
+// @Description Auto mode detects the power source of the device, ac and battery modes override it.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdatePowerModeRequest true "Params"
+// @Success 200 {object} service.PowerProfile
+// @Failure 400 {object} api.Error
+// @Router /settings/update_power [POST]
+func (h *Handler) UpdatePowerMode(c echo.Context) (err error) {
+	req := entity.UpdatePowerModeRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.Power.Mode = req.Mode
+	h.conf.Unlock()
+	h.conf.Save()
+	h.power.Refresh()
+
+	return c.JSON(http.StatusOK, h.power.Profile())
+}
+
+// @Tags Settings
+// @Summary Update exit node
+// @Description All IPv4 internet traffic is routed through the exit node, the peer should allow using it as exit node.
+// @Description Routes are set while the exit node is connected. Empty peer id routes traffic directly.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateExitNodeRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /settings/exit_node [POST]
+func (h *Handler) UpdateExitNode(c echo.Context) (err error) {
+	req := entity.UpdateExitNodeRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.PeerID != "" {
+		knownPeer, exists := h.conf.GetPeer(req.PeerID)
+		if !exists {
+			return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+		} else if !knownPeer.AllowedUsingAsExitNode {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("peer doesn't allow using it as exit node"))
+		}
+	}
+
+	h.conf.Lock()
+	h.conf.VPNConfig.ExitNodePeerID = req.PeerID
+	h.conf.Unlock()
+	h.conf.Save()
+	h.tunnel.RefreshPeersList()
+	h.routing.Refresh()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Update advertised routes
+// @Description Routes are our networks, e.g. home LAN, which peers reach through us. Traffic to them is translated to our address.
+// @Description Routes are sent to friends with status info. Empty list stops advertising.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateAdvertisedRoutesRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/advertised_routes [POST]
+func (h *Handler) UpdateAdvertisedRoutes(c echo.Context) (err error) {
+	req := entity.UpdateAdvertisedRoutesRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if len(req.Routes) > vpn.MaxSubnetRoutes {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("too many routes, max is %d", vpn.MaxSubnetRoutes)))
+	}
+	h.conf.RLock()
+	_, vpnNet, err := net.ParseCIDR(h.conf.VPNConfig.IPNet)
+	h.conf.RUnlock()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+	for _, route := range req.Routes {
+		_, network, err := net.ParseCIDR(route)
+		if err != nil || network.IP.To4() == nil {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("invalid IPv4 network %q", route)))
+		}
+		if ones, _ := network.Mask.Size(); ones == 0 {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("default route can't be advertised, use exit node instead"))
+		}
+		if vpn.NetworksOverlap(network, vpnNet) {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("network %s overlaps vpn network %s", network, vpnNet)))
+		}
+	}
+	routes := vpn.ValidSubnetRoutes(req.Routes)
+
+	h.conf.Lock()
+	h.conf.VPNConfig.AdvertisedRoutes = routes
+	h.conf.Unlock()
+	// status info with new routes is sent to peers on config change event
+	h.conf.Save()
+	h.tunnel.RefreshPeersList()
+	h.routing.Refresh()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// @Tags Settings
+// @Summary Get connection gater settings
+// @Accept json
+// @Produce json
+// @Success 200 {object} config.ConnectionGaterConfig
+// @Router /settings/connection_gater [GET]
+func (h *Handler) GetConnectionGater(c echo.Context) (err error) {
+	h.conf.RLock()
+	gaterConfig := h.conf.P2pNode.ConnectionGater
+	gaterConfig.Allowlist = append([]string{}, gaterConfig.Allowlist...)
+	gaterConfig.Denylist = append([]string{}, gaterConfig.Denylist...)
+	h.conf.RUnlock()
+
+	return c.JSON(http.StatusOK, gaterConfig)
+}
+
+// @Tags Settings
+// @Summary Update connection gater settings
+// @Description Connections of denylisted peers are refused in both directions, existing connections are closed.
+// @Description With OnlyKnownPeers inbound connections are accepted only from known and allowlisted peers, bootstrap peers and relays.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateConnectionGaterRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/update_connection_gater [POST]
+func (h *Handler) UpdateConnectionGater(c echo.Context) (err error) {
+	req := entity.UpdateConnectionGaterRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	_, err = decodePeerIDs(req.Allowlist, h.p2p.PeerID())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	denied, err := decodePeerIDs(req.Denylist, h.p2p.PeerID())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	h.conf.Lock()
+	h.conf.P2pNode.ConnectionGater = config.ConnectionGaterConfig{
+		OnlyKnownPeers: req.OnlyKnownPeers,
+		Allowlist:      sortedUnique(req.Allowlist),
+		Denylist:       sortedUnique(req.Denylist),
+	}
+	h.conf.Unlock()
+	h.conf.Save()
+
+	for _, peerID := range denied {
+		err = h.p2p.ClosePeer(peerID)
+		if err != nil {
+			h.logger.Warnf("close connections with denied peer %s: %v", peerID, err)
+		}
+	}
 
 	return c.NoContent(http.StatusOK)
 }
@@ -74,3 +622,24 @@ func (h *Handler) ExportServerConfiguration(c echo.Context) (err error) {
 
 	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, data)
 }
+
+func decodePeerIDs(peerIDs []string, ourPeerID peer.ID) ([]peer.ID, error) {
+	result := make([]peer.ID, 0, len(peerIDs))
+	for _, peerIDStr := range peerIDs {
+		peerID, err := peer.Decode(peerIDStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer id %q: %v", peerIDStr, err)
+		}
+		if peerID == ourPeerID {
+			return nil, errors.New("can't use our own peer id")
+		}
+		result = append(result, peerID)
+	}
+	return result, nil
+}
+
+func sortedUnique(values []string) []string {
+	result := slices.Clone(values)
+	slices.Sort(result)
+	return slices.Compact(result)
+}
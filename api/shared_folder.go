@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+var webDAVMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// ProxySharedFolder forwards WebDAV requests to the known peer's shared folder, so it could be mounted
+// by any WebDAV client at /shared/<peer id>/.
+func (h *Handler) ProxySharedFolder(c echo.Context) (err error) {
+	knownPeer, exists := h.conf.GetPeer(c.Param("peerID"))
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+
+	h.sharedFolder.ProxyToPeer(c.Response(), c.Request(), knownPeer.PeerId())
+	return nil
+}
+
+// @Tags Settings
+// @Summary Update shared folder
+// @Description Folder is shared with friends over WebDAV, access is granted to each peer in peer settings.
+// @Description Friends mount it at http://<their api address>/shared/<our peer id>/.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateSharedFolderRequest true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Router /settings/shared_folder [POST]
+func (h *Handler) UpdateSharedFolder(c echo.Context) (err error) {
+	req := entity.UpdateSharedFolderRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.Path != "" {
+		if !filepath.IsAbs(req.Path) {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("path should be absolute"))
+		}
+		info, err := os.Stat(req.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+		} else if !info.IsDir() {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(fmt.Sprintf("%s is not a directory", req.Path)))
+		}
+	}
+
+	h.conf.Lock()
+	h.conf.SharedFolder.Path = req.Path
+	h.conf.Unlock()
+	h.conf.Save()
+
+	return c.NoContent(http.StatusOK)
+}
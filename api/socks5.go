@@ -0,0 +1,63 @@
+//go:build !awl_nosocks5
+
+package api
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) setupSOCKS5Routes(e *echo.Echo) {
+	e.POST(UpdateSOCKS5Path, h.UpdateSOCKS5)
+}
+
+// @Tags Settings
+// @Summary Update SOCKS5 proxy
+// @Description Local SOCKS5 server tunnels TCP connections to the peer, the peer makes connections on our behalf.
+// @Description The peer should allow using it as exit node. Empty listen address stops the server.
+// @Description Connections to resumable ports are resumed after brief outages, e.g. SSH sessions survive Wi-Fi switch.
+// @Accept json
+// @Produce json
+// @Param body body entity.UpdateSOCKS5Request true "Params"
+// @Success 200 "OK"
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Router /settings/socks5 [POST]
+func (h *Handler) UpdateSOCKS5(c echo.Context) (err error) {
+	req := entity.UpdateSOCKS5Request{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if req.ListenAddress != "" {
+		_, _, err = net.SplitHostPort(req.ListenAddress)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+		}
+		knownPeer, exists := h.conf.GetPeer(req.PeerID)
+		if !exists {
+			return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+		} else if !knownPeer.AllowedUsingAsExitNode {
+			return c.JSON(http.StatusBadRequest, ErrorMessage("peer doesn't allow using it as exit node"))
+		}
+	}
+
+	h.conf.Lock()
+	h.conf.SOCKS5.ListenAddress = req.ListenAddress
+	h.conf.SOCKS5.PeerID = req.PeerID
+	h.conf.SOCKS5.ResumablePorts = req.ResumablePorts
+	h.conf.Unlock()
+	err = h.socks5.Restart()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	h.conf.Save()
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,10 @@
+//go:build awl_nosocks5
+
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// setupSOCKS5Routes doesn't serve SOCKS5 api in builds without it.
+func (h *Handler) setupSOCKS5Routes(_ *echo.Echo) {}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/service"
+	"github.com/labstack/echo/v4"
+)
+
+// @Tags Peers
+// @Summary Measure throughput to the peer
+// @Description Streams generated data to the peer and back for given seconds each way and measures latency under load.
+// @Description Peers of older versions are sent 4 MiB with echo protocol. Result is saved to speed test history, failed tests as well.
+// @Description Tests could be run regularly with scheduled jobs with speedtest action.
+// @Accept json
+// @Produce json
+// @Param body body entity.SpeedTestRequest true "Params"
+// @Success 200 {object} service.SpeedTestResult
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /peers/speed_test [POST]
+func (h *Handler) RunSpeedTest(c echo.Context) (err error) {
+	req := entity.SpeedTestRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	knownPeer, exists := h.conf.GetPeer(req.PeerID)
+	if !exists {
+		return c.JSON(http.StatusNotFound, ErrorMessage("peer not found"))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), service.SpeedTestTimeout)
+	defer cancel()
+	result, err := h.speedTest.Run(ctx, knownPeer.PeerId(), time.Duration(req.Seconds)*time.Second)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// @Tags Peers
+// @Summary Get speed test history
+// @Description Results are kept for 90 days, trends summarize them by day to show degradation of ISP or relays.
+// @Param peer_id query string false "Peer id, all peers by default"
+// @Param from query string false "RFC3339 time, results before it are skipped"
+// @Param to query string false "RFC3339 time, results after it are skipped"
+// @Produce json
+// @Success 200 {object} entity.SpeedTestHistoryResponse
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /peers/speed_test_history [GET]
+func (h *Handler) GetSpeedTestHistory(c echo.Context) (err error) {
+	req := entity.SpeedTestHistoryRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	results, err := h.speedTest.History(req.PeerID, req.From, req.To)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, entity.SpeedTestHistoryResponse{
+		Results: results,
+		Trends:  service.SpeedTestTrends(results),
+	})
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/labstack/echo/v4"
+)
+
+const mimeTextCSV = "text/csv; charset=utf-8"
+
+// @Tags Usage
+// @Summary Export traffic or connection history as CSV
+// @Description Traffic is aggregated by hour for each known peer. Connections are sessions sampled every minute,
+// @Description disconnected_at is empty for currently connected peers. History is kept for 90 days.
+// @Param type query string true "traffic or connections"
+// @Param from query string false "RFC3339 time, records before it are skipped"
+// @Param to query string false "RFC3339 time, records after it are skipped"
+// @Produce text/csv
+// @Success 200 {string} string "csv"
+// @Failure 400 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Router /usage/export [GET]
+func (h *Handler) ExportUsage(c echo.Context) (err error) {
+	req := entity.ExportUsageRequest{}
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+	if err = c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorMessage(err.Error()))
+	}
+
+	var rows [][]string
+	switch req.Type {
+	case "traffic":
+		rows, err = h.trafficRows(req.From, req.To)
+	case "connections":
+		rows, err = h.connectionsRows(req.From, req.To)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	err = writer.WriteAll(rows)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorMessage(err.Error()))
+	}
+	filename := fmt.Sprintf("awl_%s_%s.csv", req.Type, time.Now().Format("2006-01-02"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(http.StatusOK, mimeTextCSV, buf.Bytes())
+}
+
+func (h *Handler) trafficRows(from, to time.Time) ([][]string, error) {
+	records, err := h.usage.Traffic(from, to)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]string, 0, len(records)+1)
+	rows = append(rows, []string{"hour_start", "peer_id", "peer_name", "bytes_in", "bytes_out", "connected_seconds"})
+	for _, record := range records {
+		rows = append(rows, []string{
+			record.Start.UTC().Format(time.RFC3339),
+			record.PeerID,
+			h.peerName(record.PeerID),
+			strconv.FormatInt(record.BytesIn, 10),
+			strconv.FormatInt(record.BytesOut, 10),
+			strconv.FormatInt(record.ConnectedSeconds, 10),
+		})
+	}
+	return rows, nil
+}
+
+func (h *Handler) connectionsRows(from, to time.Time) ([][]string, error) {
+	sessions, err := h.usage.Sessions(from, to)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rows := make([][]string, 0, len(sessions)+1)
+	rows = append(rows, []string{"peer_id", "peer_name", "connected_at", "disconnected_at", "duration_seconds"})
+	for _, session := range sessions {
+		disconnectedAt, end := "", now
+		if !session.DisconnectedAt.IsZero() {
+			disconnectedAt, end = session.DisconnectedAt.UTC().Format(time.RFC3339), session.DisconnectedAt
+		}
+		rows = append(rows, []string{
+			session.PeerID,
+			h.peerName(session.PeerID),
+			session.ConnectedAt.UTC().Format(time.RFC3339),
+			disconnectedAt,
+			strconv.FormatInt(int64(end.Sub(session.ConnectedAt)/time.Second), 10),
+		})
+	}
+	return rows, nil
+}
+
+// peerName returns display name of known peer or empty string for removed peers.
+func (h *Handler) peerName(peerID string) string {
+	knownPeer, exists := h.conf.GetPeer(peerID)
+	if !exists {
+		return ""
+	}
+	return knownPeer.DisplayName()
+}
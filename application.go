@@ -2,35 +2,37 @@ package awl
 
 import (
 	"context"
-	"embed"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io/fs"
 	"net"
-	"net/netip"
 	"os"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/anywherelan/awl/api"
-	"github.com/anywherelan/awl/awldns"
 	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/backup"
 	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/logsampling"
+	"github.com/anywherelan/awl/logview"
 	"github.com/anywherelan/awl/p2p"
 	"github.com/anywherelan/awl/protocol"
 	"github.com/anywherelan/awl/ringbuffer"
 	"github.com/anywherelan/awl/service"
+	"github.com/anywherelan/awl/storage"
 	"github.com/anywherelan/awl/vpn"
-	"github.com/anywherelan/ts-dns/net/dns"
-	"github.com/anywherelan/ts-dns/util/dnsname"
-	ds "github.com/ipfs/go-datastore"
-	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2pProtocol "github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.zx2c4.com/wireguard/tun"
@@ -38,18 +40,10 @@ import (
 
 const (
 	logBufSize = 1 << 20
-)
-
-//go:embed static
-var frontendStatic embed.FS
 
-func FrontendStatic() fs.FS {
-	fsys, err := fs.Sub(frontendStatic, "static")
-	if err != nil {
-		panic(err)
-	}
-	return fsys
-}
+	logSamplingWindow = 10 * time.Second
+	logSamplingBurst  = 10
+)
 
 // useAwldns is used for tests
 var useAwldns = true
@@ -66,18 +60,41 @@ var useAwldns = true
 
 type Application struct {
 	LogBuffer *ringbuffer.RingBuffer
+	LogFile   *logview.File
 	logger    *log.ZapEventLogger
 	Conf      *config.Config
 	Eventbus  awlevent.Bus
-
-	ctx        context.Context
-	ctxCancel  context.CancelFunc
-	vpnDevice  *vpn.Device
-	P2p        *p2p.P2p
-	Api        *api.Handler
-	AuthStatus *service.AuthStatus
-	Tunnel     *service.Tunnel
-	Dns        *DNSService
+	Storage   storage.Storage
+
+	ctx          context.Context
+	ctxCancel    context.CancelFunc
+	vpnDevice    *vpn.Device
+	P2p          *p2p.P2p
+	Api          *api.Handler
+	AuthStatus   *service.AuthStatus
+	Tunnel       *service.Tunnel
+	Shaper       *service.Shaper
+	Routing      *service.Routing
+	Echo         *service.Echo
+	Probe        *service.Probe
+	SpeedTest    *service.SpeedTest
+	Backup       *service.Backup
+	Scheduler    *service.Scheduler
+	Usage        *service.Usage
+	SharedFolder *service.SharedFolder
+	Support      *service.Support
+	Clock        *service.Clock
+	Power        *service.Power
+	Latency      *service.Latency
+	Management   *service.Management
+	PortScan     *service.PortScan
+	SharedGroups *service.SharedGroups
+	FileTransfer *service.FileTransfer
+	Messages     *service.Messages
+	SOCKS5       *service.SOCKS5Proxy
+	Streams      *service.StreamRegistry
+	Watchdog     *Watchdog
+	dnsComponents
 }
 
 func New() *Application {
@@ -86,8 +103,18 @@ func New() *Application {
 
 func (a *Application) Init(ctx context.Context, tunDevice tun.Device) error {
 	a.ctx, a.ctxCancel = context.WithCancel(ctx)
+	fileStorage, err := storage.Open(a.Conf.DataDir())
+	if err != nil {
+		return err
+	}
+	a.Storage = fileStorage
+
 	a.P2p = p2p.NewP2p(a.ctx)
-	p2pHost, err := a.P2p.InitHost(a.makeP2pHostConfig())
+	hostConfig, err := a.makeP2pHostConfig()
+	if err != nil {
+		return err
+	}
+	p2pHost, err := a.P2p.InitHost(hostConfig)
 	if err != nil {
 		return err
 	}
@@ -97,33 +124,158 @@ func (a *Application) Init(ctx context.Context, tunDevice tun.Device) error {
 	a.logger.Infof("Host created. We are: %s", p2pHost.ID().String())
 	a.logger.Infof("Listen interfaces: %v", p2pHost.Addrs())
 
-	localIP, netMask := a.Conf.VPNLocalIPMask()
 	interfaceName := a.Conf.VPNConfig.InterfaceName
-	vpnDevice, err := vpn.NewDevice(tunDevice, interfaceName, localIP, netMask)
+	if a.Conf.VPNConfig.AutoRenumber {
+		a.renumberVPNNetwork(interfaceName)
+	}
+	localIP, netMask := a.Conf.VPNLocalIPMask()
+	localIPv6, ipv6Mask := a.Conf.VPNLocalIPv6Mask()
+	queues := a.Conf.VPNConfig.Queues
+	if queues <= 0 {
+		queues = vpn.DefaultQueues()
+	}
+	vpnDevice, err := vpn.NewDevice(tunDevice, interfaceName, localIP, netMask, localIPv6, ipv6Mask, queues)
 	if err != nil {
 		return fmt.Errorf("failed to init vpn: %v", err)
 	}
 	a.vpnDevice = vpnDevice
 	a.logger.Infof("Created vpn interface %s: %s", interfaceName, &net.IPNet{IP: localIP, Mask: netMask})
+	if localIPv6 != nil {
+		a.logger.Infof("IPv6 address: %s", &net.IPNet{IP: localIPv6, Mask: ipv6Mask})
+	}
 
 	err = a.P2p.Bootstrap()
 	if err != nil {
 		return err
 	}
 
-	a.Dns = NewDNSService(a.Conf, a.Eventbus, a.ctx, a.logger)
-	a.AuthStatus = service.NewAuthStatus(a.P2p, a.Conf, a.Eventbus)
-	a.Tunnel = service.NewTunnel(a.P2p, vpnDevice, a.Conf)
-
-	p2pHost.SetStreamHandler(protocol.GetStatusMethod, a.AuthStatus.StatusStreamHandler)
-	p2pHost.SetStreamHandler(protocol.AuthMethod, a.AuthStatus.AuthStreamHandler)
-	p2pHost.SetStreamHandler(protocol.TunnelPacketMethod, a.Tunnel.StreamHandler)
+	a.initDNS()
+	a.Clock = service.NewClock(a.Conf)
+	a.Power = service.NewPower(a.Conf, a.P2p)
+	a.AuthStatus = service.NewAuthStatus(a.P2p, a.Conf, a.Eventbus, a.Clock)
+	a.Shaper = service.NewShaper(a.Conf)
+	a.Tunnel = service.NewTunnel(a.P2p, vpnDevice, a.Conf, a.Shaper, a.Eventbus)
+	a.Routing = service.NewRouting(a.P2p, vpnDevice, a.Conf, a.Eventbus)
+	a.Echo = service.NewEcho(a.P2p, a.Conf)
+	a.Probe = service.NewProbe(a.P2p, a.Conf)
+	a.SpeedTest = service.NewSpeedTest(a.P2p, a.Probe, a.Storage)
+	a.Backup = service.NewBackup(a.P2p, a.Conf, a.Storage)
+	a.Scheduler = service.NewScheduler(a.P2p, a.Conf, a.Backup, a.SpeedTest)
+	a.Usage = service.NewUsage(a.P2p, a.Conf, a.Storage)
+	a.SharedFolder = service.NewSharedFolder(a.P2p, a.Conf)
+	logStore := logview.NewStore(a.LogBuffer, a.LogFile)
+	a.Latency = service.NewLatency(a.P2p, a.Conf, a.Probe)
+	a.Support = service.NewSupport(a.P2p, a.Conf, logStore, a.Clock, a.Latency)
+	if config.SOCKS5Included {
+		a.SOCKS5 = service.NewSOCKS5Proxy(a.P2p, a.Conf, a.Shaper)
+	}
+	a.Management = service.NewManagement(a.P2p, a.Conf)
+	a.PortScan = service.NewPortScan(a.P2p, a.Conf)
+	a.SharedGroups = service.NewSharedGroups(a.P2p, a.Conf)
+	if config.FileTransferIncluded {
+		a.FileTransfer = service.NewFileTransfer(a.P2p, a.Conf, a.Eventbus)
+	}
+	a.Messages = service.NewMessages(a.P2p, a.Conf, a.Storage, a.Eventbus)
+
+	a.Streams = service.NewStreamRegistry(p2pHost, a.Conf)
+	statusOptions := service.StreamHandlerOptions{Allow: a.Streams.AllowKnownOrBlockedPeers}
+	a.Streams.Handle(protocol.GetStatusMethod, a.AuthStatus.StatusStreamHandler, statusOptions)
+	a.Streams.Handle(protocol.LegacyGetStatusMethod, a.AuthStatus.StatusStreamHandler, statusOptions)
+	// auth requests come from unknown peers, unless the connection gater allows only known peers
+	authOptions := service.StreamHandlerOptions{Allow: a.allowAuthRequest}
+	a.Streams.Handle(protocol.AuthMethod, a.AuthStatus.AuthStreamHandler, authOptions)
+	a.Streams.Handle(protocol.LegacyAuthMethod, a.AuthStatus.AuthStreamHandler, authOptions)
+	a.Streams.Handle(protocol.TunnelPacketMethod, a.Tunnel.StreamHandler, service.StreamHandlerOptions{
+		Allow: a.Streams.AllowKnownPeers,
+	})
+	a.Streams.Handle(protocol.TunnelExitPacketMethod, a.Tunnel.ExitStreamHandler, service.StreamHandlerOptions{
+		Allow: a.Streams.AllowKnownPeers,
+	})
+	a.Streams.Handle(protocol.EchoMethod, a.Echo.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Echo.AllowPeer,
+		Timeout: service.EchoStreamTimeout,
+	})
+	// backups are restored by new peer id of the owner, which is unknown
+	a.Streams.Handle(protocol.BackupMethod, a.Backup.StreamHandler, service.StreamHandlerOptions{
+		Timeout: service.BackupStreamTimeout,
+	})
+	a.Streams.Handle(protocol.SharedFolderMethod, a.SharedFolder.StreamHandler, service.StreamHandlerOptions{
+		Allow: a.Streams.AllowKnownPeers,
+	})
+	a.Streams.Handle(protocol.SupportMethod, a.Support.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Streams.AllowKnownPeers,
+		Timeout: service.SupportStreamTimeout,
+	})
+	a.Streams.Handle(protocol.PortScanMethod, a.PortScan.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Streams.AllowKnownPeers,
+		Timeout: service.PortScanStreamTimeout,
+	})
+	a.Streams.Handle(protocol.GroupMembershipMethod, a.SharedGroups.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Streams.AllowKnownPeers,
+		Timeout: service.GroupMembershipStreamTimeout,
+	})
+	if config.SOCKS5Included {
+		a.Streams.Handle(protocol.ProxyMethod, a.SOCKS5.StreamHandler, service.StreamHandlerOptions{
+			Allow: a.SOCKS5.AllowPeer,
+		})
+	}
+	a.handleDNSStreams()
+	a.Streams.Handle(protocol.PingMethod, a.Latency.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Streams.AllowKnownPeers,
+		Timeout: service.PingStreamTimeout,
+	})
+	a.Streams.Handle(protocol.ProbeMethod, a.Probe.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Streams.AllowKnownPeers,
+		Timeout: service.ProbeStreamTimeout,
+	})
+	a.Streams.Handle(protocol.SpeedTestMethod, a.SpeedTest.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Streams.AllowKnownPeers,
+		Timeout: service.SpeedTestStreamTimeout,
+	})
+	a.Streams.Handle(protocol.ManagementMethod, a.Management.StreamHandler, service.StreamHandlerOptions{
+		Allow: a.Management.AllowPeer,
+	})
+	if config.FileTransferIncluded {
+		a.Streams.Handle(protocol.FileTransferMethod, a.FileTransfer.StreamHandler, service.StreamHandlerOptions{
+			Allow: a.Streams.AllowKnownPeers,
+		})
+	}
+	a.Streams.Handle(protocol.MessageMethod, a.Messages.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.Streams.AllowKnownPeers,
+		Timeout: service.MessageStreamTimeout,
+	})
 
 	awlevent.WrapSubscriptionToCallback(a.ctx, func(_ interface{}) {
 		a.Tunnel.RefreshPeersList()
+		a.Shaper.Refresh()
+		a.Routing.Refresh()
 	}, a.Eventbus, new(awlevent.KnownPeerChanged))
+	awlevent.WrapSubscriptionToCallback(a.ctx, func(_ interface{}) {
+		a.Routing.Refresh()
+	}, a.Eventbus, []interface{}{new(awlevent.PeerConnected), new(awlevent.PeerDisconnected), new(awlevent.VPNInterfaceStateChanged)})
+	awlevent.WrapSubscriptionToCallback(a.ctx, func(evt interface{}) {
+		go a.SharedGroups.Sync(a.ctx, evt.(awlevent.PeerConnected).PeerID)
+	}, a.Eventbus, new(awlevent.PeerConnected))
 
-	handler := api.NewHandler(a.Conf, a.P2p, a.AuthStatus, a.Tunnel, a.LogBuffer, a.Dns)
+	reachabilityEmitter, err := a.Eventbus.Emitter(new(awlevent.ReachabilityChanged), eventbus.Stateful)
+	if err != nil {
+		return fmt.Errorf("create reachability emitter: %v", err)
+	}
+	awlevent.WrapSubscriptionToCallback(a.ctx, func(evt interface{}) {
+		reachability := evt.(event.EvtLocalReachabilityChanged).Reachability
+		_ = reachabilityEmitter.Emit(awlevent.ReachabilityChanged{Reachability: reachability.String()})
+	}, p2pHost.EventBus(), new(event.EvtLocalReachabilityChanged))
+
+	// optional services are passed only if they are included, otherwise they are linked into the binary anyway
+	var fileTransfer api.FileTransfer
+	if config.FileTransferIncluded {
+		fileTransfer = a.FileTransfer
+	}
+	var socks5 api.SOCKS5Proxy
+	if config.SOCKS5Included {
+		socks5 = a.SOCKS5
+	}
+	handler := api.NewHandler(a.Conf, a.P2p, a.AuthStatus, a.Tunnel, a.Shaper, a.Routing, a.Probe, a.SpeedTest, a.Backup, a.Scheduler, a.Usage, a.SharedFolder, a.Support, a.Clock, a.Power, a.Latency, a.Management, a.PortScan, a.SharedGroups, fileTransfer, a.Messages, socks5, a.Streams, logStore, a.Dns, a.Eventbus)
 	a.Api = handler
 	err = handler.SetupAPI()
 	if err != nil {
@@ -131,16 +283,42 @@ func (a *Application) Init(ctx context.Context, tunDevice tun.Device) error {
 	}
 
 	go a.P2p.MaintainBackgroundConnections(a.ctx, a.Conf.P2pNode.ReconnectionIntervalSec*time.Second, a.Conf.KnownPeersIds)
+	go a.P2p.MaintainNATKeepalive(a.ctx, a.Conf.KnownPeersIds)
+	go a.P2p.MaintainDirectConnections(a.ctx, a.Conf.KnownPeersIds)
+	go a.P2p.MaintainLocalConnections(a.ctx, a.Conf.KnownPeersIds)
+	go a.P2p.MaintainConnTagWeights(a.ctx, a.connTagWeights, a.Conf.KnownPeersIds)
+	go a.P2p.MaintainRelaySelection(a.ctx)
+	go a.P2p.MaintainThroughput(a.ctx)
 	go a.AuthStatus.BackgroundRetryAuthRequests(a.ctx)
 	go a.AuthStatus.BackgroundExchangeStatusInfo(a.ctx)
-
-	if useAwldns {
-		interfaceName, err := a.vpnDevice.InterfaceName()
+	go a.AuthStatus.BackgroundRendezvous(a.ctx)
+	go a.Backup.BackgroundBackup(a.ctx)
+	go a.Scheduler.BackgroundRun(a.ctx)
+	go a.Usage.BackgroundCollect(a.ctx)
+	go a.Routing.BackgroundMaintain(a.ctx)
+	go a.Clock.BackgroundCheckNTP(a.ctx)
+	go a.Power.BackgroundMonitor(a.ctx)
+	go a.Latency.BackgroundMonitor(a.ctx)
+	go a.SharedGroups.BackgroundSync(a.ctx)
+
+	if config.SOCKS5Included {
+		err = a.SOCKS5.Restart()
 		if err != nil {
-			a.logger.Errorf("failed to get TUN interface name: %v", err)
-			return nil
+			a.logger.Errorf("failed to start socks5 server: %v", err)
 		}
-		a.Dns.initDNS(interfaceName)
+	}
+
+	if useAwldns {
+		a.startDNS()
+	}
+
+	a.Watchdog = NewWatchdog()
+	a.Conf.RLock()
+	watchdogDisabled := a.Conf.Watchdog.Disabled
+	a.Conf.RUnlock()
+	if !watchdogDisabled {
+		a.watchSubsystems()
+		go a.Watchdog.BackgroundRun(a.ctx)
 	}
 
 	return nil
@@ -153,23 +331,50 @@ func (a *Application) SetupLoggerAndConfig() *log.ZapEventLogger {
 	if loadConfigErr != nil {
 		conf = config.NewConfig(a.Eventbus)
 	}
+	var restored bool
+	var restoreErr error
+	// pending restore is encrypted with the key of config, it's unknown if config is locked
+	if !errors.Is(loadConfigErr, config.ErrConfigLocked) {
+		restored, restoreErr = backup.ApplyPendingRestore(conf)
+	}
+	if restored {
+		reloaded, err := conf.Reload(a.Eventbus)
+		if err != nil {
+			restoreErr = fmt.Errorf("reload config: %v", err)
+		} else {
+			conf, loadConfigErr = reloaded, nil
+		}
+	}
 
 	// Logger
 	a.LogBuffer = ringbuffer.New(logBufSize)
-	syncer := zapcore.NewMultiWriteSyncer(
+	syncers := []zapcore.WriteSyncer{
 		zapcore.Lock(zapcore.AddSync(os.Stdout)),
 		zapcore.AddSync(a.LogBuffer),
-	)
+	}
+	var logFileErr error
+	if conf.LogFile.Enabled {
+		a.LogFile, logFileErr = logview.OpenFile(conf.LogFilePath(), int64(conf.LogFile.MaxSizeMB)<<20)
+		if logFileErr == nil {
+			config.ChownFileIfNeeded(conf.LogFilePath())
+			syncers = append(syncers, a.LogFile)
+		}
+	}
+	syncer := zapcore.NewMultiWriteSyncer(syncers...)
 
 	encoderConfig := zap.NewDevelopmentEncoderConfig()
+	encoderConfig.CallerKey = zapcore.OmitKey
 	encoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 		enc.AppendString(t.Format("2006-01-02 15:04:05"))
 	}
 	consoleEncoder := zapcore.NewConsoleEncoder(encoderConfig)
 	zapCore := zapcore.NewCore(consoleEncoder, syncer, zapcore.InfoLevel)
+	// hot-path errors like tun read failures or stream resets should not evict everything else from log buffer
+	zapCore = logsampling.NewCore(zapCore, logSamplingWindow, logSamplingBurst)
 
 	lvl := conf.LogLevel()
-	opts := []zap.Option{zap.AddStacktrace(zapcore.ErrorLevel)}
+	// caller isn't written, it's used by logsampling to sample messages of the same call site
+	opts := []zap.Option{zap.AddStacktrace(zapcore.ErrorLevel), zap.AddCaller()}
 	if conf.DevMode() {
 		opts = append(opts, zap.Development())
 	}
@@ -191,9 +396,20 @@ func (a *Application) SetupLoggerAndConfig() *log.ZapEventLogger {
 	a.logger = log.Logger("awl")
 	a.Conf = conf
 
-	if loadConfigErr != nil {
+	if errors.Is(loadConfigErr, config.ErrConfigLocked) {
+		// new config would overwrite the encrypted identity
+		a.logger.Fatalf("failed to read config file: %v", loadConfigErr)
+	} else if loadConfigErr != nil {
 		a.logger.Warnf("failed to read config file, creating new one: %v", loadConfigErr)
 	}
+	if logFileErr != nil {
+		a.logger.Errorf("failed to open log file: %v", logFileErr)
+	}
+	if restoreErr != nil {
+		a.logger.Errorf("failed to restore from backup: %v", restoreErr)
+	} else if restored {
+		a.logger.Infof("config and storage restored from backup")
+	}
 	a.logger.Infof("Anywherelan %s (%s %s-%s)", config.Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 	a.logger.Infof("Initializing app in %s directory", conf.DataDir())
 
@@ -204,66 +420,115 @@ func (a *Application) Ctx() context.Context {
 	return a.ctx
 }
 
-func (a *Application) Close() {
-	if a.ctxCancel != nil {
-		a.ctxCancel()
+// renumberVPNNetwork moves the vpn network to a free one if it overlaps a local network,
+// overlaps which appear later are reported by service.Routing.
+func (a *Application) renumberVPNNetwork(interfaceName string) {
+	localNetworks, err := vpn.LocalNetworks(interfaceName)
+	if err != nil {
+		a.logger.Warnf("get local networks: %v", err)
+		return
 	}
-	if a.Api != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-		err := a.Api.Shutdown(ctx)
-		if err != nil {
-			a.logger.Errorf("closing api server: %v", err)
-		}
+	localIP, netMask := a.Conf.VPNLocalIPMask()
+	if localIP == nil {
+		return
 	}
-	if a.P2p != nil {
-		err := a.P2p.Close()
-		if err != nil {
-			a.logger.Errorf("closing p2p server: %v", err)
+	vpnNet := &net.IPNet{IP: localIP.Mask(netMask), Mask: netMask}
+	taken := make([]*net.IPNet, 0, len(localNetworks))
+	var conflict *vpn.LocalNetwork
+	for i, local := range localNetworks {
+		taken = append(taken, local.Network)
+		if conflict == nil && vpn.NetworksOverlap(vpnNet, local.Network) {
+			conflict = &localNetworks[i]
 		}
 	}
-	if a.Dns != nil {
-		a.Dns.Close()
+	if conflict == nil {
+		return
 	}
-	if a.Tunnel != nil {
-		a.Tunnel.Close()
+
+	a.Conf.Lock()
+	newNet := a.Conf.FreeVPNNetwork(taken)
+	if newNet != nil {
+		a.Conf.RenumberVPNNetwork(newNet)
 	}
-	if a.vpnDevice != nil {
-		err := a.vpnDevice.Close()
-		if err != nil {
-			a.logger.Errorf("closing vpn: %v", err)
-		}
+	a.Conf.Unlock()
+	if newNet == nil {
+		a.logger.Warnf("vpn network %s overlaps local network %s of %s, there is no free network to move to",
+			vpnNet, conflict.Network, conflict.Interface)
+		return
 	}
 	a.Conf.Save()
+	a.logger.Warnf("vpn network %s overlaps local network %s of %s, moved to %s",
+		vpnNet, conflict.Network, conflict.Interface, newNet)
+}
+
+// allowAuthRequest checks auth requests like inbound connections, since bootstrap peers and relays could send them
+// over connections which we initiated.
+func (a *Application) allowAuthRequest(peerID peer.ID) bool {
+	if !a.Conf.AllowsConnection(peerID.String(), true, false) {
+		return false
+	}
+	_, known := a.Conf.GetPeer(peerID.String())
+	return known || a.P2p.AllowUnknownAuthRequest(peerID)
 }
 
-func (a *Application) makeP2pHostConfig() p2p.HostConfig {
-	// TODO: use persistent datastore. Check out badger2. Old badger datastore constantly use disk io
+// allowConnection applies the connection gater config and limits inbound connections of unknown peers,
+// see p2p.P2p.AllowUnknownConnection.
+func (a *Application) allowConnection(peerID peer.ID, remoteAddr multiaddr.Multiaddr, inbound, infrastructure bool) bool {
+	if !a.Conf.AllowsConnection(peerID.String(), inbound, infrastructure) {
+		return false
+	}
+	if !inbound || infrastructure {
+		return true
+	}
+	_, known := a.Conf.GetPeer(peerID.String())
+	return known || a.P2p.AllowUnknownConnection(peerID, remoteAddr)
+}
+
+func (a *Application) makeP2pHostConfig() (p2p.HostConfig, error) {
 	peerstore, err := pstoremem.NewPeerstore()
 	if err != nil {
 		panic(err)
 	}
 
 	resourceLimitsConfig := rcmgr.InfiniteLimits
-	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(resourceLimitsConfig))
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(resourceLimitsConfig), rcmgr.WithTraceReporter(a.P2p.StreamTraceReporter()))
 	if err != nil {
 		panic(err)
 	}
 
+	httpsRelayServer, err := a.makeHTTPSRelayServerConfig()
+	if err != nil {
+		return p2p.HostConfig{}, fmt.Errorf("https relay server: %v", err)
+	}
+	bootstrapPeers := a.Conf.GetBootstrapPeers()
+	fallbackRelays := a.Conf.GetFallbackRelays()
+	holePunchEmitter, err := a.Eventbus.Emitter(new(awlevent.HolePunchFinished))
+	if err != nil {
+		return p2p.HostConfig{}, fmt.Errorf("create hole punch emitter: %v", err)
+	}
+
 	return p2p.HostConfig{
-		PrivKeyBytes:   a.Conf.PrivKey(),
-		ListenAddrs:    a.Conf.GetListenAddresses(),
-		UserAgent:      config.UserAgent,
-		BootstrapPeers: a.Conf.GetBootstrapPeers(),
+		PrivKeyBytes:     a.Conf.PrivKey(),
+		ListenAddrs:      a.Conf.GetListenAddresses(),
+		UserAgent:        config.UserAgent,
+		BootstrapPeers:   bootstrapPeers,
+		FallbackRelays:   fallbackRelays,
+		HTTPSRelayServer: httpsRelayServer,
+		Transports: p2p.TransportsConfig{
+			WebSocketListenAddrs: a.Conf.GetWebSocketListenAddresses(),
+			WebTransport:         a.Conf.P2pNode.Transports.WebTransport,
+		},
 		Libp2pOpts: []libp2p.Option{
 			libp2p.EnableRelay(),
-			libp2p.EnableAutoRelayWithStaticRelays(
-				a.Conf.GetBootstrapPeers(),
+			libp2p.EnableAutoRelayWithPeerSource(
+				a.P2p.RelayPeerSource,
+				autorelay.WithMaxCandidates(len(bootstrapPeers)+len(fallbackRelays)),
+				// candidates are sorted by latency, see RelayPeerSource
+				autorelay.WithMinCandidates(min(len(bootstrapPeers), p2p.DesiredRelays)),
 				autorelay.WithNumRelays(p2p.DesiredRelays),
 				autorelay.WithBootDelay(p2p.RelayBootDelay),
 			),
 			libp2p.ResourceManager(mgr),
-			libp2p.EnableHolePunching(),
 			libp2p.NATPortMap(),
 		},
 		ConnManager: struct {
@@ -276,111 +541,88 @@ func (a *Application) makeP2pHostConfig() p2p.HostConfig {
 			GracePeriod: time.Minute,
 		},
 		Peerstore:    peerstore,
-		DHTDatastore: dssync.MutexWrap(ds.NewMapDatastore()),
-	}
-}
-
-type DNSService struct {
-	conf     *config.Config
-	eventbus awlevent.Bus
-	ctx      context.Context
-	logger   *log.ZapEventLogger
-
-	dnsOsConfigurator   dns.OSConfigurator
-	dnsResolver         *awldns.Resolver
-	upstreamDNS         string
-	isAwlDNSSetAsSystem bool
-}
-
-func NewDNSService(conf *config.Config, eventbus awlevent.Bus, ctx context.Context, logger *log.ZapEventLogger) *DNSService {
-	return &DNSService{conf: conf, eventbus: eventbus, ctx: ctx, logger: logger}
+		DHTDatastore: storage.Namespace(a.Storage, "dht"),
+		PeerFilter:   a.allowConnection,
+		TrafficCategories: map[libp2pProtocol.ID]string{
+			protocol.TunnelPacketMethod:     p2p.TrafficVPN,
+			protocol.TunnelExitPacketMethod: p2p.TrafficVPN,
+			// SOCKS5 proxy connections are the only forwarded traffic
+			protocol.ProxyMethod:        p2p.TrafficForwarding,
+			protocol.SharedFolderMethod: p2p.TrafficServices,
+			protocol.BackupMethod:       p2p.TrafficServices,
+			protocol.SupportMethod:      p2p.TrafficServices,
+			protocol.ManagementMethod:   p2p.TrafficServices,
+			protocol.PortScanMethod:     p2p.TrafficServices,
+			protocol.FileTransferMethod: p2p.TrafficServices,
+			protocol.MessageMethod:      p2p.TrafficServices,
+		},
+		OnHolePunch: func(result p2p.HolePunchResult) {
+			if _, known := a.Conf.GetPeer(result.PeerID.String()); !known {
+				return
+			}
+			_ = holePunchEmitter.Emit(awlevent.HolePunchFinished{
+				PeerID:  result.PeerID.String(),
+				Success: result.Success,
+				Error:   result.Error,
+			})
+		},
+		VPNInterface: a.vpnInterface,
+	}, nil
 }
 
-func (a *DNSService) initDNS(interfaceName string) {
-	var err error
-	a.dnsResolver = awldns.NewResolver(awldns.DNSAddress)
-	a.upstreamDNS = awldns.DefaultUpstreamDNSAddress
-	a.refreshDNSConfig()
-
-	awlevent.WrapSubscriptionToCallback(a.ctx, func(_ interface{}) {
-		a.refreshDNSConfig()
-	}, a.eventbus, new(awlevent.KnownPeerChanged))
-	defer a.refreshDNSConfig()
-
-	tsLogger := log.Logger("ts/dnsconf")
-	a.dnsOsConfigurator, err = dns.NewOSConfigurator(func(format string, args ...interface{}) {
-		tsLogger.Infof(format, args...)
-	}, interfaceName)
-	if err != nil {
-		a.logger.Errorf("create dns os configurator: %v", err)
-		return
-	}
-
-	fqdn, err := dnsname.ToFQDN(awldns.LocalDomain)
-	if err != nil {
-		panic(err)
-	}
-	newOSConfig := dns.OSConfig{
-		Nameservers:  []netip.Addr{netip.MustParseAddr(awldns.DNSIp)},
-		MatchDomains: []dnsname.FQDN{fqdn},
-	}
-
-	if !a.dnsOsConfigurator.SupportsSplitDNS() {
-		newOSConfig.MatchDomains = nil
-		baseOSConfig, err := a.dnsOsConfigurator.GetBaseConfig()
-		if err != nil {
-			a.logger.Errorf("get base config from os configurator, abort setting os dns: %v", err)
-			return
-		}
+// vpnInterface returns name of the vpn interface and our vpn networks, the interface is created after the host.
+func (a *Application) vpnInterface() (string, []*net.IPNet) {
+	a.Conf.RLock()
+	name := a.Conf.VPNConfig.InterfaceName
+	localIP, netMask := a.Conf.VPNLocalIPMask()
+	localIPv6, ipv6Mask := a.Conf.VPNLocalIPv6Mask()
+	a.Conf.RUnlock()
 
-		a.logger.Infof("os does not support split dns. base config: %v", baseOSConfig)
-		if len(baseOSConfig.Nameservers) == 0 {
-			a.logger.Errorf("got zero nameservers from os configurator, use %s as default", awldns.DefaultUpstreamDNSAddress)
-			a.upstreamDNS = awldns.DefaultUpstreamDNSAddress
-		} else {
-			// TODO: use all nameservers in awldns resolver proxy
-			a.upstreamDNS = net.JoinHostPort(baseOSConfig.Nameservers[0].String(), awldns.DefaultDNSPort)
+	if a.vpnDevice != nil {
+		if deviceName, err := a.vpnDevice.InterfaceName(); err == nil {
+			name = deviceName
 		}
 	}
-
-	err = a.dnsOsConfigurator.SetDNS(newOSConfig)
-	if err != nil {
-		a.logger.Errorf("set dns config to os configurator: %v", err)
-	} else {
-		a.logger.Info("successfully set dns config to os")
-		a.isAwlDNSSetAsSystem = true
+	var nets []*net.IPNet
+	if localIP != nil {
+		nets = append(nets, &net.IPNet{IP: localIP.Mask(netMask), Mask: netMask})
+	}
+	if localIPv6 != nil {
+		nets = append(nets, &net.IPNet{IP: localIPv6.Mask(ipv6Mask), Mask: ipv6Mask})
 	}
+	return name, nets
 }
 
-func (a *DNSService) refreshDNSConfig() {
-	if a.dnsResolver == nil {
-		a.logger.DPanicf("called refreshDNSConfig with nil resolver %v", a.dnsResolver)
-		return
+func (a *Application) connTagWeights() p2p.ConnTagWeights {
+	a.Conf.RLock()
+	defer a.Conf.RUnlock()
+	weights := a.Conf.P2pNode.ConnManagerWeights
+	return p2p.ConnTagWeights{
+		ActiveFriend:      weights.ActiveFriend,
+		IdleFriend:        weights.IdleFriend,
+		ActiveTrafficRate: float64(weights.ActiveTrafficRate),
 	}
-	dnsNamesMapping := a.conf.DNSNamesMapping()
-	dnsNamesMapping[config.AdminHttpServerDomainName] = config.AdminHttpServerIP
-	a.dnsResolver.ReceiveConfiguration(a.upstreamDNS, dnsNamesMapping)
 }
 
-func (a *DNSService) Close() {
-	if a.dnsOsConfigurator != nil {
-		err := a.dnsOsConfigurator.Close()
-		if err != nil {
-			a.logger.Errorf("closing dns configurator: %v", err)
-		}
+func (a *Application) makeHTTPSRelayServerConfig() (*p2p.HTTPSRelayServerConfig, error) {
+	a.Conf.RLock()
+	relayConf := a.Conf.P2pNode.HTTPSRelay
+	a.Conf.RUnlock()
+	if !relayConf.ServerEnabled {
+		return nil, nil
 	}
-	if a.dnsResolver != nil {
-		a.dnsResolver.Close()
-	}
-}
 
-func (a *DNSService) AwlDNSAddress() string {
-	if a.dnsResolver != nil {
-		return a.dnsResolver.DNSAddress()
+	listenAddr, err := multiaddr.NewMultiaddr(relayConf.ServerListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("parse listen address: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(relayConf.CertFile, relayConf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %v", err)
 	}
-	return ""
-}
 
-func (a *DNSService) IsAwlDNSSetAsSystem() bool {
-	return a.isAwlDNSSetAsSystem
+	return &p2p.HTTPSRelayServerConfig{
+		ListenAddr: listenAddr,
+		TLSConfig:  &tls.Config{Certificates: []tls.Certificate{cert}},
+	}, nil
 }
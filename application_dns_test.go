@@ -0,0 +1,77 @@
+//go:build !awl_nodns
+
+package awl
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anywherelan/awl/entity"
+	"github.com/miekg/dns"
+)
+
+func TestDNSUpstreamPeer(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	// dns server of upstream peer, e.g. Pi-hole, answers names of its network
+	dnsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	ts.NoError(err)
+	dnsServer := &dns.Server{Listener: dnsListener, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 5),
+		})
+		_ = w.WriteMsg(m)
+	})}
+	go func() {
+		_ = dnsServer.ActivateAndServe()
+	}()
+	defer dnsServer.Shutdown()
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+	query := new(dns.Msg).SetQuestion("intranet.example.com.", dns.TypeA)
+
+	// peer2 doesn't allow it yet
+	err = peer1.api.UpdateDNSUpstream(peer2.PeerID(), "")
+	ts.Error(err)
+	_, err = peer1.app.DNSForwarder.Exchange(context.Background(), peer2.app.P2p.PeerID(), query)
+	ts.Error(err)
+
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer1.PeerID(),
+		Alias:                peer1Config.Alias,
+		DomainName:           peer1Config.DomainName,
+		AllowUsingAsExitNode: true,
+	})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+		return peer2Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	err = peer2.api.UpdateDNSUpstream("", "bad address")
+	ts.Error(err)
+	err = peer2.api.UpdateDNSUpstream("", dnsListener.Addr().String())
+	ts.NoError(err)
+	err = peer1.api.UpdateDNSUpstream(peer2.PeerID(), "")
+	ts.NoError(err)
+	info, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(peer2.PeerID(), info.DNSUpstreamPeerID)
+	ts.Empty(info.DNSUpstreamAddress)
+
+	resp, err := peer1.app.DNSForwarder.Exchange(context.Background(), peer2.app.P2p.PeerID(), query)
+	ts.NoError(err)
+	ts.Equal(query.Id, resp.Id)
+	ts.Len(resp.Answer, 1)
+	ts.Equal("10.0.0.5", resp.Answer[0].(*dns.A).A.String())
+}
@@ -3,12 +3,29 @@ package awl
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -16,9 +33,14 @@ import (
 
 	"github.com/anywherelan/awl/api"
 	"github.com/anywherelan/awl/api/apiclient"
+	"github.com/anywherelan/awl/api/apipb"
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/backup"
 	"github.com/anywherelan/awl/config"
 	"github.com/anywherelan/awl/entity"
 	"github.com/anywherelan/awl/p2p"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/service"
 	"github.com/anywherelan/awl/vpn"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
@@ -26,6 +48,7 @@ import (
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/peer"
+	libp2pProtocol "github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
@@ -33,7 +56,16 @@ import (
 	"github.com/quic-go/quic-go/integrationtests/tools/israce"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/ipv4"
 	"golang.zx2c4.com/wireguard/tun"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func init() {
@@ -79,7 +111,7 @@ func TestRemovePeer(t *testing.T) {
 	ts.Len(peer2.app.AuthStatus.GetIngoingAuthRequests(), 0)
 
 	// Add peer2 from peer1 - should succeed
-	err = peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2")
+	err = peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "")
 	ts.NoError(err)
 	time.Sleep(500 * time.Millisecond)
 
@@ -108,7 +140,7 @@ func TestDeclinePeerFriendRequest(t *testing.T) {
 	peer2 := ts.newTestPeer(false)
 	ts.ensurePeersAvailableInDHT(peer1, peer2)
 
-	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2")
+	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "")
 	ts.NoError(err)
 
 	var authRequests []entity.AuthRequest
@@ -117,7 +149,7 @@ func TestDeclinePeerFriendRequest(t *testing.T) {
 		ts.NoError(err)
 		return len(authRequests) == 1
 	}, 15*time.Second, 50*time.Millisecond)
-	err = peer2.api.ReplyFriendRequest(authRequests[0].PeerID, "peer_1", true)
+	err = peer2.api.ReplyFriendRequest(authRequests[0].PeerID, "peer_1", "", true)
 	ts.NoError(err)
 
 	time.Sleep(500 * time.Millisecond)
@@ -142,7 +174,7 @@ func TestAutoAcceptFriendRequest(t *testing.T) {
 	peer2.app.Conf.P2pNode.AutoAcceptAuthRequests = true
 	peer2.app.Conf.Unlock()
 
-	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2")
+	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "")
 	ts.NoError(err)
 
 	ts.Eventually(func() bool {
@@ -163,25 +195,2394 @@ func TestAutoAcceptFriendRequest(t *testing.T) {
 	ts.False(knownPeer.Declined)
 }
 
+func TestEventsStream(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := peer2.api.Events(ctx, "ReceivedAuthRequest", "KnownPeerChanged")
+	ts.NoError(err)
+
+	ts.makeFriends(peer1, peer2)
+
+	var types []string
+	timeout := time.After(5 * time.Second)
+	for len(types) < 2 {
+		select {
+		case evt, ok := <-events:
+			ts.True(ok)
+			types = append(types, evt.Type)
+			if evt.Type == "ReceivedAuthRequest" {
+				authRequest := awlevent.ReceivedAuthRequest{}
+				ts.NoError(json.Unmarshal(evt.Data, &authRequest))
+				ts.Equal(peer1.PeerID(), authRequest.PeerID)
+			}
+		case <-timeout:
+			ts.FailNow("events are not received", "received: %v", types)
+		}
+	}
+	ts.Equal("ReceivedAuthRequest", types[0])
+	ts.Equal("KnownPeerChanged", types[1])
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		ts.False(ok)
+	case <-time.After(5 * time.Second):
+		ts.Fail("events channel is not closed")
+	}
+}
+
 func TestUniquePeerAlias(t *testing.T) {
 	ts := NewTestSuite(t)
 
 	peer1 := ts.newTestPeer(false)
 	peer2 := ts.newTestPeer(false)
-	peer3 := ts.newTestPeer(false)
-	ts.ensurePeersAvailableInDHT(peer1, peer2)
-	ts.ensurePeersAvailableInDHT(peer2, peer3)
+	peer3 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+	ts.ensurePeersAvailableInDHT(peer2, peer3)
+
+	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer", "")
+	ts.NoError(err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	err = peer1.api.SendFriendRequest(peer3.PeerID(), "peer", "")
+	ts.EqualError(err, api.ErrorPeerAliasIsNotUniq)
+}
+
+func TestUpdateUseAsExitNodeConfig(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+
+	ts.makeFriends(peer2, peer1)
+
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	ts.Equal(false, peer1Config.AllowedUsingAsExitNode)
+
+	// allow, check that peer1 got our config
+	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer1.PeerID(),
+		Alias:                peer1Config.Alias,
+		DomainName:           peer1Config.DomainName,
+		AllowUsingAsExitNode: true,
+	})
+	ts.NoError(err)
+
+	var peer2Config *config.KnownPeer
+	ts.Eventually(func() bool {
+		peer2Config, err = peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+
+		return peer2Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	// allow from peer1, check that peer2 got our config
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer2.PeerID(),
+		Alias:                peer2Config.Alias,
+		DomainName:           peer2Config.DomainName,
+		AllowUsingAsExitNode: true,
+	})
+	ts.NoError(err)
+
+	ts.Eventually(func() bool {
+		peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+		ts.NoError(err)
+
+		return peer1Config.AllowedUsingAsExitNode && peer1Config.WeAllowUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	// disallow from peer2, check that peer1 got our new config
+	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer1.PeerID(),
+		Alias:                peer1Config.Alias,
+		DomainName:           peer1Config.DomainName,
+		AllowUsingAsExitNode: false,
+	})
+	ts.NoError(err)
+
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+
+		return !peer2Config.AllowedUsingAsExitNode && peer2Config.WeAllowUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+}
+
+func TestPeerGroups(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+
+	ts.makeFriends(peer2, peer1)
+
+	// group policy allows peer1 to use peer2 as exit node
+	err := peer2.api.UpdatePeerGroup(entity.UpdatePeerGroupRequest{Name: "Work", AllowUsingAsExitNode: true})
+	ts.NoError(err)
+	err = peer2.api.UpdatePeerGroups(peer1.PeerID(), []string{"work", "Home"})
+	ts.NoError(err)
+	err = peer2.api.UpdatePeerGroups(peer1.PeerID(), []string{"bad name"})
+	ts.Error(err)
+
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+
+		return peer2Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	peers, err := peer2.api.KnownPeersInGroup("work")
+	ts.NoError(err)
+	ts.Len(peers, 1)
+	ts.Equal(peer1.PeerID(), peers[0].PeerID)
+	ts.Equal([]string{"home", "work"}, peers[0].Groups)
+	peers, err = peer2.api.KnownPeersInGroup("lab")
+	ts.NoError(err)
+	ts.Len(peers, 0)
+
+	groups, err := peer2.api.PeerGroups()
+	ts.NoError(err)
+	ts.Len(groups, 2)
+	ts.Equal("home", groups[0].Name)
+	ts.False(groups[0].HasPolicy)
+	ts.Equal("work", groups[1].Name)
+	ts.True(groups[1].HasPolicy)
+	ts.Equal([]string{peer1.PeerID()}, groups[1].Members)
+
+	// removing the group takes the permission back
+	err = peer2.api.RemovePeerGroup("work")
+	ts.NoError(err)
+
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+
+		return !peer2Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	ts.Equal([]string{"home"}, peer1Config.Groups)
+}
+
+func TestSharedPeerGroups(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	owner := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	peer3 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, owner)
+	// makeFriends uses the same aliases, which are unique per peer
+	for _, friend := range []testPeer{owner, peer2} {
+		ts.ensurePeersAvailableInDHT(peer3, friend)
+		err := peer3.api.SendFriendRequest(friend.PeerID(), "friend_"+friend.PeerID()[len(friend.PeerID())-6:], "")
+		ts.NoError(err)
+		ts.Eventually(func() bool {
+			authRequests, err := friend.api.AuthRequests()
+			ts.NoError(err)
+			return len(authRequests) == 1
+		}, 15*time.Second, 50*time.Millisecond)
+		err = friend.api.ReplyFriendRequest(peer3.PeerID(), "peer_3", "", false)
+		ts.NoError(err)
+	}
+
+	err := owner.api.UpdatePeerGroup(entity.UpdatePeerGroupRequest{Name: "lab", Shared: true})
+	ts.NoError(err)
+	err = owner.api.UpdatePeerGroups(peer2.PeerID(), []string{"lab"})
+	ts.NoError(err)
+	err = owner.api.UpdatePeerGroups(peer3.PeerID(), []string{"lab"})
+	ts.NoError(err)
+
+	err = peer2.api.UpdatePeerGroup(entity.UpdatePeerGroupRequest{Name: "lab", Shared: true, Owner: owner.PeerID()})
+	ts.ErrorContains(err, "can't be shared")
+	err = peer2.api.UpdatePeerGroup(entity.UpdatePeerGroupRequest{Name: "lab", Owner: owner.PeerID()})
+	ts.NoError(err)
+
+	// members are synced from the owner, including the owner itself
+	ts.Eventually(func() bool {
+		groups, err := peer2.api.PeerGroups()
+		ts.NoError(err)
+		return len(groups) == 1 && len(groups[0].Members) == 2
+	}, 15*time.Second, 100*time.Millisecond)
+	groups, err := peer2.api.PeerGroups()
+	ts.NoError(err)
+	ts.Equal(owner.PeerID(), groups[0].Owner)
+	ts.NotNil(groups[0].MembershipTime)
+	ts.ElementsMatch([]string{owner.PeerID(), peer3.PeerID()}, groups[0].Members)
+
+	err = peer2.api.UpdatePeerGroups(peer3.PeerID(), nil)
+	ts.ErrorContains(err, "managed by its owner")
+
+	// membership isn't shared with peers outside the group
+	err = owner.api.UpdatePeerGroups(peer3.PeerID(), nil)
+	ts.NoError(err)
+	err = peer3.api.UpdatePeerGroup(entity.UpdatePeerGroupRequest{Name: "lab", Owner: owner.PeerID()})
+	ts.NoError(err)
+	err = peer3.app.SharedGroups.SyncGroup(context.Background(), "lab", owner.app.P2p.PeerID())
+	ts.ErrorContains(err, "not shared with you")
+
+	err = peer2.app.SharedGroups.SyncGroup(context.Background(), "lab", owner.app.P2p.PeerID())
+	ts.NoError(err)
+	peer3Config, err := peer2.api.KnownPeerConfig(peer3.PeerID())
+	ts.NoError(err)
+	ts.Empty(peer3Config.Groups)
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+
+	ts.makeFriends(peer2, peer1)
+
+	peerLimit := config.BandwidthLimitConfig{UploadKbps: 8000, DownloadKbps: 16000}
+	err := peer1.api.UpdatePeerBandwidthLimit(peer2.PeerID(), peerLimit)
+	ts.NoError(err)
+	err = peer1.api.UpdatePeerBandwidthLimit(peer2.PeerID(), config.BandwidthLimitConfig{UploadKbps: -1})
+	ts.Error(err)
+	globalLimit := config.BandwidthLimitConfig{DownloadKbps: 100000}
+	err = peer1.api.UpdateBandwidthLimit(globalLimit)
+	ts.NoError(err)
+
+	response, err := peer1.api.BandwidthLimit()
+	ts.NoError(err)
+	ts.Equal(globalLimit, response.Global)
+	ts.Len(response.Peers, 1)
+	ts.Equal(peer2.PeerID(), response.Peers[0].PeerID)
+	ts.Equal(peerLimit, response.Peers[0].Limit)
+
+	err = peer1.api.UpdatePeerBandwidthLimit(peer2.PeerID(), config.BandwidthLimitConfig{})
+	ts.NoError(err)
+	response, err = peer1.api.BandwidthLimit()
+	ts.NoError(err)
+	ts.Empty(response.Peers)
+}
+
+func TestPowerMode(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+
+	profile, err := peer1.api.PowerProfile()
+	ts.NoError(err)
+	ts.Equal(config.PowerModeAuto, profile.Mode)
+	_, err = peer1.api.UpdatePowerMode("eco")
+	ts.Error(err)
+
+	profile, err = peer1.api.UpdatePowerMode(config.PowerModeBattery)
+	ts.NoError(err)
+	ts.Equal(config.PowerModeBattery, profile.Mode)
+	ts.True(profile.PowerSave)
+	ts.True(peer1.app.P2p.PowerSave())
+	ts.Equal(time.Minute, peer1.app.P2p.PowerSaveInterval(15*time.Second))
+
+	profile, err = peer1.api.UpdatePowerMode(config.PowerModeAC)
+	ts.NoError(err)
+	ts.False(profile.PowerSave)
+	ts.False(peer1.app.P2p.PowerSave())
+	ts.Equal(config.PowerModeAC, peer1.app.Conf.Power.Mode)
+}
+
+func TestQoS(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+
+	err := peer1.api.UpdateQoS(config.QoSConfig{Rules: []config.QoSRule{{Ports: "22", Priority: "urgent"}}})
+	ts.Error(err)
+
+	qos := config.QoSConfig{Rules: []config.QoSRule{{Protocol: config.ACLProtocolTCP, Ports: "8000-8100", Priority: "low"}}}
+	err = peer1.api.UpdateQoS(qos)
+	ts.NoError(err)
+	response, err := peer1.api.QoS()
+	ts.NoError(err)
+	ts.Equal(qos, response.QoS)
+	ts.Len(response.Classes, 3)
+	ts.Equal("high", response.Classes[0].Priority)
+	ts.Equal("low", response.Classes[2].Priority)
+	ts.Equal(qos, peer1.app.Conf.VPNConfig.QoS)
+}
+
+func TestIPAllocation(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+
+	err := peer1.api.UpdateIPAllocation("random")
+	ts.Error(err)
+	err = peer1.api.UpdateIPAllocation(config.IPAllocationManual)
+	ts.NoError(err)
+	err = peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "")
+	ts.Error(err)
+	err = peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "10.66.0.1")
+	ts.Error(err)
+	err = peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "10.66.0.42")
+	ts.NoError(err)
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.Equal("10.66.0.42", knownPeer.IPAddr)
+	ts.Eventually(func() bool {
+		return len(peer2.app.AuthStatus.GetIngoingAuthRequests()) == 1
+	}, 15*time.Second, 50*time.Millisecond)
+	err = peer2.api.ReplyFriendRequest(peer1.PeerID(), "peer_1", "", false)
+	ts.NoError(err)
+
+	// the peer has its own address in its network, it's used by negotiation
+	err = peer1.api.UpdateIPAllocation(config.IPAllocationNegotiate)
+	ts.NoError(err)
+	peer2.app.Conf.Lock()
+	peer2.app.Conf.VPNConfig.IPNet = "10.66.0.9/24"
+	peer2.app.Conf.Unlock()
+	err = peer1.app.AuthStatus.ExchangeNewStatusInfo(context.Background(), peer2.app.P2p.PeerID(), knownPeer)
+	ts.NoError(err)
+	knownPeer, _ = peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.Equal("10.66.0.9", knownPeer.IPAddr)
+}
+
+func TestRenameDevice(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	err := peer2.api.UpdateMySettings(" laptop ")
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+		return knownPeer.Name == "laptop"
+	}, 15*time.Second, 50*time.Millisecond)
+	// alias is set by the user
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.Equal("peer_1", knownPeer.Alias)
+
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{PeerID: peer2.PeerID(), Alias: "laptop", DomainName: "laptop"})
+	ts.NoError(err)
+	err = peer2.api.UpdateMySettings("desktop")
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		knownPeer, _ = peer1.app.Conf.GetPeer(peer2.PeerID())
+		return knownPeer.Name == "desktop"
+	}, 15*time.Second, 50*time.Millisecond)
+	ts.Equal("desktop", knownPeer.Alias)
+	ts.Equal("desktop", knownPeer.DomainName)
+	ts.Equal(knownPeer.IPAddr, peer1.app.Conf.DNSNamesMapping()["desktop"])
+}
+
+func TestImportPeers(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+
+	data := "name,ip,peer_id\n" +
+		"server,100.64.0.7," + peer2.PeerID() + "\n" +
+		"laptop,100.64.0.8,\n"
+	response, err := peer1.api.ImportPeers(entity.ImportPeersRequest{Format: "csv", Data: data, DryRun: true})
+	ts.NoError(err)
+	ts.Len(response.Peers, 2)
+	ts.Equal(entity.ImportStatusInvited, response.Peers[0].Status)
+	ts.Equal("10.66.0.7", response.Peers[0].SuggestedIP)
+	ts.Equal(entity.ImportStatusNeedsPeerID, response.Peers[1].Status)
+	ts.Len(peer1.app.Conf.KnownPeersIds(), 0)
+
+	response, err = peer1.api.ImportPeers(entity.ImportPeersRequest{Format: "csv", Data: data})
+	ts.NoError(err)
+	ts.Equal(entity.ImportStatusInvited, response.Peers[0].Status)
+	knownPeer, exists := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.True(exists)
+	ts.Equal("server", knownPeer.Alias)
+	ts.Equal("10.66.0.7", knownPeer.IPAddr)
+
+	ts.Eventually(func() bool {
+		authRequests, err := peer2.api.AuthRequests()
+		ts.NoError(err)
+		return len(authRequests) == 1
+	}, 15*time.Second, 50*time.Millisecond)
+}
+
+func TestEchoPeer(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+
+	_, err := peer1.api.EchoPeer(peer2.PeerID(), 1024)
+	ts.Error(err)
+
+	peer2.app.Conf.Lock()
+	peer2.app.Conf.P2pNode.EchoPeerMode = true
+	peer2.app.Conf.Unlock()
+	response, err := peer1.api.EchoPeer(peer2.PeerID(), 64*1024)
+	ts.NoError(err)
+	ts.Equal(64*1024, response.Bytes)
+
+	stats, err := peer2.api.StreamHandlersStats()
+	ts.NoError(err)
+	var echoStats service.StreamHandlerStats
+	for _, handlerStats := range stats {
+		if handlerStats.Protocol == protocol.EchoMethod {
+			echoStats = handlerStats
+		}
+	}
+	ts.EqualValues(1, echoStats.Rejected)
+	ts.EqualValues(1, echoStats.Accepted)
+	ts.Zero(echoStats.Panics)
+
+	err = peer1.api.SendFriendRequest(peer2.PeerID(), "echo", "")
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		knownPeer, exists := peer1.app.Conf.GetPeer(peer2.PeerID())
+		return exists && knownPeer.Confirmed
+	}, 15*time.Second, 50*time.Millisecond)
+}
+
+func TestProbe(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	response, err := peer1.api.EchoPeer(peer2.PeerID(), 256*1024)
+	ts.NoError(err)
+	ts.Equal(256*1024, response.Bytes)
+	// known peers answer with their clock, peers run on the same clock
+	ts.True(response.ClockOffsetKnown)
+	ts.Less(response.ClockOffset.Abs(), response.RTT)
+
+	result, err := peer1.app.Probe.Send(context.Background(), peer2.app.P2p.PeerID(), protocol.MaxEchoPayloadSize)
+	ts.NoError(err)
+	ts.True(result.Timed)
+	_, err = peer1.app.Probe.Send(context.Background(), peer2.app.P2p.PeerID(), protocol.MaxEchoPayloadSize+1)
+	ts.Error(err)
+
+	stats, err := peer2.api.StreamHandlersStats()
+	ts.NoError(err)
+	var probeStats service.StreamHandlerStats
+	for _, handlerStats := range stats {
+		if handlerStats.Protocol == protocol.ProbeMethod {
+			probeStats = handlerStats
+		}
+	}
+	ts.EqualValues(2, probeStats.Accepted)
+	ts.Zero(probeStats.Rejected)
+}
+
+func TestShutdownStopAccepting(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	_, err := peer1.api.EchoPeer(peer2.PeerID(), 1024)
+	ts.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ts.NoError(peer2.app.Tunnel.Drain(ctx))
+
+	stream, err := peer1.app.P2p.NewStream(ctx, peer2.app.P2p.PeerID(), protocol.TunnelPacketMethod)
+	ts.NoError(err)
+	// the stream is accepted by the peer after the first write, an invalid packet is skipped
+	ts.NoError(protocol.WriteUint64(stream, 1))
+	_, err = stream.Write([]byte{0})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		return activeStreams(peer2, protocol.TunnelPacketMethod) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// new streams are refused, active ones are reset, stats are kept
+	ts.NoError(peer2.app.Streams.Close(ctx))
+	ts.Zero(activeStreams(peer2, protocol.TunnelPacketMethod))
+	_ = stream.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = stream.Read(make([]byte, 1))
+	ts.Error(err)
+	ts.NotErrorIs(err, os.ErrDeadlineExceeded)
+	_, err = peer1.api.EchoPeer(peer2.PeerID(), 1024)
+	ts.Error(err)
+	stats, err := peer2.api.StreamHandlersStats()
+	ts.NoError(err)
+	ts.NotEmpty(stats)
+}
+
+func activeStreams(peer testPeer, proto libp2pProtocol.ID) int64 {
+	for _, stats := range peer.app.Streams.Stats() {
+		if stats.Protocol == proto {
+			return stats.Active
+		}
+	}
+	return 0
+}
+
+func TestPeerLatency(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	peer1.app.Latency.CheckPeers(context.Background())
+	peers, err := peer1.api.KnownPeers()
+	ts.NoError(err)
+	ts.Len(peers, 1)
+	latency := peers[0].Latency
+	ts.True(latency.Reachable)
+	ts.False(latency.Relayed)
+	ts.Positive(latency.LastRTT)
+	ts.Equal(latency.LastRTT, latency.AvgRTT)
+	ts.Zero(latency.PacketLoss)
+	ts.Equal(1, latency.Pings)
+	// peers run on the same clock
+	ts.True(latency.ClockOffsetKnown)
+	ts.Less(latency.ClockOffset.Abs(), latency.LastRTT)
+
+	// disconnected peers aren't dialed, pings are lost
+	ts.NoError(peer2.app.P2p.Close())
+	ts.Eventually(func() bool {
+		return !peer1.app.P2p.IsConnected(peer2.app.P2p.PeerID())
+	}, 15*time.Second, 50*time.Millisecond)
+	peer1.app.Latency.CheckPeers(context.Background())
+	response, err := peer1.api.PeerLatency(peer2.PeerID())
+	ts.NoError(err)
+	ts.False(response.Latency.Reachable)
+	ts.Equal(50.0, response.Latency.PacketLoss)
+	ts.Len(response.History, 2)
+	ts.True(response.History[1].Lost)
+
+	_, err = peer1.api.PeerLatency(peer1.PeerID())
+	ts.EqualError(err, "peer not found")
+}
+
+func TestPeerConnectionType(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	peers, err := peer1.api.KnownPeers()
+	ts.NoError(err)
+	ts.Len(peers, 1)
+	ts.Equal(p2p.ConnectionTypeDirect, peers[0].ConnectionType)
+	// test peers are connected over loopback
+	ts.True(peers[0].LocalConnection)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := peer1.api.Events(ctx, "PeerConnectionTypeChanged")
+	ts.NoError(err)
+	peer2ID := peer2.app.P2p.PeerID()
+	ts.NoError(peer1.app.P2p.Host().Network().ClosePeer(peer2ID))
+	ts.Eventually(func() bool {
+		return peer1.app.P2p.PeerConnectionType(peer2ID) == ""
+	}, 15*time.Second, 50*time.Millisecond)
+	ts.NoError(peer1.app.P2p.ConnectPeer(ctx, peer2ID))
+
+	select {
+	case evt := <-events:
+		changed := awlevent.PeerConnectionTypeChanged{}
+		ts.NoError(json.Unmarshal(evt.Data, &changed))
+		ts.Equal(peer2.PeerID(), changed.PeerID)
+		ts.Equal(p2p.ConnectionTypeDirect, changed.ConnectionType)
+	case <-time.After(5 * time.Second):
+		ts.FailNow("connection type event is not received")
+	}
+}
+
+func TestOptionalTransports(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeerWithConfig(false, func(conf *config.Config) {
+		conf.P2pNode.Transports.WebTransport = true
+	})
+	peer2 := ts.newTestPeerWithConfig(false, func(conf *config.Config) {
+		conf.P2pNode.Transports.WebSocket = true
+		conf.P2pNode.Transports.WebSocketListenAddresses = []string{"/ip4/127.0.0.1/tcp/0/ws"}
+		conf.P2pNode.Transports.WebTransport = true
+	})
+
+	connectOver := func(protocolCode int) {
+		var addrs []multiaddr.Multiaddr
+		for _, addr := range peer2.app.P2p.Host().Addrs() {
+			if _, err := addr.ValueForProtocol(protocolCode); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		ts.Len(addrs, 1)
+		peer2ID := peer2.app.P2p.PeerID()
+		ts.NoError(peer1.app.P2p.Host().Network().ClosePeer(peer2ID))
+		peer1.app.P2p.Host().Peerstore().ClearAddrs(peer2ID)
+		ts.NoError(peer1.app.P2p.Host().Connect(context.Background(), peer.AddrInfo{ID: peer2ID, Addrs: addrs}))
+		conns := peer1.app.P2p.Host().Network().ConnsToPeer(peer2ID)
+		ts.Len(conns, 1)
+		_, err := conns[0].RemoteMultiaddr().ValueForProtocol(protocolCode)
+		ts.NoError(err)
+	}
+	connectOver(multiaddr.P_WS)
+	connectOver(multiaddr.P_WEBTRANSPORT)
+}
+
+func TestPeerDNSRecords(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+
+	err := peer2.api.UpdateDNSRecords([]string{"bad..name"})
+	ts.Error(err)
+	err = peer2.api.UpdateDNSRecords([]string{"plex", "Printer"})
+	ts.NoError(err)
+	info, err := peer2.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal([]string{"plex", "printer"}, info.DNSRecords)
+
+	ts.makeFriends(peer2, peer1)
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.Equal([]string{"plex", "printer"}, knownPeer.DNSRecords)
+	mapping := peer1.app.Conf.DNSNamesMapping()
+	ts.Equal(knownPeer.IPAddr, mapping["plex."+knownPeer.DomainName])
+	ts.Equal(knownPeer.IPAddr, mapping["printer."+knownPeer.DomainName])
+
+	// updated records are sent to friends right away
+	err = peer2.api.UpdateDNSRecords([]string{"nas"})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+		return len(knownPeer.DNSRecords) == 1 && knownPeer.DNSRecords[0] == "nas"
+	}, 15*time.Second, 50*time.Millisecond)
+}
+
+func TestKillSwitch(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+
+	// peer2 has not confirmed friend request yet
+	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "")
+	ts.NoError(err)
+	knownPeer, exists := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.True(exists)
+	ts.Equal("10.66.0.2", knownPeer.IPAddr)
+
+	err = peer1.api.UpdateKillSwitch(true)
+	ts.NoError(err)
+	info, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.True(info.KillSwitch)
+
+	// icmp destination unreachable: ip header + icmp header + original ip header and 8 bytes
+	peer1.tun.ReferenceInboundPacketLen = 20 + 8 + 20 + 8
+	peer1.tun.Outbound <- testPacket(100)
+	ts.Eventually(func() bool {
+		return peer1.tun.InboundCount() == 1
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPacketFilter(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+
+	// udp
+	err := peer1.api.UpdatePacketFilter(entity.UpdatePacketFilterRequest{DropMulticast: true, DropProtocols: []int{17}})
+	ts.NoError(err)
+	err = peer1.api.UpdatePacketFilter(entity.UpdatePacketFilterRequest{DropProtocols: []int{256}})
+	ts.Error(err)
+	ts.True(peer1.app.Conf.VPNConfig.PacketFilter.DropMulticast)
+
+	peer1.tun.Outbound <- testPacket(100)
+	ts.Eventually(func() bool {
+		response, err := peer1.api.PacketFilter()
+		ts.NoError(err)
+		return response.Dropped.Protocol == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	response, err := peer1.api.PacketFilter()
+	ts.NoError(err)
+	ts.Equal([]int{17}, response.Filter.DropProtocols)
+	ts.Zero(peer1.tun.InboundCount())
+}
+
+func TestBackupRestore(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	_, err := peer1.api.RunBackup()
+	ts.Error(err)
+
+	peer1.app.Conf.Lock()
+	peer1.app.Conf.Backup.Peers = []string{peer2.PeerID()}
+	peer1.app.Conf.Backup.Passphrase = "passphrase"
+	peer1.app.Conf.Unlock()
+	results, err := peer1.api.RunBackup()
+	ts.NoError(err)
+	ts.Len(results, 1)
+	ts.Equal(peer2.PeerID(), results[0].PeerID)
+	ts.Empty(results[0].Error)
+
+	_, err = peer1.api.RestoreBackup(peer2.PeerID(), peer1.PeerID(), "wrong")
+	ts.Error(err)
+
+	response, err := peer1.api.RestoreBackup(peer2.PeerID(), peer1.PeerID(), "passphrase")
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), response.PeerID)
+	ts.FileExists(filepath.Join(peer1.app.Conf.DataDir(), backup.PendingRestoreFilename))
+}
+
+func TestBackupExportImport(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	restorePath := filepath.Join(peer2.app.Conf.DataDir(), backup.PendingRestoreFilename)
+
+	archive, err := peer1.api.ExportBackup("passphrase", false)
+	ts.NoError(err)
+	_, err = peer2.api.ImportBackup("wrong", archive)
+	ts.Error(err)
+	response, err := peer2.api.ImportBackup("passphrase", archive)
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), response.PeerID)
+	ts.FileExists(restorePath)
+
+	// template keeps identity of the node which imports it
+	template, err := peer1.api.ExportBackup("passphrase", true)
+	ts.NoError(err)
+	response, err = peer2.api.ImportBackup("passphrase", template)
+	ts.NoError(err)
+	ts.Equal(peer2.PeerID(), response.PeerID)
+	data, err := os.ReadFile(restorePath)
+	ts.NoError(err)
+	var snapshot backup.Snapshot
+	ts.NoError(json.Unmarshal(data, &snapshot))
+	ts.Nil(snapshot.Storage)
+	ts.Contains(string(snapshot.Config), peer2.PeerID())
+	ts.NotContains(string(snapshot.Config), peer1.PeerID())
+}
+
+func TestSharedFolder(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	folder := t.TempDir()
+	ts.NoError(os.WriteFile(filepath.Join(folder, "file.txt"), []byte("hello"), 0644))
+	ts.Error(peer1.api.UpdateSharedFolder("relative/path"))
+	ts.NoError(peer1.api.UpdateSharedFolder(folder))
+	info, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(folder, info.SharedFolderPath)
+
+	baseURL := fmt.Sprintf("http://%s/shared/%s/", peer2.app.Api.Address(), peer1.PeerID())
+	doRequest := func(method, path, body string, headers map[string]string) (int, string) {
+		req, err := http.NewRequest(method, baseURL+path, strings.NewReader(body))
+		ts.NoError(err)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		ts.NoError(err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		ts.NoError(err)
+		return resp.StatusCode, string(data)
+	}
+
+	// access is not granted yet
+	code, _ := doRequest(http.MethodGet, "file.txt", "", nil)
+	ts.Equal(http.StatusForbidden, code)
+
+	setAccess := func(access string) {
+		knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+		err := peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+			PeerID: knownPeer.PeerID, Alias: knownPeer.Alias, DomainName: knownPeer.DomainName, SharedFolderAccess: access,
+		})
+		ts.NoError(err)
+	}
+	setAccess(config.SharedFolderAccessRead)
+	code, body := doRequest(http.MethodGet, "file.txt", "", nil)
+	ts.Equal(http.StatusOK, code)
+	ts.Equal("hello", body)
+	code, body = doRequest("PROPFIND", "", "", map[string]string{"Depth": "1"})
+	ts.Equal(http.StatusMultiStatus, code)
+	ts.Contains(body, "/shared/"+peer1.PeerID()+"/file.txt")
+	code, _ = doRequest(http.MethodPut, "new.txt", "new", nil)
+	ts.Equal(http.StatusForbidden, code)
+
+	setAccess(config.SharedFolderAccessWrite)
+	code, _ = doRequest(http.MethodPut, "new.txt", "new", nil)
+	ts.Equal(http.StatusCreated, code)
+	code, _ = doRequest("MOVE", "new.txt", "", map[string]string{"Destination": baseURL + "moved.txt"})
+	ts.Equal(http.StatusCreated, code)
+	data, err := os.ReadFile(filepath.Join(folder, "moved.txt"))
+	ts.NoError(err)
+	ts.Equal("new", string(data))
+
+	ts.NoError(peer1.api.UpdateSharedFolder(""))
+	code, _ = doRequest(http.MethodGet, "file.txt", "", nil)
+	ts.Equal(http.StatusForbidden, code)
+}
+
+func TestSupportReport(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	_, err := peer2.api.SupportReport(peer1.PeerID(), 10)
+	ts.ErrorContains(err, "support access is not granted")
+
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: knownPeer.PeerID, Alias: knownPeer.Alias, DomainName: knownPeer.DomainName, SupportAccess: true,
+	})
+	ts.NoError(err)
+
+	report, err := peer2.api.SupportReport(peer1.PeerID(), 10)
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), report.PeerID)
+	ts.Contains(report.Logs, "Host created. We are: "+peer1.PeerID())
+	ts.Equal(config.Version, report.Diagnostics.Version)
+	ts.Equal(peer1.app.Conf.VPNConfig.IPNet, report.Diagnostics.VPN.IPNet)
+	ts.Len(report.Diagnostics.Peers, 1)
+	ts.Equal(peer2.PeerID(), report.Diagnostics.Peers[0].PeerID)
+	ts.True(report.Diagnostics.Peers[0].Connected)
+
+	_, err = peer2.api.SupportReport(peer1.PeerID(), protocol.MaxSupportLogMinutes+1)
+	ts.Error(err)
+}
+
+func TestManagement(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	managed := apiclient.New(peer2.app.Api.Address())
+	managed.SetManagedPeer(peer1.PeerID())
+	_, err := managed.PeerInfo()
+	ts.ErrorContains(err, "peer api is unavailable")
+
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: knownPeer.PeerID, Alias: knownPeer.Alias, DomainName: knownPeer.DomainName, ManagementAllowed: true,
+	})
+	ts.NoError(err)
+
+	info, err := managed.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), info.PeerID)
+	ts.NoError(managed.UpdateMySettings("managed"))
+	info, err = peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal("managed", info.Name)
+
+	// managed peers can't be used to manage their friends
+	chained := apiclient.New(peer2.app.Api.Address())
+	chained.SetManagedPeer(peer1.PeerID() + protocol.ManagementPathPrefix + peer2.PeerID())
+	_, err = chained.PeerInfo()
+	ts.ErrorContains(err, "managed peers can't be managed through this peer")
+
+	// grant is checked for open connections too
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: knownPeer.PeerID, Alias: knownPeer.Alias, DomainName: knownPeer.DomainName,
+	})
+	ts.NoError(err)
+	_, err = managed.PeerInfo()
+	ts.ErrorContains(err, "management is not allowed")
+}
+
+func TestPortScan(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	ts.NoError(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-awl_test\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	ports := strconv.Itoa(port)
+
+	_, err = peer2.api.PortScan(peer1.PeerID(), "", ports)
+	ts.ErrorContains(err, "management access is not granted")
+
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: knownPeer.PeerID, Alias: knownPeer.Alias, DomainName: knownPeer.DomainName, ManagementAllowed: true,
+	})
+	ts.NoError(err)
+
+	response, err := peer2.api.PortScan(peer1.PeerID(), "", ports)
+	ts.NoError(err)
+	ts.Equal([]protocol.OpenPort{{Port: port, Banner: "SSH-2.0-awl_test"}}, response.Open)
+
+	// hosts outside advertised routes of the peer are not scanned
+	_, err = peer2.api.PortScan(peer1.PeerID(), "192.168.77.1", ports)
+	ts.ErrorContains(err, "not in advertised routes")
+
+	_, err = peer2.api.PortScan(peer1.PeerID(), "", "1-65535")
+	ts.ErrorContains(err, "too many ports")
+}
+
+func TestFileTransfer(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	content := bytes.Repeat([]byte("file transfer "), 100_000)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	ts.NoError(os.WriteFile(path, content, 0644))
+	waitTransfer := func(id string) service.FileTransferStatus {
+		var status service.FileTransferStatus
+		ts.Eventually(func() bool {
+			transfers, err := peer1.api.FileTransfers()
+			ts.NoError(err)
+			for _, transfer := range transfers {
+				if transfer.ID == id {
+					status = transfer
+				}
+			}
+			return status.State != "" && status.State != service.FileTransferStateActive
+		}, 15*time.Second, 50*time.Millisecond)
+		return status
+	}
+
+	// files are refused without inbox and such transfers aren't retried
+	sent, err := peer1.api.SendFile(peer2.PeerID(), path)
+	ts.NoError(err)
+	status := waitTransfer(sent.ID)
+	ts.Equal(service.FileTransferStateFailed, status.State)
+	ts.Contains(status.Error, "inbox is not configured")
+	ts.Equal(1, status.Attempts)
+
+	inbox := t.TempDir()
+	ts.Error(peer2.api.UpdateFileTransfer("relative/path"))
+	ts.NoError(peer2.api.UpdateFileTransfer(inbox))
+	info, err := peer2.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(inbox, info.FileTransferInboxPath)
+
+	// files above the max size are refused
+	peer2.app.Conf.Lock()
+	peer2.app.Conf.FileTransfer.MaxSizeMB = 1
+	peer2.app.Conf.Unlock()
+	sent, err = peer1.api.SendFile(peer2.PeerID(), path)
+	ts.NoError(err)
+	status = waitTransfer(sent.ID)
+	ts.Equal(service.FileTransferStateFailed, status.State)
+	ts.Contains(status.Error, "file is too large")
+	ts.Equal(1, status.Attempts)
+	peer2.app.Conf.Lock()
+	peer2.app.Conf.FileTransfer.MaxSizeMB = 10
+	peer2.app.Conf.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := peer2.api.Events(ctx, "FileTransferProgress")
+	ts.NoError(err)
+
+	// interrupted transfer is resumed from the received part
+	hash := sha256.Sum256(content)
+	partialDir := filepath.Join(inbox, ".awl-partial")
+	ts.NoError(os.MkdirAll(partialDir, 0700))
+	partialPath := filepath.Join(partialDir, peer1.PeerID()+"-"+hex.EncodeToString(hash[:]))
+	ts.NoError(os.WriteFile(partialPath, content[:len(content)/2], 0600))
+
+	sent, err = peer1.api.SendFile(peer2.PeerID(), path)
+	ts.NoError(err)
+	status = waitTransfer(sent.ID)
+	ts.Equal(service.FileTransferStateCompleted, status.State, status.Error)
+	ts.EqualValues(len(content), status.Transferred)
+	received, err := os.ReadFile(filepath.Join(inbox, "file.txt"))
+	ts.NoError(err)
+	ts.Equal(content, received)
+	ts.NoFileExists(partialPath)
+
+	transfers := peer2.app.FileTransfer.Transfers()
+	ts.Len(transfers, 1)
+	ts.Equal(service.FileTransferDirectionReceive, transfers[0].Direction)
+	ts.Equal(peer1.PeerID(), transfers[0].PeerID)
+	ts.Equal(filepath.Join(inbox, "file.txt"), transfers[0].Path)
+
+	completed := false
+	for !completed {
+		select {
+		case evt := <-events:
+			progress := awlevent.FileTransferProgress{}
+			ts.NoError(json.Unmarshal(evt.Data, &progress))
+			ts.Equal("file.txt", progress.Name)
+			completed = progress.State == service.FileTransferStateCompleted
+		case <-time.After(5 * time.Second):
+			ts.FailNow("file transfer event is not received")
+		}
+	}
+
+	// existing files are kept
+	sent, err = peer1.api.SendFile(peer2.PeerID(), path)
+	ts.NoError(err)
+	status = waitTransfer(sent.ID)
+	ts.Equal(service.FileTransferStateCompleted, status.State, status.Error)
+	received, err = os.ReadFile(filepath.Join(inbox, "file (1).txt"))
+	ts.NoError(err)
+	ts.Equal(content, received)
+}
+
+func TestMessages(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := peer2.api.Events(ctx, "TextMessageReceived")
+	ts.NoError(err)
+
+	_, err = peer1.api.SendMessage(peer2.PeerID(), strings.Repeat("a", protocol.MaxTextMessageLength+1))
+	ts.Error(err)
+	sent, err := peer1.api.SendMessage(peer2.PeerID(), "rebooting the shared server")
+	ts.NoError(err)
+	ts.Equal(service.MessageDirectionOutbound, sent.Direction)
+
+	select {
+	case evt := <-events:
+		received := awlevent.TextMessageReceived{}
+		ts.NoError(json.Unmarshal(evt.Data, &received))
+		ts.Equal(peer1.PeerID(), received.PeerID)
+		ts.Equal("rebooting the shared server", received.Text)
+	case <-time.After(5 * time.Second):
+		ts.FailNow("message event is not received")
+	}
+
+	info, err := peer2.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(1, info.UnreadMessages)
+	peers, err := peer2.api.KnownPeers()
+	ts.NoError(err)
+	ts.Equal(1, peers[0].UnreadMessages)
+
+	messages, err := peer2.api.Messages(peer1.PeerID(), 0)
+	ts.NoError(err)
+	ts.Len(messages, 1)
+	ts.Equal(service.MessageDirectionInbound, messages[0].Direction)
+	ts.False(messages[0].Read)
+	messages, err = peer1.api.Messages("", 10)
+	ts.NoError(err)
+	ts.Len(messages, 1)
+	ts.Equal(sent.ID, messages[0].ID)
+
+	ts.NoError(peer2.api.MarkMessagesRead(peer1.PeerID()))
+	info, err = peer2.api.PeerInfo()
+	ts.NoError(err)
+	ts.Zero(info.UnreadMessages)
+	messages, err = peer2.api.Messages("", 0)
+	ts.NoError(err)
+	ts.True(messages[0].Read)
+}
+
+func TestRotateIdentity(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+	oldPeerID := peer1.PeerID()
+	peer2.app.Conf.Lock()
+	peer2.app.Conf.Backup.Peers = []string{oldPeerID}
+	peer2.app.Conf.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := peer2.api.Events(ctx, "PeerIdentityMigrated")
+	ts.NoError(err)
+
+	migration, err := peer1.api.RotateIdentity()
+	ts.NoError(err)
+	ts.Equal(oldPeerID, migration.OldPeerID)
+	ts.NotEqual(oldPeerID, migration.NewPeerID)
+	ts.Equal([]string{peer2.PeerID()}, migration.PendingPeers)
+	ts.True(migration.RestartRequired)
+	ts.Equal(migration.NewPeerID, peer1.PeerID())
+	_, err = peer1.api.RotateIdentity()
+	ts.ErrorContains(err, "restart is required")
+
+	// the statement isn't sent until restart, so peers don't lose us while we use the old peer id
+	peer1.app.AuthStatus.SendIdentityMigration(ctx)
+	_, exists := peer2.app.Conf.GetPeer(oldPeerID)
+	ts.True(exists)
+
+	peer1 = ts.restartTestPeer(peer1)
+	ts.Equal(migration.NewPeerID, peer1.app.P2p.PeerID().String())
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+	peer1.app.AuthStatus.SendIdentityMigration(ctx)
+	select {
+	case evt := <-events:
+		migrated := awlevent.PeerIdentityMigrated{}
+		ts.NoError(json.Unmarshal(evt.Data, &migrated))
+		ts.Equal(awlevent.PeerIdentityMigrated{OldPeerID: oldPeerID, NewPeerID: migration.NewPeerID}, migrated)
+	case <-time.After(5 * time.Second):
+		ts.FailNow("migration event is not received")
+	}
+	_, exists = peer2.app.Conf.GetPeer(oldPeerID)
+	ts.False(exists)
+	knownPeer, exists := peer2.app.Conf.GetPeer(migration.NewPeerID)
+	ts.True(exists)
+	ts.True(knownPeer.Confirmed)
+	ts.Equal("peer_2", knownPeer.Alias)
+	peer2.app.Conf.RLock()
+	ts.Equal([]string{migration.NewPeerID}, peer2.app.Conf.Backup.Peers)
+	peer2.app.Conf.RUnlock()
+	ts.Empty(peer1.app.AuthStatus.IdentityMigration().PendingPeers)
+
+	// the same statement is accepted again, e.g. if the response was lost
+	peer1.app.Conf.Lock()
+	peer1.app.Conf.P2pNode.IdentityMigration.PendingPeers = []string{peer2.PeerID()}
+	peer1.app.Conf.Unlock()
+	peer1.app.AuthStatus.SendIdentityMigration(ctx)
+	ts.Empty(peer1.app.AuthStatus.IdentityMigration().PendingPeers)
+
+	status, err := peer1.api.IdentityMigration()
+	ts.NoError(err)
+	ts.False(status.RestartRequired)
+	ts.Equal(migration.NewPeerID, status.NewPeerID)
+}
+
+func TestFeatures(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer := ts.newTestPeer(false)
+	info, err := peer.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(config.Features(), info.Features)
+
+	// api of features which are excluded from the build isn't served
+	_, err = peer.api.FileTransfers()
+	if config.FileTransferIncluded {
+		ts.NoError(err)
+	} else {
+		ts.Error(err)
+	}
+	proxyHandled := false
+	for _, handler := range peer.app.Streams.Stats() {
+		proxyHandled = proxyHandled || handler.Protocol == protocol.ProxyMethod
+	}
+	ts.Equal(config.SOCKS5Included, proxyHandled)
+}
+
+func TestExitNode(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	// peer2 doesn't allow it yet
+	err := peer1.api.UpdateExitNode(peer2.PeerID())
+	ts.Error(err)
+
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer1.PeerID(),
+		Alias:                peer1Config.Alias,
+		DomainName:           peer1Config.DomainName,
+		AllowUsingAsExitNode: true,
+	})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+		return peer2Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	err = peer1.api.UpdateExitNode(peer2.PeerID())
+	ts.NoError(err)
+	info, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(peer2.PeerID(), info.ExitNodePeerID)
+
+	// both peers see each other as 10.66.0.2
+	internetIP := net.IPv4(1, 1, 1, 1).To4()
+	peer2.tun.ReferenceInboundPacketLen = len(testPacket(0))
+	peer2.tun.Inbound = make(chan []byte, 1)
+	peer1.tun.Outbound <- testPacketWithAddrs(net.IPv4(10, 66, 0, 1), internetIP)
+	select {
+	case data := <-peer2.tun.Inbound:
+		ts.Equal(net.IPv4(10, 66, 0, 2).To4(), net.IP(data[12:16]))
+		ts.Equal(internetIP, net.IP(data[16:20]))
+	case <-time.After(5 * time.Second):
+		ts.Fail("packet was not sent through exit node")
+	}
+
+	// private networks of the exit node are not reachable through it
+	peer1.tun.Outbound <- testPacketWithAddrs(net.IPv4(10, 66, 0, 1), net.IPv4(192, 168, 1, 5))
+	select {
+	case <-peer2.tun.Inbound:
+		ts.Fail("packet to private network was sent through exit node")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	peer1.tun.ReferenceInboundPacketLen = len(testPacket(0))
+	peer1.tun.Inbound = make(chan []byte, 1)
+	peer2.tun.Outbound <- testPacketWithAddrs(internetIP, net.IPv4(10, 66, 0, 2))
+	select {
+	case data := <-peer1.tun.Inbound:
+		ts.Equal(internetIP, net.IP(data[12:16]))
+		ts.Equal(net.IPv4(10, 66, 0, 1).To4(), net.IP(data[16:20]))
+	case <-time.After(5 * time.Second):
+		ts.Fail("reply was not received from exit node")
+	}
+
+	// exit node is a hop for the packet: ip header + icmp header + original ip header and 8 bytes
+	peer1.tun.ReferenceInboundPacketLen = 20 + 8 + 20 + 8
+	expiring := testPacketWithAddrs(net.IPv4(10, 66, 0, 1), internetIP)
+	expiring[8] = 1
+	peer1.tun.Outbound <- expiring
+	select {
+	case data := <-peer1.tun.Inbound:
+		ts.EqualValues(ipv4.ICMPTypeTimeExceeded, data[20])
+		ts.Equal(internetIP, net.IP(data[12:16]))
+		ts.Equal(net.IPv4(10, 66, 0, 1).To4(), net.IP(data[16:20]))
+	case <-time.After(5 * time.Second):
+		ts.Fail("time exceeded was not received from exit node")
+	}
+
+	err = peer1.api.UpdateExitNode("")
+	ts.NoError(err)
+}
+
+func TestSubnetRoutes(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	err := peer2.api.UpdateAdvertisedRoutes([]string{"10.66.0.128/25"})
+	ts.Error(err)
+	err = peer2.api.UpdateAdvertisedRoutes([]string{"0.0.0.0/0"})
+	ts.Error(err)
+	err = peer2.api.UpdateAdvertisedRoutes([]string{"192.168.10.1/24"})
+	ts.NoError(err)
+	info, err := peer2.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal([]string{"192.168.10.0/24"}, info.AdvertisedRoutes)
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+		return len(peer2Config.SubnetRoutes) == 1 && peer2Config.SubnetRoutes[0] == "192.168.10.0/24"
+	}, 15*time.Second, 100*time.Millisecond)
+
+	// both peers see each other as 10.66.0.2
+	lanIP := net.IPv4(192, 168, 10, 5).To4()
+	peer2.tun.ReferenceInboundPacketLen = len(testPacket(0))
+	peer2.tun.Inbound = make(chan []byte, 1)
+	notRouted := func(msg string) {
+		peer1.tun.Outbound <- testPacketWithAddrs(net.IPv4(10, 66, 0, 1), lanIP)
+		select {
+		case <-peer2.tun.Inbound:
+			ts.Fail(msg)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	// routes are not accepted by default and the peer should allow us to use it as router
+	notRouted("packet was routed to subnet which routes are not accepted")
+	err = peer1.api.UpdatePeerRoutes(peer2.PeerID(), true, false)
+	ts.NoError(err)
+	notRouted("packet was routed to subnet of the peer which doesn't allow using it as router")
+
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer1.PeerID(),
+		Alias:                peer1Config.Alias,
+		DomainName:           peer1Config.DomainName,
+		AllowUsingAsExitNode: true,
+	})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+		return peer2Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	// packets are dropped until the refused stream is reopened
+	ts.Eventually(func() bool {
+		peer1.tun.Outbound <- testPacketWithAddrs(net.IPv4(10, 66, 0, 1), lanIP)
+		select {
+		case data := <-peer2.tun.Inbound:
+			ts.Equal(net.IPv4(10, 66, 0, 2).To4(), net.IP(data[12:16]))
+			ts.Equal(lanIP, net.IP(data[16:20]))
+			return true
+		case <-time.After(500 * time.Millisecond):
+			return false
+		}
+	}, 15*time.Second, 100*time.Millisecond, "packet was not routed to advertised subnet")
+
+	peer1.tun.ReferenceInboundPacketLen = len(testPacket(0))
+	peer1.tun.Inbound = make(chan []byte, 1)
+	peer2.tun.Outbound <- testPacketWithAddrs(lanIP, net.IPv4(10, 66, 0, 2))
+	select {
+	case data := <-peer1.tun.Inbound:
+		ts.Equal(lanIP, net.IP(data[12:16]))
+		ts.Equal(net.IPv4(10, 66, 0, 1).To4(), net.IP(data[16:20]))
+	case <-time.After(5 * time.Second):
+		ts.Fail("reply was not received from advertised subnet")
+	}
+
+	err = peer2.api.UpdateAdvertisedRoutes(nil)
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+		return len(peer2Config.SubnetRoutes) == 0
+	}, 15*time.Second, 100*time.Millisecond)
+}
+
+func TestRoutingLoop(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	// peer2 uses peer1 as exit node, while peer1 routes public network of peer2 back to it
+	peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+	ts.NoError(err)
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer2.PeerID(),
+		Alias:                peer2Config.Alias,
+		DomainName:           peer2Config.DomainName,
+		AllowUsingAsExitNode: true,
+	})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+		ts.NoError(err)
+		return peer1Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+	err = peer2.api.UpdateExitNode(peer1.PeerID())
+	ts.NoError(err)
+	err = peer2.api.UpdateAdvertisedRoutes([]string{"203.0.113.0/24"})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+		return len(peer2Config.SubnetRoutes) == 1
+	}, 15*time.Second, 100*time.Millisecond)
+	err = peer1.api.UpdatePeerRoutes(peer2.PeerID(), true, true)
+	ts.NoError(err)
+	time.Sleep(200 * time.Millisecond)
+
+	peer1.tun.ReferenceInboundPacketLen = len(testPacket(0))
+	peer1.tun.Inbound = make(chan []byte, 1)
+	peer2.tun.Outbound <- testPacketWithAddrs(net.IPv4(10, 66, 0, 1), net.IPv4(1, 1, 1, 1))
+	select {
+	case <-peer1.tun.Inbound:
+	case <-time.After(5 * time.Second):
+		ts.Fail("packet was not sent through exit node")
+	}
+
+	peer2.tun.Outbound <- testPacketWithAddrs(net.IPv4(10, 66, 0, 1), net.IPv4(203, 0, 113, 5))
+	select {
+	case <-peer1.tun.Inbound:
+		ts.Fail("packet which is routed back to the peer was forwarded")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestPeerACL(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	err := peer2.api.UpdatePeerACL(peer1.PeerID(), []config.ACLRule{{Action: "reject"}})
+	ts.Error(err)
+	err = peer2.api.UpdatePeerACL(peer1.PeerID(), []config.ACLRule{{Action: config.ACLActionAllow, Protocol: config.ACLProtocolICMP, Ports: "22"}})
+	ts.Error(err)
+	err = peer2.api.UpdatePeerACL(peer1.PeerID(), []config.ACLRule{{Action: config.ACLActionAllow, Ports: "100-10"}})
+	ts.Error(err)
+
+	// peer1 could connect only to udp 9090 of the test packet
+	rules := []config.ACLRule{
+		{Action: config.ACLActionAllow, Direction: config.ACLDirectionIn, Protocol: config.ACLProtocolUDP, Ports: "9000-9100"},
+		{Action: config.ACLActionDeny, Direction: config.ACLDirectionIn},
+	}
+	err = peer2.api.UpdatePeerACL(peer1.PeerID(), rules)
+	ts.NoError(err)
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	ts.Equal(rules, peer1Config.ACL)
+	time.Sleep(200 * time.Millisecond)
+
+	receive := func(peer testPeer) bool {
+		select {
+		case <-peer.tun.Inbound:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+	peer1.tun.ReferenceInboundPacketLen = len(testPacket(0))
+	peer1.tun.Inbound = make(chan []byte, 10)
+	peer2.tun.ReferenceInboundPacketLen = len(testPacket(0))
+	peer2.tun.Inbound = make(chan []byte, 10)
+
+	peer1.tun.Outbound <- testPacket(0)
+	ts.True(receive(peer2), "allowed packet was not received")
+	peer1.tun.Outbound <- testPacketWithPorts(43472, 22)
+	ts.False(receive(peer2), "denied packet was received")
+
+	// replies to connections opened by peer2 are allowed
+	peer2.tun.Outbound <- testPacketWithPorts(40000, 22)
+	ts.True(receive(peer1), "packet to peer1 was not received")
+	peer1.tun.Outbound <- testPacketWithPorts(22, 40000)
+	ts.True(receive(peer2), "reply from peer1 was not received")
+
+	err = peer2.api.UpdatePeerACL(peer1.PeerID(), nil)
+	ts.NoError(err)
+	time.Sleep(200 * time.Millisecond)
+	peer1.tun.Outbound <- testPacketWithPorts(43472, 22)
+	ts.True(receive(peer2), "packet was not received after acl was cleared")
+}
+
+func TestPeerMTU(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	err := peer2.api.UpdatePeerMTU(peer1.PeerID(), 100)
+	ts.Error(err)
+	err = peer2.api.UpdatePeerMTU(peer1.PeerID(), vpn.InterfaceMTU+1)
+	ts.Error(err)
+	err = peer2.api.UpdatePeerMTU(peer1.PeerID(), 1400)
+	ts.NoError(err)
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	ts.Equal(1400, peer1Config.MTU)
+	time.Sleep(200 * time.Millisecond)
+
+	// SYN from peer1 is clamped by peer2
+	syn := testTCPSynPacket(1460)
+	peer2.tun.ReferenceInboundPacketLen = len(syn)
+	peer2.tun.Inbound = make(chan []byte, 1)
+	peer1.tun.Outbound <- syn
+	select {
+	case data := <-peer2.tun.Inbound:
+		ts.EqualValues(1400-40, binary.BigEndian.Uint16(data[20+20+2:]))
+	case <-time.After(5 * time.Second):
+		ts.Fail("packet was not received")
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	// proxy peer doesn't connect to loopback addresses, so echo server listens on an external interface
+	var echoIP net.IP
+	addrs, err := net.InterfaceAddrs()
+	ts.NoError(err)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			echoIP = ipNet.IP.To4()
+			break
+		}
+	}
+	if echoIP == nil {
+		t.Skip("no external IPv4 address")
+	}
+	echoListener, err := net.Listen("tcp", net.JoinHostPort(echoIP.String(), "0"))
+	ts.NoError(err)
+	defer echoListener.Close()
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	// peer2 doesn't allow it yet
+	err = peer1.api.UpdateSOCKS5("127.0.0.1:0", peer2.PeerID())
+	ts.Error(err)
+
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:               peer1.PeerID(),
+		Alias:                peer1Config.Alias,
+		DomainName:           peer1Config.DomainName,
+		AllowUsingAsExitNode: true,
+	})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+		ts.NoError(err)
+		return peer2Config.AllowedUsingAsExitNode
+	}, 15*time.Second, 100*time.Millisecond)
+
+	err = peer1.api.UpdateSOCKS5("127.0.0.1:0", peer2.PeerID())
+	ts.NoError(err)
+	info, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(peer2.PeerID(), info.SOCKS5PeerID)
+	proxyAddr := peer1.app.SOCKS5.ListenAddr()
+	ts.NotNil(proxyAddr)
+
+	connect := func(destination *net.TCPAddr) (net.Conn, byte) {
+		conn, err := net.Dial("tcp", proxyAddr.String())
+		ts.NoError(err)
+		_ = conn.SetDeadline(time.Now().Add(15 * time.Second))
+		_, err = conn.Write([]byte{5, 1, 0})
+		ts.NoError(err)
+		reply := make([]byte, 2)
+		_, err = io.ReadFull(conn, reply)
+		ts.NoError(err)
+		ts.Equal([]byte{5, 0}, reply)
+
+		request := append([]byte{5, 1, 0, 1}, destination.IP.To4()...)
+		request = binary.BigEndian.AppendUint16(request, uint16(destination.Port))
+		_, err = conn.Write(request)
+		ts.NoError(err)
+		reply = make([]byte, 10)
+		_, err = io.ReadFull(conn, reply)
+		ts.NoError(err)
+		return conn, reply[1]
+	}
+
+	conn, reply := connect(echoListener.Addr().(*net.TCPAddr))
+	ts.EqualValues(0, reply)
+	_, err = conn.Write([]byte("hello"))
+	ts.NoError(err)
+	response := make([]byte, 5)
+	_, err = io.ReadFull(conn, response)
+	ts.NoError(err)
+	ts.Equal("hello", string(response))
+	_ = conn.Close()
+
+	// loopback of proxy peer is not reachable
+	conn, reply = connect(proxyAddr.(*net.TCPAddr))
+	ts.NotEqualValues(0, reply)
+	_ = conn.Close()
+
+	// connections to resumable ports survive outage of the proxy peer
+	echoPort := echoListener.Addr().(*net.TCPAddr).Port
+	err = peer1.api.UpdateSOCKS5("127.0.0.1:0", peer2.PeerID(), echoPort)
+	ts.NoError(err)
+	info, err = peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal([]int{echoPort}, info.SOCKS5ResumablePorts)
+	proxyAddr = peer1.app.SOCKS5.ListenAddr()
+	conn, reply = connect(echoListener.Addr().(*net.TCPAddr))
+	ts.EqualValues(0, reply)
+	echo := func(data []byte) {
+		_, err := conn.Write(data)
+		ts.NoError(err)
+		response := make([]byte, len(data))
+		_, err = io.ReadFull(conn, response)
+		ts.NoError(err)
+		ts.Equal(data, response)
+	}
+	echo([]byte("before outage"))
+	ts.NoError(peer1.app.P2p.Host().Network().ClosePeer(peer2.app.P2p.PeerID()))
+	echo([]byte("after outage"))
+	// more than acknowledged at once
+	echo(bytes.Repeat([]byte("data"), 100<<10))
+	_ = conn.Close()
+
+	err = peer1.api.UpdateSOCKS5("", "")
+	ts.NoError(err)
+	ts.Nil(peer1.app.SOCKS5.ListenAddr())
+}
+
+func TestKioskAPI(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeerWithConfig(false, func(conf *config.Config) {
+		conf.KioskListenAddress = "127.0.0.1:0"
+	})
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+	ts.Empty(peer2.app.Api.KioskAddress())
+
+	kiosk := apiclient.New(peer1.app.Api.KioskAddress())
+	info, err := kiosk.PeerInfo()
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), info.PeerID)
+	knownPeers, err := kiosk.KnownPeers()
+	ts.NoError(err)
+	ts.Len(knownPeers, 1)
+	_, err = kiosk.StatsSnapshot()
+	ts.NoError(err)
+
+	err = kiosk.UpdateMySettings("kiosk")
+	ts.ErrorContains(err, "read-only")
+	_, err = kiosk.KnownPeerConfig(peer2.PeerID())
+	ts.ErrorContains(err, "read-only")
+	// exported config contains identity
+	resp, err := http.Get("http://" + peer1.app.Api.KioskAddress() + api.ExportServerConfigPath)
+	ts.NoError(err)
+	_ = resp.Body.Close()
+	ts.Equal(http.StatusForbidden, resp.StatusCode)
+	err = kiosk.RemovePeer(peer2.PeerID())
+	ts.ErrorContains(err, "read-only")
+
+	info, err = peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.NotEqual("kiosk", info.Name)
+	knownPeers, err = peer1.api.KnownPeers()
+	ts.NoError(err)
+	ts.Len(knownPeers, 1)
+}
+
+func TestSearch(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+	ts.NoError(err)
+	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID:     peer2.PeerID(),
+		Alias:      "Living Room TV",
+		DomainName: peer2Config.DomainName,
+	})
+	ts.NoError(err)
+
+	result, err := peer1.api.Search("room tv", 0)
+	ts.NoError(err)
+	ts.Len(result.Peers, 1)
+	ts.Equal(peer2.PeerID(), result.Peers[0].PeerID)
+
+	result, err = peer1.api.Search(peer2Config.IPAddr, 0)
+	ts.NoError(err)
+	ts.Len(result.Peers, 1)
+	ts.Equal(peer2.PeerID(), result.Peers[0].PeerID)
+
+	result, err = peer1.api.Search("room nothing", 0)
+	ts.NoError(err)
+	ts.Empty(result.Peers)
+
+	result, err = peer1.api.Search("web server", 1)
+	ts.NoError(err)
+	ts.Len(result.Events, 1)
+	ts.Contains(strings.ToLower(result.Events[0].Message), "web server")
+
+	_, err = peer1.api.Search(" ", 0)
+	ts.Error(err)
+}
+
+func TestRendezvous(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	peer3 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+	ts.ensurePeersAvailableInDHT(peer2, peer3)
+
+	err := peer1.api.UpdateRendezvous("short")
+	ts.ErrorContains(err, "at least")
+	const phrase = "Purple elephant dances at midnight"
+	ts.NoError(peer1.api.UpdateRendezvous(phrase))
+	ts.NoError(peer2.api.UpdateRendezvous(" purple  elephant DANCES at midnight"))
+	ts.NoError(peer3.api.UpdateRendezvous("another elephant dances at noon"))
+
+	peerInfo, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.True(peerInfo.RendezvousEnabled)
+
+	// peers with the same phrase become friends without confirmation
+	ts.Eventually(func() bool {
+		_, _ = peer2.app.AuthStatus.DiscoverRendezvousPeers(context.Background())
+		knownPeer1, known1 := peer2.app.Conf.GetPeer(peer1.PeerID())
+		knownPeer2, known2 := peer1.app.Conf.GetPeer(peer2.PeerID())
+		return known1 && known2 && knownPeer1.Confirmed && knownPeer2.Confirmed
+	}, 30*time.Second, 500*time.Millisecond)
+
+	for peer, count := range map[testPeer]int{peer1: 1, peer2: 1, peer3: 0} {
+		knownPeers, err := peer.api.KnownPeers()
+		ts.NoError(err)
+		ts.Len(knownPeers, count)
+	}
+	authRequests, err := peer1.api.AuthRequests()
+	ts.NoError(err)
+	ts.Empty(authRequests)
+}
+
+func TestInvite(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	peer3 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer2, peer1)
+
+	invite, err := peer1.api.CreateInvite("laptop", 0)
+	ts.NoError(err)
+	ts.WithinDuration(time.Now().Add(service.DefaultInviteTTL), invite.ExpiresAt, time.Minute)
+	_, err = peer1.api.AcceptInvite(invite.Token, "")
+	ts.Error(err)
+	_, err = peer2.api.AcceptInvite(invite.Token+"x", "")
+	ts.Error(err)
+
+	accepted, err := peer2.api.AcceptInvite(invite.Token, "desktop")
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), accepted.PeerID)
+	ts.Equal("desktop", accepted.Alias)
+
+	// both sides are confirmed without accepting friend request manually
+	ts.Eventually(func() bool {
+		knownPeer, exists := peer1.app.Conf.GetPeer(peer2.PeerID())
+		return exists && knownPeer.Confirmed
+	}, 15*time.Second, 50*time.Millisecond)
+	ts.Eventually(func() bool {
+		knownPeer, exists := peer2.app.Conf.GetPeer(peer1.PeerID())
+		return exists && knownPeer.Confirmed
+	}, 15*time.Second, 50*time.Millisecond)
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.Equal("laptop", knownPeer.Alias)
+	ts.Empty(peer1.app.AuthStatus.GetIngoingAuthRequests())
+
+	// invite is one-time, the next peer needs confirmation
+	ts.ensurePeersAvailableInDHT(peer3, peer1)
+	_, err = peer3.api.AcceptInvite(invite.Token, "")
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		authRequests, err := peer1.api.AuthRequests()
+		ts.NoError(err)
+		return len(authRequests) == 1
+	}, 15*time.Second, 50*time.Millisecond)
+	_, exists := peer1.app.Conf.GetPeer(peer3.PeerID())
+	ts.False(exists)
+}
+
+func TestPeerNotes(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	contact := config.PeerContact{Owner: "Alice", Phone: "+1 555 0100", Email: "alice@example.com"}
+	err := peer1.api.UpdatePeerNotes(peer2.PeerID(), " raspberry pi in the garage ", contact)
+	ts.NoError(err)
+	err = peer1.api.UpdatePeerNotes(peer2.PeerID(), strings.Repeat("a", 5000), contact)
+	ts.Error(err)
+	err = peer1.api.UpdatePeerNotes(peer1.PeerID(), "", contact)
+	ts.Error(err)
+
+	knownPeers, err := peer1.api.KnownPeers()
+	ts.NoError(err)
+	ts.Len(knownPeers, 1)
+	ts.Equal("raspberry pi in the garage", knownPeers[0].Notes)
+	ts.Equal(contact, knownPeers[0].Contact)
+	peerConfig, err := peer1.api.KnownPeerConfig(peer2.PeerID())
+	ts.NoError(err)
+	ts.Equal(contact, peerConfig.Contact)
+
+	result, err := peer1.api.Search("alice garage", 0)
+	ts.NoError(err)
+	ts.Len(result.Peers, 1)
+
+	// notes are local, the peer doesn't get them
+	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	ts.NoError(err)
+	ts.Empty(peer1Config.Notes)
+}
+
+func TestConnectionGater(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	peer3 := ts.newTestPeer(false)
+
+	err := peer1.api.UpdateConnectionGater(entity.UpdateConnectionGaterRequest{
+		OnlyKnownPeers: true,
+		Allowlist:      []string{peer2.PeerID()},
+	})
+	ts.NoError(err)
+	err = peer1.api.UpdateConnectionGater(entity.UpdateConnectionGaterRequest{Denylist: []string{"invalid"}})
+	ts.Error(err)
+	gaterConfig, err := peer1.api.ConnectionGater()
+	ts.NoError(err)
+	ts.True(gaterConfig.OnlyKnownPeers)
+	ts.Equal([]string{peer2.PeerID()}, gaterConfig.Allowlist)
+
+	// allowlisted peer sends friend request
+	ts.makeFriends(peer2, peer1)
+
+	// unknown peer can't
+	ts.ensurePeersAvailableInDHT(peer3, peer1)
+	err = peer3.api.SendFriendRequest(peer1.PeerID(), "peer_1", "")
+	ts.NoError(err)
+	time.Sleep(2 * time.Second)
+	authRequests, err := peer1.api.AuthRequests()
+	ts.NoError(err)
+	ts.Empty(authRequests)
+
+	// denied known peer is disconnected
+	err = peer1.api.UpdateConnectionGater(entity.UpdateConnectionGaterRequest{
+		OnlyKnownPeers: true,
+		Denylist:       []string{peer2.PeerID()},
+	})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		return !peer1.app.P2p.IsConnected(peer2.app.P2p.PeerID())
+	}, 5*time.Second, 50*time.Millisecond)
+	peer2.app.P2p.ClearBackoff(peer1.app.P2p.PeerID())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = peer2.app.P2p.ConnectPeer(ctx, peer1.app.P2p.PeerID())
+	ts.Error(err)
+	ts.Greater(peer1.app.P2p.RefusedConnections(), int64(0))
+}
+
+func TestClockSkew(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	peer2ID := peer2.app.P2p.PeerID()
+	peer2Config, exists := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.True(exists)
+	err := peer1.app.AuthStatus.ExchangeNewStatusInfo(context.Background(), peer2ID, peer2Config)
+	ts.NoError(err)
+	skew, ok := peer1.app.Clock.PeerSkew(peer2ID)
+	ts.True(ok)
+	ts.Less(skew.Offset.Abs(), time.Second)
+
+	peerInfo, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Empty(peerInfo.ClockWarnings)
+
+	peer1.app.Clock.UpdatePeerSkew(peer2ID, peer2Config.DisplayName(), -2*time.Minute)
+	knownPeers, err := peer1.api.KnownPeers()
+	ts.NoError(err)
+	ts.Len(knownPeers, 1)
+	ts.Equal(-2*time.Minute, knownPeers[0].ClockSkew)
+	peerInfo, err = peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.Equal([]string{"clock is 2m0s ahead from peer peer_1"}, peerInfo.ClockWarnings)
+}
+
+func TestWatchdog(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer := ts.newTestPeer(false)
+	statuses := peer.app.Watchdog.Status()
+	names := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		ts.True(status.Healthy)
+		names = append(names, status.Name)
+	}
+	ts.Contains(names, "p2p host")
+	ts.Contains(names, "api server")
+
+	// api server is restarted on the same address
+	address := peer.app.Api.Address()
+	ts.NoError(peer.app.Api.Restart())
+	ts.Equal(address, peer.app.Api.Address())
+	_, err := peer.api.PeerInfo()
+	ts.NoError(err)
+
+	watchdog := NewWatchdog()
+	failed := true
+	var restarts int
+	watchdog.Watch("test", func() bool {
+		return failed
+	}, func() error {
+		restarts++
+		return errors.New("restart failed")
+	})
+	ctx := context.Background()
+	now := time.Now()
+	watchdog.check(ctx, now)
+	ts.Equal(1, restarts)
+	status := watchdog.Status()[0]
+	ts.False(status.Healthy)
+	ts.Equal("restart failed", status.LastError)
+
+	// restarts are retried with backoff
+	watchdog.check(ctx, now.Add(watchdogMinBackoff/2))
+	ts.Equal(1, restarts)
+	watchdog.check(ctx, now.Add(watchdogMinBackoff))
+	ts.Equal(2, restarts)
+	watchdog.check(ctx, now.Add(2*watchdogMinBackoff))
+	ts.Equal(2, restarts)
+	watchdog.check(ctx, now.Add(3*watchdogMinBackoff))
+	ts.Equal(3, restarts)
+
+	failed = false
+	watchdog.check(ctx, now.Add(4*watchdogMinBackoff))
+	ts.Equal(3, restarts)
+	status = watchdog.Status()[0]
+	ts.True(status.Healthy)
+	ts.Equal(3, status.Restarts)
+
+	// subsystems aren't restarted after the app is closed
+	failed = true
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	watchdog.check(ctx, now.Add(5*watchdogMaxBackoff))
+	ts.Equal(3, restarts)
+}
+
+func TestGRPCAPI(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	certFile, keyFile := writeTestCertificate(t)
+	peer1 := ts.newTestPeerWithConfig(false, func(conf *config.Config) {
+		conf.GRPC = config.GRPCConfig{
+			ListenAddress: "127.0.0.1:0",
+			CertFile:      certFile,
+			KeyFile:       keyFile,
+			ClientCAFile:  certFile,
+		}
+	})
+	peer2 := ts.newTestPeer(false)
+	ts.Equal("", peer2.app.Api.GRPCAddress())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	ts.NoError(err)
+	certData, err := os.ReadFile(certFile)
+	ts.NoError(err)
+	pool := x509.NewCertPool()
+	ts.True(pool.AppendCertsFromPEM(certData))
+	dial := func(certificates []tls.Certificate) *grpc.ClientConn {
+		conn, err := grpc.Dial(peer1.app.Api.GRPCAddress(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      pool,
+			Certificates: certificates,
+		})))
+		ts.NoError(err)
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		return conn
+	}
+
+	// clients without certificate are rejected
+	_, err = apipb.NewAwlClient(dial(nil)).GetMyPeerInfo(ctx, &emptypb.Empty{})
+	ts.Error(err)
+
+	conn := dial([]tls.Certificate{cert})
+	reflectionStream, err := grpc_reflection_v1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	ts.NoError(err)
+	ts.NoError(reflectionStream.Send(&grpc_reflection_v1alpha.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1alpha.ServerReflectionRequest_ListServices{},
+	}))
+	reflectionResp, err := reflectionStream.Recv()
+	ts.NoError(err)
+	var services []string
+	for _, service := range reflectionResp.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	ts.Contains(services, "awl.v1.Awl")
+
+	client := apipb.NewAwlClient(conn)
+	info, err := client.GetMyPeerInfo(ctx, &emptypb.Empty{})
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), info.GetPeerId())
+	_, err = client.GetStats(ctx, &emptypb.Empty{})
+	ts.NoError(err)
+
+	// api networks are applied to grpc too
+	peer1.app.Conf.Lock()
+	peer1.app.Conf.APIAuth.DeniedNetworks = []string{"127.0.0.0/8"}
+	peer1.app.Conf.Unlock()
+	_, err = client.GetMyPeerInfo(ctx, &emptypb.Empty{})
+	ts.Equal(codes.PermissionDenied, status.Code(err))
+	reflectionStream, err = grpc_reflection_v1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	ts.NoError(err)
+	_, err = reflectionStream.Recv()
+	ts.Equal(codes.PermissionDenied, status.Code(err))
+	peer1.app.Conf.Lock()
+	peer1.app.Conf.APIAuth.DeniedNetworks = nil
+	peer1.app.Conf.Unlock()
+
+	_, err = client.SendFriendRequest(ctx, &apipb.FriendRequest{PeerId: peer2.PeerID(), Alias: " "})
+	ts.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = client.GetPeer(ctx, &apipb.PeerRequest{PeerId: peer2.PeerID()})
+	ts.Equal(codes.NotFound, status.Code(err))
+
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+	_, err = client.SendFriendRequest(ctx, &apipb.FriendRequest{PeerId: peer2.PeerID(), Alias: "peer_2"})
+	ts.NoError(err)
+	var authRequests []entity.AuthRequest
+	ts.Eventually(func() bool {
+		authRequests, err = peer2.api.AuthRequests()
+		ts.NoError(err)
+		return len(authRequests) == 1
+	}, 15*time.Second, 50*time.Millisecond)
+	ts.NoError(peer2.api.ReplyFriendRequest(authRequests[0].PeerID, "peer_1", "", false))
+
+	ts.Eventually(func() bool {
+		peers, err := client.ListPeers(ctx, &emptypb.Empty{})
+		ts.NoError(err)
+		return len(peers.GetPeers()) == 1 && peers.GetPeers()[0].GetConfirmed()
+	}, 15*time.Second, 50*time.Millisecond)
+
+	_, err = client.UpdatePeerSettings(ctx, &apipb.UpdatePeerSettingsRequest{PeerId: peer2.PeerID(), Alias: "renamed", DomainName: "renamed"})
+	ts.NoError(err)
+	knownPeer, err := client.GetPeer(ctx, &apipb.PeerRequest{PeerId: peer2.PeerID()})
+	ts.NoError(err)
+	ts.Equal("renamed", knownPeer.GetAlias())
+
+	_, err = client.RemovePeer(ctx, &apipb.PeerRequest{PeerId: peer2.PeerID()})
+	ts.NoError(err)
+	blocked, err := client.ListBlockedPeers(ctx, &emptypb.Empty{})
+	ts.NoError(err)
+	ts.Len(blocked.GetPeers(), 1)
+	ts.Equal(peer2.PeerID(), blocked.GetPeers()[0].GetPeerId())
+}
+
+func TestAPIAuth(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	certFile, keyFile := writeTestCertificate(t)
+	peer := ts.newTestPeerWithConfig(false, func(conf *config.Config) {
+		conf.APIAuth.CertFile = certFile
+		conf.APIAuth.KeyFile = keyFile
+	})
+	certData, err := os.ReadFile(certFile)
+	ts.NoError(err)
+	pool := x509.NewCertPool()
+	ts.True(pool.AppendCertsFromPEM(certData))
+	newClient := func(apiKey string) *apiclient.Client {
+		client := apiclient.New(peer.app.Api.Address())
+		client.SetTLSConfig(&tls.Config{RootCAs: pool})
+		client.SetAPIKey(apiKey)
+		return client
+	}
+
+	// api doesn't require keys until the first one is created
+	adminKey, err := newClient("").CreateAPIKey("admin", config.APIKeyPermissionAdmin)
+	ts.NoError(err)
+	readKey, err := newClient("").CreateAPIKey("monitoring", config.APIKeyPermissionRead)
+	ts.NoError(err)
+	_, err = newClient(adminKey).CreateAPIKey("monitoring", config.APIKeyPermissionRead)
+	ts.EqualError(err, "api key with this name already exists")
+	// localhost is trusted by default
+	_, err = newClient("").PeerInfo()
+	ts.NoError(err)
+
+	peer.app.Conf.Lock()
+	peer.app.Conf.APIAuth.RequireOnLocalhost = true
+	peer.app.Conf.Unlock()
+	_, err = newClient("").PeerInfo()
+	ts.EqualError(err, "api key is required")
+	_, err = newClient("invalid").PeerInfo()
+	ts.EqualError(err, "invalid api key")
+
+	_, err = newClient(readKey).PeerInfo()
+	ts.NoError(err)
+	_, err = newClient(readKey).APIKeys()
+	ts.EqualError(err, "api key is read-only")
+	err = newClient(readKey).RemoveAPIKey("admin")
+	ts.EqualError(err, "api key is read-only")
+
+	keys, err := newClient(adminKey).APIKeys()
+	ts.NoError(err)
+	ts.Len(keys, 2)
+	ts.NoError(newClient(adminKey).RemoveAPIKey("monitoring"))
+	_, err = newClient(readKey).PeerInfo()
+	ts.EqualError(err, "invalid api key")
+
+	// keys are passed to websocket too
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = newClient(adminKey).Events(ctx)
+	ts.NoError(err)
+}
+
+func TestGRPCAPIKeys(t *testing.T) {
+	ts := NewTestSuite(t)
+	peer := ts.newTestPeerWithConfig(false, func(conf *config.Config) {
+		conf.GRPC = config.GRPCConfig{ListenAddress: "127.0.0.1:0"}
+	})
+	adminKey, err := peer.api.CreateAPIKey("admin", config.APIKeyPermissionAdmin)
+	ts.NoError(err)
+	readKey, err := peer.api.CreateAPIKey("monitoring", config.APIKeyPermissionRead)
+	ts.NoError(err)
+
+	conn, err := grpc.Dial(peer.app.Api.GRPCAddress(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	ts.NoError(err)
+	defer conn.Close()
+	client := apipb.NewAwlClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+key)
+	}
+
+	// localhost is trusted by default
+	_, err = client.GetMyPeerInfo(ctx, &emptypb.Empty{})
+	ts.NoError(err)
+
+	peer.app.Conf.Lock()
+	peer.app.Conf.APIAuth.RequireOnLocalhost = true
+	peer.app.Conf.Unlock()
+	_, err = client.GetMyPeerInfo(ctx, &emptypb.Empty{})
+	ts.Equal(codes.Unauthenticated, status.Code(err))
+	_, err = client.GetMyPeerInfo(withKey("invalid"), &emptypb.Empty{})
+	ts.Equal(codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetMyPeerInfo(withKey(readKey), &emptypb.Empty{})
+	ts.NoError(err)
+	reflectionStream, err := grpc_reflection_v1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(withKey(readKey))
+	ts.NoError(err)
+	ts.NoError(reflectionStream.Send(&grpc_reflection_v1alpha.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1alpha.ServerReflectionRequest_ListServices{},
+	}))
+	_, err = reflectionStream.Recv()
+	ts.NoError(err)
+	_, err = client.RemovePeer(withKey(readKey), &apipb.PeerRequest{})
+	ts.Equal(codes.PermissionDenied, status.Code(err))
+	// admin keys pass to the handler, which validates the request
+	_, err = client.RemovePeer(withKey(adminKey), &apipb.PeerRequest{})
+	ts.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestAPINetworks(t *testing.T) {
+	ts := NewTestSuite(t)
+	peer := ts.newTestPeer(false)
+	setNetworks := func(allowed, denied []string) {
+		peer.app.Conf.Lock()
+		peer.app.Conf.APIAuth.AllowedNetworks = allowed
+		peer.app.Conf.APIAuth.DeniedNetworks = denied
+		peer.app.Conf.Unlock()
+	}
+
+	setNetworks([]string{"10.66.0.0/16"}, nil)
+	_, err := peer.api.PeerInfo()
+	ts.EqualError(err, "address is not allowed")
+
+	setNetworks([]string{"10.66.0.0/16", "127.0.0.0/8"}, nil)
+	_, err = peer.api.PeerInfo()
+	ts.NoError(err)
+
+	setNetworks([]string{"127.0.0.0/8"}, []string{"127.0.0.1/32"})
+	_, err = peer.api.PeerInfo()
+	ts.EqualError(err, "address is not allowed")
+
+	setNetworks([]string{"invalid"}, nil)
+	ts.Error(peer.app.Api.Restart())
+}
+
+func TestBootstrapPeers(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	peers, err := peer1.api.BootstrapPeers()
+	ts.NoError(err)
+	ts.Len(peers, len(ts.bootstrapAddrsStr))
+
+	address := fmt.Sprintf("%s/p2p/%s", peer2.app.P2p.Host().Addrs()[0], peer2.PeerID())
+	testResult, err := peer1.api.TestBootstrapPeer(address)
+	ts.NoError(err)
+	ts.Empty(testResult.Error)
+	ts.Equal(peer2.PeerID(), testResult.PeerID)
+	_, err = peer1.api.TestBootstrapPeer("invalid")
+	ts.Error(err)
+
+	bootstrapPeersCount := len(peer1.app.P2p.BootstrapPeers())
+	ts.NoError(peer1.api.AddBootstrapPeer(address))
+	ts.EqualError(peer1.api.AddBootstrapPeer(address), "bootstrap peer has already been added")
+	// bootstrap peers are applied without restart
+	ts.Len(peer1.app.P2p.BootstrapPeers(), bootstrapPeersCount+1)
+	ts.Eventually(func() bool {
+		peers, err = peer1.api.BootstrapPeers()
+		ts.NoError(err)
+		return len(peers) == len(ts.bootstrapAddrsStr)+1 && peers[len(peers)-1].Connected
+	}, 15*time.Second, 50*time.Millisecond)
+	ts.Equal(address, peers[len(peers)-1].Address)
+
+	ts.NoError(peer1.api.RemoveBootstrapPeer(address))
+	ts.Error(peer1.api.RemoveBootstrapPeer(address))
+	ts.Len(peer1.app.P2p.BootstrapPeers(), bootstrapPeersCount)
+	// config of other peers isn't affected
+	ts.Equal(ts.bootstrapAddrsStr, peer2.app.Conf.P2pNode.BootstrapPeers)
+}
+
+// writeTestCertificate writes self-signed certificate for 127.0.0.1, which is also used as CA of client certificates.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "awl test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestScheduledJobs(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	job := config.ScheduledJob{
+		Name:            "nightly-backup",
+		PeerID:          peer2.PeerID(),
+		Time:            time.Now().Add(2 * time.Minute).Format(config.ScheduledJobTimeLayout),
+		Action:          config.ScheduledJobActionBackup,
+		DurationMinutes: 30,
+	}
+	invalidJob := job
+	invalidJob.Time = "25:00"
+	err := peer1.api.UpdateScheduledJobs([]config.ScheduledJob{invalidJob})
+	ts.Error(err)
+	err = peer1.api.UpdateScheduledJobs([]config.ScheduledJob{job, job})
+	ts.Error(err)
+	err = peer1.api.UpdateScheduledJobs([]config.ScheduledJob{job})
+	ts.NoError(err)
+	jobs, err := peer1.api.ScheduledJobs()
+	ts.NoError(err)
+	ts.Equal([]config.ScheduledJob{job}, jobs)
 
-	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer")
+	// job time is within pre-warm window, so connection is pinned right away
+	ts.Eventually(func() bool {
+		reports, err := peer1.api.ScheduledJobReports()
+		ts.NoError(err)
+		return len(reports) == 1 && reports[0].Probes > 0
+	}, 10*time.Second, 100*time.Millisecond)
+	reports, err := peer1.api.ScheduledJobReports()
 	ts.NoError(err)
+	ts.Equal(job.Name, reports[0].Job)
+	ts.True(reports[0].WindowEnd.IsZero())
+	ts.Equal(reports[0].Probes, reports[0].ConnectedProbes)
+	ts.True(peer1.app.P2p.IsConnected(peer2.app.P2p.PeerID()))
+}
 
-	time.Sleep(200 * time.Millisecond)
+func TestSpeedTest(t *testing.T) {
+	ts := NewTestSuite(t)
 
-	err = peer1.api.SendFriendRequest(peer3.PeerID(), "peer")
-	ts.EqualError(err, api.ErrorPeerAliasIsNotUniq)
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	result, err := peer1.api.RunSpeedTest(peer2.PeerID(), 1)
+	ts.NoError(err)
+	ts.Equal(peer2.PeerID(), result.PeerID)
+	ts.Positive(result.Throughput)
+	ts.Positive(result.Upload)
+	ts.Positive(result.Download)
+	ts.Positive(result.MinRTT)
+	ts.Positive(result.LoadedRTT)
+	ts.Positive(result.Bytes)
+	ts.False(result.ThroughRelay)
+	_, err = peer1.api.RunSpeedTest(peer1.PeerID(), 0)
+	ts.Error(err)
+	_, err = peer1.api.RunSpeedTest(peer2.PeerID(), protocol.MaxSpeedTestSeconds+1)
+	ts.Error(err)
+
+	// the job time is now, so the test is run right away
+	job := config.ScheduledJob{
+		Name:            "nightly-speedtest",
+		PeerID:          peer2.PeerID(),
+		Time:            time.Now().Format(config.ScheduledJobTimeLayout),
+		Action:          config.ScheduledJobActionSpeedTest,
+		DurationMinutes: 1,
+	}
+	err = peer1.api.UpdateScheduledJobs([]config.ScheduledJob{job})
+	ts.NoError(err)
+	ts.Eventually(func() bool {
+		history, err := peer1.api.SpeedTestHistory(peer2.PeerID(), time.Time{}, time.Time{})
+		ts.NoError(err)
+		return len(history.Results) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+
+	history, err := peer1.api.SpeedTestHistory("", time.Now().Add(-time.Hour), time.Time{})
+	ts.NoError(err)
+	ts.Len(history.Results, 2)
+	ts.Len(history.Trends, 1)
+	ts.Equal(2, history.Trends[0].Tests)
+	ts.Zero(history.Trends[0].Failed)
+	ts.Positive(history.Trends[0].MinThroughput)
+
+	history, err = peer1.api.SpeedTestHistory("", time.Now().Add(time.Hour), time.Time{})
+	ts.NoError(err)
+	ts.Empty(history.Results)
 }
 
-func TestUpdateUseAsExitNodeConfig(t *testing.T) {
+func TestStatsSnapshot(t *testing.T) {
 	ts := NewTestSuite(t)
 
 	peer1 := ts.newTestPeer(false)
@@ -189,58 +2590,253 @@ func TestUpdateUseAsExitNodeConfig(t *testing.T) {
 
 	ts.makeFriends(peer2, peer1)
 
-	peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+	stats, err := peer1.api.StatsSnapshot()
 	ts.NoError(err)
-	ts.Equal(false, peer1Config.AllowedUsingAsExitNode)
+	ts.Equal(p2p.StatsSnapshotVersion, stats.Version)
+	ts.GreaterOrEqual(stats.Connections.ConnectedPeersCount, 1)
+	ts.Equal(len(ts.bootstrapAddrs), stats.Bootstrap.TotalCount)
 
-	// allow, check that peer1 got our config
-	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
-		PeerID:               peer1.PeerID(),
-		Alias:                peer1Config.Alias,
-		DomainName:           peer1Config.DomainName,
-		AllowUsingAsExitNode: true,
-	})
+	_, err = peer1.api.EchoPeer(peer2.PeerID(), 1024)
 	ts.NoError(err)
+	ts.Eventually(func() bool {
+		history := peer1.app.P2p.StreamHistoryStats()[protocol.ProbeMethod]
+		return history.OpenedOutbound == 1 && history.Closed == 1
+	}, 15*time.Second, 50*time.Millisecond)
+	stats, err = peer2.api.StatsSnapshot()
+	ts.NoError(err)
+	ts.EqualValues(1, stats.Streams.History[protocol.ProbeMethod].OpenedInbound)
+}
 
-	var peer2Config *config.KnownPeer
+func TestTrafficCategories(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+
+	ts.makeFriends(peer2, peer1)
+
+	const packetSize = 1000
+	const packetsCount = 100
+	peer2.tun.ReferenceInboundPacketLen = packetSize
+	packet := testPacket(packetSize)
+	for i := 0; i < packetsCount; i++ {
+		peer1.tun.Outbound <- packet
+	}
 	ts.Eventually(func() bool {
-		peer2Config, err = peer1.api.KnownPeerConfig(peer2.PeerID())
+		return peer2.tun.InboundCount() == packetsCount
+	}, 5*time.Second, 50*time.Millisecond)
+
+	var knownPeer entity.KnownPeersResponse
+	ts.Eventually(func() bool {
+		knownPeers, err := peer1.api.KnownPeers()
 		ts.NoError(err)
+		ts.Len(knownPeers, 1)
+		knownPeer = knownPeers[0]
+		return knownPeer.NetworkStatsByCategory[p2p.TrafficVPN].TotalOut >= packetsCount*packetSize
+	}, 5*time.Second, 100*time.Millisecond)
+	byCategory := knownPeer.NetworkStatsByCategory
+	ts.Len(byCategory, len(p2p.TrafficCategories))
+	// auth and status protocols
+	ts.Greater(byCategory[p2p.TrafficControl].TotalOut, int64(0))
+	ts.Zero(byCategory[p2p.TrafficForwarding].TotalOut)
+	ts.Zero(byCategory[p2p.TrafficServices].TotalOut)
+	ts.Contains(knownPeer.NetworkStatsByCategoryInIECUnits, p2p.TrafficVPN)
 
-		return peer2Config.AllowedUsingAsExitNode
-	}, 15*time.Second, 100*time.Millisecond)
+	ts.Eventually(func() bool {
+		stats := peer1.app.P2p.StatsSnapshot()
+		return stats.Bandwidth.ByCategory[p2p.TrafficVPN].TotalOut >= packetsCount*packetSize
+	}, 5*time.Second, 100*time.Millisecond)
+}
 
-	// allow from peer1, check that peer2 got our config
-	err = peer1.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
-		PeerID:               peer2.PeerID(),
-		Alias:                peer2Config.Alias,
-		DomainName:           peer2Config.DomainName,
-		AllowUsingAsExitNode: true,
-	})
+func TestDHTRoutingTable(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer1, peer2)
+
+	table, err := peer1.api.DHTRoutingTable()
+	ts.NoError(err)
+	ts.Equal(peer1.PeerID(), table.LocalPeerID)
+	ts.Equal(peer1.app.P2p.RoutingTableSize(), table.Size)
+	peersCount := 0
+	for _, bucket := range table.Buckets {
+		peersCount += len(bucket.Peers)
+	}
+	ts.Equal(table.Size, peersCount)
+}
+
+func TestPeerDiagnostics(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
+
+	diagnostics, err := peer1.api.PeerDiagnostics(peer2.PeerID())
 	ts.NoError(err)
+	ts.Equal(peer2.PeerID(), diagnostics.PeerID)
+	ts.True(diagnostics.Connected)
+	ts.Equal(p2p.ConnectionTypeDirect, diagnostics.ConnectionType)
+	ts.True(diagnostics.LocalConnection)
+	ts.NotEmpty(diagnostics.Connections)
+	ts.NotEmpty(diagnostics.PeerstoreAddrs)
+	ts.NotEmpty(diagnostics.UserAgent)
+	// connected peer is not dialed
+	ts.Nil(diagnostics.Dial)
+
+	_, err = peer1.api.PeerDiagnostics("invalid")
+	ts.Error(err)
+	_, err = peer1.api.PeerDiagnostics(peer1.PeerID())
+	ts.Error(err)
+}
+
+func TestExportUsage(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.makeFriends(peer2, peer1)
 
+	var rows [][]string
 	ts.Eventually(func() bool {
-		peer1Config, err := peer2.api.KnownPeerConfig(peer1.PeerID())
+		data, err := peer1.api.ExportUsage(entity.ExportUsageRequest{Type: "traffic", From: time.Now().Add(-time.Hour)})
 		ts.NoError(err)
+		rows, err = csv.NewReader(bytes.NewReader(data)).ReadAll()
+		ts.NoError(err)
+		return len(rows) == 2 && rows[1][4] != "0"
+	}, 5*time.Second, 100*time.Millisecond)
+	ts.Equal([]string{"hour_start", "peer_id", "peer_name", "bytes_in", "bytes_out", "connected_seconds"}, rows[0])
+	ts.Equal(peer2.PeerID(), rows[1][1])
+	knownPeer, _ := peer1.app.Conf.GetPeer(peer2.PeerID())
+	ts.Equal(knownPeer.DisplayName(), rows[1][2])
+
+	data, err := peer1.api.ExportUsage(entity.ExportUsageRequest{Type: "connections"})
+	ts.NoError(err)
+	rows, err = csv.NewReader(bytes.NewReader(data)).ReadAll()
+	ts.NoError(err)
+	ts.Len(rows, 2)
+	ts.Equal(peer2.PeerID(), rows[1][0])
+	// still connected
+	ts.Empty(rows[1][3])
 
-		return peer1Config.AllowedUsingAsExitNode && peer1Config.WeAllowUsingAsExitNode
-	}, 15*time.Second, 100*time.Millisecond)
+	data, err = peer1.api.ExportUsage(entity.ExportUsageRequest{Type: "traffic", To: time.Now().Add(-2 * time.Hour)})
+	ts.NoError(err)
+	rows, err = csv.NewReader(bytes.NewReader(data)).ReadAll()
+	ts.NoError(err)
+	ts.Len(rows, 1)
 
-	// disallow from peer2, check that peer1 got our new config
-	err = peer2.api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
-		PeerID:               peer1.PeerID(),
-		Alias:                peer1Config.Alias,
-		DomainName:           peer1Config.DomainName,
-		AllowUsingAsExitNode: false,
+	_, err = peer1.api.ExportUsage(entity.ExportUsageRequest{Type: "unknown"})
+	ts.Error(err)
+}
+
+func TestLogEntries(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer := ts.newTestPeer(false)
+
+	all, err := peer.api.LogEntries(entity.LogEntriesRequest{})
+	ts.NoError(err)
+	ts.NotEmpty(all.Entries)
+	ts.Equal(len(all.Entries), all.Total)
+
+	page, err := peer.api.LogEntries(entity.LogEntriesRequest{LogQuery: entity.LogQuery{Logger: "awl"}, Offset: 1, Limit: 1})
+	ts.NoError(err)
+	ts.Len(page.Entries, 1)
+	ts.Equal(1, page.Offset)
+	ts.Contains(page.Entries[0].Logger, "awl")
+
+	future, err := peer.api.LogEntries(entity.LogEntriesRequest{At: time.Now().Add(time.Hour)})
+	ts.NoError(err)
+	ts.Empty(future.Entries)
+	ts.Equal(future.Total, future.Offset)
+
+	_, err = peer.api.LogEntries(entity.LogEntriesRequest{LogQuery: entity.LogQuery{Level: "unknown"}})
+	ts.ErrorContains(err, "invalid log level")
+
+	logs, err := peer.api.DownloadLog(entity.LogQuery{From: time.Now().Add(-time.Hour), Logger: "awl"})
+	ts.NoError(err)
+	ts.Contains(string(logs), "Anywherelan")
+}
+
+func TestEmbeddingAPI(t *testing.T) {
+	ts := NewTestSuite(t)
+
+	peer1 := ts.newTestPeer(false)
+	peer2 := ts.newTestPeer(false)
+	ts.ensurePeersAvailableInDHT(peer2, peer1)
+
+	events := make(chan interface{}, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	peer1.app.SubscribeEvents(ctx, func(evt interface{}) {
+		events <- evt
 	})
+
+	err := peer2.api.SendFriendRequest(peer1.PeerID(), "peer_1", "")
 	ts.NoError(err)
+	ts.Eventually(func() bool {
+		return len(peer1.app.Status().AuthRequests) == 1
+	}, 15*time.Second, 50*time.Millisecond)
+	ts.Equal(peer2.PeerID(), peer1.app.Status().AuthRequests[0].PeerID)
+	ts.NoError(peer1.app.AcceptPeer(peer2.PeerID(), "peer_2"))
+	ts.Eventually(func() bool {
+		peers := peer1.app.Status().Peers
+		return len(peers) == 1 && peers[0].Connected && peers[0].Confirmed
+	}, 15*time.Second, 50*time.Millisecond)
+	status := peer1.app.Status()
+	ts.Equal("peer_2", status.Peers[0].DisplayName)
+	ts.Empty(status.AuthRequests)
+	ts.ErrorContains(peer1.app.DeclinePeer(peer2.PeerID()), "already added")
 
+	const packetSize = 100
+	peer1.tun.ReferenceInboundPacketLen = packetSize
+	peer2.tun.ReferenceInboundPacketLen = packetSize
+	sendPacket := func() {
+		select {
+		case peer2.tun.Outbound <- testPacket(packetSize):
+		case <-time.After(time.Second):
+			ts.Fail("packet was not read")
+		}
+	}
+	sendPacket()
 	ts.Eventually(func() bool {
-		peer2Config, err := peer1.api.KnownPeerConfig(peer2.PeerID())
-		ts.NoError(err)
+		return peer1.tun.InboundCount() == 1
+	}, 5*time.Second, 50*time.Millisecond)
 
-		return !peer2Config.AllowedUsingAsExitNode && peer2Config.WeAllowUsingAsExitNode
-	}, 15*time.Second, 100*time.Millisecond)
+	err = peer1.api.UpdateVPNPause(true)
+	ts.NoError(err)
+	peerInfo, err := peer1.api.PeerInfo()
+	ts.NoError(err)
+	ts.True(peerInfo.VPNPaused)
+	status = peer1.app.Status()
+	ts.True(status.VPNPaused)
+	ts.False(status.VPNUp)
+	ts.Eventually(func() bool {
+		for {
+			select {
+			case evt := <-events:
+				if state, ok := evt.(awlevent.VPNInterfaceStateChanged); ok {
+					return !state.Up && state.Paused
+				}
+			default:
+				return false
+			}
+		}
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// connections to peers are kept, but traffic of the interface is dropped
+	sendPacket()
+	time.Sleep(500 * time.Millisecond)
+	ts.EqualValues(1, peer1.tun.InboundCount())
+	ts.True(peer1.app.P2p.IsConnected(peer2.app.P2p.PeerID()))
+
+	ts.NoError(peer1.app.ResumeVPN())
+	ts.False(peer1.app.Status().VPNPaused)
+	sendPacket()
+	ts.Eventually(func() bool {
+		return peer1.tun.InboundCount() == 2
+	}, 5*time.Second, 50*time.Millisecond)
 }
 
 func TestTunnelPackets(t *testing.T) {
@@ -260,6 +2856,10 @@ func TestTunnelPackets(t *testing.T) {
 
 	peer1.tun.ReferenceInboundPacketLen = packetSize
 	peer2.tun.ReferenceInboundPacketLen = packetSize
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pathEvents, err := peer1.api.Events(ctx, "VPNPathChanged")
+	ts.NoError(err)
 
 	wg := &sync.WaitGroup{}
 
@@ -287,6 +2887,38 @@ func TestTunnelPackets(t *testing.T) {
 	received2 := peer2.tun.InboundCount()
 	ts.EqualValues(packetsCount, received1)
 	ts.EqualValues(packetsCount, received2)
+
+	// stream is reopened after maxPacketsPerStream, but the path stays up
+	var paths []awlevent.VPNPathChanged
+	for receiving := true; receiving; {
+		select {
+		case evt := <-pathEvents:
+			path := awlevent.VPNPathChanged{}
+			ts.NoError(json.Unmarshal(evt.Data, &path))
+			paths = append(paths, path)
+		case <-time.After(500 * time.Millisecond):
+			receiving = false
+		}
+	}
+	ts.Equal([]awlevent.VPNPathChanged{{PeerID: peer2.PeerID(), Up: true, ConnectionType: p2p.ConnectionTypeDirect}}, paths)
+
+	flows, err := peer1.api.Flows()
+	ts.NoError(err)
+	ts.Len(flows, 2)
+	for _, flow := range flows {
+		ts.Equal("udp", flow.Protocol)
+		ts.Equal(peer2.PeerID(), flow.PeerID)
+		if flow.Outbound {
+			ts.Equal("10.66.0.1:43472", flow.LocalAddr)
+			ts.Equal("10.66.0.2:9090", flow.RemoteAddr)
+			ts.EqualValues(packetsCount, flow.PacketsOut)
+			ts.EqualValues(packetsCount*packetSize, flow.BytesOut)
+		} else {
+			ts.Equal("10.66.0.1:9090", flow.LocalAddr)
+			ts.Equal("10.66.0.2:43472", flow.RemoteAddr)
+			ts.EqualValues(packetsCount, flow.PacketsIn)
+		}
+	}
 }
 
 func BenchmarkTunnelPackets(b *testing.B) {
@@ -326,6 +2958,63 @@ func BenchmarkTunnelPackets(b *testing.B) {
 	}
 }
 
+func testPacketWithAddrs(src, dst net.IP) []byte {
+	packet := vpn.Packet{}
+	_, err := packet.ReadFrom(bytes.NewReader(testPacket(0)))
+	if err != nil {
+		panic(err)
+	}
+	packet.Parse()
+	copy(packet.Src, src.To4())
+	copy(packet.Dst, dst.To4())
+	packet.RecalculateChecksum()
+
+	return packet.Packet
+}
+
+func testPacketWithPorts(src, dst uint16) []byte {
+	packet := vpn.Packet{}
+	_, err := packet.ReadFrom(bytes.NewReader(testPacket(0)))
+	if err != nil {
+		panic(err)
+	}
+	packet.Parse()
+	binary.BigEndian.PutUint16(packet.Packet[20:22], src)
+	binary.BigEndian.PutUint16(packet.Packet[22:24], dst)
+	packet.RecalculateChecksum()
+
+	return packet.Packet
+}
+
+// testTCPSynPacket returns TCP SYN from 10.66.0.1 to 10.66.0.2 with MSS option.
+func testTCPSynPacket(mss uint16) []byte {
+	data := make([]byte, 20+24)
+	data[0] = 0x45
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	data[8] = 64
+	data[9] = 6
+	copy(data[12:16], net.IPv4(10, 66, 0, 1).To4())
+	copy(data[16:20], net.IPv4(10, 66, 0, 2).To4())
+	tcp := data[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], 43472)
+	binary.BigEndian.PutUint16(tcp[2:4], 22)
+	tcp[12] = 6 << 4
+	tcp[13] = 0x02
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	tcp[20], tcp[21] = 2, 4
+	binary.BigEndian.PutUint16(tcp[22:24], mss)
+
+	packet := vpn.Packet{}
+	_, err := packet.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	packet.Parse()
+	packet.RecalculateChecksum()
+
+	return packet.Packet
+}
+
 func testPacket(length int) []byte {
 	data, err := hex.DecodeString("4500002828f540004011fd490a4200010a420002a9d0238200148bfd68656c6c6f20776f726c6421")
 	if err != nil {
@@ -374,13 +3063,14 @@ func NewTestSuite(t testing.TB) *TestSuite {
 }
 
 type testPeer struct {
-	app *Application
-	api *apiclient.Client
-	tun *TestTUN
+	app       *Application
+	api       *apiclient.Client
+	tun       *TestTUN
+	closeOnce *sync.Once
 }
 
 func (tp testPeer) Close() {
-	tp.app.Close()
+	tp.closeOnce.Do(tp.app.Close)
 }
 
 func (tp testPeer) PeerID() string {
@@ -388,6 +3078,11 @@ func (tp testPeer) PeerID() string {
 }
 
 func (ts *TestSuite) newTestPeer(disableLogging bool) testPeer {
+	return ts.newTestPeerWithConfig(disableLogging, nil)
+}
+
+// newTestPeerWithConfig calls updateConfig before the peer is started.
+func (ts *TestSuite) newTestPeerWithConfig(disableLogging bool, updateConfig func(conf *config.Config)) testPeer {
 	tempDir := ts.t.TempDir()
 	ts.t.Setenv(config.AppDataDirEnvKey, tempDir)
 	tempConf := config.NewConfig(eventbus.NewBus())
@@ -397,6 +3092,18 @@ func (ts *TestSuite) newTestPeer(disableLogging bool) testPeer {
 	}
 	tempConf.Save()
 
+	return ts.startTestPeer(disableLogging, updateConfig)
+}
+
+// restartTestPeer closes the peer and starts it again with the same data directory.
+func (ts *TestSuite) restartTestPeer(tp testPeer) testPeer {
+	tp.Close()
+	ts.t.Setenv(config.AppDataDirEnvKey, tp.app.Conf.DataDir())
+	return ts.startTestPeer(false, nil)
+}
+
+// startTestPeer starts the peer with config from config.AppDataDirEnvKey directory.
+func (ts *TestSuite) startTestPeer(disableLogging bool, updateConfig func(conf *config.Config)) testPeer {
 	app := New()
 	app.SetupLoggerAndConfig()
 	if disableLogging {
@@ -411,15 +3118,20 @@ func (ts *TestSuite) newTestPeer(disableLogging bool) testPeer {
 		multiaddr.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"),
 	})
 	app.Conf.P2pNode.BootstrapPeers = ts.bootstrapAddrsStr
+	app.Conf.Clock.DisableNTP = true
+	if updateConfig != nil {
+		updateConfig(app.Conf)
+	}
 
 	testTUN := NewTestTUN()
 	err := app.Init(context.Background(), testTUN.TUN())
 	ts.NoError(err)
 
 	tp := testPeer{
-		app: app,
-		api: apiclient.New(app.Api.Address()),
-		tun: testTUN,
+		app:       app,
+		api:       apiclient.New(app.Api.Address()),
+		tun:       testTUN,
+		closeOnce: &sync.Once{},
 	}
 
 	ts.t.Cleanup(func() {
@@ -492,7 +3204,7 @@ func (ts *TestSuite) ensurePeersAvailableInDHT(peer1, peer2 testPeer) {
 
 func (ts *TestSuite) makeFriends(peer1, peer2 testPeer) {
 	ts.ensurePeersAvailableInDHT(peer1, peer2)
-	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2")
+	err := peer1.api.SendFriendRequest(peer2.PeerID(), "peer_2", "")
 	ts.NoError(err)
 
 	var authRequests []entity.AuthRequest
@@ -501,7 +3213,7 @@ func (ts *TestSuite) makeFriends(peer1, peer2 testPeer) {
 		ts.NoError(err)
 		return len(authRequests) == 1
 	}, 15*time.Second, 50*time.Millisecond)
-	err = peer2.api.ReplyFriendRequest(authRequests[0].PeerID, "peer_1", false)
+	err = peer2.api.ReplyFriendRequest(authRequests[0].PeerID, "peer_1", "", false)
 	ts.NoError(err)
 
 	time.Sleep(500 * time.Millisecond)
@@ -518,6 +3230,8 @@ func (ts *TestSuite) makeFriends(peer1, peer2 testPeer) {
 type TestTUN struct {
 	Outbound                  chan []byte
 	ReferenceInboundPacketLen int
+	// Inbound receives copies of written packets if it is not nil
+	Inbound chan []byte
 
 	inboundCount int64
 	closed       chan struct{}
@@ -581,6 +3295,9 @@ func (t *testTun) Write(bufs [][]byte, offset int) (n int, err error) {
 		default:
 		}
 		atomic.AddInt64(&t.t.inboundCount, 1)
+		if t.t.Inbound != nil {
+			t.t.Inbound <- append([]byte(nil), msg...)
+		}
 		n++
 	}
 
@@ -1,11 +1,14 @@
+//go:build !awl_nodns
+
 package awldns
 
 import (
+	"context"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
-	"unicode"
 
 	"github.com/ipfs/go-log/v2"
 	"github.com/miekg/dns"
@@ -18,23 +21,37 @@ const (
 )
 
 const (
-	LocalDomain               = "awl"
 	DNSIp                     = "127.0.0.66"
 	DefaultDNSPort            = "53"
 	DNSAddress                = "127.0.0.66:53"
 	DefaultUpstreamDNSAddress = "1.1.1.1:53"
+
+	upstreamExchangeTimeout = 5 * time.Second
 )
 
+// ExchangeFunc resolves the query instead of upstream dns address, e.g. through a peer.
+type ExchangeFunc func(ctx context.Context, req *dns.Msg) (*dns.Msg, error)
+
 type Resolver struct {
 	udpServer *dns.Server
 	tcpServer *dns.Server
 	udpClient *dns.Client
 	tcpClient *dns.Client
 	cfg       atomic.Pointer[config]
+	cache     *cache
 	logger    *log.ZapEventLogger
 
-	udpServerWorking bool
-	tcpServerWorking bool
+	// serversLock guards udpServer and tcpServer, they are replaced by Restart
+	serversLock sync.Mutex
+	mux         *dns.ServeMux
+
+	udpServerWorking atomic.Bool
+	tcpServerWorking atomic.Bool
+	// failed is set when any server stopped with error, see Restart
+	failed atomic.Bool
+
+	// upstreamExchange is used before upstream dns address, see SetUpstreamExchange
+	upstreamExchange atomic.Pointer[ExchangeFunc]
 
 	dnsAddress string
 }
@@ -54,6 +71,7 @@ func NewResolver(dnsAddress string) *Resolver {
 		tcpClient: &dns.Client{
 			Net: "tcp",
 		},
+		cache:      newCache(),
 		dnsAddress: dnsAddress,
 	}
 	r.cfg.Store(&config{})
@@ -63,46 +81,75 @@ func NewResolver(dnsAddress string) *Resolver {
 	mux.HandleFunc(strings.TrimPrefix(ptrV4Suffix, "."), r.ptrv4Handler)
 	mux.HandleFunc(".", r.dnsProxyHandler)
 
-	r.udpServer = &dns.Server{
-		Addr:    dnsAddress,
+	r.mux = mux
+	r.startServers()
+
+	return r
+}
+
+func (r *Resolver) startServers() {
+	udpServer := &dns.Server{
+		Addr:    r.dnsAddress,
 		Net:     "udp",
-		Handler: mux,
+		Handler: r.mux,
 		NotifyStartedFunc: func() {
-			r.logger.Infof("udp server has started on %s", dnsAddress)
-			r.udpServerWorking = true
+			r.logger.Infof("udp server has started on %s", r.dnsAddress)
+			r.udpServerWorking.Store(true)
 		},
 	}
-	r.tcpServer = &dns.Server{
-		Addr:    dnsAddress,
+	tcpServer := &dns.Server{
+		Addr:    r.dnsAddress,
 		Net:     "tcp",
-		Handler: mux,
+		Handler: r.mux,
 		NotifyStartedFunc: func() {
-			r.logger.Infof("tcp server has started on %s", dnsAddress)
-			r.tcpServerWorking = true
+			r.logger.Infof("tcp server has started on %s", r.dnsAddress)
+			r.tcpServerWorking.Store(true)
 		},
 	}
-	go func() {
-		err := r.udpServer.ListenAndServe()
-		if err != nil {
-			r.logger.Errorf("serve udp server: %v", err)
-		}
-		r.udpServerWorking = false
-	}()
-	go func() {
-		err := r.tcpServer.ListenAndServe()
-		if err != nil {
-			r.logger.Errorf("serve tcp server: %v", err)
-		}
-		r.tcpServerWorking = false
-	}()
+	r.serversLock.Lock()
+	r.udpServer, r.tcpServer = udpServer, tcpServer
+	r.serversLock.Unlock()
 
-	return r
+	go r.serve(udpServer, &r.udpServerWorking)
+	go r.serve(tcpServer, &r.tcpServerWorking)
+}
+
+func (r *Resolver) serve(server *dns.Server, working *atomic.Bool) {
+	err := server.ListenAndServe()
+
+	r.serversLock.Lock()
+	defer r.serversLock.Unlock()
+	if server != r.udpServer && server != r.tcpServer {
+		// replaced by Restart
+		return
+	}
+	if err != nil {
+		r.logger.Errorf("serve %s server: %v", server.Net, err)
+		r.failed.Store(true)
+	}
+	working.Store(false)
+}
+
+// Failed returns true if udp or tcp server stopped with error, e.g. the address was taken by another process.
+func (r *Resolver) Failed() bool {
+	return r.failed.Load()
+}
+
+// Restart stops servers and starts them again, cache and configuration are kept.
+func (r *Resolver) Restart() {
+	r.shutdownServers()
+	r.failed.Store(false)
+	r.startServers()
 }
 
 func (r *Resolver) ReceiveConfiguration(upstreamDNS string, namesMapping map[string]string) {
 	reverseMapping := make(map[string]string, len(namesMapping))
 	directMapping := make(map[string]string, len(namesMapping))
 	for key, ip := range namesMapping {
+		// clients query international names in punycode
+		if asciiKey, err := ToASCIIDomainName(key); err == nil {
+			key = asciiKey
+		}
 		canonicalName := dns.CanonicalName(key + "." + LocalDomain)
 		directMapping[canonicalName] = ip
 		existedName, exists := reverseMapping[ip]
@@ -120,11 +167,25 @@ func (r *Resolver) ReceiveConfiguration(upstreamDNS string, namesMapping map[str
 		directMapping:  directMapping,
 		reverseMapping: reverseMapping,
 	}
-	r.cfg.Store(&cfg)
+	old := r.cfg.Swap(&cfg)
+	if old != nil && old.upstreamDNS != upstreamDNS {
+		r.cache.reset()
+	}
+}
+
+// SetUpstreamExchange makes queries which aren't answered locally resolved by exchange, they are sent to upstream
+// dns address only if exchange fails. Nil exchange resolves queries by upstream dns address.
+func (r *Resolver) SetUpstreamExchange(exchange ExchangeFunc) {
+	if exchange == nil {
+		r.upstreamExchange.Store(nil)
+	} else {
+		r.upstreamExchange.Store(&exchange)
+	}
+	r.cache.reset()
 }
 
 func (r *Resolver) DNSAddress() string {
-	if !r.tcpServerWorking || !r.udpServerWorking {
+	if !r.tcpServerWorking.Load() || !r.udpServerWorking.Load() {
 		return ""
 	}
 
@@ -132,11 +193,19 @@ func (r *Resolver) DNSAddress() string {
 }
 
 func (r *Resolver) Close() {
-	err := r.udpServer.Shutdown()
+	r.shutdownServers()
+}
+
+func (r *Resolver) shutdownServers() {
+	r.serversLock.Lock()
+	udpServer, tcpServer := r.udpServer, r.tcpServer
+	r.serversLock.Unlock()
+
+	err := udpServer.Shutdown()
 	if err != nil {
 		r.logger.Warnf("shutdown udp server: %v", err)
 	}
-	err = r.tcpServer.Shutdown()
+	err = tcpServer.Shutdown()
 	if err != nil {
 		r.logger.Warnf("shutdown tcp server: %v", err)
 	}
@@ -225,23 +294,70 @@ func (r *Resolver) ptrv4Handler(resp dns.ResponseWriter, req *dns.Msg) {
 func (r *Resolver) dnsProxyHandler(resp dns.ResponseWriter, req *dns.Msg) {
 	cfg := r.loadConfig()
 
+	cached, prefetch := r.cache.get(req)
+	if cached != nil {
+		if prefetch {
+			go r.prefetch(req.Copy(), cfg.upstreamDNS)
+		}
+		truncateResponse(req, resp, cached)
+		_ = resp.WriteMsg(cached)
+		return
+	}
+
 	dnsClient := r.udpClient
 	if _, ok := resp.RemoteAddr().(*net.TCPAddr); ok {
 		dnsClient = r.tcpClient
 	}
 
-	upstreamResp, _, err := dnsClient.Exchange(req, cfg.upstreamDNS)
+	upstreamResp, err := r.exchange(req, dnsClient, cfg.upstreamDNS)
 	if err != nil {
+		if stale := r.cache.getStale(req); stale != nil {
+			r.logger.Debugf("send request to upstream dns, serving stale response: %v", err)
+			truncateResponse(req, resp, stale)
+			_ = resp.WriteMsg(stale)
+			return
+		}
 		r.logger.Warnf("send request to upstream dns: %v", err)
 		m := new(dns.Msg)
 		m.SetRcode(req, dns.RcodeServerFailure)
 		_ = resp.WriteMsg(m)
 		return
 	}
+	r.cache.set(req, upstreamResp)
 
+	truncateResponse(req, resp, upstreamResp)
 	_ = resp.WriteMsg(upstreamResp)
 }
 
+// exchange resolves the query with upstream exchange if it's set and by upstream dns address otherwise.
+func (r *Resolver) exchange(req *dns.Msg, dnsClient *dns.Client, upstreamDNS string) (*dns.Msg, error) {
+	if exchange := r.upstreamExchange.Load(); exchange != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), upstreamExchangeTimeout)
+		resp, err := (*exchange)(ctx, req)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+		r.logger.Debugf("upstream exchange failed, send request to upstream dns: %v", err)
+	}
+	resp, _, err := dnsClient.Exchange(req, upstreamDNS)
+	return resp, err
+}
+
+// prefetch refreshes cached response before it expires, so popular names are always resolved from cache.
+func (r *Resolver) prefetch(req *dns.Msg, upstreamDNS string) {
+	upstreamResp, err := r.exchange(req, r.udpClient, upstreamDNS)
+	if err == nil && upstreamResp.Truncated {
+		upstreamResp, _, err = r.tcpClient.Exchange(req, upstreamDNS)
+	}
+	if err != nil {
+		r.logger.Debugf("prefetch %s from upstream dns: %v", req.Question[0].Name, err)
+		r.cache.prefetchFailed(req)
+		return
+	}
+	r.cache.set(req, upstreamResp)
+}
+
 func (r *Resolver) loadConfig() config {
 	cfg := r.cfg.Load()
 	if cfg == nil {
@@ -251,6 +367,13 @@ func (r *Resolver) loadConfig() config {
 }
 
 func processOwnResponse(req *dns.Msg, respWriter dns.ResponseWriter, resp *dns.Msg) {
+	truncateResponse(req, respWriter, resp)
+
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+}
+
+func truncateResponse(req *dns.Msg, respWriter dns.ResponseWriter, resp *dns.Msg) {
 	maxSize := dns.MinMsgSize
 	if respWriter.LocalAddr().Network() == "tcp" {
 		maxSize = dns.MaxMsgSize
@@ -263,26 +386,6 @@ func processOwnResponse(req *dns.Msg, respWriter dns.ResponseWriter, resp *dns.M
 		}
 	}
 	resp.Truncate(maxSize)
-
-	resp.Authoritative = true
-	resp.RecursionAvailable = true
-}
-
-func TrimDomainName(domain string) string {
-	domain = strings.TrimSpace(domain)
-	domain = strings.Map(func(r rune) rune {
-		if unicode.IsSpace(r) {
-			return '_'
-		}
-		return r
-	}, domain)
-
-	return strings.ToLower(domain)
-}
-
-func IsValidDomainName(domain string) bool {
-	_, ok := dns.IsDomainName(domain + "." + LocalDomain)
-	return ok && domain == TrimDomainName(domain)
 }
 
 func ptrV4NameToIP(name string) net.IP {
@@ -1,3 +1,5 @@
+//go:build !awl_nodns
+
 package awldns
 
 import (
@@ -5,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,10 +32,13 @@ func TestDNS(t *testing.T) {
 	name2 := "laptop.office"
 	name2Capitalized := "LAPTOP.office"
 	addr2 := "10.66.0.2"
+	name3 := "ноутбук"
+	addr3 := "10.66.0.3"
 
 	namesMapping := map[string]string{
 		name1: addr1,
 		name2: addr2,
+		name3: addr3,
 	}
 	resolver.ReceiveConfiguration("", namesMapping)
 
@@ -57,6 +63,11 @@ func TestDNS(t *testing.T) {
 	assertAddr(name2+".awl", addr2)
 	assertAddr(name2Capitalized+".awl", addr2)
 
+	name3ASCII, err := ToASCIIDomainName(name3)
+	a.NoError(err)
+	a.Equal("xn--90asheufc", name3ASCII)
+	assertAddr(name3ASCII+".awl", addr3)
+
 	addrs, err := client.LookupHost(ctx, "unknown.awl")
 	a.Error(err)
 	a.Empty(addrs)
@@ -68,6 +79,79 @@ func TestDNS(t *testing.T) {
 	}
 }
 
+func TestResolver_Restart(t *testing.T) {
+	ctx := context.Background()
+	a := require.New(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", FindFreePort())
+
+	// address is taken, so udp server fails to start
+	conn, err := net.ListenPacket("udp", addr)
+	a.NoError(err)
+	resolver := NewResolver(addr)
+	defer resolver.Close()
+	a.Eventually(resolver.Failed, time.Second, 10*time.Millisecond)
+	a.Empty(resolver.DNSAddress())
+
+	a.NoError(conn.Close())
+	resolver.Restart()
+	a.False(resolver.Failed())
+	a.Eventually(func() bool {
+		return resolver.DNSAddress() == addr
+	}, time.Second, 10*time.Millisecond)
+
+	resolver.ReceiveConfiguration("", map[string]string{"peer": "10.66.0.2"})
+	addrs, err := NewResolverClient(addr).LookupHost(ctx, "peer.awl")
+	a.NoError(err)
+	a.Equal([]string{"10.66.0.2"}, addrs)
+}
+
+func TestUpstreamExchange(t *testing.T) {
+	ctx := context.Background()
+	a := require.New(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", FindFreePort())
+	resolver := NewResolver(addr)
+	defer resolver.Close()
+	a.Eventually(func() bool {
+		return resolver.DNSAddress() == addr
+	}, time.Second, 10*time.Millisecond)
+	// upstream address is unreachable, so queries are resolved only by exchange
+	resolver.ReceiveConfiguration(fmt.Sprintf("127.0.0.1:%d", FindFreePort()), map[string]string{"peer": "10.66.0.2"})
+
+	var exchangedLock sync.Mutex
+	var exchanged []string
+	resolver.SetUpstreamExchange(func(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+		exchangedLock.Lock()
+		exchanged = append(exchanged, req.Question[0].Name)
+		exchangedLock.Unlock()
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if req.Question[0].Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 5),
+			})
+		}
+		return m, nil
+	})
+	client := NewResolverClient(addr)
+	addrs, err := client.LookupHost(ctx, "intranet.example.com")
+	a.NoError(err)
+	a.Equal([]string{"10.0.0.5"}, addrs)
+	addrs, err = client.LookupHost(ctx, "peer.awl")
+	a.NoError(err)
+	a.Equal([]string{"10.66.0.2"}, addrs)
+	// awl names are answered locally
+	exchangedLock.Lock()
+	a.NotContains(exchanged, "peer.awl.")
+	a.Contains(exchanged, "intranet.example.com.")
+	exchangedLock.Unlock()
+
+	// cache is reset, so the query is sent to unreachable upstream address
+	resolver.SetUpstreamExchange(nil)
+	_, err = client.LookupHost(ctx, "intranet.example.com")
+	a.Error(err)
+}
+
 func NewResolverClient(address string) *net.Resolver {
 	dialer := &net.Dialer{Timeout: time.Second}
 	return &net.Resolver{
@@ -0,0 +1,244 @@
+//go:build !awl_nodns
+
+package awldns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	maxCacheEntries = 4096
+	maxCacheTTL     = time.Hour
+	// rfc2308: negative answers are cached for SOA minimum, but we don't trust upstream to be reasonable
+	defaultNegativeTTL = time.Minute
+	maxNegativeTTL     = 5 * time.Minute
+	// rfc8767: expired entries are served when upstream is unreachable
+	maxStaleDuration = time.Hour
+	staleTTLSeconds  = 30
+	// entries which were requested at least twice are refreshed in background when this part of ttl is left
+	prefetchThreshold = 10
+	prefetchMinHits   = 2
+)
+
+type cacheKey struct {
+	name     string
+	qtype    uint16
+	qclass   uint16
+	dnssecOK bool
+}
+
+type cacheEntry struct {
+	msg         *dns.Msg
+	storedAt    time.Time
+	ttl         time.Duration
+	hits        int
+	prefetching bool
+}
+
+// cache stores upstream responses with ttl handling and negative caching. It is safe for concurrent use.
+type cache struct {
+	lock    sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	now     func() time.Time
+}
+
+func newCache() *cache {
+	return &cache{
+		entries: make(map[cacheKey]*cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns cached response with decremented ttl and reports whether it should be prefetched.
+// Only the caller which gets prefetch = true should refresh the entry and then call set or prefetchFailed.
+func (c *cache) get(req *dns.Msg) (resp *dns.Msg, prefetch bool) {
+	key, ok := makeCacheKey(req)
+	if !ok {
+		return nil, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	elapsed := c.now().Sub(entry.storedAt)
+	if elapsed >= entry.ttl {
+		return nil, false
+	}
+	entry.hits++
+	left := entry.ttl - elapsed
+	if !entry.prefetching && entry.hits >= prefetchMinHits && left < entry.ttl/prefetchThreshold {
+		entry.prefetching = true
+		prefetch = true
+	}
+
+	return entry.reply(req, uint32(elapsed/time.Second), 0), prefetch
+}
+
+// getStale returns expired response if it is not too old, it should be used only when upstream is unreachable.
+func (c *cache) getStale(req *dns.Msg) *dns.Msg {
+	key, ok := makeCacheKey(req)
+	if !ok {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, exists := c.entries[key]
+	if !exists || c.now().Sub(entry.storedAt) > entry.ttl+maxStaleDuration {
+		return nil
+	}
+	return entry.reply(req, 0, staleTTLSeconds)
+}
+
+func (c *cache) set(req, resp *dns.Msg) {
+	key, ok := makeCacheKey(req)
+	if !ok {
+		return
+	}
+	ttl, ok := cacheTTL(resp)
+	if !ok {
+		c.prefetchFailed(req)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= maxCacheEntries {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &cacheEntry{
+		msg:      resp.Copy(),
+		storedAt: now,
+		ttl:      ttl,
+	}
+}
+
+func (c *cache) prefetchFailed(req *dns.Msg) {
+	key, ok := makeCacheKey(req)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	if entry, exists := c.entries[key]; exists {
+		entry.prefetching = false
+	}
+	c.lock.Unlock()
+}
+
+func (c *cache) reset() {
+	c.lock.Lock()
+	c.entries = make(map[cacheKey]*cacheEntry)
+	c.lock.Unlock()
+}
+
+// evictLocked removes entries which can't be served even as stale, or any entry if there are no such.
+func (c *cache) evictLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if now.Sub(entry.storedAt) > entry.ttl+maxStaleDuration {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) < maxCacheEntries {
+		return
+	}
+	for key := range c.entries {
+		delete(c.entries, key)
+		break
+	}
+}
+
+// reply copies cached response for req. TTLs are decremented by elapsed or set to fixedTTL if it is not zero.
+func (e *cacheEntry) reply(req *dns.Msg, elapsed, fixedTTL uint32) *dns.Msg {
+	resp := e.msg.Copy()
+	resp.Id = req.Id
+	// original name from the request, as some clients expect that
+	if len(resp.Question) > 0 && len(req.Question) > 0 {
+		resp.Question[0].Name = req.Question[0].Name
+	}
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			switch {
+			case fixedTTL != 0:
+				hdr.Ttl = fixedTTL
+			case hdr.Ttl > elapsed:
+				hdr.Ttl -= elapsed
+			default:
+				hdr.Ttl = 0
+			}
+		}
+	}
+	return resp
+}
+
+func makeCacheKey(req *dns.Msg) (cacheKey, bool) {
+	if len(req.Question) != 1 {
+		return cacheKey{}, false
+	}
+	question := req.Question[0]
+	key := cacheKey{
+		name:   strings.ToLower(question.Name),
+		qtype:  question.Qtype,
+		qclass: question.Qclass,
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		key.dnssecOK = opt.Do()
+	}
+	return key, true
+}
+
+// cacheTTL returns for how long the response could be cached: min ttl of records for positive answers
+// and SOA ttl for negative ones (rfc2308). Truncated responses and server failures are not cached.
+func cacheTTL(resp *dns.Msg) (time.Duration, bool) {
+	if resp.Truncated {
+		return 0, false
+	}
+
+	switch {
+	case resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0:
+		minTTL := uint32(maxCacheTTL / time.Second)
+		for _, section := range [][]dns.RR{resp.Answer, resp.Ns} {
+			for _, rr := range section {
+				if ttl := rr.Header().Ttl; ttl < minTTL {
+					minTTL = ttl
+				}
+			}
+		}
+		if minTTL == 0 {
+			return 0, false
+		}
+		return time.Duration(minTTL) * time.Second, true
+	case resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError:
+		ttl := defaultNegativeTTL
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				soaTTL := soa.Hdr.Ttl
+				if soa.Minttl < soaTTL {
+					soaTTL = soa.Minttl
+				}
+				ttl = time.Duration(soaTTL) * time.Second
+				break
+			}
+		}
+		if ttl > maxNegativeTTL {
+			ttl = maxNegativeTTL
+		}
+		if ttl == 0 {
+			return 0, false
+		}
+		return ttl, true
+	default:
+		return 0, false
+	}
+}
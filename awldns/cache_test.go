@@ -0,0 +1,132 @@
+//go:build !awl_nodns
+
+package awldns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newCache()
+	c.now = func() time.Time { return now }
+
+	req := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	resp := new(dns.Msg).SetReply(req)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 100},
+		A:   net.IPv4(1, 2, 3, 4),
+	})
+	c.set(req, resp)
+
+	// case-insensitive, ttl is decremented
+	now = now.Add(30 * time.Second)
+	req2 := new(dns.Msg).SetQuestion("EXAMPLE.com.", dns.TypeA)
+	cached, prefetch := c.get(req2)
+	a.NotNil(cached)
+	a.False(prefetch)
+	a.Equal(req2.Id, cached.Id)
+	a.Equal("EXAMPLE.com.", cached.Question[0].Name)
+	a.EqualValues(70, cached.Answer[0].Header().Ttl)
+
+	// prefetch only once when less than 10% of ttl is left
+	now = now.Add(65 * time.Second)
+	cached, prefetch = c.get(req)
+	a.NotNil(cached)
+	a.True(prefetch)
+	_, prefetch = c.get(req)
+	a.False(prefetch)
+
+	// expired, but could be served as stale
+	now = now.Add(10 * time.Second)
+	cached, _ = c.get(req)
+	a.Nil(cached)
+	stale := c.getStale(req)
+	a.NotNil(stale)
+	a.EqualValues(staleTTLSeconds, stale.Answer[0].Header().Ttl)
+	now = now.Add(maxStaleDuration)
+	a.Nil(c.getStale(req))
+
+	// negative caching with soa ttl
+	nxReq := new(dns.Msg).SetQuestion("unknown.example.com.", dns.TypeA)
+	nxResp := new(dns.Msg).SetRcode(nxReq, dns.RcodeNameError)
+	nxResp.Ns = append(nxResp.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Minttl: 20,
+	})
+	c.set(nxReq, nxResp)
+	cached, _ = c.get(nxReq)
+	a.NotNil(cached)
+	a.Equal(dns.RcodeNameError, cached.Rcode)
+	now = now.Add(21 * time.Second)
+	cached, _ = c.get(nxReq)
+	a.Nil(cached)
+
+	// server failures and truncated responses are not cached
+	failReq := new(dns.Msg).SetQuestion("fail.example.com.", dns.TypeA)
+	c.set(failReq, new(dns.Msg).SetRcode(failReq, dns.RcodeServerFailure))
+	cached, _ = c.get(failReq)
+	a.Nil(cached)
+	truncated := new(dns.Msg).SetReply(failReq)
+	truncated.Truncated = true
+	c.set(failReq, truncated)
+	cached, _ = c.get(failReq)
+	a.Nil(cached)
+}
+
+func TestResolverCache(t *testing.T) {
+	ctx := context.Background()
+	a := require.New(t)
+
+	var upstreamRequests int64
+	upstreamAddr := fmt.Sprintf("127.0.0.1:%d", FindFreePort())
+	upstream := &dns.Server{Addr: upstreamAddr, Net: "udp", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt64(&upstreamRequests, 1)
+		m := new(dns.Msg).SetReply(req)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(1, 2, 3, 4),
+		})
+		_ = w.WriteMsg(m)
+	})}
+	started := make(chan struct{})
+	upstream.NotifyStartedFunc = func() { close(started) }
+	go func() {
+		_ = upstream.ListenAndServe()
+	}()
+	<-started
+
+	addr := fmt.Sprintf("127.0.0.1:%d", FindFreePort())
+	resolver := NewResolver(addr)
+	defer resolver.Close()
+	// TODO: remove sleep. We need it because NewResolver starts servers in goroutines
+	time.Sleep(50 * time.Millisecond)
+	resolver.ReceiveConfiguration(upstreamAddr, nil)
+	client := NewResolverClient(addr)
+
+	for i := 0; i < 3; i++ {
+		addrs, err := client.LookupHost(ctx, "example.com")
+		a.NoError(err)
+		a.Equal([]string{"1.2.3.4"}, addrs)
+	}
+	// one for A and one for AAAA
+	a.EqualValues(2, atomic.LoadInt64(&upstreamRequests))
+
+	// stale response is served when upstream is unreachable
+	a.NoError(upstream.Shutdown())
+	resolver.cache.lock.Lock()
+	resolver.cache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	resolver.cache.lock.Unlock()
+	addrs, err := client.LookupHost(ctx, "example.com")
+	a.NoError(err)
+	a.Equal([]string{"1.2.3.4"}, addrs)
+}
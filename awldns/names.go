@@ -0,0 +1,93 @@
+package awldns
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// LocalDomain is the domain of peer names.
+const LocalDomain = "awl"
+
+// MaxPeerDNSRecords limits the number of additional names published by a single peer.
+const MaxPeerDNSRecords = 32
+
+const (
+	// maxDomainNameLength is 255 bytes of wire format without the length of the first label and the root label
+	maxDomainNameLength = 253
+	maxLabelLength      = 63
+)
+
+// domainProfile maps names for lookup, underscores are allowed since names are generated from aliases with spaces.
+var domainProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.Transitional(false))
+
+// TrimDomainName makes domain name from peer alias. International names are kept in unicode,
+// they are normalized and case folded like in browsers, punycode is decoded.
+func TrimDomainName(domain string) string {
+	domain = strings.TrimSpace(domain)
+	domain = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return '_'
+		}
+		return r
+	}, domain)
+	domain = strings.ToLower(domain)
+
+	unicodeDomain, err := domainProfile.ToUnicode(domain)
+	if err != nil {
+		return domain
+	}
+	return unicodeDomain
+}
+
+// ToASCIIDomainName converts international domain name to punycode which is used in dns queries.
+func ToASCIIDomainName(domain string) (string, error) {
+	return domainProfile.ToASCII(domain)
+}
+
+func IsValidDomainName(domain string) bool {
+	if domain != TrimDomainName(domain) {
+		return false
+	}
+	asciiDomain, err := ToASCIIDomainName(domain)
+	if err != nil {
+		return false
+	}
+	return isDomainName(asciiDomain + "." + LocalDomain)
+}
+
+// ValidDNSRecords returns unique valid records in the same order, invalid ones and ones above MaxPeerDNSRecords are skipped.
+func ValidDNSRecords(records []string) []string {
+	result := make([]string, 0, len(records))
+	seen := make(map[string]struct{}, len(records))
+	for _, record := range records {
+		record = TrimDomainName(record)
+		if !IsValidDomainName(record) {
+			continue
+		}
+		if _, exists := seen[record]; exists {
+			continue
+		}
+		seen[record] = struct{}{}
+		result = append(result, record)
+		if len(result) == MaxPeerDNSRecords {
+			break
+		}
+	}
+	return result
+}
+
+// isDomainName checks lengths of the name and its labels like dns.IsDomainName, it's used without dns package
+// since names are validated in builds without the resolver too.
+func isDomainName(name string) bool {
+	if len(name) > maxDomainNameLength {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > maxLabelLength {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package awldns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidDNSRecords(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal([]string{"plex", "printer", "web.media", "my_nas"},
+		ValidDNSRecords([]string{"plex", " Printer ", "plex", "web.media", "bad..name", "", "my nas"}))
+
+	tooMany := make([]string, 0, MaxPeerDNSRecords+1)
+	for i := 0; i <= MaxPeerDNSRecords; i++ {
+		tooMany = append(tooMany, fmt.Sprintf("name%d", i))
+	}
+	a.Len(ValidDNSRecords(tooMany), MaxPeerDNSRecords)
+}
+
+func TestTrimDomainName(t *testing.T) {
+	a := require.New(t)
+
+	a.Equal("my_nas", TrimDomainName(" My NAS "))
+	a.Equal("мой_ноутбук", TrimDomainName("Мой Ноутбук"))
+	// decomposed é is composed
+	a.Equal("café", TrimDomainName("Cafe\u0301"))
+	a.Equal("ноутбук", TrimDomainName("xn--90asheufc"))
+
+	a.True(IsValidDomainName("мой_ноутбук"))
+	a.True(IsValidDomainName("laptop.office"))
+	a.False(IsValidDomainName("Мой_ноутбук"))
+	a.False(IsValidDomainName("bad..name"))
+	a.True(IsValidDomainName(strings.Repeat("a", 63)))
+	a.False(IsValidDomainName(strings.Repeat("a", 64)))
+	a.False(IsValidDomainName(strings.Repeat("a.", 125) + "ab"))
+}
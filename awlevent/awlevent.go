@@ -2,6 +2,7 @@ package awlevent
 
 import (
 	"context"
+	"reflect"
 
 	"github.com/anywherelan/awl/protocol"
 	"github.com/libp2p/go-libp2p/core/event"
@@ -10,14 +11,131 @@ import (
 type Bus = event.Bus
 type Emitter = event.Emitter
 
+// KnownPeerChanged is emitted when a known peer is added, updated or removed.
 type KnownPeerChanged struct {
 }
 
+// ConfigChanged is emitted after the config has been saved.
+type ConfigChanged struct {
+}
+
 type ReceivedAuthRequest struct {
 	protocol.AuthPeer
 	PeerID string
 }
 
+// PeerConnected is emitted when the first connection to a known peer is established.
+type PeerConnected struct {
+	PeerID    string
+	Direction string
+	Address   string
+}
+
+// PeerDisconnected is emitted when the last connection to a known peer is closed.
+type PeerDisconnected struct {
+	PeerID string
+}
+
+// PeerConnectionTypeChanged is emitted when a known peer is connected or its connections become direct or relayed.
+type PeerConnectionTypeChanged struct {
+	PeerID         string
+	ConnectionType string `enums:"direct,relayed"`
+}
+
+// HolePunchFinished is emitted after each attempt to replace relayed connection with a known peer by direct one.
+type HolePunchFinished struct {
+	PeerID  string
+	Success bool
+	Error   string `json:",omitempty"`
+}
+
+// ReachabilityChanged is emitted when our NAT reachability status is changed.
+type ReachabilityChanged struct {
+	Reachability string `enums:"Unknown,Public,Private"`
+}
+
+// VPNPathChanged is emitted when a tunnel path to a peer goes up or down or its connection type changes.
+// Path goes down when a tunnel stream can't be opened, streams which are closed when idle don't change it.
+type VPNPathChanged struct {
+	PeerID string
+	Up     bool
+	// ConnectionType is empty if the path is down
+	ConnectionType string `json:",omitempty" enums:",direct,relayed"`
+	Error          string `json:",omitempty"`
+}
+
+// VPNInterfaceStateChanged is emitted when the vpn interface goes down or up.
+// Traffic is paused while the interface is down.
+type VPNInterfaceStateChanged struct {
+	Up bool
+	// Paused is true if the interface is brought down by the user, see service.Tunnel.SetPaused
+	Paused bool
+}
+
+// FileTransferProgress is emitted periodically while a file is sent or received and when the transfer is finished.
+type FileTransferProgress struct {
+	ID          string
+	PeerID      string
+	Direction   string `enums:"send,receive"`
+	Name        string
+	Size        int64
+	Transferred int64
+	State       string `enums:"active,completed,failed"`
+	Error       string `json:",omitempty"`
+}
+
+// TextMessageReceived is emitted when a known peer sends us a text message, see service.Messages.
+type TextMessageReceived struct {
+	ID     string
+	PeerID string
+	Text   string
+}
+
+// PeerIdentityMigrated is emitted when a known peer rotates its identity key and moves to the new peer id.
+type PeerIdentityMigrated struct {
+	OldPeerID string
+	NewPeerID string
+}
+
+// PeerRenamed is emitted when a known peer changes its name, its alias and domain name follow the name
+// unless they were set by the user.
+type PeerRenamed struct {
+	PeerID     string
+	OldName    string
+	NewName    string
+	Alias      string
+	DomainName string
+}
+
+// SubnetConflictsChanged is emitted when the vpn network or routes of peers start or stop overlapping local networks.
+type SubnetConflictsChanged struct {
+	Conflicts []SubnetConflict
+}
+
+// SubnetConflict is the vpn network or the route advertised by the peer which overlaps the network of a local interface.
+// Routes of peers are not used while they overlap, the vpn network should be changed, see Resolution.
+type SubnetConflict struct {
+	Network string
+	// PeerID is the peer which advertises Network, it's empty for the vpn network
+	PeerID         string `json:",omitempty"`
+	LocalNetwork   string
+	LocalInterface string
+	// Resolution is the action which resolves the conflict
+	Resolution string
+}
+
+// Name returns the event type name, e.g. "PeerConnected".
+func Name(evt interface{}) string {
+	t := reflect.TypeOf(evt)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
 func WrapSubscriptionToCallback(ctx context.Context, callback func(interface{}), bus Bus,
 	eventType interface{}, opts ...event.SubscriptionOpt) {
 	sub, err := bus.Subscribe(eventType, opts...)
@@ -41,3 +159,8 @@ func WrapSubscriptionToCallback(ctx context.Context, callback func(interface{}),
 		}
 	}()
 }
+
+// Tap calls callback for every event emitted on the bus until ctx is done.
+func Tap(ctx context.Context, callback func(interface{}), bus Bus, opts ...event.SubscriptionOpt) {
+	WrapSubscriptionToCallback(ctx, callback, bus, event.WildcardSubscription, opts...)
+}
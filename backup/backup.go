@@ -0,0 +1,285 @@
+// Package backup creates encrypted snapshots of config and persistent state and restores them.
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/storage"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	SnapshotVersion = 1
+
+	// PendingRestoreFilename is a snapshot which is applied on the next start, it's encrypted like config at rest.
+	PendingRestoreFilename = "restore_awl.json"
+
+	filesPerm = 0600
+	magic     = "awlbk1"
+	saltSize  = 16
+	keySize   = 32
+)
+
+var ErrInvalidPassphrase = errors.New("invalid passphrase or corrupted backup")
+
+// Snapshot is a plaintext backup content.
+type Snapshot struct {
+	Version   int
+	CreatedAt time.Time
+	PeerID    string
+	Config    json.RawMessage
+	Storage   []byte
+}
+
+func NewSnapshot(conf *config.Config, s storage.Storage) (Snapshot, error) {
+	var storageData bytes.Buffer
+	err := s.Backup(&storageData)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("backup storage: %v", err)
+	}
+
+	conf.RLock()
+	peerID := conf.P2pNode.PeerID
+	conf.RUnlock()
+
+	return Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now(),
+		PeerID:    peerID,
+		Config:    conf.Export(),
+		Storage:   storageData.Bytes(),
+	}, nil
+}
+
+// WithoutIdentity returns snapshot for templating configs. Identity of the node and its rotation are removed,
+// storage is removed as well since it belongs to the node, e.g. peerstore and usage history.
+func (s Snapshot) WithoutIdentity() (Snapshot, error) {
+	conf, err := editP2pNodeConfig(s.Config, func(node map[string]json.RawMessage) error {
+		delete(node, "identity")
+		delete(node, "peerId")
+		delete(node, "identityMigration")
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s.Config = conf
+	s.PeerID = ""
+	s.Storage = nil
+	return s, nil
+}
+
+// HasIdentity is false for snapshots created with WithoutIdentity.
+func (s Snapshot) HasIdentity() bool {
+	return s.PeerID != ""
+}
+
+// WithIdentity sets identity to snapshot without one, so the node keeps its identity when template is imported.
+func (s Snapshot) WithIdentity(identity, peerID string) (Snapshot, error) {
+	conf, err := editP2pNodeConfig(s.Config, func(node map[string]json.RawMessage) error {
+		var err error
+		node["identity"], err = json.Marshal(identity)
+		if err != nil {
+			return err
+		}
+		node["peerId"], err = json.Marshal(peerID)
+		return err
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s.Config = conf
+	s.PeerID = peerID
+	return s, nil
+}
+
+// editP2pNodeConfig changes p2pNode section of config json, unknown fields are kept.
+func editP2pNodeConfig(data json.RawMessage, edit func(node map[string]json.RawMessage) error) (json.RawMessage, error) {
+	var conf map[string]json.RawMessage
+	err := json.Unmarshal(data, &conf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	node := make(map[string]json.RawMessage)
+	if raw, ok := conf["p2pNode"]; ok {
+		err = json.Unmarshal(raw, &node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	err = edit(node)
+	if err != nil {
+		return nil, err
+	}
+	conf["p2pNode"], err = json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(conf)
+}
+
+// Seal encrypts snapshot with a key derived from passphrase.
+func Seal(passphrase string, snapshot Snapshot) ([]byte, error) {
+	plaintext, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, len(magic)+len(salt)+len(nonce)+len(plaintext)+gcm.Overhead())
+	data = append(data, magic...)
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	data = gcm.Seal(data, nonce, plaintext, []byte(magic))
+	return data, nil
+}
+
+// Open decrypts snapshot sealed with Seal.
+func Open(passphrase string, data []byte) (Snapshot, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) || len(data) < len(magic)+saltSize {
+		return Snapshot{}, errors.New("unknown backup format")
+	}
+	data = data[len(magic):]
+	salt, data := data[:saltSize], data[saltSize:]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return Snapshot{}, errors.New("unknown backup format")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(magic))
+	if err != nil {
+		return Snapshot{}, ErrInvalidPassphrase
+	}
+
+	var snapshot Snapshot
+	err = json.Unmarshal(plaintext, &snapshot)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("invalid snapshot: %v", err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		return Snapshot{}, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return snapshot, nil
+}
+
+// RestoreToken proves to the peer that stores backup that we know the passphrase, without revealing it.
+// Peer stores only sha256 of the token, see RestoreTokenHash.
+func RestoreToken(passphrase, ownerPeerID string) []byte {
+	salt := sha256.Sum256([]byte("awl-backup-restore:" + ownerPeerID))
+	return deriveKey(passphrase, salt[:saltSize])
+}
+
+func RestoreTokenHash(token []byte) []byte {
+	hash := sha256.Sum256(token)
+	return hash[:]
+}
+
+// SavePendingRestore saves snapshot to be applied on the next start, see ApplyPendingRestore.
+// It contains identity, so it's encrypted with the key of config if encryption at rest is enabled.
+func SavePendingRestore(conf *config.Config, snapshot Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	data, err = conf.SealData(data)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(conf.DataDir(), PendingRestoreFilename)
+	err = os.WriteFile(path, data, filesPerm)
+	if err != nil {
+		return err
+	}
+	config.ChownFileIfNeeded(path)
+	return nil
+}
+
+// ApplyPendingRestore replaces config file and storage with pending snapshot if it exists, config file keeps
+// encryption of conf. It should be called after config is loaded and before storage is opened,
+// then config should be reloaded with config.Config.Reload.
+func ApplyPendingRestore(conf *config.Config) (bool, error) {
+	dataDir := conf.DataDir()
+	path := filepath.Join(dataDir, PendingRestoreFilename)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	// remove it anyway to not fail on every start
+	defer os.Remove(path)
+
+	data, err = conf.OpenData(data)
+	if err != nil {
+		return false, err
+	}
+	var snapshot Snapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return false, fmt.Errorf("invalid snapshot: %v", err)
+	}
+
+	err = conf.Import(snapshot.Config)
+	if err != nil {
+		return false, fmt.Errorf("import config: %v", err)
+	}
+	if snapshot.Storage == nil {
+		// template without identity keeps storage of the node
+		return true, nil
+	}
+	s, err := storage.Open(dataDir)
+	if err != nil {
+		return false, fmt.Errorf("open storage: %v", err)
+	}
+	err = s.Restore(bytes.NewReader(snapshot.Storage))
+	if err != nil {
+		_ = s.Close()
+		return false, fmt.Errorf("restore storage: %v", err)
+	}
+	err = s.Close()
+	if err != nil {
+		return false, fmt.Errorf("close storage: %v", err)
+	}
+
+	return true, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func deriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, keySize)
+}
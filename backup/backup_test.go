@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/storage"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	conf := config.NewConfig(eventbus.NewBus())
+	conf.P2pNode.Name = "laptop"
+	s := storage.NewInMemory()
+	require.NoError(t, s.Put(context.Background(), ds.NewKey("/dht/key"), []byte("value")))
+
+	snapshot, err := NewSnapshot(conf, s)
+	require.NoError(t, err)
+	data, err := Seal("correct horse battery staple", snapshot)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, []byte("laptop")))
+
+	_, err = Open("wrong passphrase", data)
+	require.ErrorIs(t, err, ErrInvalidPassphrase)
+
+	opened, err := Open("correct horse battery staple", data)
+	require.NoError(t, err)
+	require.Equal(t, snapshot.Storage, opened.Storage)
+	require.JSONEq(t, string(snapshot.Config), string(opened.Config))
+
+	require.Equal(t, RestoreToken("pass", "peer1"), RestoreToken("pass", "peer1"))
+	require.NotEqual(t, RestoreToken("pass", "peer1"), RestoreToken("pass", "peer2"))
+}
+
+func TestApplyPendingRestore(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv(config.AppDataDirEnvKey, dataDir)
+	current := config.NewConfig(eventbus.NewBus())
+	applied, err := ApplyPendingRestore(current)
+	require.NoError(t, err)
+	require.False(t, applied)
+
+	conf := config.NewConfig(eventbus.NewBus())
+	conf.P2pNode.Name = "restored"
+	s := storage.NewInMemory()
+	key := ds.NewKey("/dht/key")
+	require.NoError(t, s.Put(context.Background(), key, []byte("value")))
+	snapshot, err := NewSnapshot(conf, s)
+	require.NoError(t, err)
+	require.NoError(t, SavePendingRestore(current, snapshot))
+
+	applied, err = ApplyPendingRestore(current)
+	require.NoError(t, err)
+	require.True(t, applied)
+	_, err = os.Stat(filepath.Join(dataDir, PendingRestoreFilename))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	configData, err := os.ReadFile(filepath.Join(dataDir, config.AppConfigFilename))
+	require.NoError(t, err)
+	require.Contains(t, string(configData), `"restored"`)
+
+	restoredStorage, err := storage.Open(dataDir)
+	require.NoError(t, err)
+	defer restoredStorage.Close()
+	value, err := restoredStorage.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), value)
+}
+
+func TestSnapshotWithoutIdentity(t *testing.T) {
+	conf := config.NewConfig(eventbus.NewBus())
+	conf.P2pNode.Name = "template"
+	conf.P2pNode.Identity = "identity"
+	conf.P2pNode.PeerID = "peer1"
+	s := storage.NewInMemory()
+	snapshot, err := NewSnapshot(conf, s)
+	require.NoError(t, err)
+	require.True(t, snapshot.HasIdentity())
+
+	template, err := snapshot.WithoutIdentity()
+	require.NoError(t, err)
+	require.False(t, template.HasIdentity())
+	require.Nil(t, template.Storage)
+	require.NotContains(t, string(template.Config), `"identity"`)
+	require.Contains(t, string(template.Config), `"template"`)
+
+	imported, err := template.WithIdentity("identity2", "peer2")
+	require.NoError(t, err)
+	require.Equal(t, "peer2", imported.PeerID)
+
+	dataDir := t.TempDir()
+	t.Setenv(config.AppDataDirEnvKey, dataDir)
+	current := config.NewConfig(eventbus.NewBus())
+	require.NoError(t, SavePendingRestore(current, imported))
+	applied, err := ApplyPendingRestore(current)
+	require.NoError(t, err)
+	require.True(t, applied)
+	configData, err := os.ReadFile(filepath.Join(dataDir, config.AppConfigFilename))
+	require.NoError(t, err)
+	var restored config.Config
+	require.NoError(t, json.Unmarshal(configData, &restored))
+	require.Equal(t, "identity2", restored.P2pNode.Identity)
+	require.Equal(t, "peer2", restored.P2pNode.PeerID)
+	require.Equal(t, "template", restored.P2pNode.Name)
+}
+
+func TestApplyPendingRestoreEncrypted(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv(config.AppDataDirEnvKey, dataDir)
+	bus := eventbus.NewBus()
+	current := config.NewConfig(bus)
+	require.NoError(t, current.SetEncryption(config.EncryptionPassphrase, "correct horse"))
+
+	conf := config.NewConfig(bus)
+	conf.P2pNode.Name = "restored"
+	conf.P2pNode.Identity = "secret-identity"
+	snapshot, err := NewSnapshot(conf, storage.NewInMemory())
+	require.NoError(t, err)
+	require.NoError(t, SavePendingRestore(current, snapshot))
+	pending, err := os.ReadFile(filepath.Join(dataDir, PendingRestoreFilename))
+	require.NoError(t, err)
+	require.NotContains(t, string(pending), "secret-identity")
+
+	applied, err := ApplyPendingRestore(current)
+	require.NoError(t, err)
+	require.True(t, applied)
+	// imported config is encrypted with the same key
+	configData, err := os.ReadFile(filepath.Join(dataDir, config.AppConfigFilename))
+	require.NoError(t, err)
+	require.NotContains(t, string(configData), "secret-identity")
+
+	reloaded, err := current.Reload(bus)
+	require.NoError(t, err)
+	require.Equal(t, "restored", reloaded.P2pNode.Name)
+	require.Equal(t, "secret-identity", reloaded.P2pNode.Identity)
+	require.Equal(t, config.EncryptionPassphrase, reloaded.Encryption())
+
+	// pending restore sealed with another key isn't applied
+	other := config.NewConfig(bus)
+	require.NoError(t, SavePendingRestore(current, snapshot))
+	_, err = ApplyPendingRestore(other)
+	require.ErrorIs(t, err, config.ErrConfigLocked)
+}
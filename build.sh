@@ -54,7 +54,7 @@ install-wintun() {
 # build for linux OS
 gobuild-linux() {
   name="$1"
-  for arch in 386 amd64 arm arm64 mips mipsle; do
+  for arch in 386 amd64 arm arm64 mips mipsle riscv64; do
     archive_name="$name-linux-$arch-$VERSION.tar.gz"
     filename="$name"
     CGO_ENABLED=0 GOOS=linux GOARCH=$arch go build -trimpath -ldflags "-s -w -X github.com/anywherelan/awl/config.Version=${VERSION}" -o "$filename"
@@ -80,7 +80,7 @@ gobuild-macos() {
 # build for windows OS
 gobuild-windows() {
   name="$1"
-  for arch in 386 amd64; do
+  for arch in 386 amd64 arm64; do
     install-wintun "$arch"
     archive_name="$name-windows-$arch-$VERSION.zip"
     filename="$name.exe"
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/anywherelan/awl/api/apiclient"
+)
+
+func runBackup(api *apiclient.Client) error {
+	results, err := api.RunBackup()
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Printf("%s: failed: %s\n", result.PeerID, result.Error)
+			continue
+		}
+		fmt.Printf("%s: stored\n", result.PeerID)
+	}
+	return nil
+}
+
+func restoreBackup(api *apiclient.Client, peerID, ownerPeerID, passphrase string) error {
+	response, err := api.RestoreBackup(peerID, ownerPeerID, passphrase)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("downloaded backup of %s created at %s\n", response.PeerID, response.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Println("restart awl to apply it")
+	return nil
+}
+
+func exportBackup(api *apiclient.Client, path, passphrase string, withoutIdentity bool) error {
+	if passphrase == "" {
+		var err error
+		passphrase, err = readNewPassphrase()
+		if err != nil {
+			return err
+		}
+	}
+	data, err := api.ExportBackup(passphrase, withoutIdentity)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	if withoutIdentity {
+		fmt.Printf("exported template to %s, nodes which import it keep their identity\n", path)
+	} else {
+		fmt.Printf("exported to %s, it contains identity of the node, keep it safe\n", path)
+	}
+	return nil
+}
+
+func importBackup(api *apiclient.Client, path, passphrase string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	response, err := api.ImportBackup(passphrase, data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported backup of %s created at %s\n", response.PeerID, response.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Println("restart awl to apply it")
+	return nil
+}
@@ -2,17 +2,25 @@ package cli
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GrigoryKrasnochub/updaterini"
 	"github.com/anywherelan/awl/api/apiclient"
 	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/logview"
+	"github.com/anywherelan/awl/peerimport"
+	"github.com/anywherelan/awl/sysservice"
 	"github.com/anywherelan/awl/update"
 	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
@@ -22,6 +30,10 @@ import (
 const (
 	WithEnvCommandName = "with-env"
 	CliCommandName     = "cli"
+	// CtlCommandName is an alias of CliCommandName, e.g. 'awl ctl peers status'
+	CtlCommandName = "ctl"
+	// ServiceCommandName is a shortcut for 'cli service', e.g. 'awl service install'
+	ServiceCommandName = "service"
 )
 
 var defaultApiAddr = "127.0.0.1:" + strconv.Itoa(config.DefaultHTTPPort)
@@ -50,13 +62,21 @@ func (a *Application) Run() {
 	} else if os.Args[1] == WithEnvCommandName {
 		// is handled in linux_root_hacks.go
 		return
-	} else if os.Args[1] == CliCommandName {
+	}
+
+	args := os.Args[1:]
+	switch os.Args[1] {
+	case CliCommandName:
 		// ok, handle here below
-	} else {
+	case CtlCommandName:
+		args[0] = CliCommandName
+	case ServiceCommandName:
+		args = append([]string{CliCommandName}, args...)
+	default:
 		a.logger.Fatalf("Unknown command '%s', try '%s cli -h' for info on cli commands or '%s' to start awl server", os.Args[1], binaryName, binaryName)
 	}
 
-	err := a.cliapp.Run(os.Args[1:])
+	err := a.cliapp.Run(args)
 	if err != nil {
 		a.logger.Fatalf("Error occurred: %v", err)
 	}
@@ -79,239 +99,1785 @@ func (a *Application) init() {
 				Usage:    fmt.Sprintf("awl api address, example: %s", defaultApiAddr),
 				Required: false,
 			},
-		},
-		Commands: []*cli.Command{
+			&cli.StringFlag{
+				Name:    "api_key",
+				Usage:   "awl api key, it is required if api keys are created and api is not on localhost",
+				EnvVars: []string{"AWL_API_KEY"},
+			},
+			&cli.BoolFlag{
+				Name:  "api_tls",
+				Usage: "connect to api with https, it is enabled if api_ca_file is set",
+			},
+			&cli.StringFlag{
+				Name:  "api_ca_file",
+				Usage: "PEM encoded CA certificates to verify api server, system ones are used by default",
+			},
+			&cli.StringFlag{
+				Name: "host",
+				Usage: "name or id of known peer to manage instead of this node, commands are sent over awl network. " +
+					"The peer should allow it with 'peers management'",
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "me",
+				Usage: "Group of commands to work with your status and settings",
+				Subcommands: []*cli.Command{
+					{
+						Name:    "status",
+						Aliases: []string{"stats"},
+						Usage:   "Print your server status, network stats",
+						Before:  a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return printStatus(a.api)
+						},
+					},
+					{
+						Name:   "id",
+						Usage:  "Print your peer id",
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return printPeerId(a.api)
+						},
+					},
+					{
+						Name:  "rotate_identity",
+						Usage: "Replace your identity key, trusted peers move to the new peer id. It's applied after restart",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:     "quiet",
+								Aliases:  []string{"q"},
+								Usage:    "rotate without confirmation message",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "status",
+								Usage:    "print the last rotation and peers which haven't received the new peer id",
+								Required: false,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							if c.Bool("status") {
+								return printIdentityMigration(a.api)
+							}
+							if !c.Bool("quiet") {
+								ok, err := a.yesNoPrompt("rotate identity key, peers which don't receive the new peer id should add you again", false)
+								if !ok || err != nil {
+									return err
+								}
+							}
+							return rotateIdentity(a.api)
+						},
+					},
+					{
+						Name:  "encrypt_config",
+						Usage: "Encrypt config with identity key and peers at rest with passphrase or os keychain",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "mode",
+								Usage:    "passphrase, keychain or none to store config as plain json",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "passphrase",
+								Usage:    "passphrase for passphrase mode, it's asked in terminal if empty",
+								Required: false,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							mode := c.String("mode")
+							switch mode {
+							case "none":
+								mode = config.EncryptionNone
+							case config.EncryptionPassphrase, config.EncryptionKeychain:
+							default:
+								return fmt.Errorf("unknown mode %q", mode)
+							}
+							return updateConfigEncryption(a.api, mode, c.String("passphrase"))
+						},
+					},
+					{
+						Name:  "rename",
+						Usage: "Rename your peer",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: true,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return renameMe(a.api, c.String("name"))
+						},
+					},
+					{
+						Name:  "dns_records",
+						Usage: "Set additional dns names which friends resolve to your peer, e.g. --record plex for plex.<your domain>.awl",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  "record",
+								Usage: "dns record, can be repeated. Omit to remove all records",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return updateMyDNSRecords(a.api, c.StringSlice("record"))
+						},
+					},
+					{
+						Name:  "kill_switch",
+						Usage: "Drop traffic to all peers while they are not confirmed or not connected",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:     "enabled",
+								Usage:    "enabled",
+								Required: false,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setKillSwitch(a.api, c.Bool("enabled"))
+						},
+					},
+					{
+						Name:   "vpn_pause",
+						Usage:  "Bring vpn interface down without disconnecting from peers",
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setVPNPause(a.api, true)
+						},
+					},
+					{
+						Name:   "vpn_resume",
+						Usage:  "Bring vpn interface up after vpn_pause",
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setVPNPause(a.api, false)
+						},
+					},
+					{
+						Name:  "rendezvous",
+						Usage: "Find peers which set the same secret phrase and become friends with them without confirmation",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "phrase",
+								Usage:    "secret phrase shared with friends, e.g. several random words, empty phrase disables rendezvous",
+								Required: true,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setRendezvous(a.api, c.String("phrase"))
+						},
+					},
+					{
+						Name:  "dns_upstream",
+						Usage: "Resolve dns queries with known peer, e.g. the peer with Pi-hole. It should allow using it as exit node",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id, empty to resolve queries with upstream address",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "address",
+								Usage:    "dns server which resolves our queries and queries of peers, e.g. 127.0.0.1:5353. Empty uses the system one",
+								Required: false,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setDNSUpstream(a.api, c.String("pid"), c.String("address"))
+						},
+					},
+					{
+						Name:  "exit_node",
+						Usage: "Route internet traffic through known peer, it should allow using it as exit node",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id, empty to route traffic directly",
+								Required: false,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setExitNode(a.api, c.String("pid"))
+						},
+					},
+					{
+						Name:  "advertise_routes",
+						Usage: "Let friends reach your networks through your peer, e.g. --route 192.168.1.0/24 for home LAN",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  "route",
+								Usage: "IPv4 network in CIDR notation, can be repeated. Omit to stop advertising",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setAdvertisedRoutes(a.api, c.StringSlice("route"))
+						},
+					},
+					{
+						Name:  "shared_folder",
+						Usage: "Share folder with friends over WebDAV, access is granted to each peer with 'peers shared_folder'",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "path",
+								Usage:    "absolute path of the folder, empty to stop sharing",
+								Required: false,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setSharedFolder(a.api, c.String("path"))
+						},
+					},
+					{
+						Name:  "inbox",
+						Usage: "Set folder for files sent by friends with 'peers send_file'",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "path",
+								Usage:    "absolute path of the folder, empty to refuse files",
+								Required: false,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setFileTransferInbox(a.api, c.String("path"))
+						},
+					},
+					{
+						Name:  "connection_gater",
+						Usage: "Refuse connections of denied peers, and optionally of all peers which are not known. Prints settings without flags",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "only_known_peers",
+								Usage: "accept connections only from known and allowed peers, bootstrap peers and relays",
+							},
+							&cli.StringSliceFlag{
+								Name:  "allow",
+								Usage: "peer id to add to allowlist",
+							},
+							&cli.StringSliceFlag{
+								Name:  "disallow",
+								Usage: "peer id to remove from allowlist",
+							},
+							&cli.StringSliceFlag{
+								Name:  "deny",
+								Usage: "peer id to add to denylist",
+							},
+							&cli.StringSliceFlag{
+								Name:  "undeny",
+								Usage: "peer id to remove from denylist",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							var onlyKnownPeers *bool
+							if c.IsSet("only_known_peers") {
+								value := c.Bool("only_known_peers")
+								onlyKnownPeers = &value
+							}
+							return updateConnectionGater(a.api, onlyKnownPeers,
+								c.StringSlice("allow"), c.StringSlice("disallow"), c.StringSlice("deny"), c.StringSlice("undeny"))
+						},
+					},
+					{
+						Name:  "packet_filter",
+						Usage: "Drop unwanted traffic read from the interface before it's routed to peers. Prints filter and dropped packets without flags",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "drop_ipv6",
+								Usage: "drop all IPv6 packets",
+							},
+							&cli.BoolFlag{
+								Name:  "drop_multicast",
+								Usage: "drop multicast and broadcast packets, e.g. mDNS and SSDP",
+							},
+							&cli.IntSliceFlag{
+								Name:  "drop_protocol",
+								Usage: "IP protocol number to drop, e.g. 2 for IGMP. Previous protocols are replaced",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							var dropIPv6, dropMulticast *bool
+							if c.IsSet("drop_ipv6") {
+								value := c.Bool("drop_ipv6")
+								dropIPv6 = &value
+							}
+							if c.IsSet("drop_multicast") {
+								value := c.Bool("drop_multicast")
+								dropMulticast = &value
+							}
+							var dropProtocols []int
+							if c.IsSet("drop_protocol") {
+								dropProtocols = c.IntSlice("drop_protocol")
+							}
+							return updatePacketFilter(a.api, dropIPv6, dropMulticast, dropProtocols)
+						},
+					},
+					{
+						Name:  "ip_allocation",
+						Usage: "Set strategy of addresses of new peers",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name: "strategy",
+								Usage: "sequential after the highest address, hash of peer id which is the same on every node, " +
+									"manual which requires --ip when peers are added, or negotiate which uses the address the peer has for itself",
+								Required: true,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return updateIPAllocation(a.api, c.String("strategy"))
+						},
+					},
+					{
+						Name:  "subnet_conflicts",
+						Usage: "Print the vpn network and routes of peers which overlap local networks",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "auto_renumber",
+								Usage: "move the vpn network to a free one on start if it overlaps a local network",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							var autoRenumber *bool
+							if c.IsSet("auto_renumber") {
+								value := c.Bool("auto_renumber")
+								autoRenumber = &value
+							}
+							return subnetConflicts(a.api, autoRenumber)
+						},
+					},
+					{
+						Name:  "qos",
+						Usage: "Prioritize interactive traffic to peers when the link is saturated. Prints rules and packets by priority without flags",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "disabled",
+								Usage: "send packets in arrival order",
+							},
+							&cli.StringSliceFlag{
+								Name:  "rule",
+								Usage: "rule as [protocol:]ports:priority, e.g. tcp:22:low for scp or 5000-5100:high. Previous rules are replaced",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							var disabled *bool
+							if c.IsSet("disabled") {
+								value := c.Bool("disabled")
+								disabled = &value
+							}
+							var rules []string
+							if c.IsSet("rule") {
+								rules = c.StringSlice("rule")
+							}
+							return updateQoS(a.api, disabled, rules)
+						},
+					},
+					{
+						Name:  "bandwidth_limit",
+						Usage: "Limit VPN and SOCKS5 traffic with all peers in total. Prints limits of all peers without flags",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "upload",
+								Usage: "upload limit in kilobits per second, 0 is unlimited",
+							},
+							&cli.IntFlag{
+								Name:  "download",
+								Usage: "download limit in kilobits per second, 0 is unlimited",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							var upload, download *int
+							if c.IsSet("upload") {
+								value := c.Int("upload")
+								upload = &value
+							}
+							if c.IsSet("download") {
+								value := c.Int("download")
+								download = &value
+							}
+							return updateBandwidthLimit(a.api, upload, download)
+						},
+					},
+					{
+						Name:  "power",
+						Usage: "Change power mode, battery mode reduces background network activity. Prints power profile without flags",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "mode",
+								Usage: "auto (detect power source), ac or battery",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return updatePowerMode(a.api, c.String("mode"))
+						},
+					},
+					{
+						Name:  "bootstrap_peers",
+						Usage: "Change bootstrap peers without restart. Prints bootstrap peers without flags",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  "add",
+								Usage: "multiaddr with peer id to add, e.g. /ip4/203.0.113.1/tcp/6150/p2p/12D3KooW...",
+							},
+							&cli.StringSliceFlag{
+								Name:  "remove",
+								Usage: "multiaddr to remove",
+							},
+							&cli.StringFlag{
+								Name:  "test",
+								Usage: "multiaddr with peer id to test connectivity with, it doesn't have to be added",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return updateBootstrapPeers(a.api, c.StringSlice("add"), c.StringSlice("remove"), c.String("test"))
+						},
+					},
+					{
+						Name:  "socks5",
+						Usage: "Run local SOCKS5 server which tunnels connections through known peer, it should allow using it as exit node",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "listen",
+								Usage:    "listen address, e.g. 127.0.0.1:1080, empty to stop the server",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.IntSliceFlag{
+								Name:  "resume_port",
+								Usage: "destination port of connections which survive brief outages of the peer, e.g. 22 for SSH",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return setSOCKS5(a.api, c.String("listen"), c.String("pid"), c.IntSlice("resume_port"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "api_keys",
+				Usage: "Group of commands to work with api keys. Api requires keys after the first one is created",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "Print api keys",
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return printAPIKeys(a.api)
+						},
+					},
+					{
+						Name:  "create",
+						Usage: "Create api key and print it, the key can't be printed again",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "unique key name",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "read_only",
+								Usage: "allow only read-only api, the same as kiosk web server",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return createAPIKey(a.api, c.String("name"), c.Bool("read_only"))
+						},
+					},
+					{
+						Name:  "remove",
+						Usage: "Remove api key",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "key name",
+								Required: true,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return removeAPIKey(a.api, c.String("name"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "peers",
+				Usage: "Group of commands to work with peers. Use to check friend requests and work with known peers",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "status",
+						Usage: "Print peers status",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "format",
+								Aliases:  []string{"f"},
+								Required: false,
+								Value:    "npslucv",
+								Usage: "control table columns list and order.Each char add column, write column chars together without gap. Use these chars to add specific columns:\n   " +
+									"n - peers number\n   p - peers name, domain and ip address\n   i - peers id\n   s - peers status and connection type (direct or relayed)\n   l - peers last seen datetime\n   v - peers awl version" +
+									"\n   u - network usage by peer (in/out)\n   t - traffic by category: vpn, forwarding (SOCKS5 proxy), services, control" +
+									"\n   q - connection quality: latency, packet loss of recent pings and direct or relayed path" +
+									"\n   g - peer groups" +
+									"\n   c - list of peers connections (IP address + protocol)\n  ",
+							},
+							&cli.StringFlag{
+								Name:  "group",
+								Usage: "print only peers of the group",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return printPeersStatus(a.api, c.String("format"), c.String("group"))
+						},
+					},
+					{
+						Name:   "requests",
+						Usage:  "Print all incoming friend requests",
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return printFriendRequests(a.api)
+						},
+					},
+					{
+						Name:  "decline",
+						Usage: "Decline incoming friend request, the peer is blocked",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: true,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return declineFriendRequest(a.api, c.String("pid"))
+						},
+					},
+					{
+						Name:  "add",
+						Usage: "Invite peer or accept existing invitation from this peer",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "ip",
+								Usage: "peer address in vpn network, it's allocated by ip allocation strategy by default",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return addPeer(a.api, c.String("pid"), c.String("name"), c.String("ip"))
+						},
+					},
+					{
+						Name:  "invite",
+						Usage: "Create one-time invite token, friend request of the peer which uses it is accepted automatically",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "peer name given to the invited peer, it's generated from its name by default",
+							},
+							&cli.IntFlag{
+								Name:  "expires",
+								Usage: "lifetime of the invite in hours",
+								Value: 24,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return createInvite(a.api, c.String("name"), c.Int("expires"))
+						},
+					},
+					{
+						Name:  "join",
+						Usage: "Add the peer which created invite token, it becomes friend without confirmation",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "token",
+								Usage:    "invite token",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "peer name, it's taken from the token by default",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return acceptInvite(a.api, c.String("token"), c.String("name"))
+						},
+					},
+					{
+						Name:  "remove",
+						Usage: "Remove peer from the friends list",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return removePeer(a.api, c.String("pid"))
+						},
+					},
+					{
+						Name:  "rename",
+						Usage: "Change known peer name",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "new_name",
+								Usage:    "peer new name",
+								Required: true,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return changePeerAlias(a.api, c.String("pid"), c.String("new_name"))
+						},
+					},
+					{
+						Name:  "update_domain",
+						Usage: "Change known peer domain name",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "domain",
+								Usage:    "peer domain name",
+								Required: true,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return changePeerDomain(a.api, c.String("pid"), c.String("domain"))
+						},
+					},
+					{
+						Name:  "update_ip",
+						Usage: "Change known peer address in vpn network",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "ip",
+								Usage:    "peer address, it should be free and in vpn network",
+								Required: true,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return changePeerIPAddr(a.api, c.String("pid"), c.String("ip"))
+						},
+					},
+					{
+						Name:  "allow_exit_node",
+						Usage: "Allow known peer to use this device as exit node (as socks5 proxy)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "allow",
+								Usage:    "allow",
+								Required: false,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setAllowUsingAsExitNode(a.api, c.String("pid"), c.Bool("allow"))
+						},
+					},
+					{
+						Name:  "kill_switch",
+						Usage: "Drop traffic to known peer while it is not confirmed or not connected",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "enabled",
+								Usage:    "enabled",
+								Required: false,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerKillSwitch(a.api, c.String("pid"), c.Bool("enabled"))
+						},
+					},
+					{
+						Name:  "shared_folder",
+						Usage: "Set known peer access to our shared folder. Peer's folder is mounted as WebDAV at http://<api address>/shared/<peer id>/",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "access",
+								Usage:    "read, write or empty to deny access",
+								Required: false,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerSharedFolderAccess(a.api, c.String("pid"), c.String("access"))
+						},
+					},
+					{
+						Name:  "echo",
+						Usage: "Check connection to the peer end-to-end by sending echo request",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.IntFlag{
+								Name:  "size",
+								Usage: "payload size in bytes",
+								Value: 1024,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return echoPeer(a.api, c.String("pid"), c.Int("size"))
+						},
+					},
+					{
+						Name:  "speedtest",
+						Usage: "Measure upload, download and latency under load to the peer, results are kept in history for 90 days",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "history",
+								Usage:    "print daily trends of previous tests instead of running a new one",
+								Required: false,
+							},
+							&cli.IntFlag{
+								Name:  "days",
+								Usage: "number of days of history",
+								Value: 30,
+							},
+							&cli.IntFlag{
+								Name:  "seconds",
+								Usage: "duration of each direction, max 20",
+								Value: 5,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							if c.Bool("history") {
+								return printSpeedTestHistory(a.api, c.String("pid"), c.Int("days"))
+							}
+							return runSpeedTest(a.api, c.String("pid"), c.Int("seconds"))
+						},
+					},
+					{
+						Name:  "send_file",
+						Usage: "Send file to known peer, it's saved to the peer's inbox. Interrupted transfer is resumed",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "path",
+								Usage:    "path of the file",
+								Required: true,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return sendFile(a.api, c.String("pid"), c.String("path"))
+						},
+					},
+					{
+						Name:   "transfers",
+						Usage:  "Print active and recent file transfers",
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return printFileTransfers(a.api)
+						},
+					},
+					{
+						Name:  "message",
+						Usage: "Send short text message to known peer",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "text",
+								Usage:    "message text",
+								Required: true,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return sendMessage(a.api, c.String("pid"), c.String("text"))
+						},
+					},
+					{
+						Name:  "messages",
+						Usage: "Print messages with known peer or with all peers, they are marked as read",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.IntFlag{
+								Name:  "limit",
+								Usage: "number of the latest messages",
+								Value: 20,
+							},
+						},
+						Before: func(c *cli.Context) error {
+							if c.String("pid") == "" && c.String("name") == "" {
+								return a.initApiConnection(c)
+							}
+							return a.initApiAndPeerId(c)
+						},
+						Action: func(c *cli.Context) error {
+							return printMessages(a.api, c.String("pid"), c.Int("limit"))
+						},
+					},
+					{
+						Name:  "support_access",
+						Usage: "Allow known peer to fetch recent logs and diagnostics report of this device to help with troubleshooting",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "allow",
+								Usage:    "allow",
+								Required: false,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerSupportAccess(a.api, c.String("pid"), c.Bool("allow"))
+						},
+					},
+					{
+						Name:  "management",
+						Usage: "Allow known peer to administer this device over awl network, e.g. with 'awl cli --host'. It gives full access like admin api key",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "allow",
+								Usage:    "allow",
+								Required: false,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerManagementAllowed(a.api, c.String("pid"), c.Bool("allow"))
+						},
+					},
+					{
+						Name:  "acl",
+						Usage: "Print or replace access control list of known peer, the first matching rule is applied",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringSliceFlag{
+								Name:  "rule",
+								Usage: "rule in format 'allow|deny [in|out] [tcp|udp|icmp] [port or range]', e.g. 'deny in tcp 22', could be repeated",
+							},
+							&cli.BoolFlag{
+								Name:  "clear",
+								Usage: "remove all rules, traffic with the peer is allowed",
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerACL(a.api, c.String("pid"), c.StringSlice("rule"), c.Bool("clear"))
+						},
+					},
+					{
+						Name:  "set_groups",
+						Usage: "Replace groups of known peer, policies of the groups are applied to the peer along with its own settings",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringSliceFlag{
+								Name:  "group",
+								Usage: "group name, e.g. 'work' or 'home-lab', could be repeated. Without groups the peer is removed from all groups",
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerGroups(a.api, c.String("pid"), c.StringSlice("group"))
+						},
+					},
+					{
+						Name:   "groups",
+						Usage:  "Print peer groups with their policies and members",
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return printPeerGroups(a.api)
+						},
+					},
+					{
+						Name:  "update_group",
+						Usage: "Replace policy of peer group, its ACL rules are checked after rules of each member",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "group",
+								Usage:    "group name",
+								Required: true,
+							},
+							&cli.StringSliceFlag{
+								Name:  "rule",
+								Usage: "rule in format 'allow|deny [in|out] [tcp|udp|icmp] [port or range]', e.g. 'deny in tcp 22', could be repeated",
+							},
+							&cli.BoolFlag{
+								Name:  "allow_exit_node",
+								Usage: "allow members to use this node as exit node and router",
+							},
+							&cli.BoolFlag{
+								Name:  "shared",
+								Usage: "publish members of the group signed by this node to the members",
+							},
+							&cli.StringFlag{
+								Name:  "owner",
+								Usage: "peer id of the owner which shares the group with the same name, members are synced from it",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return updatePeerGroup(a.api, c.String("group"), c.StringSlice("rule"), c.Bool("allow_exit_node"),
+								c.Bool("shared"), c.String("owner"))
+						},
+					},
+					{
+						Name:  "remove_group",
+						Usage: "Remove policy of peer group and the group from all peers",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "group",
+								Usage:    "group name",
+								Required: true,
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return removePeerGroup(a.api, c.String("group"))
+						},
+					},
+					{
+						Name:  "mtu",
+						Usage: "Limit MTU of the path to known peer, TCP MSS of connections with it is clamped to fit MTU",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.IntFlag{
+								Name:     "mtu",
+								Usage:    "path MTU, e.g. 1400, 0 removes the limit",
+								Required: true,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerMTU(a.api, c.String("pid"), c.Int("mtu"))
+						},
+					},
+					{
+						Name:  "routes",
+						Usage: "Accept subnet routes advertised by known peer, only private networks not wider than /16 are accepted by default",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "accept",
+								Usage:    "route traffic to networks advertised by the peer",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:     "all",
+								Usage:    "accept public networks and ones wider than /16 as well",
+								Required: false,
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return setPeerRoutes(a.api, c.String("pid"), c.Bool("accept"), c.Bool("all"))
+						},
+					},
+					{
+						Name:  "bandwidth_limit",
+						Usage: "Limit VPN and SOCKS5 traffic with known peer, the global limit is applied as well. Omitted limits are removed",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.IntFlag{
+								Name:  "upload",
+								Usage: "upload limit in kilobits per second, 0 is unlimited",
+							},
+							&cli.IntFlag{
+								Name:  "download",
+								Usage: "download limit in kilobits per second, 0 is unlimited",
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							limit := config.BandwidthLimitConfig{UploadKbps: c.Int("upload"), DownloadKbps: c.Int("download")}
+							return setPeerBandwidthLimit(a.api, c.String("pid"), limit)
+						},
+					},
+					{
+						Name:  "notes",
+						Usage: "Print or update notes and contact info of known peer, only given fields are changed",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:  "notes",
+								Usage: "free-form notes",
+							},
+							&cli.StringFlag{
+								Name:  "owner",
+								Usage: "who owns the device",
+							},
+							&cli.StringFlag{
+								Name:  "phone",
+								Usage: "phone number of the owner",
+							},
+							&cli.StringFlag{
+								Name:  "email",
+								Usage: "email of the owner",
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							fields := make(map[string]string)
+							for _, name := range []string{"notes", "owner", "phone", "email"} {
+								if c.IsSet(name) {
+									fields[name] = c.String(name)
+								}
+							}
+							return updatePeerNotes(a.api, c.String("pid"), fields)
+						},
+					},
+					{
+						Name:  "support_report",
+						Usage: "Fetch recent logs and diagnostics report from the peer, it should allow support access for us",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.IntFlag{
+								Name:  "minutes",
+								Usage: "period of the latest logs in minutes, max 1440",
+								Value: 30,
+							},
+							&cli.StringFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Usage:   "file to save logs, prints to stdout by default",
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return supportReport(a.api, c.String("pid"), c.Int("minutes"), c.String("output"))
+						},
+					},
+					{
+						Name:  "port_scan",
+						Usage: "Print listening TCP ports of the peer or a host of its advertised routes, it should allow management for us",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "pid",
+								Usage:    "peer id",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "peer name",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:  "lan_host",
+								Usage: "IPv4 address from advertised routes of the peer, the peer itself by default",
+							},
+							&cli.StringFlag{
+								Name:  "ports",
+								Usage: "ports and ranges separated by commas, up to 4096 ports",
+								Value: "1-1024",
+							},
+						},
+						Before: a.initApiAndPeerId,
+						Action: func(c *cli.Context) error {
+							return portScan(a.api, c.String("pid"), c.String("lan_host"), c.String("ports"))
+						},
+					},
+					{
+						Name:  "import",
+						Usage: "Import peers from Tailscale (tailscale status --json), ZeroTier (Central API member list), Nebula (nebula-cert print -json) or csv with name,ip,peer_id columns",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "format",
+								Usage:    "export format: " + strings.Join(peerimport.Formats, ", "),
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "file",
+								Usage:    "path to exported file",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "dry_run",
+								Usage: "only show what would be imported",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return importPeers(a.api, c.String("format"), c.String("file"), c.Bool("dry_run"))
+						},
+					},
+				},
+			},
+			{
+				Name:    "logs",
+				Aliases: []string{"log"},
+				Usage:   "Prints application logs (default print 10 logs from the end of logs)",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:     "head",
+						Usage:    "print logs from the beginning of logs",
+						Required: false,
+					},
+					&cli.IntFlag{
+						Name:     "n",
+						Usage:    "define number of rows of logs to output. Use 0 to print all",
+						Required: false,
+						Value:    10,
+					},
+					&cli.BoolFlag{
+						Name:    "follow",
+						Aliases: []string{"f"},
+						Usage:   "print new logs until interrupted",
+					},
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:  "download",
+						Usage: "Save logs for time range, they are taken from log file if it is enabled",
+						Flags: []cli.Flag{
+							&cli.TimestampFlag{
+								Name:     "from",
+								Usage:    "skip logs written before this time",
+								Layout:   logview.TimeLayout,
+								Timezone: time.Local,
+							},
+							&cli.TimestampFlag{
+								Name:     "to",
+								Usage:    "skip logs written after this time",
+								Layout:   logview.TimeLayout,
+								Timezone: time.Local,
+							},
+							&cli.StringFlag{
+								Name:  "level",
+								Usage: "minimal level: debug, info, warn, error",
+							},
+							&cli.StringFlag{
+								Name:  "logger",
+								Usage: "logger name prefix, e.g. awl/vpn",
+							},
+							&cli.StringFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Usage:   "file to save logs, prints to stdout by default",
+							},
+						},
+						Before: a.initApiConnection,
+						Action: func(c *cli.Context) error {
+							return downloadLogs(a.api, c.Timestamp("from"), c.Timestamp("to"), c.String("level"), c.String("logger"), c.String("output"))
+						},
+					},
+				},
+				Before: a.initApiConnection,
+				Action: func(c *cli.Context) error {
+					if c.Bool("follow") {
+						return followLogs(c.Context, a.api, c.Int("n"))
+					}
+					logs, err := a.api.ApplicationLog(c.Int("n"), c.Bool("head"))
+					if err != nil {
+						return err
+					}
+					fmt.Println(logs)
+
+					return nil
+				},
+			},
+			{
+				Name:   "p2p_info",
+				Usage:  "Prints p2p debug info",
+				Before: a.initApiConnection,
+				Action: func(*cli.Context) error {
+					debugInfo, err := a.api.P2pDebugInfo()
+					if err != nil {
+						return err
+					}
+
+					bytes, err := json.MarshalIndent(debugInfo, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(bytes))
+
+					return nil
+				},
+			},
+			{
+				Name:   "stats",
+				Usage:  "Prints p2p stats snapshot",
+				Before: a.initApiConnection,
+				Action: func(*cli.Context) error {
+					stats, err := a.api.StatsSnapshot()
+					if err != nil {
+						return err
+					}
+
+					bytes, err := json.MarshalIndent(stats, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(bytes))
+
+					return nil
+				},
+			},
+			{
+				Name:  "dht",
+				Usage: "Prints DHT routing table grouped by buckets",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print in json format",
+					},
+				},
+				Before: a.initApiConnection,
+				Action: func(c *cli.Context) error {
+					return printDHTRoutingTable(a.api, c.Bool("json"))
+				},
+			},
+			{
+				Name:  "peer",
+				Usage: "Prints diagnostics of connection with the peer: addresses, dial errors, connections, NAT reachability and hole punching attempts. Disconnected peer is looked up and dialed, it takes up to 30 seconds",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "pid",
+						Usage:    "peer id",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "name",
+						Usage:    "peer name",
+						Required: false,
+					},
+				},
+				Before: a.initApiAndPeerId,
+				Action: func(c *cli.Context) error {
+					diagnostics, err := a.api.PeerDiagnostics(c.String("pid"))
+					if err != nil {
+						return err
+					}
+
+					bytes, err := json.MarshalIndent(diagnostics, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(bytes))
+
+					return nil
+				},
+			},
+			{
+				Name:  "flows",
+				Usage: "Prints active connections through the tunnel",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print in json format",
+					},
+				},
+				Before: a.initApiConnection,
+				Action: func(c *cli.Context) error {
+					return printFlows(a.api, c.Bool("json"))
+				},
+			},
+			{
+				Name:      "search",
+				Usage:     "Search known peers by id, name, alias, ip and domain, and events in logs",
+				ArgsUsage: "<query>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "max number of events",
+						Value: 20,
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print in json format",
+					},
+				},
+				Before: a.initApiConnection,
+				Action: func(c *cli.Context) error {
+					return search(a.api, strings.Join(c.Args().Slice(), " "), c.Int("limit"), c.Bool("json"))
+				},
+			},
+			{
+				Name:  "events",
+				Usage: "Prints events, e.g. connected peers and auth requests, until interrupted",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "type",
+						Usage: "event type to print, e.g. PeerConnected, all events by default",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print in json format",
+					},
+				},
+				Before: a.initApiConnection,
+				Action: func(c *cli.Context) error {
+					return printEvents(c.Context, a.api, c.StringSlice("type"), c.Bool("json"))
+				},
+			},
 			{
-				Name:  "me",
-				Usage: "Group of commands to work with your status and settings",
-				Subcommands: []*cli.Command{
-					{
-						Name:    "status",
-						Aliases: []string{"stats"},
-						Usage:   "Print your server status, network stats",
-						Before:  a.initApiConnection,
-						Action: func(c *cli.Context) error {
-							return printStatus(a.api)
-						},
+				Name:  "usage",
+				Usage: "Exports per-peer traffic by hour or connection history as CSV",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "traffic or connections",
+						Value: "traffic",
 					},
+					&cli.TimestampFlag{
+						Name:     "from",
+						Usage:    "skip records before this time",
+						Layout:   logview.TimeLayout,
+						Timezone: time.Local,
+					},
+					&cli.TimestampFlag{
+						Name:     "to",
+						Usage:    "skip records after this time",
+						Layout:   logview.TimeLayout,
+						Timezone: time.Local,
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "file to save csv, prints to stdout by default",
+					},
+				},
+				Before: a.initApiConnection,
+				Action: func(c *cli.Context) error {
+					return exportUsage(a.api, c.String("type"), c.Timestamp("from"), c.Timestamp("to"), c.String("output"))
+				},
+			},
+			{
+				Name:  "backup",
+				Usage: "Backup config and storage to peers or restore them",
+				Subcommands: []*cli.Command{
 					{
-						Name:   "id",
-						Usage:  "Print your peer id",
+						Name:   "run",
+						Usage:  "Store encrypted backup on peers from config now",
 						Before: a.initApiConnection,
 						Action: func(c *cli.Context) error {
-							return printPeerId(a.api)
+							return runBackup(a.api)
 						},
 					},
 					{
-						Name:  "rename",
-						Usage: "Rename your peer",
+						Name:  "restore",
+						Usage: "Download backup from the peer, it will be applied after restart",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "name",
-								Usage:    "peer name",
+								Name:     "pid",
+								Usage:    "id of the peer which stores backup",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "owner",
+								Usage:    "peer id of the node which created backup",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "passphrase",
+								Usage:    "backup passphrase",
 								Required: true,
 							},
 						},
 						Before: a.initApiConnection,
 						Action: func(c *cli.Context) error {
-							return renameMe(a.api, c.String("name"))
+							return restoreBackup(a.api, c.String("pid"), c.String("owner"), c.String("passphrase"))
 						},
 					},
-				},
-			},
-			{
-				Name:  "peers",
-				Usage: "Group of commands to work with peers. Use to check friend requests and work with known peers",
-				Subcommands: []*cli.Command{
 					{
-						Name:  "status",
-						Usage: "Print peers status",
+						Name:  "export",
+						Usage: "Export config and storage to encrypted archive, e.g. to move the node to another machine",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "format",
-								Aliases:  []string{"f"},
+								Name:     "out",
+								Usage:    "path of the archive",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "passphrase",
+								Usage:    "archive passphrase, it's asked in terminal if empty",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "without-identity",
+								Usage:    "export template for other nodes without identity and storage",
 								Required: false,
-								Value:    "npslucv",
-								Usage: "control table columns list and order.Each char add column, write column chars together without gap. Use these chars to add specific columns:\n   " +
-									"n - peers number\n   p - peers name, domain and ip address\n   i - peers id\n   s - peers status\n   l - peers last seen datetime\n   v - peers awl version" +
-									"\n   u - network usage by peer (in/out)\n   c - list of peers connections (IP address + protocol)\n  ",
 							},
 						},
 						Before: a.initApiConnection,
 						Action: func(c *cli.Context) error {
-							return printPeersStatus(a.api, c.String("format"))
-						},
-					},
-					{
-						Name:   "requests",
-						Usage:  "Print all incoming friend requests",
-						Before: a.initApiConnection,
-						Action: func(c *cli.Context) error {
-							return printFriendRequests(a.api)
+							return exportBackup(a.api, c.String("out"), c.String("passphrase"), c.Bool("without-identity"))
 						},
 					},
 					{
-						Name:  "add",
-						Usage: "Invite peer or accept existing invitation from this peer",
+						Name:  "import",
+						Usage: "Import archive from 'backup export', it will be applied after restart",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "pid",
-								Usage:    "peer id",
+								Name:     "file",
+								Usage:    "path of the archive",
 								Required: true,
 							},
 							&cli.StringFlag{
-								Name:     "name",
-								Usage:    "peer name",
+								Name:     "passphrase",
+								Usage:    "archive passphrase",
 								Required: true,
 							},
 						},
 						Before: a.initApiConnection,
 						Action: func(c *cli.Context) error {
-							return addPeer(a.api, c.String("pid"), c.String("name"))
+							return importBackup(a.api, c.String("file"), c.String("passphrase"))
 						},
 					},
+				},
+			},
+			{
+				Name:  "jobs",
+				Usage: "Scheduled jobs with peers, connections with them are established shortly before the jobs",
+				Subcommands: []*cli.Command{
 					{
-						Name:  "remove",
-						Usage: "Remove peer from the friends list",
-						Flags: []cli.Flag{
-							&cli.StringFlag{
-								Name:     "pid",
-								Usage:    "peer id",
-								Required: false,
-							},
-							&cli.StringFlag{
-								Name:     "name",
-								Usage:    "peer name",
-								Required: false,
-							},
-						},
-						Before: a.initApiAndPeerId,
+						Name:   "list",
+						Usage:  "Print scheduled jobs and whether connection was direct during their last windows",
+						Before: a.initApiConnection,
 						Action: func(c *cli.Context) error {
-							return removePeer(a.api, c.String("pid"))
+							return printScheduledJobs(a.api)
 						},
 					},
 					{
-						Name:  "rename",
-						Usage: "Change known peer name",
+						Name:  "add",
+						Usage: "Add daily job with the peer",
 						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "unique job name",
+								Required: true,
+							},
 							&cli.StringFlag{
 								Name:     "pid",
 								Usage:    "peer id",
-								Required: false,
+								Required: true,
 							},
 							&cli.StringFlag{
-								Name:     "name",
-								Usage:    "peer name",
-								Required: false,
+								Name:     "time",
+								Usage:    "daily time in local timezone, e.g. 02:00",
+								Required: true,
 							},
 							&cli.StringFlag{
-								Name:     "new_name",
-								Usage:    "peer new name",
-								Required: true,
+								Name:  "action",
+								Usage: "backup to store our backup on the peer, speedtest to measure throughput, empty only keeps the connection",
+							},
+							&cli.IntFlag{
+								Name:  "duration",
+								Usage: "minutes to keep the connection after the time",
+								Value: 30,
 							},
 						},
-						Before: a.initApiAndPeerId,
+						Before: a.initApiConnection,
 						Action: func(c *cli.Context) error {
-							return changePeerAlias(a.api, c.String("pid"), c.String("new_name"))
+							return addScheduledJob(a.api, config.ScheduledJob{
+								Name:            c.String("name"),
+								PeerID:          c.String("pid"),
+								Time:            c.String("time"),
+								Action:          c.String("action"),
+								DurationMinutes: c.Int("duration"),
+							})
 						},
 					},
 					{
-						Name:  "update_domain",
-						Usage: "Change known peer domain name",
+						Name:  "remove",
+						Usage: "Remove scheduled job",
 						Flags: []cli.Flag{
-							&cli.StringFlag{
-								Name:     "pid",
-								Usage:    "peer id",
-								Required: false,
-							},
 							&cli.StringFlag{
 								Name:     "name",
-								Usage:    "peer name",
-								Required: false,
-							},
-							&cli.StringFlag{
-								Name:     "domain",
-								Usage:    "peer domain name",
+								Usage:    "job name",
 								Required: true,
 							},
 						},
-						Before: a.initApiAndPeerId,
+						Before: a.initApiConnection,
 						Action: func(c *cli.Context) error {
-							return changePeerDomain(a.api, c.String("pid"), c.String("domain"))
+							return removeScheduledJob(a.api, c.String("name"))
 						},
 					},
+				},
+			},
+			{
+				Name: ServiceCommandName,
+				Usage: "Group of commands to run awl as system service: systemd unit on linux, launchd daemon on macOS " +
+					"or Windows service. They require root or administrator privileges, sudo is used if it's available",
+				Subcommands: []*cli.Command{
 					{
-						Name:  "allow_exit_node",
-						Usage: "Allow known peer to use this device as exit node (as socks5 proxy)",
+						Name:  "install",
+						Usage: "Register awl to start on boot and restart on failures, existing service is replaced",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "pid",
-								Usage:    "peer id",
-								Required: false,
-							},
-							&cli.StringFlag{
-								Name:     "name",
-								Usage:    "peer name",
-								Required: false,
-							},
-							&cli.BoolFlag{
-								Name:     "allow",
-								Usage:    "allow",
-								Required: false,
+								Name: "data_dir",
+								Usage: "config directory of the service, by default the directory of executable if it contains config " +
+									"or system wide one, e.g. /etc/anywherelan on linux",
 							},
 						},
-						Before: a.initApiAndPeerId,
+						Before: a.requireElevation,
 						Action: func(c *cli.Context) error {
-							return setAllowUsingAsExitNode(a.api, c.String("pid"), c.Bool("allow"))
+							return a.installService(c.String("data_dir"))
 						},
 					},
-				},
-			},
-			{
-				Name:    "logs",
-				Aliases: []string{"log"},
-				Usage:   "Prints application logs (default print 10 logs from the end of logs)",
-				Flags: []cli.Flag{
-					&cli.BoolFlag{
-						Name:     "head",
-						Usage:    "print logs from the beginning of logs",
-						Required: false,
+					{
+						Name:   "uninstall",
+						Usage:  "Stop and remove the service, config is kept",
+						Before: a.requireElevation,
+						Action: func(c *cli.Context) error {
+							err := sysservice.Uninstall()
+							if err != nil {
+								return err
+							}
+							fmt.Println("service uninstalled successfully")
+							return nil
+						},
 					},
-					&cli.IntFlag{
-						Name:     "n",
-						Usage:    "define number of rows of logs to output. Use 0 to print all",
-						Required: false,
-						Value:    10,
+					{
+						Name:   "start",
+						Usage:  "Start the service",
+						Before: a.requireElevation,
+						Action: func(c *cli.Context) error {
+							err := sysservice.Start()
+							if err != nil {
+								return err
+							}
+							fmt.Println("service started successfully")
+							return nil
+						},
+					},
+					{
+						Name:   "stop",
+						Usage:  "Stop the service",
+						Before: a.requireElevation,
+						Action: func(c *cli.Context) error {
+							err := sysservice.Stop()
+							if err != nil {
+								return err
+							}
+							fmt.Println("service stopped successfully")
+							return nil
+						},
 					},
-				},
-				Before: a.initApiConnection,
-				Action: func(c *cli.Context) error {
-					logs, err := a.api.ApplicationLog(c.Int("n"), c.Bool("head"))
-					if err != nil {
-						return err
-					}
-					fmt.Println(logs)
-
-					return nil
-				},
-			},
-			{
-				Name:   "p2p_info",
-				Usage:  "Prints p2p debug info",
-				Before: a.initApiConnection,
-				Action: func(*cli.Context) error {
-					debugInfo, err := a.api.P2pDebugInfo()
-					if err != nil {
-						return err
-					}
-
-					bytes, err := json.MarshalIndent(debugInfo, "", "  ")
-					if err != nil {
-						return err
-					}
-					fmt.Println(string(bytes))
-
-					return nil
 				},
 			},
 			{
@@ -377,17 +1943,84 @@ func (a *Application) init() {
 	}
 }
 
+// requireElevation reruns the command with sudo when privileges to manage services are missing.
+func (a *Application) requireElevation(_ *cli.Context) error {
+	if sysservice.IsElevated() {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable path: %v", err)
+	}
+	err = sysservice.RunElevated(append([]string{executable}, os.Args[1:]...))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	} else if err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+func (a *Application) installService(dataDir string) error {
+	if a.updateType != update.AppTypeAwl {
+		return errors.New("service runs awl server without tray, install it with awl binary")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable path: %v", err)
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return fmt.Errorf("find executable path: %v", err)
+	}
+	if dataDir == "" {
+		dataDir = sysservice.DefaultDataDir(executable, config.AppConfigFilename)
+	}
+	dataDir, err = filepath.Abs(dataDir)
+	if err != nil {
+		return err
+	}
+
+	err = sysservice.Install(sysservice.Options{Executable: executable, DataDir: dataDir})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("service installed successfully, executable: %s, config directory: %s\n", executable, dataDir)
+	fmt.Printf("start it with '%s %s start'\n", binaryName, ServiceCommandName)
+	return nil
+}
+
 func (a *Application) initApiConnection(c *cli.Context) (err error) {
 	apiAddr := c.String("api_addr")
 	var addr string
+	// caFile is used as root certificate of api server, the same certificate from config is used for local server
+	caFile := c.String("api_ca_file")
+	useTLS := c.Bool("api_tls") || caFile != ""
 	defer func() {
 		if err != nil {
 			return
 		}
 		a.api = apiclient.New(addr)
+		if apiKey := c.String("api_key"); apiKey != "" {
+			a.api.SetAPIKey(apiKey)
+		}
+		if useTLS {
+			tlsConfig, err2 := apiTLSConfig(caFile)
+			if err2 != nil {
+				err = fmt.Errorf("api tls: %v", err2)
+				return
+			}
+			a.api.SetTLSConfig(tlsConfig)
+		}
 		_, err2 := a.api.PeerInfo()
 		if err2 != nil {
 			err = fmt.Errorf("could not access api on address %s: %v", addr, err2)
+			return
+		}
+		if host := c.String("host"); host != "" {
+			err = a.manageHost(host)
 		}
 	}()
 	if apiAddr != "" {
@@ -404,10 +2037,45 @@ func (a *Application) initApiConnection(c *cli.Context) (err error) {
 	if addr == "" {
 		return errors.New("httpListenAddress from config is empty")
 	}
+	if conf.APIAuth.CertFile != "" && !c.IsSet("api_tls") {
+		useTLS = true
+		if caFile == "" {
+			caFile = conf.APIAuth.CertFile
+		}
+	}
+
+	return nil
+}
 
+// manageHost switches api client to the known peer, host is its name or peer id.
+func (a *Application) manageHost(host string) error {
+	peerID, err := getPeerIdByAlias(a.api, host)
+	if err != nil {
+		peerID = host
+	}
+	a.api.SetManagedPeer(peerID)
+	_, err = a.api.PeerInfo()
+	if err != nil {
+		return fmt.Errorf("could not access api of peer %s: %v", host, err)
+	}
 	return nil
 }
 
+func apiTLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return &tls.Config{}, nil
+	}
+	caData, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
 func (a *Application) initApiAndPeerId(c *cli.Context) error {
 	err := a.initApiConnection(c)
 	if err != nil {
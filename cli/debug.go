@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anywherelan/awl/api/apiclient"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	followLogsInterval = time.Second
+	// followLogsLimit is the max page size of /debug/log/entries
+	followLogsLimit = 1000
+)
+
+func printDHTRoutingTable(api *apiclient.Client, asJSON bool) error {
+	table, err := api.DHTRoutingTable()
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		bytes, err := json.MarshalIndent(table, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+		return nil
+	}
+
+	fmt.Printf("Local peer ID: %s\nRouting table size: %d\n\n", table.LocalPeerID, table.Size)
+
+	now := time.Now()
+	ago := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return now.Sub(t).Round(time.Second).String()
+	}
+
+	writer := tablewriter.NewWriter(os.Stdout)
+	writer.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: false})
+	writer.SetHeader([]string{"bucket", "peer ID", "connected", "version", "added", "last useful", "last query"})
+	for _, bucket := range table.Buckets {
+		for _, peer := range bucket.Peers {
+			version := config.VersionFromUserAgent(peer.UserAgent)
+			if version == "" {
+				version = peer.UserAgent
+			}
+			writer.Append([]string{
+				strconv.Itoa(bucket.CommonPrefixLen),
+				peer.PeerID,
+				strconv.FormatBool(peer.Connected),
+				version,
+				ago(peer.AddedAt),
+				ago(peer.LastUsefulAt),
+				ago(peer.LastSuccessfulOutboundQueryAt),
+			})
+		}
+	}
+	writer.Render()
+
+	return nil
+}
+
+func printFlows(api *apiclient.Client, asJSON bool) error {
+	flows, err := api.Flows()
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		bytes, err := json.MarshalIndent(flows, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+		return nil
+	}
+
+	now := time.Now()
+	writer := tablewriter.NewWriter(os.Stdout)
+	writer.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: false})
+	writer.SetHeader([]string{"proto", "local", "remote", "peer", "direction", "bytes out", "bytes in", "age", "idle"})
+	for _, flow := range flows {
+		peerName := flow.PeerName
+		if peerName == "" {
+			peerName = flow.PeerID
+		}
+		direction := "in"
+		if flow.Outbound {
+			direction = "out"
+		}
+		writer.Append([]string{
+			flow.Protocol,
+			flow.LocalAddr,
+			flow.RemoteAddr,
+			peerName,
+			direction,
+			strconv.FormatInt(flow.BytesOut, 10),
+			strconv.FormatInt(flow.BytesIn, 10),
+			now.Sub(flow.FirstSeen).Round(time.Second).String(),
+			now.Sub(flow.LastSeen).Round(time.Second).String(),
+		})
+	}
+	writer.Render()
+
+	return nil
+}
+
+func printEvents(ctx context.Context, api *apiclient.Client, types []string, asJSON bool) error {
+	events, err := api.Events(ctx, types...)
+	if err != nil {
+		return err
+	}
+
+	for evt := range events {
+		if asJSON {
+			bytes, err := json.Marshal(evt)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bytes))
+			continue
+		}
+		fmt.Printf("%s %s %s\n", evt.Time.Local().Format(time.TimeOnly), evt.Type, evt.Data)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return errors.New("connection to events stream is lost")
+}
+
+func search(api *apiclient.Client, query string, limit int, asJSON bool) error {
+	if strings.TrimSpace(query) == "" {
+		return errors.New("query is required")
+	}
+	result, err := api.Search(query, limit)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		bytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+		return nil
+	}
+
+	fmt.Printf("peers: %d\n", len(result.Peers))
+	if len(result.Peers) > 0 {
+		writer := tablewriter.NewWriter(os.Stdout)
+		writer.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: false})
+		writer.SetHeader([]string{"peer", "ip", "domain", "status", "peer ID"})
+		for _, peer := range result.Peers {
+			status := "offline"
+			if peer.Connected {
+				status = "online"
+			}
+			writer.Append([]string{peer.DisplayName, peer.IpAddr, peer.DomainName, status, peer.PeerID})
+		}
+		writer.Render()
+	}
+	fmt.Printf("\nevents: %d\n", len(result.Events))
+	for _, entry := range result.Events {
+		fmt.Println(entry.String())
+	}
+
+	return nil
+}
+
+func downloadLogs(api *apiclient.Client, from, to *time.Time, level, logger, output string) error {
+	query := entity.LogQuery{
+		Level:  level,
+		Logger: logger,
+	}
+	if from != nil {
+		query.From = *from
+	}
+	if to != nil {
+		query.To = *to
+	}
+	logs, err := api.DownloadLog(query)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(logs, output)
+}
+
+// followLogs prints the last n logs and then new ones until ctx is done. Logs are polled, entries written
+// in the same second as the last printed one are skipped by their count, as time has seconds precision.
+func followLogs(ctx context.Context, api *apiclient.Client, n int) error {
+	first, err := api.LogEntries(entity.LogEntriesRequest{Limit: 1})
+	if err != nil {
+		return err
+	}
+	var from time.Time
+	skip := 0
+	if n > 0 {
+		skip = max(first.Total-n, 0)
+	}
+
+	ticker := time.NewTicker(followLogsInterval)
+	defer ticker.Stop()
+	for {
+		resp, err := api.LogEntries(entity.LogEntriesRequest{LogQuery: entity.LogQuery{From: from}, Offset: skip, Limit: followLogsLimit})
+		if err != nil {
+			return err
+		}
+		for _, entry := range resp.Entries {
+			fmt.Println(entry.String())
+			if entry.Time.Equal(from) {
+				skip++
+			} else {
+				from = entry.Time
+				skip = 1
+			}
+		}
+		if len(resp.Entries) == followLogsLimit {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func exportUsage(api *apiclient.Client, exportType string, from, to *time.Time, output string) error {
+	req := entity.ExportUsageRequest{Type: exportType}
+	if from != nil {
+		req.From = *from
+	}
+	if to != nil {
+		req.To = *to
+	}
+	data, err := api.ExportUsage(req)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(data, output)
+}
+
+// writeOutput writes data to the file or to stdout if output is empty.
+func writeOutput(data []byte, output string) error {
+	if output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	err := os.WriteFile(output, data, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("saved to %s\n", output)
+	return nil
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/anywherelan/awl/api/apiclient"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/service"
+	"github.com/olekukonko/tablewriter"
+)
+
+func printScheduledJobs(api *apiclient.Client) error {
+	jobs, err := api.ScheduledJobs()
+	if err != nil {
+		return err
+	}
+	reports, err := api.ScheduledJobReports()
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: false})
+	table.SetHeader([]string{"name", "peer ID", "time", "action", "duration", "last window"})
+	for _, job := range jobs {
+		action := job.Action
+		if action == "" {
+			action = "connect"
+		}
+		lastWindow := "-"
+		// reports are sorted from the newest
+		for _, report := range reports {
+			if report.Job != job.Name {
+				continue
+			}
+			switch {
+			case report.WindowEnd.IsZero():
+				lastWindow = "in progress"
+			case report.Error != "":
+				lastWindow = "failed: " + report.Error
+			case report.Healthy:
+				lastWindow = "direct"
+			default:
+				lastWindow = fmt.Sprintf("direct %d/%d checks", report.DirectProbes, report.Probes)
+			}
+			lastWindow += report.ScheduledAt.Format(" (2006-01-02 15:04)")
+			break
+		}
+		table.Append([]string{job.Name, job.PeerID, job.Time, action, strconv.Itoa(job.DurationMinutes) + "m", lastWindow})
+	}
+	table.Render()
+
+	return nil
+}
+
+func addScheduledJob(api *apiclient.Client, job config.ScheduledJob) error {
+	jobs, err := api.ScheduledJobs()
+	if err != nil {
+		return err
+	}
+	for _, existing := range jobs {
+		if existing.Name == job.Name {
+			return fmt.Errorf("job %s already exists", job.Name)
+		}
+	}
+
+	err = api.UpdateScheduledJobs(append(jobs, job))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("job %s added, connection with the peer will be established %s before %s\n", job.Name, service.ScheduledJobPrewarm, job.Time)
+	return nil
+}
+
+func removeScheduledJob(api *apiclient.Client, name string) error {
+	jobs, err := api.ScheduledJobs()
+	if err != nil {
+		return err
+	}
+	newJobs := make([]config.ScheduledJob, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Name != name {
+			newJobs = append(newJobs, job)
+		}
+	}
+	if len(newJobs) == len(jobs) {
+		return fmt.Errorf("job %s not found", name)
+	}
+
+	err = api.UpdateScheduledJobs(newJobs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("job %s removed\n", name)
+	return nil
+}
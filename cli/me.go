@@ -1,14 +1,21 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/anywherelan/awl/api/apiclient"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/service"
 	"github.com/mdp/qrterminal/v3"
 	"github.com/olekukonko/tablewriter"
+	"golang.org/x/term"
 )
 
 func printStatus(api *apiclient.Client) error {
@@ -21,16 +28,33 @@ func printStatus(api *apiclient.Client) error {
 	if !stats.IsAwlDNSSetAsSystem {
 		dnsStatus = "not working"
 	}
+	clockStatus := "ok"
+	if len(stats.ClockWarnings) != 0 {
+		clockStatus = strings.Join(stats.ClockWarnings, "\n")
+	}
 
 	table := tablewriter.NewWriter(os.Stdout)
 	table.AppendBulk([][]string{
 		{"Download rate", fmt.Sprintf("%s (%s)", stats.NetworkStatsInIECUnits.RateIn, stats.NetworkStatsInIECUnits.TotalIn)},
 		{"Upload rate", fmt.Sprintf("%s (%s)", stats.NetworkStatsInIECUnits.RateOut, stats.NetworkStatsInIECUnits.TotalOut)},
+		{"Traffic by category", trafficByCategoryString(stats.NetworkStatsByCategoryInIECUnits)},
 		{"Bootstrap peers", fmt.Sprintf("%d/%d", stats.TotalBootstrapPeers, stats.ConnectedBootstrapPeers)},
 		{"DNS", dnsStatus},
+		{"DNS records", strings.Join(stats.DNSRecords, ", ")},
+		{"DNS upstream", dnsUpstreamString(stats.DNSUpstreamPeerID, stats.DNSUpstreamAddress)},
+		{"Kill switch", strconv.FormatBool(stats.KillSwitch)},
+		{"VPN paused", strconv.FormatBool(stats.VPNPaused)},
+		{"Rendezvous", strconv.FormatBool(stats.RendezvousEnabled)},
+		{"Advertised routes", strings.Join(stats.AdvertisedRoutes, ", ")},
+		{"SOCKS5 proxy", stats.SOCKS5ListenAddress},
+		{"Inbox", stats.FileTransferInboxPath},
+		{"Unread messages", strconv.Itoa(stats.UnreadMessages)},
 		{"Reachability", strings.ToLower(stats.Reachability)},
+		{"Clock", clockStatus},
 		{"Uptime", stats.Uptime.Round(time.Second).String()},
 		{"Server version", stats.ServerVersion},
+		{"Features", strings.Join(stats.Features, ", ")},
+		{"Config encryption", configEncryptionText(stats.ConfigEncryption)},
 	})
 
 	table.Render()
@@ -50,6 +74,534 @@ func printPeerId(api *apiclient.Client) error {
 	return nil
 }
 
+func rotateIdentity(api *apiclient.Client) error {
+	migration, err := api.RotateIdentity()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("your new peer id: %s, restart awl to apply it\n", migration.NewPeerID)
+	if len(migration.PendingPeers) > 0 {
+		fmt.Printf("%d peers will receive the new peer id after restart\n", len(migration.PendingPeers))
+	}
+
+	return nil
+}
+
+func configEncryptionText(mode string) string {
+	if mode == config.EncryptionNone {
+		return "disabled"
+	}
+	return mode
+}
+
+func updateConfigEncryption(api *apiclient.Client, mode, passphrase string) error {
+	if mode == config.EncryptionPassphrase && passphrase == "" {
+		var err error
+		passphrase, err = readNewPassphrase()
+		if err != nil {
+			return err
+		}
+	}
+	err := api.UpdateConfigEncryption(mode, passphrase)
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case config.EncryptionPassphrase:
+		fmt.Printf("config is encrypted, passphrase is required on start in %s env or in terminal\n", config.ConfigPassphraseEnvKey)
+	case config.EncryptionKeychain:
+		fmt.Println("config is encrypted with a key stored in os keychain")
+	default:
+		fmt.Println("config is not encrypted")
+	}
+
+	return nil
+}
+
+func readNewPassphrase() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("stdin is not a terminal, use --passphrase flag")
+	}
+	fmt.Print("new passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	fmt.Print("repeat passphrase: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	if string(passphrase) != string(repeated) {
+		return "", errors.New("passphrases don't match")
+	}
+	return string(passphrase), nil
+}
+
+func printIdentityMigration(api *apiclient.Client) error {
+	migration, err := api.IdentityMigration()
+	if err != nil {
+		return err
+	}
+	if migration.OldPeerID == "" {
+		fmt.Println("identity was never rotated")
+		return nil
+	}
+	fmt.Printf("identity rotated at %s from %s to %s\n", migration.CreatedAt.Format(time.RFC3339), migration.OldPeerID, migration.NewPeerID)
+	if migration.RestartRequired {
+		fmt.Println("restart awl to apply the new identity")
+	}
+	for _, peerID := range migration.PendingPeers {
+		fmt.Printf("peer %s hasn't received the new peer id\n", peerID)
+	}
+
+	return nil
+}
+
+func updateMyDNSRecords(api *apiclient.Client, records []string) error {
+	err := api.UpdateDNSRecords(records)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("my dns records updated successfully")
+
+	return nil
+}
+
+func setKillSwitch(api *apiclient.Client, enabled bool) error {
+	err := api.UpdateKillSwitch(enabled)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("kill switch updated successfully")
+
+	return nil
+}
+
+func setVPNPause(api *apiclient.Client, paused bool) error {
+	err := api.UpdateVPNPause(paused)
+	if err != nil {
+		return err
+	}
+
+	if paused {
+		fmt.Println("vpn paused, connections to peers are kept")
+	} else {
+		fmt.Println("vpn resumed")
+	}
+
+	return nil
+}
+
+func setRendezvous(api *apiclient.Client, phrase string) error {
+	err := api.UpdateRendezvous(phrase)
+	if err != nil {
+		return err
+	}
+
+	if phrase == "" {
+		fmt.Println("rendezvous disabled")
+	} else {
+		fmt.Println("rendezvous enabled, peers with the same phrase will be added automatically")
+	}
+
+	return nil
+}
+
+func setDNSUpstream(api *apiclient.Client, peerID, address string) error {
+	err := api.UpdateDNSUpstream(peerID, address)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("dns upstream updated successfully")
+
+	return nil
+}
+
+// dnsUpstreamString describes who resolves dns queries which aren't awl names.
+func dnsUpstreamString(peerID, address string) string {
+	if address == "" {
+		address = "system"
+	}
+	if peerID == "" {
+		return address
+	}
+	return fmt.Sprintf("peer %s, %s while it's unreachable", peerID, address)
+}
+
+func setExitNode(api *apiclient.Client, peerID string) error {
+	err := api.UpdateExitNode(peerID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("exit node updated successfully")
+
+	return nil
+}
+
+func setAdvertisedRoutes(api *apiclient.Client, routes []string) error {
+	err := api.UpdateAdvertisedRoutes(routes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("advertised routes updated successfully")
+
+	return nil
+}
+
+func setSharedFolder(api *apiclient.Client, path string) error {
+	err := api.UpdateSharedFolder(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("shared folder updated successfully")
+
+	return nil
+}
+
+func setFileTransferInbox(api *apiclient.Client, path string) error {
+	err := api.UpdateFileTransfer(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("inbox updated successfully")
+
+	return nil
+}
+
+func setSOCKS5(api *apiclient.Client, listenAddress, peerID string, resumablePorts []int) error {
+	err := api.UpdateSOCKS5(listenAddress, peerID, resumablePorts...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("socks5 proxy updated successfully")
+
+	return nil
+}
+
+// updateConnectionGater adds and removes peers from lists, nil onlyKnownPeers keeps the current value.
+func updateConnectionGater(api *apiclient.Client, onlyKnownPeers *bool, allow, disallow, deny, undeny []string) error {
+	gaterConfig, err := api.ConnectionGater()
+	if err != nil {
+		return err
+	}
+	if onlyKnownPeers == nil && len(allow)+len(disallow)+len(deny)+len(undeny) == 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.AppendBulk([][]string{
+			{"Only known peers", strconv.FormatBool(gaterConfig.OnlyKnownPeers)},
+			{"Allowlist", strings.Join(gaterConfig.Allowlist, "\n")},
+			{"Denylist", strings.Join(gaterConfig.Denylist, "\n")},
+		})
+		table.Render()
+		return nil
+	}
+
+	request := entity.UpdateConnectionGaterRequest{
+		OnlyKnownPeers: gaterConfig.OnlyKnownPeers,
+		Allowlist:      updateList(gaterConfig.Allowlist, allow, disallow),
+		Denylist:       updateList(gaterConfig.Denylist, deny, undeny),
+	}
+	if onlyKnownPeers != nil {
+		request.OnlyKnownPeers = *onlyKnownPeers
+	}
+	err = api.UpdateConnectionGater(request)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("connection gater updated successfully")
+
+	return nil
+}
+
+// updatePacketFilter changes the filter, nil values and protocols keep the current ones.
+func updatePacketFilter(api *apiclient.Client, dropIPv6, dropMulticast *bool, dropProtocols []int) error {
+	response, err := api.PacketFilter()
+	if err != nil {
+		return err
+	}
+	filter := response.Filter
+	if dropIPv6 == nil && dropMulticast == nil && dropProtocols == nil {
+		protocols := make([]string, 0, len(filter.DropProtocols))
+		for _, proto := range filter.DropProtocols {
+			protocols = append(protocols, strconv.Itoa(proto))
+		}
+		dropped := response.Dropped
+		table := tablewriter.NewWriter(os.Stdout)
+		table.AppendBulk([][]string{
+			{"Drop IPv6", strconv.FormatBool(filter.DropIPv6)},
+			{"Drop multicast", strconv.FormatBool(filter.DropMulticast)},
+			{"Drop protocols", strings.Join(protocols, ", ")},
+			{"Dropped", fmt.Sprintf("ipv6: %d, multicast: %d, protocol: %d, invalid: %d",
+				dropped.IPv6, dropped.Multicast, dropped.Protocol, dropped.Invalid)},
+		})
+		table.Render()
+		return nil
+	}
+
+	request := entity.UpdatePacketFilterRequest{
+		DropIPv6:      filter.DropIPv6,
+		DropMulticast: filter.DropMulticast,
+		DropProtocols: filter.DropProtocols,
+	}
+	if dropIPv6 != nil {
+		request.DropIPv6 = *dropIPv6
+	}
+	if dropMulticast != nil {
+		request.DropMulticast = *dropMulticast
+	}
+	if dropProtocols != nil {
+		request.DropProtocols = dropProtocols
+	}
+	err = api.UpdatePacketFilter(request)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("packet filter updated successfully")
+
+	return nil
+}
+
+func updateIPAllocation(api *apiclient.Client, strategy string) error {
+	err := api.UpdateIPAllocation(strategy)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ip allocation strategy updated successfully")
+	return nil
+}
+
+func subnetConflicts(api *apiclient.Client, autoRenumber *bool) error {
+	if autoRenumber != nil {
+		err := api.UpdateAutoRenumber(*autoRenumber)
+		if err != nil {
+			return err
+		}
+		fmt.Println("auto renumber updated successfully")
+		return nil
+	}
+
+	response, err := api.SubnetConflicts()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("auto renumber: %t\n", response.AutoRenumber)
+	if len(response.Conflicts) == 0 {
+		fmt.Println("no conflicts with local networks")
+		return nil
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"network", "peer", "local network", "resolution"})
+	for _, conflict := range response.Conflicts {
+		peer := conflict.PeerID
+		if peer == "" {
+			peer = "vpn network"
+		}
+		table.Append([]string{conflict.Network, peer, conflict.LocalNetwork + " (" + conflict.LocalInterface + ")", conflict.Resolution})
+	}
+	table.Render()
+	return nil
+}
+
+func updateQoS(api *apiclient.Client, disabled *bool, rules []string) error {
+	response, err := api.QoS()
+	if err != nil {
+		return err
+	}
+	qos := response.QoS
+	if disabled == nil && rules == nil {
+		ruleStrings := make([]string, 0, len(qos.Rules))
+		for _, rule := range qos.Rules {
+			ruleString := rule.Ports + ":" + rule.Priority
+			if rule.Protocol != "" {
+				ruleString = rule.Protocol + ":" + ruleString
+			}
+			ruleStrings = append(ruleStrings, ruleString)
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.AppendBulk([][]string{
+			{"Disabled", strconv.FormatBool(qos.Disabled)},
+			{"Rules", strings.Join(ruleStrings, ", ")},
+		})
+		table.Render()
+
+		table = tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"priority", "queued", "sent", "dropped"})
+		for _, class := range response.Classes {
+			table.Append([]string{class.Priority, strconv.Itoa(class.Queued),
+				strconv.FormatInt(class.Sent, 10), strconv.FormatInt(class.Dropped, 10)})
+		}
+		table.Render()
+		return nil
+	}
+
+	if disabled != nil {
+		qos.Disabled = *disabled
+	}
+	if rules != nil {
+		qos.Rules = make([]config.QoSRule, 0, len(rules))
+		for _, ruleString := range rules {
+			parts := strings.Split(ruleString, ":")
+			var rule config.QoSRule
+			switch len(parts) {
+			case 2:
+				rule = config.QoSRule{Ports: parts[0], Priority: parts[1]}
+			case 3:
+				rule = config.QoSRule{Protocol: parts[0], Ports: parts[1], Priority: parts[2]}
+			default:
+				return fmt.Errorf("invalid rule %q, expected [protocol:]ports:priority", ruleString)
+			}
+			qos.Rules = append(qos.Rules, rule)
+		}
+	}
+	err = api.UpdateQoS(qos)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("qos updated successfully")
+
+	return nil
+}
+
+func updateBandwidthLimit(api *apiclient.Client, upload, download *int) error {
+	response, err := api.BandwidthLimit()
+	if err != nil {
+		return err
+	}
+	limit := response.Global
+	if upload == nil && download == nil {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"peer", "upload", "download"})
+		table.Append([]string{"all peers", formatKbps(limit.UploadKbps), formatKbps(limit.DownloadKbps)})
+		for _, peerLimit := range response.Peers {
+			table.Append([]string{peerLimit.PeerName, formatKbps(peerLimit.Limit.UploadKbps), formatKbps(peerLimit.Limit.DownloadKbps)})
+		}
+		table.Render()
+		fmt.Printf("delayed by limits: upload %s, download %s\n",
+			response.Delayed.UploadDelay.Round(time.Millisecond), response.Delayed.DownloadDelay.Round(time.Millisecond))
+		return nil
+	}
+
+	if upload != nil {
+		limit.UploadKbps = *upload
+	}
+	if download != nil {
+		limit.DownloadKbps = *download
+	}
+	err = api.UpdateBandwidthLimit(limit)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("bandwidth limit updated successfully")
+
+	return nil
+}
+
+func updatePowerMode(api *apiclient.Client, mode string) error {
+	var profile *service.PowerProfile
+	var err error
+	if mode == "" {
+		profile, err = api.PowerProfile()
+	} else {
+		profile, err = api.UpdatePowerMode(mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	source := "ac"
+	if profile.OnBattery {
+		source = "battery"
+	}
+	if profile.Mode != config.PowerModeAuto {
+		source = "not detected in " + profile.Mode + " mode"
+	} else if profile.DetectionError != "" {
+		source = "unknown: " + profile.DetectionError
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"mode", "power source", "power save", "changed at"})
+	table.Append([]string{profile.Mode, source, strconv.FormatBool(profile.PowerSave), profile.ChangedAt.Format("2006-01-02 15:04:05")})
+	table.Render()
+
+	return nil
+}
+
+func formatKbps(kbps int) string {
+	if kbps == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d kbit/s", kbps)
+}
+
+func updateBootstrapPeers(api *apiclient.Client, add, remove []string, test string) error {
+	if test != "" {
+		response, err := api.TestBootstrapPeer(test)
+		if err != nil {
+			return err
+		}
+		if response.Error != "" {
+			fmt.Printf("peer %s is unreachable: %s\n", response.PeerID, response.Error)
+		} else {
+			fmt.Printf("peer %s is reachable, latency %s\n", response.PeerID, response.Latency.Round(time.Millisecond))
+		}
+	}
+	for _, address := range add {
+		err := api.AddBootstrapPeer(address)
+		if err != nil {
+			return fmt.Errorf("add %s: %v", address, err)
+		}
+		fmt.Printf("bootstrap peer %s added\n", address)
+	}
+	for _, address := range remove {
+		err := api.RemoveBootstrapPeer(address)
+		if err != nil {
+			return fmt.Errorf("remove %s: %v", address, err)
+		}
+		fmt.Printf("bootstrap peer %s removed\n", address)
+	}
+	if test != "" || len(add)+len(remove) > 0 {
+		return nil
+	}
+
+	peers, err := api.BootstrapPeers()
+	if err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Address", "Default", "Connected"})
+	for _, p := range peers {
+		table.Append([]string{p.Address, strconv.FormatBool(p.Default), strconv.FormatBool(p.Connected)})
+	}
+	table.Render()
+
+	return nil
+}
+
+func updateList(list, add, remove []string) []string {
+	result := make([]string, 0, len(list)+len(add))
+	for _, value := range append(list, add...) {
+		if !slices.Contains(remove, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
 func renameMe(api *apiclient.Client, newName string) error {
 	err := api.UpdateMySettings(newName)
 	if err != nil {
@@ -60,3 +612,45 @@ func renameMe(api *apiclient.Client, newName string) error {
 
 	return nil
 }
+
+func printAPIKeys(api *apiclient.Client) error {
+	keys, err := api.APIKeys()
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Permission", "Created"})
+	for _, key := range keys {
+		table.Append([]string{key.Name, key.Permission, key.CreatedAt.Local().Format("2006-01-02 15:04")})
+	}
+	table.Render()
+
+	return nil
+}
+
+func createAPIKey(api *apiclient.Client, name string, readOnly bool) error {
+	permission := config.APIKeyPermissionAdmin
+	if readOnly {
+		permission = config.APIKeyPermissionRead
+	}
+	key, err := api.CreateAPIKey(name, permission)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("api key created, it can't be printed again: %s\n", key)
+
+	return nil
+}
+
+func removeAPIKey(api *apiclient.Client, name string) error {
+	err := api.RemoveAPIKey(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("api key removed successfully")
+
+	return nil
+}
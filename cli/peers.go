@@ -1,19 +1,27 @@
 package cli
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/anywherelan/awl/api/apiclient"
 	"github.com/anywherelan/awl/awldns"
+	"github.com/anywherelan/awl/config"
 	"github.com/anywherelan/awl/entity"
+	"github.com/anywherelan/awl/p2p"
+	"github.com/anywherelan/awl/service"
+	"github.com/mdp/qrterminal/v3"
 	"github.com/olekukonko/tablewriter"
 )
 
-func printPeersStatus(api *apiclient.Client, format string) error {
+// printPeersStatus prints peers of the group, all peers if the group is empty.
+func printPeersStatus(api *apiclient.Client, format, group string) error {
 	const (
 		TableFormatRowNumber    = "n"
 		TableFormatPeer         = "p"
@@ -23,6 +31,9 @@ func printPeersStatus(api *apiclient.Client, format string) error {
 		TableFormatNetworkUsage = "u"
 		TableFormatConnection   = "c"
 		TableFormatVersion      = "v"
+		TableFormatTraffic      = "t"
+		TableFormatQuality      = "q"
+		TableFormatGroups       = "g"
 	)
 
 	fHeaderMap := map[string]string{
@@ -34,6 +45,9 @@ func printPeersStatus(api *apiclient.Client, format string) error {
 		TableFormatNetworkUsage: "network usage\n(↓in/↑out)",
 		TableFormatConnection:   "connections\naddress | protocol",
 		TableFormatVersion:      "version",
+		TableFormatTraffic:      "traffic by category\n(↓in/↑out)",
+		TableFormatQuality:      "connection quality\n(latency/loss)",
+		TableFormatGroups:       "groups",
 	}
 
 	if len(format) < 1 {
@@ -58,7 +72,13 @@ func printPeersStatus(api *apiclient.Client, format string) error {
 		columns = append(columns, fcs)
 	}
 
-	peers, err := api.KnownPeers()
+	var peers []entity.KnownPeersResponse
+	var err error
+	if group != "" {
+		peers, err = api.KnownPeersInGroup(group)
+	} else {
+		peers, err = api.KnownPeers()
+	}
 	if err != nil {
 		return err
 	}
@@ -73,12 +93,16 @@ func printPeersStatus(api *apiclient.Client, format string) error {
 			case TableFormatRowNumber:
 				row = append(row, strconv.Itoa(i+1))
 			case TableFormatPeer:
-				info := make([]string, 0, 3)
+				info := make([]string, 0, 4)
 				if peer.DisplayName != "" {
 					info = append(info, peer.DisplayName)
 				}
 				if peer.DomainName != "" {
 					info = append(info, fmt.Sprintf("%s.%s", peer.DomainName, awldns.LocalDomain))
+					// punycode is for systems without international names support
+					if asciiName, err := awldns.ToASCIIDomainName(peer.DomainName); err == nil && asciiName != peer.DomainName {
+						info = append(info, fmt.Sprintf("%s.%s", asciiName, awldns.LocalDomain))
+					}
 				}
 				info = append(info, peer.IpAddr)
 
@@ -90,9 +114,18 @@ func printPeersStatus(api *apiclient.Client, format string) error {
 				if peer.Connected {
 					status = "online"
 				}
+				if peer.ConnectionType != "" {
+					status += "\n(" + peer.ConnectionType + ")"
+				}
+				if peer.LocalConnection {
+					status += "\n(local network)"
+				}
 				if !peer.Confirmed {
 					status += "\n(not confirmed)"
 				}
+				if peer.UnreadMessages > 0 {
+					status += fmt.Sprintf("\n(%d unread messages)", peer.UnreadMessages)
+				}
 				row = append(row, status)
 			case TableFormatLastSeen:
 				if peer.LastSeen.IsZero() {
@@ -121,6 +154,12 @@ func printPeersStatus(api *apiclient.Client, format string) error {
 				row = append(row, strings.Join(consStr, "\n"))
 			case TableFormatVersion:
 				row = append(row, peer.Version)
+			case TableFormatTraffic:
+				row = append(row, trafficByCategoryString(peer.NetworkStatsByCategoryInIECUnits))
+			case TableFormatQuality:
+				row = append(row, latencyString(peer.Latency))
+			case TableFormatGroups:
+				row = append(row, strings.Join(peer.Groups, "\n"))
 			}
 		}
 		table.Append(row)
@@ -129,6 +168,35 @@ func printPeersStatus(api *apiclient.Client, format string) error {
 	return nil
 }
 
+// trafficByCategoryString returns total traffic of every category on a separate line.
+func trafficByCategoryString(byCategory map[string]entity.StatsInUnits) string {
+	lines := make([]string, 0, len(p2p.TrafficCategories))
+	for _, category := range p2p.TrafficCategories {
+		stats, ok := byCategory[category]
+		if !ok {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: ↓ %s ↑ %s", category, stats.TotalIn, stats.TotalOut))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// latencyString returns the last round trip time and loss of recent pings, empty if the peer wasn't pinged yet.
+func latencyString(latency service.PeerLatency) string {
+	if latency.Pings == 0 {
+		return ""
+	}
+	loss := fmt.Sprintf("%.0f%% loss", latency.PacketLoss)
+	if !latency.Reachable {
+		return "unreachable\n" + loss
+	}
+	path := "direct"
+	if latency.Relayed {
+		path = "relay"
+	}
+	return fmt.Sprintf("%s (avg %s)\n%s, %s", latency.LastRTT.Round(time.Millisecond), latency.AvgRTT.Round(time.Millisecond), loss, path)
+}
+
 func printFriendRequests(api *apiclient.Client) error {
 	authRequests, err := api.AuthRequests()
 	if err != nil {
@@ -145,6 +213,17 @@ func printFriendRequests(api *apiclient.Client) error {
 	return nil
 }
 
+func declineFriendRequest(api *apiclient.Client, peerID string) error {
+	// alias is required by api, but it's not used for declined peers
+	err := api.ReplyFriendRequest(peerID, peerID, "", true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("friend request declined, peer is blocked")
+	return nil
+}
+
 func getPeerIdByAlias(api *apiclient.Client, alias string) (string, error) {
 	if alias == "" {
 		return "", errors.New("name is empty")
@@ -163,7 +242,7 @@ func getPeerIdByAlias(api *apiclient.Client, alias string) (string, error) {
 	return "", fmt.Errorf("can't find peer with name \"%s\"", alias)
 }
 
-func addPeer(api *apiclient.Client, peerID, alias string) error {
+func addPeer(api *apiclient.Client, peerID, alias, ipAddr string) error {
 	authRequests, err := api.AuthRequests()
 	if err != nil {
 		return err
@@ -176,7 +255,7 @@ func addPeer(api *apiclient.Client, peerID, alias string) error {
 		}
 	}
 	if hasRequest {
-		err := api.ReplyFriendRequest(peerID, alias, false)
+		err := api.ReplyFriendRequest(peerID, alias, ipAddr, false)
 		if err != nil {
 			return err
 		}
@@ -185,7 +264,7 @@ func addPeer(api *apiclient.Client, peerID, alias string) error {
 		return nil
 	}
 
-	err = api.SendFriendRequest(peerID, alias)
+	err = api.SendFriendRequest(peerID, alias, ipAddr)
 	if err != nil {
 		return err
 	}
@@ -193,6 +272,27 @@ func addPeer(api *apiclient.Client, peerID, alias string) error {
 	return nil
 }
 
+func createInvite(api *apiclient.Client, alias string, expiresInHours int) error {
+	response, err := api.CreateInvite(alias, expiresInHours)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("invite token, it could be used once until %s:\n%s\n", response.ExpiresAt.Format("2006-01-02 15:04"), response.Token)
+	qrterminal.GenerateHalfBlock(response.Token, qrterminal.L, os.Stdout)
+	return nil
+}
+
+func acceptInvite(api *apiclient.Client, token, alias string) error {
+	response, err := api.AcceptInvite(token, alias)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("friend request sent to %s (%s), it will be accepted automatically\n", response.Alias, response.PeerID)
+	return nil
+}
+
 func removePeer(api *apiclient.Client, peerID string) error {
 	err := api.RemovePeer(peerID)
 	if err != nil {
@@ -211,7 +311,8 @@ func changePeerAlias(api *apiclient.Client, peerID, newAlias string) error {
 
 	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
 		PeerID: peerID, DomainName: pcfg.DomainName, AllowUsingAsExitNode: pcfg.WeAllowUsingAsExitNode,
-		Alias: newAlias,
+		KillSwitch: pcfg.KillSwitch, SharedFolderAccess: pcfg.SharedFolderAccess,
+		SupportAccess: pcfg.SupportAccess, ManagementAllowed: pcfg.ManagementAllowed, Alias: newAlias,
 	})
 	if err != nil {
 		return err
@@ -229,7 +330,8 @@ func changePeerDomain(api *apiclient.Client, peerID, newDomain string) error {
 
 	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
 		PeerID: peerID, Alias: pcfg.Alias, AllowUsingAsExitNode: pcfg.WeAllowUsingAsExitNode,
-		DomainName: newDomain,
+		KillSwitch: pcfg.KillSwitch, SharedFolderAccess: pcfg.SharedFolderAccess,
+		SupportAccess: pcfg.SupportAccess, ManagementAllowed: pcfg.ManagementAllowed, DomainName: newDomain,
 	})
 	if err != nil {
 		return err
@@ -239,6 +341,25 @@ func changePeerDomain(api *apiclient.Client, peerID, newDomain string) error {
 	return nil
 }
 
+func changePeerIPAddr(api *apiclient.Client, peerID, ipAddr string) error {
+	pcfg, err := api.KnownPeerConfig(peerID)
+	if err != nil {
+		return err
+	}
+
+	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: peerID, Alias: pcfg.Alias, DomainName: pcfg.DomainName, AllowUsingAsExitNode: pcfg.WeAllowUsingAsExitNode,
+		KillSwitch: pcfg.KillSwitch, SharedFolderAccess: pcfg.SharedFolderAccess,
+		SupportAccess: pcfg.SupportAccess, ManagementAllowed: pcfg.ManagementAllowed, IPAddr: ipAddr,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("peer address updated successfully")
+	return nil
+}
+
 func setAllowUsingAsExitNode(api *apiclient.Client, peerID string, allow bool) error {
 	pcfg, err := api.KnownPeerConfig(peerID)
 	if err != nil {
@@ -247,7 +368,8 @@ func setAllowUsingAsExitNode(api *apiclient.Client, peerID string, allow bool) e
 
 	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
 		PeerID: peerID, Alias: pcfg.Alias, DomainName: pcfg.DomainName,
-		AllowUsingAsExitNode: allow,
+		KillSwitch: pcfg.KillSwitch, SharedFolderAccess: pcfg.SharedFolderAccess,
+		SupportAccess: pcfg.SupportAccess, ManagementAllowed: pcfg.ManagementAllowed, AllowUsingAsExitNode: allow,
 	})
 	if err != nil {
 		return err
@@ -256,3 +378,514 @@ func setAllowUsingAsExitNode(api *apiclient.Client, peerID string, allow bool) e
 	fmt.Println("AllowUsingAsExitNode config updated successfully")
 	return nil
 }
+
+func setPeerKillSwitch(api *apiclient.Client, peerID string, enabled bool) error {
+	pcfg, err := api.KnownPeerConfig(peerID)
+	if err != nil {
+		return err
+	}
+
+	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: peerID, Alias: pcfg.Alias, DomainName: pcfg.DomainName,
+		AllowUsingAsExitNode: pcfg.WeAllowUsingAsExitNode, SharedFolderAccess: pcfg.SharedFolderAccess,
+		SupportAccess: pcfg.SupportAccess, ManagementAllowed: pcfg.ManagementAllowed, KillSwitch: enabled,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("KillSwitch config updated successfully")
+	return nil
+}
+
+func setPeerSharedFolderAccess(api *apiclient.Client, peerID, access string) error {
+	pcfg, err := api.KnownPeerConfig(peerID)
+	if err != nil {
+		return err
+	}
+
+	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: peerID, Alias: pcfg.Alias, DomainName: pcfg.DomainName,
+		AllowUsingAsExitNode: pcfg.WeAllowUsingAsExitNode, KillSwitch: pcfg.KillSwitch,
+		SupportAccess: pcfg.SupportAccess, ManagementAllowed: pcfg.ManagementAllowed, SharedFolderAccess: access,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("shared folder access updated successfully")
+	return nil
+}
+
+func setPeerSupportAccess(api *apiclient.Client, peerID string, allow bool) error {
+	pcfg, err := api.KnownPeerConfig(peerID)
+	if err != nil {
+		return err
+	}
+
+	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: peerID, Alias: pcfg.Alias, DomainName: pcfg.DomainName,
+		AllowUsingAsExitNode: pcfg.WeAllowUsingAsExitNode, KillSwitch: pcfg.KillSwitch,
+		SharedFolderAccess: pcfg.SharedFolderAccess, ManagementAllowed: pcfg.ManagementAllowed, SupportAccess: allow,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("support access updated successfully")
+	return nil
+}
+
+func setPeerManagementAllowed(api *apiclient.Client, peerID string, allow bool) error {
+	pcfg, err := api.KnownPeerConfig(peerID)
+	if err != nil {
+		return err
+	}
+
+	err = api.UpdatePeerSettings(entity.UpdatePeerSettingsRequest{
+		PeerID: peerID, Alias: pcfg.Alias, DomainName: pcfg.DomainName,
+		AllowUsingAsExitNode: pcfg.WeAllowUsingAsExitNode, KillSwitch: pcfg.KillSwitch,
+		SharedFolderAccess: pcfg.SharedFolderAccess, SupportAccess: pcfg.SupportAccess, ManagementAllowed: allow,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("management access updated successfully")
+	return nil
+}
+
+// updatePeerNotes prints notes and contact of the peer if fields are empty, otherwise updates the given fields:
+// notes, owner, phone or email.
+func updatePeerNotes(api *apiclient.Client, peerID string, fields map[string]string) error {
+	pcfg, err := api.KnownPeerConfig(peerID)
+	if err != nil {
+		return err
+	}
+
+	if len(fields) == 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.AppendBulk([][]string{
+			{"Owner", pcfg.Contact.Owner},
+			{"Phone", pcfg.Contact.Phone},
+			{"Email", pcfg.Contact.Email},
+			{"Notes", pcfg.Notes},
+		})
+		table.Render()
+		return nil
+	}
+
+	notes, contact := pcfg.Notes, pcfg.Contact
+	for name, value := range fields {
+		switch name {
+		case "notes":
+			notes = value
+		case "owner":
+			contact.Owner = value
+		case "phone":
+			contact.Phone = value
+		case "email":
+			contact.Email = value
+		}
+	}
+	err = api.UpdatePeerNotes(peerID, notes, contact)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("notes updated successfully")
+	return nil
+}
+
+func setPeerMTU(api *apiclient.Client, peerID string, mtu int) error {
+	err := api.UpdatePeerMTU(peerID, mtu)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("mtu updated successfully")
+	return nil
+}
+
+func setPeerRoutes(api *apiclient.Client, peerID string, acceptRoutes, acceptAllRoutes bool) error {
+	err := api.UpdatePeerRoutes(peerID, acceptRoutes, acceptAllRoutes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("routes settings updated successfully")
+	return nil
+}
+
+func setPeerBandwidthLimit(api *apiclient.Client, peerID string, limit config.BandwidthLimitConfig) error {
+	err := api.UpdatePeerBandwidthLimit(peerID, limit)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("bandwidth limit updated successfully")
+	return nil
+}
+
+func setPeerACL(api *apiclient.Client, peerID string, ruleStrings []string, clearRules bool) error {
+	if len(ruleStrings) == 0 && !clearRules {
+		pcfg, err := api.KnownPeerConfig(peerID)
+		if err != nil {
+			return err
+		}
+		if len(pcfg.ACL) == 0 {
+			fmt.Println("no rules, all traffic is allowed")
+			return nil
+		}
+		for i, rule := range pcfg.ACL {
+			fmt.Printf("%d. %s\n", i+1, formatACLRule(rule))
+		}
+		return nil
+	}
+
+	rules := make([]config.ACLRule, 0, len(ruleStrings))
+	for _, ruleStr := range ruleStrings {
+		rule, err := parseACLRule(ruleStr)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	err := api.UpdatePeerACL(peerID, rules)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("acl updated successfully")
+	return nil
+}
+
+func setPeerGroups(api *apiclient.Client, peerID string, groups []string) error {
+	err := api.UpdatePeerGroups(peerID, groups)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("peer groups updated successfully")
+	return nil
+}
+
+func printPeerGroups(api *apiclient.Client) error {
+	groups, err := api.PeerGroups()
+	if err != nil {
+		return err
+	}
+	knownPeers, err := api.KnownPeers()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(knownPeers))
+	for _, knownPeer := range knownPeers {
+		names[knownPeer.PeerID] = knownPeer.DisplayName
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Group", "ACL", "Exit node", "Sharing", "Members"})
+	table.SetRowLine(true)
+	for _, group := range groups {
+		rules := make([]string, 0, len(group.ACL))
+		for _, rule := range group.ACL {
+			rules = append(rules, formatACLRule(rule))
+		}
+		members := make([]string, 0, len(group.Members))
+		for _, peerID := range group.Members {
+			members = append(members, names[peerID])
+		}
+		sharing := ""
+		if group.Shared {
+			sharing = "shared"
+		} else if group.Owner != "" {
+			sharing = "owned by " + names[group.Owner]
+			if group.MembershipTime != nil {
+				sharing += "\nsynced " + group.MembershipTime.Format("2006-01-02 15:04")
+			}
+		}
+		table.Append([]string{group.Name, strings.Join(rules, "\n"), strconv.FormatBool(group.AllowUsingAsExitNode), sharing, strings.Join(members, "\n")})
+	}
+	table.Render()
+	return nil
+}
+
+func updatePeerGroup(api *apiclient.Client, name string, ruleStrings []string, allowUsingAsExitNode, shared bool, owner string) error {
+	request := entity.UpdatePeerGroupRequest{
+		Name:                 name,
+		ACL:                  make([]config.ACLRule, 0, len(ruleStrings)),
+		AllowUsingAsExitNode: allowUsingAsExitNode,
+		Shared:               shared,
+		Owner:                owner,
+	}
+	for _, ruleStr := range ruleStrings {
+		rule, err := parseACLRule(ruleStr)
+		if err != nil {
+			return err
+		}
+		request.ACL = append(request.ACL, rule)
+	}
+	err := api.UpdatePeerGroup(request)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("peer group updated successfully")
+	return nil
+}
+
+func removePeerGroup(api *apiclient.Client, name string) error {
+	err := api.RemovePeerGroup(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("peer group removed successfully")
+	return nil
+}
+
+func parseACLRule(ruleStr string) (config.ACLRule, error) {
+	fields := strings.Fields(ruleStr)
+	if len(fields) == 0 {
+		return config.ACLRule{}, errors.New("empty acl rule")
+	}
+	rule := config.ACLRule{Action: fields[0]}
+	for _, field := range fields[1:] {
+		switch field {
+		case config.ACLDirectionIn, config.ACLDirectionOut:
+			rule.Direction = field
+		case config.ACLProtocolTCP, config.ACLProtocolUDP, config.ACLProtocolICMP:
+			rule.Protocol = field
+		default:
+			if rule.Ports != "" {
+				return config.ACLRule{}, fmt.Errorf("acl rule %q: unexpected %q", ruleStr, field)
+			}
+			rule.Ports = field
+		}
+	}
+	return rule, nil
+}
+
+func formatACLRule(rule config.ACLRule) string {
+	fields := []string{rule.Action}
+	for _, field := range []string{rule.Direction, rule.Protocol, rule.Ports} {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func importPeers(api *apiclient.Client, format, filePath string, dryRun bool) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	response, err := api.ImportPeers(entity.ImportPeersRequest{
+		Format: format,
+		Data:   string(data),
+		DryRun: dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: false})
+	table.SetRowLine(true)
+	table.SetHeader([]string{"name", "source ip", "awl ip", "peer ID", "status"})
+	needsPeerID := 0
+	for _, p := range response.Peers {
+		if p.Status == entity.ImportStatusNeedsPeerID {
+			needsPeerID++
+		}
+		table.Append([]string{p.Alias, p.SourceIP, p.SuggestedIP, p.PeerID, p.Status + "\n" + p.Message})
+	}
+	table.Render()
+
+	if needsPeerID > 0 {
+		fmt.Printf("\n%d peers need to be paired manually. Install awl on each of them, get its peer ID with `awl cli me id` "+
+			"and run `awl cli peers add --pid <peer ID> --name <name>` here\n", needsPeerID)
+	}
+	if dryRun {
+		fmt.Println("dry run: no changes were made")
+	}
+	return nil
+}
+
+func echoPeer(api *apiclient.Client, peerID string, payloadSize int) error {
+	response, err := api.EchoPeer(peerID, payloadSize)
+	if err != nil {
+		return err
+	}
+
+	path := "direct connection"
+	if response.ThroughRelay {
+		path = "through relay"
+	}
+	fmt.Printf("received %d bytes back in %s (%s)\n", response.Bytes, response.RTT, path)
+	if response.ClockOffsetKnown {
+		fmt.Printf("peer clock offset %s\n", response.ClockOffset.Round(time.Millisecond))
+	}
+	return nil
+}
+
+func runSpeedTest(api *apiclient.Client, peerID string, seconds int) error {
+	result, err := api.RunSpeedTest(peerID, seconds)
+	if err != nil {
+		return err
+	}
+
+	path := "direct connection"
+	if result.ThroughRelay {
+		path = "through relay"
+	}
+	if result.Upload == 0 && result.Download == 0 {
+		// the peer is of older version
+		fmt.Printf("throughput %.1f Mbit/s, fastest round %s (%s)\n", result.Throughput/1e6, result.MinRTT.Round(time.Millisecond), path)
+		return nil
+	}
+	fmt.Printf("upload %.1f Mbit/s, download %.1f Mbit/s (%s)\n", result.Upload/1e6, result.Download/1e6, path)
+	fmt.Printf("latency %s idle, %s under load\n", result.MinRTT.Round(time.Millisecond), result.LoadedRTT.Round(time.Millisecond))
+	return nil
+}
+
+func printSpeedTestHistory(api *apiclient.Client, peerID string, days int) error {
+	history, err := api.SpeedTestHistory(peerID, time.Now().AddDate(0, 0, -days), time.Time{})
+	if err != nil {
+		return err
+	}
+	if len(history.Trends) == 0 {
+		fmt.Println("no speed tests, run them with 'peers speedtest' or scheduled jobs with speedtest action")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"day", "tests", "failed", "relayed", "avg mbit/s", "min mbit/s", "max mbit/s"})
+	for _, trend := range history.Trends {
+		table.Append([]string{
+			trend.Day.Format(time.DateOnly),
+			strconv.Itoa(trend.Tests),
+			strconv.Itoa(trend.Failed),
+			strconv.Itoa(trend.Relayed),
+			fmt.Sprintf("%.1f", trend.AvgThroughput/1e6),
+			fmt.Sprintf("%.1f", trend.MinThroughput/1e6),
+			fmt.Sprintf("%.1f", trend.MaxThroughput/1e6),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func sendFile(api *apiclient.Client, peerID, path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	status, err := api.SendFile(peerID, path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("sending %s, check progress with 'peers transfers'\n", status.Name)
+	return nil
+}
+
+func printFileTransfers(api *apiclient.Client) error {
+	transfers, err := api.FileTransfers()
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: false})
+	table.SetHeader([]string{"direction", "peer ID", "name", "progress", "state", "started"})
+	for _, transfer := range transfers {
+		progress := "100%"
+		if transfer.Size > 0 {
+			progress = fmt.Sprintf("%d%%", transfer.Transferred*100/transfer.Size)
+		}
+		state := transfer.State
+		if transfer.Error != "" {
+			state += ": " + transfer.Error
+		}
+		table.Append([]string{transfer.Direction, transfer.PeerID, transfer.Name, progress, state,
+			transfer.StartedAt.Format("2006-01-02 15:04")})
+	}
+	table.Render()
+
+	return nil
+}
+
+func sendMessage(api *apiclient.Client, peerID, text string) error {
+	_, err := api.SendMessage(peerID, text)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("message sent successfully")
+	return nil
+}
+
+func printMessages(api *apiclient.Client, peerID string, limit int) error {
+	messages, err := api.Messages(peerID, limit)
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: false})
+	table.SetHeader([]string{"time", "peer ID", "direction", "text"})
+	for _, message := range messages {
+		direction := "from"
+		if message.Direction == service.MessageDirectionOutbound {
+			direction = "to"
+		}
+		if !message.Read {
+			direction += " (new)"
+		}
+		table.Append([]string{message.Time.Format("2006-01-02 15:04"), message.PeerID, direction, message.Text})
+	}
+	table.Render()
+
+	return api.MarkMessagesRead(peerID)
+}
+
+func portScan(api *apiclient.Client, peerID, host, ports string) error {
+	response, err := api.PortScan(peerID, host, ports)
+	if err != nil {
+		return err
+	}
+	if len(response.Open) == 0 {
+		fmt.Println("no open ports")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"port", "banner"})
+	for _, port := range response.Open {
+		table.Append([]string{strconv.Itoa(port.Port), port.Banner})
+	}
+	table.Render()
+	return nil
+}
+
+func supportReport(api *apiclient.Client, peerID string, logMinutes int, output string) error {
+	report, err := api.SupportReport(peerID, logMinutes)
+	if err != nil {
+		return err
+	}
+
+	diagnostics, err := json.MarshalIndent(report.Diagnostics, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(diagnostics))
+	if output == "" {
+		fmt.Println()
+	}
+
+	return writeOutput([]byte(report.Logs), output)
+}
@@ -51,3 +51,32 @@ func showQuestionDialog(title, message, okLabel string) bool {
 	}
 	return true
 }
+
+type friendRequestAnswer int
+
+const (
+	friendRequestLater friendRequestAnswer = iota
+	friendRequestAccepted
+	friendRequestDeclined
+)
+
+// showFriendRequestDialog returns friendRequestLater if the dialog is dismissed or can't be shown,
+// so requests are never accepted without the user.
+func showFriendRequestDialog(title, message string) friendRequestAnswer {
+	uid, hasUID := getRealUserID()
+	opts := []zenity.Option{zenity.Title(title), zenity.QuestionIcon, zenity.OKLabel("Accept"),
+		zenity.ExtraButton("Decline"), zenity.CancelLabel("Later"), zenity.Width(250)}
+	if hasUID {
+		opts = append(opts, zenity.UnixUID(uid))
+	}
+	err := zenity.Question(message, opts...)
+	switch {
+	case err == nil:
+		return friendRequestAccepted
+	case err == zenity.ErrExtraButton:
+		return friendRequestDeclined
+	case err != zenity.ErrCanceled:
+		logger.Errorf("show dialog: friend request: %v", err)
+	}
+	return friendRequestLater
+}
@@ -141,7 +141,17 @@ func subscribeToNotifications(app *awl.Application) {
 		if notifyErr != nil {
 			logger.Errorf("show notification: incoming friend request: %v", notifyErr)
 		}
+		// the request stays pending in the web ui if the dialog is dismissed
+		switch showFriendRequestDialog(title, "Accept friend request from peer "+authRequest.PeerID+"?") {
+		case friendRequestAccepted:
+			handleErrorWithDialog(app.AcceptPeer(authRequest.PeerID, ""))
+		case friendRequestDeclined:
+			handleErrorWithDialog(app.DeclinePeer(authRequest.PeerID))
+		}
 	}, app.Eventbus, new(awlevent.ReceivedAuthRequest))
+	awlevent.WrapSubscriptionToCallback(app.Ctx(), func(_ interface{}) {
+		refreshVPNPauseMenu()
+	}, app.Eventbus, new(awlevent.VPNInterfaceStateChanged))
 }
 
 func openWebGUI(a *awl.Application) error {
@@ -150,7 +160,7 @@ func openWebGUI(a *awl.Application) error {
 		return openURL(adminURL)
 	}
 
-	return openURL("http://" + a.Api.Address())
+	return openURL(a.Api.URL())
 }
 
 func checkURL(url string) bool {
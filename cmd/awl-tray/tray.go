@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"image"
 	"runtime"
-	"sort"
 
 	"fyne.io/systray"
 	"github.com/GrigoryKrasnochub/updaterini"
@@ -23,6 +22,7 @@ var (
 	statusMenu      *systray.MenuItem
 	openBrowserMenu *systray.MenuItem
 	peersMenu       *systray.MenuItem
+	vpnPauseMenu    *systray.MenuItem
 	startStopMenu   *systray.MenuItem
 	restartMenu     *systray.MenuItem
 	updateMenu      *systray.MenuItem
@@ -91,6 +91,24 @@ func initTray() {
 		}
 	}()
 
+	vpnPauseMenu = systray.AddMenuItem("", "Bring vpn interface down without disconnecting from peers")
+	go func() {
+		for range vpnPauseMenu.ClickedCh {
+			a := app
+			if a == nil {
+				continue
+			}
+			var err error
+			if a.Status().VPNPaused {
+				err = a.ResumeVPN()
+			} else {
+				err = a.PauseVPN()
+			}
+			handleErrorWithDialog(err)
+			refreshVPNPauseMenu()
+		}
+	}()
+
 	startStopMenu = systray.AddMenuItem("", "")
 	go func() {
 		for range startStopMenu.ClickedCh {
@@ -136,9 +154,11 @@ func refreshMenusOnStartedServer() {
 	statusMenu.SetTitle("Status: running")
 	openBrowserMenu.Enable()
 	peersMenu.Enable()
+	vpnPauseMenu.Enable()
 	startStopMenu.SetTitle("Stop server")
 	restartMenu.Enable()
 
+	refreshVPNPauseMenu()
 	refreshPeersSubmenus()
 }
 
@@ -146,34 +166,41 @@ func refreshMenusOnStoppedServer() {
 	statusMenu.SetTitle("Status: stopped")
 	openBrowserMenu.Disable()
 	peersMenu.Disable()
+	vpnPauseMenu.SetTitle("Pause VPN")
+	vpnPauseMenu.Disable()
 	startStopMenu.SetTitle("Start server")
 	restartMenu.Disable()
 }
 
+func refreshVPNPauseMenu() {
+	a := app
+	if a == nil {
+		return
+	}
+	if a.Status().VPNPaused {
+		statusMenu.SetTitle("Status: running, vpn paused")
+		vpnPauseMenu.SetTitle("Resume VPN")
+	} else {
+		statusMenu.SetTitle("Status: running")
+		vpnPauseMenu.SetTitle("Pause VPN")
+	}
+}
+
 var peersSubmenus []*systray.MenuItem
 var previousOnlinePeers []string
 var previousOfflinePeers []string
 
 // TODO: submenus on linux doesn't work reliably
 func refreshPeersSubmenus() {
-	app.Conf.RLock()
 	onlinePeers := make([]string, 0)
 	offlinePeers := make([]string, 0)
-	for _, knownPeer := range app.Conf.KnownPeers {
-		online := app.P2p.IsConnected(knownPeer.PeerId())
-		peerName := knownPeer.DisplayName()
-		if peerName == "" {
-			peerName = knownPeer.PeerID
-		}
-		if online {
-			onlinePeers = append(onlinePeers, peerName)
+	for _, peerStatus := range app.Status().Peers {
+		if peerStatus.Connected {
+			onlinePeers = append(onlinePeers, peerStatus.DisplayName)
 		} else {
-			offlinePeers = append(offlinePeers, peerName)
+			offlinePeers = append(offlinePeers, peerStatus.DisplayName)
 		}
 	}
-	app.Conf.RUnlock()
-	sort.Strings(onlinePeers)
-	sort.Strings(offlinePeers)
 
 	if slices.Equal(previousOnlinePeers, onlinePeers) && slices.Equal(previousOfflinePeers, offlinePeers) {
 		return
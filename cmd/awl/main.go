@@ -12,8 +12,10 @@ import (
 	"github.com/anywherelan/awl"
 	"github.com/anywherelan/awl/cli"
 	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/sysservice"
 	"github.com/anywherelan/awl/update"
 	"github.com/ipfs/go-log/v2"
+	"golang.org/x/term"
 )
 
 func main() {
@@ -29,6 +31,10 @@ func main() {
 		}
 	}
 
+	quit := make(chan os.Signal, 2)
+	sysservice.Run(quit)
+
+	config.PassphrasePrompt = promptConfigPassphrase
 	app := awl.New()
 	logger := app.SetupLoggerAndConfig()
 	ctx, ctxCancel := context.WithCancel(context.Background())
@@ -59,7 +65,6 @@ func main() {
 		}()
 	}
 
-	quit := make(chan os.Signal, 2)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
 	logger.Infof("received exit signal '%s'", <-quit)
@@ -98,3 +103,17 @@ func checkForUpdates(conf *config.Config, logger *log.ZapEventLogger) {
 
 	logger.Infof("New version available: %s, current version: %s", updService.NewVersion.VersionTag(), config.Version)
 }
+
+func promptConfigPassphrase() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("stdin is not a terminal, set %s", config.ConfigPassphraseEnvKey)
+	}
+	fmt.Print("config passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(passphrase), nil
+}
@@ -5,10 +5,12 @@ package anywherelan
 
 import (
 	"context"
+	"encoding/json"
 	"os"
-	"strconv"
+	"sync"
 
 	"github.com/anywherelan/awl"
+	"github.com/anywherelan/awl/awlevent"
 	"github.com/anywherelan/awl/config"
 	"github.com/anywherelan/awl/vpn"
 )
@@ -16,34 +18,92 @@ import (
 var (
 	globalApp     *awl.Application
 	globalDataDir string
+
+	listenerLock   sync.Mutex
+	globalListener EventListener
+	listenerCancel context.CancelFunc
 )
 
+// EventListener receives events of the application, e.g. to notify about friend requests or to refresh the list
+// of peers. Types and data are the same as in /events of the web api, data is JSON.
+type EventListener interface {
+	OnEvent(eventType string, data string)
+}
+
 // All public functions are part of the library
 
+// InitServer starts the application with the interface created by VpnService, tunFD is the descriptor of the interface.
+// The application owns the descriptor after the call, it's closed by StopServer or on error.
 func InitServer(dataDir string, tunFD int32) error {
 	globalDataDir = dataDir
 	_ = os.Setenv(config.AppDataDirEnvKey, dataDir)
-	_ = os.Setenv(vpn.TunFDEnvKey, strconv.Itoa(int(tunFD)))
+
+	tunDevice, err := vpn.NewTUNFromFD(int(tunFD))
+	if err != nil {
+		return err
+	}
 
 	globalApp = awl.New()
 	globalApp.SetupLoggerAndConfig()
-	err := globalApp.Init(context.Background(), nil)
+	err = globalApp.Init(context.Background(), tunDevice)
 	if err != nil {
 		globalApp.Close()
 		globalApp = nil
 		return err
 	}
 
+	listenerLock.Lock()
+	subscribeListener()
+	listenerLock.Unlock()
+
 	return nil
 }
 
 func StopServer() {
+	listenerLock.Lock()
+	if listenerCancel != nil {
+		listenerCancel()
+		listenerCancel = nil
+	}
+	listenerLock.Unlock()
+
 	if globalApp != nil {
 		globalApp.Close()
 		globalApp = nil
 	}
 }
 
+// SetEventListener replaces the listener of events, nil removes it. It's kept when the server is restarted.
+func SetEventListener(listener EventListener) {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+	if listenerCancel != nil {
+		listenerCancel()
+		listenerCancel = nil
+	}
+	globalListener = listener
+	if globalApp != nil {
+		subscribeListener()
+	}
+}
+
+// subscribeListener should be called with listenerLock held.
+func subscribeListener() {
+	if globalListener == nil {
+		return
+	}
+	listener := globalListener
+	ctx, cancel := context.WithCancel(context.Background())
+	listenerCancel = cancel
+	awlevent.Tap(ctx, func(evt interface{}) {
+		data, err := json.Marshal(evt)
+		if err != nil {
+			return
+		}
+		listener.OnEvent(awlevent.Name(evt), string(data))
+	}, globalApp.Eventbus)
+}
+
 func ImportConfig(data string) error {
 	if globalApp != nil || globalDataDir == "" {
 		panic("call to ImportConfig before server shutdown")
@@ -52,6 +112,17 @@ func ImportConfig(data string) error {
 	return config.ImportConfig([]byte(data), globalDataDir)
 }
 
+// SetVPNPaused drops traffic of the interface while it's paused, connections to peers are kept.
+func SetVPNPaused(paused bool) error {
+	if globalApp == nil {
+		return awl.ErrNotStarted
+	}
+	if paused {
+		return globalApp.PauseVPN()
+	}
+	return globalApp.ResumeVPN()
+}
+
 func GetApiAddress() string {
 	if globalApp != nil && globalApp.Api != nil {
 		return globalApp.Api.Address()
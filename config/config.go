@@ -1,11 +1,14 @@
 package config
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,12 +17,15 @@ import (
 	"github.com/mr-tron/base58/base58"
 	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/text/unicode/norm"
 
+	"github.com/anywherelan/awl/awldns"
 	"github.com/anywherelan/awl/awlevent"
 )
 
 const (
 	AppConfigFilename         = "config_awl.json"
+	LogFilename               = "awl.log"
 	AppDataDirectory          = "anywherelan"
 	DhtPeerstoreDataDirectory = "peerstore"
 	AppDataDirEnvKey          = "AWL_DATA_DIR"
@@ -31,6 +37,54 @@ const (
 	AdminHttpServerListenAddress = "127.0.0.66:80"
 
 	DefaultPeerAlias = "peer"
+
+	defaultHTTPSRelayListenAddress = "/ip4/0.0.0.0/tcp/443/wss"
+	defaultNTPServer               = "pool.ntp.org:123"
+
+	defaultBackupIntervalHours   = 24
+	defaultLogFileMaxSizeMB      = 10
+	defaultFileTransferMaxSizeMB = 16 << 10
+
+	defaultActiveFriendConnWeight = 100
+	defaultIdleFriendConnWeight   = 50
+	defaultActiveTrafficRate      = 1024
+
+	defaultPeerRequestTimeoutSec = 10
+	defaultProxyDialTimeoutSec   = 10
+
+	SharedFolderAccessRead  = "read"
+	SharedFolderAccessWrite = "write"
+
+	ACLActionAllow  = "allow"
+	ACLActionDeny   = "deny"
+	ACLDirectionIn  = "in"
+	ACLDirectionOut = "out"
+	ACLProtocolTCP  = "tcp"
+	ACLProtocolUDP  = "udp"
+	ACLProtocolICMP = "icmp"
+	MaxACLRules     = 64
+
+	ScheduledJobActionBackup    = "backup"
+	ScheduledJobActionSpeedTest = "speedtest"
+	ScheduledJobTimeLayout      = "15:04"
+
+	APIKeyPermissionRead  = "read"
+	APIKeyPermissionAdmin = "admin"
+
+	MaxQoSRules = 64
+
+	PowerModeAuto    = "auto"
+	PowerModeAC      = "ac"
+	PowerModeBattery = "battery"
+
+	// IPAllocationSequential gives new peers the address after the highest one
+	IPAllocationSequential = "sequential"
+	// IPAllocationHash derives addresses from peer ids, so every node of the network gives the peer the same address
+	IPAllocationHash = "hash"
+	// IPAllocationManual requires the address when a friend request is sent or accepted
+	IPAllocationManual = "manual"
+	// IPAllocationNegotiate uses the address which the peer has for itself if it's free
+	IPAllocationNegotiate = "negotiate"
 )
 
 // LinuxFilesOwnerUID is used to set correct files owner uid.
@@ -39,9 +93,11 @@ var LinuxFilesOwnerUID = os.Geteuid()
 
 type (
 	Config struct {
-		sync.RWMutex `swaggerignore:"true"`
-		dataDir      string
-		emitter      awlevent.Emitter
+		sync.RWMutex  `swaggerignore:"true"`
+		dataDir       string
+		emitter       awlevent.Emitter
+		configEmitter awlevent.Emitter
+		encryption    configEncryption
 
 		Version               string                 `json:"version"`
 		LoggerLevel           string                 `json:"loggerLevel"`
@@ -51,7 +107,36 @@ type (
 		VPNConfig             VPNConfig              `json:"vpn"`
 		KnownPeers            map[string]KnownPeer   `json:"knownPeers"`
 		BlockedPeers          map[string]BlockedPeer `json:"blockedPeers"`
-		Update                UpdateConfig           `json:"update"`
+		// PeerGroups are policies of groups of known peers by group name, see KnownPeer.Groups
+		PeerGroups   map[string]PeerGroup `json:"peerGroups"`
+		Update       UpdateConfig         `json:"update"`
+		Backup       BackupConfig         `json:"backup"`
+		LogFile      LogFileConfig        `json:"logFile"`
+		SharedFolder SharedFolderConfig   `json:"sharedFolder"`
+		DNS          DNSConfig            `json:"dns"`
+		SOCKS5       SOCKS5Config         `json:"socks5"`
+		// FileTransfer receives files sent by known peers
+		FileTransfer FileTransferConfig `json:"fileTransfer"`
+		// KioskListenAddress serves only read-only api, e.g. for status dashboard on TV. Empty address disables it
+		KioskListenAddress string `json:"kioskListenAddress"`
+		// Clock is checked against NTP server and peers, since clock skew breaks TLS handshakes
+		Clock ClockConfig `json:"clock"`
+		// ScheduledJobs are daily jobs with peers, connections with them are established shortly before the jobs
+		ScheduledJobs []ScheduledJob `json:"scheduledJobs"`
+		// Invites are pending one-time invitations issued by us, friend requests with them are accepted automatically
+		Invites []Invite `json:"invites"`
+		// Watchdog restarts failed parts of the app, e.g. TUN interface or web server, without restarting the process
+		Watchdog WatchdogConfig `json:"watchdog"`
+		// GRPC is gRPC version of the management api for automation tools, it's disabled by default
+		GRPC GRPCConfig `json:"grpc"`
+		// APIAuth protects web api with keys and TLS, so it could be exposed beyond localhost
+		APIAuth APIAuthConfig `json:"apiAuth"`
+		// BandwidthLimit limits VPN and SOCKS5 traffic with all peers in total, see KnownPeer.BandwidthLimit
+		BandwidthLimit BandwidthLimitConfig `json:"bandwidthLimit"`
+		// Power reduces background network activity of battery powered devices
+		Power PowerConfig `json:"power"`
+		// Timeouts limit work which isn't bound to api requests, so it's cancelled in time on pause and shutdown
+		Timeouts TimeoutsConfig `json:"timeouts"`
 	}
 	P2pNodeConfig struct {
 		// Hex-encoded multihash representing a peer ID, calculated from Identity
@@ -62,10 +147,249 @@ type (
 		ListenAddresses         []string      `json:"listenAddresses"`
 		ReconnectionIntervalSec time.Duration `json:"reconnectionIntervalSec" swaggertype:"primitive,integer"`
 		AutoAcceptAuthRequests  bool          `json:"autoAcceptAuthRequests"`
+		// EchoPeerMode runs public test peer: all friend requests are accepted and echo requests are answered for everyone
+		EchoPeerMode bool `json:"echoPeerMode"`
+		// ConnManagerWeights are conn manager tag weights for known peers, peers with lower weights are disconnected first.
+		// Defaults are used only if all fields are zero
+		ConnManagerWeights ConnManagerWeightsConfig `json:"connManagerWeights"`
+		// HTTPSRelay is used when relays are unreachable over QUIC and TCP, e.g. in networks which allow only web traffic
+		HTTPSRelay HTTPSRelayConfig `json:"httpsRelay"`
+		// DNSRecords are additional names published to friends, all of them point to this node.
+		// Names are prefixed to our domain name on friends' side, e.g. "plex" is resolved as plex.<our domain>.awl
+		DNSRecords []string `json:"dnsRecords"`
+		// ConnectionGater filters connections of other peers
+		ConnectionGater ConnectionGaterConfig `json:"connectionGater"`
+		// Transports are optional transports besides QUIC and TCP, they are applied after restart
+		Transports TransportsConfig `json:"transports"`
+		// IdentityMigration is the statement of the last rotation of Identity, it's sent to peers until they accept it
+		IdentityMigration IdentityMigrationConfig `json:"identityMigration"`
+		// Rendezvous finds peers which know the same secret phrase and makes friends with them
+		Rendezvous RendezvousConfig `json:"rendezvous"`
+	}
+	IdentityMigrationConfig struct {
+		OldPeerID string    `json:"oldPeerId"`
+		NewPeerID string    `json:"newPeerId"`
+		CreatedAt time.Time `json:"createdAt"`
+		// Signature of the statement by the old key, see protocol.IdentityMigration
+		Signature []byte `json:"signature"`
+		// PendingPeers are trusted peers which haven't accepted the new peer id yet
+		PendingPeers []string `json:"pendingPeers"`
+	}
+	LogFileConfig struct {
+		// Enabled writes logs to file in data directory in addition to in-memory buffer, so they are kept between runs
+		Enabled bool `json:"enabled"`
+		// MaxSizeMB is the size after which file is rotated, one rotated file is kept
+		MaxSizeMB int `json:"maxSizeMB"`
+	}
+	SharedFolderConfig struct {
+		// Path is absolute path of the directory shared with friends over WebDAV, empty path disables sharing.
+		// Access is granted to each peer separately, see KnownPeer.SharedFolderAccess
+		Path string `json:"path"`
+	}
+	FileTransferConfig struct {
+		// InboxPath is absolute path of the directory for files sent by known peers, empty path disables receiving
+		InboxPath string `json:"inboxPath"`
+		// MaxSizeMB limits size of received files, larger files are refused before they are transferred
+		MaxSizeMB int `json:"maxSizeMB"`
+	}
+	DNSConfig struct {
+		// DisableSystemResolver keeps OS resolver settings untouched: systemd-resolved, /etc/resolver or NRPT rules.
+		// Peer names are resolved only by querying awl dns address directly, e.g. with dig @127.0.0.66 peer.awl
+		DisableSystemResolver bool `json:"disableSystemResolver"`
+		// UpstreamPeerID is id of known peer which resolves our queries, e.g. the peer with Pi-hole or corporate dns.
+		// It should allow using it as exit node. Queries are resolved by upstream address while the peer is unreachable
+		UpstreamPeerID string `json:"upstreamPeerId"`
+		// UpstreamAddress is dns server which resolves our queries and queries of peers which use us as upstream peer,
+		// e.g. 127.0.0.1:5353. Empty uses the system one or awldns.DefaultUpstreamDNSAddress
+		UpstreamAddress string `json:"upstreamAddress"`
+	}
+	ClockConfig struct {
+		// NTPServer is used to check clock of this device, e.g. pool.ntp.org:123
+		NTPServer string `json:"ntpServer"`
+		// DisableNTP disables the check against NTP server, e.g. in networks without internet access
+		DisableNTP bool `json:"disableNTP"`
+	}
+	PowerConfig struct {
+		// Mode is auto, ac or battery. Auto detects the power source of the device, battery mode reduces
+		// keepalives, DHT participation and status exchange with peers
+		Mode string `json:"mode"`
+	}
+	WatchdogConfig struct {
+		Disabled bool `json:"disabled"`
+	}
+	TimeoutsConfig struct {
+		// PeerRequestSec limits requests to peers made in background, e.g. friend requests, status exchange
+		// after connection, identity migrations and membership of shared groups
+		PeerRequestSec int `json:"peerRequestSec"`
+		// ProxyDialSec limits TCP connections made for peers which use us as exit node
+		ProxyDialSec int `json:"proxyDialSec"`
+	}
+	GRPCConfig struct {
+		// ListenAddress is address of gRPC server, e.g. 127.0.0.1:8640. Empty address disables the server.
+		// Addresses other than loopback require ClientCAFile or api keys
+		ListenAddress string `json:"listenAddress"`
+		// CertFile and KeyFile are paths to PEM encoded TLS certificate and key, TLS is disabled if they are empty
+		CertFile string `json:"certFile"`
+		KeyFile  string `json:"keyFile"`
+		// ClientCAFile is path to PEM encoded CA certificates, clients should present certificates signed by them if it is set
+		ClientCAFile string `json:"clientCAFile"`
+	}
+	APIAuthConfig struct {
+		// Keys are accepted in Authorization header as "Bearer <key>", web api doesn't require keys if there are none
+		Keys []APIKey `json:"keys"`
+		// RequireOnLocalhost requires keys for requests from loopback addresses too, e.g. from the web ui
+		RequireOnLocalhost bool `json:"requireOnLocalhost"`
+		// CertFile and KeyFile are paths to PEM encoded TLS certificate and key of web api, TLS is disabled if they are empty
+		CertFile string `json:"certFile"`
+		KeyFile  string `json:"keyFile"`
+		// AllowedNetworks are CIDR networks which could connect to web api, e.g. 10.66.0.0/16 and 127.0.0.0/8.
+		// Empty list allows all addresses. They are checked before api keys
+		AllowedNetworks []string `json:"allowedNetworks"`
+		// DeniedNetworks are CIDR networks which can't connect to web api, it overrides AllowedNetworks
+		DeniedNetworks []string `json:"deniedNetworks"`
+	}
+	APIKey struct {
+		// Name is unique name of the key, e.g. monitoring
+		Name string `json:"name"`
+		// Hash is hex-encoded sha256 of the key, the key itself is shown only when it is created
+		Hash string `json:"hash"`
+		// Permission is read for read-only api or admin for the whole api
+		Permission string    `json:"permission" enums:"read,admin"`
+		CreatedAt  time.Time `json:"createdAt"`
+	}
+	ScheduledJob struct {
+		// Name is unique name of the job, e.g. nightly-backup
+		Name   string `json:"name"`
+		PeerID string `json:"peerId"`
+		// Time is daily time of the job in local timezone, e.g. 02:00
+		Time string `json:"time"`
+		// Action is ScheduledJobActionBackup to store our backup on the peer
+		// or ScheduledJobActionSpeedTest to measure throughput to the peer, results are kept in speed test history.
+		// Empty action only keeps the connection for jobs run by other programs, e.g. rsync from cron
+		Action string `json:"action" enums:",backup,speedtest"`
+		// DurationMinutes is how long the connection is kept after Time
+		DurationMinutes int `json:"durationMinutes"`
+	}
+	Invite struct {
+		// SecretHash is hex-encoded sha256 of the secret from invite token
+		SecretHash string `json:"secretHash"`
+		// Alias is given to the peer which uses the invite, empty alias is generated from the peer name
+		Alias     string    `json:"alias"`
+		CreatedAt time.Time `json:"createdAt"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	SOCKS5Config struct {
+		// ListenAddress of local SOCKS5 server, e.g. 127.0.0.1:1080, empty disables the server
+		ListenAddress string `json:"listenAddress"`
+		// PeerID of known peer which makes connections, it should allow using it as exit node
+		PeerID string `json:"peerId"`
+		// ResumablePorts are destination ports of connections which survive brief outages of the peer,
+		// e.g. 22 for SSH. Their data is buffered until the peer acknowledges it
+		ResumablePorts []int `json:"resumablePorts"`
+	}
+	BackupConfig struct {
+		// Peers are ids of trusted known peers which store our encrypted backups
+		Peers []string `json:"peers"`
+		// Passphrase is used to encrypt backups, it is required to restore them on a new device
+		Passphrase    string `json:"passphrase"`
+		IntervalHours int    `json:"intervalHours"`
+	}
+	ConnManagerWeightsConfig struct {
+		ActiveFriend int `json:"activeFriend"`
+		IdleFriend   int `json:"idleFriend"`
+		// ActiveTrafficRate is min traffic rate in bytes per second for a friend to be considered active
+		ActiveTrafficRate int `json:"activeTrafficRate"`
+	}
+	ConnectionGaterConfig struct {
+		// OnlyKnownPeers refuses inbound connections of peers which are not known or allowlisted, so random nodes
+		// from DHT can't connect and send friend requests. Bootstrap peers and fallback relays are still allowed.
+		// It also refuses clients of our relay, so it shouldn't be enabled on relay servers
+		OnlyKnownPeers bool `json:"onlyKnownPeers"`
+		// Allowlist are peer ids which could connect when OnlyKnownPeers is enabled, e.g. to send us friend request
+		Allowlist []string `json:"allowlist"`
+		// Denylist are peer ids which connections are refused in both directions, it overrides everything else
+		Denylist []string `json:"denylist"`
+	}
+	RendezvousConfig struct {
+		// Phrase is shared by the user with friends, e.g. several random words. Anyone who knows it is accepted
+		// as a friend without confirmation, empty phrase disables rendezvous
+		Phrase string `json:"phrase"`
+	}
+	TransportsConfig struct {
+		// WebSocket listens for peers on WebSocketListenAddresses, for networks which block UDP and TCP ports other than web ones.
+		// Websocket addresses of other peers are dialed anyway. Secure websocket is served by HTTPSRelay server
+		WebSocket                bool     `json:"webSocket"`
+		WebSocketListenAddresses []string `json:"webSocketListenAddresses"`
+		// WebTransport is dialed and listened on the same ports as QUIC, it passes firewalls which allow only HTTP/3
+		WebTransport bool `json:"webTransport"`
+	}
+	HTTPSRelayConfig struct {
+		// FallbackRelays are multiaddrs of relays with secure websocket transport, e.g. /dns4/relay.example.com/tcp/443/wss/p2p/12D3KooW...
+		FallbackRelays []string `json:"fallbackRelays"`
+		// ServerEnabled enables serving as a fallback relay for other peers
+		ServerEnabled bool `json:"serverEnabled"`
+		// ServerListenAddress is a websocket multiaddr, e.g. /ip4/0.0.0.0/tcp/443/wss
+		ServerListenAddress string `json:"serverListenAddress"`
+		CertFile            string `json:"certFile"`
+		KeyFile             string `json:"keyFile"`
 	}
 	VPNConfig struct {
 		InterfaceName string `json:"interfaceName"`
 		IPNet         string `json:"ipNet"`
+		// IPv6Prefix is ULA /96 prefix, IPv6 address of every peer is the prefix with its IPv4 address in the last 32 bits
+		IPv6Prefix string `json:"ipv6Prefix"`
+		// KillSwitch drops traffic to all peers while the authenticated path to them is down, see KnownPeer.KillSwitch
+		KillSwitch bool `json:"killSwitch"`
+		// ExitNodePeerID is the known peer which we use as exit node, all internet traffic is routed through it.
+		// The peer should allow it, see KnownPeer.AllowedUsingAsExitNode
+		ExitNodePeerID string `json:"exitNodePeerId"`
+		// AdvertisedRoutes are IPv4 networks reachable through us, e.g. office LAN 192.168.10.0/24.
+		// They are sent to friends in status info, traffic from peers which we allow using us as exit node and router
+		// is forwarded with NAT, see KnownPeer.ExitNodeAllowed.
+		AdvertisedRoutes []string `json:"advertisedRoutes"`
+		// Queues is the number of packet processing workers and interface queues on linux, zero means one per CPU.
+		// It's applied after restart
+		Queues int `json:"queues"`
+		// PacketFilter drops unwanted traffic read from the interface before it's routed to peers
+		PacketFilter PacketFilterConfig `json:"packetFilter"`
+		// QoS prioritizes interactive traffic to peers over bulk transfers when the link is saturated
+		QoS QoSConfig `json:"qos"`
+		// IPAllocation is the strategy of addresses of new peers: IPAllocationSequential, IPAllocationHash,
+		// IPAllocationManual or IPAllocationNegotiate. Addresses of known peers aren't changed,
+		// except that IPAllocationNegotiate follows the peers
+		IPAllocation string `json:"ipAllocation" enums:"sequential,hash,manual,negotiate"`
+		// AutoRenumber moves the vpn network to a free one on start if it overlaps a local network,
+		// host parts of our address and addresses of peers are kept
+		AutoRenumber bool `json:"autoRenumber"`
+		// DisablePingResponder passes ping of our address from peers to the system, otherwise it's answered by awl,
+		// so ping is a reliable test of connectivity even if the system firewall drops it
+		DisablePingResponder bool `json:"disablePingResponder"`
+	}
+	PacketFilterConfig struct {
+		DropIPv6 bool `json:"dropIpv6"`
+		// DropMulticast drops multicast and broadcast, e.g. mDNS and SSDP discovery of the OS
+		DropMulticast bool `json:"dropMulticast"`
+		// DropProtocols are IP protocol numbers 0-255, e.g. 2 for IGMP
+		DropProtocols []int `json:"dropProtocols"`
+	}
+	QoSConfig struct {
+		// Disabled sends packets to peers in arrival order
+		Disabled bool `json:"disabled"`
+		// Rules are checked in order before DSCP marks and ports of interactive protocols: SSH, DNS, NTP, STUN and SIP
+		Rules []QoSRule `json:"rules"`
+	}
+	QoSRule struct {
+		// Protocol is ACLProtocolTCP, ACLProtocolUDP or empty for both
+		Protocol string `json:"protocol" enums:",tcp,udp"`
+		// Ports are ports of either side of connection, e.g. "22" or "8000-8080"
+		Ports    string `json:"ports"`
+		Priority string `json:"priority" enums:"high,normal,low"`
+	}
+	// BandwidthLimitConfig is the rate limit of traffic in kilobits per second, zero is unlimited.
+	// Upload is traffic which we send to peers, Download is traffic which we receive from them
+	BandwidthLimitConfig struct {
+		UploadKbps   int `json:"uploadKbps"`
+		DownloadKbps int `json:"downloadKbps"`
 	}
 	KnownPeer struct {
 		// Hex-encoded multihash representing a peer ID
@@ -88,6 +412,54 @@ type (
 		Declined               bool `json:"declined"`
 		WeAllowUsingAsExitNode bool `json:"weAllowUsingAsExitNode"`
 		AllowedUsingAsExitNode bool `json:"allowedUsingAsExitNode"`
+		// DNSRecords are additional names published by the peer, without peer domain name and zone suffix
+		DNSRecords []string `json:"dnsRecords"`
+		// KillSwitch drops traffic to the peer while it is not confirmed or not connected,
+		// instead of waiting for the connection
+		KillSwitch bool `json:"killSwitch"`
+		// SharedFolderAccess is the peer's access to our shared folder: empty, SharedFolderAccessRead or SharedFolderAccessWrite
+		SharedFolderAccess string `json:"sharedFolderAccess"`
+		// SubnetRoutes are networks advertised by the peer, traffic to them is routed to the peer if AcceptRoutes is set
+		SubnetRoutes []string `json:"subnetRoutes"`
+		// AcceptRoutes routes traffic to SubnetRoutes of the peer. Only private networks not wider than
+		// vpn.MinSubnetRouteBits are accepted, unless AcceptAllRoutes is set
+		AcceptRoutes    bool `json:"acceptRoutes"`
+		AcceptAllRoutes bool `json:"acceptAllRoutes"`
+		// SupportAccess allows the peer to fetch our recent logs and diagnostics report to help with troubleshooting
+		SupportAccess bool `json:"supportAccess"`
+		// ManagementAllowed lets the peer administer this node through its web api over awl network, e.g. 'awl cli --host'.
+		// It gives the same access as admin api key
+		ManagementAllowed bool `json:"managementAllowed"`
+		// MTU of the path to the peer, TCP MSS of connections with the peer is clamped to fit it.
+		// It's for paths which drop big packets, e.g. PPPoE links of subnet routers. Zero uses the interface MTU
+		MTU int `json:"mtu"`
+		// ACL filters VPN traffic with the peer, the first matching rule is applied, traffic is allowed if no rule matches
+		ACL []ACLRule `json:"acl"`
+		// Notes are free-form user notes about the peer, they are not sent to anyone
+		Notes string `json:"notes"`
+		// Contact of the peer owner, e.g. to ask them to restart the device
+		Contact PeerContact `json:"contact"`
+		// Groups are normalized names of groups of the peer, e.g. "work" or "home-lab".
+		// Policies of the groups in Config.PeerGroups are applied to the peer along with its own settings
+		Groups []string `json:"groups"`
+		// BandwidthLimit limits VPN and SOCKS5 traffic with the peer, the global limit is applied as well
+		BandwidthLimit BandwidthLimitConfig `json:"bandwidthLimit"`
+	}
+	PeerContact struct {
+		Owner string `json:"owner"`
+		Phone string `json:"phone"`
+		Email string `json:"email"`
+	}
+	// ACLRule matches connections by the side which opened them, packets of allowed connections pass in both directions.
+	// Empty fields match everything.
+	ACLRule struct {
+		Action string `json:"action" enums:"allow,deny"`
+		// Direction is ACLDirectionIn for connections opened by the peer, ACLDirectionOut for connections opened by us
+		Direction string `json:"direction" enums:",in,out"`
+		// Protocol is ACLProtocolTCP, ACLProtocolUDP or ACLProtocolICMP, which matches ICMPv6 too
+		Protocol string `json:"protocol" enums:",tcp,udp,icmp"`
+		// Ports are destination ports of connection, e.g. "22" or "8000-8080", only tcp and udp packets match them
+		Ports string `json:"ports"`
 	}
 	BlockedPeer struct {
 		// Hex-encoded multihash representing a peer ID
@@ -101,6 +473,12 @@ type (
 		UpdateServerURL       string `json:"updateServerURL"`
 		TrayAutoCheckEnabled  bool   `json:"trayAutoCheckEnabled"`
 		TrayAutoCheckInterval string `json:"trayAutoCheckInterval"`
+		// PublicKey is minisign public key of releases. If set, update is installed only if all its files
+		// have valid signatures in <filename>.minisig assets. Trusted comments of signatures should contain
+		// the file name and the release version, e.g. "file:awl-linux-amd64.tar.gz version:v0.12.0"
+		PublicKey string `json:"publicKey"`
+		// DisableGitHubSource leaves UpdateServerURL as the only source, e.g. for self-hosted update channel
+		DisableGitHubSource bool `json:"disableGitHubSource"`
 	}
 )
 
@@ -108,22 +486,58 @@ func (c *Config) Save() {
 	c.RLock()
 	c.save()
 	c.RUnlock()
+
+	_ = c.configEmitter.Emit(awlevent.ConfigChanged{})
+}
+
+// NormalizePeerAlias trims spaces and composes unicode characters (NFC), so the same alias typed on different OS is equal.
+func NormalizePeerAlias(alias string) string {
+	return norm.NFC.String(strings.TrimSpace(alias))
+}
+
+// peerAliasKey is equal for aliases which look the same: they differ only in case or unicode compatibility forms.
+func peerAliasKey(alias string) string {
+	return strings.ToLower(norm.NFKC.String(alias))
 }
 
 func (c *Config) IsUniqPeerAlias(excludePeerID, alias string) bool {
 	c.RLock()
 	defer c.RUnlock()
+	key := peerAliasKey(alias)
 	for _, kPeer := range c.KnownPeers {
 		if kPeer.PeerID == excludePeerID {
 			continue
 		}
-		if kPeer.Alias == alias {
+		if peerAliasKey(kPeer.Alias) == key {
 			return false
 		}
 	}
 	return true
 }
 
+// IsUniqDomainName checks that domain name isn't used by another peer, names are compared after normalization.
+func (c *Config) IsUniqDomainName(excludePeerID, domainName string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	domainName = awldns.TrimDomainName(domainName)
+	for _, kPeer := range c.KnownPeers {
+		if kPeer.PeerID == excludePeerID {
+			continue
+		}
+		if awldns.TrimDomainName(kPeer.DomainName) == domainName {
+			return false
+		}
+	}
+	return true
+}
+
+// GenUniqDomainName makes domain name from peer display name which isn't used by other peers.
+func (c *Config) GenUniqDomainName(excludePeerID, displayName string) string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.genUniqDomainName(excludePeerID, displayName)
+}
+
 func (c *Config) GenUniqPeerAlias(name, alias string) string {
 	c.RLock()
 	alias = c.genUniqPeerAlias(name, alias, nil)
@@ -131,6 +545,97 @@ func (c *Config) GenUniqPeerAlias(name, alias string) string {
 	return alias
 }
 
+// FollowPeerRename returns alias and domain name of the known peer after it renamed itself to newName.
+// Alias and domain name which were generated from the previous name follow the new one, the ones set by the user are kept.
+func (c *Config) FollowPeerRename(peer KnownPeer, newName string) (alias, domainName string) {
+	c.RLock()
+	defer c.RUnlock()
+	alias, domainName = peer.Alias, peer.DomainName
+	if peer.Name == "" || NormalizePeerAlias(newName) == "" {
+		return alias, domainName
+	}
+
+	if isGeneratedName(peerAliasKey(alias), peerAliasKey(NormalizePeerAlias(peer.Name))) {
+		uniqAliases := make(map[string]struct{}, len(c.KnownPeers))
+		for _, kPeer := range c.KnownPeers {
+			if kPeer.PeerID != peer.PeerID {
+				uniqAliases[peerAliasKey(kPeer.Alias)] = struct{}{}
+			}
+		}
+		alias = c.genUniqPeerAlias(newName, "", uniqAliases)
+	}
+	if isGeneratedName(awldns.TrimDomainName(domainName), awldns.TrimDomainName(peer.DisplayName())) {
+		domainName = c.genUniqDomainName(peer.PeerID, alias)
+	}
+	return alias, domainName
+}
+
+// isGeneratedName reports whether name is base or base with suffix of uniqueness, e.g. laptop_1 for laptop.
+func isGeneratedName(name, base string) bool {
+	if name == base {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(name, base+"_")
+	if !ok || suffix == "" {
+		return false
+	}
+	for _, r := range suffix {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// GenUniqPeerAliases generates aliases which are unique among known peers and each other.
+func (c *Config) GenUniqPeerAliases(names []string) []string {
+	c.RLock()
+	defer c.RUnlock()
+	uniqAliases := make(map[string]struct{}, len(c.KnownPeers)+len(names))
+	for _, kPeer := range c.KnownPeers {
+		uniqAliases[peerAliasKey(kPeer.Alias)] = struct{}{}
+	}
+	aliases := make([]string, 0, len(names))
+	for _, name := range names {
+		aliases = append(aliases, c.genUniqPeerAlias(name, "", uniqAliases))
+	}
+	return aliases
+}
+
+func (c *Config) RendezvousPhrase() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.P2pNode.Rendezvous.Phrase
+}
+
+// DNSUpstreamPeer returns DNSConfig.UpstreamPeerID.
+func (c *Config) DNSUpstreamPeer() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.DNS.UpstreamPeerID
+}
+
+// DNSUpstreamAddress returns DNSConfig.UpstreamAddress.
+func (c *Config) DNSUpstreamAddress() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.DNS.UpstreamAddress
+}
+
+// PeerRequestTimeout is the timeout of background requests to peers, see TimeoutsConfig.PeerRequestSec.
+func (c *Config) PeerRequestTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.Timeouts.PeerRequestSec) * time.Second
+}
+
+// ProxyDialTimeout is the timeout of connections made for peers, see TimeoutsConfig.ProxyDialSec.
+func (c *Config) ProxyDialTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.Timeouts.ProxyDialSec) * time.Second
+}
+
 func (c *Config) KnownPeersIds() []peer.ID {
 	c.RLock()
 	ids := make([]peer.ID, 0, len(c.KnownPeers))
@@ -213,6 +718,141 @@ func (c *Config) UpsertBlockedPeer(peerID, displayName string) {
 	c.Unlock()
 }
 
+// MigratePeer moves the known peer to its new peer id after rotation of its identity key,
+// references to the old peer id are updated too. It returns false if the old peer isn't known or the new one is.
+func (c *Config) MigratePeer(oldPeerID, newPeerID string) bool {
+	c.Lock()
+	knownPeer, exists := c.KnownPeers[oldPeerID]
+	_, newExists := c.KnownPeers[newPeerID]
+	if !exists || newExists {
+		c.Unlock()
+		return false
+	}
+	delete(c.KnownPeers, oldPeerID)
+	knownPeer.PeerID = newPeerID
+	c.KnownPeers[newPeerID] = knownPeer
+
+	replace := func(peerID *string) {
+		if *peerID == oldPeerID {
+			*peerID = newPeerID
+		}
+	}
+	replace(&c.VPNConfig.ExitNodePeerID)
+	replace(&c.SOCKS5.PeerID)
+	for i := range c.Backup.Peers {
+		replace(&c.Backup.Peers[i])
+	}
+	for i := range c.P2pNode.ConnectionGater.Allowlist {
+		replace(&c.P2pNode.ConnectionGater.Allowlist[i])
+	}
+	for i := range c.ScheduledJobs {
+		replace(&c.ScheduledJobs[i].PeerID)
+	}
+	c.save()
+	c.Unlock()
+
+	_ = c.emitter.Emit(awlevent.KnownPeerChanged{})
+	return true
+}
+
+func (c *Config) AddInvite(invite Invite) {
+	c.Lock()
+	c.Invites = slices.DeleteFunc(c.Invites, func(i Invite) bool {
+		return !time.Now().Before(i.ExpiresAt)
+	})
+	c.Invites = append(c.Invites, invite)
+	c.save()
+	c.Unlock()
+}
+
+// UseInvite removes the invite with the secret hash and returns it if it is not expired.
+func (c *Config) UseInvite(secretHash string) (Invite, bool) {
+	c.Lock()
+	defer c.Unlock()
+	idx := slices.IndexFunc(c.Invites, func(i Invite) bool {
+		return i.SecretHash == secretHash
+	})
+	if idx == -1 {
+		return Invite{}, false
+	}
+	invite := c.Invites[idx]
+	c.Invites = slices.Delete(c.Invites, idx, idx+1)
+	c.save()
+
+	return invite, time.Now().Before(invite.ExpiresAt)
+}
+
+// AddAPIKey returns false if there is a key with the same name.
+func (c *Config) AddAPIKey(key APIKey) bool {
+	c.Lock()
+	defer c.Unlock()
+	if slices.ContainsFunc(c.APIAuth.Keys, func(k APIKey) bool { return k.Name == key.Name }) {
+		return false
+	}
+	c.APIAuth.Keys = append(c.APIAuth.Keys, key)
+	c.save()
+	return true
+}
+
+func (c *Config) RemoveAPIKey(name string) bool {
+	c.Lock()
+	defer c.Unlock()
+	idx := slices.IndexFunc(c.APIAuth.Keys, func(k APIKey) bool {
+		return k.Name == name
+	})
+	if idx == -1 {
+		return false
+	}
+	c.APIAuth.Keys = slices.Delete(c.APIAuth.Keys, idx, idx+1)
+	c.save()
+	return true
+}
+
+// APIKeyByHash returns the key with hex-encoded sha256 hash.
+func (c *Config) APIKeyByHash(hash string) (APIKey, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	for _, key := range c.APIAuth.Keys {
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// AllowsAPIAddress reports whether the address could connect to web api by APIAuthConfig networks.
+// It returns error if networks are invalid.
+func (c *Config) AllowsAPIAddress(ip net.IP) (bool, error) {
+	c.RLock()
+	allowedNetworks, deniedNetworks := c.APIAuth.AllowedNetworks, c.APIAuth.DeniedNetworks
+	c.RUnlock()
+
+	denied, err := containsIP(deniedNetworks, ip)
+	if err != nil || denied {
+		return false, err
+	}
+	if len(allowedNetworks) == 0 {
+		return true, nil
+	}
+	return containsIP(allowedNetworks, ip)
+}
+
+// AllowsConnection reports whether connection with the peer is allowed by ConnectionGaterConfig.
+// Inbound is true for connections initiated by the peer, infrastructure is true for bootstrap peers and relays.
+func (c *Config) AllowsConnection(peerID string, inbound, infrastructure bool) bool {
+	c.RLock()
+	defer c.RUnlock()
+	gater := c.P2pNode.ConnectionGater
+	if slices.Contains(gater.Denylist, peerID) {
+		return false
+	}
+	if !inbound || !gater.OnlyKnownPeers || infrastructure {
+		return true
+	}
+	_, known := c.KnownPeers[peerID]
+	return known || slices.Contains(gater.Allowlist, peerID)
+}
+
 func (c *Config) SetIdentity(key crypto.PrivKey, id peer.ID) {
 	c.Lock()
 	by, _ := key.Raw()
@@ -265,6 +905,47 @@ func (c *Config) GetBootstrapPeers() []peer.AddrInfo {
 	return addrInfos
 }
 
+// AddBootstrapPeer returns false if the address is already added.
+func (c *Config) AddBootstrapPeer(addr string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if slices.Contains(c.P2pNode.BootstrapPeers, addr) {
+		return false
+	}
+	// slices are copied, since they could be shared with copies of config
+	c.P2pNode.BootstrapPeers = append(slices.Clip(c.P2pNode.BootstrapPeers), addr)
+	c.save()
+	return true
+}
+
+func (c *Config) RemoveBootstrapPeer(addr string) bool {
+	c.Lock()
+	defer c.Unlock()
+	idx := slices.Index(c.P2pNode.BootstrapPeers, addr)
+	if idx == -1 {
+		return false
+	}
+	c.P2pNode.BootstrapPeers = slices.Delete(slices.Clone(c.P2pNode.BootstrapPeers), idx, idx+1)
+	c.save()
+	return true
+}
+
+func (c *Config) GetFallbackRelays() []peer.AddrInfo {
+	c.RLock()
+	defer c.RUnlock()
+	relays := make([]peer.AddrInfo, 0, len(c.P2pNode.HTTPSRelay.FallbackRelays))
+	for _, val := range c.P2pNode.HTTPSRelay.FallbackRelays {
+		addrInfo, err := peer.AddrInfoFromString(val)
+		if err != nil {
+			logger.Warnf("invalid fallback relay addr info from config: %v", err)
+			continue
+		}
+		relays = append(relays, *addrInfo)
+	}
+
+	return relays
+}
+
 func (c *Config) SetListenAddresses(multiaddrs []multiaddr.Multiaddr) {
 	c.Lock()
 	result := make([]string, 0, len(multiaddrs))
@@ -290,6 +971,25 @@ func (c *Config) GetListenAddresses() []multiaddr.Multiaddr {
 	return result
 }
 
+// GetWebSocketListenAddresses returns nil if listening on websocket is disabled, see TransportsConfig.
+func (c *Config) GetWebSocketListenAddresses() []multiaddr.Multiaddr {
+	c.RLock()
+	defer c.RUnlock()
+	if !c.P2pNode.Transports.WebSocket {
+		return nil
+	}
+	result := make([]multiaddr.Multiaddr, 0, len(c.P2pNode.Transports.WebSocketListenAddresses))
+	for _, val := range c.P2pNode.Transports.WebSocketListenAddresses {
+		newMultiaddr, err := multiaddr.NewMultiaddr(val)
+		if err != nil {
+			logger.Errorf("parse websocket listen address '%s': %v", val, err)
+			continue
+		}
+		result = append(result, newMultiaddr)
+	}
+	return result
+}
+
 func (c *Config) VPNLocalIPMask() (net.IP, net.IPMask) {
 	localIP, ipNet, err := net.ParseCIDR(c.VPNConfig.IPNet)
 	if err != nil {
@@ -308,6 +1008,9 @@ func (c *Config) DNSNamesMapping() map[string]string {
 		mapping[knownPeer.PeerID] = knownPeer.IPAddr
 		if knownPeer.DomainName != "" {
 			mapping[knownPeer.DomainName] = knownPeer.IPAddr
+			for _, record := range knownPeer.DNSRecords {
+				mapping[record+"."+knownPeer.DomainName] = knownPeer.IPAddr
+			}
 		}
 	}
 
@@ -323,6 +1026,10 @@ func (c *Config) DataDir() string {
 	return c.dataDir
 }
 
+func (c *Config) LogFilePath() string {
+	return filepath.Join(c.dataDir, LogFilename)
+}
+
 func (c *Config) LogLevel() zapcore.Level {
 	level := c.LoggerLevel
 	if c.LoggerLevel == "dev" {
@@ -352,17 +1059,35 @@ func (c *Config) Export() []byte {
 }
 
 func (c *Config) save() {
+	err := c.writeFile()
+	if err != nil {
+		logger.DPanicf("Save config: %v", err)
+	}
+}
+
+func (c *Config) writeFile() error {
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
-		logger.DPanicf("Marshal config: %v", err)
-		return
+		return fmt.Errorf("marshal config: %v", err)
+	}
+	return writeConfigFile(c.path(), data, c.encryption)
+}
+
+// writeConfigFile is the only way config is written, so it's always encrypted if encryption at rest is enabled.
+func writeConfigFile(path string, data []byte, encryption configEncryption) error {
+	var err error
+	if encryption.mode != EncryptionNone {
+		data, err = encryption.seal(data)
+		if err != nil {
+			return fmt.Errorf("encrypt config: %v", err)
+		}
 	}
-	path := c.path()
 	err = os.WriteFile(path, data, filesPerm)
 	if err != nil {
-		logger.DPanicf("Save config: %v", err)
+		return err
 	}
 	ChownFileIfNeeded(path)
+	return nil
 }
 
 func (c *Config) path() string {
@@ -370,8 +1095,11 @@ func (c *Config) path() string {
 	return path
 }
 
+// genUniqPeerAlias keys uniqAliases by peerAliasKey.
 func (c *Config) genUniqPeerAlias(name, alias string, uniqAliases map[string]struct{}) string {
+	alias = NormalizePeerAlias(alias)
 	if alias == "" {
+		name = NormalizePeerAlias(name)
 		if name == "" {
 			alias = DefaultPeerAlias
 		} else {
@@ -381,21 +1109,40 @@ func (c *Config) genUniqPeerAlias(name, alias string, uniqAliases map[string]str
 	if uniqAliases == nil {
 		uniqAliases = make(map[string]struct{}, len(c.KnownPeers)+1)
 		for _, kPeer := range c.KnownPeers {
-			uniqAliases[kPeer.Alias] = struct{}{}
+			uniqAliases[peerAliasKey(kPeer.Alias)] = struct{}{}
 		}
 	}
-	if _, ok := uniqAliases[alias]; ok {
+	if _, ok := uniqAliases[peerAliasKey(alias)]; ok {
 		newAlias := ""
 		for i := 0; ok; i++ {
 			newAlias = fmt.Sprintf("%s_%d", alias, i)
-			_, ok = uniqAliases[newAlias]
+			_, ok = uniqAliases[peerAliasKey(newAlias)]
 		}
 		alias = newAlias
 	}
-	uniqAliases[alias] = struct{}{}
+	uniqAliases[peerAliasKey(alias)] = struct{}{}
 	return alias
 }
 
+func (c *Config) genUniqDomainName(excludePeerID, displayName string) string {
+	domainName := awldns.TrimDomainName(displayName)
+	used := make(map[string]struct{}, len(c.KnownPeers))
+	for _, kPeer := range c.KnownPeers {
+		if kPeer.PeerID != excludePeerID && kPeer.DomainName != "" {
+			used[awldns.TrimDomainName(kPeer.DomainName)] = struct{}{}
+		}
+	}
+	if _, ok := used[domainName]; !ok {
+		return domainName
+	}
+	newDomainName := ""
+	for i, ok := 0, true; ok; i++ {
+		newDomainName = fmt.Sprintf("%s_%d", domainName, i)
+		_, ok = used[newDomainName]
+	}
+	return newDomainName
+}
+
 func (kp KnownPeer) PeerId() peer.ID {
 	peerID, err := peer.Decode(kp.PeerID)
 	if err != nil {
@@ -1,7 +1,14 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 )
 
 func TestConfig_GetBootstrapPeers(t *testing.T) {
@@ -11,3 +18,342 @@ func TestConfig_GetBootstrapPeers(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestConfig_AllowsConnection(t *testing.T) {
+	cfg := &Config{KnownPeers: map[string]KnownPeer{"known": {}}}
+	cfg.P2pNode.ConnectionGater.Denylist = []string{"denied"}
+	if !cfg.AllowsConnection("unknown", true, false) || cfg.AllowsConnection("denied", false, true) {
+		t.Fatal()
+	}
+
+	cfg.P2pNode.ConnectionGater.OnlyKnownPeers = true
+	cfg.P2pNode.ConnectionGater.Allowlist = []string{"allowed"}
+	tests := []struct {
+		peerID         string
+		inbound        bool
+		infrastructure bool
+		want           bool
+	}{
+		{"known", true, false, true},
+		{"allowed", true, false, true},
+		{"unknown", true, false, false},
+		{"unknown", false, false, true},
+		{"unknown", true, true, true},
+		{"denied", true, true, false},
+	}
+	for _, tt := range tests {
+		if got := cfg.AllowsConnection(tt.peerID, tt.inbound, tt.infrastructure); got != tt.want {
+			t.Errorf("AllowsConnection(%s, %v, %v) = %v, want %v", tt.peerID, tt.inbound, tt.infrastructure, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_UseInvite(t *testing.T) {
+	cfg := &Config{dataDir: t.TempDir()}
+	cfg.AddInvite(Invite{SecretHash: "expired", ExpiresAt: time.Now().Add(-time.Minute)})
+	cfg.AddInvite(Invite{SecretHash: "valid", Alias: "laptop", ExpiresAt: time.Now().Add(time.Hour)})
+	// expired invites are removed when new ones are added
+	if len(cfg.Invites) != 1 {
+		t.Fatalf("expected 1 invite, got %d", len(cfg.Invites))
+	}
+
+	invite, ok := cfg.UseInvite("valid")
+	if !ok || invite.Alias != "laptop" {
+		t.Fatalf("invite should be valid: %v", invite)
+	}
+	if _, ok = cfg.UseInvite("valid"); ok {
+		t.Fatal("invite should be used only once")
+	}
+	if _, ok = cfg.UseInvite("unknown"); ok {
+		t.Fatal("unknown invite should be invalid")
+	}
+}
+
+func TestConfig_APIKeys(t *testing.T) {
+	cfg := &Config{dataDir: t.TempDir()}
+	if !cfg.AddAPIKey(APIKey{Name: "monitoring", Hash: "hash1", Permission: APIKeyPermissionRead}) {
+		t.Fatal("key should be added")
+	}
+	if cfg.AddAPIKey(APIKey{Name: "monitoring", Hash: "hash2", Permission: APIKeyPermissionAdmin}) {
+		t.Fatal("key names should be unique")
+	}
+
+	key, ok := cfg.APIKeyByHash("hash1")
+	if !ok || key.Name != "monitoring" {
+		t.Fatalf("key should be found: %v", key)
+	}
+	if _, ok = cfg.APIKeyByHash("hash2"); ok {
+		t.Fatal("unknown key should not be found")
+	}
+
+	if !cfg.RemoveAPIKey("monitoring") {
+		t.Fatal("key should be removed")
+	}
+	if _, ok = cfg.APIKeyByHash("hash1"); ok {
+		t.Fatal("removed key should not be found")
+	}
+}
+
+func TestConfig_SetEncryption(t *testing.T) {
+	cfg := &Config{dataDir: t.TempDir()}
+	cfg.P2pNode.Identity = "secret-identity"
+	if err := cfg.SetEncryption(EncryptionPassphrase, "short"); err == nil {
+		t.Fatal("short passphrase is accepted")
+	}
+	if err := cfg.SetEncryption(EncryptionPassphrase, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cfg.path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedConfig(data) || bytes.Contains(data, []byte("secret-identity")) {
+		t.Fatal("config is not encrypted")
+	}
+
+	t.Setenv(ConfigPassphraseEnvKey, "")
+	if _, _, err := openConfig(data, cfg.path()); !errors.Is(err, ErrConfigLocked) {
+		t.Fatalf("open without passphrase: %v", err)
+	}
+	t.Setenv(ConfigPassphraseEnvKey, "wrong passphrase")
+	if _, _, err := openConfig(data, cfg.path()); !errors.Is(err, ErrConfigLocked) {
+		t.Fatalf("open with wrong passphrase: %v", err)
+	}
+	t.Setenv(ConfigPassphraseEnvKey, "correct horse")
+	plaintext, encryption, err := openConfig(data, cfg.path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := new(Config)
+	if err := json.Unmarshal(plaintext, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.P2pNode.Identity != "secret-identity" || encryption.mode != EncryptionPassphrase {
+		t.Fatalf("unexpected config after decryption: %s", plaintext)
+	}
+
+	// config is saved with the same key after load
+	loaded.dataDir = cfg.dataDir
+	loaded.encryption = encryption
+	loaded.save()
+	data, _ = os.ReadFile(cfg.path())
+	if _, _, err := openConfig(data, cfg.path()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loaded.SetEncryption(EncryptionNone, ""); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(cfg.path())
+	if isEncryptedConfig(data) || !bytes.Contains(data, []byte("secret-identity")) {
+		t.Fatal("config is still encrypted")
+	}
+}
+
+func TestImportConfig_KeepsEncryption(t *testing.T) {
+	cfg := &Config{dataDir: t.TempDir()}
+	if err := cfg.SetEncryption(EncryptionPassphrase, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	imported := []byte(`{"p2pNode": {"identity": "secret-identity"}}`)
+
+	t.Setenv(ConfigPassphraseEnvKey, "")
+	if err := ImportConfig(imported, cfg.dataDir); !errors.Is(err, ErrConfigLocked) {
+		t.Fatalf("import without passphrase: %v", err)
+	}
+	t.Setenv(ConfigPassphraseEnvKey, "correct horse")
+	if err := ImportConfig(imported, cfg.dataDir); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(cfg.path())
+	if !isEncryptedConfig(data) || bytes.Contains(data, []byte("secret-identity")) {
+		t.Fatal("imported config is not encrypted")
+	}
+
+	if err := cfg.Import([]byte(`{"p2pNode": {"identity": "another-identity"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := cfg.Reload(eventbus.NewBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.P2pNode.Identity != "another-identity" || reloaded.Encryption() != EncryptionPassphrase {
+		t.Fatalf("unexpected config after reload: %+v", reloaded.P2pNode)
+	}
+}
+
+func TestConfig_UnicodePeerAliases(t *testing.T) {
+	cfg := &Config{KnownPeers: map[string]KnownPeer{
+		"laptop": {PeerID: "laptop", Alias: "Café", DomainName: "café"},
+	}}
+	if NormalizePeerAlias(" Café ") != "Café" {
+		t.Fatal("alias is not composed")
+	}
+	if cfg.IsUniqPeerAlias("", "CAFÉ") || cfg.IsUniqPeerAlias("", "ｃａｆé") || !cfg.IsUniqPeerAlias("laptop", "café") {
+		t.Fatal("alias collision is not detected")
+	}
+	if !cfg.IsUniqPeerAlias("", "Cafe") {
+		t.Fatal("different alias is not unique")
+	}
+	if cfg.IsUniqDomainName("", "CAFÉ") || !cfg.IsUniqDomainName("laptop", "café") {
+		t.Fatal("domain name collision is not detected")
+	}
+	if alias := cfg.GenUniqPeerAlias("café", ""); alias != "café_0" {
+		t.Fatalf("unexpected alias %s", alias)
+	}
+	if domainName := cfg.GenUniqDomainName("phone", "Café"); domainName != "café_0" {
+		t.Fatalf("unexpected domain name %s", domainName)
+	}
+}
+
+func TestConfig_PeerGroups(t *testing.T) {
+	groups, err := NormalizePeerGroups([]string{" Work", "home-lab", "work"})
+	if err != nil || len(groups) != 2 || groups[0] != "home-lab" || groups[1] != "work" {
+		t.Fatalf("unexpected groups %v: %v", groups, err)
+	}
+	for _, invalid := range []string{"", "home lab", "a.b"} {
+		if _, err = NormalizePeerGroup(invalid); err == nil {
+			t.Fatalf("invalid group %q is accepted", invalid)
+		}
+	}
+
+	denySSH := ACLRule{Action: ACLActionDeny, Direction: ACLDirectionIn, Protocol: ACLProtocolTCP, Ports: "22"}
+	allowAll := ACLRule{Action: ACLActionAllow}
+	cfg := &Config{
+		KnownPeers: map[string]KnownPeer{
+			"laptop": {PeerID: "laptop", ACL: []ACLRule{allowAll}, Groups: []string{"home-lab", "work"}},
+			"phone":  {PeerID: "phone"},
+		},
+		PeerGroups: map[string]PeerGroup{
+			"work": {ACL: []ACLRule{denySSH}, AllowUsingAsExitNode: true},
+		},
+	}
+	laptop := cfg.KnownPeers["laptop"]
+	if rules := laptop.EffectiveACL(cfg.PeerGroups); len(rules) != 2 || rules[0] != allowAll || rules[1] != denySSH {
+		t.Fatalf("unexpected effective acl %v", rules)
+	}
+	if len(laptop.ACL) != 1 {
+		t.Fatal("acl of the peer is changed")
+	}
+	if !cfg.PeerExitNodeAllowed("laptop") || cfg.PeerExitNodeAllowed("phone") || cfg.PeerExitNodeAllowed("unknown") {
+		t.Fatal("exit node permission of the group is not applied")
+	}
+	if names := cfg.PeerGroupNames(); len(names) != 2 || names[0] != "home-lab" || names[1] != "work" {
+		t.Fatalf("unexpected group names %v", names)
+	}
+
+	cfg.dataDir = t.TempDir()
+	setDefaults(cfg, eventbus.NewBus())
+	members := cfg.RemovePeerGroup("work")
+	if len(members) != 1 || members[0].PeerID != "laptop" {
+		t.Fatalf("unexpected members %v", members)
+	}
+	if cfg.PeerExitNodeAllowed("laptop") || cfg.KnownPeers["laptop"].InGroup("work") || !cfg.KnownPeers["laptop"].InGroup("home-lab") {
+		t.Fatal("group is not removed")
+	}
+}
+
+func TestConfig_ApplyGroupMembership(t *testing.T) {
+	cfg := new(Config)
+	cfg.dataDir = t.TempDir()
+	setDefaults(cfg, eventbus.NewBus())
+	cfg.KnownPeers["laptop"] = KnownPeer{PeerID: "laptop", Groups: []string{"work"}}
+	cfg.KnownPeers["phone"] = KnownPeer{PeerID: "phone", Groups: []string{"home-lab"}}
+	cfg.KnownPeers["owner"] = KnownPeer{PeerID: "owner"}
+	cfg.PeerGroups = map[string]PeerGroup{"work": {Owner: "owner"}}
+
+	if cfg.ApplyGroupMembership("work", "phone", []string{"phone"}, 2) {
+		t.Fatal("membership signed by other peer is applied")
+	}
+	if cfg.ApplyGroupMembership("home-lab", "owner", []string{"phone"}, 2) {
+		t.Fatal("membership of not owned group is applied")
+	}
+	if !cfg.ApplyGroupMembership("work", "owner", []string{"owner", "phone", "unknown"}, 2) {
+		t.Fatal("membership is not applied")
+	}
+	if members := cfg.GroupMembers("work"); len(members) != 2 || members[0] != "owner" || members[1] != "phone" {
+		t.Errorf("GroupMembers() = %v", members)
+	}
+	if groups := cfg.KnownPeers["phone"].Groups; len(groups) != 2 || groups[0] != "home-lab" || groups[1] != "work" {
+		t.Errorf("unexpected groups of member %v", groups)
+	}
+	if cfg.PeerGroups["work"].MembershipTime != 2 {
+		t.Errorf("unexpected membership time %d", cfg.PeerGroups["work"].MembershipTime)
+	}
+	if cfg.ApplyGroupMembership("work", "owner", []string{"laptop"}, 2) {
+		t.Fatal("membership which is not newer is applied")
+	}
+}
+
+func TestConfig_FollowPeerRename(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+	cfg.KnownPeers["peer1"] = KnownPeer{PeerID: "peer1", Name: "laptop", Alias: "laptop_1", DomainName: "laptop_1"}
+	cfg.KnownPeers["peer2"] = KnownPeer{PeerID: "peer2", Name: "nas", Alias: "desktop", DomainName: "desktop"}
+
+	alias, domainName := cfg.FollowPeerRename(cfg.KnownPeers["peer1"], "Desktop")
+	if alias != "Desktop_0" || domainName != "desktop_0" {
+		t.Errorf("FollowPeerRename() = %v, %v", alias, domainName)
+	}
+	// set by the user
+	alias, domainName = cfg.FollowPeerRename(cfg.KnownPeers["peer2"], "server")
+	if alias != "desktop" || domainName != "desktop" {
+		t.Errorf("FollowPeerRename() of user alias = %v, %v", alias, domainName)
+	}
+	// case change doesn't collide with the peer itself
+	alias, _ = cfg.FollowPeerRename(KnownPeer{PeerID: "peer2", Name: "desktop", Alias: "desktop"}, "DESKTOP")
+	if alias != "DESKTOP" {
+		t.Errorf("FollowPeerRename() of case change = %v", alias)
+	}
+}
+
+func TestConfig_Timeouts(t *testing.T) {
+	cfg := new(Config)
+	cfg.Timeouts.ProxyDialSec = 3
+	setDefaults(cfg, eventbus.NewBus())
+
+	if got := cfg.PeerRequestTimeout(); got != defaultPeerRequestTimeoutSec*time.Second {
+		t.Errorf("PeerRequestTimeout() = %v", got)
+	}
+	if got := cfg.ProxyDialTimeout(); got != 3*time.Second {
+		t.Errorf("ProxyDialTimeout() = %v", got)
+	}
+}
+
+func TestConfig_ConnManagerWeights(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+	if cfg.P2pNode.ConnManagerWeights.ActiveFriend != defaultActiveFriendConnWeight || cfg.P2pNode.ConnManagerWeights.IdleFriend != defaultIdleFriendConnWeight {
+		t.Errorf("unexpected default weights %+v", cfg.P2pNode.ConnManagerWeights)
+	}
+
+	// zero weights are kept if the weights are configured
+	cfg = new(Config)
+	cfg.P2pNode.ConnManagerWeights.ActiveFriend = 10
+	setDefaults(cfg, eventbus.NewBus())
+	if weights := cfg.P2pNode.ConnManagerWeights; weights != (ConnManagerWeightsConfig{ActiveFriend: 10}) {
+		t.Errorf("unexpected weights %+v", weights)
+	}
+}
+
+func TestConfig_BackupInterval(t *testing.T) {
+	cfg := new(Config)
+	cfg.Backup.IntervalHours = -1
+	setDefaults(cfg, eventbus.NewBus())
+	if cfg.Backup.IntervalHours != defaultBackupIntervalHours {
+		t.Errorf("invalid interval is kept: %d", cfg.Backup.IntervalHours)
+	}
+}
+
+func TestConfig_FileTransferMaxSize(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+	if cfg.FileTransfer.MaxSizeMB != defaultFileTransferMaxSizeMB {
+		t.Errorf("default max size is not set: %d", cfg.FileTransfer.MaxSizeMB)
+	}
+	cfg.FileTransfer.MaxSizeMB = 10
+	setDefaults(cfg, eventbus.NewBus())
+	if cfg.FileTransfer.MaxSizeMB != 10 {
+		t.Errorf("configured max size is replaced: %d", cfg.FileTransfer.MaxSizeMB)
+	}
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// ConfigPassphraseEnvKey provides passphrase of encrypted config, e.g. from secret manager of a system service.
+	// Passphrase is asked with PassphrasePrompt if it isn't set.
+	ConfigPassphraseEnvKey = "AWL_CONFIG_PASSPHRASE"
+
+	// EncryptionPassphrase encrypts config with a key derived from passphrase,
+	// EncryptionKeychain encrypts it with a random key which is stored by OS:
+	// Keychain on macOS, Secret Service (libsecret) on Linux and DPAPI on Windows.
+	EncryptionNone       = ""
+	EncryptionPassphrase = "passphrase"
+	EncryptionKeychain   = "keychain"
+
+	// MinConfigPassphraseLength is checked when encryption is enabled.
+	MinConfigPassphraseLength = 8
+
+	// encrypted config is the magic, mode byte, salt, nonce and sealed json, the header before nonce is authenticated
+	encryptedConfigMagic  = "awl-encrypted-config-v1\n"
+	encryptionSaltSize    = 16
+	encryptionKeySize     = 32
+	encryptionModePass    = 1
+	encryptionModeKeychan = 2
+)
+
+// ErrConfigLocked is returned by LoadConfig when config is encrypted and the key isn't available.
+// New config shouldn't be created in this case, since it overwrites the encrypted one.
+var ErrConfigLocked = errors.New("config is encrypted")
+
+// PassphrasePrompt asks passphrase of encrypted config if ConfigPassphraseEnvKey isn't set, e.g. in terminal.
+// It's nil for apps without terminal.
+var PassphrasePrompt func() (string, error)
+
+type configEncryption struct {
+	mode string
+	salt []byte
+	key  []byte
+}
+
+// SetEncryption encrypts config file at rest with the passphrase or a key stored by OS keychain,
+// EncryptionNone saves config as plain json again. Config is saved immediately.
+func (c *Config) SetEncryption(mode, passphrase string) error {
+	c.Lock()
+	defer c.Unlock()
+	account := c.path()
+
+	encryption := configEncryption{mode: mode}
+	switch mode {
+	case EncryptionNone:
+	case EncryptionPassphrase:
+		if len(passphrase) < MinConfigPassphraseLength {
+			return fmt.Errorf("passphrase should be at least %d characters", MinConfigPassphraseLength)
+		}
+		encryption.salt = make([]byte, encryptionSaltSize)
+		_, err := rand.Read(encryption.salt)
+		if err != nil {
+			return err
+		}
+		encryption.key = deriveConfigKey(passphrase, encryption.salt)
+	case EncryptionKeychain:
+		encryption.key = make([]byte, encryptionKeySize)
+		_, err := rand.Read(encryption.key)
+		if err != nil {
+			return err
+		}
+		err = keychainStore(account, encryption.key)
+		if err != nil {
+			return fmt.Errorf("store key in os keychain: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown encryption mode %q", mode)
+	}
+
+	previous := c.encryption
+	c.encryption = encryption
+	err := c.writeFile()
+	if err != nil {
+		c.encryption = previous
+		return err
+	}
+	if previous.mode == EncryptionKeychain && mode != EncryptionKeychain {
+		err = keychainDelete(account)
+		if err != nil {
+			logger.Warnf("delete config key from os keychain: %v", err)
+		}
+	}
+	return nil
+}
+
+// Encryption returns the mode of config encryption at rest.
+func (c *Config) Encryption() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.encryption.mode
+}
+
+func (e configEncryption) seal(plaintext []byte) ([]byte, error) {
+	header := e.header()
+	gcm, err := newConfigGCM(e.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+gcm.Overhead())
+	data = append(data, header...)
+	data = append(data, nonce...)
+	return gcm.Seal(data, nonce, plaintext, header), nil
+}
+
+func (e configEncryption) header() []byte {
+	header := append([]byte(encryptedConfigMagic), encryptionModePass)
+	if e.mode == EncryptionKeychain {
+		header[len(header)-1] = encryptionModeKeychan
+	}
+	salt := e.salt
+	if salt == nil {
+		salt = make([]byte, encryptionSaltSize)
+	}
+	return append(header, salt...)
+}
+
+func isEncryptedConfig(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedConfigMagic))
+}
+
+// openConfig decrypts config file, account is the config path which identifies the key in OS keychain.
+func openConfig(data []byte, account string) ([]byte, configEncryption, error) {
+	headerSize := len(encryptedConfigMagic) + 1 + encryptionSaltSize
+	if len(data) < headerSize {
+		return nil, configEncryption{}, errors.New("invalid encrypted config")
+	}
+	header, data := data[:headerSize], data[headerSize:]
+	encryption := configEncryption{salt: bytes.Clone(header[headerSize-encryptionSaltSize:])}
+
+	switch header[len(encryptedConfigMagic)] {
+	case encryptionModePass:
+		encryption.mode = EncryptionPassphrase
+		passphrase := os.Getenv(ConfigPassphraseEnvKey)
+		if passphrase == "" && PassphrasePrompt != nil {
+			var err error
+			passphrase, err = PassphrasePrompt()
+			if err != nil {
+				return nil, configEncryption{}, fmt.Errorf("%w: %v", ErrConfigLocked, err)
+			}
+		}
+		if passphrase == "" {
+			return nil, configEncryption{}, fmt.Errorf("%w: passphrase should be set in %s", ErrConfigLocked, ConfigPassphraseEnvKey)
+		}
+		encryption.key = deriveConfigKey(passphrase, encryption.salt)
+	case encryptionModeKeychan:
+		encryption.mode = EncryptionKeychain
+		encryption.salt = nil
+		key, err := keychainLoad(account)
+		if err != nil {
+			return nil, configEncryption{}, fmt.Errorf("%w: load key from os keychain: %v", ErrConfigLocked, err)
+		}
+		encryption.key = key
+	default:
+		return nil, configEncryption{}, errors.New("unknown config encryption mode")
+	}
+
+	plaintext, err := encryption.open(header, data)
+	if err != nil {
+		return nil, configEncryption{}, err
+	}
+	return plaintext, encryption, nil
+}
+
+// open decrypts data after the header with the key, which is already known.
+func (e configEncryption) open(header, data []byte) ([]byte, error) {
+	gcm, err := newConfigGCM(e.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted config")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid passphrase or key", ErrConfigLocked)
+	}
+	return plaintext, nil
+}
+
+// SealData encrypts other files with secrets, e.g. pending restore, with the key of config encryption at rest.
+// Data is returned as is if config isn't encrypted.
+func (c *Config) SealData(data []byte) ([]byte, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if c.encryption.mode == EncryptionNone {
+		return data, nil
+	}
+	return c.encryption.seal(data)
+}
+
+// OpenData decrypts data sealed with SealData. Plain data is returned as is, e.g. saved before encryption was enabled.
+func (c *Config) OpenData(data []byte) ([]byte, error) {
+	if !isEncryptedConfig(data) {
+		return data, nil
+	}
+	c.RLock()
+	encryption := c.encryption
+	c.RUnlock()
+	header := encryption.header()
+	if encryption.mode == EncryptionNone || !bytes.HasPrefix(data, header) {
+		return nil, fmt.Errorf("%w: data is encrypted with another key", ErrConfigLocked)
+	}
+	return encryption.open(header, data[len(header):])
+}
+
+func newConfigGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveConfigKey uses the same parameters as backups.
+func deriveConfigKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, encryptionKeySize)
+}
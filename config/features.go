@@ -0,0 +1,28 @@
+package config
+
+// Optional features are compiled in by default. They are excluded with build tags to get smaller binary
+// for embedded targets, e.g. go build -tags awl_nodns,awl_noui,awl_nofiletransfer,awl_nosocks5 ./cmd/awl
+const (
+	FeatureDNS          = "dns"
+	FeatureWebUI        = "web_ui"
+	FeatureFileTransfer = "file_transfer"
+	FeatureSOCKS5       = "socks5"
+)
+
+// Features returns optional features compiled into the binary.
+func Features() []string {
+	features := make([]string, 0, 4)
+	if DNSIncluded {
+		features = append(features, FeatureDNS)
+	}
+	if WebUIIncluded {
+		features = append(features, FeatureWebUI)
+	}
+	if FileTransferIncluded {
+		features = append(features, FeatureFileTransfer)
+	}
+	if SOCKS5Included {
+		features = append(features, FeatureSOCKS5)
+	}
+	return features
+}
@@ -0,0 +1,6 @@
+//go:build awl_nodns
+
+package config
+
+// DNSIncluded is false in builds with awl_nodns tag, peer names aren't resolved by awl dns server then.
+const DNSIncluded = false
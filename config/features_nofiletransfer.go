@@ -0,0 +1,6 @@
+//go:build awl_nofiletransfer
+
+package config
+
+// FileTransferIncluded is false in builds with awl_nofiletransfer tag.
+const FileTransferIncluded = false
@@ -0,0 +1,6 @@
+//go:build !awl_nosocks5
+
+package config
+
+// SOCKS5Included is false in builds with awl_nosocks5 tag.
+const SOCKS5Included = true
@@ -0,0 +1,6 @@
+//go:build !awl_noui
+
+package config
+
+// WebUIIncluded is false in builds with awl_noui tag, web ui isn't embedded then.
+const WebUIIncluded = true
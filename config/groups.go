@@ -0,0 +1,219 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/anywherelan/awl/awlevent"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MaxPeerGroupNameLength = 32
+	MaxPeerGroups          = 16
+)
+
+var peerGroupNameRegex = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// PeerGroup is the policy of known peers which have the group in KnownPeer.Groups.
+// Groups without policy are just tags, e.g. to filter peers list.
+type PeerGroup struct {
+	// ACL rules are checked after rules of the peer, in the order of the peer groups
+	ACL []ACLRule `json:"acl"`
+	// AllowUsingAsExitNode lets members use us as exit node and router, like KnownPeer.WeAllowUsingAsExitNode
+	AllowUsingAsExitNode bool `json:"allowUsingAsExitNode"`
+	// Shared publishes membership of the group signed by us to its members
+	Shared bool `json:"shared,omitempty"`
+	// Owner is the peer id which publishes membership of the group, members are replaced with the signed ones.
+	// It's empty for groups managed by us
+	Owner string `json:"owner,omitempty"`
+	// MembershipTime is unix milliseconds of the membership applied from Owner
+	MembershipTime int64 `json:"membershipTime,omitempty"`
+}
+
+// NormalizePeerGroup returns lowercase composed name of the group, e.g. "Home-Lab" is "home-lab".
+func NormalizePeerGroup(name string) (string, error) {
+	name = strings.ToLower(norm.NFC.String(strings.TrimSpace(name)))
+	if name == "" {
+		return "", errors.New("empty group name")
+	}
+	if len([]rune(name)) > MaxPeerGroupNameLength {
+		return "", fmt.Errorf("group name %q is longer than %d characters", name, MaxPeerGroupNameLength)
+	}
+	if !peerGroupNameRegex.MatchString(name) {
+		return "", fmt.Errorf("group name %q should contain only letters, digits, '-' and '_'", name)
+	}
+	return name, nil
+}
+
+// NormalizePeerGroups returns sorted unique normalized groups.
+func NormalizePeerGroups(groups []string) ([]string, error) {
+	result := make([]string, 0, len(groups))
+	for _, group := range groups {
+		name, err := NormalizePeerGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	result = slices.Compact(result)
+	if len(result) > MaxPeerGroups {
+		return nil, fmt.Errorf("too many groups, max %d", MaxPeerGroups)
+	}
+	return result, nil
+}
+
+// InGroup reports whether the peer has the group, the name should be normalized.
+func (kp KnownPeer) InGroup(group string) bool {
+	return slices.Contains(kp.Groups, group)
+}
+
+// EffectiveACL returns rules of the peer followed by rules of its groups.
+func (kp KnownPeer) EffectiveACL(groups map[string]PeerGroup) []ACLRule {
+	rules := kp.ACL
+	for _, name := range kp.Groups {
+		if group, exists := groups[name]; exists && len(group.ACL) > 0 {
+			rules = append(slices.Clip(rules), group.ACL...)
+		}
+	}
+	return rules
+}
+
+// ExitNodeAllowed reports whether we allow the peer to use us as exit node and router, by itself or by its groups.
+func (kp KnownPeer) ExitNodeAllowed(groups map[string]PeerGroup) bool {
+	if kp.WeAllowUsingAsExitNode {
+		return true
+	}
+	for _, name := range kp.Groups {
+		if groups[name].AllowUsingAsExitNode {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerExitNodeAllowed is KnownPeer.ExitNodeAllowed of the known peer, false for unknown peers.
+func (c *Config) PeerExitNodeAllowed(peerID string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	knownPeer, exists := c.KnownPeers[peerID]
+	return exists && knownPeer.ExitNodeAllowed(c.PeerGroups)
+}
+
+// GetPeerGroup returns policy of the group, false if the group has no policy.
+func (c *Config) GetPeerGroup(name string) (PeerGroup, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	group, exists := c.PeerGroups[name]
+	return group, exists
+}
+
+// PeerGroupNames returns sorted names of groups with policy and groups of known peers.
+func (c *Config) PeerGroupNames() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, 0, len(c.PeerGroups))
+	for name := range c.PeerGroups {
+		names = append(names, name)
+	}
+	for _, knownPeer := range c.KnownPeers {
+		names = append(names, knownPeer.Groups...)
+	}
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+// SetPeerGroup replaces policy of the group, the name should be normalized.
+func (c *Config) SetPeerGroup(name string, group PeerGroup) {
+	c.Lock()
+	if c.PeerGroups == nil {
+		c.PeerGroups = make(map[string]PeerGroup)
+	}
+	c.PeerGroups[name] = group
+	c.save()
+	c.Unlock()
+
+	_ = c.emitter.Emit(awlevent.KnownPeerChanged{})
+}
+
+// GroupMembers returns sorted ids of known peers in the group, the name should be normalized.
+func (c *Config) GroupMembers(name string) []string {
+	c.RLock()
+	defer c.RUnlock()
+	var members []string
+	for peerID, knownPeer := range c.KnownPeers {
+		if knownPeer.InGroup(name) {
+			members = append(members, peerID)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// ApplyGroupMembership replaces members of the group owned by the peer with members signed by it.
+// Members which aren't known peers are skipped. It returns false if the group isn't owned by the peer
+// or the membership isn't newer than the applied one.
+func (c *Config) ApplyGroupMembership(name, ownerPeerID string, members []string, time int64) bool {
+	c.Lock()
+	group, exists := c.PeerGroups[name]
+	if !exists || group.Owner == "" || group.Owner != ownerPeerID || time <= group.MembershipTime {
+		c.Unlock()
+		return false
+	}
+	group.MembershipTime = time
+	c.PeerGroups[name] = group
+	for peerID, knownPeer := range c.KnownPeers {
+		member := slices.Contains(members, peerID)
+		if member == knownPeer.InGroup(name) || (member && len(knownPeer.Groups) >= MaxPeerGroups) {
+			continue
+		}
+		if member {
+			knownPeer.Groups = append(slices.Clone(knownPeer.Groups), name)
+			sort.Strings(knownPeer.Groups)
+		} else {
+			knownPeer.Groups = slices.DeleteFunc(slices.Clone(knownPeer.Groups), func(group string) bool {
+				return group == name
+			})
+		}
+		c.KnownPeers[peerID] = knownPeer
+	}
+	c.save()
+	c.Unlock()
+
+	_ = c.emitter.Emit(awlevent.KnownPeerChanged{})
+	return true
+}
+
+// RemovePeerGroup removes policy of the group and the group from all known peers.
+// It returns peers which were in the group.
+func (c *Config) RemovePeerGroup(name string) []KnownPeer {
+	c.Lock()
+	_, exists := c.PeerGroups[name]
+	delete(c.PeerGroups, name)
+	var members []KnownPeer
+	for peerID, knownPeer := range c.KnownPeers {
+		if !knownPeer.InGroup(name) {
+			continue
+		}
+		knownPeer.Groups = slices.DeleteFunc(slices.Clone(knownPeer.Groups), func(group string) bool {
+			return group == name
+		})
+		c.KnownPeers[peerID] = knownPeer
+		members = append(members, knownPeer)
+	}
+	changed := exists || len(members) > 0
+	if changed {
+		c.save()
+	}
+	c.Unlock()
+
+	if changed {
+		_ = c.emitter.Emit(awlevent.KnownPeerChanged{})
+	}
+	return members
+}
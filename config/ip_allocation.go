@@ -0,0 +1,142 @@
+package config
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+)
+
+var ErrNoFreeIPAddr = errors.New("no free addresses in vpn network")
+
+// ipAllocator picks the address of a new peer from free addresses of the pool.
+type ipAllocator interface {
+	allocate(pool ipPool, peerID string) (uint32, bool)
+}
+
+// ipAllocators by VPNConfig.IPAllocation. Manual and negotiate strategies need addresses for peers added without them,
+// e.g. by invites, so they are sequential. Negotiated addresses are applied when peers send them in status info.
+var ipAllocators = map[string]ipAllocator{
+	IPAllocationSequential: sequentialAllocator{},
+	IPAllocationHash:       hashAllocator{},
+	IPAllocationManual:     sequentialAllocator{},
+	IPAllocationNegotiate:  sequentialAllocator{},
+}
+
+func IsValidIPAllocation(strategy string) bool {
+	_, ok := ipAllocators[strategy]
+	return ok
+}
+
+// AllocateIPAddr returns free address of the vpn network for the new peer by VPNConfig.IPAllocation,
+// requested address is used instead if it's not empty.
+// AllocateIPAddr is not thread safe.
+func (c *Config) AllocateIPAddr(peerID, requested string) (string, error) {
+	pool, ok := c.newIPPool(peerID)
+	if !ok {
+		return "", ErrNoFreeIPAddr
+	}
+	if requested != "" {
+		ip := net.ParseIP(requested).To4()
+		if ip == nil || !pool.isFree(binary.BigEndian.Uint32(ip)) {
+			return "", fmt.Errorf("address %s is not in vpn network %s or already taken", requested, c.VPNConfig.IPNet)
+		}
+		return ip.String(), nil
+	}
+
+	allocator, ok := ipAllocators[c.VPNConfig.IPAllocation]
+	if !ok {
+		allocator = sequentialAllocator{}
+	}
+	ip, ok := allocator.allocate(pool, peerID)
+	if !ok {
+		return "", ErrNoFreeIPAddr
+	}
+	return uint32ToIP(ip).String(), nil
+}
+
+// IsFreeIPAddr reports whether the address is in the vpn network and isn't used by us or other peers.
+// IsFreeIPAddr is not thread safe.
+func (c *Config) IsFreeIPAddr(peerID, ipAddr string) bool {
+	ip := net.ParseIP(ipAddr).To4()
+	pool, ok := c.newIPPool(peerID)
+	return ok && ip != nil && pool.isFree(binary.BigEndian.Uint32(ip))
+}
+
+// ipPool is the vpn network with addresses which are used by us and known peers.
+type ipPool struct {
+	network   uint32
+	broadcast uint32
+	local     uint32
+	taken     map[uint32]bool
+}
+
+func (c *Config) newIPPool(excludePeerID string) (ipPool, bool) {
+	localIP, netMask := c.VPNLocalIPMask()
+	if localIP == nil {
+		return ipPool{}, false
+	}
+	network := binary.BigEndian.Uint32(localIP.Mask(netMask))
+	pool := ipPool{
+		network:   network,
+		broadcast: network | ^binary.BigEndian.Uint32(net.IP(netMask).To4()),
+		local:     binary.BigEndian.Uint32(localIP),
+		taken:     make(map[uint32]bool, len(c.KnownPeers)+1),
+	}
+	pool.taken[pool.local] = true
+	for _, known := range c.KnownPeers {
+		if known.PeerID == excludePeerID {
+			continue
+		}
+		if ip := net.ParseIP(known.IPAddr).To4(); ip != nil {
+			pool.taken[binary.BigEndian.Uint32(ip)] = true
+		}
+	}
+	return pool, pool.broadcast-pool.network > 1
+}
+
+func (p ipPool) isFree(ip uint32) bool {
+	return ip > p.network && ip < p.broadcast && !p.taken[ip]
+}
+
+// next returns the first free address from host number start, it wraps around the network.
+func (p ipPool) next(start uint32) (uint32, bool) {
+	hosts := p.broadcast - p.network - 1
+	for i := uint32(0); i < hosts; i++ {
+		ip := p.network + 1 + (start+i)%hosts
+		if !p.taken[ip] {
+			return ip, true
+		}
+	}
+	return 0, false
+}
+
+// sequentialAllocator gives the address after the highest used one, freed addresses are reused
+// when the end of the network is reached.
+type sequentialAllocator struct{}
+
+func (sequentialAllocator) allocate(pool ipPool, _ string) (uint32, bool) {
+	highest := pool.local
+	for ip := range pool.taken {
+		if ip > highest && ip < pool.broadcast {
+			highest = ip
+		}
+	}
+	return pool.next(highest - pool.network)
+}
+
+// hashAllocator derives the address from the peer id, collisions are resolved with the next free address.
+type hashAllocator struct{}
+
+func (hashAllocator) allocate(pool ipPool, peerID string) (uint32, bool) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(peerID))
+	return pool.next(h.Sum32() % (pool.broadcast - pool.network - 1))
+}
+
+func uint32ToIP(i uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, i)
+	return ip
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+func TestConfig_AllocateIPAddr(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+	cfg.VPNConfig.IPNet = "10.66.0.1/29"
+	cfg.KnownPeers["peer1"] = KnownPeer{PeerID: "peer1", IPAddr: "10.66.0.6"}
+
+	// the end of the network is reached, freed addresses are reused
+	if got, err := cfg.AllocateIPAddr("peer2", ""); err != nil || got != "10.66.0.2" {
+		t.Errorf("AllocateIPAddr() = %v, %v", got, err)
+	}
+	if got, err := cfg.AllocateIPAddr("peer2", "10.66.0.4"); err != nil || got != "10.66.0.4" {
+		t.Errorf("AllocateIPAddr() requested = %v, %v", got, err)
+	}
+	for _, requested := range []string{"10.66.0.6", "10.66.0.1", "10.66.0.7", "10.66.0.0", "10.66.1.2", "invalid"} {
+		if _, err := cfg.AllocateIPAddr("peer2", requested); err == nil {
+			t.Errorf("AllocateIPAddr() requested %s is allowed", requested)
+		}
+	}
+	if !cfg.IsFreeIPAddr("peer1", "10.66.0.6") || cfg.IsFreeIPAddr("peer2", "10.66.0.6") {
+		t.Errorf("IsFreeIPAddr() doesn't exclude the peer itself")
+	}
+
+	for i, ip := range []string{"10.66.0.2", "10.66.0.3", "10.66.0.4", "10.66.0.5"} {
+		peerID := string(rune('a' + i))
+		cfg.KnownPeers[peerID] = KnownPeer{PeerID: peerID, IPAddr: ip}
+	}
+	if _, err := cfg.AllocateIPAddr("peer2", ""); !errors.Is(err, ErrNoFreeIPAddr) {
+		t.Errorf("AllocateIPAddr() in full network = %v", err)
+	}
+}
+
+func TestConfig_AllocateIPAddrHash(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+	cfg.VPNConfig.IPNet = "10.66.0.1/16"
+	cfg.VPNConfig.IPAllocation = IPAllocationHash
+
+	peerID := "12D3KooWJhHVNDU9tSxxQr3VEdqfjsnGXjGBNsyrrJ9gYhYDzP8j"
+	first, err := cfg.AllocateIPAddr(peerID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other nodes give the peer the same address
+	other := new(Config)
+	setDefaults(other, eventbus.NewBus())
+	other.VPNConfig = cfg.VPNConfig
+	other.KnownPeers["peer"] = KnownPeer{PeerID: "peer", IPAddr: "10.66.0.2"}
+	if got, _ := other.AllocateIPAddr(peerID, ""); got != first {
+		t.Errorf("AllocateIPAddr() = %v, want %v", got, first)
+	}
+
+	// collision is resolved with the next address
+	cfg.KnownPeers["peer"] = KnownPeer{PeerID: "peer", IPAddr: first}
+	if got, _ := cfg.AllocateIPAddr(peerID, ""); got == first || got == "" {
+		t.Errorf("AllocateIPAddr() with collision = %v", got)
+	}
+
+	if IsValidIPAllocation("random") || !IsValidIPAllocation(IPAllocationNegotiate) {
+		t.Errorf("IsValidIPAllocation() is wrong")
+	}
+}
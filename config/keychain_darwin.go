@@ -0,0 +1,46 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+const keychainService = "awl"
+
+// keychainStore passes the key on stdin to hide it from process list: security prompts for the password if -w is
+// the last argument without value. It reads the prompt from stdin only without controlling terminal, so it's
+// started in a new session.
+func keychainStore(account string, key []byte) error {
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", keychainService, "-a", account, "-w")
+	password := hex.EncodeToString(key)
+	// the password is asked twice to confirm it
+	cmd.Stdin = strings.NewReader(password + "\n" + password + "\n")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+func keychainLoad(account string) ([]byte, error) {
+	cmd := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(output)))
+}
+
+func keychainDelete(account string) error {
+	cmd := exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", account)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
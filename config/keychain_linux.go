@@ -0,0 +1,42 @@
+//go:build !android
+
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const keychainService = "awl"
+
+// keychain uses Secret Service with secret-tool from libsecret, the key is passed on stdin to hide it from process list
+func keychainStore(account string, key []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label=Anywherelan config key", "service", keychainService, "account", account)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+func keychainLoad(account string) ([]byte, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", keychainService, "account", account)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(output)))
+}
+
+func keychainDelete(account string) error {
+	cmd := exec.Command("secret-tool", "clear", "service", keychainService, "account", account)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !darwin && !windows && (!linux || android)
+
+package config
+
+import (
+	"errors"
+)
+
+var errKeychainUnsupported = errors.New("os keychain is not supported on this platform")
+
+func keychainStore(string, []byte) error {
+	return errKeychainUnsupported
+}
+
+func keychainLoad(string) ([]byte, error) {
+	return nil, errKeychainUnsupported
+}
+
+func keychainDelete(string) error {
+	return errKeychainUnsupported
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// keychain on windows keeps the key protected by DPAPI for the current user next to the config
+func keychainStore(account string, key []byte) error {
+	protected, err := dpapiCall(key, true)
+	if err != nil {
+		return err
+	}
+	path := keychainPath(account)
+	err = os.WriteFile(path, protected, filesPerm)
+	if err != nil {
+		return err
+	}
+	ChownFileIfNeeded(path)
+	return nil
+}
+
+func keychainLoad(account string) ([]byte, error) {
+	protected, err := os.ReadFile(keychainPath(account))
+	if err != nil {
+		return nil, err
+	}
+	return dpapiCall(protected, false)
+}
+
+func keychainDelete(account string) error {
+	return os.Remove(keychainPath(account))
+}
+
+func keychainPath(account string) string {
+	return account + ".key"
+}
+
+func dpapiCall(data []byte, protect bool) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, windows.ERROR_INVALID_DATA
+	}
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	var err error
+	if protect {
+		err = windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	} else {
+		err = windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	}()
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
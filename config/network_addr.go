@@ -7,42 +7,163 @@ import (
 
 const (
 	defaultInterfaceName = "awl0"
-	// TODO: generate subnets if this has already taken
 	defaultNetworkSubnet = "10.66.0.1/24"
+	defaultIPv6Prefix    = "fd61:776c:6c00::/96"
+	ipv6PrefixLen        = 96
 )
 
-// GenerateNextIpAddr is not thread safe.
-func (c *Config) GenerateNextIpAddr() string {
+// VPNLocalIPv6Mask returns our IPv6 address and mask, which covers the same peers as IPv4 network.
+func (c *Config) VPNLocalIPv6Mask() (net.IP, net.IPMask) {
 	localIP, netMask := c.VPNLocalIPMask()
-	ipNet := net.IPNet{
-		IP:   localIP.Mask(netMask),
-		Mask: netMask,
+	localIPv6 := c.IPv6FromIPv4(localIP)
+	if localIPv6 == nil {
+		return nil, nil
+	}
+	ones, _ := netMask.Size()
+	return localIPv6, net.CIDRMask(ipv6PrefixLen+ones, 8*net.IPv6len)
+}
+
+// IPv6FromIPv4 embeds IPv4 address of the peer into IPv6 prefix. Returns nil if ip or prefix is invalid.
+func (c *Config) IPv6FromIPv4(ip net.IP) net.IP {
+	prefix := parseIPv6Prefix(c.VPNConfig.IPv6Prefix)
+	ip = ip.To4()
+	if prefix == nil || ip == nil {
+		return nil
+	}
+	result := make(net.IP, net.IPv6len)
+	copy(result, prefix)
+	copy(result[net.IPv6len-net.IPv4len:], ip)
+	return result
+}
+
+func parseIPv6Prefix(prefix string) net.IP {
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil || ipNet.IP.To4() != nil {
+		return nil
+	}
+	if ones, _ := ipNet.Mask.Size(); ones != ipv6PrefixLen {
+		return nil
+	}
+	return ipNet.IP
+}
+
+// SuggestIpAddr maps host part of IPv4 address from another network into our vpn network,
+// e.g. 100.64.3.7 -> 10.66.0.7 for 10.66.0.1/24.
+// Returns empty string if resulting address is not usable or already taken.
+// SuggestIpAddr is not thread safe.
+func (c *Config) SuggestIpAddr(sourceIP string) string {
+	ip := net.ParseIP(sourceIP).To4()
+	localIP, netMask := c.VPNLocalIPMask()
+	if ip == nil || localIP == nil {
+		return ""
+	}
+
+	network := localIP.Mask(netMask)
+	newIp := make(net.IP, net.IPv4len)
+	for i := range newIp {
+		newIp[i] = network[i] | (ip[i] &^ netMask[i])
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = network[i] | ^netMask[i]
+	}
+	if newIp.Equal(network) || newIp.Equal(broadcast) || newIp.Equal(localIP) {
+		return ""
 	}
-	maxIp := localIP
 	for _, known := range c.KnownPeers {
-		ip := net.ParseIP(known.IPAddr)
-		if ip == nil {
-			continue
+		if net.ParseIP(known.IPAddr).Equal(newIp) {
+			return ""
 		}
-		ip = ip.To4()
+	}
 
-		if ipNet.Contains(ip) && binary.BigEndian.Uint32(ip) > binary.BigEndian.Uint32(maxIp) {
-			maxIp = ip
+	return newIp.String()
+}
+
+// FreeVPNNetwork returns the first network of 10.0.0.0/8 after the vpn network with the same size which doesn't overlap
+// taken networks, our advertised routes and routes of peers. Returns nil if there is no such network.
+// FreeVPNNetwork is not thread safe.
+func (c *Config) FreeVPNNetwork(taken []*net.IPNet) *net.IPNet {
+	localIP, netMask := c.VPNLocalIPMask()
+	ones, _ := netMask.Size()
+	if localIP == nil || ones <= 8 || ones > 30 {
+		return nil
+	}
+	for _, route := range c.VPNConfig.AdvertisedRoutes {
+		if _, network, err := net.ParseCIDR(route); err == nil {
+			taken = append(taken, network)
+		}
+	}
+	for _, known := range c.KnownPeers {
+		for _, route := range known.SubnetRoutes {
+			if _, network, err := net.ParseCIDR(route); err == nil {
+				taken = append(taken, network)
+			}
 		}
 	}
 
-	newIp := incrementIPAddr(maxIp)
-
-	return newIp.String()
+	const base = 10 << 24
+	size := uint32(1) << (32 - ones)
+	count := uint32(1) << (ones - 8)
+	current := (binary.BigEndian.Uint32(localIP.Mask(netMask)) - base) / size
+	if binary.BigEndian.Uint32(localIP)>>24 != 10 {
+		current = count - 1
+	}
+	for i := uint32(1); i <= count; i++ {
+		network := &net.IPNet{IP: uint32ToIP(base + (current+i)%count*size), Mask: netMask}
+		if !overlapsAnyNetwork(taken, network) {
+			return network
+		}
+	}
+	return nil
 }
 
-func incrementIPAddr(ip net.IP) net.IP {
-	i := binary.BigEndian.Uint32(ip)
-	i++
+// RenumberVPNNetwork moves the vpn network to network of the same size, host parts of our address and addresses
+// of known peers in the old network are kept, e.g. 10.66.0.7 -> 10.67.0.7 for 10.67.0.0/24.
+// RenumberVPNNetwork is not thread safe.
+func (c *Config) RenumberVPNNetwork(network *net.IPNet) {
+	localIP, netMask := c.VPNLocalIPMask()
+	newIP := network.IP.To4()
+	if localIP == nil || newIP == nil || netMask.String() != network.Mask.String() {
+		return
+	}
+	oldNetwork := &net.IPNet{IP: localIP.Mask(netMask), Mask: netMask}
+	renumber := func(ip net.IP) net.IP {
+		result := make(net.IP, net.IPv4len)
+		for i := range result {
+			result[i] = newIP[i] | (ip[i] &^ netMask[i])
+		}
+		return result
+	}
+
+	c.VPNConfig.IPNet = (&net.IPNet{IP: renumber(localIP), Mask: netMask}).String()
+	for peerID, known := range c.KnownPeers {
+		ip := net.ParseIP(known.IPAddr).To4()
+		if ip == nil || !oldNetwork.Contains(ip) {
+			continue
+		}
+		known.IPAddr = renumber(ip).String()
+		c.KnownPeers[peerID] = known
+	}
+}
 
-	bs := make([]byte, 4)
-	binary.BigEndian.PutUint32(bs, i)
+func overlapsAnyNetwork(networks []*net.IPNet, network *net.IPNet) bool {
+	for _, n := range networks {
+		if n.Contains(network.IP) || network.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
 
-	ipNew := net.IP(bs)
-	return ipNew
+func containsIP(networks []string, ip net.IP) (bool, error) {
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return false, err
+		}
+		if ipNet.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
 }
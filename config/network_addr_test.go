@@ -7,40 +7,108 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 )
 
-func TestIncrementIPAddr(t *testing.T) {
-	type args struct {
-		ip string
+func TestConfig_AllocateIPAddrSequential(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+
+	addr, err := cfg.AllocateIPAddr("peer", "")
+	if err != nil || addr != "10.66.0.2" {
+		t.Errorf("AllocateIPAddr() = %v, %v", addr, err)
 	}
+}
+
+func TestConfig_SuggestIpAddr(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+	cfg.KnownPeers["peer"] = KnownPeer{PeerID: "peer", IPAddr: "10.66.0.5"}
+
 	tests := []struct {
-		name string
-		args args
-		want string
+		sourceIP string
+		want     string
 	}{
-		{name: "", args: args{ip: "127.16.0.1"}, want: "127.16.0.2"},
-		{name: "", args: args{ip: "127.16.0.236"}, want: "127.16.0.237"},
-		{name: "", args: args{ip: "127.16.1.1"}, want: "127.16.1.2"},
-		{name: "", args: args{ip: "127.16.3.254"}, want: "127.16.3.255"},
-		{name: "", args: args{ip: "127.16.0.254"}, want: "127.16.0.255"},
-		{name: "", args: args{ip: "127.16.0.255"}, want: "127.16.1.0"},
-		{name: "", args: args{ip: "10.66.0.1"}, want: "10.66.0.2"},
+		{sourceIP: "100.64.3.7", want: "10.66.0.7"},
+		{sourceIP: "192.168.100.42", want: "10.66.0.42"},
+		{sourceIP: "10.147.17.5", want: ""},
+		{sourceIP: "172.16.0.1", want: ""},
+		{sourceIP: "172.16.0.0", want: ""},
+		{sourceIP: "172.16.0.255", want: ""},
+		{sourceIP: "fd7a:115c:a1e0::1", want: ""},
+		{sourceIP: "invalid", want: ""},
 	}
 	for _, tt := range tests {
-		ip := net.ParseIP(tt.args.ip)
-		ip = ip.To4()
-		t.Run(tt.name, func(t *testing.T) {
-			if got := incrementIPAddr(ip); got.String() != tt.want {
-				t.Errorf("IncrementIPAddr() = %v, want %v", got, tt.want)
+		t.Run(tt.sourceIP, func(t *testing.T) {
+			if got := cfg.SuggestIpAddr(tt.sourceIP); got != tt.want {
+				t.Errorf("SuggestIpAddr() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestConfig_GenerateNextIpAddr(t *testing.T) {
+func TestConfig_IPv6FromIPv4(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+
+	ip := cfg.IPv6FromIPv4(net.ParseIP("10.66.0.2"))
+	if ip.String() != "fd61:776c:6c00::a42:2" {
+		t.Errorf("IPv6FromIPv4() = %v", ip)
+	}
+
+	localIP, mask := cfg.VPNLocalIPv6Mask()
+	ones, bits := mask.Size()
+	if localIP.String() != "fd61:776c:6c00::a42:1" || ones != 120 || bits != 128 {
+		t.Errorf("VPNLocalIPv6Mask() = %v/%d", localIP, ones)
+	}
+
+	cfg.VPNConfig.IPv6Prefix = "fd00::/64"
+	if ip := cfg.IPv6FromIPv4(net.ParseIP("10.66.0.2")); ip != nil {
+		t.Errorf("IPv6FromIPv4() with invalid prefix = %v", ip)
+	}
+}
+
+func TestConfig_FreeVPNNetwork(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg, eventbus.NewBus())
+	cfg.VPNConfig.AdvertisedRoutes = []string{"10.68.2.0/24"}
+	cfg.KnownPeers["peer"] = KnownPeer{PeerID: "peer", SubnetRoutes: []string{"10.68.0.0/23"}}
+
+	_, local, _ := net.ParseCIDR("10.64.0.0/14")
+	network := cfg.FreeVPNNetwork([]*net.IPNet{local})
+	if network.String() != "10.68.3.0/24" {
+		t.Errorf("FreeVPNNetwork() = %v", network)
+	}
+
+	cfg.VPNConfig.IPNet = "192.168.1.1/24"
+	if network := cfg.FreeVPNNetwork(nil); network.String() != "10.0.0.0/24" {
+		t.Errorf("FreeVPNNetwork() outside 10.0.0.0/8 = %v", network)
+	}
+
+	cfg.VPNConfig.IPNet = "10.0.0.1/8"
+	if network := cfg.FreeVPNNetwork(nil); network != nil {
+		t.Errorf("FreeVPNNetwork() of 10.0.0.0/8 = %v", network)
+	}
+}
+
+func TestConfig_RenumberVPNNetwork(t *testing.T) {
 	cfg := new(Config)
 	setDefaults(cfg, eventbus.NewBus())
+	cfg.KnownPeers["peer"] = KnownPeer{PeerID: "peer", IPAddr: "10.66.0.7"}
+	cfg.KnownPeers["other"] = KnownPeer{PeerID: "other", IPAddr: "192.168.1.7"}
+
+	_, network, _ := net.ParseCIDR("10.70.3.0/24")
+	cfg.RenumberVPNNetwork(network)
+	if cfg.VPNConfig.IPNet != "10.70.3.1/24" {
+		t.Errorf("IPNet = %v", cfg.VPNConfig.IPNet)
+	}
+	if ip := cfg.KnownPeers["peer"].IPAddr; ip != "10.70.3.7" {
+		t.Errorf("address of peer = %v", ip)
+	}
+	if ip := cfg.KnownPeers["other"].IPAddr; ip != "192.168.1.7" {
+		t.Errorf("address outside the network = %v", ip)
+	}
 
-	addr := cfg.GenerateNextIpAddr()
-	if addr != "10.66.0.2" {
-		t.Fail()
+	_, network, _ = net.ParseCIDR("10.80.0.0/16")
+	cfg.RenumberVPNNetwork(network)
+	if cfg.VPNConfig.IPNet != "10.70.3.1/24" {
+		t.Errorf("IPNet after network of another size = %v", cfg.VPNConfig.IPNet)
 	}
 }
@@ -13,7 +13,6 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/multiformats/go-multiaddr"
 
-	"github.com/anywherelan/awl/awldns"
 	"github.com/anywherelan/awl/awlevent"
 )
 
@@ -101,26 +100,78 @@ func LoadConfig(bus awlevent.Bus) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	var encryption configEncryption
+	if isEncryptedConfig(data) {
+		data, encryption, err = openConfig(data, configPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parseConfig(data, dataDir, encryption, bus)
+}
+
+// Reload reads config file again, e.g. after Import. Encrypted config is opened with the known key.
+// Config shouldn't be used after it, since saving it would overwrite the file.
+func (c *Config) Reload(bus awlevent.Bus) (*Config, error) {
+	data, err := os.ReadFile(c.path())
+	if err != nil {
+		return nil, err
+	}
+	data, err = c.OpenData(data)
+	if err != nil {
+		return nil, err
+	}
+	c.RLock()
+	encryption := c.encryption
+	c.RUnlock()
+	return parseConfig(data, c.dataDir, encryption, bus)
+}
+
+func parseConfig(data []byte, dataDir string, encryption configEncryption, bus awlevent.Bus) (*Config, error) {
 	// TODO: config migration
 	conf := new(Config)
-	err = json.Unmarshal(data, conf)
+	err := json.Unmarshal(data, conf)
 	if err != nil {
 		return nil, err
 	}
 	conf.dataDir = dataDir
+	conf.encryption = encryption
 	setDefaults(conf, bus)
 	return conf, nil
 }
 
+// ImportConfig replaces config file in the directory, encryption at rest of the existing config is kept,
+// so its key should be available, see LoadConfig.
 func ImportConfig(data []byte, directory string) error {
+	path := filepath.Join(directory, AppConfigFilename)
+	var encryption configEncryption
+	existing, err := os.ReadFile(path)
+	if err == nil && isEncryptedConfig(existing) {
+		_, encryption, err = openConfig(existing, path)
+		if err != nil {
+			return err
+		}
+	}
+	return importConfig(data, path, encryption)
+}
+
+// Import replaces config file with imported data encrypted by the key of current config, if encryption is enabled.
+// The app should be restarted or config reloaded with Reload.
+func (c *Config) Import(data []byte) error {
+	c.RLock()
+	encryption := c.encryption
+	c.RUnlock()
+	return importConfig(data, c.path(), encryption)
+}
+
+func importConfig(data []byte, path string, encryption configEncryption) error {
 	conf := new(Config)
 	err := json.Unmarshal(data, conf)
 	if err != nil {
 		return fmt.Errorf("invalid format: %v", err)
 	}
 
-	path := filepath.Join(directory, AppConfigFilename)
-	err = os.WriteFile(path, data, filesPerm)
+	err = writeConfigFile(path, data, encryption)
 	if err != nil {
 		return fmt.Errorf("save file: %v", err)
 	}
@@ -141,11 +192,45 @@ func setDefaults(conf *Config, bus awlevent.Bus) {
 	if conf.P2pNode.BootstrapPeers == nil {
 		conf.P2pNode.BootstrapPeers = make([]string, 0)
 	}
+	// weights are used as is if any of them is set, so zero weights are allowed
+	if conf.P2pNode.ConnManagerWeights == (ConnManagerWeightsConfig{}) {
+		conf.P2pNode.ConnManagerWeights = ConnManagerWeightsConfig{
+			ActiveFriend:      defaultActiveFriendConnWeight,
+			IdleFriend:        defaultIdleFriendConnWeight,
+			ActiveTrafficRate: defaultActiveTrafficRate,
+		}
+	}
+	if conf.P2pNode.HTTPSRelay.FallbackRelays == nil {
+		conf.P2pNode.HTTPSRelay.FallbackRelays = make([]string, 0)
+	}
+	if conf.P2pNode.HTTPSRelay.ServerListenAddress == "" {
+		conf.P2pNode.HTTPSRelay.ServerListenAddress = defaultHTTPSRelayListenAddress
+	}
+	if conf.P2pNode.Transports.WebSocketListenAddresses == nil {
+		// secure websocket is on 443 port of HTTPSRelay server, so plain one is on the other web port
+		conf.P2pNode.Transports.WebSocketListenAddresses = []string{"/ip4/0.0.0.0/tcp/80/ws", "/ip6/::/tcp/80/ws"}
+	}
 	if conf.P2pNode.ReconnectionIntervalSec == 0 {
 		conf.P2pNode.ReconnectionIntervalSec = 10
 	}
 
+	// Backup
+	if conf.Backup.Peers == nil {
+		conf.Backup.Peers = make([]string, 0)
+	}
+	if conf.Backup.IntervalHours <= 0 {
+		conf.Backup.IntervalHours = defaultBackupIntervalHours
+	}
+
+	// File transfer
+	if conf.FileTransfer.MaxSizeMB <= 0 {
+		conf.FileTransfer.MaxSizeMB = defaultFileTransferMaxSizeMB
+	}
+
 	// Other
+	if conf.LogFile.MaxSizeMB <= 0 {
+		conf.LogFile.MaxSizeMB = defaultLogFileMaxSizeMB
+	}
 	if conf.LoggerLevel == "" {
 		conf.LoggerLevel = "info"
 	}
@@ -158,12 +243,33 @@ func setDefaults(conf *Config, bus awlevent.Bus) {
 	// TODO: remove in next release
 	conf.HttpListenOnAdminHost = true
 
+	if conf.Clock.NTPServer == "" {
+		conf.Clock.NTPServer = defaultNTPServer
+	}
+	if conf.Timeouts.PeerRequestSec <= 0 {
+		conf.Timeouts.PeerRequestSec = defaultPeerRequestTimeoutSec
+	}
+	if conf.Timeouts.ProxyDialSec <= 0 {
+		conf.Timeouts.ProxyDialSec = defaultProxyDialTimeoutSec
+	}
+	switch conf.Power.Mode {
+	case PowerModeAuto, PowerModeAC, PowerModeBattery:
+	default:
+		conf.Power.Mode = PowerModeAuto
+	}
+	if !IsValidIPAllocation(conf.VPNConfig.IPAllocation) {
+		conf.VPNConfig.IPAllocation = IPAllocationSequential
+	}
+
 	if conf.VPNConfig.IPNet == "" {
 		conf.VPNConfig.IPNet = defaultNetworkSubnet
 	}
 	if ip, _ := conf.VPNLocalIPMask(); ip == nil {
 		conf.VPNConfig.IPNet = defaultNetworkSubnet
 	}
+	if parseIPv6Prefix(conf.VPNConfig.IPv6Prefix) == nil {
+		conf.VPNConfig.IPv6Prefix = defaultIPv6Prefix
+	}
 	if conf.VPNConfig.InterfaceName == "" {
 		if runtime.GOOS == "darwin" {
 			conf.VPNConfig.InterfaceName = "utun"
@@ -180,14 +286,18 @@ func setDefaults(conf *Config, bus awlevent.Bus) {
 		peer := conf.KnownPeers[peerID]
 		newAlias := conf.genUniqPeerAlias(peer.Name, peer.Alias, uniqAliases)
 		if newAlias != peer.Alias {
-			logger.Warnf("incorrect config: peer (id: %s) alias %s is not unique, updated automaticaly to %s", peerID, peer.Alias, newAlias)
+			logger.Warnf("incorrect config: peer (id: %s) alias %s is not unique or normalized, updated automaticaly to %s", peerID, peer.Alias, newAlias)
 			peer.Alias = newAlias
 		}
 		if peer.IPAddr == "" {
-			peer.IPAddr = conf.GenerateNextIpAddr()
+			ipAddr, err := conf.AllocateIPAddr(peerID, "")
+			if err != nil {
+				logger.Warnf("incorrect config: peer (id: %s) has no address: %v", peerID, err)
+			}
+			peer.IPAddr = ipAddr
 		}
 		if peer.DomainName == "" {
-			peer.DomainName = awldns.TrimDomainName(peer.DisplayName())
+			peer.DomainName = conf.genUniqDomainName(peerID, peer.DisplayName())
 		}
 		conf.KnownPeers[peerID] = peer
 	}
@@ -214,6 +324,11 @@ func setDefaults(conf *Config, bus awlevent.Bus) {
 		panic(err)
 	}
 	conf.emitter = emitter
+	configEmitter, err := bus.Emitter(new(awlevent.ConfigChanged))
+	if err != nil {
+		panic(err)
+	}
+	conf.configEmitter = configEmitter
 
 	if u := conf.Update.UpdateServerURL; u == "" || u == "http://example/example.json" {
 		conf.Update.UpdateServerURL = "https://build.anywherelan.com/repository/releases.json"
@@ -2,6 +2,7 @@ package config
 
 import (
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -40,3 +41,51 @@ func SystemInfoFromUserAgent(userAgent string) (goos string, goarch string) {
 	goos, goarch, _ = strings.Cut(systemInfo, "-")
 	return
 }
+
+// CompareVersions compares awl versions like v0.10.0, v0.10.0-rc1 or dev by their numeric part.
+// Dev version is considered newer than any other version.
+// Result is -1 if a < b, 0 if a == b and 1 if a > b. ok is false if any version could not be parsed.
+func CompareVersions(a, b string) (result int, ok bool) {
+	if a == DevVersion || b == DevVersion {
+		switch {
+		case a == b:
+			return 0, true
+		case a == DevVersion:
+			return 1, true
+		default:
+			return -1, true
+		}
+	}
+
+	partsA, okA := parseVersion(a)
+	partsB, okB := parseVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range partsA {
+		if partsA[i] < partsB[i] {
+			return -1, true
+		} else if partsA[i] > partsB[i] {
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+func parseVersion(version string) ([3]int, bool) {
+	var parts [3]int
+	version = strings.TrimPrefix(version, "v")
+	version, _, _ = strings.Cut(version, "-")
+	fields := strings.Split(version, ".")
+	if len(fields) != len(parts) {
+		return parts, false
+	}
+	for i, field := range fields {
+		num, err := strconv.Atoi(field)
+		if err != nil || num < 0 {
+			return parts, false
+		}
+		parts[i] = num
+	}
+	return parts, true
+}
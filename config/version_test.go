@@ -112,3 +112,30 @@ func TestSystemInfoFromUserAgent(t *testing.T) {
 		})
 	}
 }
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b       string
+		wantResult int
+		wantOk     bool
+	}{
+		{a: "v0.10.0", b: "v0.10.0", wantResult: 0, wantOk: true},
+		{a: "v0.9.1", b: "v0.10.0", wantResult: -1, wantOk: true},
+		{a: "v1.0.0", b: "v0.10.5", wantResult: 1, wantOk: true},
+		{a: "v0.10.0-rc1", b: "v0.10.0", wantResult: 0, wantOk: true},
+		{a: "v0.10.0-3-gabcdef12", b: "v0.9.0", wantResult: 1, wantOk: true},
+		{a: "dev", b: "v0.10.0", wantResult: 1, wantOk: true},
+		{a: "v0.10.0", b: "dev", wantResult: -1, wantOk: true},
+		{a: "dev", b: "dev", wantResult: 0, wantOk: true},
+		{a: "", b: "v0.10.0", wantResult: 0, wantOk: false},
+		{a: "v0.10", b: "v0.10.0", wantResult: 0, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			result, ok := CompareVersions(tt.a, tt.b)
+			if result != tt.wantResult || ok != tt.wantOk {
+				t.Errorf("CompareVersions() = %v, %v, want %v, %v", result, ok, tt.wantResult, tt.wantOk)
+			}
+		})
+	}
+}
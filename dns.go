@@ -0,0 +1,228 @@
+//go:build !awl_nodns
+
+package awl
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/anywherelan/awl/awldns"
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/service"
+	"github.com/anywherelan/ts-dns/net/dns"
+	"github.com/anywherelan/ts-dns/util/dnsname"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// dnsComponents are fields of Application which exist only in builds with dns, see config.DNSIncluded.
+type dnsComponents struct {
+	DNSForwarder *service.DNSForwarder
+	Dns          *DNSService
+}
+
+func (a *Application) initDNS() {
+	a.DNSForwarder = service.NewDNSForwarder(a.P2p, a.Conf)
+	a.Dns = NewDNSService(a.Conf, a.Eventbus, a.DNSForwarder, a.ctx, a.logger)
+}
+
+func (a *Application) handleDNSStreams() {
+	a.Streams.Handle(protocol.DNSMethod, a.DNSForwarder.StreamHandler, service.StreamHandlerOptions{
+		Allow:   a.DNSForwarder.AllowPeer,
+		Timeout: service.DNSStreamTimeout,
+	})
+}
+
+// startDNS starts awl dns server and sets it as system resolver for awl names.
+func (a *Application) startDNS() {
+	interfaceName, err := a.vpnDevice.InterfaceName()
+	if err != nil {
+		a.logger.Errorf("failed to get TUN interface name: %v", err)
+		return
+	}
+	a.Dns.start(interfaceName)
+}
+
+func (a *Application) watchDNS() {
+	if a.Dns.dnsResolver == nil {
+		return
+	}
+	a.Watchdog.Watch("dns server", a.Dns.dnsResolver.Failed, func() error {
+		a.Dns.dnsResolver.Restart()
+		return nil
+	})
+}
+
+type DNSService struct {
+	conf      *config.Config
+	eventbus  awlevent.Bus
+	forwarder *service.DNSForwarder
+	ctx       context.Context
+	logger    *log.ZapEventLogger
+
+	dnsOsConfigurator   dns.OSConfigurator
+	osConfig            dns.OSConfig
+	dnsResolver         *awldns.Resolver
+	upstreamDNS         string
+	isAwlDNSSetAsSystem bool
+
+	// upstreamPeerLock guards upstreamPeerID, it's the peer which resolves queries of dnsResolver
+	upstreamPeerLock sync.Mutex
+	upstreamPeerID   string
+}
+
+func NewDNSService(conf *config.Config, eventbus awlevent.Bus, forwarder *service.DNSForwarder, ctx context.Context, logger *log.ZapEventLogger) *DNSService {
+	return &DNSService{conf: conf, eventbus: eventbus, forwarder: forwarder, ctx: ctx, logger: logger}
+}
+
+func (a *DNSService) start(interfaceName string) {
+	var err error
+	a.dnsResolver = awldns.NewResolver(awldns.DNSAddress)
+	a.upstreamDNS = awldns.DefaultUpstreamDNSAddress
+	a.refreshDNSConfig()
+
+	awlevent.WrapSubscriptionToCallback(a.ctx, func(_ interface{}) {
+		a.refreshDNSConfig()
+	}, a.eventbus, new(awlevent.KnownPeerChanged))
+	defer a.refreshDNSConfig()
+
+	a.conf.RLock()
+	disableSystemResolver := a.conf.DNS.DisableSystemResolver
+	a.conf.RUnlock()
+	if disableSystemResolver {
+		a.logger.Infof("system resolver is disabled in config, awl dns is available at %s", a.dnsResolver.DNSAddress())
+		return
+	}
+
+	tsLogger := log.Logger("ts/dnsconf")
+	a.dnsOsConfigurator, err = dns.NewOSConfigurator(func(format string, args ...interface{}) {
+		tsLogger.Infof(format, args...)
+	}, interfaceName)
+	if err != nil {
+		a.logger.Errorf("create dns os configurator: %v", err)
+		return
+	}
+
+	fqdn, err := dnsname.ToFQDN(awldns.LocalDomain)
+	if err != nil {
+		panic(err)
+	}
+	newOSConfig := dns.OSConfig{
+		Nameservers:  []netip.Addr{netip.MustParseAddr(awldns.DNSIp)},
+		MatchDomains: []dnsname.FQDN{fqdn},
+	}
+
+	if !a.dnsOsConfigurator.SupportsSplitDNS() {
+		newOSConfig.MatchDomains = nil
+		baseOSConfig, err := a.dnsOsConfigurator.GetBaseConfig()
+		if err != nil {
+			a.logger.Errorf("get base config from os configurator, abort setting os dns: %v", err)
+			return
+		}
+
+		a.logger.Infof("os does not support split dns. base config: %v", baseOSConfig)
+		if len(baseOSConfig.Nameservers) == 0 {
+			a.logger.Errorf("got zero nameservers from os configurator, use %s as default", awldns.DefaultUpstreamDNSAddress)
+			a.upstreamDNS = awldns.DefaultUpstreamDNSAddress
+		} else {
+			// TODO: use all nameservers in awldns resolver proxy
+			a.upstreamDNS = net.JoinHostPort(baseOSConfig.Nameservers[0].String(), awldns.DefaultDNSPort)
+		}
+	}
+
+	err = a.dnsOsConfigurator.SetDNS(newOSConfig)
+	if err != nil {
+		a.logger.Errorf("set dns config to os configurator: %v", err)
+	} else {
+		a.logger.Info("successfully set dns config to os")
+		a.osConfig = newOSConfig
+		a.isAwlDNSSetAsSystem = true
+	}
+}
+
+// reapplyOSConfig sets dns config to os again, e.g. after TUN interface was recreated and lost its settings.
+func (a *DNSService) reapplyOSConfig() {
+	if !a.isAwlDNSSetAsSystem {
+		return
+	}
+	err := a.dnsOsConfigurator.SetDNS(a.osConfig)
+	if err != nil {
+		a.logger.Errorf("set dns config to os configurator: %v", err)
+	}
+}
+
+func (a *DNSService) refreshDNSConfig() {
+	if a.dnsResolver == nil {
+		a.logger.DPanicf("called refreshDNSConfig with nil resolver %v", a.dnsResolver)
+		return
+	}
+	dnsNamesMapping := a.conf.DNSNamesMapping()
+	dnsNamesMapping[config.AdminHttpServerDomainName] = config.AdminHttpServerIP
+	upstreamDNS := a.upstreamDNS
+	if address := a.conf.DNSUpstreamAddress(); address != "" {
+		upstreamDNS = address
+	}
+	a.dnsResolver.ReceiveConfiguration(upstreamDNS, dnsNamesMapping)
+	a.forwarder.SetUpstreamDNS(a.upstreamDNS)
+	a.refreshUpstreamPeer()
+}
+
+// refreshUpstreamPeer makes queries resolved by config.DNSConfig.UpstreamPeerID, the resolver cache is reset
+// only when the peer is changed.
+func (a *DNSService) refreshUpstreamPeer() {
+	upstreamPeerID := a.conf.DNSUpstreamPeer()
+	a.upstreamPeerLock.Lock()
+	defer a.upstreamPeerLock.Unlock()
+	if upstreamPeerID == a.upstreamPeerID {
+		return
+	}
+	a.upstreamPeerID = upstreamPeerID
+
+	if upstreamPeerID == "" {
+		a.dnsResolver.SetUpstreamExchange(nil)
+		a.logger.Info("dns queries are resolved by upstream dns")
+		return
+	}
+	peerID, err := peer.Decode(upstreamPeerID)
+	if err != nil {
+		a.logger.Errorf("invalid dns upstream peer %s: %v", upstreamPeerID, err)
+		a.dnsResolver.SetUpstreamExchange(nil)
+		return
+	}
+	a.dnsResolver.SetUpstreamExchange(a.forwarder.UpstreamExchange(peerID))
+	a.logger.Infof("dns queries are resolved by peer %s", upstreamPeerID)
+}
+
+// RefreshConfig applies dns settings after they are changed in config.
+func (a *DNSService) RefreshConfig() {
+	if a.dnsResolver != nil {
+		a.refreshDNSConfig()
+	}
+}
+
+func (a *DNSService) Close() {
+	if a.dnsOsConfigurator != nil {
+		err := a.dnsOsConfigurator.Close()
+		if err != nil {
+			a.logger.Errorf("closing dns configurator: %v", err)
+		}
+	}
+	if a.dnsResolver != nil {
+		a.dnsResolver.Close()
+	}
+}
+
+func (a *DNSService) AwlDNSAddress() string {
+	if a.dnsResolver != nil {
+		return a.dnsResolver.DNSAddress()
+	}
+	return ""
+}
+
+func (a *DNSService) IsAwlDNSSetAsSystem() bool {
+	return a.isAwlDNSSetAsSystem
+}
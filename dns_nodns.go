@@ -0,0 +1,35 @@
+//go:build awl_nodns
+
+package awl
+
+// dnsComponents are fields of Application which exist only in builds with dns, see config.DNSIncluded.
+type dnsComponents struct {
+	Dns *DNSService
+}
+
+// DNSService does nothing in builds without dns.
+type DNSService struct{}
+
+func (a *Application) initDNS() {
+	a.Dns = &DNSService{}
+}
+
+func (a *Application) handleDNSStreams() {}
+
+func (a *Application) startDNS() {}
+
+func (a *Application) watchDNS() {}
+
+func (a *DNSService) reapplyOSConfig() {}
+
+func (a *DNSService) RefreshConfig() {}
+
+func (a *DNSService) Close() {}
+
+func (a *DNSService) AwlDNSAddress() string {
+	return ""
+}
+
+func (a *DNSService) IsAwlDNSSetAsSystem() bool {
+	return false
+}
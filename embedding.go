@@ -0,0 +1,125 @@
+package awl
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/entity"
+)
+
+// Methods of this file are the api for frontends which embed the application, e.g. awl-tray.
+// They don't require the web api and are safe to call concurrently after Init.
+
+var ErrNotStarted = errors.New("application is not started")
+
+// Status is a snapshot of the application state, see Application.Status.
+type Status struct {
+	PeerID       string
+	Name         string
+	Reachability string
+	// VPNUp is false while the vpn interface is down or paused
+	VPNUp     bool
+	VPNPaused bool
+	Peers     []PeerStatus
+	// AuthRequests are incoming friend requests waiting for AcceptPeer or DeclinePeer
+	AuthRequests []entity.AuthRequest
+}
+
+type PeerStatus struct {
+	PeerID      string
+	DisplayName string
+	IPAddr      string
+	Connected   bool
+	// Confirmed is false until the peer accepts our friend request
+	Confirmed bool
+}
+
+// Status returns the snapshot of our peer, known peers sorted by display name and incoming friend requests.
+func (a *Application) Status() Status {
+	a.Conf.RLock()
+	status := Status{
+		PeerID:       a.Conf.P2pNode.PeerID,
+		Name:         a.Conf.P2pNode.Name,
+		Reachability: a.P2p.Reachability().String(),
+		VPNUp:        a.vpnDevice.IsUp(),
+		VPNPaused:    a.vpnDevice.IsPaused(),
+		Peers:        make([]PeerStatus, 0, len(a.Conf.KnownPeers)),
+	}
+	for _, knownPeer := range a.Conf.KnownPeers {
+		displayName := knownPeer.DisplayName()
+		if displayName == "" {
+			displayName = knownPeer.PeerID
+		}
+		status.Peers = append(status.Peers, PeerStatus{
+			PeerID:      knownPeer.PeerID,
+			DisplayName: displayName,
+			IPAddr:      knownPeer.IPAddr,
+			Connected:   a.P2p.IsConnected(knownPeer.PeerId()),
+			Confirmed:   knownPeer.Confirmed,
+		})
+	}
+	a.Conf.RUnlock()
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].DisplayName < status.Peers[j].DisplayName
+	})
+
+	for peerID, authPeer := range a.AuthStatus.GetIngoingAuthRequests() {
+		status.AuthRequests = append(status.AuthRequests, entity.AuthRequest{AuthPeer: authPeer, PeerID: peerID})
+	}
+	sort.Slice(status.AuthRequests, func(i, j int) bool {
+		return status.AuthRequests[i].PeerID < status.AuthRequests[j].PeerID
+	})
+
+	return status
+}
+
+// SubscribeEvents calls callback for every event of awlevent package until ctx or the application is done.
+// Use awlevent.Name to get the type of the event, it's the same as in /events of the web api.
+func (a *Application) SubscribeEvents(ctx context.Context, callback func(evt interface{})) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-ctx.Done():
+		case <-a.ctx.Done():
+		}
+	}()
+	awlevent.Tap(ctx, callback, a.Eventbus)
+}
+
+// AcceptPeer accepts the incoming friend request, the name of the peer is used if alias is empty.
+func (a *Application) AcceptPeer(peerID, alias string) error {
+	if a.Api == nil {
+		return ErrNotStarted
+	}
+	if alias == "" {
+		alias = a.AuthStatus.GetIngoingAuthRequests()[peerID].Name
+	}
+	return a.Api.ReplyFriendRequest(entity.FriendRequestReply{PeerID: peerID, Alias: alias})
+}
+
+// DeclinePeer declines the incoming friend request and blocks the peer.
+func (a *Application) DeclinePeer(peerID string) error {
+	if a.Api == nil {
+		return ErrNotStarted
+	}
+	return a.Api.ReplyFriendRequest(entity.FriendRequestReply{PeerID: peerID, Decline: true})
+}
+
+// PauseVPN brings the vpn interface down until ResumeVPN, connections to peers are kept.
+// awlevent.VPNInterfaceStateChanged is emitted on change.
+func (a *Application) PauseVPN() error {
+	if a.Tunnel == nil {
+		return ErrNotStarted
+	}
+	return a.Tunnel.SetPaused(true)
+}
+
+func (a *Application) ResumeVPN() error {
+	if a.Tunnel == nil {
+		return ErrNotStarted
+	}
+	return a.Tunnel.SetPaused(false)
+}
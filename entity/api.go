@@ -1,10 +1,16 @@
 package entity
 
 import (
+	"encoding/json"
 	"time"
 
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/logview"
 	"github.com/anywherelan/awl/p2p"
 	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/service"
+	"github.com/anywherelan/awl/vpn"
 	kbucket "github.com/libp2p/go-libp2p-kbucket"
 	"github.com/libp2p/go-libp2p/core/metrics"
 )
@@ -15,14 +21,66 @@ type (
 		StartFromHead bool `url:"from_head" query:"from_head"`
 		LogsRows      int  `url:"logs" query:"logs" validate:"numeric,gte=0"`
 	}
+	// LogQuery selects log entries. Time range is inclusive, Level is the minimal level, Logger is logger name prefix.
+	LogQuery struct {
+		From   time.Time `url:"from,omitempty" query:"from"`
+		To     time.Time `url:"to,omitempty" query:"to"`
+		Level  string    `url:"level,omitempty" query:"level"`
+		Logger string    `url:"logger,omitempty" query:"logger"`
+	}
+	LogEntriesRequest struct {
+		LogQuery
+		// At moves Offset to the first entry written at or after this time
+		At     time.Time `url:"at,omitempty" query:"at"`
+		Offset int       `url:"offset,omitempty" query:"offset" validate:"gte=0"`
+		Limit  int       `url:"limit,omitempty" query:"limit" validate:"gte=0,lte=1000"`
+	}
+	PeerLatencyRequest struct {
+		PeerID string `url:"peer_id" query:"peer_id" validate:"required"`
+	}
+	ExportUsageRequest struct {
+		// Type is traffic for hourly traffic by peer or connections for connection sessions
+		Type string    `url:"type" query:"type" validate:"required,oneof=traffic connections"`
+		From time.Time `url:"from,omitempty" query:"from"`
+		To   time.Time `url:"to,omitempty" query:"to"`
+	}
+	KnownPeersRequest struct {
+		// Group filters peers of the group, all peers by default
+		Group string `url:"group,omitempty" query:"group"`
+	}
+	SearchRequest struct {
+		// Query is case-insensitive, results contain all of its space separated words
+		Query string `url:"q" query:"q" validate:"required"`
+		// Limit of events, default 100, max 1000
+		Limit int `url:"limit,omitempty" query:"limit" validate:"gte=0,lte=1000"`
+	}
+	EventsRequest struct {
+		// Types of events to receive, e.g. PeerConnected, all events by default
+		Types []string `url:"types,omitempty" query:"types"`
+	}
 	FriendRequest struct {
 		PeerID string `validate:"required"`
 		Alias  string `validate:"required,trimmed_str_not_empty"`
+		// IPAddr of the peer in our vpn network, empty to allocate it by config.VPNConfig.IPAllocation
+		IPAddr string `validate:"omitempty,ipv4"`
+	}
+	CreateInviteRequest struct {
+		// Alias is given to the peer which uses the invite, empty alias is generated from the peer name
+		Alias string
+		// ExpiresInHours is lifetime of the invite, 24 hours by default
+		ExpiresInHours int `validate:"gte=0,lte=720"`
+	}
+	AcceptInviteRequest struct {
+		Token string `validate:"required"`
+		// Alias of the peer which issued the invite, empty alias is generated from its name
+		Alias string
 	}
 	FriendRequestReply struct {
 		PeerID  string `validate:"required"`
 		Alias   string `validate:"required,trimmed_str_not_empty"`
 		Decline bool
+		// IPAddr of the peer in our vpn network, empty to allocate it by config.VPNConfig.IPAllocation
+		IPAddr string `validate:"omitempty,ipv4"`
 	}
 	PeerIDRequest struct {
 		PeerID string `validate:"required"`
@@ -32,14 +90,264 @@ type (
 		Alias                string `validate:"required,trimmed_str_not_empty"`
 		DomainName           string
 		AllowUsingAsExitNode bool
+		// KillSwitch drops traffic to the peer while it is not confirmed or not connected
+		KillSwitch bool
+		// SharedFolderAccess is the peer's access to our shared folder, empty denies access
+		SharedFolderAccess string `validate:"omitempty,oneof=read write" enums:",read,write"`
+		// SupportAccess allows the peer to fetch our recent logs and diagnostics report
+		SupportAccess bool
+		// ManagementAllowed lets the peer administer this node through the web api over awl network
+		ManagementAllowed bool
+		// IPAddr changes the peer address in our vpn network, empty keeps the current one
+		IPAddr string `validate:"omitempty,ipv4"`
+	}
+	UpdatePeerACLRequest struct {
+		PeerID string `validate:"required"`
+		// Rules are checked in order, empty list allows all traffic
+		Rules []config.ACLRule
+	}
+	UpdatePeerGroupsRequest struct {
+		PeerID string `validate:"required"`
+		// Groups replace the current ones, e.g. "work" or "home-lab"
+		Groups []string
+	}
+	UpdatePeerGroupRequest struct {
+		Name string `validate:"required"`
+		// ACL rules are checked after rules of each member
+		ACL []config.ACLRule
+		// AllowUsingAsExitNode lets members use us as exit node and router
+		AllowUsingAsExitNode bool
+		// Shared publishes membership of the group signed by us to its members
+		Shared bool
+		// Owner is the peer id which manages members of the group, they are synced from it.
+		// The owner should share the group with the same name
+		Owner string
+	}
+	RemovePeerGroupRequest struct {
+		Name string `validate:"required"`
+	}
+	UpdatePeerNotesRequest struct {
+		PeerID  string `validate:"required"`
+		Notes   string `validate:"max=4096"`
+		Contact config.PeerContact
+	}
+	UpdatePeerMTURequest struct {
+		PeerID string `validate:"required"`
+		// MTU of the path to the peer, zero removes the limit
+		MTU int
+	}
+	UpdatePeerRoutesRequest struct {
+		PeerID string `validate:"required"`
+		// AcceptRoutes routes traffic to private networks advertised by the peer
+		AcceptRoutes bool
+		// AcceptAllRoutes also accepts public networks and ones wider than /16
+		AcceptAllRoutes bool
+	}
+	UpdatePeerBandwidthLimitRequest struct {
+		PeerID string `validate:"required"`
+		// Limits in kilobits per second, zero is unlimited
+		UploadKbps   int `validate:"gte=0"`
+		DownloadKbps int `validate:"gte=0"`
 	}
 	UpdateMySettingsRequest struct {
 		Name string
 	}
+	UpdateKillSwitchRequest struct {
+		// Enabled drops traffic to all peers while the authenticated path to them is down
+		Enabled bool
+	}
+	UpdateVPNPauseRequest struct {
+		// Paused brings the vpn interface down, connections to peers are kept
+		Paused bool
+	}
+	UpdateRendezvousRequest struct {
+		// Phrase is the secret shared with friends, empty phrase disables rendezvous
+		Phrase string
+	}
+	UpdateDNSUpstreamRequest struct {
+		// PeerID of known peer which allows using it as exit node, it resolves our dns queries.
+		// Empty peer id resolves queries with upstream address
+		PeerID string
+		// Address of dns server which resolves our queries and queries of peers which use us as upstream peer,
+		// e.g. 127.0.0.1:5353. Empty address uses the system one
+		Address string
+	}
+	UpdatePacketFilterRequest struct {
+		DropIPv6 bool
+		// DropMulticast drops multicast and broadcast, e.g. mDNS and SSDP discovery of the OS
+		DropMulticast bool
+		// DropProtocols are IP protocol numbers, e.g. 2 for IGMP
+		DropProtocols []int `validate:"dive,gte=0,lte=255"`
+	}
+	UpdateQoSRequest struct {
+		// Disabled sends packets to peers in arrival order
+		Disabled bool
+		// Rules set priority of packets by ports, they are checked before DSCP marks and ports of interactive protocols
+		Rules []config.QoSRule
+	}
+	UpdateIPAllocationRequest struct {
+		Strategy string `validate:"required,oneof=sequential hash manual negotiate" enums:"sequential,hash,manual,negotiate"`
+	}
+	UpdateAutoRenumberRequest struct {
+		// Enabled moves the vpn network to a free one on start if it overlaps a local network
+		Enabled bool
+	}
+	UpdateBandwidthLimitRequest struct {
+		// Limits of traffic with all peers in total in kilobits per second, zero is unlimited
+		UploadKbps   int `validate:"gte=0"`
+		DownloadKbps int `validate:"gte=0"`
+	}
+	UpdateExitNodeRequest struct {
+		// PeerID of known peer which allows using it as exit node, empty to route traffic directly
+		PeerID string
+	}
+	UpdateAdvertisedRoutesRequest struct {
+		// Routes are IPv4 networks in CIDR notation which peers could reach through us, e.g. 192.168.1.0/24
+		Routes []string
+	}
+	UpdateSOCKS5Request struct {
+		// ListenAddress of local SOCKS5 server, e.g. 127.0.0.1:1080, empty address stops the server
+		ListenAddress string
+		// PeerID of known peer which allows using it as exit node, it makes connections on our behalf
+		PeerID string
+		// ResumablePorts are destination ports of connections which survive brief outages of the peer, e.g. 22 for SSH
+		ResumablePorts []int `validate:"dive,gte=1,lte=65535"`
+	}
+	UpdatePowerModeRequest struct {
+		// Mode is auto (power source is detected), ac or battery. Battery mode reduces background network activity
+		Mode string `validate:"required,oneof=auto ac battery" enums:"auto,ac,battery"`
+	}
+	UpdateConfigEncryptionRequest struct {
+		// Mode is passphrase, keychain (OS keychain: Keychain, Secret Service or DPAPI) or empty to store config as plain json
+		Mode string `validate:"omitempty,oneof=passphrase keychain"`
+		// Passphrase is required for passphrase mode. It should be provided on start in AWL_CONFIG_PASSPHRASE env or in terminal
+		Passphrase string
+	}
+	UpdateConnectionGaterRequest struct {
+		// OnlyKnownPeers refuses inbound connections of peers which are not known or allowlisted
+		OnlyKnownPeers bool
+		// Allowlist are peer ids which could connect when OnlyKnownPeers is enabled
+		Allowlist []string
+		// Denylist are peer ids which connections are refused in both directions
+		Denylist []string
+	}
+	UpdateScheduledJobsRequest struct {
+		// Jobs replace all scheduled jobs, peers should be known
+		Jobs []config.ScheduledJob
+	}
+	UpdateSharedFolderRequest struct {
+		// Path is absolute path of existing directory, empty path disables sharing
+		Path string
+	}
+	UpdateFileTransferRequest struct {
+		// InboxPath is absolute path of existing directory for files received from peers, empty path refuses files
+		InboxPath string
+	}
+	SendFileRequest struct {
+		PeerID string `validate:"required"`
+		// Path is absolute path of the file on this peer
+		Path string `validate:"required"`
+	}
+	SendMessageRequest struct {
+		PeerID string `validate:"required"`
+		// Text is up to 4 KiB
+		Text string `validate:"required"`
+	}
+	MessagesRequest struct {
+		// PeerID filters messages with the peer, empty for all peers
+		PeerID string `url:"peer_id,omitempty" query:"peer_id"`
+		// Limit is the number of the latest messages, zero for all stored messages
+		Limit int `url:"limit,omitempty" query:"limit" validate:"gte=0"`
+	}
+	MarkMessagesReadRequest struct {
+		// PeerID of messages sender, empty marks messages of all peers
+		PeerID string
+	}
+	UpdateDNSRecordsRequest struct {
+		// Records are names without our domain name and zone suffix, e.g. "plex" for plex.<my domain>.awl
+		Records []string
+	}
+	EchoRequest struct {
+		PeerID string `validate:"required"`
+		// PayloadSize in bytes, up to 1 MiB
+		PayloadSize int `validate:"gte=0"`
+	}
+	SpeedTestRequest struct {
+		PeerID string `validate:"required"`
+		// Seconds of each direction, 5 seconds by default, max 20
+		Seconds int `validate:"gte=0,lte=20"`
+	}
+	SpeedTestHistoryRequest struct {
+		// PeerID filters results of the peer, all peers by default
+		PeerID string    `url:"peer_id,omitempty" query:"peer_id"`
+		From   time.Time `url:"from,omitempty" query:"from"`
+		To     time.Time `url:"to,omitempty" query:"to"`
+	}
+	SupportReportRequest struct {
+		PeerID string `validate:"required"`
+		// LogMinutes is the period of the latest logs, default 30 minutes, max 24 hours
+		LogMinutes int `validate:"gte=0,lte=1440"`
+	}
+	PortScanRequest struct {
+		PeerID string `validate:"required"`
+		// Host is IPv4 address from advertised routes of the peer, empty for the peer itself
+		Host string `validate:"omitempty,ipv4"`
+		// Ports are single ports and ranges separated by commas, e.g. "22,80,8000-8100", up to 4096 ports
+		Ports string `validate:"required"`
+	}
+	RestoreBackupRequest struct {
+		// PeerID of the peer which stores the backup
+		PeerID string `validate:"required"`
+		// OwnerPeerID is our old peer id, the one which created the backup
+		OwnerPeerID string `validate:"required"`
+		Passphrase  string `validate:"required"`
+	}
+	ExportBackupRequest struct {
+		// Passphrase encrypts the archive, it's required to import it
+		Passphrase string `validate:"required"`
+		// WithoutIdentity exports a template for other nodes: identity and storage aren't included
+		WithoutIdentity bool
+	}
+	ImportBackupRequest struct {
+		Passphrase string `validate:"required"`
+		// Data is the archive from ExportBackup
+		Data []byte `validate:"required"`
+	}
+	ImportPeersRequest struct {
+		Format string `validate:"required" enums:"tailscale,zerotier,nebula,csv"`
+		// Data is the content of exported file
+		Data string `validate:"required"`
+		// DryRun only shows what would be imported
+		DryRun bool
+	}
+	CreateAPIKeyRequest struct {
+		Name       string `validate:"required,trimmed_str_not_empty"`
+		Permission string `validate:"required,oneof=read admin" enums:"read,admin"`
+	}
+	RemoveAPIKeyRequest struct {
+		Name string `validate:"required"`
+	}
+	BootstrapPeerRequest struct {
+		// Address is multiaddr with peer id, e.g. /ip4/203.0.113.1/tcp/6150/p2p/12D3KooW...
+		Address string `validate:"required"`
+	}
 )
 
 // Responses
 type (
+	PeerGroupResponse struct {
+		Name string
+		// HasPolicy is false for groups which are only tags of peers
+		HasPolicy            bool
+		ACL                  []config.ACLRule
+		AllowUsingAsExitNode bool
+		Shared               bool
+		Owner                string
+		// MembershipTime is the time of members synced from Owner
+		MembershipTime *time.Time
+		// Members are ids of known peers in the group
+		Members []string
+	}
 	KnownPeersResponse struct {
 		PeerID                 string
 		Name                   string // Deprecated: use DisplayName instead
@@ -47,16 +355,40 @@ type (
 		Alias                  string
 		Version                string
 		IpAddr                 string
+		IPv6Addr               string
 		DomainName             string
 		Connected              bool
 		Confirmed              bool
 		Declined               bool
 		WeAllowUsingAsExitNode bool
 		AllowedUsingAsExitNode bool
+		DNSRecords             []string
+		// SubnetRoutes are networks advertised by the peer, we reach them through it if AcceptRoutes is set
+		SubnetRoutes           []string
+		AcceptRoutes           bool
+		AcceptAllRoutes        bool
+		KillSwitch             bool
 		LastSeen               time.Time
 		Connections            []p2p.ConnectionInfo
 		NetworkStats           metrics.Stats
 		NetworkStatsInIECUnits StatsInUnits
+		// NetworkStatsByCategory is bandwidth by traffic category: vpn, forwarding, services, control
+		NetworkStatsByCategory           map[string]metrics.Stats
+		NetworkStatsByCategoryInIECUnits map[string]StatsInUnits
+		// ClockSkew is the peer clock minus ours, zero if unknown
+		ClockSkew time.Duration `swaggertype:"primitive,integer"`
+		Notes     string
+		Contact   config.PeerContact
+		// Latency is summary of recent pings, empty if the peer wasn't pinged yet
+		Latency service.PeerLatency
+		// ConnectionType is direct if any connection with the peer is direct, empty if the peer is disconnected
+		ConnectionType string `enums:",direct,relayed"`
+		// LocalConnection is true if the peer is connected over loopback or our local network
+		LocalConnection bool
+		// UnreadMessages is the number of messages from the peer which are not marked as read
+		UnreadMessages int
+		// Groups of the peer, policies of the groups are applied along with the peer settings
+		Groups []string
 	}
 
 	PeerInfo struct {
@@ -71,6 +403,104 @@ type (
 		Reachability            string `enums:"Unknown,Public,Private"`
 		AwlDNSAddress           string
 		IsAwlDNSSetAsSystem     bool
+		DNSRecords              []string
+		DNSUpstreamPeerID       string
+		DNSUpstreamAddress      string
+		KillSwitch              bool
+		SharedFolderPath        string
+		ExitNodePeerID          string
+		AdvertisedRoutes        []string
+		SOCKS5ListenAddress     string
+		SOCKS5PeerID            string
+		SOCKS5ResumablePorts    []int
+		// NTPClockSkew is NTP server clock minus ours, zero if unknown
+		NTPClockSkew time.Duration `swaggertype:"primitive,integer"`
+		// ClockWarnings describe clock skews against NTP server and peers which could break connections
+		ClockWarnings []string
+		// NetworkStatsByCategory is bandwidth by traffic category: vpn, forwarding, services, control
+		NetworkStatsByCategory           map[string]metrics.Stats
+		NetworkStatsByCategoryInIECUnits map[string]StatsInUnits
+
+		// FileTransferInboxPath is the directory of files received from peers, empty if files are refused
+		FileTransferInboxPath string
+		// UnreadMessages is the number of messages from all peers which are not marked as read
+		UnreadMessages int
+
+		// Features are optional features compiled into the binary: dns, web_ui, file_transfer, socks5
+		Features []string
+		// ConfigEncryption is the mode of config encryption at rest: passphrase, keychain or empty
+		ConfigEncryption string
+		// VPNPaused is true while the vpn interface is brought down by the user, see /settings/vpn_pause
+		VPNPaused bool
+		// RendezvousEnabled is true if peers with the same phrase are found and added, see /settings/rendezvous
+		RendezvousEnabled bool
+	}
+
+	// Event is a message of the events WebSocket. Data is the event of the Type from awlevent package.
+	Event struct {
+		Type string
+		Time time.Time
+		Data json.RawMessage `swaggertype:"object"`
+	}
+
+	// SearchResponse contains matched known peers and events, events are log entries, the newest first.
+	SearchResponse struct {
+		Peers  []KnownPeersResponse
+		Events []logview.Entry
+	}
+
+	CreateInviteResponse struct {
+		// Token should be passed to the invited peer, it could be used only once
+		Token     string
+		ExpiresAt time.Time
+	}
+	AcceptInviteResponse struct {
+		PeerID string
+		Name   string
+		Alias  string
+	}
+
+	PacketFilterResponse struct {
+		Filter config.PacketFilterConfig
+		// Dropped are numbers of packets dropped since the start, Invalid are malformed packets
+		Dropped vpn.PacketFilterStats
+	}
+
+	QoSResponse struct {
+		QoS config.QoSConfig
+		// Classes are numbers of packets to peers by priority from high to low, Queued are packets waiting for now
+		Classes []service.QoSClassStats
+	}
+
+	SubnetConflictsResponse struct {
+		AutoRenumber bool
+		// Conflicts are the vpn network and routes of peers which overlap local networks
+		Conflicts []awlevent.SubnetConflict
+	}
+
+	BandwidthLimitResponse struct {
+		Global config.BandwidthLimitConfig
+		// Peers are known peers with their own limits
+		Peers []PeerBandwidthLimit
+		// Delayed is total delay of traffic by the limits since the start
+		Delayed service.ShaperStats
+	}
+	PeerBandwidthLimit struct {
+		PeerID   string
+		PeerName string
+		Limit    config.BandwidthLimitConfig
+	}
+
+	FlowResponse struct {
+		vpn.Flow
+		PeerName string
+	}
+
+	LogEntriesResponse struct {
+		Entries []logview.Entry
+		// Offset of the first returned entry among all matched entries
+		Offset int
+		Total  int
 	}
 
 	StatsInUnits struct {
@@ -84,6 +514,97 @@ type (
 		PeerID string
 		protocol.AuthPeer
 	}
+
+	PeerLatencyResponse struct {
+		Latency service.PeerLatency
+		// History is recent pings from the oldest one, they are sent every 30 seconds
+		History []service.LatencySample
+	}
+
+	EchoResponse struct {
+		RTT          time.Duration `swaggertype:"primitive,integer"`
+		Bytes        int
+		ThroughRelay bool
+		// ClockOffsetKnown is false if the peer answered with plain echo, e.g. it's unknown peer in echo peer mode
+		ClockOffsetKnown bool
+		// ClockOffset is the peer clock minus ours
+		ClockOffset time.Duration `swaggertype:"primitive,integer"`
+	}
+
+	SpeedTestHistoryResponse struct {
+		// Results are ordered by time, failed tests have Error
+		Results []service.SpeedTestResult
+		// Trends summarize results by day
+		Trends []service.SpeedTestTrend
+	}
+
+	BackupResult struct {
+		PeerID string
+		Error  string `json:",omitempty"`
+	}
+	PortScanResponse struct {
+		PeerID string
+		Host   string
+		// Open are ports which accept TCP connections sorted by port
+		Open []protocol.OpenPort
+	}
+
+	RestoreBackupResponse struct {
+		CreatedAt time.Time
+		PeerID    string
+	}
+
+	IdentityMigrationResponse struct {
+		OldPeerID string
+		NewPeerID string
+		CreatedAt time.Time
+		// PendingPeers are trusted peers which haven't accepted the new peer id yet, it's sent again when they connect
+		PendingPeers []string
+		// RestartRequired is true until the app is restarted with the new identity
+		RestartRequired bool
+	}
+
+	ImportPeersResponse struct {
+		Peers []ImportedPeer
+	}
+	ImportedPeer struct {
+		Alias       string
+		SourceIP    string
+		SuggestedIP string
+		PeerID      string
+		Status      string `enums:"invited,needs_peer_id,skipped"`
+		// Message explains the status and what to do next
+		Message string
+	}
+
+	APIKeyResponse struct {
+		Name       string
+		Permission string `enums:"read,admin"`
+		CreatedAt  time.Time
+	}
+	BootstrapPeerResponse struct {
+		Address string
+		PeerID  string
+		// Default peers are built into the app, they can't be removed
+		Default   bool
+		Connected bool
+	}
+	TestBootstrapPeerResponse struct {
+		PeerID  string
+		Latency time.Duration `swaggertype:"primitive,integer"`
+		// Error is empty if the peer is reachable
+		Error string `json:",omitempty"`
+	}
+	CreateAPIKeyResponse struct {
+		// Key should be passed in Authorization header as "Bearer <key>", it isn't stored and can't be shown again
+		Key string
+	}
+)
+
+const (
+	ImportStatusInvited     = "invited"
+	ImportStatusNeedsPeerID = "needs_peer_id"
+	ImportStatusSkipped     = "skipped"
 )
 
 type (
@@ -112,6 +633,8 @@ type (
 		OpenConnectionsCount int
 		OpenStreamsCount     int64
 		LastTrimAgo          string
+		RefusedCount         int64
+		Greylist             p2p.GreylistStats
 	}
 	BandwidthDebugInfo struct {
 		Total      BandwidthInfo
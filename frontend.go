@@ -0,0 +1,19 @@
+//go:build !awl_noui
+
+package awl
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static
+var frontendStatic embed.FS
+
+func FrontendStatic() fs.FS {
+	fsys, err := fs.Sub(frontendStatic, "static")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
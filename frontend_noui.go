@@ -0,0 +1,12 @@
+//go:build awl_noui
+
+package awl
+
+import (
+	"io/fs"
+)
+
+// FrontendStatic returns nil in builds without web ui, only api is served then.
+func FrontendStatic() fs.FS {
+	return nil
+}
@@ -0,0 +1,138 @@
+// Package invite creates and verifies invitation tokens. Token is signed by the issuer peer key and contains
+// one-time secret, the invited peer sends it back with friend request to be accepted without confirmation.
+package invite
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// Prefix makes tokens recognizable when they are pasted along with other text
+	Prefix = "awl-invite:"
+
+	secretSize = 16
+	// signaturePrefix separates invite signatures from other signatures made with the peer key
+	signaturePrefix = "awl invite:"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid invite token")
+	ErrExpired      = errors.New("invite token is expired")
+)
+
+type Token struct {
+	PeerID string
+	// Name of the issuer peer
+	Name      string
+	ExpiresAt time.Time
+	Secret    []byte
+}
+
+type signedToken struct {
+	Payload   []byte
+	Signature []byte
+}
+
+type payload struct {
+	PeerID    string
+	Name      string
+	ExpiresAt int64
+	Secret    []byte
+}
+
+// New creates token of the key owner with random secret.
+func New(key crypto.PrivKey, name string, expiresAt time.Time) (Token, error) {
+	peerID, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return Token{}, err
+	}
+	secret := make([]byte, secretSize)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		PeerID:    peerID.String(),
+		Name:      name,
+		ExpiresAt: expiresAt.Truncate(time.Second),
+		Secret:    secret,
+	}, nil
+}
+
+// Encode signs the token with the issuer key.
+func Encode(key crypto.PrivKey, token Token) (string, error) {
+	data, err := json.Marshal(payload{
+		PeerID:    token.PeerID,
+		Name:      token.Name,
+		ExpiresAt: token.ExpiresAt.Unix(),
+		Secret:    token.Secret,
+	})
+	if err != nil {
+		return "", err
+	}
+	signature, err := key.Sign(append([]byte(signaturePrefix), data...))
+	if err != nil {
+		return "", fmt.Errorf("sign: %v", err)
+	}
+	signed, err := json.Marshal(signedToken{Payload: data, Signature: signature})
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + base64.RawURLEncoding.EncodeToString(signed), nil
+}
+
+// Decode verifies signature of the token issuer and expiration time.
+func Decode(s string, now time.Time) (Token, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), Prefix)
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+	var signed signedToken
+	var p payload
+	if json.Unmarshal(data, &signed) != nil || json.Unmarshal(signed.Payload, &p) != nil {
+		return Token{}, ErrInvalidToken
+	}
+	peerID, err := peer.Decode(p.PeerID)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+	pubKey, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+	ok, err := pubKey.Verify(append([]byte(signaturePrefix), signed.Payload...), signed.Signature)
+	if err != nil || !ok || len(p.Secret) != secretSize {
+		return Token{}, ErrInvalidToken
+	}
+
+	token := Token{
+		PeerID:    p.PeerID,
+		Name:      p.Name,
+		ExpiresAt: time.Unix(p.ExpiresAt, 0),
+		Secret:    p.Secret,
+	}
+	if !now.Before(token.ExpiresAt) {
+		return Token{}, ErrExpired
+	}
+	return token, nil
+}
+
+// SecretHash is stored by the issuer instead of the secret.
+func SecretHash(secret []byte) string {
+	hash := sha256.Sum256(secret)
+	return hex.EncodeToString(hash[:])
+}
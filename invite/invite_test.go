@@ -0,0 +1,84 @@
+package invite
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	peerID, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	now := time.Now()
+
+	token, err := New(key, "laptop", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, peerID.String(), token.PeerID)
+	encoded, err := Encode(key, token)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(encoded, Prefix))
+
+	decoded, err := Decode(" "+encoded+"\n", now)
+	require.NoError(t, err)
+	require.Equal(t, token.PeerID, decoded.PeerID)
+	require.Equal(t, token.Name, decoded.Name)
+	require.Equal(t, token.Secret, decoded.Secret)
+	require.True(t, token.ExpiresAt.Equal(decoded.ExpiresAt))
+
+	_, err = Decode(encoded, now.Add(2*time.Hour))
+	require.ErrorIs(t, err, ErrExpired)
+
+	_, err = Decode("awl-invite:garbage", now)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// token of another peer signed by our key
+	otherKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	otherID, err := peer.IDFromPrivateKey(otherKey)
+	require.NoError(t, err)
+	forged := token
+	forged.PeerID = otherID.String()
+	encoded, err = Encode(key, forged)
+	require.NoError(t, err)
+	_, err = Decode(encoded, now)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestDecodeTampered(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	now := time.Now()
+	token, err := New(key, "laptop", now.Add(time.Hour))
+	require.NoError(t, err)
+	encoded, err := Encode(key, token)
+	require.NoError(t, err)
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encoded, Prefix))
+	require.NoError(t, err)
+	var signed signedToken
+	require.NoError(t, json.Unmarshal(data, &signed))
+	var p payload
+	require.NoError(t, json.Unmarshal(signed.Payload, &p))
+	p.ExpiresAt = now.Add(24 * time.Hour).Unix()
+	signed.Payload, err = json.Marshal(p)
+	require.NoError(t, err)
+	data, err = json.Marshal(signed)
+	require.NoError(t, err)
+
+	_, err = Decode(Prefix+base64.RawURLEncoding.EncodeToString(data), now)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestSecretHash(t *testing.T) {
+	require.Equal(t, SecretHash([]byte("secret")), SecretHash([]byte("secret")))
+	require.NotEqual(t, SecretHash([]byte("secret")), SecretHash([]byte("other")))
+}
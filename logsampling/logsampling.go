@@ -0,0 +1,162 @@
+// Package logsampling deduplicates repeated log messages, so hot-path errors don't flood logs.
+package logsampling
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// maxEntries bounds memory used for messages without caller, which are keyed by the text, new ones aren't sampled above it.
+const maxEntries = 1000
+
+// entryKey is the call site of the entry, so messages with variable text are sampled together.
+// The text is used only if the logger doesn't add caller, see zap.AddCaller.
+type entryKey struct {
+	level      zapcore.Level
+	loggerName string
+	caller     uintptr
+	message    string
+}
+
+type entryState struct {
+	windowStart time.Time
+	count       int
+	dropped     int
+	last        zapcore.Entry
+}
+
+type state struct {
+	mu      sync.Mutex
+	window  time.Duration
+	burst   int
+	entries map[entryKey]*entryState
+	// nextFlush limits checks of expired windows to once per window
+	nextFlush time.Time
+	now       func() time.Time
+}
+
+type core struct {
+	zapcore.Core
+	state *state
+}
+
+// NewCore wraps zapcore.Core: only first burst entries with the same logger, level and call site are written during window.
+// Others are dropped and reported later with a single "message repeated N times" entry, it's written with the next
+// entry up to one more window later.
+// Debug entries and entries with level above Error are never dropped.
+func NewCore(inner zapcore.Core, window time.Duration, burst int) zapcore.Core {
+	return &core{
+		Core: inner,
+		state: &state{
+			window:  window,
+			burst:   burst,
+			entries: make(map[entryKey]*entryState),
+			now:     time.Now,
+		},
+	}
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{
+		Core:  c.Core.With(fields),
+		state: c.state,
+	}
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Level <= zapcore.DebugLevel || ent.Level > zapcore.ErrorLevel {
+		return c.Core.Write(ent, fields)
+	}
+
+	summaries, write := c.state.process(ent)
+	for _, summary := range summaries {
+		_ = c.Core.Write(summary, nil)
+	}
+	if !write {
+		return nil
+	}
+	return c.Core.Write(ent, fields)
+}
+
+func (c *core) Sync() error {
+	for _, summary := range c.state.flush(true) {
+		_ = c.Core.Write(summary, nil)
+	}
+	return c.Core.Sync()
+}
+
+// process returns summaries for expired windows and whether ent should be written.
+func (s *state) process(ent zapcore.Entry) ([]zapcore.Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var summaries []zapcore.Entry
+	if !now.Before(s.nextFlush) {
+		summaries = s.flushLocked(false)
+		s.nextFlush = now.Add(s.window)
+	}
+	key := entryKey{level: ent.Level, loggerName: ent.LoggerName}
+	if ent.Caller.Defined {
+		key.caller = ent.Caller.PC
+	} else {
+		key.message = ent.Message
+	}
+	entry, exists := s.entries[key]
+	if !exists {
+		if len(s.entries) >= maxEntries {
+			return summaries, true
+		}
+		entry = &entryState{windowStart: now}
+		s.entries[key] = entry
+	}
+	entry.count++
+	entry.last = ent
+	if entry.count <= s.burst {
+		return summaries, true
+	}
+	entry.dropped++
+	return summaries, false
+}
+
+func (s *state) flush(all bool) []zapcore.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked(all)
+}
+
+// flushLocked removes expired windows (or all if all is true) and returns summaries for dropped entries.
+func (s *state) flushLocked(all bool) []zapcore.Entry {
+	now := s.now()
+	var summaries []zapcore.Entry
+	for key, entry := range s.entries {
+		if !all && now.Sub(entry.windowStart) < s.window {
+			continue
+		}
+		delete(s.entries, key)
+		if entry.dropped == 0 {
+			continue
+		}
+		summary := entry.last
+		summary.Time = now
+		summary.Stack = ""
+		summary.Message = fmt.Sprintf("message repeated %d times in %s: %s",
+			entry.dropped, now.Sub(entry.windowStart).Truncate(time.Second), entry.last.Message)
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Message < summaries[j].Message
+	})
+	return summaries
+}
@@ -0,0 +1,69 @@
+package logsampling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCore(t *testing.T) {
+	observedCore, logs := observer.New(zapcore.DebugLevel)
+	now := time.Now()
+	samplingCore := NewCore(observedCore, 10*time.Second, 3)
+	samplingCore.(*core).state.now = func() time.Time { return now }
+	logger := zap.New(samplingCore).Sugar()
+
+	for i := 0; i < 100; i++ {
+		logger.Warn("read from tun: file already closed")
+	}
+	logger.Warn("other message")
+	for i := 0; i < 10; i++ {
+		logger.Debug("debug message")
+	}
+	require.Equal(t, 3+1+10, logs.Len())
+
+	now = now.Add(11 * time.Second)
+	logger.Info("after window")
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3+1+10+2)
+	require.Equal(t, "message repeated 97 times in 11s: read from tun: file already closed", entries[14].Message)
+	require.Equal(t, zapcore.WarnLevel, entries[14].Level)
+	require.Equal(t, "after window", entries[15].Message)
+
+	for i := 0; i < 5; i++ {
+		logger.Error("stream reset")
+	}
+	require.NoError(t, samplingCore.Sync())
+	entries = logs.TakeAll()
+	require.Len(t, entries, 3+1)
+	require.Equal(t, "message repeated 2 times in 0s: stream reset", entries[3].Message)
+}
+
+func TestCore_Caller(t *testing.T) {
+	observedCore, logs := observer.New(zapcore.DebugLevel)
+	now := time.Now()
+	samplingCore := NewCore(observedCore, 10*time.Second, 3)
+	samplingCore.(*core).state.now = func() time.Time { return now }
+	logger := zap.New(samplingCore, zap.AddCaller()).Sugar()
+
+	// messages with variable text of the same call site are sampled together
+	for i := 0; i < 100; i++ {
+		logger.Warnf("inbound reader dropped packet, len %d", i)
+	}
+	logger.Warnf("inbound reader dropped packet, len %d", 100)
+	require.Equal(t, 3+1, logs.Len())
+	require.Len(t, samplingCore.(*core).state.entries, 2)
+
+	// expired windows are flushed once per window
+	now = now.Add(5 * time.Second)
+	logger.Warn("other message")
+	now = now.Add(6 * time.Second)
+	logger.Warn("other message")
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3+1+1+1+1)
+	require.Equal(t, "message repeated 97 times in 11s: inbound reader dropped packet, len 99", entries[5].Message)
+}
@@ -0,0 +1,121 @@
+package logview
+
+import (
+	"bytes"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// TimeLayout is the time format of log lines, it is set in logger config.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// Entry is a parsed line of console encoded log: "time\tLEVEL\tlogger\tmessage".
+// Message contains everything after logger name, including fields and stack traces.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Logger  string    `json:"logger"`
+	Message string    `json:"message"`
+}
+
+// String formats entry the same way it was written by the logger.
+func (e Entry) String() string {
+	return e.Time.Format(TimeLayout) + "\t" + e.Level + "\t" + e.Logger + "\t" + e.Message
+}
+
+// Parse parses console encoded logs. Lines without timestamp, e.g. stack traces, are appended to the previous entry.
+// Lines before the first entry are skipped because ring buffer could cut them.
+func Parse(data []byte) []Entry {
+	if !utf8.Valid(data) {
+		data = bytes.ToValidUTF8(data, []byte(""))
+	}
+	lines := strings.Split(strings.TrimRight(string(data), zapcore.DefaultLineEnding), zapcore.DefaultLineEnding)
+	entries := make([]Entry, 0, len(lines))
+	for _, line := range lines {
+		entry, ok := parseLine(line)
+		if ok {
+			entries = append(entries, entry)
+			continue
+		}
+		if len(entries) > 0 {
+			last := &entries[len(entries)-1]
+			last.Message += zapcore.DefaultLineEnding + line
+		}
+	}
+
+	return entries
+}
+
+func parseLine(line string) (Entry, bool) {
+	parts := strings.SplitN(line, "\t", 4)
+	if len(parts) < 3 {
+		return Entry{}, false
+	}
+	t, err := time.ParseInLocation(TimeLayout, parts[0], time.Local)
+	if err != nil {
+		return Entry{}, false
+	}
+	var level zapcore.Level
+	if level.UnmarshalText([]byte(parts[1])) != nil {
+		return Entry{}, false
+	}
+	entry := Entry{
+		Time:   t,
+		Level:  parts[1],
+		Logger: parts[2],
+	}
+	if len(parts) == 4 {
+		entry.Message = parts[3]
+	}
+	return entry, true
+}
+
+// Query selects entries in time range [From, To] with level not lower than MinLevel.
+// Zero From and To are not limited. Empty Logger matches all loggers, otherwise logger name prefix is matched.
+type Query struct {
+	From     time.Time
+	To       time.Time
+	MinLevel zapcore.Level
+	Logger   string
+}
+
+// Filter returns entries matching the query, order is preserved.
+func (q Query) Filter(entries []Entry) []Entry {
+	result := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if q.match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+func (q Query) match(entry Entry) bool {
+	if !q.From.IsZero() && entry.Time.Before(q.From.Truncate(time.Second)) {
+		return false
+	}
+	if !q.To.IsZero() && entry.Time.After(q.To) {
+		return false
+	}
+	if q.Logger != "" && !strings.HasPrefix(entry.Logger, q.Logger) {
+		return false
+	}
+	var level zapcore.Level
+	_ = level.UnmarshalText([]byte(entry.Level))
+	return level >= q.MinLevel
+}
+
+// IndexAt returns index of the first entry written at or after t, or len(entries) if there are no such.
+// Entries are expected to be sorted by time.
+func IndexAt(entries []Entry, t time.Time) int {
+	t = t.Truncate(time.Second)
+	for i, entry := range entries {
+		if !entry.Time.Before(t) {
+			return i
+		}
+	}
+	return len(entries)
+}
@@ -0,0 +1,122 @@
+package logview
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+const rotatedFileSuffix = ".1"
+
+// File is a log file which is rotated when it exceeds max size. Only one rotated file is kept,
+// so disk usage is limited to 2*maxSize. It is safe for concurrent use.
+type File struct {
+	lock    sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+func OpenFile(path string, maxSize int64) (*File, error) {
+	f := &File{
+		path:    path,
+		maxSize: maxSize,
+	}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Path() string {
+	return f.path
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) Sync() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.file.Sync()
+}
+
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// ReadAll returns content of rotated and current files.
+func (f *File) ReadAll() ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	rotated, err := os.ReadFile(f.path + rotatedFileSuffix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read rotated log file: %v", err)
+	}
+	current, err := os.ReadFile(f.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read log file: %v", err)
+	}
+	return append(rotated, current...), nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("stat log file: %v", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return fmt.Errorf("close log file: %v", err)
+	}
+	f.file = nil
+	renameErr := os.Rename(f.path, f.path+rotatedFileSuffix)
+	// reopen anyway to continue writing
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("rotate log file: %v", renameErr)
+	}
+	return nil
+}
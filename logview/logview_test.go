@@ -0,0 +1,85 @@
+package logview
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+const testLogs = `lines cut by ring buffer
+2024-01-02 10:00:00	INFO	awl	Anywherelan v0.1
+2024-01-02 10:00:01	WARN	awl/vpn	tun read failed	{"err": "closed"}
+2024-01-02 10:00:02	ERROR	awl/service/tunnel	stream failed
+main.run
+	/app/main.go:10
+2024-01-02 10:00:05	DEBUG	swarm2	dialing
+`
+
+func TestParseAndFilter(t *testing.T) {
+	a := require.New(t)
+	entries := Parse([]byte(testLogs))
+	a.Len(entries, 4)
+	a.Equal("awl/vpn", entries[1].Logger)
+	a.Equal("WARN", entries[1].Level)
+	a.Equal(`tun read failed	{"err": "closed"}`, entries[1].Message)
+	a.Equal("stream failed\nmain.run\n\t/app/main.go:10", entries[2].Message)
+	a.Equal(time.Date(2024, 1, 2, 10, 0, 5, 0, time.Local), entries[3].Time)
+	a.Equal(testLogs[strings.Index(testLogs, "\n")+1:], joinEntries(entries))
+
+	filtered := Query{MinLevel: zapcore.WarnLevel}.Filter(entries)
+	a.Len(filtered, 2)
+
+	filtered = Query{MinLevel: zapcore.DebugLevel, Logger: "awl/"}.Filter(entries)
+	a.Len(filtered, 2)
+
+	filtered = Query{
+		From:     time.Date(2024, 1, 2, 10, 0, 1, 500, time.Local),
+		To:       time.Date(2024, 1, 2, 10, 0, 2, 0, time.Local),
+		MinLevel: zapcore.DebugLevel,
+	}.Filter(entries)
+	a.Len(filtered, 2)
+	a.Equal("awl/vpn", filtered[0].Logger)
+
+	a.Equal(2, IndexAt(entries, time.Date(2024, 1, 2, 10, 0, 2, 0, time.Local)))
+	a.Equal(3, IndexAt(entries, time.Date(2024, 1, 2, 10, 0, 3, 0, time.Local)))
+	a.Equal(4, IndexAt(entries, time.Date(2024, 1, 3, 0, 0, 0, 0, time.Local)))
+}
+
+func TestFileRotation(t *testing.T) {
+	a := require.New(t)
+	path := filepath.Join(t.TempDir(), "awl.log")
+	f, err := OpenFile(path, 10)
+	a.NoError(err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		_, err = f.Write([]byte(line))
+		a.NoError(err)
+	}
+	data, err := f.ReadAll()
+	a.NoError(err)
+	// the first line was removed with rotation
+	a.Equal("second\nthird\n", string(data))
+	a.NoError(f.Close())
+
+	f, err = OpenFile(path, 100)
+	a.NoError(err)
+	defer f.Close()
+	_, err = f.Write([]byte("fourth\n"))
+	a.NoError(err)
+	data, err = f.ReadAll()
+	a.NoError(err)
+	a.Equal("second\nthird\nfourth\n", string(data))
+}
+
+func joinEntries(entries []Entry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(entry.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
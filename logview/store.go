@@ -0,0 +1,37 @@
+package logview
+
+import (
+	"github.com/anywherelan/awl/ringbuffer"
+)
+
+// Store provides logs of the app: from the log file if it is enabled, as it keeps logs of previous runs,
+// otherwise from the in-memory buffer.
+type Store struct {
+	buffer *ringbuffer.RingBuffer
+	file   *File
+}
+
+// NewStore creates Store, file could be nil.
+func NewStore(buffer *ringbuffer.RingBuffer, file *File) *Store {
+	return &Store{
+		buffer: buffer,
+		file:   file,
+	}
+}
+
+// Buffer returns in-memory logs of the current run.
+func (s *Store) Buffer() *ringbuffer.RingBuffer {
+	return s.buffer
+}
+
+// Entries returns all available parsed log entries, the oldest first.
+func (s *Store) Entries() ([]Entry, error) {
+	if s.file == nil {
+		return Parse(s.buffer.Bytes()), nil
+	}
+	data, err := s.file.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data), nil
+}
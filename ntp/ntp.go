@@ -0,0 +1,95 @@
+// Package ntp implements simple SNTP client, see rfc4330, which is enough to check clock of the device.
+package ntp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	packetLen = 48
+	// ntpEpochOffset is seconds between 1900-01-01 and unix epoch
+	ntpEpochOffset = 2208988800
+
+	versionClient = 4<<3 | 3
+	modeServer    = 4
+)
+
+type Response struct {
+	// Offset is the server clock minus ours, positive if our clock is behind
+	Offset time.Duration
+	// RTT is round trip time of the request, Offset is accurate within RTT/2
+	RTT time.Duration
+}
+
+// Query sends request to the server, e.g. pool.ntp.org:123, and returns offset of our clock.
+func Query(ctx context.Context, server string) (Response, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	request := make([]byte, packetLen)
+	request[0] = versionClient
+	sentAt := time.Now()
+	// server copies transmit timestamp to originate timestamp of the response, so we could match them
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sentAt))
+	_, err = conn.Write(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	response := make([]byte, packetLen)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			return Response{}, err
+		}
+		receivedAt := time.Now()
+		if n < packetLen || binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]) {
+			// stale or spoofed response
+			continue
+		}
+		return parseResponse(response, sentAt, receivedAt)
+	}
+}
+
+func parseResponse(response []byte, sentAt, receivedAt time.Time) (Response, error) {
+	if response[0]&0x07 != modeServer {
+		return Response{}, fmt.Errorf("unexpected mode %d", response[0]&0x07)
+	}
+	if response[0]>>6 == 3 {
+		return Response{}, errors.New("server clock is not synchronized")
+	}
+	if stratum := response[1]; stratum == 0 {
+		return Response{}, fmt.Errorf("kiss of death %q", response[12:16])
+	}
+
+	serverReceivedAt := fromNTPTime(binary.BigEndian.Uint64(response[32:]))
+	serverSentAt := fromNTPTime(binary.BigEndian.Uint64(response[40:]))
+	return Response{
+		Offset: (serverReceivedAt.Sub(sentAt) + serverSentAt.Sub(receivedAt)) / 2,
+		RTT:    max(receivedAt.Sub(sentAt)-serverSentAt.Sub(serverReceivedAt), 0),
+	}, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+func fromNTPTime(ntpTime uint64) time.Time {
+	seconds := int64(ntpTime>>32) - ntpEpochOffset
+	nanoseconds := int64((ntpTime & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
@@ -0,0 +1,74 @@
+package ntp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	a := require.New(t)
+	const offset = 2 * time.Minute
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.NoError(err)
+	defer conn.Close()
+	go func() {
+		request := make([]byte, packetLen)
+		for {
+			n, addr, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			if n < packetLen {
+				continue
+			}
+			// stale response is skipped by client
+			_, _ = conn.WriteTo(make([]byte, packetLen), addr)
+
+			response := make([]byte, packetLen)
+			response[0] = 4<<3 | modeServer
+			response[1] = 1
+			copy(response[24:32], request[40:48])
+			binary.BigEndian.PutUint64(response[32:], toNTPTime(time.Now().Add(offset)))
+			binary.BigEndian.PutUint64(response[40:], toNTPTime(time.Now().Add(offset)))
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := Query(ctx, conn.LocalAddr().String())
+	a.NoError(err)
+	a.InDelta(offset, response.Offset, float64(100*time.Millisecond))
+	a.Less(response.RTT, time.Second)
+}
+
+func TestParseResponse(t *testing.T) {
+	a := require.New(t)
+	now := time.Now()
+
+	response := make([]byte, packetLen)
+	response[0] = 4<<3 | modeServer
+	_, err := parseResponse(response, now, now)
+	a.ErrorContains(err, "kiss of death")
+
+	response[0] = 3<<6 | 4<<3 | modeServer
+	response[1] = 2
+	_, err = parseResponse(response, now, now)
+	a.Error(err)
+
+	response[0] = 4<<3 | 3
+	_, err = parseResponse(response, now, now)
+	a.ErrorContains(err, "unexpected mode")
+}
+
+func TestNTPTime(t *testing.T) {
+	a := require.New(t)
+	now := time.Now()
+	a.WithinDuration(now, fromNTPTime(toNTPTime(now)), time.Microsecond)
+}
@@ -0,0 +1,50 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const bootstrapPeerTestTimeout = 10 * time.Second
+
+// BootstrapPeers returns current bootstrap peers, they are used by DHT when its routing table is empty.
+func (p *P2p) BootstrapPeers() []peer.AddrInfo {
+	return *p.bootstrapPeers.Load()
+}
+
+// SetBootstrapPeers replaces bootstrap peers of running host. Removed peers are no longer protected from trimming,
+// new ones are connected on the next Bootstrap.
+func (p *P2p) SetBootstrapPeers(peers []peer.AddrInfo) {
+	old := p.bootstrapPeers.Swap(&peers)
+	// relay candidates are measured again
+	p.selectedRelays.Store(nil)
+	kept := make(map[peer.ID]struct{}, len(peers))
+	for _, info := range peers {
+		kept[info.ID] = struct{}{}
+	}
+	for _, info := range *old {
+		if _, ok := kept[info.ID]; !ok {
+			p.host.ConnManager().Unprotect(info.ID, protectedBootstrapPeerTag)
+		}
+	}
+	if p.gater != nil {
+		p.gater.setInfrastructure(peers, p.fallbackRelays)
+	}
+}
+
+// TestBootstrapPeer connects to the peer and returns round trip time of ping, it works for peers which are not bootstrap ones yet.
+func (p *P2p) TestBootstrapPeer(ctx context.Context, info peer.AddrInfo) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, bootstrapPeerTestTimeout)
+	defer cancel()
+
+	p.ClearBackoff(info.ID)
+	err := p.host.Connect(ctx, p.withNAT64Addrs(info))
+	if err != nil {
+		return 0, err
+	}
+	result := <-ping.Ping(ctx, p.host, info.ID)
+	return result.RTT, result.Error
+}
@@ -0,0 +1,64 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	friendConnTag          = "awl-friend"
+	connTagWeightsInterval = 30 * time.Second
+)
+
+// ConnTagWeights are conn manager tag weights for known peers. Known peers aren't protected, so under pressure
+// connections are trimmed by weights: DHT peers are tagged by DHT itself with lower weights and trimmed first,
+// zero weight makes friends equal to other peers.
+type ConnTagWeights struct {
+	ActiveFriend int
+	IdleFriend   int
+	// ActiveTrafficRate is min traffic rate in bytes per second for a friend to be considered active
+	ActiveTrafficRate float64
+}
+
+func (w ConnTagWeights) weight(trafficRate float64) int {
+	if trafficRate >= w.ActiveTrafficRate {
+		return w.ActiveFriend
+	}
+	return w.IdleFriend
+}
+
+// MaintainConnTagWeights periodically recalculates conn manager tag weights of known peers from their traffic stats.
+func (p *P2p) MaintainConnTagWeights(ctx context.Context, weightsFunc func() ConnTagWeights, knownPeersIdsFunc func() []peer.ID) {
+	ticker := time.NewTicker(connTagWeightsInterval)
+	defer ticker.Stop()
+
+	tagged := make(map[peer.ID]struct{})
+	for {
+		p.tagKnownPeers(weightsFunc(), knownPeersIdsFunc(), tagged)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tagKnownPeers tags known peers by weights and untags peers from tagged which are not known anymore.
+func (p *P2p) tagKnownPeers(weights ConnTagWeights, knownPeerIDs []peer.ID, tagged map[peer.ID]struct{}) {
+	knownPeers := make(map[peer.ID]struct{})
+	for _, peerID := range knownPeerIDs {
+		knownPeers[peerID] = struct{}{}
+		tagged[peerID] = struct{}{}
+		stats := p.bandwidthCounter.GetBandwidthForPeer(peerID)
+		p.connManager.TagPeer(peerID, friendConnTag, weights.weight(stats.RateIn+stats.RateOut))
+	}
+	for peerID := range tagged {
+		if _, known := knownPeers[peerID]; !known {
+			p.connManager.UntagPeer(peerID, friendConnTag)
+			delete(tagged, peerID)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagKnownPeers(t *testing.T) {
+	a := require.New(t)
+	newHost := func(opts ...libp2p.Option) host.Host {
+		opts = append(opts, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.ResourceManager(&network.NullResourceManager{}))
+		h, err := libp2p.New(opts...)
+		a.NoError(err)
+		t.Cleanup(func() {
+			_ = h.Close()
+		})
+		return h
+	}
+
+	// trimmedPeer connects friend and DHT peer, tags them and returns the one which is disconnected by trimming
+	trimmedPeer := func(weights ConnTagWeights) string {
+		connManager, err := connmgr.NewConnManager(1, 2, connmgr.WithGracePeriod(0))
+		a.NoError(err)
+		p := &P2p{connManager: connManager, bandwidthCounter: metrics.NewBandwidthCounter()}
+		h := newHost(libp2p.ConnectionManager(connManager))
+		peers := map[string]host.Host{"friend": newHost(), "dht": newHost()}
+		for _, other := range peers {
+			a.NoError(h.Connect(context.Background(), peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()}))
+		}
+		connManager.TagPeer(peers["dht"].ID(), "kbucket", 5)
+		p.tagKnownPeers(weights, []peer.ID{peers["friend"].ID()}, make(map[peer.ID]struct{}))
+
+		connManager.TrimOpenConns(context.Background())
+		var trimmed []string
+		for name, other := range peers {
+			if len(h.Network().ConnsToPeer(other.ID())) == 0 {
+				trimmed = append(trimmed, name)
+			}
+		}
+		a.Len(trimmed, 1)
+		return trimmed[0]
+	}
+
+	weights := ConnTagWeights{ActiveFriend: 100, IdleFriend: 50, ActiveTrafficRate: 1024}
+	a.Equal("dht", trimmedPeer(weights))
+	// friends with zero weight are trimmed before DHT peers
+	weights.IdleFriend = 0
+	a.Equal("friend", trimmedPeer(weights))
+}
@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"sort"
+	"time"
+
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+)
+
+type DHTRoutingTable struct {
+	LocalPeerID string
+	Size        int
+	// Buckets are sorted by common prefix length with our peer id, only non-empty buckets are present
+	Buckets []DHTBucket
+}
+
+type DHTBucket struct {
+	CommonPrefixLen int
+	Peers           []DHTPeerInfo
+}
+
+type DHTPeerInfo struct {
+	PeerID    string
+	UserAgent string
+	Connected bool
+	Addrs     []string
+	AddedAt   time.Time
+	// LastUsefulAt is the last time the peer was useful to us: answered a query or was added with a useful query
+	LastUsefulAt time.Time
+	// LastSuccessfulOutboundQueryAt is the last time the peer answered our query
+	LastSuccessfulOutboundQueryAt time.Time
+}
+
+// DHTRoutingTable returns DHT routing table grouped by buckets.
+func (p *P2p) DHTRoutingTable() DHTRoutingTable {
+	localID := kbucket.ConvertPeerID(p.host.ID())
+	peerInfos := p.dht.RoutingTable().GetPeerInfos()
+	buckets := make(map[int][]DHTPeerInfo)
+	for _, info := range peerInfos {
+		cpl := kbucket.CommonPrefixLen(localID, kbucket.ConvertPeerID(info.Id))
+		addrs := p.host.Peerstore().Addrs(info.Id)
+		peerInfo := DHTPeerInfo{
+			PeerID:                        info.Id.String(),
+			UserAgent:                     p.PeerUserAgent(info.Id),
+			Connected:                     p.IsConnected(info.Id),
+			Addrs:                         make([]string, 0, len(addrs)),
+			AddedAt:                       info.AddedAt,
+			LastUsefulAt:                  info.LastUsefulAt,
+			LastSuccessfulOutboundQueryAt: info.LastSuccessfulOutboundQueryAt,
+		}
+		for _, addr := range addrs {
+			peerInfo.Addrs = append(peerInfo.Addrs, addr.String())
+		}
+		sort.Strings(peerInfo.Addrs)
+		buckets[cpl] = append(buckets[cpl], peerInfo)
+	}
+
+	table := DHTRoutingTable{
+		LocalPeerID: p.host.ID().String(),
+		Size:        len(peerInfos),
+		Buckets:     make([]DHTBucket, 0, len(buckets)),
+	}
+	for cpl, peers := range buckets {
+		sort.Slice(peers, func(i, j int) bool {
+			return peers[i].AddedAt.Before(peers[j].AddedAt)
+		})
+		table.Buckets = append(table.Buckets, DHTBucket{CommonPrefixLen: cpl, Peers: peers})
+	}
+	sort.Slice(table.Buckets, func(i, j int) bool {
+		return table.Buckets[i].CommonPrefixLen < table.Buckets[j].CommonPrefixLen
+	})
+
+	return table
+}
@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	diagnosticsLookupTimeout = 15 * time.Second
+	diagnosticsDialTimeout   = 15 * time.Second
+	// DiagnosticsTimeout is the longest time of DiagnosePeer.
+	DiagnosticsTimeout = diagnosticsLookupTimeout + diagnosticsDialTimeout
+
+	maxHolePunchesPerPeer    = 5
+	maxHolePunchHistoryPeers = 256
+)
+
+// PeerDiagnostics describes how we are connected to the peer or why we are not, see DiagnosePeer.
+type PeerDiagnostics struct {
+	PeerID    string
+	UserAgent string
+	Connected bool
+	// ConnectionType is empty if the peer is disconnected
+	ConnectionType  string `enums:",direct,relayed"`
+	LocalConnection bool
+	Connections     []ConnectionInfo
+	// PeerstoreAddrs are known addresses of the peer, they are dialed by libp2p
+	PeerstoreAddrs []string
+	// LocalAddrs are interface addresses sent by the peer, see MaintainLocalConnections
+	LocalAddrs    []string
+	Reachability  string `enums:"Unknown,Public,Private"`
+	ObservedAddrs []string
+	// HolePunches are recent hole punching attempts with the peer, oldest first
+	HolePunches []HolePunchResult
+	DHTLookup   PeerLookupResult
+	// Dial is set if the peer was disconnected, so it was dialed during diagnostics
+	Dial *PeerDialResult `json:",omitempty"`
+}
+
+type PeerLookupResult struct {
+	Addrs   []string
+	Error   string        `json:",omitempty"`
+	Elapsed time.Duration `swaggertype:"primitive,integer"`
+}
+
+type PeerDialResult struct {
+	// Addrs are addresses which were dialed, including synthesized NAT64 ones
+	Addrs   []string
+	Error   string          `json:",omitempty"`
+	Errors  []AddrDialError `json:",omitempty"`
+	Elapsed time.Duration   `swaggertype:"primitive,integer"`
+}
+
+type AddrDialError struct {
+	Addr  string
+	Error string
+}
+
+// DiagnosePeer looks up the peer in DHT and dials it, if it's disconnected, then collects current state of connections
+// with the peer. Hole punching history is kept since the start for the peers with recent attempts.
+func (p *P2p) DiagnosePeer(ctx context.Context, peerID peer.ID) PeerDiagnostics {
+	result := PeerDiagnostics{
+		PeerID:      peerID.String(),
+		HolePunches: p.holePunches.get(peerID),
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, diagnosticsLookupTimeout)
+	started := time.Now()
+	info, err := p.FindPeer(lookupCtx, peerID)
+	cancel()
+	result.DHTLookup = PeerLookupResult{Addrs: multiaddrsToStrings(info.Addrs), Elapsed: time.Since(started)}
+	if err != nil {
+		result.DHTLookup.Error = err.Error()
+	}
+
+	if !p.IsConnected(peerID) {
+		dialResult := p.diagnosticDial(ctx, peerID)
+		result.Dial = &dialResult
+	}
+
+	conns := p.connsToPeer(peerID)
+	result.Connected = len(conns) > 0
+	result.ConnectionType = ConnectionType(conns)
+	result.LocalConnection = hasLocalConn(conns, p.localNets())
+	result.Connections = p.PeerConnectionsInfo(peerID)
+	result.UserAgent = p.PeerUserAgent(peerID)
+	result.PeerstoreAddrs = multiaddrsToStrings(p.host.Peerstore().Addrs(peerID))
+	result.LocalAddrs = multiaddrsToStrings(p.localPeers.get(peerID))
+	result.Reachability = p.Reachability().String()
+	result.ObservedAddrs = multiaddrsToStrings(p.OwnObservedAddrs())
+
+	return result
+}
+
+// diagnosticDial dials known addresses of the peer, addresses found by DHT lookup are already added to the peerstore.
+func (p *P2p) diagnosticDial(ctx context.Context, peerID peer.ID) PeerDialResult {
+	ctx, cancel := context.WithTimeout(ctx, diagnosticsDialTimeout)
+	defer cancel()
+
+	info := p.withNAT64Addrs(peer.AddrInfo{ID: peerID, Addrs: p.host.Peerstore().Addrs(peerID)})
+	result := PeerDialResult{Addrs: multiaddrsToStrings(info.Addrs)}
+	p.ClearBackoff(peerID)
+	started := time.Now()
+	err := p.host.Connect(ctx, info)
+	result.Elapsed = time.Since(started)
+	if err == nil {
+		return result
+	}
+
+	result.Error = err.Error()
+	var dialErr *swarm.DialError
+	if errors.As(err, &dialErr) {
+		for _, transportErr := range dialErr.DialErrors {
+			result.Errors = append(result.Errors, AddrDialError{
+				Addr:  transportErr.Address.String(),
+				Error: transportErr.Cause.Error(),
+			})
+		}
+	}
+	return result
+}
+
+// holePunchHistory keeps recent hole punching attempts of peers, it's bounded, since attempts are made with any peer.
+type holePunchHistory struct {
+	lock    sync.Mutex
+	results map[peer.ID][]HolePunchResult
+}
+
+func newHolePunchHistory() *holePunchHistory {
+	return &holePunchHistory{results: make(map[peer.ID][]HolePunchResult)}
+}
+
+func (h *holePunchHistory) add(result HolePunchResult) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	results, exists := h.results[result.PeerID]
+	if !exists && len(h.results) >= maxHolePunchHistoryPeers {
+		h.removeOldest()
+	}
+	if len(results) == maxHolePunchesPerPeer {
+		results = append(results[:0:0], results[1:]...)
+	}
+	h.results[result.PeerID] = append(results, result)
+}
+
+// removeOldest removes the peer with the oldest last attempt.
+func (h *holePunchHistory) removeOldest() {
+	var oldestPeer peer.ID
+	var oldest time.Time
+	for peerID, results := range h.results {
+		last := results[len(results)-1].Time
+		if oldestPeer == "" || last.Before(oldest) {
+			oldestPeer, oldest = peerID, last
+		}
+	}
+	delete(h.results, oldestPeer)
+}
+
+func (h *holePunchHistory) get(peerID peer.ID) []HolePunchResult {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]HolePunchResult{}, h.results[peerID]...)
+}
+
+func multiaddrsToStrings(addrs []multiaddr.Multiaddr) []string {
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, addr.String())
+	}
+	sort.Strings(result)
+	return result
+}
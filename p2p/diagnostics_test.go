@@ -0,0 +1,37 @@
+package p2p
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHolePunchHistory(t *testing.T) {
+	a := require.New(t)
+	h := newHolePunchHistory()
+	now := time.Now()
+	peerID := peer.ID("peer")
+
+	for i := 0; i < maxHolePunchesPerPeer+2; i++ {
+		h.add(HolePunchResult{PeerID: peerID, Error: strconv.Itoa(i), Time: now.Add(time.Duration(i) * time.Second)})
+	}
+	results := h.get(peerID)
+	a.Len(results, maxHolePunchesPerPeer)
+	// the oldest attempts are removed
+	a.Equal("2", results[0].Error)
+	a.Equal(strconv.Itoa(maxHolePunchesPerPeer+1), results[len(results)-1].Error)
+	a.Empty(h.get("other"))
+
+	// the peer with the oldest last attempt is removed when the history is full
+	for i := 1; i < maxHolePunchHistoryPeers; i++ {
+		h.add(HolePunchResult{PeerID: peer.ID(strconv.Itoa(i)), Time: now.Add(time.Hour)})
+	}
+	a.Len(h.results, maxHolePunchHistoryPeers)
+	h.add(HolePunchResult{PeerID: "new", Time: now.Add(time.Hour)})
+	a.Len(h.results, maxHolePunchHistoryPeers)
+	a.Empty(h.get(peerID))
+	a.Len(h.get("new"), 1)
+}
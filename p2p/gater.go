@@ -0,0 +1,87 @@
+package p2p
+
+import (
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// PeerFilter reports whether connection with the peer is allowed. Inbound is true for connections initiated by the peer,
+// infrastructure is true for bootstrap peers and fallback relays. RemoteAddr is nil for outbound connections.
+type PeerFilter func(peerID peer.ID, remoteAddr multiaddr.Multiaddr, inbound, infrastructure bool) bool
+
+// connectionGater refuses connections of peers rejected by PeerFilter. Peer id of inbound connection is known
+// only after security handshake, so they are checked in InterceptSecured, outbound are checked before dialing.
+type connectionGater struct {
+	filter         PeerFilter
+	infrastructure atomic.Pointer[map[peer.ID]struct{}]
+	refused        atomic.Int64
+}
+
+func newConnectionGater(filter PeerFilter, infrastructurePeers ...[]peer.AddrInfo) *connectionGater {
+	g := &connectionGater{
+		filter: filter,
+	}
+	g.setInfrastructure(infrastructurePeers...)
+	return g
+}
+
+// setInfrastructure replaces infrastructure peers, e.g. after bootstrap peers are changed.
+func (g *connectionGater) setInfrastructure(infrastructurePeers ...[]peer.AddrInfo) {
+	infrastructure := make(map[peer.ID]struct{})
+	for _, peers := range infrastructurePeers {
+		for _, info := range peers {
+			infrastructure[info.ID] = struct{}{}
+		}
+	}
+	g.infrastructure.Store(&infrastructure)
+}
+
+func (g *connectionGater) allow(peerID peer.ID, remoteAddr multiaddr.Multiaddr, inbound bool) bool {
+	_, infrastructure := (*g.infrastructure.Load())[peerID]
+	if g.filter(peerID, remoteAddr, inbound, infrastructure) {
+		return true
+	}
+	g.refused.Add(1)
+	return false
+}
+
+func (g *connectionGater) InterceptPeerDial(peerID peer.ID) bool {
+	return g.allow(peerID, nil, false)
+}
+
+func (g *connectionGater) InterceptAddrDial(peer.ID, multiaddr.Multiaddr) bool {
+	return true
+}
+
+func (g *connectionGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *connectionGater) InterceptSecured(direction network.Direction, peerID peer.ID, addrs network.ConnMultiaddrs) bool {
+	if direction == network.DirOutbound {
+		// already checked by InterceptPeerDial
+		return true
+	}
+	return g.allow(peerID, addrs.RemoteMultiaddr(), true)
+}
+
+func (g *connectionGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// RefusedConnections returns the number of connections refused by HostConfig.PeerFilter.
+func (p *P2p) RefusedConnections() int64 {
+	if p.gater == nil {
+		return 0
+	}
+	return p.gater.refused.Load()
+}
+
+// ClosePeer closes all connections with the peer, e.g. after it was denied by HostConfig.PeerFilter.
+func (p *P2p) ClosePeer(peerID peer.ID) error {
+	return p.host.Network().ClosePeer(peerID)
+}
@@ -0,0 +1,219 @@
+package p2p
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// greylistAttempts are inbound connections and auth requests allowed for an unknown peer per greylistWindow
+	greylistAttempts = 10
+	// greylistIPAttempts are allowed for all unknown peers with the same remote ip, e.g. a node with random peer ids
+	// or peers behind the same NAT
+	greylistIPAttempts = 50
+	greylistWindow     = time.Minute
+	minGreylistDelay   = time.Minute
+	maxGreylistDelay   = time.Hour
+	// maxGreylistEntries bounds memory used by nodes with random peer ids, the least recently seen entry is evicted above it
+	maxGreylistEntries = 10000
+)
+
+type GreylistStats struct {
+	// TrackedPeers is the number of unknown peers which connected recently
+	TrackedPeers int
+	// GreylistedPeers is the number of unknown peers which are refused now
+	GreylistedPeers int
+	// GreylistedIPs is the number of remote ips which connections of unknown peers are refused now
+	GreylistedIPs       int
+	RefusedConnections  int64
+	RefusedAuthRequests int64
+}
+
+// greylist limits inbound connections and auth requests of unknown peers, so a scanning or buggy node can't consume
+// our resources. Attempts are limited per peer and per remote ip, so a node can't avoid the limit with random peer ids.
+type greylist struct {
+	peers *greylistEntries
+	ips   *greylistEntries
+
+	refusedConnections  atomic.Int64
+	refusedAuthRequests atomic.Int64
+}
+
+func newGreylist() *greylist {
+	return &greylist{
+		peers: newGreylistEntries(greylistAttempts, maxGreylistEntries),
+		ips:   newGreylistEntries(greylistIPAttempts, maxGreylistEntries),
+	}
+}
+
+// allow counts the attempt of the peer from ip, nil ip isn't limited, e.g. for relayed connections.
+// Attempts of the refused peer aren't counted for its ip, so a buggy node doesn't block other peers behind its NAT.
+func (g *greylist) allow(peerID peer.ID, ip net.IP, now time.Time) bool {
+	return g.peers.allow(string(peerID), now) && (ip == nil || g.ips.allow(ip.String(), now))
+}
+
+func (g *greylist) stats(now time.Time) GreylistStats {
+	trackedPeers, greylistedPeers := g.peers.stats(now)
+	_, greylistedIPs := g.ips.stats(now)
+	return GreylistStats{
+		TrackedPeers:        trackedPeers,
+		GreylistedPeers:     greylistedPeers,
+		GreylistedIPs:       greylistedIPs,
+		RefusedConnections:  g.refusedConnections.Load(),
+		RefusedAuthRequests: g.refusedAuthRequests.Load(),
+	}
+}
+
+// greylistEntries refuses the key which exceeds attempts per greylistWindow for a delay, the delay is doubled every time
+// the key exceeds the limit again, up to maxGreylistDelay. It's reset after maxGreylistDelay without attempts.
+// Entries are kept in LRU order, so stale and evicted ones are removed in constant time.
+type greylistEntries struct {
+	attempts   int
+	maxEntries int
+
+	lock sync.Mutex
+	// order has the most recently seen entries at front
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type greylistEntry struct {
+	key         string
+	windowStart time.Time
+	attempts    int
+	lastAttempt time.Time
+	delay       time.Duration
+	until       time.Time
+}
+
+func newGreylistEntries(attempts, maxEntries int) *greylistEntries {
+	return &greylistEntries{
+		attempts:   attempts,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (g *greylistEntries) allow(key string, now time.Time) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.removeStale(now)
+	elem, exists := g.entries[key]
+	if exists {
+		g.order.MoveToFront(elem)
+	} else {
+		if g.order.Len() >= g.maxEntries {
+			g.remove(g.order.Back())
+		}
+		elem = g.order.PushFront(&greylistEntry{key: key, windowStart: now})
+		g.entries[key] = elem
+	}
+	entry := elem.Value.(*greylistEntry)
+
+	// attempts of greylisted key don't prolong the delay, otherwise a buggy node which retries in a loop is never allowed
+	if now.Before(entry.until) {
+		return false
+	}
+	if now.Sub(entry.lastAttempt) > maxGreylistDelay {
+		entry.delay = 0
+	}
+	entry.lastAttempt = now
+	if now.Sub(entry.windowStart) >= greylistWindow {
+		entry.windowStart, entry.attempts = now, 0
+	}
+	entry.attempts++
+	if entry.attempts <= g.attempts {
+		return true
+	}
+
+	entry.delay = min(max(entry.delay*2, minGreylistDelay), maxGreylistDelay)
+	entry.until = now.Add(entry.delay)
+	entry.windowStart, entry.attempts = entry.until, 0
+	return false
+}
+
+// removeStale removes the least recently seen entries until one which is greylisted or could be greylisted again.
+func (g *greylistEntries) removeStale(now time.Time) {
+	for elem := g.order.Back(); elem != nil && elem.Value.(*greylistEntry).stale(now); elem = g.order.Back() {
+		g.remove(elem)
+	}
+}
+
+func (g *greylistEntries) remove(elem *list.Element) {
+	g.order.Remove(elem)
+	delete(g.entries, elem.Value.(*greylistEntry).key)
+}
+
+// stale is true if the entry is not greylisted and either never exceeded the limit or was quiet long enough to reset delay.
+func (e *greylistEntry) stale(now time.Time) bool {
+	if now.Before(e.until) {
+		return false
+	}
+	quiet := now.Sub(e.lastAttempt)
+	return quiet > maxGreylistDelay || e.delay == 0 && quiet >= greylistWindow
+}
+
+func (g *greylistEntries) stats(now time.Time) (tracked, greylisted int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.removeStale(now)
+	for elem := g.order.Front(); elem != nil; elem = elem.Next() {
+		if now.Before(elem.Value.(*greylistEntry).until) {
+			greylisted++
+		}
+	}
+	return g.order.Len(), greylisted
+}
+
+// greylistIP returns ip which attempts of unknown peers are limited by, nil for relayed connections since
+// their address is the relay one.
+func greylistIP(addr multiaddr.Multiaddr) net.IP {
+	if addr == nil || isCircuitAddr(addr) {
+		return nil
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return nil
+	}
+	return ip
+}
+
+// AllowUnknownConnection reports whether inbound connection of the peer, which is not our friend, is allowed by greylist.
+// Refused connections are counted in both GreylistStats and ConnectionsStats.RefusedCount.
+func (p *P2p) AllowUnknownConnection(peerID peer.ID, remoteAddr multiaddr.Multiaddr) bool {
+	if !p.greylist.allow(peerID, greylistIP(remoteAddr), time.Now()) {
+		p.greylist.refusedConnections.Add(1)
+		return false
+	}
+	return true
+}
+
+// AllowUnknownAuthRequest reports whether auth request of the peer, which is not our friend, is allowed by greylist.
+// Connections and auth requests of the peer share the same limit.
+func (p *P2p) AllowUnknownAuthRequest(peerID peer.ID) bool {
+	var ip net.IP
+	for _, conn := range p.connsToPeer(peerID) {
+		if ip = greylistIP(conn.RemoteMultiaddr()); ip != nil {
+			break
+		}
+	}
+	if !p.greylist.allow(peerID, ip, time.Now()) {
+		p.greylist.refusedAuthRequests.Add(1)
+		return false
+	}
+	return true
+}
+
+func (p *P2p) GreylistStats() GreylistStats {
+	return p.greylist.stats(time.Now())
+}
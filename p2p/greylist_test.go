@@ -0,0 +1,107 @@
+package p2p
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGreylist(t *testing.T) {
+	a := require.New(t)
+	g := newGreylist()
+	now := time.Now()
+	peerID, other := peer.ID("peer"), peer.ID("other")
+
+	for i := 0; i < greylistAttempts; i++ {
+		a.True(g.allow(peerID, nil, now))
+	}
+	a.False(g.allow(peerID, nil, now))
+	a.True(g.allow(other, nil, now))
+	a.Equal(GreylistStats{TrackedPeers: 2, GreylistedPeers: 1}, g.stats(now))
+
+	// attempts during the delay don't prolong it
+	a.False(g.allow(peerID, nil, now.Add(minGreylistDelay/2)))
+	now = now.Add(minGreylistDelay)
+	for i := 0; i < greylistAttempts; i++ {
+		a.True(g.allow(peerID, nil, now))
+	}
+	// the delay is doubled after the next violation
+	a.False(g.allow(peerID, nil, now))
+	a.False(g.allow(peerID, nil, now.Add(2*minGreylistDelay-time.Second)))
+	now = now.Add(2 * minGreylistDelay)
+	a.True(g.allow(peerID, nil, now))
+
+	// attempts are counted per window
+	now = now.Add(greylistWindow)
+	for i := 0; i < greylistAttempts; i++ {
+		a.True(g.allow(peerID, nil, now))
+	}
+	now = now.Add(greylistWindow)
+	a.True(g.allow(peerID, nil, now))
+
+	// the delay is reset after a quiet period
+	now = now.Add(maxGreylistDelay + time.Second)
+	for i := 0; i < greylistAttempts; i++ {
+		a.True(g.allow(peerID, nil, now))
+	}
+	a.False(g.allow(peerID, nil, now))
+	a.Equal(now.Add(minGreylistDelay), g.peers.entries[string(peerID)].Value.(*greylistEntry).until)
+
+	// quiet peers are forgotten
+	a.Equal(GreylistStats{TrackedPeers: 1}, g.stats(now.Add(2*minGreylistDelay)))
+	a.Zero(g.stats(now.Add(maxGreylistDelay + time.Second)).TrackedPeers)
+}
+
+func TestGreylistMaxEntries(t *testing.T) {
+	a := require.New(t)
+	g := newGreylist()
+	now := time.Now()
+	for i := 0; i < maxGreylistEntries; i++ {
+		a.True(g.allow(peer.ID(strconv.Itoa(i)), nil, now))
+	}
+	// the least recently seen peer is evicted for a new one, so new peers are still limited
+	a.True(g.allow(peer.ID("0"), nil, now))
+	for i := 0; i < greylistAttempts; i++ {
+		a.True(g.allow(peer.ID("new"), nil, now))
+	}
+	a.False(g.allow(peer.ID("new"), nil, now))
+	a.Len(g.peers.entries, maxGreylistEntries)
+	a.NotContains(g.peers.entries, "1")
+	a.Contains(g.peers.entries, "0")
+
+	// peers which never exceeded the limit are removed when they are stale
+	now = now.Add(greylistWindow)
+	a.True(g.allow(peer.ID("other"), nil, now))
+	a.Len(g.peers.entries, 2)
+}
+
+func TestGreylistIP(t *testing.T) {
+	a := require.New(t)
+	g := newGreylist()
+	now := time.Now()
+	ip, otherIP := net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2")
+
+	// random peer ids from the same ip
+	for i := 0; i < greylistIPAttempts; i++ {
+		a.True(g.allow(peer.ID(strconv.Itoa(i)), ip, now))
+	}
+	a.False(g.allow(peer.ID("new"), ip, now))
+	a.True(g.allow(peer.ID("new"), otherIP, now))
+	a.True(g.allow(peer.ID("relayed"), nil, now))
+	a.Equal(1, g.stats(now).GreylistedIPs)
+
+	// attempts of the greylisted peer aren't counted for its ip
+	for i := 0; i <= greylistAttempts; i++ {
+		g.allow(peer.ID("buggy"), otherIP, now)
+	}
+	a.False(g.allow(peer.ID("buggy"), otherIP, now))
+	a.True(g.allow(peer.ID("neighbour"), otherIP, now))
+
+	a.Nil(greylistIP(multiaddr.StringCast("/ip4/203.0.113.3/tcp/4001/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN/p2p-circuit")))
+	a.Equal("203.0.113.3", greylistIP(multiaddr.StringCast("/ip4/203.0.113.3/udp/4001/quic-v1")).String())
+}
@@ -0,0 +1,194 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Connection types of peers, see PeerConnectionType.
+const (
+	ConnectionTypeDirect  = "direct"
+	ConnectionTypeRelayed = "relayed"
+)
+
+const (
+	directUpgradeCheckInterval = time.Minute
+	// minDirectUpgradeInterval gives time to hole punching which is started by libp2p for new relayed connections
+	minDirectUpgradeInterval = 2 * time.Minute
+	maxDirectUpgradeInterval = time.Hour
+	directUpgradeTimeout     = 15 * time.Second
+)
+
+var errDirectUpgradePending = errors.New("reconnected through relay, hole punching is started by the peer")
+
+// HolePunchResult is the result of a single hole punching attempt, see HostConfig.OnHolePunch.
+type HolePunchResult struct {
+	PeerID  peer.ID
+	Success bool
+	Error   string
+	Elapsed time.Duration
+	Time    time.Time
+}
+
+// holePunchTracer passes results of hole punching attempts to the handler, other events are only logged.
+type holePunchTracer struct {
+	p *P2p
+}
+
+func (t holePunchTracer) Trace(evt *holepunch.Event) {
+	var result HolePunchResult
+	switch e := evt.Evt.(type) {
+	case *holepunch.EndHolePunchEvt:
+		result = HolePunchResult{PeerID: evt.Remote, Success: e.Success, Error: e.Error, Elapsed: e.EllapsedTime, Time: time.Unix(0, evt.Timestamp)}
+	case *holepunch.ProtocolErrorEvt:
+		result = HolePunchResult{PeerID: evt.Remote, Error: e.Error, Time: time.Unix(0, evt.Timestamp)}
+	default:
+		t.p.logger.Debugf("hole punching with %s: %s", evt.Remote, evt.Type)
+		return
+	}
+	if result.Success {
+		t.p.logger.Infof("hole punching with %s succeeded in %s", result.PeerID, result.Elapsed.Round(time.Millisecond))
+	} else {
+		t.p.logger.Debugf("hole punching with %s failed: %s", result.PeerID, result.Error)
+	}
+	t.p.holePunches.add(result)
+	if t.p.onHolePunch != nil {
+		t.p.onHolePunch(result)
+	}
+}
+
+// ConnectionType returns ConnectionTypeDirect if any of connections is direct, empty string for no connections.
+func ConnectionType(conns []network.Conn) string {
+	if len(conns) == 0 {
+		return ""
+	}
+	for _, conn := range conns {
+		if !isRelayedConn(conn) {
+			return ConnectionTypeDirect
+		}
+	}
+	return ConnectionTypeRelayed
+}
+
+// PeerConnectionType returns ConnectionTypeDirect or ConnectionTypeRelayed, empty string if the peer is disconnected.
+func (p *P2p) PeerConnectionType(peerID peer.ID) string {
+	return ConnectionType(p.connsToPeer(peerID))
+}
+
+func isRelayedConn(conn network.Conn) bool {
+	_, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+// directUpgradeState tracks attempts to replace relayed connections with the peer by direct ones.
+// Interval is doubled after each failed attempt and reset when the peer is connected directly.
+type directUpgradeState struct {
+	interval    time.Duration
+	nextAttempt time.Time
+}
+
+func newDirectUpgradeState(now time.Time) *directUpgradeState {
+	return &directUpgradeState{
+		interval:    minDirectUpgradeInterval,
+		nextAttempt: now.Add(minDirectUpgradeInterval),
+	}
+}
+
+func (s *directUpgradeState) onFailed(now time.Time) {
+	s.interval = min(s.interval*2, maxDirectUpgradeInterval)
+	s.nextAttempt = now.Add(s.interval)
+}
+
+// MaintainDirectConnections tries to upgrade relayed connections with known peers to direct ones, so NATed peers
+// don't stay on relay if hole punching failed when they were connected, e.g. because one of them had no observed address yet.
+func (p *P2p) MaintainDirectConnections(ctx context.Context, knownPeersIdsFunc func() []peer.ID) {
+	states := make(map[peer.ID]*directUpgradeState)
+	ticker := time.NewTicker(directUpgradeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		relayedPeers := make(map[peer.ID]struct{})
+		var wg sync.WaitGroup
+		for _, peerID := range knownPeersIdsFunc() {
+			if p.PeerConnectionType(peerID) != ConnectionTypeRelayed {
+				continue
+			}
+			relayedPeers[peerID] = struct{}{}
+			state, exists := states[peerID]
+			if !exists {
+				states[peerID] = newDirectUpgradeState(now)
+				continue
+			}
+			if now.Before(state.nextAttempt) {
+				continue
+			}
+
+			wg.Add(1)
+			go func(peerID peer.ID, state *directUpgradeState) {
+				defer wg.Done()
+				err := p.upgradeToDirect(ctx, peerID)
+				if err != nil {
+					state.onFailed(time.Now())
+					p.logger.Debugf("upgrade relayed connection with %s: %v, next attempt in %s", peerID, err, state.interval)
+				}
+			}(peerID, state)
+		}
+		wg.Wait()
+
+		for peerID := range states {
+			if _, relayed := relayedPeers[peerID]; !relayed {
+				delete(states, peerID)
+			}
+		}
+	}
+}
+
+// upgradeToDirect dials the peer directly, it works if the peer got public address since it was connected.
+// Otherwise, relayed connections initiated by us are reopened, so the peer starts hole punching for the new one.
+// Relayed connections initiated by the peer are left to the peer.
+func (p *P2p) upgradeToDirect(ctx context.Context, peerID peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, directUpgradeTimeout)
+	defer cancel()
+
+	p.ClearBackoff(peerID)
+	err := p.host.Connect(network.WithForceDirectDial(ctx, "upgrade relayed connection"), peer.AddrInfo{ID: peerID})
+	if err == nil {
+		p.logger.Infof("upgraded relayed connection with %s to direct one", peerID)
+		return nil
+	}
+
+	var outbound []network.Conn
+	for _, conn := range p.connsToPeer(peerID) {
+		if isRelayedConn(conn) && conn.Stat().Direction == network.DirOutbound {
+			outbound = append(outbound, conn)
+		}
+	}
+	if len(outbound) == 0 {
+		return err
+	}
+	for _, conn := range outbound {
+		_ = conn.Close()
+	}
+	err = p.host.Connect(ctx, peer.AddrInfo{ID: peerID})
+	if err != nil {
+		return err
+	}
+	if p.PeerConnectionType(peerID) != ConnectionTypeDirect {
+		return errDirectUpgradePending
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectUpgradeState(t *testing.T) {
+	now := time.Now()
+	state := newDirectUpgradeState(now)
+	require.Equal(t, minDirectUpgradeInterval, state.interval)
+	require.Equal(t, now.Add(minDirectUpgradeInterval), state.nextAttempt)
+
+	state.onFailed(now)
+	require.Equal(t, 2*minDirectUpgradeInterval, state.interval)
+	require.Equal(t, now.Add(2*minDirectUpgradeInterval), state.nextAttempt)
+
+	for i := 0; i < 20; i++ {
+		state.onFailed(now)
+	}
+	require.Equal(t, maxDirectUpgradeInterval, state.interval)
+}
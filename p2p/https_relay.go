@@ -0,0 +1,84 @@
+package p2p
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const protectedFallbackRelayTag = "fallback-relay"
+
+// HTTPSRelayServerConfig enables serving as a fallback relay over secure websocket,
+// which looks like regular https traffic for firewalls.
+type HTTPSRelayServerConfig struct {
+	// ListenAddr is a websocket multiaddr, e.g. /ip4/0.0.0.0/tcp/443/wss
+	ListenAddr multiaddr.Multiaddr
+	TLSConfig  *tls.Config
+}
+
+func httpsRelayOptions(hostConfig HostConfig) ([]libp2p.Option, []multiaddr.Multiaddr) {
+	var wsOpts []interface{}
+	var listenAddrs []multiaddr.Multiaddr
+	var opts []libp2p.Option
+	if server := hostConfig.HTTPSRelayServer; server != nil {
+		wsOpts = append(wsOpts, websocket.WithTLSConfig(server.TLSConfig))
+		listenAddrs = append(listenAddrs, server.ListenAddr)
+		opts = append(opts, libp2p.EnableRelayService())
+	}
+	// websocket transport is always enabled to be able to dial fallback relays
+	opts = append(opts, libp2p.Transport(websocket.New, wsOpts...))
+
+	return opts, listenAddrs
+}
+
+// FallbackRelaysActive returns true if bootstrap peers are unreachable and fallback https relays are used instead.
+func (p *P2p) FallbackRelaysActive() bool {
+	return p.fallbackRelaysActive.Load()
+}
+
+// updateFallbackRelays connects to fallback relays when we could not connect to any bootstrap peer
+// and releases them after bootstrap peers become reachable again.
+func (p *P2p) updateFallbackRelays(ctx context.Context) {
+	if len(p.fallbackRelays) == 0 {
+		return
+	}
+	_, connectedBootstrapPeersCount := p.BootstrapPeersStats()
+	if connectedBootstrapPeersCount > 0 {
+		if p.fallbackRelaysActive.CompareAndSwap(true, false) {
+			p.logger.Info("bootstrap peers are reachable again, stop using fallback https relays")
+			p.selectedRelays.Store(nil)
+			for _, relay := range p.fallbackRelays {
+				p.host.ConnManager().Unprotect(relay.ID, protectedFallbackRelayTag)
+			}
+		}
+		return
+	}
+
+	if p.fallbackRelaysActive.CompareAndSwap(false, true) {
+		p.logger.Warn("bootstrap peers are unreachable over QUIC and TCP, using fallback https relays")
+		p.selectedRelays.Store(nil)
+	}
+	var wg sync.WaitGroup
+	for _, relay := range p.fallbackRelays {
+		wg.Add(1)
+		relay := relay
+		p.host.ConnManager().Protect(relay.ID, protectedFallbackRelayTag)
+		go func() {
+			defer wg.Done()
+			if p.IsConnected(relay.ID) {
+				return
+			}
+			p.ClearBackoff(relay.ID)
+			if err := p.host.Connect(ctx, p.withNAT64Addrs(relay)); err != nil {
+				p.logger.Warnf("failed to connect to fallback relay %s: %v", relay.ID, err)
+			} else {
+				p.logger.Infof("connection established with fallback relay %s", relay.ID)
+			}
+		}()
+	}
+	wg.Wait()
+}
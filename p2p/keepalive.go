@@ -0,0 +1,150 @@
+package p2p
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// Most consumer routers keep UDP mappings for at least 30 seconds, but some drop them after 20-30 seconds.
+	defaultNATKeepaliveInterval = 25 * time.Second
+	minNATKeepaliveInterval     = 10 * time.Second
+	maxNATKeepaliveInterval     = 2 * time.Minute
+	natKeepaliveCheckInterval   = 5 * time.Second
+	natKeepalivePingTimeout     = 5 * time.Second
+	// natKeepaliveStableRounds is the number of successful keepalives before the interval is increased
+	natKeepaliveStableRounds = 5
+)
+
+// natKeepaliveState tracks keepalive interval for a single peer.
+// Interval is halved when the mapping is observed to expire and slowly increased while it is stable.
+type natKeepaliveState struct {
+	interval     time.Duration
+	stableRounds int
+	lastActive   time.Time
+	lastBytesIn  int64
+	hadUDPConn   bool
+}
+
+func newNATKeepaliveState(now time.Time) *natKeepaliveState {
+	return &natKeepaliveState{
+		interval:   defaultNATKeepaliveInterval,
+		lastActive: now,
+	}
+}
+
+func (s *natKeepaliveState) onStable() {
+	s.stableRounds++
+	if s.stableRounds < natKeepaliveStableRounds {
+		return
+	}
+	s.stableRounds = 0
+	s.interval += s.interval / 4
+	if s.interval > maxNATKeepaliveInterval {
+		s.interval = maxNATKeepaliveInterval
+	}
+}
+
+func (s *natKeepaliveState) onExpired() {
+	s.stableRounds = 0
+	s.interval /= 2
+	if s.interval < minNATKeepaliveInterval {
+		s.interval = minNATKeepaliveInterval
+	}
+}
+
+// MaintainNATKeepalive pings known peers over idle direct UDP paths to keep NAT mappings open.
+// Keepalives are less frequent in power save mode, so idle paths could be dropped by NAT and restored by hole punching.
+func (p *P2p) MaintainNATKeepalive(ctx context.Context, knownPeersIdsFunc func() []peer.ID) {
+	states := make(map[peer.ID]*natKeepaliveState)
+	ticker := time.NewTicker(natKeepaliveCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		knownPeers := make(map[peer.ID]struct{})
+		var wg sync.WaitGroup
+		for _, peerID := range knownPeersIdsFunc() {
+			knownPeers[peerID] = struct{}{}
+			state, exists := states[peerID]
+			if !exists {
+				state = newNATKeepaliveState(now)
+				states[peerID] = state
+			}
+
+			hasUDPConn := p.hasDirectUDPConn(peerID)
+			// peer is still connected through relay, so the direct path was most likely dropped by NAT
+			if state.hadUDPConn && !hasUDPConn && p.IsConnected(peerID) {
+				state.onExpired()
+				p.logger.Debugf("nat keepalive: lost udp path to %s, decreased interval to %s", peerID, state.interval)
+			}
+			state.hadUDPConn = hasUDPConn
+			if !hasUDPConn {
+				continue
+			}
+
+			bytesIn := p.bandwidthCounter.GetBandwidthForPeer(peerID).TotalIn
+			if bytesIn != state.lastBytesIn {
+				state.lastBytesIn = bytesIn
+				state.lastActive = now
+				continue
+			}
+			if now.Sub(state.lastActive) < p.PowerSaveInterval(state.interval) {
+				continue
+			}
+
+			wg.Add(1)
+			go func(peerID peer.ID, state *natKeepaliveState) {
+				defer wg.Done()
+				p.natKeepalivePing(ctx, peerID, state)
+			}(peerID, state)
+		}
+		wg.Wait()
+
+		for peerID := range states {
+			if _, known := knownPeers[peerID]; !known {
+				delete(states, peerID)
+			}
+		}
+	}
+}
+
+func (p *P2p) natKeepalivePing(ctx context.Context, peerID peer.ID, state *natKeepaliveState) {
+	ctx, cancel := context.WithTimeout(ctx, natKeepalivePingTimeout)
+	defer cancel()
+
+	result := <-ping.Ping(network.WithNoDial(ctx, "nat keepalive"), p.host, peerID)
+	state.lastActive = time.Now()
+	state.lastBytesIn = p.bandwidthCounter.GetBandwidthForPeer(peerID).TotalIn
+	if result.Error != nil {
+		state.onExpired()
+		p.logger.Debugf("nat keepalive: ping %s failed, decreased interval to %s: %v", peerID, state.interval, result.Error)
+		return
+	}
+	state.onStable()
+}
+
+func (p *P2p) hasDirectUDPConn(peerID peer.ID) bool {
+	for _, conn := range p.connsToPeer(peerID) {
+		addr := conn.RemoteMultiaddr()
+		if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+			continue
+		}
+		if _, err := addr.ValueForProtocol(multiaddr.P_UDP); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNATKeepaliveState(t *testing.T) {
+	state := newNATKeepaliveState(time.Now())
+	require.Equal(t, defaultNATKeepaliveInterval, state.interval)
+
+	for i := 0; i < natKeepaliveStableRounds-1; i++ {
+		state.onStable()
+	}
+	require.Equal(t, defaultNATKeepaliveInterval, state.interval)
+	state.onStable()
+	require.Greater(t, state.interval, defaultNATKeepaliveInterval)
+
+	for i := 0; i < 100*natKeepaliveStableRounds; i++ {
+		state.onStable()
+	}
+	require.Equal(t, maxNATKeepaliveInterval, state.interval)
+
+	state.onExpired()
+	require.Equal(t, maxNATKeepaliveInterval/2, state.interval)
+	for i := 0; i < 10; i++ {
+		state.onExpired()
+	}
+	require.Equal(t, minNATKeepaliveInterval, state.interval)
+}
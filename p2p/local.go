@@ -0,0 +1,339 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	localShortcutCheckInterval = time.Minute
+	// localShortcutRetryInterval is long, since addresses of the peer in our local networks are often
+	// addresses of another network with the same private range
+	localShortcutRetryInterval = 30 * time.Minute
+	localShortcutTimeout       = 10 * time.Second
+	// MaxLocalAddrs limits interface addresses which are sent to peers and probed by them.
+	MaxLocalAddrs = 16
+)
+
+var errNoLocalAddrReachable = errors.New("no local address of the peer is reachable")
+
+// localPeerAddrs are interface addresses sent by peers, see AddPeerLocalAddrs.
+type localPeerAddrs struct {
+	lock  sync.RWMutex
+	addrs map[peer.ID][]multiaddr.Multiaddr
+}
+
+func newLocalPeerAddrs() *localPeerAddrs {
+	return &localPeerAddrs{addrs: make(map[peer.ID][]multiaddr.Multiaddr)}
+}
+
+func (l *localPeerAddrs) set(peerID peer.ID, addrs []multiaddr.Multiaddr) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(addrs) == 0 {
+		delete(l.addrs, peerID)
+		return
+	}
+	l.addrs[peerID] = addrs
+}
+
+func (l *localPeerAddrs) get(peerID peer.ID) []multiaddr.Multiaddr {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.addrs[peerID]
+}
+
+// LocalAddrs returns our listen addresses on network interfaces, including loopback. They are sent to known peers,
+// so peers on the same host or in the same local network connect to us directly, see MaintainLocalConnections.
+func (p *P2p) LocalAddrs() []string {
+	addrs, err := p.host.Network().InterfaceListenAddresses()
+	if err != nil {
+		p.logger.Warnf("get interface listen addresses: %v", err)
+		return nil
+	}
+	_, vpnNets := p.vpnNets()
+	result := make([]string, 0, min(len(addrs), MaxLocalAddrs))
+	for _, addr := range addrs {
+		if len(result) == MaxLocalAddrs {
+			break
+		}
+		// link-local addresses can't be dialed without zone
+		if manet.IsIP6LinkLocal(addr) || isCircuitAddr(addr) {
+			continue
+		}
+		if ip := addrIP(addr); ip != nil && containsIP(vpnNets, ip) {
+			continue
+		}
+		result = append(result, addr.String())
+	}
+	return result
+}
+
+// AddPeerLocalAddrs replaces interface addresses of the peer, they are received in its status info.
+// Invalid addresses are skipped, only addresses on our host or in our local networks are dialed.
+func (p *P2p) AddPeerLocalAddrs(peerID peer.ID, addrs []string) {
+	parsed := make([]multiaddr.Multiaddr, 0, min(len(addrs), MaxLocalAddrs))
+	for _, value := range addrs {
+		if len(parsed) == MaxLocalAddrs {
+			break
+		}
+		addr, err := multiaddr.NewMultiaddr(value)
+		if err != nil || isCircuitAddr(addr) {
+			continue
+		}
+		parsed = append(parsed, addr)
+	}
+	p.localPeers.set(peerID, parsed)
+}
+
+// PeerIsLocal reports whether the peer is connected over loopback or one of our local networks.
+func (p *P2p) PeerIsLocal(peerID peer.ID) bool {
+	return hasLocalConn(p.connsToPeer(peerID), p.localNets())
+}
+
+// MaintainLocalConnections moves connections with known peers to loopback or local network, if the peer is on the same
+// host or in the same local network, e.g. several instances for testing or in containers. Otherwise, such peers could
+// stay connected through their public addresses or a relay. Connections are still encrypted, since encryption
+// authenticates peers and addresses of local networks are not trusted.
+func (p *P2p) MaintainLocalConnections(ctx context.Context, knownPeersIdsFunc func() []peer.ID) {
+	retryAt := make(map[peer.ID]time.Time)
+	var retryLock sync.Mutex
+	ticker := time.NewTicker(localShortcutCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		nets := p.localNets()
+		var wg sync.WaitGroup
+		for _, peerID := range knownPeersIdsFunc() {
+			addrs := localShortcutAddrs(p.localPeers.get(peerID), nets)
+			conns := p.connsToPeer(peerID)
+			retryLock.Lock()
+			skip := len(addrs) == 0 || len(conns) == 0 || hasLocalConn(conns, nets) || now.Before(retryAt[peerID])
+			retryLock.Unlock()
+			if skip {
+				continue
+			}
+
+			wg.Add(1)
+			go func(peerID peer.ID) {
+				defer wg.Done()
+				err := p.shortcutToLocal(ctx, peerID, addrs, nets)
+				if err != nil {
+					retryLock.Lock()
+					retryAt[peerID] = time.Now().Add(localShortcutRetryInterval)
+					retryLock.Unlock()
+					p.logger.Debugf("connect %s over local network: %v, next attempt in %s", peerID, err, localShortcutRetryInterval)
+					return
+				}
+				p.logger.Infof("moved connection with %s to local network", peerID)
+			}(peerID)
+		}
+		wg.Wait()
+	}
+}
+
+// shortcutToLocal probes local addresses of the peer with a separate connection, so existing connections are kept
+// if none is reachable. Then other connections are closed and the peer is dialed again through the reachable address.
+func (p *P2p) shortcutToLocal(ctx context.Context, peerID peer.ID, addrs []multiaddr.Multiaddr, nets []*net.IPNet) error {
+	ctx, cancel := context.WithTimeout(ctx, localShortcutTimeout)
+	defer cancel()
+
+	swrm, ok := p.host.Network().(*swarm.Swarm)
+	if !ok {
+		return errors.New("unsupported network")
+	}
+	var reachable multiaddr.Multiaddr
+	err := errNoLocalAddrReachable
+	for _, addr := range addrs {
+		transport := swrm.TransportForDialing(addr)
+		if transport == nil {
+			continue
+		}
+		// the peer is authenticated by security handshake, so it's not another host with the same address
+		conn, dialErr := transport.Dial(ctx, addr, peerID)
+		if dialErr != nil {
+			err = dialErr
+			continue
+		}
+		_ = conn.Close()
+		reachable = addr
+		break
+	}
+	if reachable == nil {
+		return err
+	}
+
+	for _, conn := range p.connsToPeer(peerID) {
+		if !isLocalConn(conn, nets) {
+			_ = conn.Close()
+		}
+	}
+	p.ClearBackoff(peerID)
+	err = p.host.Connect(ctx, peer.AddrInfo{ID: peerID, Addrs: []multiaddr.Multiaddr{reachable}})
+	if err != nil {
+		return err
+	}
+	if !hasLocalConn(p.connsToPeer(peerID), nets) {
+		return errNoLocalAddrReachable
+	}
+	return nil
+}
+
+// localShortcutAddrs returns addresses of the peer which are dialed over loopback or our local networks, loopback first.
+// Loopback addresses are used only if the peer is on the same host, i.e. it has one of our interface addresses.
+func localShortcutAddrs(addrs []multiaddr.Multiaddr, nets []*net.IPNet) []multiaddr.Multiaddr {
+	sameHost := false
+	for _, addr := range addrs {
+		ip := addrIP(addr)
+		if ip == nil || ip.IsLoopback() {
+			continue
+		}
+		for _, ipNet := range nets {
+			if ipNet.IP.Equal(ip) {
+				sameHost = true
+			}
+		}
+	}
+
+	var result []multiaddr.Multiaddr
+	for _, addr := range addrs {
+		ip := addrIP(addr)
+		if ip == nil {
+			continue
+		}
+		if ip.IsLoopback() && sameHost || !ip.IsLoopback() && inLocalNets(ip, nets) {
+			result = append(result, addr)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return addrIP(result[i]).IsLoopback() && !addrIP(result[j]).IsLoopback()
+	})
+	return result
+}
+
+func hasLocalConn(conns []network.Conn, nets []*net.IPNet) bool {
+	for _, conn := range conns {
+		if isLocalConn(conn, nets) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalConn reports whether the connection is direct over loopback or one of our local networks.
+func isLocalConn(conn network.Conn, nets []*net.IPNet) bool {
+	if isRelayedConn(conn) {
+		return false
+	}
+	ip := addrIP(conn.RemoteMultiaddr())
+	return ip != nil && (ip.IsLoopback() || inLocalNets(ip, nets))
+}
+
+func inLocalNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, ipNet := range nets {
+		if !ipNet.IP.IsLoopback() && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// localNets returns networks of our interfaces, link-local ones and networks of awl vpn are skipped.
+func (p *P2p) localNets() []*net.IPNet {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	vpnName, vpnNets := p.vpnNets()
+	var nets []*net.IPNet
+	for _, iface := range ifaces {
+		if iface.Name == vpnName {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, ipNet := range ipNets(addrs) {
+			if !ipNet.IP.IsLinkLocalUnicast() && !containsIP(vpnNets, ipNet.IP) {
+				nets = append(nets, ipNet)
+			}
+		}
+	}
+	return nets
+}
+
+// vpnNets returns name of our vpn interface and networks which are reachable over awl itself: networks of
+// the interface and from config, which are the same unless the interface isn't set up yet, and 464XLAT prefix.
+func (p *P2p) vpnNets() (string, []*net.IPNet) {
+	nets := []*net.IPNet{{IP: clatPrefix.Addr().AsSlice(), Mask: net.CIDRMask(clatPrefix.Bits(), 8*net.IPv4len)}}
+	if p.vpnInterface == nil {
+		return "", nets
+	}
+	name, vpnNets := p.vpnInterface()
+	nets = append(nets, vpnNets...)
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return name, nets
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return name, nets
+	}
+	return name, append(nets, ipNets(addrs)...)
+}
+
+func ipNets(addrs []net.Addr) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns IP address of the first component of the multiaddr, nil for other protocols, e.g. dns.
+func addrIP(addr multiaddr.Multiaddr) net.IP {
+	var ip net.IP
+	multiaddr.ForEach(addr, func(c multiaddr.Component) bool {
+		switch c.Protocol().Code {
+		case multiaddr.P_IP4, multiaddr.P_IP6:
+			ip = net.IP(bytes.Clone(c.RawValue()))
+		}
+		return false
+	})
+	return ip
+}
+
+func isCircuitAddr(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
@@ -0,0 +1,95 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalShortcutAddrs(t *testing.T) {
+	a := require.New(t)
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	lan.IP = net.ParseIP("192.168.1.10")
+	nets := []*net.IPNet{lan}
+
+	loopback := multiaddr.StringCast("/ip4/127.0.0.1/tcp/6150")
+	neighbour := multiaddr.StringCast("/ip4/192.168.1.20/udp/6150/quic-v1")
+	public := multiaddr.StringCast("/ip4/8.8.8.8/tcp/6150")
+	other := multiaddr.StringCast("/ip4/10.0.0.5/tcp/6150")
+
+	// the peer in our local network
+	a.Equal([]multiaddr.Multiaddr{neighbour}, localShortcutAddrs([]multiaddr.Multiaddr{loopback, public, neighbour, other}, nets))
+	// the peer on the same host has our address
+	sameHost := multiaddr.StringCast("/ip4/192.168.1.10/tcp/6151")
+	a.Equal([]multiaddr.Multiaddr{loopback, sameHost}, localShortcutAddrs([]multiaddr.Multiaddr{sameHost, loopback, public}, nets))
+	// a remote peer
+	a.Empty(localShortcutAddrs([]multiaddr.Multiaddr{loopback, public, other}, nets))
+	a.Empty(localShortcutAddrs([]multiaddr.Multiaddr{multiaddr.StringCast("/dns4/example.com/tcp/6150")}, nets))
+}
+
+func TestShortcutToLocal(t *testing.T) {
+	a := require.New(t)
+	newHost := func() *P2p {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		a.NoError(err)
+		t.Cleanup(func() {
+			_ = h.Close()
+		})
+		return &P2p{host: h, localPeers: newLocalPeerAddrs()}
+	}
+	p1, p2, p3 := newHost(), newHost(), newHost()
+	ctx := context.Background()
+	peer2 := p2.host.ID()
+	a.NoError(p1.host.Connect(ctx, peer.AddrInfo{ID: peer2, Addrs: p2.host.Addrs()}))
+	a.Len(p1.connsToPeer(peer2), 1)
+
+	// another peer listens on the address, connections are kept
+	err := p1.shortcutToLocal(ctx, peer2, p3.host.Addrs(), nil)
+	a.Error(err)
+	a.Len(p1.connsToPeer(peer2), 1)
+
+	a.NoError(p1.shortcutToLocal(ctx, peer2, p2.host.Addrs(), nil))
+	a.True(p1.PeerIsLocal(peer2))
+
+	p2.AddPeerLocalAddrs(p1.host.ID(), append(p1.LocalAddrs(), "invalid", "/ip4/1.2.3.4/tcp/1/p2p-circuit"))
+	a.Equal(p1.host.Addrs(), p2.localPeers.get(p1.host.ID()))
+}
+
+func TestLocalNetsWithoutVPN(t *testing.T) {
+	a := require.New(t)
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	a.NoError(err)
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+	p := &P2p{host: h, localPeers: newLocalPeerAddrs()}
+	a.NotEmpty(p.LocalAddrs())
+	a.True(containsIP(p.localNets(), net.ParseIP("127.0.0.1")))
+
+	// loopback stands for the vpn interface and network, peers are reachable through them over awl itself
+	_, loopbackNet, _ := net.ParseCIDR("127.0.0.0/8")
+	p.vpnInterface = func() (string, []*net.IPNet) {
+		return "", []*net.IPNet{loopbackNet}
+	}
+	a.Empty(p.LocalAddrs())
+	a.False(containsIP(p.localNets(), net.ParseIP("127.0.0.1")))
+
+	ifaces, err := net.Interfaces()
+	a.NoError(err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		p.vpnInterface = func() (string, []*net.IPNet) {
+			return iface.Name, nil
+		}
+		// addresses of the vpn interface are excluded even if they are not in vpn networks of config
+		a.Empty(p.LocalAddrs())
+		a.False(containsIP(p.localNets(), net.ParseIP("127.0.0.1")))
+	}
+}
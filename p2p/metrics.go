@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/anywherelan/awl/config"
 	"github.com/libp2p/go-libp2p-kbucket"
 	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -43,6 +44,11 @@ func (p *P2p) PeerUserAgent(peerID peer.ID) string {
 	return ""
 }
 
+// PeerVersion returns awl version of the peer, e.g. v0.10.0, or empty string if it is unknown.
+func (p *P2p) PeerVersion(peerID peer.ID) string {
+	return config.VersionFromUserAgent(p.PeerUserAgent(peerID))
+}
+
 func (p *P2p) PeerConnectionsInfo(peerID peer.ID) []ConnectionInfo {
 	conns := p.connsToPeer(peerID)
 	infos := make([]ConnectionInfo, 0, len(conns))
@@ -154,13 +160,14 @@ func (p *P2p) NetworkStatsForPeer(peerID peer.ID) metrics.Stats {
 // BootstrapPeersStats returns total peers count and connected count.
 func (p *P2p) BootstrapPeersStats() (int, int) {
 	connected := 0
-	for _, peerAddr := range p.bootstrapPeers {
+	bootstrapPeers := p.BootstrapPeers()
+	for _, peerAddr := range bootstrapPeers {
 		if p.IsConnected(peerAddr.ID) {
 			connected += 1
 		}
 	}
 
-	return len(p.bootstrapPeers), connected
+	return len(bootstrapPeers), connected
 }
 
 func (p *P2p) BootstrapPeersStatsDetailed() map[string]BootstrapPeerDebugInfo {
@@ -171,6 +178,21 @@ func (p *P2p) BootstrapPeersStatsDetailed() map[string]BootstrapPeerDebugInfo {
 	return *m
 }
 
+// isWebsocketAddr matches /ip4/.../tcp/443/wss and /ip4/.../tcp/443/tls/ws addresses.
+func isWebsocketAddr(protocols []multiaddr.Protocol) bool {
+	if len(protocols) < 3 || protocols[1].Code != multiaddr.P_TCP {
+		return false
+	}
+	switch {
+	case len(protocols) == 3:
+		return protocols[2].Code == multiaddr.P_WS || protocols[2].Code == multiaddr.P_WSS
+	case len(protocols) == 4:
+		return protocols[2].Code == multiaddr.P_TLS && protocols[3].Code == multiaddr.P_WS
+	default:
+		return false
+	}
+}
+
 func parseMultiaddrToInfo(addr multiaddr.Multiaddr) (ConnectionInfo, bool) {
 	info := ConnectionInfo{Multiaddr: addr.String()}
 	protocols := addr.Protocols()
@@ -185,6 +207,14 @@ func parseMultiaddrToInfo(addr multiaddr.Multiaddr) (ConnectionInfo, bool) {
 		ip, _ := addr.ValueForProtocol(protocols[0].Code)
 		port, _ := addr.ValueForProtocol(protocols[1].Code)
 		info.Address = net.JoinHostPort(ip, port)
+	} else if isWebsocketAddr(protocols) {
+		info.Protocol = "wss"
+		if protocols[len(protocols)-1].Code == multiaddr.P_WS && len(protocols) == 3 {
+			info.Protocol = "ws"
+		}
+		ip, _ := addr.ValueForProtocol(protocols[0].Code)
+		port, _ := addr.ValueForProtocol(protocols[1].Code)
+		info.Address = net.JoinHostPort(ip, port)
 	} else if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
 		info.ThroughRelay = true
 		info.RelayPeerID, _ = addr.ValueForProtocol(multiaddr.P_P2P)
@@ -53,6 +53,30 @@ func Test_parseMultiaddrToInfo(t *testing.T) {
 			},
 			want1: true,
 		},
+		{
+			name: "wss",
+			args: args{addr: mustNewMultiaddr("/ip4/192.168.1.11/tcp/443/wss")},
+			want: ConnectionInfo{
+				Multiaddr:    "/ip4/192.168.1.11/tcp/443/wss",
+				ThroughRelay: false,
+				RelayPeerID:  "",
+				Address:      "192.168.1.11:443",
+				Protocol:     "wss",
+			},
+			want1: true,
+		},
+		{
+			name: "tls-ws",
+			args: args{addr: mustNewMultiaddr("/ip4/192.168.1.11/tcp/443/tls/ws")},
+			want: ConnectionInfo{
+				Multiaddr:    "/ip4/192.168.1.11/tcp/443/tls/ws",
+				ThroughRelay: false,
+				RelayPeerID:  "",
+				Address:      "192.168.1.11:443",
+				Protocol:     "wss",
+			},
+			want1: true,
+		},
 		{
 			name: "relay",
 			args: args{addr: mustNewMultiaddr("/ip4/192.168.1.21/udp/6150/quic-v1/p2p/12D3KooWNWa2r6dJVogbjNf1CKrKNttVAhKZr1PpWRPJYX7o4t4M/p2p-circuit")},
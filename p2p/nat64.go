@@ -0,0 +1,154 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// nat64DiscoveryName has only IPv4 addresses, DNS64 synthesizes IPv6 addresses for it with NAT64 prefix, see RFC 7050
+	nat64DiscoveryName    = "ipv4only.arpa"
+	nat64DiscoveryTimeout = 2 * time.Second
+)
+
+var (
+	nat64DiscoveryIPs = []netip.Addr{netip.AddrFrom4([4]byte{192, 0, 0, 170}), netip.AddrFrom4([4]byte{192, 0, 0, 171})}
+	// clatPrefix is used by 464XLAT client on mobile devices for IPv4 address of its interface, see RFC 7335
+	clatPrefix = netip.MustParsePrefix("192.0.0.0/29")
+	// nat64PrefixLengths are allowed by RFC 6052
+	nat64PrefixLengths = []int{96, 64, 56, 48, 40, 32}
+)
+
+// NAT64Prefix returns prefix of NAT64 which translates our IPv6 connections to IPv4 peers,
+// it's invalid if we are not behind NAT64.
+func (p *P2p) NAT64Prefix() netip.Prefix {
+	prefix := p.nat64Prefix.Load()
+	if prefix == nil {
+		return netip.Prefix{}
+	}
+	return *prefix
+}
+
+// updateNAT64Prefix discovers NAT64 prefix with DNS64, it's repeated as mobile devices switch networks.
+func (p *P2p) updateNAT64Prefix(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, nat64DiscoveryTimeout)
+	defer cancel()
+
+	var prefix netip.Prefix
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip6", nat64DiscoveryName)
+	if err == nil {
+		for _, addr := range addrs {
+			if prefix = nat64PrefixFromAddr(addr); prefix.IsValid() {
+				break
+			}
+		}
+	}
+
+	old := p.nat64Prefix.Swap(&prefix)
+	if old == nil || *old != prefix {
+		if prefix.IsValid() {
+			p.logger.Infof("running behind NAT64 with prefix %s, IPv4 addresses of peers are dialed through it", prefix)
+		} else if old != nil && old.IsValid() {
+			p.logger.Infof("NAT64 is not detected anymore")
+		}
+	}
+}
+
+// withNAT64Addrs adds IPv6 addresses synthesized with NAT64 prefix for public IPv4 addresses of the peer,
+// so IPv4-only peers are reachable from IPv6-only networks. IPv4 addresses are kept for dual-stack networks with DNS64.
+func (p *P2p) withNAT64Addrs(info peer.AddrInfo) peer.AddrInfo {
+	prefix := p.NAT64Prefix()
+	if !prefix.IsValid() {
+		return info
+	}
+	addrs := make([]multiaddr.Multiaddr, 0, len(info.Addrs)*2)
+	for _, addr := range info.Addrs {
+		addrs = append(addrs, addr)
+		if synthesized, ok := synthesizeNAT64Multiaddr(prefix, addr); ok {
+			addrs = append(addrs, synthesized)
+		}
+	}
+	info.Addrs = addrs
+	return info
+}
+
+func synthesizeNAT64Multiaddr(prefix netip.Prefix, addr multiaddr.Multiaddr) (multiaddr.Multiaddr, bool) {
+	first, rest := multiaddr.SplitFirst(addr)
+	if first == nil || first.Protocol().Code != multiaddr.P_IP4 || !manet.IsPublicAddr(addr) {
+		return nil, false
+	}
+	ip, ok := netip.AddrFromSlice(first.RawValue())
+	if !ok {
+		return nil, false
+	}
+	ip6, err := multiaddr.NewComponent("ip6", synthesizeNAT64Addr(prefix, ip).String())
+	if err != nil {
+		return nil, false
+	}
+	if rest == nil {
+		return ip6, true
+	}
+	return ip6.Encapsulate(rest), true
+}
+
+// withoutCLATAddrs removes our addresses of 464XLAT client interface, peers can't dial them
+// and connections from them are observed by peers as addresses of carrier NAT.
+func withoutCLATAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	return multiaddr.FilterAddrs(addrs, func(addr multiaddr.Multiaddr) bool {
+		value, err := addr.ValueForProtocol(multiaddr.P_IP4)
+		if err != nil {
+			return true
+		}
+		ip, err := netip.ParseAddr(value)
+		return err != nil || !clatPrefix.Contains(ip)
+	})
+}
+
+// nat64AddrIndices returns positions of IPv4 address bytes in IPv6 address for the prefix length,
+// bits 64-71 are reserved and skipped, see RFC 6052 section 2.2.
+func nat64AddrIndices(prefixLen int) [4]int {
+	var indices [4]int
+	i := prefixLen / 8
+	for n := 0; n < len(indices); i++ {
+		if i == 8 {
+			continue
+		}
+		indices[n] = i
+		n++
+	}
+	return indices
+}
+
+func nat64PrefixFromAddr(addr netip.Addr) netip.Prefix {
+	if !addr.Is6() || addr.Is4In6() {
+		return netip.Prefix{}
+	}
+	bytes := addr.As16()
+	for _, prefixLen := range nat64PrefixLengths {
+		var ip4 [4]byte
+		for n, i := range nat64AddrIndices(prefixLen) {
+			ip4[n] = bytes[i]
+		}
+		for _, discoveryIP := range nat64DiscoveryIPs {
+			if netip.AddrFrom4(ip4) == discoveryIP {
+				return netip.PrefixFrom(addr, prefixLen).Masked()
+			}
+		}
+	}
+	return netip.Prefix{}
+}
+
+func synthesizeNAT64Addr(prefix netip.Prefix, ip4 netip.Addr) netip.Addr {
+	bytes := prefix.Addr().As16()
+	ip4Bytes := ip4.As4()
+	for n, i := range nat64AddrIndices(prefix.Bits()) {
+		bytes[i] = ip4Bytes[n]
+	}
+	return netip.AddrFrom16(bytes)
+}
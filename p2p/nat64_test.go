@@ -0,0 +1,60 @@
+package p2p
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNAT64Addr(t *testing.T) {
+	a := require.New(t)
+
+	// examples from RFC 6052 section 2.4
+	ip4 := netip.MustParseAddr("192.0.2.33")
+	for prefix, expected := range map[string]string{
+		"2001:db8::/32":         "2001:db8:c000:221::",
+		"2001:db8:100::/40":     "2001:db8:1c0:2:21::",
+		"2001:db8:122::/48":     "2001:db8:122:c000:2:2100::",
+		"2001:db8:122:300::/56": "2001:db8:122:3c0:0:221::",
+		"2001:db8:122:344::/64": "2001:db8:122:344:c0:2:2100:0",
+		"2001:db8:122:344::/96": "2001:db8:122:344::c000:221",
+		"64:ff9b::/96":          "64:ff9b::c000:221",
+	} {
+		a.Equal(netip.MustParseAddr(expected), synthesizeNAT64Addr(netip.MustParsePrefix(prefix), ip4), prefix)
+	}
+
+	// DNS64 answers for ipv4only.arpa
+	a.Equal(netip.MustParsePrefix("64:ff9b::/96"), nat64PrefixFromAddr(netip.MustParseAddr("64:ff9b::192.0.0.170")))
+	a.Equal(netip.MustParsePrefix("2001:db8:122:344::/64"), nat64PrefixFromAddr(netip.MustParseAddr("2001:db8:122:344:c0:0:aa00:0")))
+	a.False(nat64PrefixFromAddr(netip.MustParseAddr("2001:db8::1")).IsValid())
+	a.False(nat64PrefixFromAddr(netip.MustParseAddr("192.0.0.170")).IsValid())
+}
+
+func TestWithNAT64Addrs(t *testing.T) {
+	a := require.New(t)
+	p := &P2p{}
+	info := peer.AddrInfo{Addrs: []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/8.8.8.8/udp/6150/quic-v1"),
+		multiaddr.StringCast("/ip4/192.168.1.5/tcp/6150"),
+		multiaddr.StringCast("/ip6/2001:4860::1/tcp/6150"),
+	}}
+	a.Equal(info, p.withNAT64Addrs(info))
+
+	prefix := netip.MustParsePrefix("64:ff9b::/96")
+	p.nat64Prefix.Store(&prefix)
+	result := p.withNAT64Addrs(info)
+	a.Equal([]multiaddr.Multiaddr{
+		info.Addrs[0],
+		multiaddr.StringCast("/ip6/64:ff9b::808:808/udp/6150/quic-v1"),
+		info.Addrs[1],
+		info.Addrs[2],
+	}, result.Addrs)
+
+	a.Equal([]multiaddr.Multiaddr{multiaddr.StringCast("/ip4/10.0.0.5/tcp/6150")}, withoutCLATAddrs([]multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/192.0.0.4/tcp/6150"),
+		multiaddr.StringCast("/ip4/10.0.0.5/tcp/6150"),
+	}))
+}
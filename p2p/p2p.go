@@ -1,11 +1,14 @@
 package p2p
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,6 +28,7 @@ import (
 	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
 	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	"github.com/multiformats/go-multiaddr"
@@ -38,7 +42,7 @@ const (
 	DHTProtocolPrefix protocol.ID = "/awl"
 
 	protectedBootstrapPeerTag = "bootstrap"
-	protectedPeerTag          = "known"
+	pinnedPeerTag             = "pinned"
 
 	// Port is unassigned by IANA and seems quite unused.
 	// https://www.iana.org/assignments/service-names-port-numbers/service-names-port-numbers.txt
@@ -50,6 +54,10 @@ type HostConfig struct {
 	ListenAddrs    []multiaddr.Multiaddr
 	UserAgent      string
 	BootstrapPeers []peer.AddrInfo
+	// FallbackRelays are used only when bootstrap peers are unreachable, see HTTPSRelayServerConfig
+	FallbackRelays   []peer.AddrInfo
+	HTTPSRelayServer *HTTPSRelayServerConfig
+	Transports       TransportsConfig
 
 	Libp2pOpts  []libp2p.Option
 	ConnManager struct {
@@ -60,6 +68,16 @@ type HostConfig struct {
 	Peerstore    peerstore.Peerstore
 	DHTDatastore ds.Batching
 	DHTOpts      []dht.Option
+	// PeerFilter is checked for every connection, nil allows all peers
+	PeerFilter PeerFilter
+	// TrafficCategories attribute bandwidth of protocols to categories, other protocols are TrafficControl
+	TrafficCategories map[protocol.ID]string
+	// OnHolePunch is called after each hole punching attempt, hole punching is started by libp2p for new
+	// relayed connections and by MaintainDirectConnections
+	OnHolePunch func(HolePunchResult)
+	// VPNInterface returns name and networks of our vpn interface, they aren't local networks for
+	// MaintainLocalConnections, since peers are reachable through them over awl itself
+	VPNInterface func() (string, []*net.IPNet)
 }
 
 type IDService interface {
@@ -78,19 +96,43 @@ type P2p struct {
 	host             host.Host
 	basicHost        *basichost.BasicHost
 	dht              *dht.IpfsDHT
+	dhtHost          *dhtHost
 	bandwidthCounter metrics.Reporter
+	traffic          *trafficReporter
 	connManager      *connmgr.BasicConnMgr
-	bootstrapPeers   []peer.AddrInfo
+	bootstrapPeers   atomic.Pointer[[]peer.AddrInfo]
+	fallbackRelays   []peer.AddrInfo
 	startedAt        time.Time
 	bootstrapsInfo   atomic.Pointer[map[string]BootstrapPeerDebugInfo]
+
+	fallbackRelaysActive atomic.Bool
+	// selectedRelays are the fastest relay candidates provided to autorelay, nil if candidates should be measured
+	// again, see MaintainRelaySelection
+	selectedRelays atomic.Pointer[[]peer.AddrInfo]
+	powerSave      atomic.Bool
+	nat64Prefix    atomic.Pointer[netip.Prefix]
+	throughput     *throughputMeter
+	streamHistory  *streamHistory
+	gater          *connectionGater
+	onHolePunch    func(HolePunchResult)
+	localPeers     *localPeerAddrs
+	greylist       *greylist
+	holePunches    *holePunchHistory
+	vpnInterface   func() (string, []*net.IPNet)
 }
 
 func NewP2p(ctx context.Context) *P2p {
 	newCtx, ctxCancel := context.WithCancel(ctx)
 	return &P2p{
-		ctx:       newCtx,
-		ctxCancel: ctxCancel,
-		logger:    log.Logger("awl/p2p"),
+		ctx:        newCtx,
+		ctxCancel:  ctxCancel,
+		logger:     log.Logger("awl/p2p"),
+		throughput: newThroughputMeter(),
+		localPeers: newLocalPeerAddrs(),
+		greylist:   newGreylist(),
+
+		streamHistory: newStreamHistory(),
+		holePunches:   newHolePunchHistory(),
 	}
 }
 
@@ -109,8 +151,12 @@ func (p *P2p) InitHost(hostConfig HostConfig) (host.Host, error) {
 		}
 	}
 
-	p.bandwidthCounter = metrics.NewBandwidthCounter()
-	p.bootstrapPeers = hostConfig.BootstrapPeers
+	p.traffic = newTrafficReporter(hostConfig.TrafficCategories)
+	p.bandwidthCounter = p.traffic
+	p.onHolePunch = hostConfig.OnHolePunch
+	p.vpnInterface = hostConfig.VPNInterface
+	p.bootstrapPeers.Store(&hostConfig.BootstrapPeers)
+	p.fallbackRelays = hostConfig.FallbackRelays
 
 	p.connManager, err = connmgr.NewConnManager(
 		hostConfig.ConnManager.LowWater,
@@ -125,6 +171,15 @@ func (p *P2p) InitHost(hostConfig HostConfig) (host.Host, error) {
 	if len(listenAddrs) == 0 {
 		listenAddrs = findListenAddrs()
 	}
+	transportsOpts, transportsListenAddrs := transportsOptions(hostConfig, listenAddrs)
+	httpsRelayOpts, httpsRelayListenAddrs := httpsRelayOptions(hostConfig)
+	listenAddrs = append(listenAddrs, transportsListenAddrs...)
+	listenAddrs = append(listenAddrs, httpsRelayListenAddrs...)
+	var gaterOpts []libp2p.Option
+	if hostConfig.PeerFilter != nil {
+		p.gater = newConnectionGater(hostConfig.PeerFilter, p.BootstrapPeers(), p.fallbackRelays)
+		gaterOpts = append(gaterOpts, libp2p.ConnectionGater(p.gater))
+	}
 
 	p2pHost, err := libp2p.New(
 		libp2p.Peerstore(hostConfig.Peerstore),
@@ -133,24 +188,31 @@ func (p *P2p) InitHost(hostConfig HostConfig) (host.Host, error) {
 		libp2p.BandwidthReporter(p.bandwidthCounter),
 		libp2p.ConnectionManager(p.connManager),
 		libp2p.ListenAddrs(listenAddrs...),
+		libp2p.AddrsFactory(withoutCLATAddrs),
 		libp2p.ChainOptions(
 			libp2p.Transport(libp2pquic.NewTransport),
 			libp2p.Transport(tcp.NewTCPTransport),
 		),
+		libp2p.ChainOptions(transportsOpts...),
+		libp2p.ChainOptions(httpsRelayOpts...),
+		libp2p.ChainOptions(gaterOpts...),
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 			opts := []dht.Option{
 				dht.Datastore(hostConfig.DHTDatastore),
 				dht.ProtocolPrefix(DHTProtocolPrefix),
-				dht.BootstrapPeers(p.bootstrapPeers...),
+				dht.BootstrapPeersFunc(p.BootstrapPeers),
 			}
 			opts = append(opts, hostConfig.DHTOpts...)
-			kademliaDHT, err := dht.New(p.ctx, h, opts...)
+			p.dhtHost = newDHTHost(h)
+			kademliaDHT, err := dht.New(p.ctx, p.dhtHost, opts...)
 			p.dht = kademliaDHT
 			p.basicHost = h.(*basichost.BasicHost)
 			return p.dht, err
 		}),
 		libp2p.DefaultMuxers,
 		libp2p.DefaultSecurity,
+		// relayed connections are upgraded to direct ones by hole punching (DCUtR), see MaintainDirectConnections
+		libp2p.EnableHolePunching(holepunch.WithTracer(holePunchTracer{p: p})),
 		libp2p.ChainOptions(hostConfig.Libp2pOpts...),
 	)
 	if err != nil {
@@ -171,6 +233,23 @@ func (p *P2p) Close() error {
 	return err
 }
 
+// IsListening returns false if the host has lost all its listeners, e.g. sockets were closed by the system.
+func (p *P2p) IsListening() bool {
+	return len(p.host.Network().ListenAddresses()) > 0
+}
+
+// Relisten opens listeners on the addresses again and bootstraps the DHT. The host itself is kept,
+// since it's used by all services and stream handlers.
+func (p *P2p) Relisten(addrs []multiaddr.Multiaddr) error {
+	err := p.host.Network().Listen(addrs...)
+	if err != nil {
+		return fmt.Errorf("listen: %v", err)
+	}
+	p.logger.Infof("Listen interfaces: %v", p.host.Addrs())
+
+	return p.Bootstrap()
+}
+
 func (p *P2p) PeerID() peer.ID {
 	return p.host.ID()
 }
@@ -195,7 +274,7 @@ func (p *P2p) ConnectPeer(ctx context.Context, peerID peer.ID) error {
 	if err != nil {
 		return fmt.Errorf("could not find peer %s: %v", peerID.String(), err)
 	}
-	err = p.host.Connect(ctx, peerInfo)
+	err = p.host.Connect(ctx, p.withNAT64Addrs(peerInfo))
 
 	return err
 }
@@ -204,21 +283,28 @@ func (p *P2p) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
 	return p.dht.FindPeer(ctx, id)
 }
 
-func (p *P2p) NewStream(ctx context.Context, id peer.ID, proto protocol.ID) (network.Stream, error) {
+// NewStream opens stream with the first of protos supported by the peer, in order of preference.
+func (p *P2p) NewStream(ctx context.Context, id peer.ID, protos ...protocol.ID) (network.Stream, error) {
 	ctx = network.WithUseTransient(ctx, "awl")
-	return p.host.NewStream(ctx, id, proto)
+	stream, err := p.host.NewStream(ctx, id, protos...)
+	if err != nil && len(protos) > 0 {
+		p.streamHistory.openFailed(protos[0], time.Now())
+	}
+	return stream, err
 }
 
 func (p *P2p) IsConnected(peerID peer.ID) bool {
 	return p.host.Network().Connectedness(peerID) == network.Connected
 }
 
-func (p *P2p) ProtectPeer(id peer.ID) {
-	p.host.ConnManager().Protect(id, protectedPeerTag)
+// PinPeer protects connections with the peer until UnpinPeer, other known peers are kept by conn tag weights,
+// see MaintainConnTagWeights.
+func (p *P2p) PinPeer(id peer.ID) {
+	p.host.ConnManager().Protect(id, pinnedPeerTag)
 }
 
-func (p *P2p) UnprotectPeer(id peer.ID) {
-	p.host.ConnManager().Unprotect(id, protectedPeerTag)
+func (p *P2p) UnpinPeer(id peer.ID) {
+	p.host.ConnManager().Unprotect(id, pinnedPeerTag)
 }
 
 func (p *P2p) SubscribeConnectionEvents(onConnected, onDisconnected func(network.Network, network.Conn)) {
@@ -235,15 +321,16 @@ func (p *P2p) Bootstrap() error {
 	ctx, cancel := context.WithTimeout(p.ctx, 2*time.Second)
 	defer cancel()
 	var wg sync.WaitGroup
+	p.updateNAT64Prefix(p.ctx)
 
-	for _, peerAddr := range p.bootstrapPeers {
+	for _, peerAddr := range p.BootstrapPeers() {
 		wg.Add(1)
 		peerAddr := peerAddr
 		p.host.ConnManager().Protect(peerAddr.ID, protectedBootstrapPeerTag)
 
 		go func() {
 			defer wg.Done()
-			if err := p.host.Connect(ctx, peerAddr); err != nil && !errors.Is(err, context.Canceled) {
+			if err := p.host.Connect(ctx, p.withNAT64Addrs(peerAddr)); err != nil && !errors.Is(err, context.Canceled) {
 				p.logger.Warnf("Failed to connect to bootstrap node %s: %v", peerAddr.ID, err)
 			} else if err == nil {
 				p.logger.Infof("Connection established with bootstrap node: %s", peerAddr.ID)
@@ -252,6 +339,7 @@ func (p *P2p) Bootstrap() error {
 	}
 	wg.Wait()
 	p.logger.Info("Connection established with all bootstrap nodes")
+	p.updateFallbackRelays(p.ctx)
 
 	if err := p.dht.Bootstrap(p.ctx); err != nil {
 		return fmt.Errorf("bootstrap dht: %v", err)
@@ -273,25 +361,31 @@ func (p *P2p) MaintainBackgroundConnections(ctx context.Context, interval time.D
 	t := time.NewTicker(interval)
 	defer t.Stop()
 
+	lastTry := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-t.C:
 		}
+		// in power save mode only every few ticks are used, half of the interval covers ticker jitter
+		if time.Since(lastTry) < p.PowerSaveInterval(interval)-interval/2 {
+			continue
+		}
 
+		lastTry = time.Now()
 		p.connectToKnownPeers(ctx, interval, knownPeersIdsFunc())
 	}
 }
 
 func (p *P2p) connectToKnownPeers(ctx context.Context, timeout time.Duration, peerIds []peer.ID) {
+	p.updateNAT64Prefix(ctx)
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	var wg sync.WaitGroup
 	for _, peerID := range peerIds {
 		wg.Add(1)
-		p.ProtectPeer(peerID)
 		go func(peerID peer.ID) {
 			defer wg.Done()
 			_ = p.ConnectPeer(ctx, peerID)
@@ -302,7 +396,7 @@ func (p *P2p) connectToKnownPeers(ctx context.Context, timeout time.Duration, pe
 	bootstrapsInfo := make(map[string]BootstrapPeerDebugInfo)
 	var mu sync.Mutex
 
-	for _, peerAddr := range p.bootstrapPeers {
+	for _, peerAddr := range p.BootstrapPeers() {
 		wg.Add(1)
 		peerAddr := peerAddr
 		go func() {
@@ -312,7 +406,7 @@ func (p *P2p) connectToKnownPeers(ctx context.Context, timeout time.Duration, pe
 				p.ClearBackoff(peerAddr.ID)
 			}
 
-			err := p.host.Connect(ctx, peerAddr)
+			err := p.host.Connect(ctx, p.withNAT64Addrs(peerAddr))
 			var info BootstrapPeerDebugInfo
 			if err != nil {
 				info.Error = err.Error()
@@ -327,12 +421,36 @@ func (p *P2p) connectToKnownPeers(ctx context.Context, timeout time.Duration, pe
 	wg.Wait()
 
 	p.bootstrapsInfo.Store(&bootstrapsInfo)
+	p.updateFallbackRelays(ctx)
 }
 
 func (p *P2p) connsToPeer(peerID peer.ID) []network.Conn {
 	return p.host.Network().ConnsToPeer(peerID)
 }
 
+// PeerRemoteIPs returns IPv4 addresses which we use to reach the peer, relay address for relayed connections.
+func (p *P2p) PeerRemoteIPs(peerID peer.ID) []net.IP {
+	conns := p.connsToPeer(peerID)
+	ips := make([]net.IP, 0, len(conns))
+	seen := make(map[string]struct{}, len(conns))
+	for _, conn := range conns {
+		value, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_IP4)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(value).To4()
+		if _, exists := seen[value]; exists || ip == nil || ip.IsLoopback() {
+			continue
+		}
+		seen[value] = struct{}{}
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		return bytes.Compare(ips[i], ips[j]) < 0
+	})
+	return ips
+}
+
 func (p *P2p) peerAddressesString(peerID peer.ID) []string {
 	conns := p.connsToPeer(peerID)
 	addrs := make([]string, 0, len(conns))
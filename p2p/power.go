@@ -0,0 +1,84 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// powerSaveFactor multiplies intervals of background keepalives and reconnections in power save mode.
+const powerSaveFactor = 4
+
+// SetPowerSave switches power save mode, e.g. when the device runs on battery. In this mode NAT keepalives and
+// reconnections to peers are less frequent and the DHT doesn't answer queries of other peers.
+func (p *P2p) SetPowerSave(enabled bool) {
+	if p.powerSave.Swap(enabled) == enabled {
+		return
+	}
+	p.logger.Infof("power save mode: %t", enabled)
+	if p.dhtHost != nil {
+		p.dhtHost.suspendServer(enabled)
+	}
+}
+
+func (p *P2p) PowerSave() bool {
+	return p.powerSave.Load()
+}
+
+// PowerSaveInterval returns the interval of background work for the current power mode.
+func (p *P2p) PowerSaveInterval(interval time.Duration) time.Duration {
+	if p.PowerSave() {
+		return interval * powerSaveFactor
+	}
+	return interval
+}
+
+// dhtHost is the host of the DHT which keeps its stream handlers, so DHT server mode could be suspended
+// without restarting the DHT. The DHT still switches between client and server modes by reachability.
+type dhtHost struct {
+	host.Host
+
+	lock      sync.Mutex
+	handlers  map[protocol.ID]network.StreamHandler
+	suspended bool
+}
+
+func newDHTHost(h host.Host) *dhtHost {
+	return &dhtHost{
+		Host:     h,
+		handlers: make(map[protocol.ID]network.StreamHandler),
+	}
+}
+
+func (h *dhtHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.handlers[pid] = handler
+	if !h.suspended {
+		h.Host.SetStreamHandler(pid, handler)
+	}
+}
+
+func (h *dhtHost) RemoveStreamHandler(pid protocol.ID) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.handlers, pid)
+	h.Host.RemoveStreamHandler(pid)
+}
+
+// suspendServer removes stream handlers of the DHT, peers learn about it by identify push and stop sending queries.
+func (h *dhtHost) suspendServer(suspended bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.suspended = suspended
+	for pid, handler := range h.handlers {
+		if suspended {
+			h.Host.RemoveStreamHandler(pid)
+		} else {
+			h.Host.SetStreamHandler(pid, handler)
+		}
+	}
+}
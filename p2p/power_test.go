@@ -0,0 +1,38 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDHTHostSuspendServer(t *testing.T) {
+	a := require.New(t)
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	a.NoError(err)
+	defer h.Close()
+
+	const proto = DHTProtocolPrefix + "/kad/1.0.0"
+	wrapped := newDHTHost(h)
+	wrapped.SetStreamHandler(proto, func(network.Stream) {})
+	a.Contains(h.Mux().Protocols(), protocol.ID(proto))
+
+	// handlers set by the DHT while suspended are applied on resume
+	wrapped.suspendServer(true)
+	a.NotContains(h.Mux().Protocols(), protocol.ID(proto))
+	wrapped.SetStreamHandler(proto+"/v2", func(network.Stream) {})
+	a.NotContains(h.Mux().Protocols(), protocol.ID(proto+"/v2"))
+
+	wrapped.suspendServer(false)
+	a.Contains(h.Mux().Protocols(), protocol.ID(proto))
+	a.Contains(h.Mux().Protocols(), protocol.ID(proto+"/v2"))
+
+	// the DHT switched to client mode by reachability
+	wrapped.RemoveStreamHandler(proto)
+	wrapped.suspendServer(true)
+	wrapped.suspendServer(false)
+	a.NotContains(h.Mux().Protocols(), protocol.ID(proto))
+}
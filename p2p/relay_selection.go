@@ -0,0 +1,189 @@
+package p2p
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	relaySelectionInterval = 5 * time.Minute
+	relayPingTimeout       = 5 * time.Second
+	// Candidates which are slower than the fastest one both by factor and margin are not provided to autorelay,
+	// used relay is replaced when another candidate is faster by them. Margin prevents switching between close relays.
+	relayLatencyFactor = 2
+	relayLatencyMargin = 50 * time.Millisecond
+)
+
+type relayCandidate struct {
+	info peer.AddrInfo
+	// latency is zero if the candidate is unreachable
+	latency time.Duration
+}
+
+// RelayPeerSource provides relay candidates for autorelay: bootstrap peers, and fallback https relays when no bootstrap peer
+// is reachable over QUIC or TCP. Latency to candidates is measured and only the fastest of them are provided,
+// otherwise autorelay picks random candidates, e.g. on another continent.
+func (p *P2p) RelayPeerSource(ctx context.Context, num int) <-chan peer.AddrInfo {
+	selected := p.selectedRelays.Load()
+	if selected == nil {
+		relays := selectRelayCandidates(p.measureRelayCandidates(ctx), DesiredRelays)
+		p.selectedRelays.Store(&relays)
+		selected = &relays
+	}
+	candidates := *selected
+	if len(candidates) > num {
+		candidates = candidates[:num]
+	}
+
+	ch := make(chan peer.AddrInfo, len(candidates))
+	defer close(ch)
+	for _, candidate := range candidates {
+		ch <- candidate
+	}
+	return ch
+}
+
+// MaintainRelaySelection periodically measures latency to relay candidates and updates the set which is provided
+// to autorelay, so it makes reservations with the fastest relays when it looks for new ones.
+// Connections to relays aren't closed, they are bootstrap peers and could be used by friends.
+func (p *P2p) MaintainRelaySelection(ctx context.Context) {
+	ticker := time.NewTicker(relaySelectionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.reevaluateRelays(ctx)
+	}
+}
+
+func (p *P2p) reevaluateRelays(ctx context.Context) {
+	candidates := p.measureRelayCandidates(ctx)
+	selected := selectRelayCandidates(candidates, DesiredRelays)
+	p.selectedRelays.Store(&selected)
+
+	usedRelays := p.usedRelays()
+	latencies := make(map[peer.ID]time.Duration)
+	var fastestUnused time.Duration
+	for _, candidate := range candidates {
+		latencies[candidate.info.ID] = candidate.latency
+		_, used := usedRelays[candidate.info.ID]
+		if !used && candidate.latency > 0 && (fastestUnused == 0 || candidate.latency < fastestUnused) {
+			fastestUnused = candidate.latency
+		}
+	}
+	if fastestUnused == 0 {
+		return
+	}
+	for relayID := range usedRelays {
+		latency := latencies[relayID]
+		if latency > 0 && isMuchSlower(latency, fastestUnused) {
+			p.logger.Infof("relay %s latency %s is much higher than %s of another candidate, it's replaced when autorelay looks for new relays",
+				relayID, latency.Round(time.Millisecond), fastestUnused.Round(time.Millisecond))
+		}
+	}
+}
+
+// usedRelays returns relays with our reservations, they are in our circuit addresses.
+func (p *P2p) usedRelays() map[peer.ID]struct{} {
+	result := make(map[peer.ID]struct{})
+	for _, addr := range p.host.Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err != nil {
+			continue
+		}
+		value, err := addr.ValueForProtocol(multiaddr.P_P2P)
+		if err != nil {
+			continue
+		}
+		relayID, err := peer.Decode(value)
+		if err == nil {
+			result[relayID] = struct{}{}
+		}
+	}
+	return result
+}
+
+// measureRelayCandidates pings relay candidates concurrently, it connects to them if needed.
+func (p *P2p) measureRelayCandidates(ctx context.Context) []relayCandidate {
+	bootstrapPeers := p.BootstrapPeers()
+	infos := make([]peer.AddrInfo, 0, len(bootstrapPeers)+len(p.fallbackRelays))
+	if p.fallbackRelaysActive.Load() {
+		infos = append(infos, p.fallbackRelays...)
+	}
+	infos = append(infos, bootstrapPeers...)
+
+	candidates := make([]relayCandidate, len(infos))
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		candidates[i].info = info
+		wg.Add(1)
+		go func(candidate *relayCandidate) {
+			defer wg.Done()
+			candidate.latency = p.pingRelay(ctx, candidate.info)
+		}(&candidates[i])
+	}
+	wg.Wait()
+
+	return candidates
+}
+
+func (p *P2p) pingRelay(ctx context.Context, info peer.AddrInfo) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, relayPingTimeout)
+	defer cancel()
+
+	if !p.IsConnected(info.ID) {
+		err := p.host.Connect(ctx, p.withNAT64Addrs(info))
+		if err != nil {
+			return 0
+		}
+	}
+	result := <-ping.Ping(ctx, p.host, info.ID)
+	if result.Error != nil {
+		p.logger.Debugf("ping relay candidate %s: %v", info.ID, result.Error)
+		return 0
+	}
+	// ping records latency to peerstore, its moving average is more stable than a single measurement
+	if latency := p.host.Peerstore().LatencyEWMA(info.ID); latency > 0 {
+		return latency
+	}
+	return result.RTT
+}
+
+// selectRelayCandidates returns reachable candidates sorted by latency which are not much slower than the fastest one,
+// but at least minCount of them. Unreachable candidates are returned only if there are not enough reachable ones.
+func selectRelayCandidates(candidates []relayCandidate, minCount int) []peer.AddrInfo {
+	if len(candidates) == 0 {
+		return nil
+	}
+	sorted := append([]relayCandidate(nil), candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].latency == 0 || sorted[j].latency == 0 {
+			return sorted[j].latency == 0 && sorted[i].latency != 0
+		}
+		return sorted[i].latency < sorted[j].latency
+	})
+
+	result := make([]peer.AddrInfo, 0, len(sorted))
+	fastest := sorted[0].latency
+	for _, candidate := range sorted {
+		if len(result) >= minCount && (candidate.latency == 0 || isMuchSlower(candidate.latency, fastest)) {
+			break
+		}
+		result = append(result, candidate.info)
+	}
+	return result
+}
+
+func isMuchSlower(latency, fastest time.Duration) bool {
+	return latency > fastest*relayLatencyFactor && latency-fastest > relayLatencyMargin
+}
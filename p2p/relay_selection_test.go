@@ -0,0 +1,42 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectRelayCandidates(t *testing.T) {
+	a := require.New(t)
+	candidate := func(id string, latency time.Duration) relayCandidate {
+		return relayCandidate{info: peer.AddrInfo{ID: peer.ID(id)}, latency: latency}
+	}
+	ids := func(infos []peer.AddrInfo) []peer.ID {
+		result := make([]peer.ID, 0, len(infos))
+		for _, info := range infos {
+			result = append(result, info.ID)
+		}
+		return result
+	}
+
+	candidates := []relayCandidate{
+		candidate("unreachable", 0),
+		candidate("other-continent", 250*time.Millisecond),
+		candidate("near", 20*time.Millisecond),
+		candidate("nearby", 40*time.Millisecond),
+		candidate("same-city", 10*time.Millisecond),
+	}
+	a.Equal([]peer.ID{"same-city", "near", "nearby"}, ids(selectRelayCandidates(candidates, 2)))
+	a.Equal([]peer.ID{"same-city", "near", "nearby", "other-continent"}, ids(selectRelayCandidates(candidates, 4)))
+	a.Equal([]peer.ID{"same-city", "near", "nearby", "other-continent", "unreachable"}, ids(selectRelayCandidates(candidates, 10)))
+
+	// no candidate is reachable
+	a.Equal([]peer.ID{"a"}, ids(selectRelayCandidates([]relayCandidate{candidate("a", 0), candidate("b", 0)}, 1)))
+	a.Empty(selectRelayCandidates(nil, 2))
+
+	a.False(isMuchSlower(60*time.Millisecond, 20*time.Millisecond))
+	a.True(isMuchSlower(250*time.Millisecond, 20*time.Millisecond))
+	a.False(isMuchSlower(25*time.Millisecond, 10*time.Millisecond))
+}
@@ -0,0 +1,33 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+)
+
+// Advertise publishes us as provider of the namespace in DHT. It returns how long the record is kept,
+// it should be advertised again before that.
+func (p *P2p) Advertise(ctx context.Context, ns string) (time.Duration, error) {
+	return drouting.NewRoutingDiscovery(p.dht).Advertise(ctx, ns)
+}
+
+// FindPeers returns up to limit peers which advertised the namespace, except us. Peers found before ctx is done
+// are returned without error.
+func (p *P2p) FindPeers(ctx context.Context, ns string, limit int) ([]peer.AddrInfo, error) {
+	peersCh, err := drouting.NewRoutingDiscovery(p.dht).FindPeers(ctx, ns, discovery.Limit(limit))
+	if err != nil {
+		return nil, err
+	}
+	var peers []peer.AddrInfo
+	for info := range peersCh {
+		if info.ID == p.host.ID() {
+			continue
+		}
+		peers = append(peers, info)
+	}
+	return peers, nil
+}
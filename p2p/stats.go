@@ -0,0 +1,126 @@
+package p2p
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// StatsSnapshotVersion is the schema version of StatsSnapshot.
+// Increment it on every incompatible change (field removal, type or meaning change).
+// Adding new fields is a compatible change.
+const StatsSnapshotVersion = 1
+
+// StatsSnapshot is a point-in-time view of all p2p stats.
+// Consumers (api, cli) should use it instead of calling separate getters.
+type StatsSnapshot struct {
+	Version      int
+	CreatedAt    time.Time
+	Uptime       time.Duration `swaggertype:"primitive,integer"`
+	Reachability string        `enums:"Unknown,Public,Private"`
+
+	Connections ConnectionsStats
+	Streams     StreamsStats
+	Bandwidth   BandwidthStats
+	DHT         DHTStats
+	Bootstrap   BootstrapStats
+
+	// Throughput is smoothed current rate, it's updated every second
+	Throughput ThroughputStats
+}
+
+type ConnectionsStats struct {
+	ConnectedPeersCount  int
+	OpenConnectionsCount int
+	LastTrimAgo          time.Duration `swaggertype:"primitive,integer"`
+	// RefusedCount is the number of connections refused by connection gater since start
+	RefusedCount int64
+	// Greylist limits inbound connections and auth requests of unknown peers
+	Greylist GreylistStats
+}
+
+type StreamsStats struct {
+	OpenStreamsCount int64
+	// ByProtocol is a count of open streams by protocol and direction (inbound/outbound)
+	ByProtocol map[protocol.ID]map[string]int
+	// History are counters of streams by protocol since start
+	History map[protocol.ID]StreamHistory
+}
+
+type BandwidthStats struct {
+	Total      metrics.Stats
+	ByProtocol map[protocol.ID]metrics.Stats
+	// ByCategory is bandwidth by traffic category: vpn, forwarding, services, control.
+	// It counts only streams payload, so its sum is less than Total which includes connections overhead
+	ByCategory map[string]metrics.Stats
+}
+
+type DHTStats struct {
+	RoutingTableSize    int
+	PeersWithAddrsCount int
+	ListenAddrs         []string
+	ObservedAddrs       []string
+}
+
+type BootstrapStats struct {
+	TotalCount     int
+	ConnectedCount int
+	// FallbackRelaysActive is true if bootstrap peers are unreachable and fallback https relays are used
+	FallbackRelaysActive bool
+	Peers                map[string]BootstrapPeerDebugInfo
+}
+
+func (p *P2p) StatsSnapshot() StatsSnapshot {
+	totalBootstraps, connectedBootstraps := p.BootstrapPeersStats()
+	listenAddrs := p.AnnouncedAs()
+	observedAddrs := p.OwnObservedAddrs()
+
+	snapshot := StatsSnapshot{
+		Version:      StatsSnapshotVersion,
+		CreatedAt:    time.Now(),
+		Uptime:       p.Uptime(),
+		Reachability: p.Reachability().String(),
+		Connections: ConnectionsStats{
+			ConnectedPeersCount:  p.ConnectedPeersCount(),
+			OpenConnectionsCount: p.OpenConnectionsCount(),
+			LastTrimAgo:          p.ConnectionsLastTrimAgo(),
+			RefusedCount:         p.RefusedConnections(),
+			Greylist:             p.GreylistStats(),
+		},
+		Streams: StreamsStats{
+			OpenStreamsCount: p.OpenStreamsCount(),
+			ByProtocol:       p.OpenStreamStats(),
+			History:          p.StreamHistoryStats(),
+		},
+		Bandwidth: BandwidthStats{
+			Total:      p.NetworkStats(),
+			ByProtocol: p.NetworkStatsByProtocol(),
+			ByCategory: p.NetworkStatsByCategory(),
+		},
+		DHT: DHTStats{
+			RoutingTableSize:    p.RoutingTableSize(),
+			PeersWithAddrsCount: p.PeersWithAddrsCount(),
+			ListenAddrs:         make([]string, 0, len(listenAddrs)),
+			ObservedAddrs:       make([]string, 0, len(observedAddrs)),
+		},
+		Bootstrap: BootstrapStats{
+			TotalCount:           totalBootstraps,
+			ConnectedCount:       connectedBootstraps,
+			FallbackRelaysActive: p.FallbackRelaysActive(),
+			Peers:                p.BootstrapPeersStatsDetailed(),
+		},
+		Throughput: p.ThroughputStats(),
+	}
+	for _, addr := range listenAddrs {
+		snapshot.DHT.ListenAddrs = append(snapshot.DHT.ListenAddrs, addr.String())
+	}
+	for _, addr := range observedAddrs {
+		snapshot.DHT.ObservedAddrs = append(snapshot.DHT.ObservedAddrs, addr.String())
+	}
+	sort.Strings(snapshot.DHT.ListenAddrs)
+	sort.Strings(snapshot.DHT.ObservedAddrs)
+
+	return snapshot
+}
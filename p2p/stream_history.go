@@ -0,0 +1,132 @@
+package p2p
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+const protocolScopePrefix = "protocol:"
+
+// StreamHistory are counters of streams of a protocol since start. Opened minus Closed is the number of open streams,
+// if it keeps growing while traffic is steady, the protocol leaks streams.
+type StreamHistory struct {
+	OpenedInbound  int64
+	OpenedOutbound int64
+	Closed         int64
+	// Errors are streams refused by resource limits and outbound streams which failed to open
+	Errors int64
+	// AvgLifetime is the average time streams are open, streams which are still open are counted with their
+	// current lifetime, so it grows with leaked streams too
+	AvgLifetime time.Duration `swaggertype:"primitive,integer"`
+}
+
+// streamHistory counts streams of protocols by trace events of resource manager, streams are attached to scopes
+// of protocols after negotiation. It's an rcmgr.TraceReporter.
+type streamHistory struct {
+	lock       sync.Mutex
+	byProtocol map[protocol.ID]*protocolStreams
+}
+
+type protocolStreams struct {
+	history StreamHistory
+	open    int64
+	// streamTime is the sum of lifetimes of all streams till lastChange, average lifetime is calculated by it
+	streamTime time.Duration
+	lastChange time.Time
+}
+
+func newStreamHistory() *streamHistory {
+	return &streamHistory{
+		byProtocol: make(map[protocol.ID]*protocolStreams),
+	}
+}
+
+// ConsumeEvent is called synchronously by resource manager, so it should be fast.
+func (h *streamHistory) ConsumeEvent(evt rcmgr.TraceEvt) {
+	switch evt.Type {
+	case rcmgr.TraceAddStreamEvt, rcmgr.TraceRemoveStreamEvt, rcmgr.TraceBlockAddStreamEvt:
+		h.consume(evt, time.Now())
+	}
+}
+
+func (h *streamHistory) consume(evt rcmgr.TraceEvt, now time.Time) {
+	proto, ok := protocolFromScope(evt.Name)
+	if !ok {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	streams := h.protocol(proto, now)
+
+	switch evt.Type {
+	case rcmgr.TraceAddStreamEvt:
+		streams.history.OpenedInbound += int64(evt.DeltaIn)
+		streams.history.OpenedOutbound += int64(evt.DeltaOut)
+		streams.open += int64(evt.DeltaIn + evt.DeltaOut)
+	case rcmgr.TraceRemoveStreamEvt:
+		// deltas of removed streams are negative
+		closed := -int64(evt.DeltaIn + evt.DeltaOut)
+		streams.history.Closed += closed
+		streams.open -= closed
+	case rcmgr.TraceBlockAddStreamEvt:
+		streams.history.Errors += int64(evt.DeltaIn + evt.DeltaOut)
+	}
+}
+
+// openFailed counts outbound streams which failed to open, e.g. the peer doesn't support the protocol.
+func (h *streamHistory) openFailed(proto protocol.ID, now time.Time) {
+	h.lock.Lock()
+	h.protocol(proto, now).history.Errors++
+	h.lock.Unlock()
+}
+
+// protocol returns counters with streamTime updated till now.
+func (h *streamHistory) protocol(proto protocol.ID, now time.Time) *protocolStreams {
+	streams, exists := h.byProtocol[proto]
+	if !exists {
+		streams = &protocolStreams{lastChange: now}
+		h.byProtocol[proto] = streams
+	}
+	streams.streamTime += time.Duration(streams.open) * now.Sub(streams.lastChange)
+	streams.lastChange = now
+	return streams
+}
+
+func (h *streamHistory) stats(now time.Time) map[protocol.ID]StreamHistory {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := make(map[protocol.ID]StreamHistory, len(h.byProtocol))
+	for proto := range h.byProtocol {
+		streams := h.protocol(proto, now)
+		history := streams.history
+		if opened := history.OpenedInbound + history.OpenedOutbound; opened > 0 {
+			history.AvgLifetime = streams.streamTime / time.Duration(opened)
+		}
+		result[proto] = history
+	}
+	return result
+}
+
+// protocolFromScope parses names of protocol scopes, e.g. "protocol:/awl/1.0.0/ping/".
+// Scopes of protocol and peer pairs and spans are skipped, their streams are counted by the protocol scope.
+func protocolFromScope(name string) (protocol.ID, bool) {
+	if !strings.HasPrefix(name, protocolScopePrefix) || rcmgr.IsSpan(name) || strings.Contains(name, ".peer:") {
+		return "", false
+	}
+	return protocol.ID(strings.TrimPrefix(name, protocolScopePrefix)), true
+}
+
+// StreamTraceReporter should be passed to resource manager of the host with rcmgr.WithTraceReporter,
+// otherwise stream history is empty.
+func (p *P2p) StreamTraceReporter() rcmgr.TraceReporter {
+	return p.streamHistory
+}
+
+// StreamHistoryStats returns stream counters by protocol since start.
+func (p *P2p) StreamHistoryStats() map[protocol.ID]StreamHistory {
+	return p.streamHistory.stats(time.Now())
+}
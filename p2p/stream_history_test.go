@@ -0,0 +1,43 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamHistory(t *testing.T) {
+	a := require.New(t)
+	history := newStreamHistory()
+	const proto = protocol.ID("/awl/1.0.0/ping/")
+
+	now := time.Now()
+	consume := func(typ rcmgr.TraceEvtTyp, name string, deltaIn, deltaOut int) {
+		history.consume(rcmgr.TraceEvt{Type: typ, Name: name, DeltaIn: deltaIn, DeltaOut: deltaOut}, now)
+	}
+	consume(rcmgr.TraceAddStreamEvt, "protocol:"+string(proto), 1, 0)
+	consume(rcmgr.TraceAddStreamEvt, "protocol:"+string(proto), 0, 1)
+	// streams are counted once by the protocol scope
+	consume(rcmgr.TraceAddStreamEvt, "protocol:"+string(proto)+".peer:12D3KooW", 1, 0)
+	consume(rcmgr.TraceAddStreamEvt, "stream-1", 1, 0)
+	consume(rcmgr.TraceBlockAddStreamEvt, "protocol:"+string(proto), 1, 0)
+
+	now = now.Add(2 * time.Second)
+	consume(rcmgr.TraceRemoveStreamEvt, "protocol:"+string(proto), -1, 0)
+	now = now.Add(2 * time.Second)
+	history.openFailed(proto, now)
+
+	stats := history.stats(now)
+	a.Len(stats, 1)
+	a.Equal(StreamHistory{
+		OpenedInbound:  1,
+		OpenedOutbound: 1,
+		Closed:         1,
+		Errors:         2,
+		// the closed stream was open for 2s, the open one is for 4s till now
+		AvgLifetime: 3 * time.Second,
+	}, stats[proto])
+}
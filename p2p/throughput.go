@@ -0,0 +1,168 @@
+package p2p
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+	throughputSampleInterval = time.Second
+	// minReportedRate is in bytes per second, peers and protocols with slower rates are omitted from stats
+	minReportedRate = 1
+)
+
+// throughputWindows are time constants of moving averages, they match fields of Throughput.
+var throughputWindows = [...]time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// Rate is throughput in bytes per second.
+type Rate struct {
+	In  float64
+	Out float64
+}
+
+// Throughput is exponential moving average of rate with 1 second, 10 seconds and 1 minute time constants.
+type Throughput struct {
+	Rate1s  Rate
+	Rate10s Rate
+	Rate1m  Rate
+}
+
+type ThroughputStats struct {
+	Total Throughput
+	// ByPeer and ByProtocol contain only active entries, rates of missing ones are zero
+	ByPeer     map[peer.ID]Throughput
+	ByProtocol map[protocol.ID]Throughput
+}
+
+// throughputMeter samples bandwidth totals periodically, so clients get smoothed rates instead of diffing totals.
+type throughputMeter struct {
+	lock       sync.Mutex
+	total      emaCounter
+	byPeer     map[peer.ID]*emaCounter
+	byProtocol map[protocol.ID]*emaCounter
+	lastSample time.Time
+}
+
+type emaCounter struct {
+	lastIn  int64
+	lastOut int64
+	rates   [len(throughputWindows)]Rate
+}
+
+func newThroughputMeter() *throughputMeter {
+	return &throughputMeter{
+		byPeer:     make(map[peer.ID]*emaCounter),
+		byProtocol: make(map[protocol.ID]*emaCounter),
+	}
+}
+
+// MaintainThroughput updates moving averages of throughput, see StatsSnapshot.Throughput.
+func (p *P2p) MaintainThroughput(ctx context.Context) {
+	ticker := time.NewTicker(throughputSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		p.throughput.sample(p.NetworkStats(), p.NetworkStatsByPeer(), p.NetworkStatsByProtocol(), time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *P2p) ThroughputStats() ThroughputStats {
+	return p.throughput.stats()
+}
+
+func (m *throughputMeter) sample(total metrics.Stats, byPeer map[peer.ID]metrics.Stats, byProtocol map[protocol.ID]metrics.Stats, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	first := m.lastSample.IsZero()
+	elapsed := now.Sub(m.lastSample)
+	m.lastSample = now
+	if !first && elapsed <= 0 {
+		return
+	}
+
+	m.total.update(total, elapsed, first)
+	sampleCounters(m.byPeer, byPeer, elapsed, first)
+	sampleCounters(m.byProtocol, byProtocol, elapsed, first)
+}
+
+// sampleCounters updates counters of keys from current totals. Keys which appeared after the first sample
+// have started from zero totals, keys which disappeared are removed.
+func sampleCounters[K comparable](counters map[K]*emaCounter, current map[K]metrics.Stats, elapsed time.Duration, first bool) {
+	for key, stats := range current {
+		counter, exists := counters[key]
+		if !exists {
+			counter = &emaCounter{}
+			counters[key] = counter
+		}
+		counter.update(stats, elapsed, first)
+	}
+	for key := range counters {
+		if _, exists := current[key]; !exists {
+			delete(counters, key)
+		}
+	}
+}
+
+func (m *throughputMeter) stats() ThroughputStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return ThroughputStats{
+		Total:      m.total.throughput(),
+		ByPeer:     activeThroughputs(m.byPeer),
+		ByProtocol: activeThroughputs(m.byProtocol),
+	}
+}
+
+func activeThroughputs[K comparable](counters map[K]*emaCounter) map[K]Throughput {
+	result := make(map[K]Throughput)
+	for key, counter := range counters {
+		if counter.active() {
+			result[key] = counter.throughput()
+		}
+	}
+	return result
+}
+
+func (c *emaCounter) update(stats metrics.Stats, elapsed time.Duration, first bool) {
+	deltaIn, deltaOut := stats.TotalIn-c.lastIn, stats.TotalOut-c.lastOut
+	c.lastIn, c.lastOut = stats.TotalIn, stats.TotalOut
+	if first {
+		return
+	}
+	// totals are reset when the reporter forgets idle entries
+	deltaIn, deltaOut = max(deltaIn, 0), max(deltaOut, 0)
+
+	seconds := elapsed.Seconds()
+	rateIn, rateOut := float64(deltaIn)/seconds, float64(deltaOut)/seconds
+	for i, window := range throughputWindows {
+		alpha := 1 - math.Exp(-seconds/window.Seconds())
+		c.rates[i].In += alpha * (rateIn - c.rates[i].In)
+		c.rates[i].Out += alpha * (rateOut - c.rates[i].Out)
+	}
+}
+
+func (c *emaCounter) active() bool {
+	for _, rate := range c.rates {
+		if rate.In >= minReportedRate || rate.Out >= minReportedRate {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *emaCounter) throughput() Throughput {
+	return Throughput{Rate1s: c.rates[0], Rate10s: c.rates[1], Rate1m: c.rates[2]}
+}
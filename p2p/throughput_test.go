@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThroughputMeter(t *testing.T) {
+	a := require.New(t)
+	meter := newThroughputMeter()
+	const (
+		peerID = peer.ID("peer")
+		proto  = protocol.ID("/proto")
+	)
+
+	now := time.Now()
+	var total int64 = 5000
+	sample := func(active bool) {
+		byPeer := map[peer.ID]metrics.Stats{peerID: {TotalIn: total, TotalOut: total / 2}}
+		byProtocol := map[protocol.ID]metrics.Stats{}
+		if active {
+			byProtocol[proto] = metrics.Stats{TotalIn: total}
+		}
+		meter.sample(metrics.Stats{TotalIn: total, TotalOut: total / 2}, byPeer, byProtocol, now)
+	}
+
+	// the first sample only remembers totals
+	sample(true)
+	stats := meter.stats()
+	a.Equal(Throughput{}, stats.Total)
+	a.Empty(stats.ByPeer)
+
+	// constant rate of 1000 B/s in and 500 B/s out
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		total += 1000
+		sample(true)
+	}
+	stats = meter.stats()
+	a.InDelta(1000, stats.Total.Rate1s.In, 1)
+	a.InDelta(500, stats.Total.Rate1s.Out, 1)
+	// longer averages converge slower
+	a.Less(stats.Total.Rate10s.In, stats.Total.Rate1s.In)
+	a.Less(stats.Total.Rate1m.In, stats.Total.Rate10s.In)
+	a.InDelta(1000*(1-0.3679), stats.Total.Rate10s.In, 10)
+	a.Equal(stats.Total, stats.ByPeer[peerID])
+	a.Contains(stats.ByProtocol, proto)
+
+	// traffic stops, short averages drop to zero first
+	for i := 0; i < 30; i++ {
+		now = now.Add(time.Second)
+		sample(false)
+	}
+	stats = meter.stats()
+	a.Less(stats.Total.Rate1s.In, 0.001)
+	a.Greater(stats.Total.Rate1m.In, float64(minReportedRate))
+	a.Contains(stats.ByPeer, peerID)
+	// protocol disappeared from reporter
+	a.NotContains(stats.ByProtocol, proto)
+
+	for i := 0; i < 600; i++ {
+		now = now.Add(time.Second)
+		sample(false)
+	}
+	a.Empty(meter.stats().ByPeer)
+
+	// reset totals don't produce negative rates
+	total = 0
+	now = now.Add(time.Second)
+	sample(false)
+	stats = meter.stats()
+	a.GreaterOrEqual(stats.Total.Rate1s.In, float64(0))
+	a.GreaterOrEqual(stats.Total.Rate1m.Out, float64(0))
+	a.Empty(stats.ByPeer)
+}
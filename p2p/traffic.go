@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Traffic categories, see HostConfig.TrafficCategories.
+const (
+	TrafficVPN        = "vpn"
+	TrafficForwarding = "forwarding"
+	TrafficServices   = "services"
+	// TrafficControl is traffic of protocols without category: awl control protocols, dht, identify, relay, etc.
+	TrafficControl = "control"
+)
+
+var TrafficCategories = []string{TrafficVPN, TrafficForwarding, TrafficServices, TrafficControl}
+
+// trafficReporter counts bandwidth of every traffic category separately in addition to total bandwidth.
+type trafficReporter struct {
+	metrics.Reporter
+	categories map[protocol.ID]string
+	counters   map[string]*metrics.BandwidthCounter
+}
+
+func newTrafficReporter(categories map[protocol.ID]string) *trafficReporter {
+	r := &trafficReporter{
+		Reporter:   metrics.NewBandwidthCounter(),
+		categories: categories,
+		counters:   make(map[string]*metrics.BandwidthCounter, len(TrafficCategories)),
+	}
+	for _, category := range TrafficCategories {
+		r.counters[category] = metrics.NewBandwidthCounter()
+	}
+	return r
+}
+
+func (r *trafficReporter) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	r.Reporter.LogSentMessageStream(size, proto, p)
+	counter := r.counter(proto)
+	counter.LogSentMessageStream(size, proto, p)
+	// stream logs don't change totals, they are logged separately for connections
+	counter.LogSentMessage(size)
+}
+
+func (r *trafficReporter) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	r.Reporter.LogRecvMessageStream(size, proto, p)
+	counter := r.counter(proto)
+	counter.LogRecvMessageStream(size, proto, p)
+	counter.LogRecvMessage(size)
+}
+
+func (r *trafficReporter) counter(proto protocol.ID) *metrics.BandwidthCounter {
+	category, ok := r.categories[proto]
+	if counter, exists := r.counters[category]; ok && exists {
+		return counter
+	}
+	return r.counters[TrafficControl]
+}
+
+func (r *trafficReporter) totals() map[string]metrics.Stats {
+	result := make(map[string]metrics.Stats, len(r.counters))
+	for category, counter := range r.counters {
+		result[category] = counter.GetBandwidthTotals()
+	}
+	return result
+}
+
+func (r *trafficReporter) forPeer(peerID peer.ID) map[string]metrics.Stats {
+	result := make(map[string]metrics.Stats, len(r.counters))
+	for category, counter := range r.counters {
+		result[category] = counter.GetBandwidthForPeer(peerID)
+	}
+	return result
+}
+
+// NetworkStatsByCategory returns bandwidth totals by traffic category.
+func (p *P2p) NetworkStatsByCategory() map[string]metrics.Stats {
+	return p.traffic.totals()
+}
+
+// NetworkStatsForPeerByCategory returns bandwidth with the peer by traffic category.
+func (p *P2p) NetworkStatsForPeerByCategory(peerID peer.ID) map[string]metrics.Stats {
+	return p.traffic.forPeer(peerID)
+}
@@ -0,0 +1,39 @@
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p"
+	libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// TransportsConfig enables optional transports besides QUIC and TCP, so peers could connect from networks
+// which block UDP and TCP ports other than web ones.
+type TransportsConfig struct {
+	// WebSocketListenAddrs are websocket multiaddrs, e.g. /ip4/0.0.0.0/tcp/80/ws.
+	// Websocket addresses of other peers are dialed anyway, see httpsRelayOptions
+	WebSocketListenAddrs []multiaddr.Multiaddr
+	// WebTransport is dialed and listened on the same ports as QUIC
+	WebTransport bool
+}
+
+func transportsOptions(hostConfig HostConfig, listenAddrs []multiaddr.Multiaddr) ([]libp2p.Option, []multiaddr.Multiaddr) {
+	var opts []libp2p.Option
+	var addrs []multiaddr.Multiaddr
+	transports := hostConfig.Transports
+	addrs = append(addrs, transports.WebSocketListenAddrs...)
+	if transports.WebTransport {
+		opts = append(opts, libp2p.Transport(libp2pwebtransport.New))
+		for _, addr := range listenAddrs {
+			if isQUICAddr(addr) {
+				addrs = append(addrs, addr.Encapsulate(multiaddr.StringCast("/webtransport")))
+			}
+		}
+	}
+
+	return opts, addrs
+}
+
+func isQUICAddr(addr multiaddr.Multiaddr) bool {
+	protocols := addr.Protocols()
+	return len(protocols) > 0 && protocols[len(protocols)-1].Code == multiaddr.P_QUIC_V1
+}
@@ -0,0 +1,29 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportsOptions(t *testing.T) {
+	a := require.New(t)
+	listenAddrs := UnicastListenAddrs()
+	wsAddr := multiaddr.StringCast("/ip4/0.0.0.0/tcp/80/ws")
+
+	opts, addrs := transportsOptions(HostConfig{}, listenAddrs)
+	a.Empty(opts)
+	a.Empty(addrs)
+
+	opts, addrs = transportsOptions(HostConfig{Transports: TransportsConfig{
+		WebSocketListenAddrs: []multiaddr.Multiaddr{wsAddr},
+		WebTransport:         true,
+	}}, listenAddrs)
+	a.Len(opts, 1)
+	a.Equal([]multiaddr.Multiaddr{
+		wsAddr,
+		multiaddr.StringCast("/ip4/0.0.0.0/udp/0/quic-v1/webtransport"),
+		multiaddr.StringCast("/ip6/::/udp/0/quic-v1/webtransport"),
+	}, addrs)
+}
@@ -0,0 +1,244 @@
+// Package peerimport parses peer lists exported from other mesh VPN tools.
+package peerimport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+const (
+	// FormatTailscale is an output of `tailscale status --json`.
+	FormatTailscale = "tailscale"
+	// FormatZeroTier is a member list from ZeroTier Central API: GET /api/v1/network/{networkID}/member.
+	FormatZeroTier = "zerotier"
+	// FormatNebula is an output of `nebula-cert print -json`, one or multiple certificates.
+	FormatNebula = "nebula"
+	// FormatCSV is a csv file with a header. Supported columns: name, ip, peer_id.
+	FormatCSV = "csv"
+)
+
+var Formats = []string{FormatTailscale, FormatZeroTier, FormatNebula, FormatCSV}
+
+// Peer is a peer found in the export of another tool.
+type Peer struct {
+	Name string
+	// IP is the address of the peer in the source network, if any.
+	IP string
+	// PeerID is awl peer id. Exports of other tools don't contain it, only csv could.
+	PeerID string
+}
+
+func Parse(format string, r io.Reader) ([]Peer, error) {
+	var (
+		peers []Peer
+		err   error
+	)
+	switch format {
+	case FormatTailscale:
+		peers, err = parseTailscale(r)
+	case FormatZeroTier:
+		peers, err = parseZeroTier(r)
+	case FormatNebula:
+		peers, err = parseNebula(r)
+	case FormatCSV:
+		peers, err = parseCSV(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q, supported formats: %s", format, strings.Join(Formats, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s export: %v", format, err)
+	}
+	if len(peers) == 0 {
+		return nil, errors.New("no peers found")
+	}
+
+	return peers, nil
+}
+
+type tailscaleStatus struct {
+	Peer map[string]struct {
+		HostName     string
+		DNSName      string
+		TailscaleIPs []string
+	}
+}
+
+func parseTailscale(r io.Reader) ([]Peer, error) {
+	var status tailscaleStatus
+	err := json.NewDecoder(r).Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]Peer, 0, len(status.Peer))
+	for _, tsPeer := range status.Peer {
+		name := tsPeer.HostName
+		if dnsName, _, _ := strings.Cut(tsPeer.DNSName, "."); dnsName != "" {
+			name = dnsName
+		}
+		peers = append(peers, Peer{
+			Name: name,
+			IP:   firstIPv4(tsPeer.TailscaleIPs),
+		})
+	}
+	// map iteration order is random
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+
+	return peers, nil
+}
+
+type zeroTierMember struct {
+	Name   string
+	NodeID string `json:"nodeId"`
+	Config struct {
+		IPAssignments []string `json:"ipAssignments"`
+	} `json:"config"`
+}
+
+func parseZeroTier(r io.Reader) ([]Peer, error) {
+	var members []zeroTierMember
+	err := json.NewDecoder(r).Decode(&members)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]Peer, 0, len(members))
+	for _, member := range members {
+		name := member.Name
+		if name == "" {
+			name = member.NodeID
+		}
+		peers = append(peers, Peer{
+			Name: name,
+			IP:   firstIPv4(member.Config.IPAssignments),
+		})
+	}
+
+	return peers, nil
+}
+
+type nebulaCert struct {
+	Details struct {
+		Name string   `json:"name"`
+		IPs  []string `json:"ips"`
+		IsCA bool     `json:"isCa"`
+	} `json:"details"`
+}
+
+func parseNebula(r io.Reader) ([]Peer, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []nebulaCert
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &certs)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// nebula-cert prints multiple certificates as a stream of json objects
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var cert nebulaCert
+			err = decoder.Decode(&cert)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+	}
+
+	peers := make([]Peer, 0, len(certs))
+	for _, cert := range certs {
+		if cert.Details.IsCA {
+			continue
+		}
+		ips := make([]string, 0, len(cert.Details.IPs))
+		for _, ipNet := range cert.Details.IPs {
+			ip, _, _ := strings.Cut(ipNet, "/")
+			ips = append(ips, ip)
+		}
+		peers = append(peers, Peer{
+			Name: cert.Details.Name,
+			IP:   firstIPv4(ips),
+		})
+	}
+
+	return peers, nil
+}
+
+func parseCSV(r io.Reader) ([]Peer, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"name": -1, "ip": -1, "peer_id": -1}
+	for i, column := range records[0] {
+		column = strings.ToLower(strings.TrimSpace(column))
+		switch column {
+		case "alias", "hostname":
+			column = "name"
+		case "address", "ip_address":
+			column = "ip"
+		case "peerid", "peer id":
+			column = "peer_id"
+		}
+		if _, ok := columns[column]; ok {
+			columns[column] = i
+		}
+	}
+	if columns["name"] == -1 && columns["peer_id"] == -1 {
+		return nil, errors.New("header should contain name or peer_id column")
+	}
+
+	get := func(record []string, column string) string {
+		i := columns[column]
+		if i == -1 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	peers := make([]Peer, 0, len(records)-1)
+	for _, record := range records[1:] {
+		p := Peer{
+			Name:   get(record, "name"),
+			IP:     get(record, "ip"),
+			PeerID: get(record, "peer_id"),
+		}
+		if p.Name == "" && p.PeerID == "" {
+			continue
+		}
+		peers = append(peers, p)
+	}
+
+	return peers, nil
+}
+
+func firstIPv4(ips []string) string {
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+			return ip
+		}
+	}
+	return ""
+}
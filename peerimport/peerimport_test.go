@@ -0,0 +1,77 @@
+package peerimport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []Peer
+	}{
+		{
+			name:   "tailscale",
+			format: FormatTailscale,
+			input: `{"Self": {"HostName": "me"}, "Peer": {
+				"nodekey:2": {"HostName": "Laptop", "DNSName": "laptop.tail1234.ts.net.", "TailscaleIPs": ["100.101.5.6", "fd7a:115c:a1e0::1"]},
+				"nodekey:1": {"HostName": "desktop", "DNSName": "", "TailscaleIPs": ["fd7a:115c:a1e0::2", "100.101.5.7"]}
+			}}`,
+			want: []Peer{{Name: "desktop", IP: "100.101.5.7"}, {Name: "laptop", IP: "100.101.5.6"}},
+		},
+		{
+			name:   "zerotier",
+			format: FormatZeroTier,
+			input: `[{"nodeId": "a1b2c3d4e5", "name": "nas", "config": {"ipAssignments": ["10.147.17.20"]}},
+				{"nodeId": "f6a7b8c9d0", "name": "", "config": {"ipAssignments": []}}]`,
+			want: []Peer{{Name: "nas", IP: "10.147.17.20"}, {Name: "f6a7b8c9d0"}},
+		},
+		{
+			name:   "nebula stream",
+			format: FormatNebula,
+			input: `{"details": {"name": "ca", "isCa": true}}
+				{"details": {"name": "lighthouse", "ips": ["192.168.100.1/24"]}}`,
+			want: []Peer{{Name: "lighthouse", IP: "192.168.100.1"}},
+		},
+		{
+			name:   "nebula array",
+			format: FormatNebula,
+			input:  `[{"details": {"name": "server", "ips": ["192.168.100.5/24"]}}]`,
+			want:   []Peer{{Name: "server", IP: "192.168.100.5"}},
+		},
+		{
+			name:   "csv",
+			format: FormatCSV,
+			input: "Hostname, Address, Peer ID\n" +
+				"router,192.168.1.1,12D3KooWJ7ZqTmmmhZ6hLFw4BHPs2PzBv6Vx1EvWAYdPLY4V3Dp9\n" +
+				"phone\n" +
+				",,\n",
+			want: []Peer{
+				{Name: "router", IP: "192.168.1.1", PeerID: "12D3KooWJ7ZqTmmmhZ6hLFw4BHPs2PzBv6Vx1EvWAYdPLY4V3Dp9"},
+				{Name: "phone"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers, err := Parse(tt.format, strings.NewReader(tt.input))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, peers)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse("wireguard", strings.NewReader(""))
+	require.Error(t, err)
+
+	_, err = Parse(FormatCSV, strings.NewReader("ip\n10.0.0.1\n"))
+	require.Error(t, err)
+
+	_, err = Parse(FormatZeroTier, strings.NewReader("[]"))
+	require.Error(t, err)
+}
@@ -0,0 +1,14 @@
+// Package power detects whether the device runs on battery.
+package power
+
+import (
+	"errors"
+)
+
+// ErrUnsupported is returned on platforms where the power source isn't detected.
+var ErrUnsupported = errors.New("power source detection is not supported on this platform")
+
+// OnBattery reports whether the device runs on battery, it's false for devices without battery.
+func OnBattery() (bool, error) {
+	return onBattery()
+}
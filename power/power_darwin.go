@@ -0,0 +1,17 @@
+package power
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// onBattery parses the first line of pmset, e.g. "Now drawing from 'Battery Power'".
+func onBattery() (bool, error) {
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return false, fmt.Errorf("pmset: %v", err)
+	}
+	line, _, _ := bytes.Cut(out, []byte("\n"))
+	return bytes.Contains(line, []byte("'Battery Power'")), nil
+}
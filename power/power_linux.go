@@ -0,0 +1,50 @@
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+func onBattery() (bool, error) {
+	return onBatteryFromSysfs(powerSupplyDir)
+}
+
+// onBatteryFromSysfs reads power supplies of the kernel, the device runs on battery if a battery is discharging
+// and no mains adapter is online. Batteries of peripherals, e.g. mouse, have scope Device and are skipped.
+func onBatteryFromSysfs(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	var discharging bool
+	for _, entry := range entries {
+		supplyDir := filepath.Join(dir, entry.Name())
+		switch readAttr(supplyDir, "type") {
+		case "Mains", "USB":
+			if readAttr(supplyDir, "online") == "1" {
+				return false, nil
+			}
+		case "Battery":
+			if readAttr(supplyDir, "scope") == "Device" {
+				continue
+			}
+			if readAttr(supplyDir, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+
+	return discharging, nil
+}
+
+func readAttr(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
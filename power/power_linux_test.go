@@ -0,0 +1,45 @@
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnBatteryFromSysfs(t *testing.T) {
+	a := require.New(t)
+	writeSupply := func(dir, name string, attrs map[string]string) {
+		supplyDir := filepath.Join(dir, name)
+		a.NoError(os.MkdirAll(supplyDir, 0o755))
+		for attr, value := range attrs {
+			a.NoError(os.WriteFile(filepath.Join(supplyDir, attr), []byte(value+"\n"), 0o644))
+		}
+	}
+
+	// desktop without battery
+	dir := t.TempDir()
+	writeSupply(dir, "AC", map[string]string{"type": "Mains", "online": "0"})
+	onBattery, err := onBatteryFromSysfs(dir)
+	a.NoError(err)
+	a.False(onBattery)
+
+	// discharging laptop, battery of the mouse is skipped
+	dir = t.TempDir()
+	writeSupply(dir, "AC", map[string]string{"type": "Mains", "online": "0"})
+	writeSupply(dir, "BAT0", map[string]string{"type": "Battery", "status": "Discharging"})
+	writeSupply(dir, "hidpp_battery_0", map[string]string{"type": "Battery", "scope": "Device", "status": "Discharging"})
+	onBattery, err = onBatteryFromSysfs(dir)
+	a.NoError(err)
+	a.True(onBattery)
+
+	// plugged in laptop, the battery could report discharging while the adapter is online
+	writeSupply(dir, "AC", map[string]string{"online": "1"})
+	onBattery, err = onBatteryFromSysfs(dir)
+	a.NoError(err)
+	a.False(onBattery)
+
+	_, err = onBatteryFromSysfs(filepath.Join(dir, "missing"))
+	a.Error(err)
+}
@@ -0,0 +1,33 @@
+package power
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGetSystemPowerStatus = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+const (
+	acLineOffline    = 0
+	batteryFlagNoBat = 128
+)
+
+// systemPowerStatus is SYSTEM_POWER_STATUS of win32 api.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+func onBattery() (bool, error) {
+	var status systemPowerStatus
+	ret, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status)))
+	if ret == 0 {
+		return false, err
+	}
+	return status.ACLineStatus == acLineOffline && status.BatteryFlag&batteryFlagNoBat == 0, nil
+}
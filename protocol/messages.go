@@ -0,0 +1,469 @@
+package protocol
+
+// Field numbers of messages in protobuf wire format, they must never be reused or changed.
+
+func (m *PeerStatusInfo) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendBool(b, 2, m.Declined)
+	b = appendBool(b, 3, m.AllowUsingAsExitNode)
+	b = appendStrings(b, 4, m.DNSRecords)
+	b = appendStrings(b, 5, m.SubnetRoutes)
+	b = appendUint(b, 6, uint64(m.Time))
+	b = appendString(b, 7, m.IPAddr)
+	return b
+}
+
+func (m *PeerStatusInfo) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Name, err = v.String()
+		case 2:
+			m.Declined, err = v.Bool()
+		case 3:
+			m.AllowUsingAsExitNode, err = v.Bool()
+		case 4:
+			var record string
+			record, err = v.String()
+			m.DNSRecords = append(m.DNSRecords, record)
+		case 5:
+			var route string
+			route, err = v.String()
+			m.SubnetRoutes = append(m.SubnetRoutes, route)
+		case 6:
+			var t uint64
+			t, err = v.Uint()
+			m.Time = int64(t)
+		case 7:
+			m.IPAddr, err = v.String()
+		}
+		return err
+	})
+}
+
+func (m *AuthPeer) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendBytes(b, 2, m.Invite)
+	if m.Migration != nil {
+		b = appendBytes(b, 3, m.Migration.MarshalWire(nil))
+	}
+	b = appendBytes(b, 4, m.Rendezvous)
+	return b
+}
+
+func (m *AuthPeer) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Name, err = v.String()
+		case 2:
+			m.Invite, err = v.Bytes()
+		case 3:
+			var data []byte
+			data, err = v.Bytes()
+			if err != nil {
+				return err
+			}
+			m.Migration = new(IdentityMigration)
+			err = m.Migration.UnmarshalWire(data)
+		case 4:
+			m.Rendezvous, err = v.Bytes()
+		}
+		return err
+	})
+}
+
+func (m *AuthPeerResponse) MarshalWire(b []byte) []byte {
+	b = appendBool(b, 1, m.Confirmed)
+	b = appendBool(b, 2, m.Declined)
+	b = appendBool(b, 3, m.Migrated)
+	return b
+}
+
+func (m *AuthPeerResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Confirmed, err = v.Bool()
+		case 2:
+			m.Declined, err = v.Bool()
+		case 3:
+			m.Migrated, err = v.Bool()
+		}
+		return err
+	})
+}
+
+func (m *IdentityMigration) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.OldPeerID)
+	b = appendString(b, 2, m.NewPeerID)
+	b = appendUint(b, 3, uint64(m.Time))
+	b = appendBytes(b, 4, m.Signature)
+	return b
+}
+
+func (m *IdentityMigration) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.OldPeerID, err = v.String()
+		case 2:
+			m.NewPeerID, err = v.String()
+		case 3:
+			var t uint64
+			t, err = v.Uint()
+			m.Time = int64(t)
+		case 4:
+			m.Signature, err = v.Bytes()
+		}
+		return err
+	})
+}
+
+func (m *BackupRequest) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Action)
+	b = appendString(b, 2, m.OwnerPeerID)
+	b = appendBytes(b, 3, m.RestoreTokenHash)
+	b = appendBytes(b, 4, m.RestoreToken)
+	b = appendBytes(b, 5, m.Data)
+	return b
+}
+
+func (m *BackupRequest) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Action, err = v.String()
+		case 2:
+			m.OwnerPeerID, err = v.String()
+		case 3:
+			m.RestoreTokenHash, err = v.Bytes()
+		case 4:
+			m.RestoreToken, err = v.Bytes()
+		case 5:
+			m.Data, err = v.Bytes()
+		}
+		return err
+	})
+}
+
+func (m *BackupResponse) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Error)
+	b = appendBytes(b, 2, m.Data)
+	return b
+}
+
+func (m *BackupResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		case 2:
+			m.Data, err = v.Bytes()
+		}
+		return err
+	})
+}
+
+func (m *SupportRequest) MarshalWire(b []byte) []byte {
+	return appendInt(b, 1, m.LogMinutes)
+}
+
+func (m *SupportRequest) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.LogMinutes, err = v.Int()
+		}
+		return err
+	})
+}
+
+func (m *SupportResponse) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Error)
+	b = appendString(b, 2, m.Logs)
+	b = appendBytes(b, 3, m.Diagnostics)
+	return b
+}
+
+func (m *SupportResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		case 2:
+			m.Logs, err = v.String()
+		case 3:
+			m.Diagnostics, err = v.Bytes()
+		}
+		return err
+	})
+}
+
+func (m *ProxyRequest) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Address)
+	b = appendString(b, 2, m.Session)
+	b = appendBool(b, 3, m.Resume)
+	b = appendUint(b, 4, m.Received)
+	return b
+}
+
+func (m *ProxyRequest) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Address, err = v.String()
+		case 2:
+			m.Session, err = v.String()
+		case 3:
+			m.Resume, err = v.Bool()
+		case 4:
+			m.Received, err = v.Uint()
+		}
+		return err
+	})
+}
+
+func (m *ProxyResponse) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Error)
+	b = appendBool(b, 2, m.Resumable)
+	b = appendUint(b, 3, m.Received)
+	return b
+}
+
+func (m *ProxyResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		case 2:
+			m.Resumable, err = v.Bool()
+		case 3:
+			m.Received, err = v.Uint()
+		}
+		return err
+	})
+}
+
+func (m *FileTransferRequest) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendUint(b, 2, uint64(m.Size))
+	b = appendBytes(b, 3, m.SHA256)
+	return b
+}
+
+func (m *FileTransferRequest) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Name, err = v.String()
+		case 2:
+			var size uint64
+			size, err = v.Uint()
+			m.Size = int64(size)
+		case 3:
+			m.SHA256, err = v.Bytes()
+		}
+		return err
+	})
+}
+
+func (m *FileTransferResponse) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Error)
+	b = appendUint(b, 2, uint64(m.Offset))
+	return b
+}
+
+func (m *FileTransferResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		case 2:
+			var offset uint64
+			offset, err = v.Uint()
+			m.Offset = int64(offset)
+		}
+		return err
+	})
+}
+
+func (m *FileTransferResult) MarshalWire(b []byte) []byte {
+	return appendString(b, 1, m.Error)
+}
+
+func (m *FileTransferResult) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		}
+		return err
+	})
+}
+
+func (m *TextMessage) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Text)
+	b = appendUint(b, 2, uint64(m.Time))
+	return b
+}
+
+func (m *TextMessage) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Text, err = v.String()
+		case 2:
+			var t uint64
+			t, err = v.Uint()
+			m.Time = int64(t)
+		}
+		return err
+	})
+}
+
+func (m *TextMessageResponse) MarshalWire(b []byte) []byte {
+	return appendString(b, 1, m.Error)
+}
+
+func (m *TextMessageResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		}
+		return err
+	})
+}
+
+func (m *PortScanRequest) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Host)
+	b = appendString(b, 2, m.Ports)
+	return b
+}
+
+func (m *PortScanRequest) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Host, err = v.String()
+		case 2:
+			m.Ports, err = v.String()
+		}
+		return err
+	})
+}
+
+func (m *PortScanResponse) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Error)
+	for i := range m.Open {
+		b = appendBytes(b, 2, m.Open[i].MarshalWire(nil))
+	}
+	return b
+}
+
+func (m *PortScanResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		case 2:
+			var data []byte
+			data, err = v.Bytes()
+			if err != nil {
+				return err
+			}
+			var port OpenPort
+			err = port.UnmarshalWire(data)
+			m.Open = append(m.Open, port)
+		}
+		return err
+	})
+}
+
+func (m *OpenPort) MarshalWire(b []byte) []byte {
+	b = appendInt(b, 1, m.Port)
+	b = appendString(b, 2, m.Banner)
+	return b
+}
+
+func (m *OpenPort) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Port, err = v.Int()
+		case 2:
+			m.Banner, err = v.String()
+		}
+		return err
+	})
+}
+
+func (m *GroupMembershipRequest) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Group)
+	return b
+}
+
+func (m *GroupMembershipRequest) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Group, err = v.String()
+		}
+		return err
+	})
+}
+
+func (m *GroupMembershipResponse) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Error)
+	b = appendBytes(b, 2, m.Membership.MarshalWire(nil))
+	return b
+}
+
+func (m *GroupMembershipResponse) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Error, err = v.String()
+		case 2:
+			var data []byte
+			data, err = v.Bytes()
+			if err != nil {
+				return err
+			}
+			err = m.Membership.UnmarshalWire(data)
+		}
+		return err
+	})
+}
+
+func (m *GroupMembership) MarshalWire(b []byte) []byte {
+	b = appendString(b, 1, m.Group)
+	b = appendString(b, 2, m.OwnerPeerID)
+	b = appendStrings(b, 3, m.Members)
+	b = appendUint(b, 4, uint64(m.Time))
+	b = appendBytes(b, 5, m.Signature)
+	return b
+}
+
+func (m *GroupMembership) UnmarshalWire(b []byte) error {
+	return consumeFields(b, func(v wireValue) (err error) {
+		switch v.num {
+		case 1:
+			m.Group, err = v.String()
+		case 2:
+			m.OwnerPeerID, err = v.String()
+		case 3:
+			var member string
+			member, err = v.String()
+			m.Members = append(m.Members, member)
+		case 4:
+			var t uint64
+			t, err = v.Uint()
+			m.Time = int64(t)
+		case 5:
+			m.Signature, err = v.Bytes()
+		}
+		return err
+	})
+}
@@ -3,9 +3,15 @@ package protocol
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strconv"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
@@ -13,9 +19,91 @@ const (
 	Version  = "0.3.0"
 	basePath = "/awl/" + Version
 
-	AuthMethod         protocol.ID = basePath + "/auth/"
-	GetStatusMethod    protocol.ID = basePath + "/status/"
-	TunnelPacketMethod protocol.ID = basePath + "/tunnel/"
+	// Messages of auth and status protocols are envelopes, see WriteMessage.
+	// Legacy methods with JSON messages are served for peers of older versions, see StreamFormat.
+	AuthMethod            protocol.ID = basePath + "/auth/v2/"
+	GetStatusMethod       protocol.ID = basePath + "/status/v2/"
+	LegacyAuthMethod      protocol.ID = basePath + "/auth/"
+	LegacyGetStatusMethod protocol.ID = basePath + "/status/"
+	TunnelPacketMethod    protocol.ID = basePath + "/tunnel/"
+	// TunnelExitPacketMethod is for packets to and from the internet through exit node,
+	// their internet address is kept instead of being replaced with the peer address
+	TunnelExitPacketMethod protocol.ID = basePath + "/tunnel_exit/"
+	EchoMethod             protocol.ID = basePath + "/echo/"
+	BackupMethod           protocol.ID = basePath + "/backup/"
+	// SharedFolderMethod streams are HTTP connections to the peer's WebDAV shared folder
+	SharedFolderMethod protocol.ID = basePath + "/shared_folder/"
+	SupportMethod      protocol.ID = basePath + "/support/"
+	// ProxyMethod streams are TCP connections made by the peer on our behalf, they start with ProxyRequest and ProxyResponse
+	ProxyMethod protocol.ID = basePath + "/proxy/"
+	// PingMethod streams echo small payload back, they are used to monitor latency and reachability of known peers
+	PingMethod protocol.ID = basePath + "/ping/"
+	// ProbeMethod streams are measurements of known peers: payload size (uint32) and payload are answered with
+	// receive and transmit time of the peer (unix nanoseconds, uint64) and the same payload
+	ProbeMethod protocol.ID = basePath + "/probe/"
+	// ManagementMethod streams are HTTP connections to the peer's web api, they are allowed by the peer's grant
+	ManagementMethod protocol.ID = basePath + "/management/"
+	// FileTransferMethod streams send a file to the peer inbox: FileTransferRequest and FileTransferResponse
+	// are followed by file data from the response offset and FileTransferResult
+	FileTransferMethod protocol.ID = basePath + "/file_transfer/"
+	// MessageMethod streams deliver TextMessage to the peer, it's acknowledged with TextMessageResponse
+	MessageMethod protocol.ID = basePath + "/message/"
+	// SpeedTestMethod streams measure throughput of one direction: direction (byte) and duration in milliseconds (uint32)
+	// are followed by generated data from the sender until the duration is over. Upload is answered with received
+	// bytes and nanoseconds from the first to the last byte (uint64).
+	SpeedTestMethod protocol.ID = basePath + "/speedtest/"
+	// PortScanMethod streams probe which TCP ports are listening on the peer or a host of its advertised routes,
+	// PortScanRequest is answered with PortScanResponse. They are allowed by the peer's management grant
+	PortScanMethod protocol.ID = basePath + "/port_scan/"
+	// GroupMembershipMethod streams fetch GroupMembership of the group published by the peer,
+	// GroupMembershipRequest is answered with GroupMembershipResponse. Only members of the group are answered
+	GroupMembershipMethod protocol.ID = basePath + "/group_membership/"
+	// DNSMethod streams resolve one DNS query with the peer's upstream dns, the query and the response are written
+	// with WriteDNSMessage. They are allowed by the peer's exit node grant
+	DNSMethod protocol.ID = basePath + "/dns/"
+
+	// SharedFolderPathPrefix is followed by peer id of the folder owner in WebDAV request paths, e.g. /shared/12D3KooW.../file.txt
+	SharedFolderPathPrefix = "/shared/"
+	// ManagementPathPrefix is followed by peer id and path of the peer's api, e.g. /management/12D3KooW.../api/v0/peers/get_known
+	ManagementPathPrefix = "/management/"
+
+	// MaxEchoPayloadSize is the max number of bytes echoed back in a single stream.
+	MaxEchoPayloadSize = 1 << 20
+	// MaxBackupSize is the max size of encrypted backup which peers store for each other.
+	MaxBackupSize = 4 << 20
+	// MaxSupportLogMinutes limits the period of logs which could be requested by a support peer.
+	MaxSupportLogMinutes = 24 * 60
+	// MaxSupportLogSize is the max size of logs sent to a support peer, the oldest lines are dropped.
+	MaxSupportLogSize = 8 << 20
+	// MaxFileNameLength limits names of transferred files, it's the usual limit of file systems.
+	MaxFileNameLength = 255
+	// MaxTextMessageLength is the max size of text messages in bytes.
+	MaxTextMessageLength = 4 << 10
+	// MaxSpeedTestSeconds is the max duration of each direction of a speed test.
+	MaxSpeedTestSeconds = 20
+	// MaxPortScanPorts is the max number of ports probed by a single port scan.
+	MaxPortScanPorts = 4096
+	// MaxPortBannerLength limits banners of open ports in port scan responses.
+	MaxPortBannerLength = 64
+	// MaxGroupMembers is the max number of members of a shared group.
+	MaxGroupMembers = 1024
+)
+
+// identityMigrationSignaturePrefix separates signatures of identity migrations from other data signed by peer keys.
+const identityMigrationSignaturePrefix = "awl identity migration\n"
+
+// groupMembershipSignaturePrefix separates signatures of group memberships from other data signed by peer keys.
+const groupMembershipSignaturePrefix = "awl group membership\n"
+
+const (
+	BackupActionStore   = "store"
+	BackupActionRestore = "restore"
+)
+
+// Directions of SpeedTestMethod streams, upload is from the stream opener to the peer.
+const (
+	SpeedTestUpload   byte = 1
+	SpeedTestDownload byte = 2
 )
 
 type (
@@ -23,60 +111,501 @@ type (
 		Name                 string
 		Declined             bool
 		AllowUsingAsExitNode bool
+		// DNSRecords are additional names which point to the peer, see config.P2pNodeConfig.DNSRecords
+		DNSRecords []string `json:",omitempty"`
+		// SubnetRoutes are networks reachable through the peer, see config.VPNConfig.AdvertisedRoutes
+		SubnetRoutes []string `json:",omitempty"`
+		// Time is the sender clock in unix milliseconds, it's used to detect clock skew between peers
+		Time int64 `json:",omitempty"`
+		// IPAddr is the sender's own address in its vpn network, peers with negotiated addresses use it for the sender
+		IPAddr string `json:",omitempty"`
+		// LocalAddrs are the sender's listen multiaddrs on its network interfaces, peers on the same host
+		// or in the same local network connect to them directly
+		LocalAddrs []string `json:",omitempty"`
 	}
 )
 
-func ReceiveStatus(stream io.Reader) (PeerStatusInfo, error) {
+func ReceiveStatus(stream io.Reader, format Format) (PeerStatusInfo, error) {
 	statusInfo := PeerStatusInfo{}
-	err := json.NewDecoder(stream).Decode(&statusInfo)
+	err := readMessage(stream, format, &statusInfo, MaxMessageSize)
 	return statusInfo, err
 }
 
-func SendStatus(stream io.Writer, statusInfo PeerStatusInfo) error {
-	err := json.NewEncoder(stream).Encode(&statusInfo)
-	return err
+func SendStatus(stream io.Writer, format Format, statusInfo PeerStatusInfo) error {
+	return writeMessage(stream, format, &statusInfo)
+}
+
+type (
+	BackupRequest struct {
+		Action string
+		// OwnerPeerID is the peer whose backup should be restored. Store is allowed only for own backup.
+		OwnerPeerID string
+		// RestoreTokenHash is sent with store action, peer should send the token itself to restore the backup
+		RestoreTokenHash []byte
+		RestoreToken     []byte
+		// Data is an encrypted backup
+		Data []byte
+	}
+	BackupResponse struct {
+		Error string
+		Data  []byte
+	}
+)
+
+func ReceiveBackupRequest(stream io.Reader) (BackupRequest, error) {
+	request := BackupRequest{}
+	err := ReadMessage(stream, &request, MaxBackupSize+MaxMessageSize)
+	return request, err
+}
+
+func SendBackupRequest(stream io.Writer, request BackupRequest) error {
+	return WriteMessage(stream, &request)
+}
+
+func ReceiveBackupResponse(stream io.Reader) (BackupResponse, error) {
+	response := BackupResponse{}
+	err := ReadMessage(stream, &response, MaxBackupSize+MaxMessageSize)
+	return response, err
+}
+
+func SendBackupResponse(stream io.Writer, response BackupResponse) error {
+	return WriteMessage(stream, &response)
+}
+
+type (
+	SupportRequest struct {
+		// LogMinutes is the period of the latest logs to send
+		LogMinutes int
+	}
+	SupportResponse struct {
+		Error string
+		// Logs are log lines in the same format as they are written, the oldest first
+		Logs string
+		// Diagnostics is json encoded report about the peer state
+		Diagnostics json.RawMessage
+	}
+)
+
+func ReceiveSupportRequest(stream io.Reader) (SupportRequest, error) {
+	request := SupportRequest{}
+	err := ReadMessage(stream, &request, 1<<10)
+	return request, err
+}
+
+func SendSupportRequest(stream io.Writer, request SupportRequest) error {
+	return WriteMessage(stream, &request)
+}
+
+func ReceiveSupportResponse(stream io.Reader) (SupportResponse, error) {
+	response := SupportResponse{}
+	// diagnostics are added to logs
+	err := ReadMessage(stream, &response, MaxSupportLogSize*2)
+	return response, err
+}
+
+func SendSupportResponse(stream io.Writer, response SupportResponse) error {
+	return WriteMessage(stream, &response)
+}
+
+type (
+	ProxyRequest struct {
+		// Address is host:port of TCP destination, host could be a domain name which is resolved by the proxy peer
+		Address string
+		// Session is the id of resumable connection, its data is sent in frames after the response, see AppendProxyFrame.
+		// Peers of older versions ignore it and don't confirm it in ProxyResponse.Resumable
+		Session string
+		// Resume attaches the stream to the existing session instead of making new connection
+		Resume bool
+		// Received is the number of bytes of the session received by us
+		Received uint64
+	}
+	ProxyResponse struct {
+		// Error is empty if the connection is established, the stream is the connection after the response
+		Error string
+		// Resumable confirms ProxyRequest.Session
+		Resumable bool
+		// Received is the number of bytes of the resumed session received by the proxy peer
+		Received uint64
+	}
+)
+
+const (
+	// Frames of resumable proxy sessions are kind (byte), payload size (uint32) and payload.
+	ProxyFrameData byte = 1
+	// ProxyFrameAck payload is the number of bytes of the session received by the sender (uint64)
+	ProxyFrameAck byte = 2
+	// ProxyFrameClose is sent after the last data, the connection is closed for writing by the sender
+	ProxyFrameClose byte = 3
+
+	MaxProxyFrameSize    = 64 << 10
+	proxyFrameHeaderSize = 5
+)
+
+func ReceiveProxyRequest(stream io.Reader) (ProxyRequest, error) {
+	request := ProxyRequest{}
+	err := ReadMessage(stream, &request, 1<<10)
+	return request, err
+}
+
+func SendProxyRequest(stream io.Writer, request ProxyRequest) error {
+	return WriteMessage(stream, &request)
+}
+
+func ReceiveProxyResponse(stream io.Reader) (ProxyResponse, error) {
+	response := ProxyResponse{}
+	err := ReadMessage(stream, &response, 1<<10)
+	return response, err
+}
+
+func SendProxyResponse(stream io.Writer, response ProxyResponse) error {
+	return WriteMessage(stream, &response)
+}
+
+// AppendProxyFrame appends the frame of resumable proxy session, frames are appended to one buffer to send them
+// with one write.
+func AppendProxyFrame(b []byte, kind byte, payload []byte) []byte {
+	b = append(b, kind)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	return append(b, payload...)
+}
+
+// ReadProxyFrame reads the frame to buf, which should fit MaxProxyFrameSize.
+func ReadProxyFrame(stream io.Reader, buf []byte) (kind byte, payload []byte, err error) {
+	header := buf[:proxyFrameHeaderSize]
+	_, err = io.ReadFull(stream, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	kind = header[0]
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxProxyFrameSize || int(size) > len(buf) {
+		return 0, nil, fmt.Errorf("proxy frame size %d exceeds limit %d", size, MaxProxyFrameSize)
+	}
+	payload = buf[:size]
+	_, err = io.ReadFull(stream, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return kind, payload, nil
+}
+
+type (
+	FileTransferRequest struct {
+		// Name is the file name without directories
+		Name string
+		Size int64
+		// SHA256 of the whole file identifies the transfer, so the interrupted one is resumed by the same request
+		SHA256 []byte
+	}
+	FileTransferResponse struct {
+		// Error is empty if the file is accepted, then the sender writes file data from Offset
+		Error string
+		// Offset is the size of the part received before
+		Offset int64
+	}
+	FileTransferResult struct {
+		// Error is empty if the whole file is received and its hash matches
+		Error string
+	}
+)
+
+func ReceiveFileTransferRequest(stream io.Reader) (FileTransferRequest, error) {
+	request := FileTransferRequest{}
+	err := ReadMessage(stream, &request, 1<<10)
+	return request, err
+}
+
+func SendFileTransferRequest(stream io.Writer, request FileTransferRequest) error {
+	return WriteMessage(stream, &request)
+}
+
+func ReceiveFileTransferResponse(stream io.Reader) (FileTransferResponse, error) {
+	response := FileTransferResponse{}
+	err := ReadMessage(stream, &response, 1<<10)
+	return response, err
+}
+
+func SendFileTransferResponse(stream io.Writer, response FileTransferResponse) error {
+	return WriteMessage(stream, &response)
+}
+
+func ReceiveFileTransferResult(stream io.Reader) (FileTransferResult, error) {
+	result := FileTransferResult{}
+	err := ReadMessage(stream, &result, 1<<10)
+	return result, err
+}
+
+func SendFileTransferResult(stream io.Writer, result FileTransferResult) error {
+	return WriteMessage(stream, &result)
+}
+
+type (
+	TextMessage struct {
+		Text string
+		// Time is the sender clock in unix milliseconds
+		Time int64
+	}
+	TextMessageResponse struct {
+		// Error is empty if the message is stored by the peer
+		Error string
+	}
+)
+
+func ReceiveTextMessage(stream io.Reader) (TextMessage, error) {
+	message := TextMessage{}
+	err := ReadMessage(stream, &message, MaxTextMessageLength+1<<10)
+	return message, err
+}
+
+func SendTextMessage(stream io.Writer, message TextMessage) error {
+	return WriteMessage(stream, &message)
+}
+
+func ReceiveTextMessageResponse(stream io.Reader) (TextMessageResponse, error) {
+	response := TextMessageResponse{}
+	err := ReadMessage(stream, &response, 1<<10)
+	return response, err
+}
+
+func SendTextMessageResponse(stream io.Writer, response TextMessageResponse) error {
+	return WriteMessage(stream, &response)
+}
+
+type (
+	PortScanRequest struct {
+		// Host is IPv4 address from advertised routes of the peer, empty for the peer itself
+		Host string
+		// Ports are single ports and ranges separated by commas, e.g. "22,80,8000-8100"
+		Ports string
+	}
+	PortScanResponse struct {
+		Error string
+		Open  []OpenPort
+	}
+	OpenPort struct {
+		Port int
+		// Banner is the first line sent by the service right after connection, e.g. SSH version.
+		// It's empty for most protocols, where clients speak first
+		Banner string
+	}
+)
+
+func ReceivePortScanRequest(stream io.Reader) (PortScanRequest, error) {
+	request := PortScanRequest{}
+	err := ReadMessage(stream, &request, 1<<10)
+	return request, err
+}
+
+func SendPortScanRequest(stream io.Writer, request PortScanRequest) error {
+	return WriteMessage(stream, &request)
+}
+
+func ReceivePortScanResponse(stream io.Reader) (PortScanResponse, error) {
+	response := PortScanResponse{}
+	// every port is a nested message with port number and banner
+	err := ReadMessage(stream, &response, MaxPortScanPorts*(MaxPortBannerLength+16))
+	return response, err
+}
+
+func SendPortScanResponse(stream io.Writer, response PortScanResponse) error {
+	return WriteMessage(stream, &response)
+}
+
+type (
+	GroupMembershipRequest struct {
+		Group string
+	}
+	GroupMembershipResponse struct {
+		Error      string
+		Membership GroupMembership
+	}
+)
+
+// GroupMembership is the list of members of the group, it's signed by the owner of the group.
+// Peers which trust the owner apply it to groups of their known peers, so the group is managed in one place.
+type GroupMembership struct {
+	Group       string
+	OwnerPeerID string
+	// Members are sorted peer ids, the owner is a member too
+	Members []string
+	// Time is unix milliseconds of signing, older memberships are ignored
+	Time      int64
+	Signature []byte
+}
+
+// NewGroupMembership signs the list of members with the key of the owner.
+func NewGroupMembership(ownerKey crypto.PrivKey, group string, members []string, now time.Time) (GroupMembership, error) {
+	ownerPeerID, err := peer.IDFromPrivateKey(ownerKey)
+	if err != nil {
+		return GroupMembership{}, err
+	}
+	membership := GroupMembership{
+		Group:       group,
+		OwnerPeerID: ownerPeerID.String(),
+		Members:     members,
+		Time:        now.UnixMilli(),
+	}
+	membership.Signature, err = ownerKey.Sign(membership.signedData())
+	if err != nil {
+		return GroupMembership{}, fmt.Errorf("sign group membership: %v", err)
+	}
+	return membership, nil
+}
+
+// Verify checks the signature by the public key of the owner.
+func (m GroupMembership) Verify() error {
+	ownerPeerID, err := peer.Decode(m.OwnerPeerID)
+	if err != nil {
+		return fmt.Errorf("invalid owner peer id: %v", err)
+	}
+	if len(m.Members) > MaxGroupMembers {
+		return fmt.Errorf("too many members, max %d", MaxGroupMembers)
+	}
+	pubKey, err := ownerPeerID.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("extract public key of owner peer id: %v", err)
+	}
+	ok, err := pubKey.Verify(m.signedData(), m.Signature)
+	if err != nil {
+		return fmt.Errorf("verify signature: %v", err)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func (m GroupMembership) signedData() []byte {
+	data := groupMembershipSignaturePrefix + m.OwnerPeerID + "\n" + m.Group + "\n" + strconv.FormatInt(m.Time, 10)
+	for _, member := range m.Members {
+		data += "\n" + member
+	}
+	return []byte(data)
+}
+
+func ReceiveGroupMembershipRequest(stream io.Reader) (GroupMembershipRequest, error) {
+	request := GroupMembershipRequest{}
+	err := ReadMessage(stream, &request, 1<<10)
+	return request, err
+}
+
+func SendGroupMembershipRequest(stream io.Writer, request GroupMembershipRequest) error {
+	return WriteMessage(stream, &request)
+}
+
+func ReceiveGroupMembershipResponse(stream io.Reader) (GroupMembershipResponse, error) {
+	response := GroupMembershipResponse{}
+	// peer ids are about 52 bytes in text form
+	err := ReadMessage(stream, &response, MaxGroupMembers*64+MaxMessageSize)
+	return response, err
+}
+
+func SendGroupMembershipResponse(stream io.Writer, response GroupMembershipResponse) error {
+	return WriteMessage(stream, &response)
 }
 
 type AuthPeer struct {
 	Name string
+	// Invite is the secret from invite token issued by the receiver, such requests are accepted without confirmation
+	Invite []byte `json:",omitempty"`
+	// Migration is sent to trusted peers after rotation of our identity key, instead of a friend request
+	Migration *IdentityMigration `json:",omitempty"`
+	// Rendezvous proves that we know the rendezvous phrase of the receiver, such requests are accepted without confirmation
+	Rendezvous []byte `json:",omitempty"`
 }
 
 type AuthPeerResponse struct {
 	Confirmed bool
 	Declined  bool
+	// Migrated is true if the peer has replaced our old peer id with the new one from AuthPeer.Migration
+	Migrated bool `json:",omitempty"`
+}
+
+// IdentityMigration is a statement that the peer has replaced its identity key, it's signed by the old key.
+// Peers which trust the old peer id move it to the new one, so they don't have to add the peer again.
+type IdentityMigration struct {
+	OldPeerID string
+	NewPeerID string
+	// Time is unix milliseconds of the rotation
+	Time      int64
+	Signature []byte
+}
+
+// NewIdentityMigration signs the statement with the old key.
+func NewIdentityMigration(oldKey crypto.PrivKey, newPeerID peer.ID, now time.Time) (IdentityMigration, error) {
+	oldPeerID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return IdentityMigration{}, err
+	}
+	migration := IdentityMigration{
+		OldPeerID: oldPeerID.String(),
+		NewPeerID: newPeerID.String(),
+		Time:      now.UnixMilli(),
+	}
+	migration.Signature, err = oldKey.Sign(migration.signedData())
+	if err != nil {
+		return IdentityMigration{}, fmt.Errorf("sign identity migration: %v", err)
+	}
+	return migration, nil
 }
 
-func ReceiveAuth(stream io.Reader) (AuthPeer, error) {
+// Verify checks the signature by the public key of the old peer id.
+func (m IdentityMigration) Verify() error {
+	oldPeerID, err := peer.Decode(m.OldPeerID)
+	if err != nil {
+		return fmt.Errorf("invalid old peer id: %v", err)
+	}
+	newPeerID, err := peer.Decode(m.NewPeerID)
+	if err != nil {
+		return fmt.Errorf("invalid new peer id: %v", err)
+	}
+	if oldPeerID == newPeerID {
+		return errors.New("peer id is not changed")
+	}
+	pubKey, err := oldPeerID.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("extract public key of old peer id: %v", err)
+	}
+	ok, err := pubKey.Verify(m.signedData(), m.Signature)
+	if err != nil {
+		return fmt.Errorf("verify signature: %v", err)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func (m IdentityMigration) signedData() []byte {
+	return []byte(identityMigrationSignaturePrefix + m.OldPeerID + "\n" + m.NewPeerID + "\n" + strconv.FormatInt(m.Time, 10))
+}
+
+func ReceiveAuth(stream io.Reader, format Format) (AuthPeer, error) {
 	authPeer := AuthPeer{}
-	err := json.NewDecoder(stream).Decode(&authPeer)
+	err := readMessage(stream, format, &authPeer, MaxMessageSize)
 	return authPeer, err
 }
 
-func SendAuth(stream io.Writer, authPeer AuthPeer) error {
-	err := json.NewEncoder(stream).Encode(&authPeer)
-	return err
+func SendAuth(stream io.Writer, format Format, authPeer AuthPeer) error {
+	return writeMessage(stream, format, &authPeer)
 }
 
-func ReceiveAuthResponse(stream io.Reader) (AuthPeerResponse, error) {
+func ReceiveAuthResponse(stream io.Reader, format Format) (AuthPeerResponse, error) {
 	response := AuthPeerResponse{}
-	err := json.NewDecoder(stream).Decode(&response)
+	err := readMessage(stream, format, &response, MaxMessageSize)
 	return response, err
 }
 
-func SendAuthResponse(stream io.Writer, response AuthPeerResponse) error {
-	err := json.NewEncoder(stream).Encode(&response)
-	return err
+func SendAuthResponse(stream io.Writer, format Format, response AuthPeerResponse) error {
+	return writeMessage(stream, format, &response)
 }
 
 func ReadUint64(stream io.Reader) (uint64, error) {
 	var data [8]byte
-	n, err := stream.Read(data[:])
+	_, err := io.ReadFull(stream, data[:])
 	if err != nil {
 		return 0, err
 	}
-	if n != 8 {
-		return 0, fmt.Errorf("invalid uint64 data: %v. read %d instead of 8", data, n)
-	}
 
 	value := binary.BigEndian.Uint64(data[:])
 	return value, nil
@@ -88,3 +617,35 @@ func WriteUint64(stream io.Writer, number uint64) error {
 	_, err := stream.Write(data[:])
 	return err
 }
+
+// WriteDNSMessage writes packed DNS message prefixed with two-byte length, the same as DNS over TCP.
+func WriteDNSMessage(stream io.Writer, msg []byte) error {
+	if len(msg) > math.MaxUint16 {
+		return fmt.Errorf("dns message is too big: %d bytes", len(msg))
+	}
+	b := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(msg)), uint16(len(msg)))
+	_, err := stream.Write(append(b, msg...))
+	return err
+}
+
+// ReadDNSMessage reads packed DNS message written with WriteDNSMessage.
+func ReadDNSMessage(stream io.Reader) ([]byte, error) {
+	var length [2]byte
+	_, err := io.ReadFull(stream, length[:])
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(stream, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// AppendTunnelPacket appends packet with its length, the same as WriteUint64 and write of packet.
+// Packets are appended to one buffer to send them with one write.
+func AppendTunnelPacket(b []byte, packet []byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(len(packet)))
+	return append(b, packet...)
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendTunnelPacket(t *testing.T) {
+	a := require.New(t)
+
+	packets := [][]byte{[]byte("first packet"), {}, bytes.Repeat([]byte{0xab}, 3000)}
+	var buf []byte
+	for _, packet := range packets {
+		buf = AppendTunnelPacket(buf, packet)
+	}
+
+	// coalesced packets are read the same way as written separately, even with short reads
+	stream := iotest.OneByteReader(bytes.NewReader(buf))
+	for _, packet := range packets {
+		size, err := ReadUint64(stream)
+		a.NoError(err)
+		a.EqualValues(len(packet), size)
+		data, err := io.ReadAll(io.LimitReader(stream, int64(size)))
+		a.NoError(err)
+		a.Equal(packet, data)
+	}
+	_, err := ReadUint64(stream)
+	a.ErrorIs(err, io.EOF)
+}
+
+func TestProxyFrames(t *testing.T) {
+	a := require.New(t)
+
+	payload := bytes.Repeat([]byte{0xab}, MaxProxyFrameSize)
+	var data []byte
+	data = AppendProxyFrame(data, ProxyFrameData, payload)
+	data = AppendProxyFrame(data, ProxyFrameAck, []byte{0, 0, 0, 0, 0, 0, 0, 42})
+	data = AppendProxyFrame(data, ProxyFrameClose, nil)
+
+	stream := iotest.OneByteReader(bytes.NewReader(data))
+	buf := make([]byte, MaxProxyFrameSize)
+	for _, expected := range []struct {
+		kind    byte
+		payload []byte
+	}{
+		{ProxyFrameData, payload},
+		{ProxyFrameAck, []byte{0, 0, 0, 0, 0, 0, 0, 42}},
+		{ProxyFrameClose, []byte{}},
+	} {
+		kind, received, err := ReadProxyFrame(stream, buf)
+		a.NoError(err)
+		a.Equal(expected.kind, kind)
+		a.Equal(expected.payload, received)
+	}
+	_, _, err := ReadProxyFrame(stream, buf)
+	a.ErrorIs(err, io.EOF)
+
+	data = AppendProxyFrame(nil, ProxyFrameData, make([]byte, MaxProxyFrameSize+1))
+	_, _, err = ReadProxyFrame(bytes.NewReader(data), buf)
+	a.ErrorContains(err, "exceeds limit")
+}
+
+func TestDNSMessage(t *testing.T) {
+	a := require.New(t)
+
+	var buf bytes.Buffer
+	query := []byte("packed query")
+	a.NoError(WriteDNSMessage(&buf, query))
+	a.NoError(WriteDNSMessage(&buf, nil))
+	a.Equal([]byte{0, byte(len(query))}, buf.Bytes()[:2])
+
+	stream := iotest.OneByteReader(&buf)
+	msg, err := ReadDNSMessage(stream)
+	a.NoError(err)
+	a.Equal(query, msg)
+	msg, err = ReadDNSMessage(stream)
+	a.NoError(err)
+	a.Empty(msg)
+	_, err = ReadDNSMessage(stream)
+	a.ErrorIs(err, io.EOF)
+
+	a.Error(WriteDNSMessage(&buf, make([]byte, 1<<16)))
+}
@@ -0,0 +1,217 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// EnvelopeVersion is the first byte of every message, it's followed by uvarint payload length and payload.
+	// Payload of version 1 is a message in protobuf wire format, see Message.
+	EnvelopeVersion = 1
+	// MaxMessageSize is the default limit of message payload.
+	MaxMessageSize = 64 << 10
+)
+
+// Format is the encoding of messages in a stream, it's negotiated with protocol id when the stream is opened.
+type Format int
+
+const (
+	FormatEnvelope Format = iota
+	// FormatLegacyJSON is JSON without envelope, it's used by peers which don't support envelopes yet
+	FormatLegacyJSON
+)
+
+var errWireType = errors.New("unexpected wire type")
+
+// Message is encoded in protobuf wire format without generated code. Field numbers must never be reused,
+// unknown fields are skipped, so fields could be added without breaking peers of other versions.
+type Message interface {
+	MarshalWire(b []byte) []byte
+	UnmarshalWire(b []byte) error
+}
+
+// StreamFormat returns format of messages for the negotiated protocol of a stream.
+func StreamFormat(proto protocol.ID) Format {
+	switch proto {
+	case LegacyAuthMethod, LegacyGetStatusMethod:
+		return FormatLegacyJSON
+	default:
+		return FormatEnvelope
+	}
+}
+
+// WriteMessage writes the message in envelope.
+func WriteMessage(stream io.Writer, msg Message) error {
+	payload := msg.MarshalWire(nil)
+	data := make([]byte, 0, 1+protowire.SizeVarint(uint64(len(payload)))+len(payload))
+	data = append(data, EnvelopeVersion)
+	data = protowire.AppendVarint(data, uint64(len(payload)))
+	data = append(data, payload...)
+	_, err := stream.Write(data)
+	return err
+}
+
+// ReadMessage reads the message in envelope with payload up to maxSize bytes.
+func ReadMessage(stream io.Reader, msg Message, maxSize int) error {
+	reader := byteReader{stream}
+	version, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != EnvelopeVersion {
+		return fmt.Errorf("unsupported envelope version %d", version)
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return fmt.Errorf("read message size: %v", err)
+	}
+	if size > uint64(maxSize) {
+		return fmt.Errorf("message size %d exceeds limit %d", size, maxSize)
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(stream, payload)
+	if err != nil {
+		return err
+	}
+
+	return msg.UnmarshalWire(payload)
+}
+
+func writeMessage(stream io.Writer, format Format, msg Message) error {
+	if format == FormatLegacyJSON {
+		return json.NewEncoder(stream).Encode(msg)
+	}
+	return WriteMessage(stream, msg)
+}
+
+func readMessage(stream io.Reader, format Format, msg Message, maxSize int) error {
+	if format == FormatLegacyJSON {
+		return json.NewDecoder(io.LimitReader(stream, int64(maxSize))).Decode(msg)
+	}
+	return ReadMessage(stream, msg, maxSize)
+}
+
+// byteReader reads single bytes without buffering, so nothing after the envelope header is consumed.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var data [1]byte
+	_, err := io.ReadFull(r.Reader, data[:])
+	return data[0], err
+}
+
+// wireValue is a raw field value of a message.
+type wireValue struct {
+	num protowire.Number
+	typ protowire.Type
+	raw []byte
+}
+
+// consumeFields calls fn for every field of the message in order, fn should ignore unknown fields.
+func consumeFields(b []byte, fn func(v wireValue) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return fmt.Errorf("field %d: %v", num, protowire.ParseError(n))
+		}
+		err := fn(wireValue{num: num, typ: typ, raw: b[:n]})
+		if err != nil {
+			return fmt.Errorf("field %d: %v", num, err)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (v wireValue) Uint() (uint64, error) {
+	if v.typ != protowire.VarintType {
+		return 0, errWireType
+	}
+	value, n := protowire.ConsumeVarint(v.raw)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	return value, nil
+}
+
+func (v wireValue) Int() (int, error) {
+	value, err := v.Uint()
+	return int(int64(value)), err
+}
+
+func (v wireValue) Bool() (bool, error) {
+	value, err := v.Uint()
+	return value != 0, err
+}
+
+func (v wireValue) Bytes() ([]byte, error) {
+	if v.typ != protowire.BytesType {
+		return nil, errWireType
+	}
+	value, n := protowire.ConsumeBytes(v.raw)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	return value, nil
+}
+
+func (v wireValue) String() (string, error) {
+	value, err := v.Bytes()
+	return string(value), err
+}
+
+// append functions skip zero values as protobuf does, except elements of repeated fields.
+
+func appendUint(b []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+func appendInt(b []byte, num protowire.Number, value int) []byte {
+	return appendUint(b, num, uint64(int64(value)))
+}
+
+func appendBool(b []byte, num protowire.Number, value bool) []byte {
+	return appendUint(b, num, protowire.EncodeBool(value))
+}
+
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendStrings(b []byte, num protowire.Number, values []string) []byte {
+	for _, value := range values {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, value)
+	}
+	return b
+}
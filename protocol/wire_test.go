@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestMessageEnvelope(t *testing.T) {
+	a := require.New(t)
+
+	statusInfo := PeerStatusInfo{
+		Name:                 "peer",
+		AllowUsingAsExitNode: true,
+		DNSRecords:           []string{"plex", "nas"},
+		SubnetRoutes:         []string{"192.168.10.0/24"},
+		Time:                 1700000000123,
+		IPAddr:               "10.66.0.7",
+	}
+	buf := new(bytes.Buffer)
+	a.NoError(SendStatus(buf, FormatEnvelope, statusInfo))
+	a.Equal(byte(EnvelopeVersion), buf.Bytes()[0])
+	buf.WriteString("rest")
+	received, err := ReceiveStatus(buf, FormatEnvelope)
+	a.NoError(err)
+	a.Equal(statusInfo, received)
+	// nothing after the message is consumed
+	a.Equal("rest", buf.String())
+
+	buf.Reset()
+	a.NoError(SendStatus(buf, FormatLegacyJSON, statusInfo))
+	a.Equal(byte('{'), buf.Bytes()[0])
+	received, err = ReceiveStatus(buf, FormatLegacyJSON)
+	a.NoError(err)
+	a.Equal(statusInfo, received)
+
+	request := SupportRequest{LogMinutes: 30}
+	buf.Reset()
+	a.NoError(SendSupportRequest(buf, request))
+	receivedRequest, err := ReceiveSupportRequest(buf)
+	a.NoError(err)
+	a.Equal(request, receivedRequest)
+
+	authPeer := AuthPeer{Name: "peer", Invite: []byte("secret"), Rendezvous: []byte("proof")}
+	buf.Reset()
+	a.NoError(SendAuth(buf, FormatEnvelope, authPeer))
+	receivedAuth, err := ReceiveAuth(buf, FormatEnvelope)
+	a.NoError(err)
+	a.Equal(authPeer, receivedAuth)
+
+	oldKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	a.NoError(err)
+	_, newPubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	a.NoError(err)
+	newPeerID, err := peer.IDFromPublicKey(newPubKey)
+	a.NoError(err)
+	migration, err := NewIdentityMigration(oldKey, newPeerID, time.UnixMilli(1700000000123))
+	a.NoError(err)
+	a.NoError(migration.Verify())
+	authPeer = AuthPeer{Name: "peer", Migration: &migration}
+	buf.Reset()
+	a.NoError(SendAuth(buf, FormatEnvelope, authPeer))
+	receivedAuth, err = ReceiveAuth(buf, FormatEnvelope)
+	a.NoError(err)
+	a.Equal(authPeer, receivedAuth)
+	a.NoError(receivedAuth.Migration.Verify())
+	forged := migration
+	forged.NewPeerID = migration.OldPeerID
+	a.Error(forged.Verify())
+	forged.NewPeerID, forged.Time = migration.NewPeerID, migration.Time+1
+	a.ErrorContains(forged.Verify(), "invalid signature")
+
+	membership, err := NewGroupMembership(oldKey, "work", []string{migration.OldPeerID, newPeerID.String()}, time.UnixMilli(1700000000123))
+	a.NoError(err)
+	membershipResponse := GroupMembershipResponse{Membership: membership}
+	buf.Reset()
+	a.NoError(SendGroupMembershipResponse(buf, membershipResponse))
+	receivedMembershipResponse, err := ReceiveGroupMembershipResponse(buf)
+	a.NoError(err)
+	a.Equal(membershipResponse, receivedMembershipResponse)
+	a.NoError(receivedMembershipResponse.Membership.Verify())
+	forgedMembership := membership
+	forgedMembership.Members = membership.Members[:1]
+	a.ErrorContains(forgedMembership.Verify(), "invalid signature")
+
+	fileRequest := FileTransferRequest{Name: "photo.jpg", Size: 1 << 40, SHA256: bytes.Repeat([]byte{0xab}, 32)}
+	buf.Reset()
+	a.NoError(SendFileTransferRequest(buf, fileRequest))
+	receivedFileRequest, err := ReceiveFileTransferRequest(buf)
+	a.NoError(err)
+	a.Equal(fileRequest, receivedFileRequest)
+
+	proxyRequest := ProxyRequest{Address: "example.com:22", Session: "0123456789abcdef", Resume: true, Received: 1 << 33}
+	buf.Reset()
+	a.NoError(SendProxyRequest(buf, proxyRequest))
+	receivedProxyRequest, err := ReceiveProxyRequest(buf)
+	a.NoError(err)
+	a.Equal(proxyRequest, receivedProxyRequest)
+
+	textMessage := TextMessage{Text: "rebooting the shared server", Time: 1700000000123}
+	buf.Reset()
+	a.NoError(SendTextMessage(buf, textMessage))
+	receivedTextMessage, err := ReceiveTextMessage(buf)
+	a.NoError(err)
+	a.Equal(textMessage, receivedTextMessage)
+
+	portScanResponse := PortScanResponse{Open: []OpenPort{{Port: 22, Banner: "SSH-2.0-OpenSSH_9.6"}, {Port: 80}}}
+	buf.Reset()
+	a.NoError(SendPortScanResponse(buf, portScanResponse))
+	receivedPortScanResponse, err := ReceivePortScanResponse(buf)
+	a.NoError(err)
+	a.Equal(portScanResponse, receivedPortScanResponse)
+
+	buf.Reset()
+	a.NoError(SendBackupResponse(buf, BackupResponse{Data: make([]byte, MaxBackupSize+MaxMessageSize+1)}))
+	_, err = ReceiveBackupResponse(buf)
+	a.ErrorContains(err, "exceeds limit")
+
+	_, err = ReceiveAuth(bytes.NewReader([]byte{EnvelopeVersion + 1, 0}), FormatEnvelope)
+	a.ErrorContains(err, "unsupported envelope version")
+}
+
+func TestMessageUnknownFields(t *testing.T) {
+	a := require.New(t)
+
+	// message of a newer version with unknown fields of all wire types
+	payload := (&AuthPeerResponse{Confirmed: true}).MarshalWire(nil)
+	payload = protowire.AppendTag(payload, 100, protowire.VarintType)
+	payload = protowire.AppendVarint(payload, 5)
+	payload = protowire.AppendTag(payload, 101, protowire.BytesType)
+	payload = protowire.AppendString(payload, "new field")
+	payload = protowire.AppendTag(payload, 102, protowire.Fixed64Type)
+	payload = protowire.AppendFixed64(payload, 7)
+	payload = appendBool(payload, 2, true)
+
+	response := AuthPeerResponse{}
+	a.NoError(response.UnmarshalWire(payload))
+	a.Equal(AuthPeerResponse{Confirmed: true, Declined: true}, response)
+
+	// known field with unexpected wire type
+	payload = protowire.AppendTag(nil, 1, protowire.BytesType)
+	payload = protowire.AppendString(payload, "true")
+	a.Error(response.UnmarshalWire(payload))
+	a.Error(response.UnmarshalWire([]byte{0x08}))
+}
@@ -0,0 +1,83 @@
+// Package rendezvous derives DHT namespace and friend request proofs from a secret phrase. Peers which share
+// the phrase advertise themselves under the namespace, find each other and prove knowledge of the phrase
+// in friend requests, so they are accepted without confirmation.
+package rendezvous
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// MinPhraseLength is the min number of characters of normalized phrase, anyone who guesses the phrase
+	// becomes a friend, so it should be long enough, e.g. several random words
+	MinPhraseLength = 16
+
+	namespacePrefix = "/awl/rendezvous/"
+	// salt is fixed, since peers derive the same keys without exchanging anything
+	salt    = "awl rendezvous"
+	keySize = 32
+)
+
+var ErrShortPhrase = fmt.Errorf("phrase should be at least %d characters", MinPhraseLength)
+
+// Secret is derived from the phrase with argon2, so the phrase can't be brute-forced from the namespace cheaply.
+type Secret struct {
+	// Namespace is advertised in DHT, it doesn't reveal proofKey
+	Namespace string
+	proofKey  []byte
+}
+
+// NormalizePhrase makes phrases which are typed differently equal: case and extra spaces are ignored.
+func NormalizePhrase(phrase string) string {
+	return strings.Join(strings.Fields(strings.ToLower(phrase)), " ")
+}
+
+// ValidatePhrase checks length of the normalized phrase, empty phrase disables rendezvous and is valid.
+func ValidatePhrase(phrase string) error {
+	phrase = NormalizePhrase(phrase)
+	if phrase != "" && len([]rune(phrase)) < MinPhraseLength {
+		return ErrShortPhrase
+	}
+	return nil
+}
+
+// Derive derives namespace and proof key from the phrase. It takes a while and uses 64 MiB of memory,
+// so the result should be kept while the phrase isn't changed.
+func Derive(phrase string) (Secret, error) {
+	phrase = NormalizePhrase(phrase)
+	if phrase == "" {
+		return Secret{}, errors.New("empty phrase")
+	}
+	if err := ValidatePhrase(phrase); err != nil {
+		return Secret{}, err
+	}
+	key := argon2.IDKey([]byte(phrase), []byte(salt), 1, 64*1024, 4, 2*keySize)
+	return Secret{
+		Namespace: namespacePrefix + hex.EncodeToString(key[:keySize]),
+		proofKey:  key[keySize:],
+	}, nil
+}
+
+// Proof proves to the receiver of the friend request that the sender knows the phrase.
+// It's bound to both peers, so the receiver can't reuse it with other peers.
+func (s Secret) Proof(from, to peer.ID) []byte {
+	mac := hmac.New(sha256.New, s.proofKey)
+	mac.Write([]byte(from.String() + "\n" + to.String()))
+	return mac.Sum(nil)
+}
+
+// Verify checks the proof of the sender.
+func (s Secret) Verify(from, to peer.ID, proof []byte) bool {
+	if len(s.proofKey) == 0 || len(proof) == 0 {
+		return false
+	}
+	return hmac.Equal(s.Proof(from, to), proof)
+}
@@ -0,0 +1,51 @@
+package rendezvous
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func newPeerID(t *testing.T) peer.ID {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	peerID, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return peerID
+}
+
+func TestDerive(t *testing.T) {
+	secret, err := Derive("Correct Horse  battery staple")
+	require.NoError(t, err)
+	same, err := Derive(" correct horse battery STAPLE\n")
+	require.NoError(t, err)
+	require.Equal(t, secret.Namespace, same.Namespace)
+	other, err := Derive("correct horse battery staples")
+	require.NoError(t, err)
+	require.NotEqual(t, secret.Namespace, other.Namespace)
+
+	_, err = Derive("short phrase")
+	require.ErrorIs(t, err, ErrShortPhrase)
+	_, err = Derive("  ")
+	require.Error(t, err)
+	require.NoError(t, ValidatePhrase(""))
+}
+
+func TestProof(t *testing.T) {
+	secret, err := Derive("correct horse battery staple")
+	require.NoError(t, err)
+	other, err := Derive("correct horse battery staples")
+	require.NoError(t, err)
+	peer1, peer2, peer3 := newPeerID(t), newPeerID(t), newPeerID(t)
+
+	proof := secret.Proof(peer1, peer2)
+	require.True(t, secret.Verify(peer1, peer2, proof))
+	require.False(t, secret.Verify(peer2, peer1, proof))
+	require.False(t, secret.Verify(peer1, peer3, proof))
+	require.False(t, other.Verify(peer1, peer2, proof))
+	require.False(t, secret.Verify(peer1, peer2, nil))
+	require.False(t, Secret{}.Verify(peer1, peer2, proof))
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/vpn"
+)
+
+const (
+	aclConnIdleTimeout = 5 * time.Minute
+	aclCleanupInterval = 10 * time.Second
+	// maxACLConns limits tracked connections of a peer, packets of untracked connections are checked by rules
+	maxACLConns = vpn.MaxFlows
+)
+
+// ACL filters packets of a peer by config.ACLRule. Connections allowed by rules are tracked,
+// so their packets pass in both directions regardless of rules for the opposite direction. It is safe for concurrent use.
+type ACL struct {
+	lock        sync.Mutex
+	source      []config.ACLRule
+	rules       []aclRule
+	conns       map[aclConnKey]time.Time
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type aclRule struct {
+	deny      bool
+	direction string
+	protocol  string
+	// portFrom and portTo are zero if the rule matches any port
+	portFrom uint16
+	portTo   uint16
+}
+
+type aclConnKey struct {
+	protocol   string
+	localPort  uint16
+	remotePort uint16
+}
+
+func NewACL() *ACL {
+	return &ACL{
+		conns: make(map[aclConnKey]time.Time),
+		now:   time.Now,
+	}
+}
+
+// ValidateACLRules returns error if any of the rules is invalid.
+func ValidateACLRules(rules []config.ACLRule) error {
+	_, err := compileACLRules(rules)
+	return err
+}
+
+// SetRules replaces rules and forgets tracked connections if rules were changed.
+// Invalid rules deny all traffic until they are fixed.
+func (a *ACL) SetRules(rules []config.ACLRule) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if slices.Equal(a.source, rules) {
+		return nil
+	}
+	compiled, err := compileACLRules(rules)
+	if err != nil {
+		compiled = []aclRule{{deny: true}}
+	}
+	a.source = slices.Clone(rules)
+	a.rules = compiled
+	clear(a.conns)
+
+	return err
+}
+
+// Allow checks packet sent to the peer (outbound) or received from it.
+func (a *ACL) Allow(packet *vpn.Packet, outbound bool) bool {
+	protocol, srcPort, dstPort, hasTransport := packet.Transport()
+	key := aclConnKey{protocol: protocol, localPort: srcPort, remotePort: dstPort}
+	direction := config.ACLDirectionOut
+	if !outbound {
+		key.localPort, key.remotePort = dstPort, srcPort
+		direction = config.ACLDirectionIn
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.rules) == 0 {
+		return true
+	}
+	now := a.now()
+	if now.Sub(a.lastCleanup) > aclCleanupInterval {
+		a.cleanupLocked(now)
+	}
+
+	if lastSeen, exists := a.conns[key]; exists && hasTransport && now.Sub(lastSeen) <= aclConnIdleTimeout {
+		a.conns[key] = now
+		return true
+	}
+	for _, rule := range a.rules {
+		if !rule.match(direction, protocol, dstPort) {
+			continue
+		}
+		if rule.deny {
+			return false
+		}
+		break
+	}
+	if hasTransport && len(a.conns) < maxACLConns {
+		a.conns[key] = now
+	}
+	return true
+}
+
+func (a *ACL) cleanupLocked(now time.Time) {
+	a.lastCleanup = now
+	for key, lastSeen := range a.conns {
+		if now.Sub(lastSeen) > aclConnIdleTimeout {
+			delete(a.conns, key)
+		}
+	}
+}
+
+// match checks the first packet of connection, dstPort is the port of the side which accepted connection.
+func (r aclRule) match(direction, protocol string, dstPort uint16) bool {
+	if r.direction != "" && r.direction != direction {
+		return false
+	}
+	if r.protocol != "" && r.protocol != protocol && !(r.protocol == config.ACLProtocolICMP && protocol == "icmpv6") {
+		return false
+	}
+	if r.portTo != 0 {
+		if protocol != config.ACLProtocolTCP && protocol != config.ACLProtocolUDP {
+			return false
+		}
+		return dstPort >= r.portFrom && dstPort <= r.portTo
+	}
+	return true
+}
+
+func compileACLRules(rules []config.ACLRule) ([]aclRule, error) {
+	if len(rules) > config.MaxACLRules {
+		return nil, fmt.Errorf("too many rules, max %d", config.MaxACLRules)
+	}
+	result := make([]aclRule, 0, len(rules))
+	for i, rule := range rules {
+		compiled := aclRule{direction: rule.Direction, protocol: rule.Protocol}
+		switch rule.Action {
+		case config.ACLActionAllow:
+		case config.ACLActionDeny:
+			compiled.deny = true
+		default:
+			return nil, fmt.Errorf("rule %d: invalid action %q", i+1, rule.Action)
+		}
+		switch rule.Direction {
+		case "", config.ACLDirectionIn, config.ACLDirectionOut:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid direction %q", i+1, rule.Direction)
+		}
+		switch rule.Protocol {
+		case "", config.ACLProtocolTCP, config.ACLProtocolUDP, config.ACLProtocolICMP:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid protocol %q", i+1, rule.Protocol)
+		}
+		if rule.Ports != "" {
+			if rule.Protocol == config.ACLProtocolICMP {
+				return nil, fmt.Errorf("rule %d: ports are not supported for icmp", i+1)
+			}
+			var err error
+			compiled.portFrom, compiled.portTo, err = parsePortRange(rule.Ports)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid ports %q: %v", i+1, rule.Ports, err)
+			}
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+// parsePortRange parses single port "22" or range "8000-8080".
+func parsePortRange(ports string) (from, to uint16, err error) {
+	fromStr, toStr, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		toStr = fromStr
+	}
+	fromValue, err := strconv.ParseUint(strings.TrimSpace(fromStr), 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	toValue, err := strconv.ParseUint(strings.TrimSpace(toStr), 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fromValue == 0 || fromValue > toValue {
+		return 0, 0, errors.New("ports should be in range 1-65535 and ascending")
+	}
+	return uint16(fromValue), uint16(toValue), nil
+}
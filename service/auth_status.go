@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"fmt"
+	"net"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -10,8 +12,12 @@ import (
 	"github.com/anywherelan/awl/awldns"
 	"github.com/anywherelan/awl/awlevent"
 	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/p2p"
 	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/rendezvous"
+	"github.com/anywherelan/awl/vpn"
 	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	libp2pProtocol "github.com/libp2p/go-libp2p/core/protocol"
@@ -23,41 +29,116 @@ const (
 )
 
 type P2p interface {
+	PeerID() peer.ID
 	ConnectPeer(ctx context.Context, peerID peer.ID) error
-	NewStream(ctx context.Context, id peer.ID, proto libp2pProtocol.ID) (network.Stream, error)
+	NewStream(ctx context.Context, id peer.ID, protos ...libp2pProtocol.ID) (network.Stream, error)
 	SubscribeConnectionEvents(onConnected, onDisconnected func(network.Network, network.Conn))
-	ProtectPeer(id peer.ID)
+	PinPeer(id peer.ID)
+	UnpinPeer(id peer.ID)
+	PeerVersion(peerID peer.ID) string
+	IsConnected(peerID peer.ID) bool
+	NetworkStatsForPeer(peerID peer.ID) metrics.Stats
+	PeerRemoteIPs(peerID peer.ID) []net.IP
+	PeerConnectionsInfo(peerID peer.ID) []p2p.ConnectionInfo
+	StatsSnapshot() p2p.StatsSnapshot
+	PowerSaveInterval(interval time.Duration) time.Duration
+	Advertise(ctx context.Context, ns string) (time.Duration, error)
+	FindPeers(ctx context.Context, ns string, limit int) ([]peer.AddrInfo, error)
+	LocalAddrs() []string
+	AddPeerLocalAddrs(peerID peer.ID, addrs []string)
 }
 
 type AuthStatus struct {
-	ingoingAuths  map[peer.ID]protocol.AuthPeer
-	outgoingAuths map[peer.ID]protocol.AuthPeer
-	authsLock     sync.RWMutex
-	logger        *log.ZapEventLogger
-	p2p           P2p
-	conf          *config.Config
-	authsEmitter  awlevent.Emitter
+	ingoingAuths        map[peer.ID]protocol.AuthPeer
+	outgoingAuths       map[peer.ID]protocol.AuthPeer
+	authsLock           sync.RWMutex
+	logger              *log.ZapEventLogger
+	p2p                 P2p
+	compat              *Compat
+	clock               *Clock
+	conf                *config.Config
+	eventbus            awlevent.Bus
+	authsEmitter        awlevent.Emitter
+	connectedEmitter    awlevent.Emitter
+	disconnectedEmitter awlevent.Emitter
+
+	connectionTypeEmitter awlevent.Emitter
+	migratedEmitter       awlevent.Emitter
+	renamedEmitter        awlevent.Emitter
+
+	// ctx bounds requests to peers which aren't made by callers, e.g. after connection, it's cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// rendezvousSecret is derived from rendezvousPhrase, see rendezvousSecretFor
+	rendezvousLock    sync.Mutex
+	rendezvousPhrase  string
+	rendezvousSecret  rendezvous.Secret
+	rendezvousRefresh chan struct{}
 }
 
-func NewAuthStatus(p2pService P2p, conf *config.Config, eventbus awlevent.Bus) *AuthStatus {
+func NewAuthStatus(p2pService P2p, conf *config.Config, eventbus awlevent.Bus, clock *Clock) *AuthStatus {
 	emitter, err := eventbus.Emitter(new(awlevent.ReceivedAuthRequest))
 	if err != nil {
 		panic(err)
 	}
+	connectedEmitter, err := eventbus.Emitter(new(awlevent.PeerConnected))
+	if err != nil {
+		panic(err)
+	}
+	disconnectedEmitter, err := eventbus.Emitter(new(awlevent.PeerDisconnected))
+	if err != nil {
+		panic(err)
+	}
+	connectionTypeEmitter, err := eventbus.Emitter(new(awlevent.PeerConnectionTypeChanged))
+	if err != nil {
+		panic(err)
+	}
+	migratedEmitter, err := eventbus.Emitter(new(awlevent.PeerIdentityMigrated))
+	if err != nil {
+		panic(err)
+	}
+	renamedEmitter, err := eventbus.Emitter(new(awlevent.PeerRenamed))
+	if err != nil {
+		panic(err)
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	auth := &AuthStatus{
-		ingoingAuths:  make(map[peer.ID]protocol.AuthPeer),
-		outgoingAuths: make(map[peer.ID]protocol.AuthPeer),
-		logger:        log.Logger("awl/service/status"),
-		p2p:           p2pService,
-		conf:          conf,
-		authsEmitter:  emitter,
+		ingoingAuths:        make(map[peer.ID]protocol.AuthPeer),
+		outgoingAuths:       make(map[peer.ID]protocol.AuthPeer),
+		logger:              log.Logger("awl/service/status"),
+		p2p:                 p2pService,
+		compat:              NewCompat(p2pService),
+		clock:               clock,
+		conf:                conf,
+		eventbus:            eventbus,
+		authsEmitter:        emitter,
+		connectedEmitter:    connectedEmitter,
+		disconnectedEmitter: disconnectedEmitter,
+
+		connectionTypeEmitter: connectionTypeEmitter,
+		migratedEmitter:       migratedEmitter,
+		renamedEmitter:        renamedEmitter,
+		ctx:                   ctx,
+		cancel:                cancel,
+		rendezvousRefresh:     make(chan struct{}, 1),
 	}
 	auth.restoreOutgoingAuths()
 	p2pService.SubscribeConnectionEvents(auth.onPeerConnected, auth.onPeerDisconnected)
 	return auth
 }
 
+// Close cancels requests to peers which are made in background.
+func (s *AuthStatus) Close() {
+	s.cancel()
+}
+
+// requestContext limits background request to the peer with config.TimeoutsConfig.PeerRequestSec.
+func (s *AuthStatus) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(s.ctx, s.conf.PeerRequestTimeout())
+}
+
 func (s *AuthStatus) StatusStreamHandler(stream network.Stream) {
 	defer func() {
 		_ = stream.Close()
@@ -65,41 +146,38 @@ func (s *AuthStatus) StatusStreamHandler(stream network.Stream) {
 
 	remotePeer := stream.Conn().RemotePeer()
 	peerID := remotePeer.String()
-	knownPeer, known := s.conf.GetPeer(peerID)
+	knownPeer, _ := s.conf.GetPeer(peerID)
 	_, isBlocked := s.conf.GetBlockedPeer(peerID)
-	if !known && !isBlocked {
-		s.logger.Infof("Unknown peer %s tried to exchange status info", peerID)
-		return
-	}
 
 	// Receiving info
-	oppositePeerInfo, err := protocol.ReceiveStatus(stream)
+	format := protocol.StreamFormat(stream.Protocol())
+	oppositePeerInfo, err := protocol.ReceiveStatus(stream, format)
 	if err != nil {
 		s.logger.Errorf("receiving status info from %s: %v", peerID, err)
 		return
 	}
+	receivedAt := time.Now()
 	s.authsLock.Lock()
 	delete(s.outgoingAuths, remotePeer)
 	s.authsLock.Unlock()
 
 	// Sending info
 	myPeerInfo := s.createPeerInfo(knownPeer, s.conf.P2pNode.Name, isBlocked)
-	err = protocol.SendStatus(stream, myPeerInfo)
+	err = protocol.SendStatus(stream, format, myPeerInfo)
 	if err != nil {
 		s.logger.Errorf("sending status info to %s as an answer: %v", peerID, err)
 	}
 
 	s.logger.Infof("successfully exchanged status info with %s (%s)", knownPeer.DisplayName(), peerID)
+	s.compat.WarnIfOutdated(remotePeer, knownPeer.DisplayName())
 	if isBlocked {
 		return
 	}
 	// Processing opposite peer info
+	s.updateClockSkew(remotePeer, knownPeer.DisplayName(), oppositePeerInfo, receivedAt)
+	s.p2p.AddPeerLocalAddrs(remotePeer, oppositePeerInfo.LocalAddrs)
 
-	// get the latest peer config to reduce race time between get and upsert (without locking)
-	// TODO: fix race completely
-	knownPeer, _ = s.conf.GetPeer(peerID)
-	newPeer := s.processPeerStatusInfo(knownPeer, oppositePeerInfo)
-	s.conf.UpsertPeer(newPeer)
+	s.updatePeerFromStatusInfo(peerID, oppositePeerInfo)
 }
 
 func (s *AuthStatus) ExchangeNewStatusInfo(ctx context.Context, remotePeerID peer.ID, knownPeer config.KnownPeer) error {
@@ -112,35 +190,41 @@ func (s *AuthStatus) ExchangeNewStatusInfo(ctx context.Context, remotePeerID pee
 		return err
 	}
 
-	stream, err := s.p2p.NewStream(ctx, remotePeerID, protocol.GetStatusMethod)
+	stream, err := s.p2p.NewStream(ctx, remotePeerID, protocol.GetStatusMethod, protocol.LegacyGetStatusMethod)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		_ = stream.Close()
 	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+	format := protocol.StreamFormat(stream.Protocol())
 
 	_, isBlocked := s.conf.GetBlockedPeer(remotePeerID.String())
 	myPeerInfo := s.createPeerInfo(knownPeer, s.conf.P2pNode.Name, isBlocked)
-	err = protocol.SendStatus(stream, myPeerInfo)
+	sentAt := time.Now()
+	err = protocol.SendStatus(stream, format, myPeerInfo)
 	if err != nil {
 		return fmt.Errorf("sending status info: %v", err)
 	}
 
-	oppositePeerInfo, err := protocol.ReceiveStatus(stream)
+	oppositePeerInfo, err := protocol.ReceiveStatus(stream, format)
 	if err != nil {
 		return fmt.Errorf("receiving status info: %v", err)
 	}
+	// peer answers after receiving our info, so its time corresponds to the middle of round trip
+	receivedAt := time.Now()
+	s.compat.WarnIfOutdated(remotePeerID, knownPeer.DisplayName())
 
 	if isBlocked {
 		return nil
 	}
+	s.updateClockSkew(remotePeerID, knownPeer.DisplayName(), oppositePeerInfo, sentAt.Add(receivedAt.Sub(sentAt)/2))
+	s.p2p.AddPeerLocalAddrs(remotePeerID, oppositePeerInfo.LocalAddrs)
 
-	// get the latest peer config to reduce race time between get and upsert (without locking)
-	// TODO: fix race completely
-	knownPeer, _ = s.conf.GetPeer(remotePeerID.String())
-	newPeer := s.processPeerStatusInfo(knownPeer, oppositePeerInfo)
-	s.conf.UpsertPeer(newPeer)
+	s.updatePeerFromStatusInfo(remotePeerID.String(), oppositePeerInfo)
 
 	return nil
 }
@@ -148,7 +232,9 @@ func (s *AuthStatus) ExchangeNewStatusInfo(ctx context.Context, remotePeerID pee
 func (s *AuthStatus) BlockPeer(peerID peer.ID, name string) {
 	s.conf.UpsertBlockedPeer(peerID.String(), name)
 	go func() {
-		_ = s.ExchangeNewStatusInfo(context.Background(), peerID, config.KnownPeer{})
+		ctx, cancel := s.requestContext()
+		defer cancel()
+		_ = s.ExchangeNewStatusInfo(ctx, peerID, config.KnownPeer{})
 	}()
 }
 
@@ -158,30 +244,85 @@ func (s *AuthStatus) createPeerInfo(peer config.KnownPeer, myPeerName string, de
 			Declined: true,
 		}
 	}
+	s.conf.RLock()
+	dnsRecords := append([]string(nil), s.conf.P2pNode.DNSRecords...)
+	subnetRoutes := append([]string(nil), s.conf.VPNConfig.AdvertisedRoutes...)
+	allowUsingAsExitNode := peer.ExitNodeAllowed(s.conf.PeerGroups)
+	localIP, _ := s.conf.VPNLocalIPMask()
+	s.conf.RUnlock()
 	myPeerInfo := protocol.PeerStatusInfo{
 		Name:                 myPeerName,
-		AllowUsingAsExitNode: peer.WeAllowUsingAsExitNode,
+		AllowUsingAsExitNode: allowUsingAsExitNode,
+		DNSRecords:           dnsRecords,
+		SubnetRoutes:         subnetRoutes,
+		Time:                 time.Now().UnixMilli(),
+		LocalAddrs:           s.p2p.LocalAddrs(),
+	}
+	if localIP != nil {
+		myPeerInfo.IPAddr = localIP.String()
 	}
 
 	return myPeerInfo
 }
 
+// updateClockSkew compares peer time from status info with our time at the moment the peer sent it.
+func (s *AuthStatus) updateClockSkew(peerID peer.ID, displayName string, peerInfo protocol.PeerStatusInfo, peerSentAt time.Time) {
+	if peerInfo.Declined || peerInfo.Time == 0 {
+		// older versions don't send time
+		return
+	}
+	s.clock.UpdatePeerSkew(peerID, displayName, time.UnixMilli(peerInfo.Time).Sub(peerSentAt))
+}
+
+func (s *AuthStatus) updatePeerFromStatusInfo(peerID string, peerInfo protocol.PeerStatusInfo) {
+	// get the latest peer config to reduce race time between get and upsert (without locking)
+	// TODO: fix race completely
+	knownPeer, _ := s.conf.GetPeer(peerID)
+	newPeer := s.processPeerStatusInfo(knownPeer, peerInfo)
+	s.conf.UpsertPeer(newPeer)
+
+	if knownPeer.Name != "" && newPeer.Name != knownPeer.Name {
+		s.logger.Infof("peer %s renamed itself from %s to %s", newPeer.DisplayName(), knownPeer.Name, newPeer.Name)
+		_ = s.renamedEmitter.Emit(awlevent.PeerRenamed{
+			PeerID:     peerID,
+			OldName:    knownPeer.Name,
+			NewName:    newPeer.Name,
+			Alias:      newPeer.Alias,
+			DomainName: newPeer.DomainName,
+		})
+	}
+}
+
 func (s *AuthStatus) processPeerStatusInfo(peer config.KnownPeer, peerInfo protocol.PeerStatusInfo) config.KnownPeer {
 	peer.LastSeen = time.Now()
 	if peerInfo.Declined {
 		peer.Declined = true
 		return peer
 	}
+	if peerInfo.Name != peer.Name {
+		peer.Alias, peer.DomainName = s.conf.FollowPeerRename(peer, peerInfo.Name)
+	}
 	peer.Name = peerInfo.Name
 	peer.Confirmed = true
 	peer.Declined = false
 	if peer.DomainName == "" {
-		peer.DomainName = awldns.TrimDomainName(peer.DisplayName())
+		peer.DomainName = s.conf.GenUniqDomainName(peer.PeerID, peer.DisplayName())
 	}
 	if peer.Alias == "" {
 		peer.Alias = s.conf.GenUniqPeerAlias(peer.Name, peer.Alias)
 	}
 	peer.AllowedUsingAsExitNode = peerInfo.AllowUsingAsExitNode
+	peer.DNSRecords = awldns.ValidDNSRecords(peerInfo.DNSRecords)
+	peer.SubnetRoutes = vpn.ValidSubnetRoutes(peerInfo.SubnetRoutes)
+
+	s.conf.RLock()
+	negotiate := s.conf.VPNConfig.IPAllocation == config.IPAllocationNegotiate && peerInfo.IPAddr != "" &&
+		peerInfo.IPAddr != peer.IPAddr && s.conf.IsFreeIPAddr(peer.PeerID, peerInfo.IPAddr)
+	s.conf.RUnlock()
+	if negotiate {
+		s.logger.Infof("peer %s uses address %s instead of %s", peer.DisplayName(), peerInfo.IPAddr, peer.IPAddr)
+		peer.IPAddr = peerInfo.IPAddr
+	}
 
 	return peer
 }
@@ -193,19 +334,41 @@ func (s *AuthStatus) AuthStreamHandler(stream network.Stream) {
 
 	remotePeer := stream.Conn().RemotePeer()
 	peerID := remotePeer.String()
-	authPeer, err := protocol.ReceiveAuth(stream)
+	format := protocol.StreamFormat(stream.Protocol())
+	authPeer, err := protocol.ReceiveAuth(stream, format)
 	if err != nil {
 		s.logger.Errorf("receiving auth from %s: %v", peerID, err)
 		return
 	}
+	if authPeer.Migration != nil {
+		migrated := true
+		err = s.receiveIdentityMigration(remotePeer, *authPeer.Migration)
+		if err != nil {
+			s.logger.Warnf("identity migration from %s (%s): %v", authPeer.Name, peerID, err)
+			migrated = false
+		}
+		err = protocol.SendAuthResponse(stream, format, protocol.AuthPeerResponse{Confirmed: migrated, Migrated: migrated})
+		if err != nil {
+			s.logger.Errorf("sending auth response to %s as an answer: %v", peerID, err)
+		}
+		return
+	}
 
 	_, isBlocked := s.conf.GetBlockedPeer(peerID)
 	_, confirmed := s.conf.GetPeer(peerID)
 	s.conf.RLock()
-	autoAccept := s.conf.P2pNode.AutoAcceptAuthRequests
+	autoAccept := s.conf.P2pNode.AutoAcceptAuthRequests || s.conf.P2pNode.EchoPeerMode
 	s.conf.RUnlock()
 
-	if !confirmed && !isBlocked && !autoAccept {
+	inviteAlias, invited := "", false
+	if !confirmed && !isBlocked {
+		inviteAlias, invited = s.useInvite(remotePeer, authPeer.Invite)
+	}
+	if !confirmed && !isBlocked && !invited {
+		invited = s.useRendezvousProof(remotePeer, authPeer.Rendezvous)
+	}
+
+	if !confirmed && !isBlocked && !autoAccept && !invited {
 		s.authsLock.Lock()
 		s.ingoingAuths[remotePeer] = authPeer
 		s.authsLock.Unlock()
@@ -214,14 +377,17 @@ func (s *AuthStatus) AuthStreamHandler(stream network.Stream) {
 			PeerID:   peerID,
 		})
 	}
-	if !confirmed && !isBlocked && autoAccept {
+	if !confirmed && !isBlocked && (autoAccept || invited) {
 		defer func() {
-			s.AddPeer(context.Background(), remotePeer, authPeer.Name, s.conf.GenUniqPeerAlias(authPeer.Name, ""), true)
+			err := s.AddPeer(s.ctx, remotePeer, authPeer.Name, s.conf.GenUniqPeerAlias(authPeer.Name, inviteAlias), "", true)
+			if err != nil {
+				s.logger.Errorf("add peer %s: %v", peerID, err)
+			}
 		}()
 	}
 
 	authResponse := protocol.AuthPeerResponse{Confirmed: confirmed, Declined: isBlocked}
-	err = protocol.SendAuthResponse(stream, authResponse)
+	err = protocol.SendAuthResponse(stream, format, authResponse)
 	if err != nil {
 		s.logger.Errorf("sending auth response to %s as an answer: %v", peerID, err)
 		return
@@ -240,20 +406,24 @@ func (s *AuthStatus) SendAuthRequest(ctx context.Context, peerID peer.ID, req pr
 		return err
 	}
 
-	stream, err := s.p2p.NewStream(ctx, peerID, protocol.AuthMethod)
+	stream, err := s.p2p.NewStream(ctx, peerID, protocol.AuthMethod, protocol.LegacyAuthMethod)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		_ = stream.Close()
 	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+	format := protocol.StreamFormat(stream.Protocol())
 
-	err = protocol.SendAuth(stream, req)
+	err = protocol.SendAuth(stream, format, req)
 	if err != nil {
 		return fmt.Errorf("sending auth: %v", err)
 	}
 
-	authResponse, err := protocol.ReceiveAuthResponse(stream)
+	authResponse, err := protocol.ReceiveAuthResponse(stream, format)
 	if err != nil {
 		return fmt.Errorf("receiving auth response from %s: %v", peerID, err)
 	}
@@ -275,10 +445,24 @@ func (s *AuthStatus) SendAuthRequest(ctx context.Context, peerID peer.ID, req pr
 	return nil
 }
 
-func (s *AuthStatus) AddPeer(ctx context.Context, peerID peer.ID, name, uniqAlias string, confirmed bool) {
+// AddPeer adds the peer with ipAddr or the address from config.VPNConfig.IPAllocation if it's empty.
+func (s *AuthStatus) AddPeer(ctx context.Context, peerID peer.ID, name, uniqAlias, ipAddr string, confirmed bool) error {
+	return s.addPeer(ctx, peerID, name, uniqAlias, ipAddr, confirmed, protocol.AuthPeer{})
+}
+
+// ImportPeer sends friend request to the peer like AddPeer.
+func (s *AuthStatus) ImportPeer(ctx context.Context, peerID peer.ID, uniqAlias, ipAddr string) error {
+	return s.addPeer(ctx, peerID, "", uniqAlias, ipAddr, false, protocol.AuthPeer{})
+}
+
+// addPeer sends friend request with invite or rendezvous proof of the request if the peer isn't confirmed.
+func (s *AuthStatus) addPeer(ctx context.Context, peerID peer.ID, name, uniqAlias, ipAddr string, confirmed bool, request protocol.AuthPeer) error {
 	s.conf.RLock()
-	ipAddr := s.conf.GenerateNextIpAddr()
+	ipAddr, err := s.conf.AllocateIPAddr(peerID.String(), ipAddr)
 	s.conf.RUnlock()
+	if err != nil {
+		return err
+	}
 	newPeerConfig := config.KnownPeer{
 		PeerID:    peerID.String(),
 		Name:      name,
@@ -287,24 +471,23 @@ func (s *AuthStatus) AddPeer(ctx context.Context, peerID peer.ID, name, uniqAlia
 		Confirmed: confirmed,
 		CreatedAt: time.Now(),
 	}
-	newPeerConfig.DomainName = awldns.TrimDomainName(newPeerConfig.DisplayName())
+	newPeerConfig.DomainName = s.conf.GenUniqDomainName(newPeerConfig.PeerID, newPeerConfig.DisplayName())
 	s.conf.RemoveBlockedPeer(peerID.String())
 	s.conf.UpsertPeer(newPeerConfig)
-	s.p2p.ProtectPeer(peerID)
 
 	go func() {
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, s.conf.PeerRequestTimeout())
 		defer cancel()
 		if !confirmed {
-			authPeer := protocol.AuthPeer{
-				Name: s.conf.P2pNode.Name,
-			}
-			_ = s.SendAuthRequest(ctx, peerID, authPeer)
+			request.Name = s.conf.P2pNode.Name
+			_ = s.SendAuthRequest(ctx, peerID, request)
 		}
 
 		knownPeer, _ := s.conf.GetPeer(peerID.String())
 		_ = s.ExchangeNewStatusInfo(ctx, peerID, knownPeer)
 	}()
+
+	return nil
 }
 
 func (s *AuthStatus) ExchangeStatusInfoWithAllKnownPeers(ctx context.Context) {
@@ -320,7 +503,9 @@ func (s *AuthStatus) ExchangeStatusInfoWithAllKnownPeers(ctx context.Context) {
 		if !exists {
 			continue
 		}
-		_ = s.ExchangeNewStatusInfo(ctx, knownPeer.PeerId(), knownPeer)
+		peerCtx, cancel := context.WithTimeout(ctx, s.conf.PeerRequestTimeout())
+		_ = s.ExchangeNewStatusInfo(peerCtx, knownPeer.PeerId(), knownPeer)
+		cancel()
 	}
 }
 
@@ -336,6 +521,7 @@ func (s *AuthStatus) BackgroundRetryAuthRequests(ctx context.Context) {
 		for peerID, auth := range outgoingAuthsCopy {
 			_ = s.SendAuthRequest(ctx, peerID, auth)
 		}
+		s.SendIdentityMigration(ctx)
 	}
 
 	ticker := time.NewTicker(backgroundRetryAuthRequests)
@@ -355,16 +541,45 @@ func (s *AuthStatus) BackgroundExchangeStatusInfo(ctx context.Context) {
 	ticker := time.NewTicker(backgroundExchangeStatusInfoInterval)
 	defer ticker.Stop()
 
+	// our name, dns records and advertised routes are sent in status info, so exchange it right after they were changed
+	statusChangedCh := make(chan struct{}, 1)
+	lastStatus := s.ownStatusFingerprint()
+	awlevent.WrapSubscriptionToCallback(ctx, func(_ interface{}) {
+		status := s.ownStatusFingerprint()
+		if status == lastStatus {
+			return
+		}
+		lastStatus = status
+		select {
+		case statusChangedCh <- struct{}{}:
+		default:
+		}
+	}, s.eventbus, new(awlevent.ConfigChanged))
+
+	lastExchange := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			s.ExchangeStatusInfoWithAllKnownPeers(ctx)
+			// periodic exchange is less frequent in power save mode, changes of our status are still sent immediately
+			if time.Since(lastExchange) < s.p2p.PowerSaveInterval(backgroundExchangeStatusInfoInterval)-backgroundExchangeStatusInfoInterval/2 {
+				continue
+			}
+		case <-statusChangedCh:
 		}
+		lastExchange = time.Now()
+		s.ExchangeStatusInfoWithAllKnownPeers(ctx)
 	}
 }
 
+func (s *AuthStatus) ownStatusFingerprint() string {
+	s.conf.RLock()
+	defer s.conf.RUnlock()
+	return s.conf.P2pNode.Name + "\n" + strings.Join(s.conf.P2pNode.DNSRecords, "\n") + "\n" +
+		strings.Join(s.conf.VPNConfig.AdvertisedRoutes, "\n")
+}
+
 func (s *AuthStatus) GetIngoingAuthRequests() map[string]protocol.AuthPeer {
 	s.authsLock.RLock()
 	defer s.authsLock.RUnlock()
@@ -392,7 +607,7 @@ func (s *AuthStatus) restoreOutgoingAuths() {
 	s.outgoingAuths = outgoingAuths
 }
 
-func (s *AuthStatus) onPeerConnected(_ network.Network, conn network.Conn) {
+func (s *AuthStatus) onPeerConnected(net network.Network, conn network.Conn) {
 	peerID := conn.RemotePeer()
 	s.authsLock.RLock()
 	authPeer, hasOutgAuth := s.outgoingAuths[peerID]
@@ -403,20 +618,36 @@ func (s *AuthStatus) onPeerConnected(_ network.Network, conn network.Conn) {
 		return
 	}
 	s.conf.UpdatePeerLastSeen(peerID.String())
+	conns := net.ConnsToPeer(peerID)
+	if known {
+		others := slices.DeleteFunc(slices.Clone(conns), func(c network.Conn) bool { return c == conn })
+		s.emitConnectionTypeChanged(peerID, p2p.ConnectionType(others), p2p.ConnectionType(conns))
+	}
+	if known && len(conns) == 1 {
+		_ = s.connectedEmitter.Emit(awlevent.PeerConnected{
+			PeerID:    peerID.String(),
+			Direction: strings.ToLower(conn.Stat().Direction.String()),
+			Address:   conn.RemoteMultiaddr().String(),
+		})
+	}
 
 	go func() {
+		ctx, cancel := s.requestContext()
+		defer cancel()
 		if hasOutgAuth {
-			err := s.SendAuthRequest(context.Background(), peerID, authPeer)
+			err := s.SendAuthRequest(ctx, peerID, authPeer)
 			if err != nil {
 				s.logger.Errorf("send auth to recently connected peer %s: %v", peerID, err)
 			}
 		}
 
 		if known {
+			s.sendIdentityMigrationTo(ctx, peerID.String())
+
 			dir := strings.ToLower(conn.Stat().Direction.String())
 			s.logger.Infof("peer '%s' connected, direction %s, address %s", knownPeer.DisplayName(), dir, conn.RemoteMultiaddr())
 
-			err := s.ExchangeNewStatusInfo(context.Background(), peerID, knownPeer)
+			err := s.ExchangeNewStatusInfo(ctx, peerID, knownPeer)
 			if err != nil && knownPeer.Confirmed {
 				s.logger.Errorf("exchange status info with recently connected peer %s (%s): %v", knownPeer.DisplayName(), peerID, err)
 			}
@@ -424,12 +655,25 @@ func (s *AuthStatus) onPeerConnected(_ network.Network, conn network.Conn) {
 	}()
 }
 
-func (s *AuthStatus) onPeerDisconnected(_ network.Network, conn network.Conn) {
+// emitConnectionTypeChanged skips disconnection, it's reported by PeerDisconnected.
+func (s *AuthStatus) emitConnectionTypeChanged(peerID peer.ID, before, after string) {
+	if before == after || after == "" {
+		return
+	}
+	_ = s.connectionTypeEmitter.Emit(awlevent.PeerConnectionTypeChanged{PeerID: peerID.String(), ConnectionType: after})
+}
+
+func (s *AuthStatus) onPeerDisconnected(net network.Network, conn network.Conn) {
 	peerID := conn.RemotePeer()
 	knownPeer, known := s.conf.GetPeer(peerID.String())
 	if !known {
 		return
 	}
 	s.conf.UpdatePeerLastSeen(peerID.String())
+	if net.Connectedness(peerID) != network.Connected {
+		_ = s.disconnectedEmitter.Emit(awlevent.PeerDisconnected{PeerID: peerID.String()})
+	}
+	conns := net.ConnsToPeer(peerID)
+	s.emitConnectionTypeChanged(peerID, p2p.ConnectionType(append(conns, conn)), p2p.ConnectionType(conns))
 	s.logger.Infof("peer '%s' disconnected, address %s", knownPeer.DisplayName(), conn.RemoteMultiaddr())
 }
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/backup"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/storage"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// backups of peers aren't included in our backups, see storage.LocalNamespace
+	receivedBackupsNamespace = "received_backups"
+	BackupStreamTimeout      = time.Minute
+	// wait for connections to peers after start
+	firstBackupDelay = 5 * time.Minute
+	// minBackupInterval prevents backups in a loop if the interval in config is invalid
+	minBackupInterval = time.Hour
+)
+
+// Backup periodically stores encrypted snapshots of config and storage on trusted peers and restores them.
+// It also stores backups of known peers.
+type Backup struct {
+	p2p      P2p
+	conf     *config.Config
+	storage  storage.Storage
+	received ds.Batching
+	logger   *log.ZapEventLogger
+}
+
+type receivedBackup struct {
+	RestoreTokenHash []byte
+	Data             []byte
+	ReceivedAt       time.Time
+}
+
+func NewBackup(p2pService P2p, conf *config.Config, s storage.Storage) *Backup {
+	return &Backup{
+		p2p:      p2pService,
+		conf:     conf,
+		storage:  s,
+		received: storage.LocalNamespace(s, receivedBackupsNamespace),
+		logger:   log.Logger("awl/service/backup"),
+	}
+}
+
+func (b *Backup) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	remotePeerID := stream.Conn().RemotePeer().String()
+	request, err := protocol.ReceiveBackupRequest(stream)
+	if err != nil {
+		b.logger.Errorf("receiving backup request from %s: %v", remotePeerID, err)
+		return
+	}
+
+	var response protocol.BackupResponse
+	switch request.Action {
+	case protocol.BackupActionStore:
+		err = b.storeReceived(remotePeerID, request)
+		if err == nil {
+			b.logger.Infof("stored backup of %s", remotePeerID)
+		}
+	case protocol.BackupActionRestore:
+		response.Data, err = b.loadReceived(request.OwnerPeerID, request.RestoreToken)
+		if err == nil {
+			b.logger.Infof("sent backup of %s to %s", request.OwnerPeerID, remotePeerID)
+		}
+	default:
+		err = fmt.Errorf("unknown action %s", request.Action)
+	}
+	if err != nil {
+		b.logger.Warnf("backup request %s from %s: %v", request.Action, remotePeerID, err)
+		response.Error = err.Error()
+	}
+
+	err = protocol.SendBackupResponse(stream, response)
+	if err != nil {
+		b.logger.Errorf("sending backup response to %s: %v", remotePeerID, err)
+	}
+}
+
+// BackupNow stores backup on all configured peers and returns errors by peer.
+func (b *Backup) BackupNow(ctx context.Context) (map[string]error, error) {
+	b.conf.RLock()
+	peers := append([]string(nil), b.conf.Backup.Peers...)
+	b.conf.RUnlock()
+	if len(peers) == 0 {
+		return nil, errors.New("backup passphrase and peers should be set in config")
+	}
+	request, err := b.newStoreRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error, len(peers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peerIDStr := range peers {
+		wg.Add(1)
+		go func(peerIDStr string) {
+			defer wg.Done()
+			peerID, err := peer.Decode(peerIDStr)
+			if err == nil {
+				_, err = b.sendRequest(ctx, peerID, request)
+			}
+			mu.Lock()
+			results[peerIDStr] = err
+			mu.Unlock()
+		}(peerIDStr)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// BackupOnPeer stores backup on the peer, which isn't required to be in configured peers.
+func (b *Backup) BackupOnPeer(ctx context.Context, peerID peer.ID) error {
+	request, err := b.newStoreRequest()
+	if err != nil {
+		return err
+	}
+	_, err = b.sendRequest(ctx, peerID, request)
+	return err
+}
+
+func (b *Backup) newStoreRequest() (protocol.BackupRequest, error) {
+	b.conf.RLock()
+	passphrase := b.conf.Backup.Passphrase
+	ownPeerID := b.conf.P2pNode.PeerID
+	b.conf.RUnlock()
+	if passphrase == "" {
+		return protocol.BackupRequest{}, errors.New("backup passphrase should be set in config")
+	}
+
+	snapshot, err := backup.NewSnapshot(b.conf, b.storage)
+	if err != nil {
+		return protocol.BackupRequest{}, err
+	}
+	data, err := backup.Seal(passphrase, snapshot)
+	if err != nil {
+		return protocol.BackupRequest{}, fmt.Errorf("encrypt backup: %v", err)
+	}
+	if len(data) > protocol.MaxBackupSize {
+		return protocol.BackupRequest{}, fmt.Errorf("backup size %d exceeds limit %d", len(data), protocol.MaxBackupSize)
+	}
+
+	return protocol.BackupRequest{
+		Action:           protocol.BackupActionStore,
+		OwnerPeerID:      ownPeerID,
+		RestoreTokenHash: backup.RestoreTokenHash(backup.RestoreToken(passphrase, ownPeerID)),
+		Data:             data,
+	}, nil
+}
+
+// Restore downloads backup of ownerPeerID from the peer and saves it to be applied on the next start.
+func (b *Backup) Restore(ctx context.Context, fromPeerID peer.ID, ownerPeerID, passphrase string) (backup.Snapshot, error) {
+	response, err := b.sendRequest(ctx, fromPeerID, protocol.BackupRequest{
+		Action:       protocol.BackupActionRestore,
+		OwnerPeerID:  ownerPeerID,
+		RestoreToken: backup.RestoreToken(passphrase, ownerPeerID),
+	})
+	if err != nil {
+		return backup.Snapshot{}, err
+	}
+	snapshot, err := backup.Open(passphrase, response.Data)
+	if err != nil {
+		return backup.Snapshot{}, err
+	}
+	err = backup.SavePendingRestore(b.conf, snapshot)
+	if err != nil {
+		return backup.Snapshot{}, fmt.Errorf("save backup: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// Export returns config and storage sealed with the passphrase, e.g. to move the node to another machine.
+// Archive without identity is a template for other nodes, see backup.Snapshot.WithoutIdentity.
+func (b *Backup) Export(passphrase string, withoutIdentity bool) ([]byte, error) {
+	snapshot, err := backup.NewSnapshot(b.conf, b.storage)
+	if err != nil {
+		return nil, err
+	}
+	if withoutIdentity {
+		snapshot, err = snapshot.WithoutIdentity()
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := backup.Seal(passphrase, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt backup: %v", err)
+	}
+	return data, nil
+}
+
+// Import saves exported archive to be applied on the next start. We keep our identity if the archive is a template.
+func (b *Backup) Import(passphrase string, data []byte) (backup.Snapshot, error) {
+	snapshot, err := backup.Open(passphrase, data)
+	if err != nil {
+		return backup.Snapshot{}, err
+	}
+	if !snapshot.HasIdentity() {
+		b.conf.RLock()
+		identity, peerID := b.conf.P2pNode.Identity, b.conf.P2pNode.PeerID
+		b.conf.RUnlock()
+		snapshot, err = snapshot.WithIdentity(identity, peerID)
+		if err != nil {
+			return backup.Snapshot{}, err
+		}
+	}
+	err = backup.SavePendingRestore(b.conf, snapshot)
+	if err != nil {
+		return backup.Snapshot{}, fmt.Errorf("save backup: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+func (b *Backup) BackgroundBackup(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(firstBackupDelay):
+	}
+
+	for {
+		b.conf.RLock()
+		enabled := b.conf.Backup.Passphrase != "" && len(b.conf.Backup.Peers) > 0
+		interval := max(time.Duration(b.conf.Backup.IntervalHours)*time.Hour, minBackupInterval)
+		b.conf.RUnlock()
+
+		if enabled {
+			results, err := b.BackupNow(ctx)
+			if err != nil {
+				b.logger.Errorf("backup: %v", err)
+			}
+			for peerID, err := range results {
+				if err != nil {
+					b.logger.Warnf("store backup on %s: %v", peerID, err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (b *Backup) sendRequest(ctx context.Context, peerID peer.ID, request protocol.BackupRequest) (protocol.BackupResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, BackupStreamTimeout)
+	defer cancel()
+
+	err := b.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return protocol.BackupResponse{}, err
+	}
+	stream, err := b.p2p.NewStream(ctx, peerID, protocol.BackupMethod)
+	if err != nil {
+		return protocol.BackupResponse{}, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	_ = stream.SetDeadline(time.Now().Add(BackupStreamTimeout))
+
+	err = protocol.SendBackupRequest(stream, request)
+	if err != nil {
+		return protocol.BackupResponse{}, fmt.Errorf("sending backup request: %v", err)
+	}
+	response, err := protocol.ReceiveBackupResponse(stream)
+	if err != nil {
+		return protocol.BackupResponse{}, fmt.Errorf("receiving backup response: %v", err)
+	}
+	if response.Error != "" {
+		return protocol.BackupResponse{}, errors.New(response.Error)
+	}
+
+	return response, nil
+}
+
+func (b *Backup) storeReceived(remotePeerID string, request protocol.BackupRequest) error {
+	knownPeer, known := b.conf.GetPeer(remotePeerID)
+	if !known || !knownPeer.Confirmed || knownPeer.Declined {
+		return errors.New("only known peers can store backups")
+	}
+	if len(request.Data) > protocol.MaxBackupSize {
+		return fmt.Errorf("backup size %d exceeds limit %d", len(request.Data), protocol.MaxBackupSize)
+	}
+	if len(request.RestoreTokenHash) == 0 {
+		return errors.New("restore token hash is empty")
+	}
+
+	data, err := json.Marshal(receivedBackup{
+		RestoreTokenHash: request.RestoreTokenHash,
+		Data:             request.Data,
+		ReceivedAt:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.received.Put(context.Background(), ds.NewKey(remotePeerID), data)
+	if err != nil {
+		b.logger.Errorf("save backup of %s: %v", remotePeerID, err)
+		return errors.New("internal error")
+	}
+	return nil
+}
+
+func (b *Backup) loadReceived(ownerPeerID string, restoreToken []byte) ([]byte, error) {
+	// keys of other backups can't be requested
+	if _, err := peer.Decode(ownerPeerID); err != nil {
+		return nil, errors.New("invalid owner peer id")
+	}
+
+	data, err := b.received.Get(context.Background(), ds.NewKey(ownerPeerID))
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, errors.New("backup not found")
+	} else if err != nil {
+		b.logger.Errorf("load backup of %s: %v", ownerPeerID, err)
+		return nil, errors.New("internal error")
+	}
+	var received receivedBackup
+	err = json.Unmarshal(data, &received)
+	if err != nil {
+		return nil, errors.New("internal error")
+	}
+	if subtle.ConstantTimeCompare(received.RestoreTokenHash, backup.RestoreTokenHash(restoreToken)) != 1 {
+		return nil, errors.New("backup not found")
+	}
+
+	return received.Data, nil
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/ntp"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// MaxClockSkew is the clock difference with NTP server or peers which is reported as a problem
+	MaxClockSkew = 30 * time.Second
+
+	ntpCheckInterval      = time.Hour
+	ntpRetryInterval      = 5 * time.Minute
+	ntpQueryTimeout       = 5 * time.Second
+	peerClockSkewLifetime = time.Hour
+)
+
+// ClockSkew is the remote clock minus ours, positive if our clock is behind.
+type ClockSkew struct {
+	Offset    time.Duration
+	CheckedAt time.Time
+}
+
+// Clock tracks skew of our clock against NTP server and peers, peers' clocks are received with status info.
+// Skew breaks TLS handshakes of relays and websocket transports in confusing ways, so it's reported in api and diagnostics.
+type Clock struct {
+	conf   *config.Config
+	logger *log.ZapEventLogger
+
+	lock     sync.RWMutex
+	ntpSkew  ClockSkew
+	peerSkew map[peer.ID]ClockSkew
+}
+
+func NewClock(conf *config.Config) *Clock {
+	return &Clock{
+		conf:     conf,
+		logger:   log.Logger("awl/service/clock"),
+		peerSkew: make(map[peer.ID]ClockSkew),
+	}
+}
+
+// BackgroundCheckNTP queries NTP server periodically, see config.ClockConfig.
+func (c *Clock) BackgroundCheckNTP(ctx context.Context) {
+	for {
+		interval := ntpCheckInterval
+		err := c.checkNTP(ctx)
+		if err != nil {
+			c.logger.Debugf("check clock against ntp server: %v", err)
+			interval = ntpRetryInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *Clock) checkNTP(ctx context.Context) error {
+	c.conf.RLock()
+	clockConfig := c.conf.Clock
+	c.conf.RUnlock()
+	if clockConfig.DisableNTP {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ntpQueryTimeout)
+	defer cancel()
+	response, err := ntp.Query(ctx, clockConfig.NTPServer)
+	if err != nil {
+		return err
+	}
+	if abs(response.Offset) > MaxClockSkew {
+		c.logger.Warnf("clock is off by %s from ntp server %s, connections with peers and relays could fail", response.Offset.Round(time.Second), clockConfig.NTPServer)
+	}
+
+	c.lock.Lock()
+	c.ntpSkew = ClockSkew{Offset: response.Offset, CheckedAt: time.Now()}
+	c.lock.Unlock()
+
+	return nil
+}
+
+// UpdatePeerSkew saves skew of the peer clock measured with its status info.
+func (c *Clock) UpdatePeerSkew(peerID peer.ID, displayName string, offset time.Duration) {
+	if abs(offset) > MaxClockSkew {
+		c.logger.Warnf("clock of peer %s (%s) is off by %s from ours", displayName, peerID, offset.Round(time.Second))
+	}
+	c.lock.Lock()
+	c.peerSkew[peerID] = ClockSkew{Offset: offset, CheckedAt: time.Now()}
+	c.lock.Unlock()
+}
+
+// NTPSkew returns our clock skew against NTP server, false if it wasn't checked yet.
+func (c *Clock) NTPSkew() (ClockSkew, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.ntpSkew, !c.ntpSkew.CheckedAt.IsZero()
+}
+
+// PeerSkew returns the latest skew of the peer clock, false if the peer didn't send its time recently.
+func (c *Clock) PeerSkew(peerID peer.ID) (ClockSkew, bool) {
+	c.lock.RLock()
+	skew, exists := c.peerSkew[peerID]
+	c.lock.RUnlock()
+	if !exists || time.Since(skew.CheckedAt) > peerClockSkewLifetime {
+		return ClockSkew{}, false
+	}
+	return skew, true
+}
+
+// Warnings describes skews bigger than MaxClockSkew.
+func (c *Clock) Warnings() []string {
+	c.lock.RLock()
+	ntpSkew := c.ntpSkew
+	peerIDs := make([]peer.ID, 0, len(c.peerSkew))
+	for peerID := range c.peerSkew {
+		peerIDs = append(peerIDs, peerID)
+	}
+	c.lock.RUnlock()
+	sort.Slice(peerIDs, func(i, j int) bool {
+		return peerIDs[i] < peerIDs[j]
+	})
+
+	warnings := make([]string, 0)
+	if !ntpSkew.CheckedAt.IsZero() && abs(ntpSkew.Offset) > MaxClockSkew {
+		warnings = append(warnings, fmt.Sprintf("clock is %s from ntp server", describeSkew(ntpSkew.Offset)))
+	}
+	for _, peerID := range peerIDs {
+		skew, ok := c.PeerSkew(peerID)
+		if !ok || abs(skew.Offset) <= MaxClockSkew {
+			continue
+		}
+		knownPeer, _ := c.conf.GetPeer(peerID.String())
+		warnings = append(warnings, fmt.Sprintf("clock is %s from peer %s", describeSkew(skew.Offset), knownPeer.DisplayName()))
+	}
+
+	return warnings
+}
+
+func describeSkew(offset time.Duration) string {
+	if offset > 0 {
+		return offset.Round(time.Second).String() + " behind"
+	}
+	return (-offset).Round(time.Second).String() + " ahead"
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// minRecommendedPeerVersion is the oldest peer version which is fully supported, users are warned to update older peers.
+const minRecommendedPeerVersion = "v0.10.0"
+
+// Compat detects versions of remote peers and warns about outdated ones. Message formats don't depend on versions,
+// they are chosen by negotiated protocols, see protocol.StreamFormat.
+type Compat struct {
+	p2p    P2p
+	logger *log.ZapEventLogger
+
+	warnedLock sync.Mutex
+	warned     map[peer.ID]string
+}
+
+func NewCompat(p2pService P2p) *Compat {
+	return &Compat{
+		p2p:    p2pService,
+		logger: log.Logger("awl/service/compat"),
+		warned: make(map[peer.ID]string),
+	}
+}
+
+// IsOutdated returns true if remote peer version is older than minRecommendedPeerVersion.
+// Peers with unknown version are not considered outdated.
+func (c *Compat) IsOutdated(peerID peer.ID) bool {
+	result, ok := config.CompareVersions(c.p2p.PeerVersion(peerID), minRecommendedPeerVersion)
+	return ok && result < 0
+}
+
+// WarnIfOutdated logs a deprecation warning once per peer version if the peer is outdated.
+func (c *Compat) WarnIfOutdated(peerID peer.ID, displayName string) {
+	if !c.IsOutdated(peerID) {
+		return
+	}
+	version := c.p2p.PeerVersion(peerID)
+
+	c.warnedLock.Lock()
+	defer c.warnedLock.Unlock()
+	if c.warned[peerID] == version {
+		return
+	}
+	c.warned[peerID] = version
+	c.logger.Warnf("peer %s (%s) uses outdated awl version %s. Please update it to %s or newer",
+		displayName, peerID, version, minRecommendedPeerVersion)
+}
@@ -0,0 +1,145 @@
+//go:build !awl_nodns
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/awldns"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/miekg/dns"
+)
+
+const DNSStreamTimeout = 10 * time.Second
+
+// DNSForwarder resolves dns queries of peers which use us as upstream peer, see config.DNSConfig.UpstreamPeerID,
+// and sends our queries to our upstream peer.
+type DNSForwarder struct {
+	p2p       P2p
+	conf      *config.Config
+	dnsClient *dns.Client
+	logger    *log.ZapEventLogger
+
+	upstreamLock sync.RWMutex
+	upstreamDNS  string
+}
+
+func NewDNSForwarder(p2pService P2p, conf *config.Config) *DNSForwarder {
+	return &DNSForwarder{
+		p2p:  p2pService,
+		conf: conf,
+		dnsClient: &dns.Client{
+			Net: "tcp",
+		},
+		logger:      log.Logger("awl/service/dns"),
+		upstreamDNS: awldns.DefaultUpstreamDNSAddress,
+	}
+}
+
+// AllowPeer reports whether the peer could resolve queries with us, it's allowed by exit node grant.
+func (f *DNSForwarder) AllowPeer(peerID peer.ID) bool {
+	return f.conf.PeerExitNodeAllowed(peerID.String())
+}
+
+// SetUpstreamDNS sets dns server which resolves queries of peers, config.DNSConfig.UpstreamAddress is used instead if set.
+func (f *DNSForwarder) SetUpstreamDNS(address string) {
+	f.upstreamLock.Lock()
+	f.upstreamDNS = address
+	f.upstreamLock.Unlock()
+}
+
+func (f *DNSForwarder) upstreamAddress() string {
+	if address := f.conf.DNSUpstreamAddress(); address != "" {
+		return address
+	}
+	f.upstreamLock.RLock()
+	defer f.upstreamLock.RUnlock()
+	return f.upstreamDNS
+}
+
+// StreamHandler resolves the query of the peer with upstream dns, failures are answered with SERVFAIL.
+func (f *DNSForwarder) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	peerID := stream.Conn().RemotePeer().String()
+	packed, err := protocol.ReadDNSMessage(stream)
+	if err != nil {
+		f.logger.Warnf("receive dns query from %s: %v", peerID, err)
+		return
+	}
+	req := new(dns.Msg)
+	err = req.Unpack(packed)
+	if err != nil || len(req.Question) == 0 {
+		f.logger.Warnf("invalid dns query from %s: %v", peerID, err)
+		return
+	}
+
+	resp, _, err := f.dnsClient.Exchange(req, f.upstreamAddress())
+	if err != nil {
+		f.logger.Debugf("resolve %s for %s: %v", req.Question[0].Name, peerID, err)
+		resp = new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeServerFailure)
+	}
+	packed, err = resp.Pack()
+	if err == nil {
+		err = protocol.WriteDNSMessage(stream, packed)
+	}
+	if err != nil {
+		f.logger.Warnf("send dns response to %s: %v", peerID, err)
+	}
+}
+
+// Exchange resolves the query with the peer's upstream dns.
+func (f *DNSForwarder) Exchange(ctx context.Context, peerID peer.ID, req *dns.Msg) (*dns.Msg, error) {
+	if !f.p2p.IsConnected(peerID) {
+		return nil, fmt.Errorf("peer %s is not connected", peerID)
+	}
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := f.p2p.NewStream(ctx, peerID, protocol.DNSMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	err = protocol.WriteDNSMessage(stream, packed)
+	if err != nil {
+		return nil, fmt.Errorf("send query: %v", err)
+	}
+	packed, err = protocol.ReadDNSMessage(stream)
+	if err != nil {
+		return nil, fmt.Errorf("receive response: %v", err)
+	}
+	resp := new(dns.Msg)
+	err = resp.Unpack(packed)
+	if err != nil {
+		return nil, fmt.Errorf("unpack response: %v", err)
+	}
+	if resp.Id != req.Id {
+		return nil, fmt.Errorf("response id %d doesn't match query id %d", resp.Id, req.Id)
+	}
+	return resp, nil
+}
+
+// UpstreamExchange returns exchange which resolves queries with the peer, see awldns.Resolver.SetUpstreamExchange.
+func (f *DNSForwarder) UpstreamExchange(peerID peer.ID) awldns.ExchangeFunc {
+	return func(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+		return f.Exchange(ctx, peerID, req)
+	}
+}
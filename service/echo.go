@@ -0,0 +1,50 @@
+package service
+
+import (
+	"io"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const EchoStreamTimeout = 30 * time.Second
+
+// Echo answers echo requests, the checks are sent with Probe.
+type Echo struct {
+	p2p    P2p
+	conf   *config.Config
+	logger *log.ZapEventLogger
+}
+
+func NewEcho(p2pService P2p, conf *config.Config) *Echo {
+	return &Echo{
+		p2p:    p2pService,
+		conf:   conf,
+		logger: log.Logger("awl/service/echo"),
+	}
+}
+
+func (e *Echo) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	peerID := stream.Conn().RemotePeer().String()
+	_, err := io.Copy(stream, io.LimitReader(stream, protocol.MaxEchoPayloadSize))
+	if err != nil {
+		e.logger.Warnf("echo to %s: %v", peerID, err)
+	}
+}
+
+// AllowPeer reports whether the peer could send echo requests: known peers or any peer in echo peer mode.
+func (e *Echo) AllowPeer(peerID peer.ID) bool {
+	e.conf.RLock()
+	echoPeerMode := e.conf.P2pNode.EchoPeerMode
+	e.conf.RUnlock()
+	_, known := e.conf.GetPeer(peerID.String())
+	return known || echoPeerMode
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/anywherelan/awl/vpn"
+)
+
+// fairQueue is a queue of packets from peers with deficit round robin between peers by bytes, so bulk transfer
+// of one peer can't starve others. When the queue is full packets of the peer with the longest queue are dropped.
+// It's safe for concurrent use.
+type fairQueue struct {
+	lock     sync.Mutex
+	queues   map[*VpnPeer]*peerQueue
+	active   []*peerQueue // round robin order, the first one is served
+	len      int
+	capacity int
+	quantum  int
+	notify   chan struct{}
+	closed   bool
+}
+
+type peerQueue struct {
+	peer    *VpnPeer
+	packets []inboundPacket
+	// deficit is bytes which the peer could send in the current round
+	deficit int
+}
+
+func newFairQueue(capacity, quantum int) *fairQueue {
+	return &fairQueue{
+		queues:   make(map[*VpnPeer]*peerQueue),
+		capacity: capacity,
+		quantum:  quantum,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Push returns dropped packet which should be put back by the caller, it could be the pushed one.
+func (q *fairQueue) Push(inbound inboundPacket) (dropped *vpn.Packet) {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return inbound.packet
+	}
+	queue := q.queues[inbound.peer]
+	if q.len >= q.capacity {
+		longest := q.longestQueue()
+		if queue == longest || (queue != nil && len(queue.packets) >= len(longest.packets)) {
+			q.lock.Unlock()
+			return inbound.packet
+		}
+		dropped = longest.packets[0].packet
+		longest.packets[0] = inboundPacket{}
+		longest.packets = longest.packets[1:]
+		q.len--
+	}
+	if queue == nil {
+		queue = &peerQueue{peer: inbound.peer, deficit: q.quantum}
+		q.queues[inbound.peer] = queue
+		q.active = append(q.active, queue)
+	}
+	queue.packets = append(queue.packets, inbound)
+	q.len++
+	// notify is closed by Close under the lock, so it's signaled under the lock too
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	q.lock.Unlock()
+	return dropped
+}
+
+// longestQueue should be called with lock when the queue isn't empty.
+func (q *fairQueue) longestQueue() *peerQueue {
+	var longest *peerQueue
+	for _, queue := range q.active {
+		if longest == nil || len(queue.packets) > len(longest.packets) {
+			longest = queue
+		}
+	}
+	return longest
+}
+
+// Len returns the number of queued packets.
+func (q *fairQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.len
+}
+
+// Pop appends up to limit packets to batch, it blocks while the queue is empty.
+// It returns false if the queue is closed.
+func (q *fairQueue) Pop(batch []inboundPacket, limit int) ([]inboundPacket, bool) {
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return batch, false
+		}
+		if q.len > 0 {
+			break
+		}
+		q.lock.Unlock()
+		<-q.notify
+	}
+	defer q.lock.Unlock()
+
+	for len(batch) < limit && len(q.active) > 0 {
+		queue := q.active[0]
+		for len(queue.packets) > 0 && len(batch) < limit {
+			size := len(queue.packets[0].packet.Packet)
+			if size > queue.deficit {
+				break
+			}
+			queue.deficit -= size
+			batch = append(batch, queue.packets[0])
+			queue.packets[0] = inboundPacket{}
+			queue.packets = queue.packets[1:]
+			q.len--
+		}
+		switch {
+		case len(queue.packets) == 0:
+			delete(q.queues, queue.peer)
+			q.active[0] = nil
+			q.active = q.active[1:]
+		case len(batch) < limit:
+			// the peer has used its share, it's moved to the end of the round
+			queue.deficit += q.quantum
+			q.active = append(q.active[1:], queue)
+		}
+	}
+
+	return batch, true
+}
+
+// Close drops queued packets, Pop returns false after it.
+func (q *fairQueue) Close(drop func(packet *vpn.Packet)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for _, queue := range q.active {
+		for _, inbound := range queue.packets {
+			drop(inbound.packet)
+		}
+	}
+	q.queues = nil
+	q.active = nil
+	q.len = 0
+	close(q.notify)
+}
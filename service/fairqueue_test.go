@@ -0,0 +1,77 @@
+package service
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/anywherelan/awl/vpn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFairQueue(t *testing.T) {
+	a := require.New(t)
+	queue := newFairQueue(10, 1000)
+	bulk, other := &VpnPeer{}, &VpnPeer{}
+	newPacket := func(peer *VpnPeer, size int) inboundPacket {
+		return inboundPacket{peer: peer, packet: &vpn.Packet{Packet: make([]byte, size)}}
+	}
+
+	var bulkPackets []*vpn.Packet
+	for i := 0; i < 10; i++ {
+		inbound := newPacket(bulk, 1000)
+		bulkPackets = append(bulkPackets, inbound.packet)
+		a.Nil(queue.Push(inbound))
+	}
+	// the queue is full, the bulk peer loses its own packets
+	inbound := newPacket(bulk, 1000)
+	a.Equal(inbound.packet, queue.Push(inbound))
+	// and the oldest ones to let other peers in
+	for i := 0; i < 5; i++ {
+		a.Equal(bulkPackets[i], queue.Push(newPacket(other, 100)))
+	}
+	inbound = newPacket(other, 100)
+	a.Equal(inbound.packet, queue.Push(inbound))
+
+	batch, open := queue.Pop(nil, vpn.MaxWriteBatch)
+	a.True(open)
+	var order []*VpnPeer
+	for _, inbound := range batch {
+		order = append(order, inbound.peer)
+	}
+	// small packets of the other peer aren't queued behind the bulk transfer
+	a.Equal([]*VpnPeer{bulk, other, other, other, other, other, bulk, bulk, bulk, bulk}, order)
+	a.Equal(bulkPackets[5:], []*vpn.Packet{batch[0].packet, batch[6].packet, batch[7].packet, batch[8].packet, batch[9].packet})
+
+	var dropped int
+	a.Nil(queue.Push(newPacket(other, 100)))
+	queue.Close(func(*vpn.Packet) { dropped++ })
+	a.Equal(1, dropped)
+	_, open = queue.Pop(nil, vpn.MaxWriteBatch)
+	a.False(open)
+}
+
+func TestFairQueueConcurrentClose(t *testing.T) {
+	queue := newFairQueue(10, 1000)
+	peer := &VpnPeer{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				queue.Push(inboundPacket{peer: peer, packet: &vpn.Packet{Packet: make([]byte, 100)}})
+			}
+		}()
+	}
+	go func() {
+		for {
+			if _, open := queue.Pop(nil, vpn.MaxWriteBatch); !open {
+				return
+			}
+		}
+	}()
+	queue.Close(func(*vpn.Packet) {})
+	// pushes after close and the second close don't panic
+	wg.Wait()
+	queue.Close(func(*vpn.Packet) {})
+}
@@ -0,0 +1,621 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	FileTransferDirectionSend    = "send"
+	FileTransferDirectionReceive = "receive"
+
+	FileTransferStateActive    = "active"
+	FileTransferStateCompleted = "completed"
+	FileTransferStateFailed    = "failed"
+
+	// partial files are kept in the inbox, so they are on the same file system and are moved without copying
+	fileTransferPartialDirectory = ".awl-partial"
+	fileTransferChunkSize        = 64 << 10
+	// fileTransferIdleTimeout is the max time without progress, stalled transfers are retried
+	fileTransferIdleTimeout = time.Minute
+	// fileTransferVerifyTimeout is the max time of hashing the received part of the file by the peer
+	fileTransferVerifyTimeout    = 10 * time.Minute
+	fileTransferMaxAttempts      = 5
+	fileTransferRetryDelay       = 10 * time.Second
+	fileTransferProgressInterval = 500 * time.Millisecond
+	// fileTransferHistorySize is the number of kept finished transfers
+	fileTransferHistorySize = 100
+	// fileTransferResumeTimeout is the time to resume the interrupted transfer before its partial file is removed
+	fileTransferResumeTimeout = fileTransferMaxAttempts * (fileTransferRetryDelay + fileTransferIdleTimeout)
+)
+
+// errFileRejected is returned if the peer refused the file, such transfers aren't retried.
+var errFileRejected = errors.New("peer rejected the file")
+
+// FileTransferStatus is the progress of sending or receiving a file.
+type FileTransferStatus struct {
+	ID        string
+	PeerID    string
+	Direction string `enums:"send,receive"`
+	Name      string
+	// Path is the sent file or the received one in the inbox, it's empty until the file is received
+	Path        string
+	Size        int64
+	Transferred int64
+	State       string `enums:"active,completed,failed"`
+	Error       string `json:",omitempty"`
+	// Attempts is the number of connections to the peer, interrupted transfers are resumed
+	Attempts  int
+	StartedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FileTransfer sends files directly to known peers and receives their files to the inbox, see config.FileTransferConfig.
+// Transfers are identified by hash of the file, so interrupted ones are resumed from the received part.
+// Progress is emitted as awlevent.FileTransferProgress.
+type FileTransfer struct {
+	p2p     P2p
+	conf    *config.Config
+	logger  *log.ZapEventLogger
+	emitter awlevent.Emitter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock      sync.Mutex
+	transfers map[string]*fileTransfer
+	// receiving are partial files which are written now
+	receiving map[string]struct{}
+	// interrupted are partial files which are removed by timer unless the transfer is resumed
+	interrupted map[string]*time.Timer
+}
+
+type fileTransfer struct {
+	status   FileTransferStatus
+	emitted  time.Time
+	finished bool
+}
+
+func NewFileTransfer(p2pService P2p, conf *config.Config, eventbus awlevent.Bus) *FileTransfer {
+	emitter, err := eventbus.Emitter(new(awlevent.FileTransferProgress))
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FileTransfer{
+		p2p:         p2pService,
+		conf:        conf,
+		logger:      log.Logger("awl/service/file_transfer"),
+		emitter:     emitter,
+		ctx:         ctx,
+		cancel:      cancel,
+		transfers:   make(map[string]*fileTransfer),
+		receiving:   make(map[string]struct{}),
+		interrupted: make(map[string]*time.Timer),
+	}
+}
+
+// Send starts sending the file to the peer in background, the transfer is retried if the connection is lost.
+func (f *FileTransfer) Send(peerID peer.ID, path string) (FileTransferStatus, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileTransferStatus{}, err
+	} else if !info.Mode().IsRegular() {
+		return FileTransferStatus{}, fmt.Errorf("%s is not a regular file", path)
+	}
+	name := filepath.Base(path)
+	if err = validateFileName(name); err != nil {
+		return FileTransferStatus{}, err
+	}
+
+	status := f.start(FileTransferStatus{
+		PeerID:    peerID.String(),
+		Direction: FileTransferDirectionSend,
+		Name:      name,
+		Path:      path,
+		Size:      info.Size(),
+	})
+	go f.send(status.ID, peerID, path)
+
+	return status, nil
+}
+
+// Transfers returns active and recently finished transfers, the oldest first.
+func (f *FileTransfer) Transfers() []FileTransferStatus {
+	f.lock.Lock()
+	result := make([]FileTransferStatus, 0, len(f.transfers))
+	for _, transfer := range f.transfers {
+		result = append(result, transfer.status)
+	}
+	f.lock.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// Close interrupts active transfers and removes partial files of interrupted ones.
+func (f *FileTransfer) Close() {
+	f.cancel()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for path, timer := range f.interrupted {
+		timer.Stop()
+		_ = os.Remove(path)
+		delete(f.interrupted, path)
+	}
+}
+
+func (f *FileTransfer) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+	peerID := stream.Conn().RemotePeer()
+
+	_ = stream.SetDeadline(time.Now().Add(fileTransferIdleTimeout))
+	request, err := protocol.ReceiveFileTransferRequest(stream)
+	if err != nil {
+		f.logger.Errorf("receiving file transfer request from %s: %v", peerID, err)
+		_ = stream.Reset()
+		return
+	}
+
+	partial, err := f.openPartial(peerID, request)
+	if err != nil {
+		f.logger.Warnf("file %s from %s is rejected: %v", request.Name, peerID, err)
+		err = protocol.SendFileTransferResponse(stream, protocol.FileTransferResponse{Error: err.Error()})
+		if err != nil {
+			f.logger.Errorf("sending file transfer response to %s: %v", peerID, err)
+		}
+		return
+	}
+	defer f.closePartial(partial)
+	stop := context.AfterFunc(f.ctx, func() {
+		_ = stream.Reset()
+	})
+	defer stop()
+
+	status := f.start(FileTransferStatus{
+		PeerID:      peerID.String(),
+		Direction:   FileTransferDirectionReceive,
+		Name:        request.Name,
+		Size:        request.Size,
+		Transferred: partial.offset,
+		Attempts:    1,
+	})
+	_ = stream.SetDeadline(time.Now().Add(fileTransferIdleTimeout))
+	err = protocol.SendFileTransferResponse(stream, protocol.FileTransferResponse{Offset: partial.offset})
+	if err == nil {
+		err = copyFileChunks(io.MultiWriter(partial.file, partial.hash), stream, request.Size-partial.offset, stream, func(n int64) {
+			f.addProgress(status.ID, n)
+		})
+	}
+	if err != nil {
+		partial.interrupted = true
+		f.finish(status.ID, err)
+		_ = stream.Reset()
+		return
+	}
+
+	path, err := f.completePartial(partial, request)
+	result := protocol.FileTransferResult{}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		f.logger.Infof("received file %s from %s", path, peerID)
+		f.update(status.ID, func(status *FileTransferStatus) {
+			status.Path = path
+		})
+	}
+	f.finish(status.ID, err)
+
+	_ = stream.SetDeadline(time.Now().Add(fileTransferIdleTimeout))
+	err = protocol.SendFileTransferResult(stream, result)
+	if err != nil {
+		f.logger.Errorf("sending file transfer result to %s: %v", peerID, err)
+	}
+}
+
+func (f *FileTransfer) send(id string, peerID peer.ID, path string) {
+	request, err := newFileTransferRequest(path)
+	for attempt := 1; err == nil; attempt++ {
+		f.update(id, func(status *FileTransferStatus) {
+			status.Size = request.Size
+			status.Attempts = attempt
+		})
+		err = f.sendAttempt(id, peerID, path, request)
+		if err == nil || errors.Is(err, errFileRejected) || attempt == fileTransferMaxAttempts || f.ctx.Err() != nil {
+			break
+		}
+		f.logger.Debugf("sending file %s to %s, attempt %d: %v", path, peerID, attempt, err)
+
+		select {
+		case <-f.ctx.Done():
+			err = f.ctx.Err()
+		case <-time.After(fileTransferRetryDelay):
+		}
+	}
+	if err != nil {
+		f.logger.Warnf("sending file %s to %s: %v", path, peerID, err)
+	} else {
+		f.logger.Infof("sent file %s to %s", path, peerID)
+	}
+	f.finish(id, err)
+}
+
+func (f *FileTransfer) sendAttempt(id string, peerID peer.ID, path string, request protocol.FileTransferRequest) error {
+	ctx, cancel := context.WithTimeout(f.ctx, fileTransferIdleTimeout)
+	defer cancel()
+	err := f.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return err
+	}
+	stream, err := f.p2p.NewStream(ctx, peerID, protocol.FileTransferMethod)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	// transfer isn't limited by ctx timeout, it's interrupted by idle timeout or Close
+	stop := context.AfterFunc(f.ctx, func() {
+		_ = stream.Reset()
+	})
+	defer stop()
+
+	_ = stream.SetDeadline(time.Now().Add(fileTransferVerifyTimeout))
+	err = protocol.SendFileTransferRequest(stream, request)
+	if err != nil {
+		return fmt.Errorf("send request: %v", err)
+	}
+	response, err := protocol.ReceiveFileTransferResponse(stream)
+	if err != nil {
+		return fmt.Errorf("receive response: %v", err)
+	} else if response.Error != "" {
+		return fmt.Errorf("%w: %s", errFileRejected, response.Error)
+	} else if response.Offset < 0 || response.Offset > request.Size {
+		return fmt.Errorf("%w: invalid offset %d", errFileRejected, response.Offset)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Seek(response.Offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	f.update(id, func(status *FileTransferStatus) {
+		status.Transferred = response.Offset
+	})
+	err = copyFileChunks(stream, file, request.Size-response.Offset, stream, func(n int64) {
+		f.addProgress(id, n)
+	})
+	if err != nil {
+		return err
+	}
+	err = stream.CloseWrite()
+	if err != nil {
+		return err
+	}
+
+	_ = stream.SetDeadline(time.Now().Add(fileTransferIdleTimeout))
+	result, err := protocol.ReceiveFileTransferResult(stream)
+	if err != nil {
+		return fmt.Errorf("receive result: %v", err)
+	} else if result.Error != "" {
+		return fmt.Errorf("%w: %s", errFileRejected, result.Error)
+	}
+	return nil
+}
+
+func newFileTransferRequest(path string) (protocol.FileTransferRequest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return protocol.FileTransferRequest{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return protocol.FileTransferRequest{}, fmt.Errorf("hash file: %v", err)
+	}
+
+	return protocol.FileTransferRequest{
+		Name:   filepath.Base(path),
+		Size:   size,
+		SHA256: hash.Sum(nil),
+	}, nil
+}
+
+// copyFileChunks copies n bytes by chunks, deadline of the stream is extended for each chunk,
+// so only stalled transfers time out.
+func copyFileChunks(dst io.Writer, src io.Reader, n int64, stream network.Stream, progress func(n int64)) error {
+	buf := make([]byte, fileTransferChunkSize)
+	for n > 0 {
+		_ = stream.SetDeadline(time.Now().Add(fileTransferIdleTimeout))
+		chunk := buf[:min(int64(len(buf)), n)]
+		_, err := io.ReadFull(src, chunk)
+		if err != nil {
+			return err
+		}
+		_, err = dst.Write(chunk)
+		if err != nil {
+			return err
+		}
+		n -= int64(len(chunk))
+		progress(int64(len(chunk)))
+	}
+	return nil
+}
+
+type partialFile struct {
+	path   string
+	file   *os.File
+	offset int64
+	hash   hash.Hash
+	// interrupted is set if the file isn't received completely
+	interrupted bool
+}
+
+// openPartial opens the part of the file received before, its hash is calculated to continue with the rest of the file.
+func (f *FileTransfer) openPartial(peerID peer.ID, request protocol.FileTransferRequest) (*partialFile, error) {
+	f.conf.RLock()
+	inbox := f.conf.FileTransfer.InboxPath
+	maxSizeMB := f.conf.FileTransfer.MaxSizeMB
+	f.conf.RUnlock()
+	if inbox == "" {
+		return nil, errors.New("inbox is not configured")
+	}
+	if err := validateFileName(request.Name); err != nil {
+		return nil, err
+	}
+	if request.Size < 0 || len(request.SHA256) != sha256.Size {
+		return nil, errors.New("invalid request")
+	}
+	if request.Size > int64(maxSizeMB)<<20 {
+		return nil, fmt.Errorf("file is too large, max size is %d MB", maxSizeMB)
+	}
+
+	dir := filepath.Join(inbox, fileTransferPartialDirectory)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.New("inbox is unavailable")
+	}
+	path := filepath.Join(dir, peerID.String()+"-"+hex.EncodeToString(request.SHA256))
+	f.lock.Lock()
+	_, inProgress := f.receiving[path]
+	f.receiving[path] = struct{}{}
+	if timer, interrupted := f.interrupted[path]; interrupted && !inProgress {
+		timer.Stop()
+		delete(f.interrupted, path)
+	}
+	f.lock.Unlock()
+	if inProgress {
+		return nil, errors.New("the file is being received already")
+	}
+
+	partial, err := openPartialFile(path, request.Size)
+	if err != nil {
+		f.logger.Errorf("open partial file %s: %v", path, err)
+		f.lock.Lock()
+		delete(f.receiving, path)
+		f.lock.Unlock()
+		return nil, errors.New("inbox is unavailable")
+	}
+	return partial, nil
+}
+
+func openPartialFile(path string, size int64) (*partialFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() > size {
+		err = file.Truncate(0)
+	}
+	partial := &partialFile{path: path, file: file, hash: sha256.New()}
+	if err == nil {
+		partial.offset, err = io.Copy(partial.hash, file)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return partial, nil
+}
+
+// closePartial closes the partial file. The file of interrupted transfer is removed after fileTransferResumeTimeout
+// unless the transfer is resumed, it's removed at once if the transfer is interrupted by Close.
+func (f *FileTransfer) closePartial(partial *partialFile) {
+	_ = partial.file.Close()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.receiving, partial.path)
+	if !partial.interrupted {
+		return
+	}
+	if f.ctx.Err() != nil {
+		_ = os.Remove(partial.path)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(fileTransferResumeTimeout, func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.interrupted[partial.path] != timer {
+			return
+		}
+		delete(f.interrupted, partial.path)
+		err := os.Remove(partial.path)
+		if err != nil {
+			f.logger.Errorf("remove partial file %s: %v", partial.path, err)
+		}
+	})
+	f.interrupted[partial.path] = timer
+}
+
+// completePartial checks hash of the received file and moves it to the inbox, names of existing files are kept.
+func (f *FileTransfer) completePartial(partial *partialFile, request protocol.FileTransferRequest) (string, error) {
+	if !bytes.Equal(partial.hash.Sum(nil), request.SHA256) {
+		_ = partial.file.Close()
+		_ = os.Remove(partial.path)
+		return "", errors.New("hash of the received file doesn't match")
+	}
+	err := partial.file.Close()
+	if err != nil {
+		return "", errors.New("inbox is unavailable")
+	}
+
+	// lock prevents choosing the same name for files received at once
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path, err := freeFilePath(filepath.Dir(filepath.Dir(partial.path)), request.Name)
+	if err == nil {
+		err = os.Rename(partial.path, path)
+	}
+	if err != nil {
+		f.logger.Errorf("move received file %s: %v", partial.path, err)
+		return "", errors.New("inbox is unavailable")
+	}
+	config.ChownFileIfNeeded(path)
+
+	return path, nil
+}
+
+// freeFilePath returns path of the file in dir, number is added to the name if the file exists, e.g. "photo (1).jpg".
+func freeFilePath(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		path := filepath.Join(dir, name)
+		if i > 0 {
+			path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		}
+		_, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("too many files named %s", name)
+}
+
+func validateFileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || name == fileTransferPartialDirectory {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	if len(name) > protocol.MaxFileNameLength {
+		return fmt.Errorf("file name is longer than %d bytes", protocol.MaxFileNameLength)
+	}
+	return nil
+}
+
+// start adds new transfer, the oldest finished transfers are dropped if there are too many of them.
+func (f *FileTransfer) start(status FileTransferStatus) FileTransferStatus {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	now := time.Now()
+	status.ID = hex.EncodeToString(id)
+	status.State = FileTransferStateActive
+	status.StartedAt = now
+	status.UpdatedAt = now
+
+	f.lock.Lock()
+	f.transfers[status.ID] = &fileTransfer{status: status, emitted: now}
+	var finished []*fileTransfer
+	for _, transfer := range f.transfers {
+		if transfer.finished {
+			finished = append(finished, transfer)
+		}
+	}
+	if len(finished) > fileTransferHistorySize {
+		sort.Slice(finished, func(i, j int) bool {
+			return finished[i].status.StartedAt.Before(finished[j].status.StartedAt)
+		})
+		for _, transfer := range finished[:len(finished)-fileTransferHistorySize] {
+			delete(f.transfers, transfer.status.ID)
+		}
+	}
+	f.lock.Unlock()
+
+	f.emit(status)
+	return status
+}
+
+func (f *FileTransfer) addProgress(id string, n int64) {
+	f.update(id, func(status *FileTransferStatus) {
+		status.Transferred += n
+	})
+}
+
+func (f *FileTransfer) finish(id string, err error) {
+	f.update(id, func(status *FileTransferStatus) {
+		status.State = FileTransferStateCompleted
+		if err != nil {
+			status.State = FileTransferStateFailed
+			status.Error = err.Error()
+		}
+	})
+}
+
+// update emits progress of active transfers once per fileTransferProgressInterval, changes of state are emitted immediately.
+func (f *FileTransfer) update(id string, fn func(status *FileTransferStatus)) {
+	f.lock.Lock()
+	transfer, exists := f.transfers[id]
+	if !exists {
+		f.lock.Unlock()
+		return
+	}
+	fn(&transfer.status)
+	now := time.Now()
+	transfer.status.UpdatedAt = now
+	transfer.finished = transfer.status.State != FileTransferStateActive
+	emit := transfer.finished || now.Sub(transfer.emitted) >= fileTransferProgressInterval
+	if emit {
+		transfer.emitted = now
+	}
+	status := transfer.status
+	f.lock.Unlock()
+
+	if emit {
+		f.emit(status)
+	}
+}
+
+func (f *FileTransfer) emit(status FileTransferStatus) {
+	_ = f.emitter.Emit(awlevent.FileTransferProgress{
+		ID:          status.ID,
+		PeerID:      status.PeerID,
+		Direction:   status.Direction,
+		Name:        status.Name,
+		Size:        status.Size,
+		Transferred: status.Transferred,
+		State:       status.State,
+		Error:       status.Error,
+	})
+}
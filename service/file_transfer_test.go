@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTransfer_closePartial(t *testing.T) {
+	a := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &FileTransfer{
+		logger:      log.Logger("awl/service/file_transfer"),
+		ctx:         ctx,
+		cancel:      cancel,
+		receiving:   make(map[string]struct{}),
+		interrupted: make(map[string]*time.Timer),
+	}
+	dir := t.TempDir()
+
+	completed, err := openPartialFile(filepath.Join(dir, "completed"), 10)
+	a.NoError(err)
+	f.closePartial(completed)
+	a.FileExists(completed.path)
+	a.Empty(f.interrupted)
+
+	// the partial file is kept to resume the transfer for a while
+	interrupted, err := openPartialFile(filepath.Join(dir, "interrupted"), 10)
+	a.NoError(err)
+	interrupted.interrupted = true
+	f.closePartial(interrupted)
+	a.FileExists(interrupted.path)
+	a.Contains(f.interrupted, interrupted.path)
+
+	f.Close()
+	a.NoFileExists(interrupted.path)
+	a.Empty(f.interrupted)
+
+	// transfers interrupted by Close are removed at once
+	interrupted, err = openPartialFile(filepath.Join(dir, "interrupted"), 10)
+	a.NoError(err)
+	interrupted.interrupted = true
+	f.closePartial(interrupted)
+	a.NoFileExists(interrupted.path)
+	a.Empty(f.interrupted)
+}
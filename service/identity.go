@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// RotateIdentity generates a new identity key, which is applied on the next start. After restart the migration statement
+// signed by the old key is sent to trusted peers, so they move us to the new peer id instead of adding us again.
+// Peers which aren't reachable then receive the statement later, it's retried like friend requests.
+func (s *AuthStatus) RotateIdentity() (config.IdentityMigrationConfig, error) {
+	if s.IdentityRotationPending() {
+		return config.IdentityMigrationConfig{}, errors.New("previous identity rotation is not applied, restart is required")
+	}
+	oldKey, err := crypto.UnmarshalEd25519PrivateKey(s.conf.PrivKey())
+	if err != nil {
+		return config.IdentityMigrationConfig{}, fmt.Errorf("load identity: %v", err)
+	}
+	newKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return config.IdentityMigrationConfig{}, fmt.Errorf("generate identity: %v", err)
+	}
+	newPeerID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return config.IdentityMigrationConfig{}, err
+	}
+	now := time.Now()
+	migration, err := protocol.NewIdentityMigration(oldKey, newPeerID, now)
+	if err != nil {
+		return config.IdentityMigrationConfig{}, err
+	}
+
+	s.conf.Lock()
+	pendingPeers := make([]string, 0, len(s.conf.KnownPeers))
+	for peerID, knownPeer := range s.conf.KnownPeers {
+		if knownPeer.Confirmed && !knownPeer.Declined {
+			pendingPeers = append(pendingPeers, peerID)
+		}
+	}
+	slices.Sort(pendingPeers)
+	previous := s.conf.P2pNode.IdentityMigration.PendingPeers
+	s.conf.P2pNode.IdentityMigration = config.IdentityMigrationConfig{
+		OldPeerID:    migration.OldPeerID,
+		NewPeerID:    migration.NewPeerID,
+		CreatedAt:    now,
+		Signature:    migration.Signature,
+		PendingPeers: pendingPeers,
+	}
+	s.conf.Unlock()
+	if len(previous) > 0 {
+		s.logger.Warnf("previous identity migration isn't accepted by %d peers, they should add us again", len(previous))
+	}
+	// config is saved with the new identity
+	s.conf.SetIdentity(newKey, newPeerID)
+	s.logger.Infof("rotated identity from %s to %s, it's applied after restart", migration.OldPeerID, migration.NewPeerID)
+
+	return s.IdentityMigration(), nil
+}
+
+// IdentityMigration returns the statement of the last identity rotation with peers which haven't accepted it yet.
+func (s *AuthStatus) IdentityMigration() config.IdentityMigrationConfig {
+	s.conf.RLock()
+	defer s.conf.RUnlock()
+	migration := s.conf.P2pNode.IdentityMigration
+	migration.PendingPeers = slices.Clone(migration.PendingPeers)
+	return migration
+}
+
+// IdentityRotationPending is true if the host still uses the old identity, until restart.
+func (s *AuthStatus) IdentityRotationPending() bool {
+	s.conf.RLock()
+	peerID := s.conf.P2pNode.PeerID
+	s.conf.RUnlock()
+	return peerID != s.p2p.PeerID().String()
+}
+
+// SendIdentityMigration sends the statement of the last identity rotation to peers which haven't accepted it yet.
+// It's sent only after restart, since peers would move to the new peer id while we still use the old one.
+func (s *AuthStatus) SendIdentityMigration(ctx context.Context) {
+	if s.IdentityRotationPending() {
+		return
+	}
+	migration := s.IdentityMigration()
+	var wg sync.WaitGroup
+	for _, peerID := range migration.PendingPeers {
+		wg.Add(1)
+		go func(peerID string) {
+			defer wg.Done()
+			s.sendIdentityMigrationTo(ctx, peerID)
+		}(peerID)
+	}
+	wg.Wait()
+}
+
+func (s *AuthStatus) sendIdentityMigrationTo(ctx context.Context, peerID string) {
+	migration := s.IdentityMigration()
+	if s.IdentityRotationPending() || !slices.Contains(migration.PendingPeers, peerID) {
+		return
+	}
+	knownPeer, _ := s.conf.GetPeer(peerID)
+	err := s.sendIdentityMigration(ctx, knownPeer.PeerId(), protocol.IdentityMigration{
+		OldPeerID: migration.OldPeerID,
+		NewPeerID: migration.NewPeerID,
+		Time:      migration.CreatedAt.UnixMilli(),
+		Signature: migration.Signature,
+	})
+	if err != nil {
+		s.logger.Warnf("send identity migration to %s (%s): %v", knownPeer.DisplayName(), peerID, err)
+		return
+	}
+
+	s.conf.Lock()
+	pendingPeers := s.conf.P2pNode.IdentityMigration.PendingPeers
+	if idx := slices.Index(pendingPeers, peerID); idx != -1 {
+		s.conf.P2pNode.IdentityMigration.PendingPeers = slices.Delete(slices.Clone(pendingPeers), idx, idx+1)
+	}
+	s.conf.Unlock()
+	s.conf.Save()
+	s.logger.Infof("peer %s (%s) has accepted our new peer id", knownPeer.DisplayName(), peerID)
+}
+
+func (s *AuthStatus) sendIdentityMigration(ctx context.Context, peerID peer.ID, migration protocol.IdentityMigration) error {
+	ctx, cancel := context.WithTimeout(ctx, s.conf.PeerRequestTimeout())
+	defer cancel()
+	err := s.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return err
+	}
+	// legacy auth protocol doesn't support migrations
+	stream, err := s.p2p.NewStream(ctx, peerID, protocol.AuthMethod)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	format := protocol.StreamFormat(stream.Protocol())
+
+	s.conf.RLock()
+	authPeer := protocol.AuthPeer{Name: s.conf.P2pNode.Name, Migration: &migration}
+	s.conf.RUnlock()
+	err = protocol.SendAuth(stream, format, authPeer)
+	if err != nil {
+		return fmt.Errorf("sending auth: %v", err)
+	}
+	response, err := protocol.ReceiveAuthResponse(stream, format)
+	if err != nil {
+		return fmt.Errorf("receiving auth response: %v", err)
+	}
+	if !response.Migrated {
+		return errors.New("peer hasn't accepted the new peer id, it could be outdated")
+	}
+	return nil
+}
+
+// receiveIdentityMigration moves the trusted peer to its new peer id. The statement is accepted only from the new
+// peer id, so the peer isn't moved while it still uses the old one.
+func (s *AuthStatus) receiveIdentityMigration(remotePeer peer.ID, migration protocol.IdentityMigration) error {
+	if remotePeer.String() != migration.NewPeerID {
+		return errors.New("statement is sent by another peer")
+	}
+	err := migration.Verify()
+	if err != nil {
+		return err
+	}
+
+	knownPeer, known := s.conf.GetPeer(migration.OldPeerID)
+	if _, migrated := s.conf.GetPeer(migration.NewPeerID); migrated && !known {
+		// response to the previous delivery was lost
+		return nil
+	}
+	if !known || !knownPeer.Confirmed || knownPeer.Declined {
+		return errors.New("old peer id is not trusted")
+	}
+	if !s.conf.MigratePeer(migration.OldPeerID, migration.NewPeerID) {
+		return errors.New("new peer id is already known")
+	}
+
+	newPeerID, _ := peer.Decode(migration.NewPeerID)
+	s.logger.Infof("peer %s moved from %s to new peer id %s", knownPeer.DisplayName(), migration.OldPeerID, migration.NewPeerID)
+	_ = s.migratedEmitter.Emit(awlevent.PeerIdentityMigrated{OldPeerID: migration.OldPeerID, NewPeerID: migration.NewPeerID})
+	go func() {
+		ctx, cancel := s.requestContext()
+		defer cancel()
+		knownPeer, _ := s.conf.GetPeer(migration.NewPeerID)
+		_ = s.ExchangeNewStatusInfo(ctx, newPeerID, knownPeer)
+	}()
+	return nil
+}
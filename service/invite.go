@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/invite"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	DefaultInviteTTL = 24 * time.Hour
+	MaxInviteTTL     = 30 * 24 * time.Hour
+)
+
+// CreateInvite issues one-time invite token signed by our key. Friend request with the token is accepted
+// automatically and the peer gets the alias, empty alias is generated from the peer name.
+func (s *AuthStatus) CreateInvite(alias string, ttl time.Duration) (string, time.Time, error) {
+	key, err := crypto.UnmarshalEd25519PrivateKey(s.conf.PrivKey())
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("load identity: %v", err)
+	}
+	s.conf.RLock()
+	name := s.conf.P2pNode.Name
+	s.conf.RUnlock()
+
+	now := time.Now()
+	token, err := invite.New(key, name, now.Add(ttl))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	encoded, err := invite.Encode(key, token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	s.conf.AddInvite(config.Invite{
+		SecretHash: invite.SecretHash(token.Secret),
+		Alias:      alias,
+		CreatedAt:  now,
+		ExpiresAt:  token.ExpiresAt,
+	})
+	s.logger.Infof("created invite which expires at %s", token.ExpiresAt.Format(time.RFC3339))
+
+	return encoded, token.ExpiresAt, nil
+}
+
+// AcceptInvite adds the issuer of the token and sends friend request with the invite secret, token should be verified.
+func (s *AuthStatus) AcceptInvite(ctx context.Context, token invite.Token, uniqAlias string) error {
+	peerID, err := peer.Decode(token.PeerID)
+	if err != nil {
+		return err
+	}
+	return s.addPeer(ctx, peerID, token.Name, uniqAlias, "", false, protocol.AuthPeer{Invite: token.Secret})
+}
+
+// useInvite returns alias of our invite which secret is sent by the peer, invite can't be used again.
+func (s *AuthStatus) useInvite(peerID peer.ID, secret []byte) (string, bool) {
+	if len(secret) == 0 {
+		return "", false
+	}
+	usedInvite, valid := s.conf.UseInvite(invite.SecretHash(secret))
+	if !valid {
+		s.logger.Warnf("peer %s sent friend request with unknown or expired invite", peerID)
+		return "", false
+	}
+	s.logger.Infof("peer %s used invite created at %s", peerID, usedInvite.CreatedAt.Format(time.RFC3339))
+	return usedInvite.Alias, true
+}
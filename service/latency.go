@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	PingStreamTimeout = 10 * time.Second
+
+	latencyCheckInterval = 30 * time.Second
+	latencyPingTimeout   = 5 * time.Second
+	// latencyHistorySize is the number of kept pings of each peer, 30 minutes with latencyCheckInterval
+	latencyHistorySize = 60
+	pingPayloadSize    = 8
+	// pingTimestampsSize is receive and transmit time of the peer which follow echoed payload,
+	// peers of older versions don't send them
+	pingTimestampsSize = 16
+)
+
+// LatencySample is the result of a single ping.
+type LatencySample struct {
+	At time.Time
+	// RTT is zero if the ping is lost, e.g. the peer is disconnected or doesn't answer
+	RTT  time.Duration `swaggertype:"primitive,integer"`
+	Lost bool
+	// Relayed is true if the ping went through relay
+	Relayed bool
+	// Timed is true if the peer sent its receive and transmit time
+	Timed bool
+	// ClockOffset is the peer clock minus ours estimated like NTP, assuming that delays both ways are equal
+	ClockOffset time.Duration `swaggertype:"primitive,integer"`
+}
+
+// PeerLatency summarizes recent pings of the peer.
+type PeerLatency struct {
+	// Reachable is true if the last ping is answered
+	Reachable bool
+	// Relayed is true if the last answered ping went through relay
+	Relayed bool
+	LastRTT time.Duration `swaggertype:"primitive,integer"`
+	AvgRTT  time.Duration `swaggertype:"primitive,integer"`
+	MinRTT  time.Duration `swaggertype:"primitive,integer"`
+	MaxRTT  time.Duration `swaggertype:"primitive,integer"`
+	// PacketLoss is the percent of lost pings
+	PacketLoss float64
+	Pings      int
+	CheckedAt  time.Time
+
+	// ClockOffsetKnown is false if the peer didn't send its time, e.g. it's of older version
+	ClockOffsetKnown bool
+	// ClockOffset is the peer clock minus ours, positive if our clock is behind.
+	// It's estimated by the ping with the lowest round trip time, as it's the least affected by queues
+	ClockOffset time.Duration `swaggertype:"primitive,integer"`
+	// DelayAsymmetry is how much longer packets go to the peer than back on average, negative if they come back longer.
+	// It's relative to the ping of ClockOffset, since constant asymmetry can't be told apart from clock offset
+	DelayAsymmetry time.Duration `swaggertype:"primitive,integer"`
+}
+
+// Latency pings known peers periodically and keeps history of round trip times and lost pings,
+// so connection quality of every peer could be shown to users.
+type Latency struct {
+	p2p    P2p
+	conf   *config.Config
+	probe  *Probe
+	logger *log.ZapEventLogger
+
+	lock    sync.RWMutex
+	history map[peer.ID][]LatencySample
+}
+
+func NewLatency(p2pService P2p, conf *config.Config, probe *Probe) *Latency {
+	return &Latency{
+		p2p:     p2pService,
+		conf:    conf,
+		probe:   probe,
+		logger:  log.Logger("awl/service/latency"),
+		history: make(map[peer.ID][]LatencySample),
+	}
+}
+
+// StreamHandler answers pings of peers of older versions, pings are sent with Probe.
+func (l *Latency) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	response := make([]byte, pingPayloadSize+pingTimestampsSize)
+	_, err := io.ReadFull(stream, response[:pingPayloadSize])
+	if err != nil {
+		l.logger.Debugf("read ping from %s: %v", stream.Conn().RemotePeer(), err)
+		return
+	}
+	binary.BigEndian.PutUint64(response[pingPayloadSize:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(response[pingPayloadSize+8:], uint64(time.Now().UnixNano()))
+	_, err = stream.Write(response)
+	if err != nil {
+		l.logger.Debugf("answer ping of %s: %v", stream.Conn().RemotePeer(), err)
+	}
+}
+
+// BackgroundMonitor pings all known peers every latencyCheckInterval.
+func (l *Latency) BackgroundMonitor(ctx context.Context) {
+	ticker := time.NewTicker(latencyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		l.CheckPeers(ctx)
+	}
+}
+
+// CheckPeers pings all known peers at once and adds results to their history.
+// Disconnected peers aren't dialed, their pings are counted as lost.
+func (l *Latency) CheckPeers(ctx context.Context) {
+	peerIDs := l.conf.KnownPeersIds()
+	samples := make([]LatencySample, len(peerIDs))
+	var wg sync.WaitGroup
+	for i, peerID := range peerIDs {
+		wg.Add(1)
+		go func(i int, peerID peer.ID) {
+			defer wg.Done()
+			sample, err := l.ping(ctx, peerID)
+			if err != nil {
+				l.logger.Debugf("ping %s: %v", peerID, err)
+			}
+			samples[i] = sample
+		}(i, peerID)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	known := make(map[peer.ID]struct{}, len(peerIDs))
+	for i, peerID := range peerIDs {
+		known[peerID] = struct{}{}
+		history := append(l.history[peerID], samples[i])
+		if len(history) > latencyHistorySize {
+			history = history[len(history)-latencyHistorySize:]
+		}
+		l.history[peerID] = history
+	}
+	for peerID := range l.history {
+		if _, ok := known[peerID]; !ok {
+			delete(l.history, peerID)
+		}
+	}
+}
+
+// ping returns lost sample with error.
+func (l *Latency) ping(ctx context.Context, peerID peer.ID) (LatencySample, error) {
+	sample := LatencySample{At: time.Now(), Lost: true}
+	if !l.p2p.IsConnected(peerID) {
+		return sample, errors.New("peer is not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, latencyPingTimeout)
+	defer cancel()
+	result, err := l.probe.Send(network.WithNoDial(ctx, "latency"), peerID, pingPayloadSize)
+	if err != nil {
+		return sample, err
+	}
+	sample.RTT = result.RTT
+	sample.Lost = false
+	sample.Relayed = result.Relayed
+	sample.Timed = result.Timed
+	sample.ClockOffset = result.ClockOffset
+	return sample, nil
+}
+
+// PeerLatency returns summary of recent pings of the peer, false if it wasn't pinged yet.
+func (l *Latency) PeerLatency(peerID peer.ID) (PeerLatency, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	history, exists := l.history[peerID]
+	if !exists {
+		return PeerLatency{}, false
+	}
+	return summarizeLatency(history), true
+}
+
+// History returns recent pings of the peer from the oldest one.
+func (l *Latency) History(peerID peer.ID) []LatencySample {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return append([]LatencySample{}, l.history[peerID]...)
+}
+
+// estimateClockOffset is NTP offset by our send and receive time and the peer receive and send time.
+func estimateClockOffset(started, received, sent, finished time.Time) time.Duration {
+	return (received.Sub(started) + sent.Sub(finished)) / 2
+}
+
+func summarizeLatency(history []LatencySample) PeerLatency {
+	result := PeerLatency{Pings: len(history)}
+	var lost, timed int
+	var total, totalOffset time.Duration
+	var bestRTT time.Duration
+	for _, sample := range history {
+		if sample.Lost {
+			lost++
+			continue
+		}
+		if sample.Timed {
+			timed++
+			totalOffset += sample.ClockOffset
+			if !result.ClockOffsetKnown || sample.RTT < bestRTT {
+				result.ClockOffsetKnown = true
+				result.ClockOffset = sample.ClockOffset
+				bestRTT = sample.RTT
+			}
+		}
+		total += sample.RTT
+		if result.MinRTT == 0 || sample.RTT < result.MinRTT {
+			result.MinRTT = sample.RTT
+		}
+		result.MaxRTT = max(result.MaxRTT, sample.RTT)
+		result.Relayed = sample.Relayed
+	}
+	if answered := len(history) - lost; answered > 0 {
+		result.AvgRTT = total / time.Duration(answered)
+	}
+	if timed > 0 {
+		// offset of the ping is shifted from the best one by half of the difference between one-way delays
+		result.DelayAsymmetry = 2 * (totalOffset/time.Duration(timed) - result.ClockOffset)
+	}
+	if len(history) > 0 {
+		last := history[len(history)-1]
+		result.Reachable = !last.Lost
+		result.LastRTT = last.RTT
+		result.CheckedAt = last.At
+		result.PacketLoss = float64(lost) * 100 / float64(len(history))
+	}
+	return result
+}
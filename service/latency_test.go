@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeLatencyClockOffset(t *testing.T) {
+	a := require.New(t)
+	started := time.Now()
+	// the peer clock is 5 seconds ahead, packets go 10ms to the peer and 30ms back
+	received := started.Add(5*time.Second + 10*time.Millisecond)
+	sent := received.Add(time.Millisecond)
+	finished := started.Add(41 * time.Millisecond)
+	offset := estimateClockOffset(started, received, sent, finished)
+	a.Equal(5*time.Second-10*time.Millisecond, offset)
+
+	history := []LatencySample{
+		// the best ping has symmetric delays
+		{RTT: 20 * time.Millisecond, Timed: true, ClockOffset: 5 * time.Second},
+		{RTT: 41 * time.Millisecond, Timed: true, ClockOffset: offset},
+		{Lost: true},
+		// pings of older versions don't have time
+		{RTT: time.Millisecond},
+	}
+	latency := summarizeLatency(history)
+	a.True(latency.ClockOffsetKnown)
+	a.Equal(5*time.Second, latency.ClockOffset)
+	a.Equal(-10*time.Millisecond, latency.DelayAsymmetry)
+	a.Equal(time.Millisecond, latency.MinRTT)
+
+	latency = summarizeLatency(history[2:])
+	a.False(latency.ClockOffsetKnown)
+	a.Zero(latency.ClockOffset)
+	a.Zero(latency.DelayAsymmetry)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const managementMaxIdleConns = 4
+
+type (
+	managementPeerKey  struct{}
+	managementErrorKey struct{}
+)
+
+// Management lets known peers administer this node over awl network: management streams are HTTP connections
+// to our web api, see Listener. Peers should be granted with config.KnownPeer.ManagementAllowed.
+// It also proxies local api requests to the api of peers which granted management to us.
+type Management struct {
+	p2p      P2p
+	conf     *config.Config
+	logger   *log.ZapEventLogger
+	listener *streamListener
+	proxy    *httputil.ReverseProxy
+}
+
+func NewManagement(p2pService P2p, conf *config.Config) *Management {
+	m := &Management{
+		p2p:      p2pService,
+		conf:     conf,
+		logger:   log.Logger("awl/service/management"),
+		listener: newStreamListener(),
+	}
+	m.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			peerID := req.Context().Value(managementPeerKey{}).(peer.ID)
+			prefix := protocol.ManagementPathPrefix + peerID.String()
+			req.URL.Scheme = "http"
+			req.URL.Host = peerID.String()
+			req.Host = peerID.String()
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
+			req.URL.RawPath = ""
+			// our api keys mean nothing to the peer, the stream itself is authenticated
+			req.Header.Del("Authorization")
+		},
+		Transport: &http.Transport{
+			DialContext:         m.dialPeer,
+			MaxIdleConnsPerHost: managementMaxIdleConns,
+			IdleConnTimeout:     sharedFolderIdleTimeout,
+		},
+		// response is written by the caller of ProxyToPeer
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			m.logger.Debugf("proxy %s %s: %v", req.Method, req.URL.Path, err)
+			*req.Context().Value(managementErrorKey{}).(*error) = err
+		},
+	}
+
+	return m
+}
+
+// AllowPeer returns true if the peer is allowed to manage this node.
+func (m *Management) AllowPeer(peerID peer.ID) bool {
+	knownPeer, known := m.conf.GetPeer(peerID.String())
+	return known && knownPeer.ManagementAllowed
+}
+
+func (m *Management) StreamHandler(stream network.Stream) {
+	if !m.listener.push(stream) {
+		_ = stream.Reset()
+	}
+}
+
+// Listener accepts management streams as connections, remote address of connection is the peer id.
+// The grant is checked when streams are opened, web api should check it again for each request.
+func (m *Management) Listener() net.Listener {
+	return m.listener
+}
+
+// ProxyToPeer forwards local api request to the api of the peer.
+// Request path should start with protocol.ManagementPathPrefix and the peer id, followed by the api path.
+// Nothing is written to w if error is returned, e.g. the peer is unreachable or doesn't allow us to manage it.
+func (m *Management) ProxyToPeer(w http.ResponseWriter, r *http.Request, peerID peer.ID) error {
+	var proxyErr error
+	ctx := context.WithValue(r.Context(), managementPeerKey{}, peerID)
+	ctx = context.WithValue(ctx, managementErrorKey{}, &proxyErr)
+	m.proxy.ServeHTTP(w, r.WithContext(ctx))
+	return proxyErr
+}
+
+func (m *Management) Close() {
+	_ = m.listener.Close()
+	m.proxy.Transport.(*http.Transport).CloseIdleConnections()
+}
+
+func (m *Management) dialPeer(ctx context.Context, _, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	peerID, err := peer.Decode(host)
+	if err != nil {
+		return nil, err
+	}
+	err = m.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := m.p2p.NewStream(ctx, peerID, protocol.ManagementMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamConn{stream}, nil
+}
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/storage"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	MessageStreamTimeout = 30 * time.Second
+
+	MessageDirectionInbound  = "inbound"
+	MessageDirectionOutbound = "outbound"
+
+	messagesStorageNamespace = "messages"
+	// messagesHistorySize is the number of stored messages with all peers, the oldest ones are removed
+	messagesHistorySize = 1000
+)
+
+// Message is a text message sent to or received from a known peer.
+type Message struct {
+	ID        string
+	PeerID    string
+	Direction string `enums:"inbound,outbound"`
+	Text      string
+	// Time is when the message was sent or received by our clock, SentAt is by the sender clock
+	Time   time.Time
+	SentAt time.Time
+	// Read is false for inbound messages until they are marked as read, outbound messages are always read
+	Read bool
+}
+
+// Messages delivers short text messages and notifications between known peers, e.g. "rebooting the shared server".
+// Messages are stored in history with unread counts, new ones are emitted as awlevent.TextMessageReceived.
+type Messages struct {
+	p2p     P2p
+	conf    *config.Config
+	store   ds.Batching
+	logger  *log.ZapEventLogger
+	emitter awlevent.Emitter
+
+	lock   sync.Mutex
+	count  int
+	unread map[string]int
+}
+
+func NewMessages(p2pService P2p, conf *config.Config, s storage.Storage, eventbus awlevent.Bus) *Messages {
+	emitter, err := eventbus.Emitter(new(awlevent.TextMessageReceived))
+	if err != nil {
+		panic(err)
+	}
+	m := &Messages{
+		p2p:     p2pService,
+		conf:    conf,
+		store:   storage.Namespace(s, messagesStorageNamespace),
+		logger:  log.Logger("awl/service/messages"),
+		emitter: emitter,
+		unread:  make(map[string]int),
+	}
+	messages, err := m.load()
+	if err != nil {
+		m.logger.Errorf("load messages: %v", err)
+	}
+	m.count = len(messages)
+	for _, message := range messages {
+		if !message.Read {
+			m.unread[message.PeerID]++
+		}
+	}
+
+	return m
+}
+
+func (m *Messages) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	peerID := stream.Conn().RemotePeer().String()
+	knownPeer, _ := m.conf.GetPeer(peerID)
+	request, err := protocol.ReceiveTextMessage(stream)
+	if err != nil {
+		m.logger.Warnf("receive message from %s: %v", knownPeer.DisplayName(), err)
+		return
+	}
+
+	response := protocol.TextMessageResponse{}
+	message := Message{
+		ID:        newMessageID(),
+		PeerID:    peerID,
+		Direction: MessageDirectionInbound,
+		Text:      request.Text,
+		Time:      time.Now(),
+		SentAt:    time.UnixMilli(request.Time),
+	}
+	err = validateMessageText(request.Text)
+	if err == nil {
+		err = m.save(message)
+		if err != nil {
+			m.logger.Errorf("save message: %v", err)
+			err = errors.New("internal error")
+		}
+	}
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		m.logger.Infof("Received message from %s", knownPeer.DisplayName())
+		_ = m.emitter.Emit(awlevent.TextMessageReceived{ID: message.ID, PeerID: peerID, Text: message.Text})
+	}
+
+	err = protocol.SendTextMessageResponse(stream, response)
+	if err != nil {
+		m.logger.Warnf("send message response to %s: %v", knownPeer.DisplayName(), err)
+	}
+}
+
+// Send delivers the message to the peer, it's stored in history only if the peer has received it.
+func (m *Messages) Send(ctx context.Context, peerID peer.ID, text string) (Message, error) {
+	err := validateMessageText(text)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = m.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return Message{}, err
+	}
+	stream, err := m.p2p.NewStream(ctx, peerID, protocol.MessageMethod)
+	if err != nil {
+		return Message{}, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	now := time.Now()
+	err = protocol.SendTextMessage(stream, protocol.TextMessage{Text: text, Time: now.UnixMilli()})
+	if err != nil {
+		return Message{}, fmt.Errorf("send message: %v", err)
+	}
+	response, err := protocol.ReceiveTextMessageResponse(stream)
+	if err != nil {
+		return Message{}, fmt.Errorf("receive response: %v", err)
+	} else if response.Error != "" {
+		return Message{}, errors.New(response.Error)
+	}
+
+	message := Message{
+		ID:        newMessageID(),
+		PeerID:    peerID.String(),
+		Direction: MessageDirectionOutbound,
+		Text:      text,
+		Time:      now,
+		SentAt:    now,
+		Read:      true,
+	}
+	err = m.save(message)
+	if err != nil {
+		m.logger.Errorf("save message: %v", err)
+	}
+	return message, nil
+}
+
+// History returns the latest messages with the peer or with all peers if peerID is empty, the oldest first.
+// Zero limit returns all stored messages.
+func (m *Messages) History(peerID string, limit int) ([]Message, error) {
+	messages, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		if peerID == "" || message.PeerID == peerID {
+			result = append(result, message)
+		}
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
+
+// MarkRead marks messages from the peer as read, messages from all peers if peerID is empty.
+func (m *Messages) MarkRead(peerID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entries, err := m.query()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		var message Message
+		err = json.Unmarshal(entry.Value, &message)
+		if err != nil || message.Read || (peerID != "" && message.PeerID != peerID) {
+			continue
+		}
+		message.Read = true
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		err = m.store.Put(context.Background(), ds.NewKey(entry.Key), data)
+		if err != nil {
+			return err
+		}
+	}
+	if peerID == "" {
+		m.unread = make(map[string]int)
+	} else {
+		delete(m.unread, peerID)
+	}
+	return nil
+}
+
+// UnreadCount returns the number of unread messages from the peer.
+func (m *Messages) UnreadCount(peerID string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.unread[peerID]
+}
+
+// UnreadTotal returns the number of unread messages from all peers.
+func (m *Messages) UnreadTotal() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	total := 0
+	for _, count := range m.unread {
+		total += count
+	}
+	return total
+}
+
+// save stores the message, the oldest messages are removed if there are more than messagesHistorySize.
+func (m *Messages) save(message Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	err = m.store.Put(context.Background(), messageKey(message), data)
+	if err != nil {
+		return err
+	}
+	m.count++
+	if !message.Read {
+		m.unread[message.PeerID]++
+	}
+	if m.count > messagesHistorySize {
+		m.prune()
+	}
+	return nil
+}
+
+func (m *Messages) prune() {
+	entries, err := m.query()
+	if err != nil {
+		m.logger.Errorf("query messages: %v", err)
+		return
+	}
+	for len(entries) > messagesHistorySize {
+		var message Message
+		if json.Unmarshal(entries[0].Value, &message) == nil && !message.Read && m.unread[message.PeerID] > 0 {
+			m.unread[message.PeerID]--
+		}
+		err = m.store.Delete(context.Background(), ds.NewKey(entries[0].Key))
+		if err != nil {
+			m.logger.Errorf("delete message: %v", err)
+		}
+		entries = entries[1:]
+	}
+	m.count = len(entries)
+}
+
+func (m *Messages) load() ([]Message, error) {
+	m.lock.Lock()
+	entries, err := m.query()
+	m.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		var message Message
+		err = json.Unmarshal(entry.Value, &message)
+		if err != nil {
+			m.logger.Warnf("decode message %s: %v", entry.Key, err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// query returns stored entries sorted by key, i.e. by time.
+func (m *Messages) query() ([]dsq.Entry, error) {
+	results, err := m.store.Query(context.Background(), dsq.Query{})
+	if err != nil {
+		return nil, fmt.Errorf("query messages: %v", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("query messages: %v", err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// messageKey keeps keys sorted by time, e.g. /001700000000000000000-0123456789abcdef.
+func messageKey(message Message) ds.Key {
+	return ds.NewKey(fmt.Sprintf("%021d-%s", message.Time.UnixNano(), message.ID))
+}
+
+func newMessageID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func validateMessageText(text string) error {
+	switch {
+	case strings.TrimSpace(text) == "":
+		return errors.New("message is empty")
+	case len(text) > protocol.MaxTextMessageLength:
+		return fmt.Errorf("message is longer than %d bytes", protocol.MaxTextMessageLength)
+	case !utf8.ValidString(text):
+		return errors.New("message is not valid utf-8")
+	}
+	return nil
+}
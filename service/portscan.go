@@ -0,0 +1,252 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	PortScanStreamTimeout = time.Minute
+
+	portScanDialTimeout   = 500 * time.Millisecond
+	portScanBannerTimeout = 300 * time.Millisecond
+	portScanWorkers       = 64
+)
+
+// PortScan probes which TCP ports are listening on this node or hosts of our advertised routes for the owner,
+// i.e. peers granted with config.KnownPeer.ManagementAllowed, so forwarding could be set up without logging in.
+type PortScan struct {
+	p2p    P2p
+	conf   *config.Config
+	logger *log.ZapEventLogger
+}
+
+func NewPortScan(p2pService P2p, conf *config.Config) *PortScan {
+	return &PortScan{
+		p2p:    p2pService,
+		conf:   conf,
+		logger: log.Logger("awl/service/portscan"),
+	}
+}
+
+func (s *PortScan) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	peerID := stream.Conn().RemotePeer().String()
+	knownPeer, _ := s.conf.GetPeer(peerID)
+	request, err := protocol.ReceivePortScanRequest(stream)
+	if err != nil {
+		s.logger.Warnf("receive port scan request from %s: %v", knownPeer.DisplayName(), err)
+		return
+	}
+
+	response := protocol.PortScanResponse{}
+	if !knownPeer.ManagementAllowed {
+		s.logger.Infof("Peer %s tried to scan ports without management access", knownPeer.DisplayName())
+		response.Error = "management access is not granted"
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), PortScanStreamTimeout)
+		response.Open, err = s.scan(ctx, request.Host, request.Ports)
+		cancel()
+		if err != nil {
+			response.Error = err.Error()
+		} else {
+			s.logger.Infof("Scanned ports %s of %s for %s, %d are open", request.Ports, scanHostName(request.Host),
+				knownPeer.DisplayName(), len(response.Open))
+		}
+	}
+
+	err = protocol.SendPortScanResponse(stream, response)
+	if err != nil {
+		s.logger.Warnf("send port scan response to %s: %v", knownPeer.DisplayName(), err)
+	}
+}
+
+// Scan asks the peer which ports are listening on it or on the host of its advertised routes if host isn't empty.
+// The peer should grant us management.
+func (s *PortScan) Scan(ctx context.Context, peerID peer.ID, host, ports string) ([]protocol.OpenPort, error) {
+	_, err := ParsePorts(ports)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.p2p.NewStream(ctx, peerID, protocol.PortScanMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	err = protocol.SendPortScanRequest(stream, protocol.PortScanRequest{Host: host, Ports: ports})
+	if err != nil {
+		return nil, fmt.Errorf("send request: %v", err)
+	}
+	response, err := protocol.ReceivePortScanResponse(stream)
+	if err != nil {
+		return nil, fmt.Errorf("receive response: %v", err)
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response.Open, nil
+}
+
+// scan probes ports of the host, empty host is this node. Other hosts should be in our advertised routes,
+// so the owner reaches only networks which we share anyway.
+func (s *PortScan) scan(ctx context.Context, host, portsSpec string) ([]protocol.OpenPort, error) {
+	ports, err := ParsePorts(portsSpec)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.IPv4(127, 0, 0, 1)
+	if host != "" {
+		ip = net.ParseIP(host).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q", host)
+		}
+		s.conf.RLock()
+		routes := parseNetworks(s.conf.VPNConfig.AdvertisedRoutes)
+		s.conf.RUnlock()
+		if !containsIP(routes, ip) {
+			return nil, fmt.Errorf("host %s is not in advertised routes", host)
+		}
+	}
+	return probePorts(ctx, ip, ports), nil
+}
+
+// ParsePorts parses single ports and ranges separated by commas, e.g. "22,80,8000-8100".
+// Ports are sorted and unique, there could be up to protocol.MaxPortScanPorts of them.
+func ParsePorts(ports string) ([]uint16, error) {
+	seen := make(map[uint16]bool)
+	for _, part := range strings.Split(ports, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		from, to, err := parsePortRange(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ports %q: %v", part, err)
+		}
+		if int(to-from)+len(seen) >= protocol.MaxPortScanPorts {
+			return nil, fmt.Errorf("too many ports, max %d", protocol.MaxPortScanPorts)
+		}
+		for port := int(from); port <= int(to); port++ {
+			seen[uint16(port)] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, errors.New("ports are empty")
+	}
+	result := make([]uint16, 0, len(seen))
+	for port := range seen {
+		result = append(result, port)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	return result, nil
+}
+
+// probePorts returns ports of ip which accept TCP connections sorted by port.
+func probePorts(ctx context.Context, ip net.IP, ports []uint16) []protocol.OpenPort {
+	portsCh := make(chan uint16)
+	go func() {
+		defer close(portsCh)
+		for _, port := range ports {
+			select {
+			case portsCh <- port:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		result []protocol.OpenPort
+	)
+	for i := 0; i < min(portScanWorkers, len(ports)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range portsCh {
+				banner, ok := probePort(ctx, ip, port)
+				if !ok {
+					continue
+				}
+				lock.Lock()
+				result = append(result, protocol.OpenPort{Port: int(port), Banner: banner})
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Port < result[j].Port
+	})
+	return result
+}
+
+// probePort connects to the port and reads the first line if the service sends it, e.g. SSH or SMTP.
+func probePort(ctx context.Context, ip net.IP, port uint16) (string, bool) {
+	dialer := net.Dialer{Timeout: portScanDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	if err != nil {
+		return "", false
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(portScanBannerTimeout))
+	line, _ := bufio.NewReader(io.LimitReader(conn, 2*protocol.MaxPortBannerLength)).ReadString('\n')
+	return cleanBanner(line), true
+}
+
+// cleanBanner keeps printable characters of the first line up to protocol.MaxPortBannerLength bytes.
+func cleanBanner(line string) string {
+	line = strings.ToValidUTF8(strings.TrimSpace(line), "")
+	var banner strings.Builder
+	for _, r := range line {
+		if !unicode.IsPrint(r) {
+			continue
+		}
+		if banner.Len()+len(string(r)) > protocol.MaxPortBannerLength {
+			break
+		}
+		banner.WriteRune(r)
+	}
+	return banner.String()
+}
+
+func scanHostName(host string) string {
+	if host == "" {
+		return "this node"
+	}
+	return host
+}
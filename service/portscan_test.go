@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/anywherelan/awl/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePorts(t *testing.T) {
+	a := require.New(t)
+
+	ports, err := ParsePorts("8080-8082, 22,80,8081")
+	a.NoError(err)
+	a.Equal([]uint16{22, 80, 8080, 8081, 8082}, ports)
+
+	ports, err = ParsePorts("1-4096")
+	a.NoError(err)
+	a.Len(ports, protocol.MaxPortScanPorts)
+
+	for _, invalid := range []string{"", " , ", "0", "80-22", "http", "1-4097", "1-4096,5000"} {
+		_, err = ParsePorts(invalid)
+		a.Error(err, invalid)
+	}
+}
+
+func TestProbePorts(t *testing.T) {
+	a := require.New(t)
+
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer silent.Close()
+	banner, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer banner.Close()
+	go func() {
+		for {
+			conn, err := banner.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("220 mail.example.com ESMTP\x07 ready\r\nEHLO\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	closedPort := uint16(closed.Addr().(*net.TCPAddr).Port)
+	a.NoError(closed.Close())
+
+	silentPort := uint16(silent.Addr().(*net.TCPAddr).Port)
+	bannerPort := uint16(banner.Addr().(*net.TCPAddr).Port)
+	open := probePorts(context.Background(), net.IPv4(127, 0, 0, 1), []uint16{silentPort, bannerPort, closedPort})
+	expected := []protocol.OpenPort{{Port: int(silentPort)}, {Port: int(bannerPort), Banner: "220 mail.example.com ESMTP ready"}}
+	if bannerPort < silentPort {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	a.Equal(expected, open)
+}
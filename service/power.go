@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/power"
+	"github.com/ipfs/go-log/v2"
+)
+
+const powerCheckInterval = time.Minute
+
+// PowerProfile is the current power profile of the device, see config.PowerConfig.
+type PowerProfile struct {
+	// Mode is the configured mode: auto, ac or battery
+	Mode string
+	// OnBattery is the detected power source, it's detected only in auto mode
+	OnBattery bool
+	// DetectionError is set when the power source couldn't be detected, the device is treated as on AC then
+	DetectionError string
+	// PowerSave reduces keepalives, DHT participation and status exchange with peers
+	PowerSave bool
+	// ChangedAt is the time PowerSave was last changed
+	ChangedAt time.Time
+}
+
+type PowerSaver interface {
+	SetPowerSave(enabled bool)
+}
+
+// Power switches power save mode of p2p by the power source, background activity ramps back up on AC power.
+type Power struct {
+	conf   *config.Config
+	p2p    PowerSaver
+	logger *log.ZapEventLogger
+	detect func() (bool, error)
+
+	lock    sync.Mutex
+	profile PowerProfile
+}
+
+func NewPower(conf *config.Config, p2p PowerSaver) *Power {
+	p := &Power{
+		conf:   conf,
+		p2p:    p2p,
+		logger: log.Logger("awl/service/power"),
+		detect: power.OnBattery,
+	}
+	p.Refresh()
+
+	return p
+}
+
+// BackgroundMonitor detects the power source every powerCheckInterval.
+func (p *Power) BackgroundMonitor(ctx context.Context) {
+	ticker := time.NewTicker(powerCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Refresh()
+		}
+	}
+}
+
+// Refresh applies the mode from config and detects the power source in auto mode.
+func (p *Power) Refresh() {
+	p.conf.RLock()
+	mode := p.conf.Power.Mode
+	p.conf.RUnlock()
+
+	profile := PowerProfile{Mode: mode}
+	switch mode {
+	case config.PowerModeBattery:
+		profile.PowerSave = true
+	case config.PowerModeAC:
+	default:
+		onBattery, err := p.detect()
+		if err != nil {
+			profile.DetectionError = err.Error()
+		}
+		profile.OnBattery = onBattery
+		profile.PowerSave = onBattery
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	profile.ChangedAt = p.profile.ChangedAt
+	if profile.PowerSave != p.profile.PowerSave || profile.ChangedAt.IsZero() {
+		profile.ChangedAt = time.Now()
+	}
+	if profile.DetectionError != "" && profile.DetectionError != p.profile.DetectionError {
+		p.logger.Debugf("detect power source: %s", profile.DetectionError)
+	}
+	p.profile = profile
+	p.p2p.SetPowerSave(profile.PowerSave)
+}
+
+func (p *Power) Profile() PowerProfile {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.profile
+}
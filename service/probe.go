@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2pProtocol "github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	ProbeStreamTimeout = 30 * time.Second
+
+	// probeHeaderSize is the payload size which precedes payload of the request
+	probeHeaderSize = 4
+	// probeTimestampsSize is receive and transmit time of the peer which precede echoed payload of the response
+	probeTimestampsSize = 16
+)
+
+// ProbeResult is the result of a single probe, it's the base of latency, speed and end-to-end checks.
+type ProbeResult struct {
+	// RTT includes transfer of the payload both ways
+	RTT   time.Duration
+	Bytes int
+	// Relayed is true if the probe went through relay
+	Relayed bool
+	// Timed is false if the peer answered with plain echo, e.g. it's of older version or unknown peer in echo peer mode
+	Timed bool
+	// ClockOffset is the peer clock minus ours estimated like NTP, assuming that delays both ways are equal
+	ClockOffset time.Duration
+}
+
+// Probe is the measurement protocol which every node answers for known peers: payload of requested size is echoed
+// with receive and transmit time of the peer. Ping, speed test and echo checks are built upon it.
+type Probe struct {
+	p2p    P2p
+	conf   *config.Config
+	logger *log.ZapEventLogger
+}
+
+func NewProbe(p2pService P2p, conf *config.Config) *Probe {
+	return &Probe{
+		p2p:    p2pService,
+		conf:   conf,
+		logger: log.Logger("awl/service/probe"),
+	}
+}
+
+func (p *Probe) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+	peerID := stream.Conn().RemotePeer()
+
+	header := make([]byte, probeHeaderSize)
+	_, err := io.ReadFull(stream, header)
+	if err != nil {
+		p.logger.Debugf("read probe from %s: %v", peerID, err)
+		return
+	}
+	payloadSize := binary.BigEndian.Uint32(header)
+	if payloadSize == 0 || payloadSize > protocol.MaxEchoPayloadSize {
+		p.logger.Debugf("invalid probe payload size %d from %s", payloadSize, peerID)
+		return
+	}
+
+	response := make([]byte, probeTimestampsSize+int(payloadSize))
+	_, err = io.ReadFull(stream, response[probeTimestampsSize:])
+	if err != nil {
+		p.logger.Debugf("read probe from %s: %v", peerID, err)
+		return
+	}
+	binary.BigEndian.PutUint64(response, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(response[8:], uint64(time.Now().UnixNano()))
+	_, err = stream.Write(response)
+	if err != nil {
+		p.logger.Debugf("answer probe of %s: %v", peerID, err)
+	}
+}
+
+// Send sends random payload of given size to the peer and checks that the same payload is received back.
+// Known peers are probed with timestamps, other peers and peers of older versions are asked for plain echo.
+// The peer isn't dialed, it should be connected by the caller.
+func (p *Probe) Send(ctx context.Context, peerID peer.ID, payloadSize int) (ProbeResult, error) {
+	if payloadSize <= 0 || payloadSize > protocol.MaxEchoPayloadSize {
+		return ProbeResult{}, fmt.Errorf("payload size should be in range 1-%d", protocol.MaxEchoPayloadSize)
+	}
+	payload := make([]byte, payloadSize)
+	_, _ = rand.Read(payload)
+
+	protos := []libp2pProtocol.ID{protocol.ProbeMethod, protocol.EchoMethod}
+	if _, known := p.conf.GetPeer(peerID.String()); !known {
+		protos = []libp2pProtocol.ID{protocol.EchoMethod}
+	}
+	started := time.Now()
+	stream, err := p.p2p.NewStream(ctx, peerID, protos...)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	result := ProbeResult{Bytes: payloadSize}
+	_, err = stream.Conn().RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT)
+	result.Relayed = err == nil
+
+	var response []byte
+	if stream.Protocol() == protocol.ProbeMethod {
+		var timestamps []byte
+		timestamps, response, err = exchangeProbe(stream, payload)
+		if err == nil {
+			finished := time.Now()
+			received := time.Unix(0, int64(binary.BigEndian.Uint64(timestamps)))
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(timestamps[8:])))
+			result.RTT = finished.Sub(started)
+			result.Timed = true
+			result.ClockOffset = estimateClockOffset(started, received, sent, finished)
+		}
+	} else {
+		response, err = exchangeEcho(stream, payload)
+		result.RTT = time.Since(started)
+	}
+	if errors.Is(err, io.EOF) {
+		return ProbeResult{}, errors.New("peer closed the stream without answer, probably it doesn't know us")
+	} else if err != nil {
+		return ProbeResult{}, err
+	}
+	if !bytes.Equal(payload, response) {
+		return ProbeResult{}, errors.New("received payload differs from sent one")
+	}
+
+	return result, nil
+}
+
+func exchangeProbe(stream network.Stream, payload []byte) (timestamps, response []byte, err error) {
+	request := make([]byte, probeHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(request, uint32(len(payload)))
+	copy(request[probeHeaderSize:], payload)
+	_, err = stream.Write(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("send payload: %v", err)
+	}
+
+	// the peer reads whole payload before answering, so the response is read after it's sent
+	data := make([]byte, probeTimestampsSize+len(payload))
+	_, err = io.ReadFull(stream, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("receive payload: %w", err)
+	}
+	return data[:probeTimestampsSize], data[probeTimestampsSize:], nil
+}
+
+func exchangeEcho(stream network.Stream, payload []byte) ([]byte, error) {
+	// payload is read while it's sent, otherwise large payloads stall when flow control windows are full
+	response := make([]byte, len(payload))
+	received := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(stream, response)
+		received <- err
+	}()
+
+	_, err := stream.Write(payload)
+	if err != nil {
+		return nil, fmt.Errorf("send payload: %v", err)
+	}
+	err = stream.CloseWrite()
+	if err != nil {
+		return nil, fmt.Errorf("close write: %v", err)
+	}
+
+	err = <-received
+	if err != nil {
+		return nil, fmt.Errorf("receive payload: %w", err)
+	}
+	return response, nil
+}
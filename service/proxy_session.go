@@ -0,0 +1,391 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+const (
+	// ProxySessionResumeTimeout is how long resumable proxy connection waits for a new stream after outage
+	ProxySessionResumeTimeout = 30 * time.Second
+	proxySessionRetryInterval = time.Second
+	// proxySessionBufferSize is sent data which isn't acknowledged by the peer, reads of the connection wait for acks
+	proxySessionBufferSize = 1 << 20
+	// proxySessionAckSize is received data after which it's acknowledged
+	proxySessionAckSize = 64 << 10
+)
+
+var (
+	errProxySessionNotFound = errors.New("proxy session not found")
+	errProxySessionClosed   = errors.New("proxy session is closed")
+)
+
+// proxySession is TCP connection of SOCKS5 proxy which survives brief outages, e.g. Wi-Fi switch. Data is sent
+// in frames and kept until the peer acknowledges it, so when the stream breaks the client opens a new one and
+// both sides continue from the last received byte. The connection is closed if it isn't resumed in time.
+type proxySession struct {
+	id     string
+	conn   net.Conn
+	logger *log.ZapEventLogger
+	// reconnect opens a new stream on the client side, it returns bytes received by the proxy peer.
+	// It's nil on the proxy peer side, which waits for the client
+	reconnect func(ctx context.Context, received uint64) (network.Stream, uint64, error)
+	onClose   func()
+
+	lock    sync.Mutex
+	changed *sync.Cond
+	stream  network.Stream
+	// readerDone is closed when reader of the last attached stream exits
+	readerDone chan struct{}
+	// generation is incremented on every attach, it cancels expiration of the disconnected session
+	generation int
+	// unacked is data from offset acked which isn't acknowledged by the peer, data till offset sent is written to stream
+	unacked          []byte
+	acked            uint64
+	sent             uint64
+	received         uint64
+	receivedSinceAck int
+	ackPending       bool
+	// connEOF is set when the connection is read till the end, then close frame is sent after the data
+	connEOF    bool
+	closeSent  bool
+	peerClosed bool
+	closed     bool
+}
+
+func newProxySession(id string, conn net.Conn, logger *log.ZapEventLogger,
+	reconnect func(ctx context.Context, received uint64) (network.Stream, uint64, error)) *proxySession {
+	session := &proxySession{
+		id:        id,
+		conn:      conn,
+		logger:    logger,
+		reconnect: reconnect,
+	}
+	session.changed = sync.NewCond(&session.lock)
+	return session
+}
+
+func newProxySessionID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// run serves the session with the first stream, it returns when the session is closed.
+func (s *proxySession) run(stream network.Stream) {
+	done, err := s.attach(stream, 0, nil)
+	if err != nil {
+		return
+	}
+	go s.readConn()
+	go s.writeStream()
+	for stream != nil {
+		s.readStream(stream, done)
+		if s.reconnect == nil {
+			// the client resumes the session with new stream, see SOCKS5Proxy.resumeSession
+			break
+		}
+		stream, done = s.resume()
+	}
+
+	s.lock.Lock()
+	for !s.closed {
+		s.changed.Wait()
+	}
+	s.lock.Unlock()
+}
+
+// attach makes the stream current, data which isn't received by the peer is sent again. The previous stream
+// is reset and its reader is waited for, so received data isn't counted twice. handshake is called before
+// the stream is used with the number of bytes received by us.
+func (s *proxySession) attach(stream network.Stream, peerReceived uint64, handshake func(received uint64) error) (chan struct{}, error) {
+	s.lock.Lock()
+	previous, previousDone := s.stream, s.readerDone
+	s.stream = nil
+	s.generation++
+	s.lock.Unlock()
+	if previous != nil {
+		_ = previous.Reset()
+	}
+	if previousDone != nil {
+		<-previousDone
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil, errProxySessionClosed
+	}
+	buffered := s.acked + uint64(len(s.unacked))
+	if peerReceived < s.acked || peerReceived > buffered {
+		s.expireLocked()
+		return nil, fmt.Errorf("peer received %d bytes, but bytes %d-%d are buffered", peerReceived, s.acked, buffered)
+	}
+	if handshake != nil {
+		// received isn't changed without reader
+		received := s.received
+		s.lock.Unlock()
+		err := handshake(received)
+		s.lock.Lock()
+		if err != nil {
+			s.expireLocked()
+			return nil, err
+		}
+		if s.closed {
+			return nil, errProxySessionClosed
+		}
+	}
+
+	s.ackLocked(peerReceived)
+	s.sent = peerReceived
+	// the peer could miss close frame
+	s.closeSent = false
+	s.stream = stream
+	s.readerDone = make(chan struct{})
+	s.changed.Broadcast()
+	return s.readerDone, nil
+}
+
+// resume opens a new stream on the client side until ProxySessionResumeTimeout, nil stream means the session is closed.
+func (s *proxySession) resume() (network.Stream, chan struct{}) {
+	deadline := time.Now().Add(ProxySessionResumeTimeout)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		closed, received := s.closed, s.received
+		s.lock.Unlock()
+		if closed {
+			return nil, nil
+		}
+
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		stream, peerReceived, err := s.reconnect(ctx, received)
+		cancel()
+		if err == nil {
+			var done chan struct{}
+			done, err = s.attach(stream, peerReceived, nil)
+			if err == nil {
+				s.logger.Debugf("proxy session %s is resumed", s.id)
+				return stream, done
+			}
+			_ = stream.Reset()
+			break
+		} else if errors.Is(err, errProxySessionNotFound) {
+			break
+		}
+		s.logger.Debugf("resume proxy session %s: %v", s.id, err)
+		time.Sleep(proxySessionRetryInterval)
+	}
+
+	s.logger.Debugf("proxy session %s isn't resumed", s.id)
+	s.close()
+	return nil, nil
+}
+
+func (s *proxySession) readConn() {
+	buf := make([]byte, protocol.MaxProxyFrameSize)
+	for {
+		n, err := s.conn.Read(buf)
+		s.lock.Lock()
+		for n > 0 && len(s.unacked) >= proxySessionBufferSize && !s.closed {
+			s.changed.Wait()
+		}
+		if s.closed {
+			s.lock.Unlock()
+			return
+		}
+		s.unacked = append(s.unacked, buf[:n]...)
+		if err != nil {
+			s.connEOF = true
+		}
+		s.changed.Broadcast()
+		s.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeStream is the only writer of streams, so acks don't wait for data which is blocked by flow control.
+func (s *proxySession) writeStream() {
+	buf := make([]byte, 0, 2*protocol.MaxProxyFrameSize)
+	ack := make([]byte, 8)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for {
+		for !s.closed && (s.stream == nil || !s.hasPendingLocked()) {
+			s.changed.Wait()
+		}
+		if s.closed {
+			return
+		}
+
+		stream := s.stream
+		buf = buf[:0]
+		if s.ackPending {
+			binary.BigEndian.PutUint64(ack, s.received)
+			buf = protocol.AppendProxyFrame(buf, protocol.ProxyFrameAck, ack)
+			s.ackPending = false
+			s.receivedSinceAck = 0
+		}
+		buffered := s.acked + uint64(len(s.unacked))
+		size := min(buffered-s.sent, protocol.MaxProxyFrameSize)
+		if size > 0 {
+			start := s.sent - s.acked
+			buf = protocol.AppendProxyFrame(buf, protocol.ProxyFrameData, s.unacked[start:start+size])
+		}
+		sendClose := s.connEOF && !s.closeSent && s.sent+size == buffered
+		if sendClose {
+			buf = protocol.AppendProxyFrame(buf, protocol.ProxyFrameClose, nil)
+		}
+
+		s.lock.Unlock()
+		_, err := stream.Write(buf)
+		s.lock.Lock()
+		if s.stream != stream {
+			// unacked data is sent again to the new stream
+			continue
+		}
+		if err != nil {
+			s.detachLocked(stream, err)
+			continue
+		}
+		s.sent += size
+		s.closeSent = s.closeSent || sendClose
+		s.finishLocked()
+	}
+}
+
+func (s *proxySession) readStream(stream network.Stream, done chan struct{}) {
+	defer close(done)
+	reader := bufio.NewReaderSize(stream, protocol.MaxProxyFrameSize)
+	buf := make([]byte, protocol.MaxProxyFrameSize)
+	for {
+		kind, payload, err := protocol.ReadProxyFrame(reader, buf)
+		if err == nil && kind == protocol.ProxyFrameAck && len(payload) != 8 {
+			err = fmt.Errorf("invalid ack size %d", len(payload))
+		}
+		if err != nil {
+			s.lock.Lock()
+			s.detachLocked(stream, err)
+			s.lock.Unlock()
+			return
+		}
+
+		switch kind {
+		case protocol.ProxyFrameData:
+			_, err = s.conn.Write(payload)
+			if err != nil {
+				s.close()
+				return
+			}
+			s.lock.Lock()
+			s.received += uint64(len(payload))
+			s.receivedSinceAck += len(payload)
+			if s.receivedSinceAck >= proxySessionAckSize {
+				s.ackPending = true
+				s.changed.Broadcast()
+			}
+			s.lock.Unlock()
+		case protocol.ProxyFrameAck:
+			s.lock.Lock()
+			s.ackLocked(binary.BigEndian.Uint64(payload))
+			s.finishLocked()
+			s.lock.Unlock()
+		case protocol.ProxyFrameClose:
+			if cw, ok := s.conn.(closeWriter); ok {
+				_ = cw.CloseWrite()
+			}
+			s.lock.Lock()
+			s.peerClosed = true
+			// the final ack lets the peer finish
+			s.ackPending = true
+			s.changed.Broadcast()
+			s.lock.Unlock()
+		}
+	}
+}
+
+func (s *proxySession) close() {
+	s.lock.Lock()
+	s.closeLocked()
+	s.lock.Unlock()
+}
+
+func (s *proxySession) hasPendingLocked() bool {
+	return s.ackPending || s.sent < s.acked+uint64(len(s.unacked)) || (s.connEOF && !s.closeSent)
+}
+
+// ackLocked drops data received by the peer, acks beyond sent data are ignored.
+func (s *proxySession) ackLocked(peerReceived uint64) {
+	if peerReceived <= s.acked || peerReceived > s.acked+uint64(len(s.unacked)) {
+		return
+	}
+	s.unacked = s.unacked[peerReceived-s.acked:]
+	if len(s.unacked) == 0 {
+		s.unacked = nil
+	}
+	s.acked = peerReceived
+	s.sent = max(s.sent, s.acked)
+	s.changed.Broadcast()
+}
+
+// finishLocked closes the session when both sides have sent and received everything.
+func (s *proxySession) finishLocked() {
+	if s.connEOF && s.closeSent && len(s.unacked) == 0 && s.peerClosed && !s.ackPending {
+		s.closeLocked()
+	}
+}
+
+func (s *proxySession) detachLocked(stream network.Stream, err error) {
+	if s.stream != stream || s.closed {
+		return
+	}
+	s.logger.Debugf("proxy session %s is disconnected: %v", s.id, err)
+	_ = stream.Reset()
+	s.stream = nil
+	s.changed.Broadcast()
+	s.expireLocked()
+}
+
+// expireLocked closes the session on the proxy peer side if the client doesn't resume it in time.
+func (s *proxySession) expireLocked() {
+	if s.reconnect != nil || s.closed {
+		return
+	}
+	generation := s.generation
+	time.AfterFunc(ProxySessionResumeTimeout, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.stream == nil && s.generation == generation {
+			s.logger.Debugf("proxy session %s isn't resumed", s.id)
+			s.closeLocked()
+		}
+	})
+}
+
+func (s *proxySession) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.changed.Broadcast()
+	_ = s.conn.Close()
+	if s.stream != nil {
+		_ = s.stream.Close()
+	}
+	if s.onClose != nil {
+		go s.onClose()
+	}
+}
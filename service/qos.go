@@ -0,0 +1,263 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/vpn"
+)
+
+// priorityQueueWeights are shares of classes in a round of priorityQueue, in InterfaceMTU bytes.
+var priorityQueueWeights = [vpn.PriorityClasses]int{
+	vpn.PriorityLow:    1,
+	vpn.PriorityNormal: 4,
+	vpn.PriorityHigh:   16,
+}
+
+// QoSClassStats are numbers of packets of the class sent to all peers and dropped since the start.
+type QoSClassStats struct {
+	Priority string
+	Queued   int
+	Sent     int64
+	Dropped  int64
+}
+
+type qosCounters [vpn.PriorityClasses]struct {
+	sent    atomic.Int64
+	dropped atomic.Int64
+}
+
+// qosClassifier assigns vpn.Priority to packets sent to peers by config.QoSRule, then by vpn.Packet.DefaultPriority.
+type qosClassifier struct {
+	disabled bool
+	rules    []qosRule
+}
+
+type qosRule struct {
+	protocol string
+	portFrom uint16
+	portTo   uint16
+	priority vpn.Priority
+}
+
+// ValidateQoSRules returns error if any of the rules is invalid.
+func ValidateQoSRules(rules []config.QoSRule) error {
+	_, err := compileQoSRules(rules)
+	return err
+}
+
+func compileQoSRules(rules []config.QoSRule) ([]qosRule, error) {
+	if len(rules) > config.MaxQoSRules {
+		return nil, fmt.Errorf("too many rules, max %d", config.MaxQoSRules)
+	}
+	result := make([]qosRule, 0, len(rules))
+	for i, rule := range rules {
+		compiled := qosRule{protocol: rule.Protocol}
+		switch rule.Protocol {
+		case "", config.ACLProtocolTCP, config.ACLProtocolUDP:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid protocol %q", i+1, rule.Protocol)
+		}
+		var ok bool
+		compiled.priority, ok = vpn.ParsePriority(rule.Priority)
+		if !ok {
+			return nil, fmt.Errorf("rule %d: invalid priority %q", i+1, rule.Priority)
+		}
+		if rule.Ports == "" {
+			return nil, fmt.Errorf("rule %d: ports are required", i+1)
+		}
+		var err error
+		compiled.portFrom, compiled.portTo, err = parsePortRange(rule.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid ports %q: %v", i+1, rule.Ports, err)
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+func (c *qosClassifier) classify(packet *vpn.Packet) vpn.Priority {
+	if c.disabled {
+		return vpn.PriorityNormal
+	}
+	if len(c.rules) > 0 {
+		protocol, srcPort, dstPort, ok := packet.Transport()
+		if ok && (protocol == config.ACLProtocolTCP || protocol == config.ACLProtocolUDP) {
+			for _, rule := range c.rules {
+				if rule.match(protocol, srcPort, dstPort) {
+					return rule.priority
+				}
+			}
+		}
+	}
+	return packet.DefaultPriority()
+}
+
+func (r qosRule) match(protocol string, srcPort, dstPort uint16) bool {
+	if r.protocol != "" && r.protocol != protocol {
+		return false
+	}
+	return (srcPort >= r.portFrom && srcPort <= r.portTo) || (dstPort >= r.portFrom && dstPort <= r.portTo)
+}
+
+// priorityQueue is a queue of packets to a peer with a subqueue for each vpn.Priority. Classes are served by deficit
+// round robin with priorityQueueWeights, so interactive packets wait for a small share of bulk traffic instead of
+// the whole queue, while bulk traffic isn't starved. When the queue is full packets of lower classes are dropped first.
+// It's safe for concurrent use.
+type priorityQueue struct {
+	lock     sync.Mutex
+	classes  [vpn.PriorityClasses]priorityClass
+	current  vpn.Priority
+	len      int
+	capacity int
+	counters *qosCounters
+	notify   chan struct{}
+	closed   bool
+}
+
+type priorityClass struct {
+	packets []*vpn.Packet
+	// deficit is bytes which the class could send in the current round
+	deficit int
+}
+
+func newPriorityQueue(capacity int, counters *qosCounters) *priorityQueue {
+	return &priorityQueue{
+		// the first round starts from the high class
+		current:  vpn.PriorityLow,
+		capacity: capacity,
+		counters: counters,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Push returns dropped packet which should be put back by the caller, it could be the pushed one.
+func (q *priorityQueue) Push(packet *vpn.Packet, priority vpn.Priority) (dropped *vpn.Packet) {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return packet
+	}
+	if q.len >= q.capacity {
+		for p := vpn.PriorityLow; p < priority && dropped == nil; p++ {
+			class := &q.classes[p]
+			if len(class.packets) == 0 {
+				continue
+			}
+			last := len(class.packets) - 1
+			dropped = class.packets[last]
+			class.packets[last] = nil
+			class.packets = class.packets[:last]
+			q.len--
+			q.counters[p].dropped.Add(1)
+		}
+		if dropped == nil {
+			q.lock.Unlock()
+			q.counters[priority].dropped.Add(1)
+			return packet
+		}
+	}
+	q.classes[priority].packets = append(q.classes[priority].packets, packet)
+	q.len++
+	// notify is closed by Close under the lock, so it's signaled under the lock too
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	q.lock.Unlock()
+	return dropped
+}
+
+// Notify returns channel which receives a value after Push, it's closed by Close.
+func (q *priorityQueue) Notify() <-chan struct{} {
+	return q.notify
+}
+
+// Len returns the number of queued packets.
+func (q *priorityQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.len
+}
+
+// Pop appends up to limit packets to batch until maxSize bytes, at least one packet is appended if the queue
+// isn't empty. It doesn't block.
+func (q *priorityQueue) Pop(batch []*vpn.Packet, limit, maxSize int) []*vpn.Packet {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	size := 0
+	for len(batch) < limit && q.len > 0 {
+		class := &q.classes[q.current]
+		if len(class.packets) > 0 && len(class.packets[0].Packet) <= class.deficit {
+			packet := class.packets[0]
+			if size > 0 && size+len(packet.Packet) > maxSize {
+				break
+			}
+			class.deficit -= len(packet.Packet)
+			size += len(packet.Packet)
+			batch = append(batch, packet)
+			class.packets[0] = nil
+			class.packets = class.packets[1:]
+			q.len--
+			q.counters[q.current].sent.Add(1)
+			if len(class.packets) == 0 {
+				// idle classes don't save their share for later
+				class.deficit = 0
+			}
+			continue
+		}
+
+		// the class has used its share, the next one is served from high to low
+		if q.current == vpn.PriorityLow {
+			q.current = vpn.PriorityHigh
+		} else {
+			q.current--
+		}
+		if next := &q.classes[q.current]; len(next.packets) > 0 {
+			next.deficit += priorityQueueWeights[q.current] * vpn.InterfaceMTU
+		}
+	}
+
+	return batch
+}
+
+// Drain drops queued packets, e.g. when the interface went down.
+func (q *priorityQueue) Drain(drop func(packet *vpn.Packet)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.dropLocked(drop)
+}
+
+// Close drops queued packets, Push returns pushed packet after it.
+func (q *priorityQueue) Close(drop func(packet *vpn.Packet)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.dropLocked(drop)
+	close(q.notify)
+}
+
+func (q *priorityQueue) dropLocked(drop func(packet *vpn.Packet)) {
+	for p := range q.classes {
+		for _, packet := range q.classes[p].packets {
+			drop(packet)
+		}
+		q.classes[p] = priorityClass{}
+	}
+	q.len = 0
+}
+
+// queuedByClass adds numbers of queued packets by priority to queued.
+func (q *priorityQueue) queuedByClass(queued *[vpn.PriorityClasses]int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for p := range q.classes {
+		queued[p] += len(q.classes[p].packets)
+	}
+}
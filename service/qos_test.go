@@ -0,0 +1,118 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/vpn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	a := require.New(t)
+	var counters qosCounters
+	queue := newPriorityQueue(10, &counters)
+	newPacket := func(size int) *vpn.Packet {
+		return &vpn.Packet{Packet: make([]byte, size)}
+	}
+
+	var normalPackets []*vpn.Packet
+	for i := 0; i < 8; i++ {
+		packet := newPacket(1000)
+		normalPackets = append(normalPackets, packet)
+		a.Nil(queue.Push(packet, vpn.PriorityNormal))
+	}
+	var highPackets []*vpn.Packet
+	for i := 0; i < 2; i++ {
+		packet := newPacket(100)
+		highPackets = append(highPackets, packet)
+		a.Nil(queue.Push(packet, vpn.PriorityHigh))
+	}
+	// the queue is full, packets of lower classes are dropped first
+	packet := newPacket(100)
+	highPackets = append(highPackets, packet)
+	a.Equal(normalPackets[7], queue.Push(packet, vpn.PriorityHigh))
+	packet = newPacket(1000)
+	a.Equal(packet, queue.Push(packet, vpn.PriorityLow))
+	a.Equal(10, queue.Len())
+
+	var queued [vpn.PriorityClasses]int
+	queue.queuedByClass(&queued)
+	a.Equal([vpn.PriorityClasses]int{0, 7, 3}, queued)
+
+	batch := queue.Pop(nil, 100, 1<<20)
+	a.Equal(append(highPackets, normalPackets[:7]...), batch)
+	a.Zero(queue.Len())
+	a.Empty(queue.Pop(nil, 100, 1<<20))
+	a.EqualValues(3, counters[vpn.PriorityHigh].sent.Load())
+	a.EqualValues(7, counters[vpn.PriorityNormal].sent.Load())
+	a.EqualValues(1, counters[vpn.PriorityNormal].dropped.Load())
+	a.EqualValues(1, counters[vpn.PriorityLow].dropped.Load())
+
+	// bulk classes aren't starved
+	queue = newPriorityQueue(100, &counters)
+	for i := 0; i < 20; i++ {
+		a.Nil(queue.Push(newPacket(vpn.InterfaceMTU), vpn.PriorityHigh))
+	}
+	normal := newPacket(vpn.InterfaceMTU)
+	a.Nil(queue.Push(normal, vpn.PriorityNormal))
+	batch = queue.Pop(nil, 100, 1<<20)
+	a.Len(batch, 21)
+	a.Equal(normal, batch[priorityQueueWeights[vpn.PriorityHigh]])
+
+	// batch is limited by count and size
+	for i := 0; i < 4; i++ {
+		a.Nil(queue.Push(newPacket(1000), vpn.PriorityNormal))
+	}
+	a.Len(queue.Pop(nil, 1, 1<<20), 1)
+	a.Len(queue.Pop(nil, 100, 1500), 1)
+	a.Equal(2, queue.Len())
+
+	var dropped int
+	queue.Close(func(*vpn.Packet) { dropped++ })
+	a.Equal(2, dropped)
+	for range queue.Notify() {
+	}
+	packet = newPacket(100)
+	a.Equal(packet, queue.Push(packet, vpn.PriorityHigh))
+}
+
+func TestQoSClassifier(t *testing.T) {
+	a := require.New(t)
+
+	rules, err := compileQoSRules([]config.QoSRule{
+		{Protocol: config.ACLProtocolUDP, Ports: "9000-9100", Priority: "low"},
+		{Ports: "22", Priority: "normal"},
+	})
+	a.NoError(err)
+	classifier := &qosClassifier{rules: rules}
+
+	udp := &vpn.Packet{Packet: []byte{
+		0x45, 0x00, 0x00, 0x20, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+		0x0a, 0x42, 0x00, 0x01, 0x0a, 0x42, 0x00, 0x02,
+		0xa9, 0xd0, 0x23, 0x82, 0x00, 0x0c, 0x00, 0x00,
+		0x74, 0x65, 0x73, 0x74,
+	}}
+	a.Equal(vpn.PriorityNormal, udp.DefaultPriority())
+	a.Equal(vpn.PriorityLow, classifier.classify(udp))
+	// rules override DSCP marks
+	udp.Packet[1] = 46 << 2
+	a.Equal(vpn.PriorityLow, classifier.classify(udp))
+	// ssh is high by default
+	udp.Packet[22], udp.Packet[23] = 0x00, 0x16
+	a.Equal(vpn.PriorityNormal, classifier.classify(udp))
+	udp.Packet[22], udp.Packet[23] = 0x23, 0xf0
+	a.Equal(vpn.PriorityHigh, classifier.classify(udp))
+	classifier.disabled = true
+	a.Equal(vpn.PriorityNormal, classifier.classify(udp))
+
+	for _, rule := range []config.QoSRule{
+		{Protocol: "icmp", Ports: "1", Priority: "low"},
+		{Ports: "1", Priority: "urgent"},
+		{Priority: "low"},
+		{Ports: "2-1", Priority: "low"},
+	} {
+		a.Error(ValidateQoSRules([]config.QoSRule{rule}), rule)
+	}
+	a.Error(ValidateQoSRules(make([]config.QoSRule, config.MaxQoSRules+1)))
+}
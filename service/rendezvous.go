@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/rendezvous"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	rendezvousInterval = time.Minute
+	// rendezvousMaxPeers limits peers found in one round, the rest are found in next rounds
+	rendezvousMaxPeers = 32
+)
+
+var errRendezvousDisabled = errors.New("rendezvous phrase is not set")
+
+// BackgroundRendezvous advertises us under the namespace of config.RendezvousConfig.Phrase and sends friend requests
+// to peers found there every rendezvousInterval, the phrase could be changed at any time.
+func (s *AuthStatus) BackgroundRendezvous(ctx context.Context) {
+	var advertisedNamespace string
+	var nextAdvertise time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.rendezvousRefresh:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+		timer.Reset(s.p2p.PowerSaveInterval(rendezvousInterval))
+
+		secret, err := s.rendezvousSecretFor(s.conf.RendezvousPhrase())
+		if errors.Is(err, errRendezvousDisabled) {
+			advertisedNamespace = ""
+			continue
+		} else if err != nil {
+			s.logger.Warnf("rendezvous: %v", err)
+			continue
+		}
+		if secret.Namespace != advertisedNamespace || time.Now().After(nextAdvertise) {
+			advertiseCtx, cancel := context.WithTimeout(ctx, s.conf.PeerRequestTimeout())
+			ttl, err := s.p2p.Advertise(advertiseCtx, secret.Namespace)
+			cancel()
+			if err != nil {
+				s.logger.Warnf("rendezvous: advertise: %v", err)
+			} else {
+				advertisedNamespace = secret.Namespace
+				nextAdvertise = time.Now().Add(ttl / 2)
+			}
+		}
+
+		_, err = s.DiscoverRendezvousPeers(ctx)
+		if err != nil {
+			s.logger.Warnf("rendezvous: find peers: %v", err)
+		}
+	}
+}
+
+// RefreshRendezvous advertises the phrase and finds peers right away, e.g. after the phrase is changed.
+func (s *AuthStatus) RefreshRendezvous() {
+	select {
+	case s.rendezvousRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// DiscoverRendezvousPeers finds peers which advertised the namespace of our phrase and sends friend requests
+// with proof of the phrase to new ones. It returns peers which were sent friend requests.
+func (s *AuthStatus) DiscoverRendezvousPeers(ctx context.Context) ([]peer.ID, error) {
+	secret, err := s.rendezvousSecretFor(s.conf.RendezvousPhrase())
+	if err != nil {
+		return nil, err
+	}
+	findCtx, cancel := context.WithTimeout(ctx, s.conf.PeerRequestTimeout())
+	found, err := s.p2p.FindPeers(findCtx, secret.Namespace, rendezvousMaxPeers)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	var requested []peer.ID
+	for _, info := range found {
+		if !s.isNewRendezvousPeer(info.ID) {
+			continue
+		}
+		s.logger.Infof("rendezvous: found peer %s, sending friend request", info.ID)
+		err = s.addPeer(ctx, info.ID, "", s.conf.GenUniqPeerAlias("", ""), "", false, protocol.AuthPeer{
+			Rendezvous: secret.Proof(s.p2p.PeerID(), info.ID),
+		})
+		if err != nil {
+			s.logger.Warnf("rendezvous: add peer %s: %v", info.ID, err)
+			continue
+		}
+		requested = append(requested, info.ID)
+	}
+	return requested, nil
+}
+
+// isNewRendezvousPeer skips known and blocked peers, so declined or removed peers aren't requested again.
+func (s *AuthStatus) isNewRendezvousPeer(peerID peer.ID) bool {
+	if _, known := s.conf.GetPeer(peerID.String()); known {
+		return false
+	}
+	if _, blocked := s.conf.GetBlockedPeer(peerID.String()); blocked {
+		return false
+	}
+	s.authsLock.RLock()
+	_, requested := s.outgoingAuths[peerID]
+	s.authsLock.RUnlock()
+	return !requested
+}
+
+// useRendezvousProof reports whether the friend request proves that the peer knows our phrase.
+func (s *AuthStatus) useRendezvousProof(peerID peer.ID, proof []byte) bool {
+	if len(proof) == 0 {
+		return false
+	}
+	secret, err := s.rendezvousSecretFor(s.conf.RendezvousPhrase())
+	if err != nil || !secret.Verify(peerID, s.p2p.PeerID(), proof) {
+		s.logger.Warnf("peer %s sent friend request with invalid rendezvous proof", peerID)
+		return false
+	}
+	s.logger.Infof("peer %s knows our rendezvous phrase", peerID)
+	return true
+}
+
+// rendezvousSecretFor derives the secret once for the phrase, since it's slow.
+func (s *AuthStatus) rendezvousSecretFor(phrase string) (rendezvous.Secret, error) {
+	if rendezvous.NormalizePhrase(phrase) == "" {
+		return rendezvous.Secret{}, errRendezvousDisabled
+	}
+	s.rendezvousLock.Lock()
+	defer s.rendezvousLock.Unlock()
+	if phrase == s.rendezvousPhrase {
+		return s.rendezvousSecret, nil
+	}
+	secret, err := rendezvous.Derive(phrase)
+	if err != nil {
+		return rendezvous.Secret{}, err
+	}
+	s.rendezvousPhrase, s.rendezvousSecret = phrase, secret
+	return secret, nil
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/awlevent"
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/vpn"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+const routingRefreshInterval = 10 * time.Second
+
+// Routing maintains system routes to our exit node and to subnets advertised by peers,
+// and NAT for peers which use us as exit node or reach our advertised subnets.
+// Packets are routed by Tunnel, see Tunnel.exitPeer and Tunnel.subnetRoutes.
+// Overlaps with local networks are reported as conflicts, see Conflicts.
+type Routing struct {
+	p2p             P2p
+	conf            *config.Config
+	device          *vpn.Device
+	logger          *log.ZapEventLogger
+	refreshCh       chan struct{}
+	conflictEmitter awlevent.Emitter
+
+	lastRoutesErr       string
+	lastSubnetRoutesErr string
+	lastNATErr          string
+
+	conflictsLock sync.Mutex
+	conflicts     []awlevent.SubnetConflict
+}
+
+func NewRouting(p2pService P2p, device *vpn.Device, conf *config.Config, bus awlevent.Bus) *Routing {
+	// stateful, so subscribers get current conflicts
+	conflictEmitter, err := bus.Emitter(new(awlevent.SubnetConflictsChanged), eventbus.Stateful)
+	if err != nil {
+		panic(err)
+	}
+	return &Routing{
+		p2p:             p2pService,
+		conf:            conf,
+		device:          device,
+		logger:          log.Logger("awl/service/routing"),
+		refreshCh:       make(chan struct{}, 1),
+		conflictEmitter: conflictEmitter,
+	}
+}
+
+// BackgroundMaintain updates routes and NAT periodically and on Refresh, they are removed when ctx is done.
+func (r *Routing) BackgroundMaintain(ctx context.Context) {
+	ticker := time.NewTicker(routingRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		r.refresh()
+		select {
+		case <-ctx.Done():
+			r.device.ClearExitRoutes()
+			r.device.ClearSubnetRoutes()
+			r.logError(&r.lastNATErr, "disable nat", r.device.SetNAT(false))
+			return
+		case <-ticker.C:
+		case <-r.refreshCh:
+		}
+	}
+}
+
+// Conflicts returns the vpn network and routes of peers which overlap local networks as of the last refresh.
+func (r *Routing) Conflicts() []awlevent.SubnetConflict {
+	r.conflictsLock.Lock()
+	defer r.conflictsLock.Unlock()
+	return append([]awlevent.SubnetConflict{}, r.conflicts...)
+}
+
+// Refresh schedules update of routes and NAT, e.g. after the exit node, permissions or routes were changed.
+func (r *Routing) Refresh() {
+	select {
+	case r.refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Routing) refresh() {
+	vpnNet := r.device.Network()
+	r.conf.RLock()
+	exitPeerID := r.conf.VPNConfig.ExitNodePeerID
+	natNeeded := len(r.conf.VPNConfig.AdvertisedRoutes) > 0
+	for _, knownPeer := range r.conf.KnownPeers {
+		natNeeded = natNeeded || knownPeer.ExitNodeAllowed(r.conf.PeerGroups)
+	}
+	peerRoutes := subnetRoutes(r.conf, vpnNet)
+	localNetworks := r.localNetworks()
+	conflicts := subnetConflicts(vpnNet, peerRoutes, localNetworks)
+	if len(conflicts) > 0 && conflicts[0].PeerID == "" {
+		conflicts[0].Resolution = r.vpnNetworkResolution(localNetworks)
+	}
+	r.conf.RUnlock()
+	r.device.SetLocalNetworks(localNetworkList(localNetworks))
+	if r.device.IsPaused() {
+		// routes and NAT are removed while the interface is paused, they are set again on resume
+		r.updateConflicts(conflicts)
+		return
+	}
+
+	r.logError(&r.lastNATErr, "set nat", r.device.SetNAT(natNeeded))
+	r.logError(&r.lastSubnetRoutesErr, "set subnet routes", r.device.SetSubnetRoutes(systemSubnetRoutes(peerRoutes, localNetworks)))
+	r.updateConflicts(conflicts)
+
+	// routes are removed while the exit node is offline, otherwise we can't reach it to reconnect
+	var bypass []net.IP
+	if exitPeer, known := r.conf.GetPeer(exitPeerID); known && exitPeer.AllowedUsingAsExitNode && r.p2p.IsConnected(exitPeer.PeerId()) {
+		bypass = r.p2p.PeerRemoteIPs(exitPeer.PeerId())
+	}
+	if len(bypass) == 0 {
+		r.device.ClearExitRoutes()
+		r.lastRoutesErr = ""
+		return
+	}
+	r.logError(&r.lastRoutesErr, "set exit node routes", r.device.SetExitRoutes(bypass))
+}
+
+// updateConflicts stores conflicts, they are logged and emitted only when changed, as refresh is called periodically.
+func (r *Routing) updateConflicts(conflicts []awlevent.SubnetConflict) {
+	r.conflictsLock.Lock()
+	changed := !reflect.DeepEqual(r.conflicts, conflicts)
+	r.conflicts = conflicts
+	r.conflictsLock.Unlock()
+	if !changed {
+		return
+	}
+
+	for _, conflict := range conflicts {
+		if conflict.PeerID == "" {
+			r.logger.Warnf("vpn network %s overlaps local network %s of %s: %s",
+				conflict.Network, conflict.LocalNetwork, conflict.LocalInterface, conflict.Resolution)
+		} else {
+			r.logger.Warnf("route %s of peer %s overlaps local network %s of %s: %s",
+				conflict.Network, conflict.PeerID, conflict.LocalNetwork, conflict.LocalInterface, conflict.Resolution)
+		}
+	}
+	_ = r.conflictEmitter.Emit(awlevent.SubnetConflictsChanged{Conflicts: conflicts})
+}
+
+// vpnNetworkResolution suggests free network for the vpn network. Config should be locked.
+func (r *Routing) vpnNetworkResolution(localNetworks []vpn.LocalNetwork) string {
+	free := r.conf.FreeVPNNetwork(localNetworkList(localNetworks))
+	switch {
+	case r.conf.VPNConfig.AutoRenumber:
+		return "restart the app to move the vpn network to a free one"
+	case free != nil:
+		return fmt.Sprintf("change vpn network in config to %s or enable auto renumber and restart the app", free)
+	default:
+		return "change vpn network in config and restart the app"
+	}
+}
+
+func (r *Routing) localNetworks() []vpn.LocalNetwork {
+	ifname, _ := r.device.InterfaceName()
+	networks, err := vpn.LocalNetworks(ifname)
+	if err != nil {
+		r.logger.Warnf("get interfaces: %v", err)
+	}
+	return networks
+}
+
+// logError logs err only if it differs from the last one, as refresh is called periodically.
+func (r *Routing) logError(last *string, msg string, err error) {
+	var errStr string
+	if err != nil {
+		errStr = err.Error()
+	}
+	if errStr == *last {
+		return
+	}
+	*last = errStr
+	switch {
+	case err == nil:
+	case errors.Is(err, vpn.ErrExitNodeUnsupported), errors.Is(err, vpn.ErrSubnetRoutesUnsupported):
+		r.logger.Warnf("%s: %v", msg, err)
+	default:
+		r.logger.Errorf("%s: %v", msg, err)
+	}
+}
+
+// systemSubnetRoutes returns routes of peers sorted by network, except ones which overlap networks of our interfaces,
+// e.g. when we are in the same LAN as the peer.
+func systemSubnetRoutes(peerRoutes map[string][]*net.IPNet, localNetworks []vpn.LocalNetwork) []*net.IPNet {
+	local := localNetworkList(localNetworks)
+	var result []*net.IPNet
+	for _, networks := range peerRoutes {
+		for _, network := range networks {
+			if !overlapsAny(local, network) {
+				result = append(result, network)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].String() < result[j].String()
+	})
+	return result
+}
+
+// subnetConflicts returns the vpn network first and then routes of peers sorted by peer id and network
+// which overlap local networks. Traffic to the overlapped vpn network partially goes to the local network,
+// while routes of peers are not used at all, see systemSubnetRoutes.
+func subnetConflicts(vpnNet *net.IPNet, peerRoutes map[string][]*net.IPNet, localNetworks []vpn.LocalNetwork) []awlevent.SubnetConflict {
+	var result []awlevent.SubnetConflict
+	for _, local := range localNetworks {
+		if vpn.NetworksOverlap(vpnNet, local.Network) {
+			result = append(result, awlevent.SubnetConflict{
+				Network:        vpnNet.String(),
+				LocalNetwork:   local.Network.String(),
+				LocalInterface: local.Interface,
+				Resolution:     "change vpn network in config and restart the app",
+			})
+			break
+		}
+	}
+
+	peerIDs := make([]string, 0, len(peerRoutes))
+	for peerID := range peerRoutes {
+		peerIDs = append(peerIDs, peerID)
+	}
+	sort.Strings(peerIDs)
+	for _, peerID := range peerIDs {
+		for _, network := range peerRoutes[peerID] {
+			for _, local := range localNetworks {
+				if !vpn.NetworksOverlap(network, local.Network) {
+					continue
+				}
+				result = append(result, awlevent.SubnetConflict{
+					Network:        network.String(),
+					PeerID:         peerID,
+					LocalNetwork:   local.Network.String(),
+					LocalInterface: local.Interface,
+					Resolution:     "route is not used while the local network is connected, the peer should advertise another network",
+				})
+				break
+			}
+		}
+	}
+	return result
+}
+
+func localNetworkList(localNetworks []vpn.LocalNetwork) []*net.IPNet {
+	result := make([]*net.IPNet, 0, len(localNetworks))
+	for _, local := range localNetworks {
+		result = append(result, local.Network)
+	}
+	return result
+}
+
+// subnetRoutes returns networks advertised by known peers which routes we accept by peer id, see KnownPeer.AcceptRoutes.
+// Networks which overlap the VPN network, our advertised routes or routes of other peers are skipped,
+// so a peer can't take over the subnet of another one with a wider or narrower network. Config should be locked.
+func subnetRoutes(conf *config.Config, vpnNet *net.IPNet) map[string][]*net.IPNet {
+	reserved := append(parseNetworks(conf.VPNConfig.AdvertisedRoutes), vpnNet)
+	candidates := make(map[string][]*net.IPNet)
+	for peerID, knownPeer := range conf.KnownPeers {
+		if !knownPeer.AcceptRoutes {
+			continue
+		}
+		var networks []*net.IPNet
+		for _, network := range parseNetworks(knownPeer.SubnetRoutes) {
+			if !knownPeer.AcceptAllRoutes && !vpn.IsPrivateSubnetRoute(network) {
+				continue
+			}
+			if overlapsAny(reserved, network) || overlapsAny(networks, network) {
+				continue
+			}
+			networks = append(networks, network)
+		}
+		if len(networks) > 0 {
+			candidates[peerID] = networks
+		}
+	}
+
+	result := make(map[string][]*net.IPNet, len(candidates))
+	for peerID, networks := range candidates {
+		for _, network := range networks {
+			if !overlapsOtherPeers(candidates, peerID, network) {
+				result[peerID] = append(result[peerID], network)
+			}
+		}
+	}
+	return result
+}
+
+func overlapsOtherPeers(peerRoutes map[string][]*net.IPNet, peerID string, network *net.IPNet) bool {
+	for otherID, networks := range peerRoutes {
+		if otherID != peerID && overlapsAny(networks, network) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(routes []string) []*net.IPNet {
+	result := make([]*net.IPNet, 0, len(routes))
+	for _, route := range routes {
+		_, network, err := net.ParseCIDR(route)
+		if err == nil {
+			result = append(result, network)
+		}
+	}
+	return result
+}
+
+func overlapsAny(networks []*net.IPNet, network *net.IPNet) bool {
+	for _, n := range networks {
+		if vpn.NetworksOverlap(n, network) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/anywherelan/awl/vpn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubnetConflicts(t *testing.T) {
+	a := require.New(t)
+	parse := func(cidr string) *net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		a.NoError(err)
+		return network
+	}
+	localNetworks := []vpn.LocalNetwork{
+		{Interface: "eth0", Network: parse("192.168.1.0/24")},
+		{Interface: "wlan0", Network: parse("10.66.0.0/16")},
+	}
+	peerRoutes := map[string][]*net.IPNet{
+		"peer2": {parse("192.168.0.0/16"), parse("172.16.0.0/24")},
+		"peer1": {parse("192.168.1.128/25")},
+	}
+
+	conflicts := subnetConflicts(parse("10.66.0.0/24"), peerRoutes, localNetworks)
+	a.Len(conflicts, 3)
+	a.Equal("10.66.0.0/24", conflicts[0].Network)
+	a.Empty(conflicts[0].PeerID)
+	a.Equal("10.66.0.0/16", conflicts[0].LocalNetwork)
+	a.Equal("wlan0", conflicts[0].LocalInterface)
+	a.Equal("peer1", conflicts[1].PeerID)
+	a.Equal("192.168.1.128/25", conflicts[1].Network)
+	a.Equal("peer2", conflicts[2].PeerID)
+	a.Equal("192.168.0.0/16", conflicts[2].Network)
+	a.Equal("eth0", conflicts[2].LocalInterface)
+
+	a.Equal([]*net.IPNet{parse("172.16.0.0/24")}, systemSubnetRoutes(peerRoutes, localNetworks))
+
+	a.Empty(subnetConflicts(parse("10.67.0.0/24"), nil, localNetworks))
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// ScheduledJobPrewarm is how long before the job connection with the peer is established
+	ScheduledJobPrewarm = 5 * time.Minute
+
+	scheduledJobCheckInterval  = 30 * time.Second
+	scheduledJobProbeInterval  = 10 * time.Second
+	scheduledJobConnectTimeout = 20 * time.Second
+	maxScheduledJobReports     = 100
+)
+
+// ScheduledJobReport describes connection with the peer during the job window, which starts ScheduledJobPrewarm
+// before the job and lasts config.ScheduledJob.DurationMinutes after it.
+type ScheduledJobReport struct {
+	Job         string
+	PeerID      string
+	ScheduledAt time.Time
+	WindowStart time.Time
+	// WindowEnd is zero while the window is in progress
+	WindowEnd time.Time
+	// Probes is the number of connection checks during the window
+	Probes          int
+	ConnectedProbes int
+	DirectProbes    int
+	// Healthy is true if there was a direct connection with the peer on every check
+	Healthy bool
+	// Error of the job action, e.g. failed backup
+	Error string
+}
+
+// Scheduler pins connections with peers during windows of scheduled jobs, so the jobs don't wait for the connection
+// and don't run over relays because hole punching hasn't finished yet. See config.ScheduledJob.
+type Scheduler struct {
+	p2p       P2p
+	conf      *config.Config
+	backup    *Backup
+	speedTest *SpeedTest
+	logger    *log.ZapEventLogger
+
+	refresh chan struct{}
+
+	lock    sync.Mutex
+	running map[string]time.Time
+	reports []*ScheduledJobReport
+}
+
+func NewScheduler(p2pService P2p, conf *config.Config, backup *Backup, speedTest *SpeedTest) *Scheduler {
+	return &Scheduler{
+		p2p:       p2pService,
+		conf:      conf,
+		backup:    backup,
+		speedTest: speedTest,
+		logger:    log.Logger("awl/service/scheduler"),
+		refresh:   make(chan struct{}, 1),
+		running:   make(map[string]time.Time),
+	}
+}
+
+// ValidateScheduledJobs returns error if any of the jobs is invalid. Peers aren't checked.
+func ValidateScheduledJobs(jobs []config.ScheduledJob) error {
+	names := make(map[string]struct{}, len(jobs))
+	for _, job := range jobs {
+		if job.Name == "" {
+			return errors.New("job name should not be empty")
+		}
+		if _, exists := names[job.Name]; exists {
+			return fmt.Errorf("job name %s is not unique", job.Name)
+		}
+		names[job.Name] = struct{}{}
+		if _, err := peer.Decode(job.PeerID); err != nil {
+			return fmt.Errorf("job %s: invalid peer id: %v", job.Name, err)
+		}
+		if _, err := time.Parse(config.ScheduledJobTimeLayout, job.Time); err != nil {
+			return fmt.Errorf("job %s: time should be in format hh:mm", job.Name)
+		}
+		if job.Action != "" && job.Action != config.ScheduledJobActionBackup && job.Action != config.ScheduledJobActionSpeedTest {
+			return fmt.Errorf("job %s: unknown action %s", job.Name, job.Action)
+		}
+		if job.DurationMinutes < 0 || job.DurationMinutes > 24*60-int(ScheduledJobPrewarm/time.Minute) {
+			return fmt.Errorf("job %s: invalid duration %d minutes", job.Name, job.DurationMinutes)
+		}
+	}
+	return nil
+}
+
+// Refresh makes scheduler check jobs immediately, it should be called after jobs are changed in config.
+func (s *Scheduler) Refresh() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Reports returns reports of the recent windows, the newest first.
+func (s *Scheduler) Reports() []ScheduledJobReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reports := make([]ScheduledJobReport, 0, len(s.reports))
+	for i := len(s.reports) - 1; i >= 0; i-- {
+		reports = append(reports, *s.reports[i])
+	}
+	return reports
+}
+
+func (s *Scheduler) BackgroundRun(ctx context.Context) {
+	ticker := time.NewTicker(scheduledJobCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.startJobs(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.refresh:
+		}
+	}
+}
+
+func (s *Scheduler) startJobs(ctx context.Context, now time.Time) {
+	s.conf.RLock()
+	jobs := append([]config.ScheduledJob(nil), s.conf.ScheduledJobs...)
+	s.conf.RUnlock()
+
+	for _, job := range jobs {
+		scheduledAt, active := scheduledJobWindow(job, now)
+		if !active {
+			continue
+		}
+		s.lock.Lock()
+		_, running := s.running[job.Name]
+		started := running || s.hasReport(job.Name, scheduledAt)
+		if !started {
+			s.running[job.Name] = scheduledAt
+		}
+		s.lock.Unlock()
+		if started {
+			continue
+		}
+
+		go s.runJob(ctx, job, scheduledAt)
+	}
+}
+
+func (s *Scheduler) hasReport(jobName string, scheduledAt time.Time) bool {
+	for _, report := range s.reports {
+		if report.Job == jobName && report.ScheduledAt.Equal(scheduledAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job config.ScheduledJob, scheduledAt time.Time) {
+	report := &ScheduledJobReport{
+		Job:         job.Name,
+		PeerID:      job.PeerID,
+		ScheduledAt: scheduledAt,
+		WindowStart: time.Now(),
+	}
+	s.lock.Lock()
+	s.reports = append(s.reports, report)
+	if len(s.reports) > maxScheduledJobReports {
+		s.reports = s.reports[len(s.reports)-maxScheduledJobReports:]
+	}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.running, job.Name)
+		s.lock.Unlock()
+	}()
+
+	peerID, err := peer.Decode(job.PeerID)
+	if err != nil {
+		s.finishJob(report, err)
+		return
+	}
+	s.logger.Infof("pre-warming connection with %s for job %s at %s", job.PeerID, job.Name, scheduledAt.Format(config.ScheduledJobTimeLayout))
+	s.p2p.PinPeer(peerID)
+	defer s.p2p.UnpinPeer(peerID)
+
+	windowEnd := scheduledAt.Add(time.Duration(job.DurationMinutes) * time.Minute)
+	actionDone := false
+	var actionErr error
+	for {
+		s.probe(ctx, peerID, report)
+		if !actionDone && !time.Now().Before(scheduledAt) {
+			actionDone = true
+			actionErr = s.runAction(ctx, job, peerID)
+		}
+		if actionDone && !time.Now().Before(windowEnd) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			s.finishJob(report, ctx.Err())
+			return
+		case <-time.After(scheduledJobProbeInterval):
+		}
+	}
+	s.finishJob(report, actionErr)
+}
+
+func (s *Scheduler) probe(ctx context.Context, peerID peer.ID, report *ScheduledJobReport) {
+	if !s.p2p.IsConnected(peerID) {
+		ctx, cancel := context.WithTimeout(ctx, scheduledJobConnectTimeout)
+		err := s.p2p.ConnectPeer(ctx, peerID)
+		cancel()
+		if err != nil {
+			s.logger.Debugf("connect to %s for scheduled job: %v", peerID, err)
+		}
+	}
+
+	connected := s.p2p.IsConnected(peerID)
+	direct := false
+	for _, conn := range s.p2p.PeerConnectionsInfo(peerID) {
+		if !conn.ThroughRelay {
+			direct = true
+			break
+		}
+	}
+
+	s.lock.Lock()
+	report.Probes++
+	if connected {
+		report.ConnectedProbes++
+	}
+	if direct {
+		report.DirectProbes++
+	}
+	s.lock.Unlock()
+}
+
+func (s *Scheduler) runAction(ctx context.Context, job config.ScheduledJob, peerID peer.ID) error {
+	switch job.Action {
+	case config.ScheduledJobActionBackup:
+		return s.backup.BackupOnPeer(ctx, peerID)
+	case config.ScheduledJobActionSpeedTest:
+		_, err := s.speedTest.Run(ctx, peerID, 0)
+		return err
+	default:
+		return nil
+	}
+}
+
+func (s *Scheduler) finishJob(report *ScheduledJobReport, err error) {
+	s.lock.Lock()
+	report.WindowEnd = time.Now()
+	report.Healthy = report.Probes > 0 && report.DirectProbes == report.Probes
+	if err != nil {
+		report.Error = err.Error()
+	}
+	finished := *report
+	s.lock.Unlock()
+
+	switch {
+	case err != nil:
+		s.logger.Warnf("scheduled job %s with %s failed: %v", finished.Job, finished.PeerID, err)
+	case !finished.Healthy:
+		s.logger.Warnf("scheduled job %s with %s had no direct connection on %d of %d checks",
+			finished.Job, finished.PeerID, finished.Probes-finished.DirectProbes, finished.Probes)
+	default:
+		s.logger.Infof("scheduled job %s with %s finished, connection was direct", finished.Job, finished.PeerID)
+	}
+}
+
+// scheduledJobWindow returns the nearest time of the job and whether now is inside its window.
+// Windows could cross midnight, so yesterday's time is checked as well.
+func scheduledJobWindow(job config.ScheduledJob, now time.Time) (time.Time, bool) {
+	jobTime, err := time.Parse(config.ScheduledJobTimeLayout, job.Time)
+	if err != nil {
+		return time.Time{}, false
+	}
+	duration := time.Duration(job.DurationMinutes) * time.Minute
+	today := time.Date(now.Year(), now.Month(), now.Day(), jobTime.Hour(), jobTime.Minute(), 0, 0, now.Location())
+	for _, scheduledAt := range []time.Time{today.AddDate(0, 0, -1), today, today.AddDate(0, 0, 1)} {
+		if !now.Before(scheduledAt.Add(-ScheduledJobPrewarm)) && !now.After(scheduledAt.Add(duration)) {
+			return scheduledAt, true
+		}
+	}
+	return today, false
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// shaperBurstDuration is the share of the rate which could be sent at once after idle time
+	shaperBurstDuration = 100 * time.Millisecond
+	// shaperMinBurst fits a few full packets, so low limits don't split them into many waits
+	shaperMinBurst = 16 << 10
+)
+
+// ShaperStats are total delays of traffic by bandwidth limits since the start.
+type ShaperStats struct {
+	UploadDelay   time.Duration `swaggertype:"primitive,integer"`
+	DownloadDelay time.Duration `swaggertype:"primitive,integer"`
+}
+
+// Shaper limits bandwidth of VPN and SOCKS5 traffic with peers by token buckets: each peer has its own limits
+// from config.KnownPeer.BandwidthLimit and all peers share config.Config.BandwidthLimit. Traffic over the limit
+// is delayed, so queues of the peer fill up and its packets are dropped, while other peers aren't affected
+// until the global limit is reached.
+type Shaper struct {
+	conf      *config.Config
+	global    *shaperBuckets
+	peersLock sync.RWMutex
+	peers     map[peer.ID]*shaperBuckets
+
+	uploadDelay   atomic.Int64
+	downloadDelay atomic.Int64
+}
+
+// shaperBuckets are replaced when limits are changed, nil bucket is unlimited.
+type shaperBuckets struct {
+	upload   atomic.Pointer[rate.Limiter]
+	download atomic.Pointer[rate.Limiter]
+}
+
+func NewShaper(conf *config.Config) *Shaper {
+	shaper := &Shaper{
+		conf:   conf,
+		global: new(shaperBuckets),
+		peers:  make(map[peer.ID]*shaperBuckets),
+	}
+	shaper.Refresh()
+
+	return shaper
+}
+
+// Refresh applies bandwidth limits from config, limits are changed without interrupting traffic.
+func (s *Shaper) Refresh() {
+	s.conf.RLock()
+	global := s.conf.BandwidthLimit
+	limits := make(map[peer.ID]config.BandwidthLimitConfig)
+	for _, knownPeer := range s.conf.KnownPeers {
+		if knownPeer.BandwidthLimit != (config.BandwidthLimitConfig{}) {
+			limits[knownPeer.PeerId()] = knownPeer.BandwidthLimit
+		}
+	}
+	s.conf.RUnlock()
+
+	s.global.set(global)
+	s.peersLock.Lock()
+	defer s.peersLock.Unlock()
+	for peerID := range s.peers {
+		if _, limited := limits[peerID]; !limited {
+			delete(s.peers, peerID)
+		}
+	}
+	for peerID, limit := range limits {
+		buckets, exists := s.peers[peerID]
+		if !exists {
+			buckets = new(shaperBuckets)
+			s.peers[peerID] = buckets
+		}
+		buckets.set(limit)
+	}
+}
+
+// Stats returns total delays of traffic by bandwidth limits.
+func (s *Shaper) Stats() ShaperStats {
+	return ShaperStats{
+		UploadDelay:   time.Duration(s.uploadDelay.Load()),
+		DownloadDelay: time.Duration(s.downloadDelay.Load()),
+	}
+}
+
+// WaitUpload waits until n bytes could be sent to the peer.
+func (s *Shaper) WaitUpload(ctx context.Context, peerID peer.ID, n int) error {
+	return s.wait(ctx, peerID, n, true)
+}
+
+// WaitDownload waits until n bytes received from the peer could be processed. Waiting delays reads of the stream,
+// so the peer slows down by flow control of the stream.
+func (s *Shaper) WaitDownload(ctx context.Context, peerID peer.ID, n int) error {
+	return s.wait(ctx, peerID, n, false)
+}
+
+// Stream limits bandwidth of the stream with the peer, e.g. of SOCKS5 connection.
+func (s *Shaper) Stream(stream network.Stream) network.Stream {
+	return &shapedStream{
+		Stream: stream,
+		shaper: s,
+		peerID: stream.Conn().RemotePeer(),
+	}
+}
+
+func (s *Shaper) wait(ctx context.Context, peerID peer.ID, n int, upload bool) error {
+	s.peersLock.RLock()
+	peerBuckets := s.peers[peerID]
+	s.peersLock.RUnlock()
+	var peerBucket *rate.Limiter
+	if peerBuckets != nil {
+		peerBucket = peerBuckets.get(upload)
+	}
+	globalBucket := s.global.get(upload)
+	if peerBucket == nil && globalBucket == nil {
+		return nil
+	}
+
+	started := time.Now()
+	err := waitBucket(ctx, peerBucket, n)
+	if err == nil {
+		err = waitBucket(ctx, globalBucket, n)
+	}
+	delay := int64(time.Since(started))
+	if upload {
+		s.uploadDelay.Add(delay)
+	} else {
+		s.downloadDelay.Add(delay)
+	}
+	return err
+}
+
+func (b *shaperBuckets) get(upload bool) *rate.Limiter {
+	if upload {
+		return b.upload.Load()
+	}
+	return b.download.Load()
+}
+
+func (b *shaperBuckets) set(limit config.BandwidthLimitConfig) {
+	setBucket(&b.upload, limit.UploadKbps)
+	setBucket(&b.download, limit.DownloadKbps)
+}
+
+// setBucket replaces the bucket if the rate is changed, tokens of the current bucket are kept otherwise.
+func setBucket(bucket *atomic.Pointer[rate.Limiter], kbps int) {
+	if kbps <= 0 {
+		bucket.Store(nil)
+		return
+	}
+	bytesPerSec := rate.Limit(kbps) * 1000 / 8
+	if current := bucket.Load(); current != nil && current.Limit() == bytesPerSec {
+		return
+	}
+	burst := max(int(float64(bytesPerSec)*shaperBurstDuration.Seconds()), shaperMinBurst)
+	bucket.Store(rate.NewLimiter(bytesPerSec, burst))
+}
+
+// waitBucket takes n tokens in chunks of the burst, nil bucket is unlimited.
+func waitBucket(ctx context.Context, bucket *rate.Limiter, n int) error {
+	if bucket == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, bucket.Burst())
+		err := bucket.WaitN(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+type shapedStream struct {
+	network.Stream
+	shaper *Shaper
+	peerID peer.ID
+}
+
+func (s *shapedStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 {
+		waitErr := s.shaper.WaitDownload(context.Background(), s.peerID, n)
+		if err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (s *shapedStream) Write(p []byte) (int, error) {
+	err := s.shaper.WaitUpload(context.Background(), s.peerID, len(p))
+	if err != nil {
+		return 0, err
+	}
+	return s.Stream.Write(p)
+}
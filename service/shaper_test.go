@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShaper(t *testing.T) {
+	a := require.New(t)
+	const peerIDStr = "12D3KooWJ7ZqTmmmhZ6hLFw4BHPs2PzBv6Vx1EvWAYdPLY4V3Dp9"
+	peerID, err := peer.Decode(peerIDStr)
+	a.NoError(err)
+	otherPeerID, err := peer.Decode("12D3KooWNWa2r6dJVogbjNf1CKrKNttVAhKZr1PpWRPJYX7o4t4M")
+	a.NoError(err)
+	conf := &config.Config{
+		KnownPeers: map[string]config.KnownPeer{
+			// 100 KB/s upload, burst is shaperMinBurst
+			peerIDStr: {PeerID: peerIDStr, BandwidthLimit: config.BandwidthLimitConfig{UploadKbps: 800}},
+		},
+	}
+	shaper := NewShaper(conf)
+	ctx := context.Background()
+
+	measure := func(peerID peer.ID, upload bool, n int) time.Duration {
+		started := time.Now()
+		if upload {
+			a.NoError(shaper.WaitUpload(ctx, peerID, n))
+		} else {
+			a.NoError(shaper.WaitDownload(ctx, peerID, n))
+		}
+		return time.Since(started)
+	}
+
+	// the burst is sent at once, the rest is delayed
+	a.Less(measure(peerID, true, shaperMinBurst), 20*time.Millisecond)
+	a.InDelta(300*time.Millisecond, measure(peerID, true, 30_000), float64(100*time.Millisecond))
+	a.Less(measure(peerID, false, 100_000), 20*time.Millisecond)
+	a.Less(measure(otherPeerID, true, 100_000), 20*time.Millisecond)
+	a.GreaterOrEqual(shaper.Stats().UploadDelay, 200*time.Millisecond)
+	a.Zero(shaper.Stats().DownloadDelay)
+
+	// the global limit is shared by all peers, removed limit of the peer is applied without delay
+	conf.BandwidthLimit = config.BandwidthLimitConfig{DownloadKbps: 800}
+	conf.KnownPeers[peerIDStr] = config.KnownPeer{PeerID: peerIDStr}
+	shaper.Refresh()
+	a.Less(measure(peerID, true, 100_000), 20*time.Millisecond)
+	a.Less(measure(peerID, false, shaperMinBurst), 20*time.Millisecond)
+	a.InDelta(200*time.Millisecond, measure(otherPeerID, false, 20_000), float64(100*time.Millisecond))
+}
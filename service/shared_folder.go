@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	sharedFolderReadHeaderTimeout = 30 * time.Second
+	sharedFolderIdleTimeout       = 2 * time.Minute
+	sharedFolderMaxIdleConns      = 4
+)
+
+type sharedFolderPeerKey struct{}
+
+// SharedFolder serves our shared folder to friends over WebDAV and proxies local WebDAV clients to friends' folders.
+// HTTP connections are tunneled in p2p streams, so there is nothing to configure besides the folder and peer access.
+type SharedFolder struct {
+	p2p      P2p
+	conf     *config.Config
+	logger   *log.ZapEventLogger
+	locks    webdav.LockSystem
+	listener *streamListener
+	server   *http.Server
+	proxy    *httputil.ReverseProxy
+}
+
+func NewSharedFolder(p2pService P2p, conf *config.Config) *SharedFolder {
+	s := &SharedFolder{
+		p2p:      p2pService,
+		conf:     conf,
+		logger:   log.Logger("awl/service/shared_folder"),
+		locks:    webdav.NewMemLS(),
+		listener: newStreamListener(),
+	}
+	s.server = &http.Server{
+		Handler:           http.HandlerFunc(s.serveFolder),
+		ReadHeaderTimeout: sharedFolderReadHeaderTimeout,
+		IdleTimeout:       sharedFolderIdleTimeout,
+	}
+	s.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			peerID := req.Context().Value(sharedFolderPeerKey{}).(peer.ID)
+			// Host header is kept, as WebDAV server checks that Destination of COPY and MOVE is on the same host
+			req.URL.Scheme = "http"
+			req.URL.Host = peerID.String()
+		},
+		Transport: &http.Transport{
+			DialContext:         s.dialPeer,
+			MaxIdleConnsPerHost: sharedFolderMaxIdleConns,
+			IdleConnTimeout:     sharedFolderIdleTimeout,
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			s.logger.Warnf("proxy %s %s: %v", req.Method, req.URL.Path, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	go func() {
+		err := s.server.Serve(s.listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf("serve shared folder: %v", err)
+		}
+	}()
+
+	return s
+}
+
+func (s *SharedFolder) StreamHandler(stream network.Stream) {
+	// folder access is checked for each request, so known peers get http errors instead of closed streams
+	if !s.listener.push(stream) {
+		_ = stream.Reset()
+	}
+}
+
+// ProxyToPeer forwards local WebDAV request to the peer's shared folder.
+// Request path should start with protocol.SharedFolderPathPrefix and the peer id.
+func (s *SharedFolder) ProxyToPeer(w http.ResponseWriter, r *http.Request, peerID peer.ID) {
+	ctx := context.WithValue(r.Context(), sharedFolderPeerKey{}, peerID)
+	s.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (s *SharedFolder) Close() {
+	_ = s.server.Close()
+	s.proxy.Transport.(*http.Transport).CloseIdleConnections()
+}
+
+func (s *SharedFolder) serveFolder(w http.ResponseWriter, r *http.Request) {
+	// remote address of stream connection is the peer id
+	peerID := r.RemoteAddr
+	s.conf.RLock()
+	folderPath := s.conf.SharedFolder.Path
+	ownPeerID := s.conf.P2pNode.PeerID
+	s.conf.RUnlock()
+	knownPeer, known := s.conf.GetPeer(peerID)
+	if folderPath == "" || !known {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	switch knownPeer.SharedFolderAccess {
+	case config.SharedFolderAccessWrite:
+	case config.SharedFolderAccessRead:
+		if !isReadOnlyWebDAVMethod(r.Method) {
+			http.Error(w, "read only access", http.StatusForbidden)
+			return
+		}
+	default:
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     protocol.SharedFolderPathPrefix + ownPeerID,
+		FileSystem: webdav.Dir(folderPath),
+		LockSystem: s.locks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				s.logger.Debugf("%s %s from %s: %v", r.Method, r.URL.Path, knownPeer.DisplayName(), err)
+			}
+		},
+	}
+	handler.ServeHTTP(w, r)
+}
+
+func (s *SharedFolder) dialPeer(ctx context.Context, _, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	peerID, err := peer.Decode(host)
+	if err != nil {
+		return nil, err
+	}
+	err = s.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.p2p.NewStream(ctx, peerID, protocol.SharedFolderMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamConn{stream}, nil
+}
+
+func isReadOnlyWebDAVMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	default:
+		return false
+	}
+}
+
+// streamConn is net.Conn over p2p stream, addresses are peer ids.
+type streamConn struct {
+	network.Stream
+}
+
+func (c streamConn) LocalAddr() net.Addr {
+	return peerAddr(c.Conn().LocalPeer())
+}
+
+func (c streamConn) RemoteAddr() net.Addr {
+	return peerAddr(c.Conn().RemotePeer())
+}
+
+type peerAddr peer.ID
+
+func (a peerAddr) Network() string { return "libp2p" }
+func (a peerAddr) String() string  { return peer.ID(a).String() }
+
+// streamListener is net.Listener which accepts streams passed to push.
+type streamListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newStreamListener() *streamListener {
+	return &streamListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// push returns false if the listener is closed.
+func (l *streamListener) push(stream network.Stream) bool {
+	select {
+	case l.conns <- streamConn{stream}:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *streamListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *streamListener) Addr() net.Addr {
+	return peerAddr("")
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	GroupMembershipStreamTimeout = 10 * time.Second
+
+	sharedGroupsSyncInterval = 5 * time.Minute
+)
+
+// SharedGroups publishes signed membership of our shared groups to their members and applies membership
+// of groups owned by other peers, so the group is managed by its owner and policies of the group follow it.
+type SharedGroups struct {
+	p2p    P2p
+	conf   *config.Config
+	logger *log.ZapEventLogger
+}
+
+func NewSharedGroups(p2pService P2p, conf *config.Config) *SharedGroups {
+	return &SharedGroups{
+		p2p:    p2pService,
+		conf:   conf,
+		logger: log.Logger("awl/service/groups"),
+	}
+}
+
+func (s *SharedGroups) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	peerID := stream.Conn().RemotePeer().String()
+	knownPeer, _ := s.conf.GetPeer(peerID)
+	request, err := protocol.ReceiveGroupMembershipRequest(stream)
+	if err != nil {
+		s.logger.Warnf("receive group membership request from %s: %v", knownPeer.DisplayName(), err)
+		return
+	}
+
+	response := protocol.GroupMembershipResponse{}
+	response.Membership, err = s.membership(request.Group, peerID)
+	if err != nil {
+		s.logger.Infof("Peer %s requested membership of group %q: %v", knownPeer.DisplayName(), request.Group, err)
+		response.Error = err.Error()
+	}
+
+	err = protocol.SendGroupMembershipResponse(stream, response)
+	if err != nil {
+		s.logger.Warnf("send group membership response to %s: %v", knownPeer.DisplayName(), err)
+	}
+}
+
+// membership signs members of our shared group for the member of the group.
+func (s *SharedGroups) membership(name, peerID string) (protocol.GroupMembership, error) {
+	group, exists := s.conf.GetPeerGroup(name)
+	members := s.conf.GroupMembers(name)
+	// the same error for unknown and foreign groups, so the names of groups aren't disclosed
+	if !exists || !group.Shared || !slices.Contains(members, peerID) {
+		return protocol.GroupMembership{}, errors.New("group is not shared with you")
+	}
+	if len(members) >= protocol.MaxGroupMembers {
+		return protocol.GroupMembership{}, fmt.Errorf("too many members, max %d", protocol.MaxGroupMembers)
+	}
+
+	ownerKey, err := crypto.UnmarshalEd25519PrivateKey(s.conf.PrivKey())
+	if err != nil {
+		return protocol.GroupMembership{}, fmt.Errorf("unmarshal private key: %v", err)
+	}
+	members = append(members, s.p2p.PeerID().String())
+	sort.Strings(members)
+
+	return protocol.NewGroupMembership(ownerKey, name, members, time.Now())
+}
+
+// BackgroundSync syncs groups owned by other peers every sharedGroupsSyncInterval.
+func (s *SharedGroups) BackgroundSync(ctx context.Context) {
+	ticker := time.NewTicker(sharedGroupsSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.Sync(ctx, "")
+	}
+}
+
+// Sync fetches membership of groups owned by the peer, or by any peer if ownerPeerID is empty, and applies it.
+// Groups of disconnected owners are skipped, they are synced when the owner connects.
+func (s *SharedGroups) Sync(ctx context.Context, ownerPeerID string) {
+	owners := make(map[string]string)
+	s.conf.RLock()
+	for name, group := range s.conf.PeerGroups {
+		if group.Owner != "" && (ownerPeerID == "" || group.Owner == ownerPeerID) {
+			owners[name] = group.Owner
+		}
+	}
+	s.conf.RUnlock()
+
+	for name, owner := range owners {
+		ownerID, err := peer.Decode(owner)
+		if err != nil || !s.p2p.IsConnected(ownerID) {
+			continue
+		}
+		err = s.SyncGroup(ctx, name, ownerID)
+		if err != nil {
+			s.logger.Warnf("sync membership of group %s: %v", name, err)
+		}
+	}
+}
+
+// SyncGroup fetches membership of the group from its owner and applies it if the signature is valid.
+func (s *SharedGroups) SyncGroup(ctx context.Context, name string, owner peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, s.conf.PeerRequestTimeout())
+	defer cancel()
+
+	err := s.p2p.ConnectPeer(ctx, owner)
+	if err != nil {
+		return err
+	}
+	stream, err := s.p2p.NewStream(ctx, owner, protocol.GroupMembershipMethod)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	err = protocol.SendGroupMembershipRequest(stream, protocol.GroupMembershipRequest{Group: name})
+	if err != nil {
+		return fmt.Errorf("send request: %v", err)
+	}
+	response, err := protocol.ReceiveGroupMembershipResponse(stream)
+	if err != nil {
+		return fmt.Errorf("receive response: %v", err)
+	}
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	membership := response.Membership
+	if membership.Group != name || membership.OwnerPeerID != owner.String() {
+		return fmt.Errorf("membership of group %q by %s is not requested", membership.Group, membership.OwnerPeerID)
+	}
+	err = membership.Verify()
+	if err != nil {
+		return err
+	}
+	if s.conf.ApplyGroupMembership(name, membership.OwnerPeerID, membership.Members, membership.Time) {
+		s.logger.Infof("Applied membership of group %s from %s, %d members", name, membership.OwnerPeerID, len(membership.Members))
+	}
+	return nil
+}
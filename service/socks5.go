@@ -0,0 +1,461 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	socks5HandshakeTimeout = 15 * time.Second
+
+	socks5Version          = 5
+	socks5MethodNoAuth     = 0
+	socks5MethodNoneValid  = 0xff
+	socks5CommandConnect   = 1
+	socks5AddrTypeIPv4     = 1
+	socks5AddrTypeDomain   = 3
+	socks5AddrTypeIPv6     = 4
+	socks5ReplySucceeded   = 0
+	socks5ReplyNotAllowed  = 2
+	socks5ReplyNetUnreach  = 3
+	socks5ReplyHostUnreach = 4
+	socks5ReplyCmdNotSupp  = 7
+	socks5ReplyAddrNotSupp = 8
+)
+
+var errProxyDestinationNotAllowed = errors.New("destination is not allowed")
+
+// SOCKS5Proxy runs local SOCKS5 server which tunnels TCP connections to the selected peer, the peer makes connections
+// on our behalf, so applications use a friend's network without routing all traffic through exit node.
+// We make connections for peers which we allow to use us as exit node, see config.KnownPeer.ExitNodeAllowed.
+type SOCKS5Proxy struct {
+	p2p    P2p
+	conf   *config.Config
+	shaper *Shaper
+	logger *log.ZapEventLogger
+	dialer *net.Dialer
+
+	lock     sync.Mutex
+	listener net.Listener
+
+	sessionsLock sync.Mutex
+	// sessions are resumable connections which we make for peers by peer id and session id
+	sessions map[string]*proxySession
+
+	// ctx bounds dials and handshakes of new connections, it's cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewSOCKS5Proxy(p2pService P2p, conf *config.Config, shaper *Shaper) *SOCKS5Proxy {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SOCKS5Proxy{
+		p2p:      p2pService,
+		conf:     conf,
+		shaper:   shaper,
+		logger:   log.Logger("awl/service/socks5"),
+		sessions: make(map[string]*proxySession),
+		dialer: &net.Dialer{
+			Control: checkProxyDestination,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Restart stops the server and starts it on config.SOCKS5Config.ListenAddress, if it is set.
+func (s *SOCKS5Proxy) Restart() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener != nil {
+		_ = s.listener.Close()
+		s.listener = nil
+	}
+	s.conf.RLock()
+	address := s.conf.SOCKS5.ListenAddress
+	s.conf.RUnlock()
+	if address == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen socks5 on %s: %v", address, err)
+	}
+	s.listener = listener
+	s.logger.Infof("started socks5 server on %s", listener.Addr())
+	go s.serve(listener)
+
+	return nil
+}
+
+// ListenAddr returns address of the running server, nil if it is disabled.
+func (s *SOCKS5Proxy) ListenAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops the server and cancels dials of new connections, established ones are kept.
+func (s *SOCKS5Proxy) Close() {
+	s.cancel()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+		s.listener = nil
+	}
+}
+
+// AllowPeer allows streams from peers which we allow to use us as exit node.
+func (s *SOCKS5Proxy) AllowPeer(peerID peer.ID) bool {
+	return s.conf.PeerExitNodeAllowed(peerID.String())
+}
+
+// StreamHandler makes TCP connection requested by the peer and pipes it to the stream.
+func (s *SOCKS5Proxy) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	_ = stream.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	request, err := protocol.ReceiveProxyRequest(stream)
+	if err != nil {
+		s.logger.Warnf("receive proxy request: %v", err)
+		return
+	}
+	if request.Resume {
+		s.resumeSession(stream, request)
+		return
+	}
+	peerID := stream.Conn().RemotePeer()
+	ctx, cancel := context.WithTimeout(s.ctx, s.conf.ProxyDialTimeout())
+	conn, err := s.dialer.DialContext(ctx, "tcp", request.Address)
+	cancel()
+	if err != nil {
+		s.logger.Debugf("proxy connection to %s for %s: %v", request.Address, peerID, err)
+		_ = protocol.SendProxyResponse(stream, protocol.ProxyResponse{Error: err.Error()})
+		return
+	}
+	defer conn.Close()
+	if request.Session == "" {
+		err = protocol.SendProxyResponse(stream, protocol.ProxyResponse{})
+		if err != nil {
+			return
+		}
+		_ = stream.SetDeadline(time.Time{})
+
+		pipeConns(conn, s.shaper.Stream(stream))
+		return
+	}
+
+	key := peerID.String() + "/" + request.Session
+	session := newProxySession(request.Session, conn, s.logger, nil)
+	session.onClose = func() {
+		s.sessionsLock.Lock()
+		if s.sessions[key] == session {
+			delete(s.sessions, key)
+		}
+		s.sessionsLock.Unlock()
+	}
+	s.sessionsLock.Lock()
+	_, exists := s.sessions[key]
+	if !exists {
+		s.sessions[key] = session
+	}
+	s.sessionsLock.Unlock()
+	if exists {
+		_ = protocol.SendProxyResponse(stream, protocol.ProxyResponse{Error: "proxy session already exists"})
+		return
+	}
+	err = protocol.SendProxyResponse(stream, protocol.ProxyResponse{Resumable: true})
+	if err != nil {
+		session.close()
+		return
+	}
+	_ = stream.SetDeadline(time.Time{})
+
+	session.run(s.shaper.Stream(stream))
+}
+
+// resumeSession attaches the stream of the client to its session after outage.
+func (s *SOCKS5Proxy) resumeSession(stream network.Stream, request protocol.ProxyRequest) {
+	s.sessionsLock.Lock()
+	session := s.sessions[stream.Conn().RemotePeer().String()+"/"+request.Session]
+	s.sessionsLock.Unlock()
+	if session == nil {
+		_ = protocol.SendProxyResponse(stream, protocol.ProxyResponse{Error: errProxySessionNotFound.Error()})
+		return
+	}
+
+	shapedStream := s.shaper.Stream(stream)
+	done, err := session.attach(shapedStream, request.Received, func(received uint64) error {
+		return protocol.SendProxyResponse(stream, protocol.ProxyResponse{Resumable: true, Received: received})
+	})
+	if err != nil {
+		s.logger.Debugf("resume proxy session %s: %v", request.Session, err)
+		_ = protocol.SendProxyResponse(stream, protocol.ProxyResponse{Error: err.Error()})
+		return
+	}
+	_ = stream.SetDeadline(time.Time{})
+
+	session.readStream(shapedStream, done)
+}
+
+func (s *SOCKS5Proxy) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Errorf("accept socks5 connection: %v", err)
+			}
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *SOCKS5Proxy) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	address, err := readSOCKS5Request(conn)
+	if err != nil {
+		s.logger.Debugf("socks5 handshake with %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	s.conf.RLock()
+	peerIDStr := s.conf.SOCKS5.PeerID
+	s.conf.RUnlock()
+	knownPeer, known := s.conf.GetPeer(peerIDStr)
+	if !known || !knownPeer.AllowedUsingAsExitNode {
+		s.logger.Warnf("socks5 connection to %s: proxy peer is not selected or doesn't allow using it as exit node", address)
+		_ = writeSOCKS5Reply(conn, socks5ReplyNotAllowed)
+		return
+	}
+
+	request := protocol.ProxyRequest{Address: address}
+	if s.resumablePort(address) {
+		request.Session = newProxySessionID()
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, socks5HandshakeTimeout)
+	stream, response, err := s.openProxyStream(ctx, knownPeer.PeerId(), request)
+	cancel()
+	if err != nil {
+		s.logger.Debugf("socks5 connection to %s through %s: %v", address, knownPeer.DisplayName(), err)
+		reply := byte(socks5ReplyHostUnreach)
+		if errors.Is(err, errProxyPeerUnreachable) {
+			reply = socks5ReplyNetUnreach
+		}
+		_ = writeSOCKS5Reply(conn, reply)
+		return
+	}
+	defer stream.Close()
+	err = writeSOCKS5Reply(conn, socks5ReplySucceeded)
+	if err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// peers of older versions don't confirm sessions
+	if !response.Resumable {
+		pipeConns(conn, s.shaper.Stream(stream))
+		return
+	}
+	peerID := knownPeer.PeerId()
+	session := newProxySession(request.Session, conn, s.logger, func(ctx context.Context, received uint64) (network.Stream, uint64, error) {
+		request := protocol.ProxyRequest{Session: request.Session, Resume: true, Received: received}
+		stream, response, err := s.openProxyStream(ctx, peerID, request)
+		if err != nil {
+			return nil, 0, err
+		}
+		return s.shaper.Stream(stream), response.Received, nil
+	})
+	session.run(s.shaper.Stream(stream))
+}
+
+var errProxyPeerUnreachable = errors.New("proxy peer is unreachable")
+
+func (s *SOCKS5Proxy) openProxyStream(ctx context.Context, peerID peer.ID, request protocol.ProxyRequest) (network.Stream, protocol.ProxyResponse, error) {
+	err := s.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return nil, protocol.ProxyResponse{}, fmt.Errorf("%w: %v", errProxyPeerUnreachable, err)
+	}
+	stream, err := s.p2p.NewStream(ctx, peerID, protocol.ProxyMethod)
+	if err != nil {
+		return nil, protocol.ProxyResponse{}, fmt.Errorf("%w: %v", errProxyPeerUnreachable, err)
+	}
+	_ = stream.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	err = protocol.SendProxyRequest(stream, request)
+	if err != nil {
+		_ = stream.Reset()
+		return nil, protocol.ProxyResponse{}, err
+	}
+	response, err := protocol.ReceiveProxyResponse(stream)
+	if err != nil {
+		_ = stream.Reset()
+		return nil, protocol.ProxyResponse{}, err
+	}
+	if response.Error == errProxySessionNotFound.Error() {
+		_ = stream.Close()
+		return nil, protocol.ProxyResponse{}, errProxySessionNotFound
+	} else if response.Error != "" {
+		_ = stream.Close()
+		return nil, protocol.ProxyResponse{}, errors.New(response.Error)
+	}
+	_ = stream.SetDeadline(time.Time{})
+
+	return stream, response, nil
+}
+
+// resumablePort reports whether connections to the address are resumed after outages, see config.SOCKS5Config.
+func (s *SOCKS5Proxy) resumablePort(address string) bool {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return false
+	}
+	s.conf.RLock()
+	defer s.conf.RUnlock()
+	return slices.Contains(s.conf.SOCKS5.ResumablePorts, port)
+}
+
+// readSOCKS5Request negotiates no authentication method and returns destination of CONNECT command, see rfc1928.
+func readSOCKS5Request(conn net.Conn) (string, error) {
+	var header [2]byte
+	_, err := io.ReadFull(conn, header[:])
+	if err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	_, err = io.ReadFull(conn, methods)
+	if err != nil {
+		return "", err
+	}
+	method := byte(socks5MethodNoneValid)
+	for _, m := range methods {
+		if m == socks5MethodNoAuth {
+			method = socks5MethodNoAuth
+		}
+	}
+	_, err = conn.Write([]byte{socks5Version, method})
+	if err != nil {
+		return "", err
+	}
+	if method == socks5MethodNoneValid {
+		return "", errors.New("client doesn't support no authentication method")
+	}
+
+	var request [4]byte
+	_, err = io.ReadFull(conn, request[:])
+	if err != nil {
+		return "", err
+	}
+	if request[0] != socks5Version {
+		return "", fmt.Errorf("unsupported version %d", request[0])
+	}
+	if request[1] != socks5CommandConnect {
+		_ = writeSOCKS5Reply(conn, socks5ReplyCmdNotSupp)
+		return "", fmt.Errorf("unsupported command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socks5AddrTypeIPv4, socks5AddrTypeIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socks5AddrTypeIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		_, err = io.ReadFull(conn, ip)
+		host = ip.String()
+	case socks5AddrTypeDomain:
+		var length [1]byte
+		_, err = io.ReadFull(conn, length[:])
+		if err == nil {
+			domain := make([]byte, length[0])
+			_, err = io.ReadFull(conn, domain)
+			host = string(domain)
+		}
+	default:
+		_ = writeSOCKS5Reply(conn, socks5ReplyAddrNotSupp)
+		return "", fmt.Errorf("unsupported address type %d", request[3])
+	}
+	if err != nil {
+		return "", err
+	}
+	var port [2]byte
+	_, err = io.ReadFull(conn, port[:])
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// writeSOCKS5Reply writes reply with zero bound address, clients don't need it for CONNECT.
+func writeSOCKS5Reply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socks5Version, reply, 0, socks5AddrTypeIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// checkProxyDestination denies connections of peers to our loopback addresses, e.g. to awl api.
+func checkProxyDestination(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errProxyDestinationNotAllowed
+	}
+	return nil
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// pipeConns copies data in both directions until both sides finish writing.
+func pipeConns(a, b io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src io.ReadWriteCloser) {
+		defer wg.Done()
+		_, err := io.Copy(dst, src)
+		if cw, ok := dst.(closeWriter); ok && err == nil {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+			_ = src.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+}
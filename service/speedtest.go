@@ -0,0 +1,506 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anywherelan/awl/protocol"
+	"github.com/anywherelan/awl/storage"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	SpeedTestTimeout = time.Minute
+	// DefaultSpeedTestDuration of each direction of the test
+	DefaultSpeedTestDuration = 5 * time.Second
+	// SpeedTestStreamTimeout is longer than each direction of the test
+	SpeedTestStreamTimeout = protocol.MaxSpeedTestSeconds*time.Second + 10*time.Second
+
+	// speedTestRounds of max probe payload for peers of older versions, the result is averaged over them
+	speedTestRounds = 4
+	// speedTestIdlePings measure latency before the load, the fastest one is taken
+	speedTestIdlePings = 3
+	// speedTestPingInterval of probes which measure latency under load
+	speedTestPingInterval = 250 * time.Millisecond
+	speedTestPingSize     = 64
+	speedTestChunkSize    = 32 << 10
+
+	speedTestHeaderSize   = 5
+	speedTestResponseSize = 16
+
+	speedTestRetention = 90 * 24 * time.Hour
+
+	speedTestStorageNamespace = "speedtest"
+	speedTestResultsPrefix    = "/results"
+)
+
+// SpeedTestResult is throughput to the peer measured with probes of max payload. Failed tests are kept as well,
+// so unreachable peer is seen in history.
+type SpeedTestResult struct {
+	PeerID string
+	At     time.Time
+	// Throughput in bits per second, payload is counted both ways
+	Throughput float64
+	// Upload and Download in bits per second, they are zero for peers of older versions which are tested with echo
+	Upload   float64
+	Download float64
+	// MinRTT is the fastest probe before the load. For peers of older versions it's the fastest echo round,
+	// it includes transfer of the payload
+	MinRTT time.Duration `swaggertype:"primitive,integer"`
+	// LoadedRTT is the median of probes during the load, it's much higher than MinRTT when buffers on the path are bloated
+	LoadedRTT    time.Duration `swaggertype:"primitive,integer"`
+	Bytes        int64
+	ThroughRelay bool
+	Error        string `json:",omitempty"`
+}
+
+// SpeedTestTrend is daily summary of successful speed tests, Tests and Failed count all tests of the day.
+type SpeedTestTrend struct {
+	Day           time.Time
+	AvgThroughput float64
+	MinThroughput float64
+	MaxThroughput float64
+	Tests         int
+	Failed        int
+	// Relayed is the number of successful tests which went through relay
+	Relayed int
+}
+
+// SpeedTest measures throughput to peers and keeps the results in storage, so degradation of ISP or relays
+// could be seen over time. Tests are run on demand or by scheduled jobs, see config.ScheduledJobActionSpeedTest.
+type SpeedTest struct {
+	p2p    P2p
+	probe  *Probe
+	store  ds.Batching
+	logger *log.ZapEventLogger
+}
+
+func NewSpeedTest(p2pService P2p, probe *Probe, s storage.Storage) *SpeedTest {
+	return &SpeedTest{
+		p2p:    p2pService,
+		probe:  probe,
+		store:  storage.Namespace(s, speedTestStorageNamespace),
+		logger: log.Logger("awl/service/speedtest"),
+	}
+}
+
+// Run streams generated data to the peer and back for duration each way, zero for DefaultSpeedTestDuration,
+// and saves the result to history. Peers of older versions are tested with echo rounds of max probe payload.
+func (s *SpeedTest) Run(ctx context.Context, peerID peer.ID, duration time.Duration) (SpeedTestResult, error) {
+	if duration == 0 {
+		duration = DefaultSpeedTestDuration
+	}
+	if duration < 0 || duration > protocol.MaxSpeedTestSeconds*time.Second {
+		return SpeedTestResult{}, fmt.Errorf("duration should be up to %d seconds", protocol.MaxSpeedTestSeconds)
+	}
+	ctx, cancel := context.WithTimeout(ctx, SpeedTestTimeout)
+	defer cancel()
+
+	result := SpeedTestResult{PeerID: peerID.String(), At: time.Now()}
+	err := s.p2p.ConnectPeer(ctx, peerID)
+	if err == nil {
+		err = s.measure(ctx, peerID, duration, &result)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.ThroughRelay = s.throughRelay(peerID)
+	}
+
+	s.save(result)
+	s.prune(result.At)
+	return result, err
+}
+
+func (s *SpeedTest) measure(ctx context.Context, peerID peer.ID, duration time.Duration, result *SpeedTestResult) error {
+	stream, err := s.p2p.NewStream(ctx, peerID, protocol.SpeedTestMethod, protocol.EchoMethod)
+	if err != nil {
+		return err
+	}
+	if stream.Protocol() != protocol.SpeedTestMethod {
+		_ = stream.Close()
+		return s.measureWithEcho(ctx, peerID, result)
+	}
+
+	for i := 0; i < speedTestIdlePings; i++ {
+		probeResult, err := s.probe.Send(ctx, peerID, speedTestPingSize)
+		if err != nil {
+			_ = stream.Close()
+			return err
+		}
+		if result.MinRTT == 0 || probeResult.RTT < result.MinRTT {
+			result.MinRTT = probeResult.RTT
+		}
+	}
+
+	var uploaded, downloaded int64
+	var uploadTime, downloadTime time.Duration
+	rtts, err := s.pingUnderLoad(ctx, peerID, func() (err error) {
+		uploaded, uploadTime, err = upload(ctx, stream, duration)
+		if err != nil {
+			return fmt.Errorf("upload: %v", err)
+		}
+		stream, err = s.p2p.NewStream(ctx, peerID, protocol.SpeedTestMethod)
+		if err != nil {
+			return err
+		}
+		downloaded, downloadTime, err = download(ctx, stream, duration)
+		if err != nil {
+			return fmt.Errorf("download: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	result.Bytes = uploaded + downloaded
+	result.Upload = float64(8*uploaded) / uploadTime.Seconds()
+	result.Download = float64(8*downloaded) / downloadTime.Seconds()
+	result.Throughput = float64(8*result.Bytes) / (uploadTime + downloadTime).Seconds()
+	result.LoadedRTT = medianDuration(rtts)
+	return nil
+}
+
+func (s *SpeedTest) measureWithEcho(ctx context.Context, peerID peer.ID, result *SpeedTestResult) error {
+	var total time.Duration
+	for i := 0; i < speedTestRounds; i++ {
+		probeResult, err := s.probe.Send(ctx, peerID, protocol.MaxEchoPayloadSize)
+		if err != nil {
+			return err
+		}
+		total += probeResult.RTT
+		result.Bytes += int64(probeResult.Bytes)
+		if result.MinRTT == 0 || probeResult.RTT < result.MinRTT {
+			result.MinRTT = probeResult.RTT
+		}
+	}
+	result.Throughput = float64(2*8*result.Bytes) / total.Seconds()
+	return nil
+}
+
+// pingUnderLoad probes the peer with small payloads while load is running and returns their round trip times.
+func (s *SpeedTest) pingUnderLoad(ctx context.Context, peerID peer.ID, load func() error) ([]time.Duration, error) {
+	done := make(chan struct{})
+	rttsCh := make(chan []time.Duration, 1)
+	go func() {
+		var rtts []time.Duration
+		ticker := time.NewTicker(speedTestPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				rttsCh <- rtts
+				return
+			case <-ticker.C:
+			}
+			probeResult, err := s.probe.Send(ctx, peerID, speedTestPingSize)
+			if err == nil {
+				rtts = append(rtts, probeResult.RTT)
+			}
+		}
+	}()
+
+	err := load()
+	close(done)
+	return <-rttsCh, err
+}
+
+func (s *SpeedTest) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+	peerID := stream.Conn().RemotePeer()
+
+	header := make([]byte, speedTestHeaderSize)
+	_, err := io.ReadFull(stream, header)
+	if err != nil {
+		s.logger.Debugf("read speed test from %s: %v", peerID, err)
+		return
+	}
+	duration := time.Duration(binary.BigEndian.Uint32(header[1:])) * time.Millisecond
+	if duration <= 0 || duration > protocol.MaxSpeedTestSeconds*time.Second {
+		s.logger.Debugf("invalid speed test duration %s from %s", duration, peerID)
+		return
+	}
+
+	switch header[0] {
+	case protocol.SpeedTestUpload:
+		received, elapsed, err := receiveSpeedTestData(stream)
+		if err != nil {
+			s.logger.Debugf("receive speed test from %s: %v", peerID, err)
+			return
+		}
+		response := make([]byte, speedTestResponseSize)
+		binary.BigEndian.PutUint64(response, uint64(received))
+		binary.BigEndian.PutUint64(response[8:], uint64(elapsed))
+		_, err = stream.Write(response)
+		if err != nil {
+			s.logger.Debugf("answer speed test of %s: %v", peerID, err)
+		}
+	case protocol.SpeedTestDownload:
+		_, err = sendSpeedTestData(stream, duration)
+		if err != nil {
+			s.logger.Debugf("send speed test to %s: %v", peerID, err)
+		}
+	default:
+		s.logger.Debugf("invalid speed test direction %d from %s", header[0], peerID)
+	}
+}
+
+// upload sends data for duration and returns the number of bytes and time it took them to arrive to the peer.
+func upload(ctx context.Context, stream network.Stream, duration time.Duration) (int64, time.Duration, error) {
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	_, err := stream.Write(speedTestHeader(protocol.SpeedTestUpload, duration))
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = sendSpeedTestData(stream, duration)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = stream.CloseWrite()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	response := make([]byte, speedTestResponseSize)
+	_, err = io.ReadFull(stream, response)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read response: %v", err)
+	}
+	received := int64(binary.BigEndian.Uint64(response))
+	elapsed := time.Duration(binary.BigEndian.Uint64(response[8:]))
+	if elapsed <= 0 {
+		return 0, 0, errors.New("peer received too little data")
+	}
+	return received, elapsed, nil
+}
+
+// download asks the peer to send data for duration and returns the number of received bytes and time it took.
+func download(ctx context.Context, stream network.Stream, duration time.Duration) (int64, time.Duration, error) {
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	_, err := stream.Write(speedTestHeader(protocol.SpeedTestDownload, duration))
+	if err != nil {
+		return 0, 0, err
+	}
+	err = stream.CloseWrite()
+	if err != nil {
+		return 0, 0, err
+	}
+	received, elapsed, err := receiveSpeedTestData(stream)
+	if err != nil {
+		return 0, 0, err
+	}
+	if elapsed <= 0 {
+		return 0, 0, errors.New("received too little data")
+	}
+	return received, elapsed, nil
+}
+
+func speedTestHeader(direction byte, duration time.Duration) []byte {
+	header := make([]byte, speedTestHeaderSize)
+	header[0] = direction
+	binary.BigEndian.PutUint32(header[1:], uint32(duration.Milliseconds()))
+	return header
+}
+
+// sendSpeedTestData writes random chunks until duration is over.
+func sendSpeedTestData(w io.Writer, duration time.Duration) (int64, error) {
+	chunk := make([]byte, speedTestChunkSize)
+	_, _ = rand.Read(chunk)
+
+	var sent int64
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline); {
+		n, err := w.Write(chunk)
+		sent += int64(n)
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// receiveSpeedTestData reads until EOF, elapsed is the time from the first to the last byte.
+func receiveSpeedTestData(r io.Reader) (received int64, elapsed time.Duration, err error) {
+	buf := make([]byte, speedTestChunkSize)
+	var first, last time.Time
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			last = time.Now()
+			if first.IsZero() {
+				first = last
+			}
+			received += int64(n)
+		}
+		if errors.Is(err, io.EOF) {
+			return received, last.Sub(first), nil
+		} else if err != nil {
+			return received, last.Sub(first), err
+		}
+	}
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted[len(sorted)/2]
+}
+
+// History returns results of the peer in [from, to] ordered by time, all peers if peerID is empty.
+// Zero from and to are not limited.
+func (s *SpeedTest) History(peerID string, from, to time.Time) ([]SpeedTestResult, error) {
+	results, err := s.store.Query(context.Background(), dsq.Query{Prefix: speedTestResultsPrefix})
+	if err != nil {
+		return nil, fmt.Errorf("query speed tests: %v", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("query speed tests: %v", err)
+	}
+
+	history := make([]SpeedTestResult, 0, len(entries))
+	for _, entry := range entries {
+		var result SpeedTestResult
+		err = json.Unmarshal(entry.Value, &result)
+		if err != nil {
+			s.logger.Warnf("decode speed test %s: %v", entry.Key, err)
+			continue
+		}
+		if peerID != "" && result.PeerID != peerID {
+			continue
+		}
+		if overlaps(result.At, result.At, from, to) {
+			history = append(history, result)
+		}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		if !history[i].At.Equal(history[j].At) {
+			return history[i].At.Before(history[j].At)
+		}
+		return history[i].PeerID < history[j].PeerID
+	})
+
+	return history, nil
+}
+
+// SpeedTestTrends summarizes results by local day, ordered by time.
+func SpeedTestTrends(history []SpeedTestResult) []SpeedTestTrend {
+	trends := make([]SpeedTestTrend, 0)
+	for _, result := range history {
+		day := time.Date(result.At.Year(), result.At.Month(), result.At.Day(), 0, 0, 0, 0, result.At.Location())
+		if len(trends) == 0 || !trends[len(trends)-1].Day.Equal(day) {
+			trends = append(trends, SpeedTestTrend{Day: day})
+		}
+		trend := &trends[len(trends)-1]
+		trend.Tests++
+		if result.Error != "" {
+			trend.Failed++
+			continue
+		}
+		succeeded := trend.Tests - trend.Failed
+		trend.AvgThroughput += (result.Throughput - trend.AvgThroughput) / float64(succeeded)
+		if succeeded == 1 || result.Throughput < trend.MinThroughput {
+			trend.MinThroughput = result.Throughput
+		}
+		if result.Throughput > trend.MaxThroughput {
+			trend.MaxThroughput = result.Throughput
+		}
+		if result.ThroughRelay {
+			trend.Relayed++
+		}
+	}
+	return trends
+}
+
+func (s *SpeedTest) throughRelay(peerID peer.ID) bool {
+	conns := s.p2p.PeerConnectionsInfo(peerID)
+	for _, conn := range conns {
+		if !conn.ThroughRelay {
+			return false
+		}
+	}
+	return len(conns) > 0
+}
+
+func (s *SpeedTest) save(result SpeedTestResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		s.logger.Errorf("encode speed test: %v", err)
+		return
+	}
+	key := speedTestKey(result.At).ChildString(result.PeerID)
+	err = s.store.Put(context.Background(), key, data)
+	if err != nil {
+		s.logger.Errorf("save speed test: %v", err)
+	}
+}
+
+// prune removes results older than retention period.
+func (s *SpeedTest) prune(now time.Time) {
+	ctx := context.Background()
+	results, err := s.store.Query(ctx, dsq.Query{Prefix: speedTestResultsPrefix, KeysOnly: true})
+	if err != nil {
+		s.logger.Errorf("query speed tests: %v", err)
+		return
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		s.logger.Errorf("query speed tests: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		t, ok := parseSpeedTestKeyTime(entry.Key)
+		if ok && now.Sub(t) > speedTestRetention {
+			_ = s.store.Delete(ctx, ds.NewKey(entry.Key))
+		}
+	}
+}
+
+// speedTestKey keeps keys sorted by time, nanoseconds are used since tests could be run in the same second.
+func speedTestKey(t time.Time) ds.Key {
+	return ds.NewKey(speedTestResultsPrefix).ChildString(fmt.Sprintf("%020d", t.UnixNano()))
+}
+
+func parseSpeedTestKeyTime(key string) (time.Time, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	if len(parts) < 2 {
+		return time.Time{}, false
+	}
+	unixNano, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, unixNano), true
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/anywherelan/awl/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpeedTestTrends(t *testing.T) {
+	a := require.New(t)
+	day := time.Date(2024, 6, 10, 0, 0, 0, 0, time.Local)
+	history := []SpeedTestResult{
+		{At: day.Add(time.Hour), Throughput: 100e6},
+		{At: day.Add(2 * time.Hour), Throughput: 50e6, ThroughRelay: true},
+		{At: day.Add(3 * time.Hour), Error: "deadline exceeded"},
+		// degraded next night
+		{At: day.Add(25 * time.Hour), Throughput: 10e6},
+	}
+
+	trends := SpeedTestTrends(history)
+	a.Equal([]SpeedTestTrend{
+		{Day: day, AvgThroughput: 75e6, MinThroughput: 50e6, MaxThroughput: 100e6, Tests: 3, Failed: 1, Relayed: 1},
+		{Day: day.AddDate(0, 0, 1), AvgThroughput: 10e6, MinThroughput: 10e6, MaxThroughput: 10e6, Tests: 1},
+	}, trends)
+	a.Empty(SpeedTestTrends(nil))
+}
+
+func TestSpeedTestKey(t *testing.T) {
+	at := time.Unix(1700000000, 123)
+	parsed, ok := parseSpeedTestKeyTime(speedTestKey(at).ChildString("peer").String())
+	require.True(t, ok)
+	require.True(t, at.Equal(parsed))
+}
+
+func TestSpeedTestData(t *testing.T) {
+	a := require.New(t)
+	r, w := io.Pipe()
+	go func() {
+		sent, err := sendSpeedTestData(w, 100*time.Millisecond)
+		a.NoError(err)
+		a.Positive(sent)
+		_ = w.Close()
+	}()
+	received, elapsed, err := receiveSpeedTestData(r)
+	a.NoError(err)
+	a.Positive(received)
+	a.Positive(elapsed)
+
+	a.Equal(speedTestHeaderSize, len(speedTestHeader(protocol.SpeedTestUpload, time.Second)))
+	a.Zero(medianDuration(nil))
+	a.Equal(20*time.Millisecond, medianDuration([]time.Duration{300 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}))
+}
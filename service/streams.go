@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2pProtocol "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// StreamHost is the part of libp2p host which handles incoming streams.
+type StreamHost interface {
+	SetStreamHandler(pid libp2pProtocol.ID, handler network.StreamHandler)
+	RemoveStreamHandler(pid libp2pProtocol.ID)
+}
+
+type StreamHandlerOptions struct {
+	// Allow reports whether the peer could open streams, streams of other peers are closed before the handler is called.
+	// Nil allows all peers, e.g. for auth requests from unknown peers.
+	Allow func(peerID peer.ID) bool
+	// Timeout is set as stream deadline before the handler is called, zero for long-lived streams.
+	Timeout time.Duration
+}
+
+type StreamHandlerStats struct {
+	Protocol libp2pProtocol.ID
+	// Accepted streams are passed to the handler, Rejected are closed because the peer is not allowed
+	Accepted int64
+	Rejected int64
+	Panics   int64
+	Active   int64
+}
+
+// StreamRegistry registers handlers of awl protocols, every handler gets peer access check, deadline,
+// metrics and panic recovery, so handlers don't need to implement them.
+type StreamRegistry struct {
+	host   StreamHost
+	conf   *config.Config
+	logger *log.ZapEventLogger
+
+	statsLock sync.RWMutex
+	stats     map[libp2pProtocol.ID]*streamHandlerStats
+
+	// active streams are reset by Close, handlers is the number of running handlers
+	activeLock sync.Mutex
+	active     map[network.Stream]struct{}
+	handlers   sync.WaitGroup
+	closed     bool
+}
+
+type streamHandlerStats struct {
+	accepted atomic.Int64
+	rejected atomic.Int64
+	panics   atomic.Int64
+	active   atomic.Int64
+}
+
+func NewStreamRegistry(host StreamHost, conf *config.Config) *StreamRegistry {
+	return &StreamRegistry{
+		host:   host,
+		conf:   conf,
+		logger: log.Logger("awl/service/streams"),
+		stats:  make(map[libp2pProtocol.ID]*streamHandlerStats),
+		active: make(map[network.Stream]struct{}),
+	}
+}
+
+// Handle sets handler of the protocol wrapped with middlewares: panic recovery and metrics, access check and deadline.
+func (r *StreamRegistry) Handle(proto libp2pProtocol.ID, handler network.StreamHandler, opts StreamHandlerOptions) {
+	stats := &streamHandlerStats{}
+	r.statsLock.Lock()
+	r.stats[proto] = stats
+	r.statsLock.Unlock()
+
+	handler = withDeadline(opts.Timeout, handler)
+	handler = r.withAccessCheck(proto, opts.Allow, stats, handler)
+	handler = r.withRecovery(proto, stats, handler)
+	handler = r.withTracking(handler)
+
+	r.host.SetStreamHandler(proto, handler)
+}
+
+// Close removes registered handlers, so new streams are refused, and resets active streams. It waits for running
+// handlers to return, so they don't use services which are closed after it, or until ctx is done. Stats are still available.
+func (r *StreamRegistry) Close(ctx context.Context) error {
+	r.statsLock.RLock()
+	for proto := range r.stats {
+		r.host.RemoveStreamHandler(proto)
+	}
+	r.statsLock.RUnlock()
+
+	r.activeLock.Lock()
+	r.closed = true
+	for stream := range r.active {
+		_ = stream.Reset()
+	}
+	r.activeLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns metrics of registered handlers sorted by protocol.
+func (r *StreamRegistry) Stats() []StreamHandlerStats {
+	r.statsLock.RLock()
+	result := make([]StreamHandlerStats, 0, len(r.stats))
+	for proto, stats := range r.stats {
+		result = append(result, StreamHandlerStats{
+			Protocol: proto,
+			Accepted: stats.accepted.Load(),
+			Rejected: stats.rejected.Load(),
+			Panics:   stats.panics.Load(),
+			Active:   stats.active.Load(),
+		})
+	}
+	r.statsLock.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Protocol < result[j].Protocol
+	})
+
+	return result
+}
+
+// AllowKnownPeers allows streams only from known peers.
+func (r *StreamRegistry) AllowKnownPeers(peerID peer.ID) bool {
+	_, known := r.conf.GetPeer(peerID.String())
+	return known
+}
+
+// AllowKnownOrBlockedPeers allows streams from known peers and peers which we blocked, so they could learn about it.
+func (r *StreamRegistry) AllowKnownOrBlockedPeers(peerID peer.ID) bool {
+	_, blocked := r.conf.GetBlockedPeer(peerID.String())
+	return blocked || r.AllowKnownPeers(peerID)
+}
+
+// withTracking registers the stream until the handler returns, streams which are opened after Close are reset.
+func (r *StreamRegistry) withTracking(next network.StreamHandler) network.StreamHandler {
+	return func(stream network.Stream) {
+		r.activeLock.Lock()
+		if r.closed {
+			r.activeLock.Unlock()
+			_ = stream.Reset()
+			return
+		}
+		r.active[stream] = struct{}{}
+		r.handlers.Add(1)
+		r.activeLock.Unlock()
+
+		defer func() {
+			r.activeLock.Lock()
+			delete(r.active, stream)
+			r.activeLock.Unlock()
+			r.handlers.Done()
+		}()
+		next(stream)
+	}
+}
+
+func (r *StreamRegistry) withRecovery(proto libp2pProtocol.ID, stats *streamHandlerStats, next network.StreamHandler) network.StreamHandler {
+	return func(stream network.Stream) {
+		stats.active.Add(1)
+		defer stats.active.Add(-1)
+		defer func() {
+			if rec := recover(); rec != nil {
+				stats.panics.Add(1)
+				r.logger.Errorf("panic in %s handler for peer %s: %v\n%s", proto, stream.Conn().RemotePeer(), rec, debug.Stack())
+				_ = stream.Reset()
+			}
+		}()
+		next(stream)
+	}
+}
+
+func (r *StreamRegistry) withAccessCheck(proto libp2pProtocol.ID, allow func(peer.ID) bool, stats *streamHandlerStats, next network.StreamHandler) network.StreamHandler {
+	return func(stream network.Stream) {
+		peerID := stream.Conn().RemotePeer()
+		if allow != nil && !allow(peerID) {
+			stats.rejected.Add(1)
+			r.logger.Infof("Unknown peer %s tried to open %s stream", peerID, proto)
+			_ = stream.Close()
+			return
+		}
+		stats.accepted.Add(1)
+		next(stream)
+	}
+}
+
+func withDeadline(timeout time.Duration, next network.StreamHandler) network.StreamHandler {
+	if timeout == 0 {
+		return next
+	}
+	return func(stream network.Stream) {
+		_ = stream.SetDeadline(time.Now().Add(timeout))
+		next(stream)
+	}
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/logview"
+	"github.com/anywherelan/awl/p2p"
+	"github.com/anywherelan/awl/protocol"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap/zapcore"
+)
+
+const SupportStreamTimeout = time.Minute
+
+// Support sends our recent logs and diagnostics report to peers which we granted support access,
+// so they could help with troubleshooting without screen sharing. See config.KnownPeer.SupportAccess.
+type Support struct {
+	p2p     P2p
+	conf    *config.Config
+	logs    *logview.Store
+	clock   *Clock
+	latency *Latency
+	logger  *log.ZapEventLogger
+}
+
+type SupportReport struct {
+	PeerID      string
+	ReceivedAt  time.Time
+	Diagnostics SupportDiagnostics
+	// Logs are log lines in the same format as they are written, the oldest first
+	Logs string
+}
+
+type SupportDiagnostics struct {
+	Version   string
+	OS        string
+	Arch      string
+	CreatedAt time.Time
+	VPN       config.VPNConfig
+	Stats     p2p.StatsSnapshot
+	Peers     []SupportPeerInfo
+	// ClockWarnings describe big clock skews against NTP server and peers, see MaxClockSkew
+	ClockWarnings []string
+}
+
+type SupportPeerInfo struct {
+	PeerID      string
+	DisplayName string
+	Version     string
+	IPAddr      string
+	Connected   bool
+	Confirmed   bool
+	Declined    bool
+	LastSeen    time.Time
+	Connections []p2p.ConnectionInfo
+	// ClockSkew is the peer clock minus ours, zero if unknown
+	ClockSkew time.Duration
+	// Latency includes clock offset and delay asymmetry estimated by pings, they are more precise than ClockSkew
+	Latency PeerLatency
+}
+
+func NewSupport(p2pService P2p, conf *config.Config, logs *logview.Store, clock *Clock, latency *Latency) *Support {
+	return &Support{
+		p2p:     p2pService,
+		conf:    conf,
+		logs:    logs,
+		clock:   clock,
+		latency: latency,
+		logger:  log.Logger("awl/service/support"),
+	}
+}
+
+func (s *Support) StreamHandler(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	peerID := stream.Conn().RemotePeer().String()
+	knownPeer, _ := s.conf.GetPeer(peerID)
+	request, err := protocol.ReceiveSupportRequest(stream)
+	if err != nil {
+		s.logger.Warnf("receive support request from %s: %v", knownPeer.DisplayName(), err)
+		return
+	}
+
+	response := protocol.SupportResponse{}
+	if !knownPeer.SupportAccess {
+		s.logger.Infof("Peer %s tried to fetch logs without support access", knownPeer.DisplayName())
+		response.Error = "support access is not granted"
+	} else {
+		response, err = s.makeResponse(request.LogMinutes)
+		if err != nil {
+			s.logger.Errorf("make support response: %v", err)
+			response = protocol.SupportResponse{Error: "internal error"}
+		} else {
+			s.logger.Infof("Sent logs for the last %d minutes and diagnostics to %s", request.LogMinutes, knownPeer.DisplayName())
+		}
+	}
+
+	err = protocol.SendSupportResponse(stream, response)
+	if err != nil {
+		s.logger.Warnf("send support response to %s: %v", knownPeer.DisplayName(), err)
+	}
+}
+
+// FetchReport requests logs for the last logMinutes and diagnostics report from the peer,
+// it should grant us support access.
+func (s *Support) FetchReport(ctx context.Context, peerID peer.ID, logMinutes int) (SupportReport, error) {
+	if logMinutes < 0 || logMinutes > protocol.MaxSupportLogMinutes {
+		return SupportReport{}, fmt.Errorf("log minutes should be in range 0-%d", protocol.MaxSupportLogMinutes)
+	}
+
+	err := s.p2p.ConnectPeer(ctx, peerID)
+	if err != nil {
+		return SupportReport{}, err
+	}
+	stream, err := s.p2p.NewStream(ctx, peerID, protocol.SupportMethod)
+	if err != nil {
+		return SupportReport{}, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	err = protocol.SendSupportRequest(stream, protocol.SupportRequest{LogMinutes: logMinutes})
+	if err != nil {
+		return SupportReport{}, fmt.Errorf("send request: %v", err)
+	}
+	response, err := protocol.ReceiveSupportResponse(stream)
+	if err != nil {
+		return SupportReport{}, fmt.Errorf("receive response: %v", err)
+	}
+	if response.Error != "" {
+		return SupportReport{}, errors.New(response.Error)
+	}
+
+	report := SupportReport{
+		PeerID:     peerID.String(),
+		ReceivedAt: time.Now(),
+		Logs:       response.Logs,
+	}
+	err = json.Unmarshal(response.Diagnostics, &report.Diagnostics)
+	if err != nil {
+		return SupportReport{}, fmt.Errorf("decode diagnostics: %v", err)
+	}
+
+	return report, nil
+}
+
+func (s *Support) makeResponse(logMinutes int) (protocol.SupportResponse, error) {
+	logMinutes = max(0, min(logMinutes, protocol.MaxSupportLogMinutes))
+	entries, err := s.logs.Entries()
+	if err != nil {
+		return protocol.SupportResponse{}, fmt.Errorf("read logs: %v", err)
+	}
+	query := logview.Query{
+		From:     time.Now().Add(-time.Duration(logMinutes) * time.Minute),
+		MinLevel: zapcore.DebugLevel,
+	}
+	entries = query.Filter(entries)
+
+	// the newest entries are kept if logs are too big
+	var size int
+	first := len(entries)
+	for first > 0 {
+		entrySize := len(entries[first-1].String()) + len(zapcore.DefaultLineEnding)
+		if size+entrySize > protocol.MaxSupportLogSize {
+			break
+		}
+		size += entrySize
+		first--
+	}
+	logs := new(strings.Builder)
+	logs.Grow(size)
+	for _, entry := range entries[first:] {
+		logs.WriteString(entry.String())
+		logs.WriteString(zapcore.DefaultLineEnding)
+	}
+
+	diagnostics, err := json.Marshal(s.diagnostics())
+	if err != nil {
+		return protocol.SupportResponse{}, fmt.Errorf("encode diagnostics: %v", err)
+	}
+
+	return protocol.SupportResponse{
+		Logs:        logs.String(),
+		Diagnostics: diagnostics,
+	}, nil
+}
+
+func (s *Support) diagnostics() SupportDiagnostics {
+	s.conf.RLock()
+	vpnConfig := s.conf.VPNConfig
+	knownPeers := make([]config.KnownPeer, 0, len(s.conf.KnownPeers))
+	for _, knownPeer := range s.conf.KnownPeers {
+		knownPeers = append(knownPeers, knownPeer)
+	}
+	s.conf.RUnlock()
+	sort.Slice(knownPeers, func(i, j int) bool {
+		return knownPeers[i].PeerID < knownPeers[j].PeerID
+	})
+
+	peers := make([]SupportPeerInfo, 0, len(knownPeers))
+	for _, knownPeer := range knownPeers {
+		id := knownPeer.PeerId()
+		clockSkew, _ := s.clock.PeerSkew(id)
+		latency, _ := s.latency.PeerLatency(id)
+		peers = append(peers, SupportPeerInfo{
+			PeerID:      knownPeer.PeerID,
+			DisplayName: knownPeer.DisplayName(),
+			Version:     s.p2p.PeerVersion(id),
+			IPAddr:      knownPeer.IPAddr,
+			Connected:   s.p2p.IsConnected(id),
+			Confirmed:   knownPeer.Confirmed,
+			Declined:    knownPeer.Declined,
+			LastSeen:    knownPeer.LastSeen,
+			Connections: s.p2p.PeerConnectionsInfo(id),
+			ClockSkew:   clockSkew.Offset,
+			Latency:     latency,
+		})
+	}
+
+	return SupportDiagnostics{
+		Version:   config.Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CreatedAt: time.Now(),
+		VPN:       vpnConfig,
+		Stats:     s.p2p.StatsSnapshot(),
+		Peers:     peers,
+
+		ClockWarnings: s.clock.Warnings(),
+	}
+}
@@ -1,69 +1,153 @@
 package service
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/anywherelan/awl/awlevent"
 	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/p2p"
 	"github.com/anywherelan/awl/protocol"
 	"github.com/anywherelan/awl/vpn"
 	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	libp2pProtocol "github.com/libp2p/go-libp2p/core/protocol"
 )
 
 const (
 	packetHandlersChanCap = 200
+	// tunnelStreamBufSize is buffer size of tunnel stream reads and coalesced writes of packets
+	tunnelStreamBufSize = 64 << 10
+	// tunnelDrainInterval is how often queues are checked by Drain
+	tunnelDrainInterval = 10 * time.Millisecond
 )
 
 type Tunnel struct {
 	p2p          P2p
 	conf         *config.Config
+	shaper       *Shaper
 	device       *vpn.Device
 	logger       *log.ZapEventLogger
+	pathEmitter  awlevent.Emitter
+	stateEmitter awlevent.Emitter
+	flows        *vpn.FlowTable
 	peersLock    sync.RWMutex
 	peerIDToPeer map[peer.ID]*VpnPeer
 	netIPToPeer  map[string]*VpnPeer
+	// exitPeer is our exit node, nil if it is not selected or the peer doesn't allow it
+	exitPeer *VpnPeer
+	// subnetRoutes are networks advertised by peers, they don't overlap
+	subnetRoutes []subnetRoute
+	// advertisedRoutes are our networks which peers could reach through us
+	advertisedRoutes []*net.IPNet
+	// inboundQueues are packets from peers sharded by flows between workers, see backgroundInboundWorker
+	inboundQueues []*fairQueue
+	// qos classifies packets to peers for their outbound queues, see priorityQueue
+	qos         atomic.Pointer[qosClassifier]
+	qosCounters qosCounters
+	// pingResponder answers echo requests of peers instead of the system, see vpn.Device.EchoReply
+	pingResponder atomic.Bool
+
+	// ctx bounds waits for bandwidth limits and opening of streams, it's cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type inboundPacket struct {
+	peer   *VpnPeer
+	packet *vpn.Packet
+	// exit is true for packets from exit stream, see ExitStreamHandler
+	exit bool
 }
 
-func NewTunnel(p2pService P2p, device *vpn.Device, conf *config.Config) *Tunnel {
+type subnetRoute struct {
+	network *net.IPNet
+	peer    *VpnPeer
+}
+
+func NewTunnel(p2pService P2p, device *vpn.Device, conf *config.Config, shaper *Shaper, eventbus awlevent.Bus) *Tunnel {
+	pathEmitter, err := eventbus.Emitter(new(awlevent.VPNPathChanged))
+	if err != nil {
+		panic(err)
+	}
+	stateEmitter, err := eventbus.Emitter(new(awlevent.VPNInterfaceStateChanged))
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	tunnel := &Tunnel{
 		p2p:          p2pService,
 		conf:         conf,
+		shaper:       shaper,
 		device:       device,
 		logger:       log.Logger("awl/service/tunnel"),
+		pathEmitter:  pathEmitter,
+		stateEmitter: stateEmitter,
+		flows:        vpn.NewFlowTable(),
 		peerIDToPeer: make(map[peer.ID]*VpnPeer),
 		netIPToPeer:  make(map[string]*VpnPeer),
+		ctx:          ctx,
+		cancel:       cancel,
 	}
+	tunnel.RefreshQoS()
 	tunnel.RefreshPeersList()
-	go tunnel.backgroundReadPackets()
+	tunnel.RefreshPacketFilter()
+	device.SubscribeStateChanges(tunnel.onInterfaceStateChanged)
+	// the same number of workers for both directions
+	for _, outboundCh := range device.OutboundChans() {
+		inboundQueue := newFairQueue(packetHandlersChanCap, vpn.InterfaceMTU)
+		tunnel.inboundQueues = append(tunnel.inboundQueues, inboundQueue)
+		go tunnel.backgroundInboundWorker(inboundQueue)
+		go tunnel.backgroundReadPackets(outboundCh)
+	}
 
 	return tunnel
 }
 
 func (t *Tunnel) StreamHandler(stream network.Stream) {
+	t.handleStream(stream, false)
+}
+
+// ExitStreamHandler receives packets to the internet or our advertised subnets from peers,
+// and replies from the internet from our exit node or from subnets advertised by peers.
+func (t *Tunnel) ExitStreamHandler(stream network.Stream) {
+	t.handleStream(stream, true)
+}
+
+func (t *Tunnel) handleStream(stream network.Stream, exit bool) {
 	defer func() {
 		_ = stream.Close()
 	}()
 
 	peerID := stream.Conn().RemotePeer()
 	t.peersLock.RLock()
-	_, ok := t.peerIDToPeer[peerID]
+	vpnPeer, ok := t.peerIDToPeer[peerID]
+	exitAllowed := ok && (vpnPeer.exitAllowed.Load() || vpnPeer == t.exitPeer || len(vpnPeer.subnetRoutes) > 0)
 	t.peersLock.RUnlock()
 	if !ok {
 		t.logger.Infof("Unknown peer %s tried to tunnel packet", peerID)
 		return
 	}
+	if exit && !exitAllowed {
+		t.logger.Infof("Peer %s tried to use us as exit node or router without permission", peerID)
+		return
+	}
 
+	// packets are coalesced by sender, see backgroundOutboundHandler
+	reader := bufio.NewReaderSize(stream, tunnelStreamBufSize)
 	wrappedStream := &io.LimitedReader{}
 	for {
 		packet := t.device.GetTempPacket()
-		packetSize, err := protocol.ReadUint64(stream)
+		packetSize, err := protocol.ReadUint64(reader)
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
 				t.logger.Warnf("read packet size: %v", err)
@@ -71,7 +155,7 @@ func (t *Tunnel) StreamHandler(stream network.Stream) {
 			t.device.PutTempPacket(packet)
 			return
 		}
-		wrappedStream.R = stream
+		wrappedStream.R = reader
 		wrappedStream.N = int64(packetSize)
 		_, err = packet.ReadFrom(wrappedStream)
 		if err != nil {
@@ -79,23 +163,31 @@ func (t *Tunnel) StreamHandler(stream network.Stream) {
 			t.device.PutTempPacket(packet)
 			return
 		}
+		// the stream isn't read while waiting, so the peer is slowed down by flow control
+		_ = t.shaper.WaitDownload(t.ctx, peerID, len(packet.Packet))
+
+		if !packet.Parse() {
+			t.logger.Warnf("got invalid packet from peerID (%s) local ip (%s)", peerID, vpnPeer.localIP)
+			t.device.PutTempPacket(packet)
+			continue
+		}
 
 		t.peersLock.RLock()
 		vpnPeer, ok := t.peerIDToPeer[peerID]
+		t.peersLock.RUnlock()
 		if !ok {
 			t.device.PutTempPacket(packet)
-			t.peersLock.RUnlock()
 			return
 		}
 
-		select {
-		case vpnPeer.inboundCh <- packet:
-		default:
+		// peers share workers, so packets are queued fairly between peers, see fairQueue
+		inboundQueue := t.inboundQueues[packet.FlowHash()%uint32(len(t.inboundQueues))]
+		dropped := inboundQueue.Push(inboundPacket{peer: vpnPeer, packet: packet, exit: exit})
+		if dropped != nil {
 			// REMOVE
-			t.logger.Warnf("inbound reader dropped packet, len %d", len(packet.Packet))
-			t.device.PutTempPacket(packet)
+			t.logger.Warnf("inbound reader dropped packet, len %d", len(dropped.Packet))
+			t.device.PutTempPacket(dropped)
 		}
-		t.peersLock.RUnlock()
 	}
 }
 
@@ -105,9 +197,14 @@ func (t *Tunnel) RefreshPeersList() {
 
 	t.conf.RLock()
 	defer t.conf.RUnlock()
+	globalKillSwitch := t.conf.VPNConfig.KillSwitch
+	exitPeerID := t.conf.VPNConfig.ExitNodePeerID
+	t.pingResponder.Store(!t.conf.VPNConfig.DisablePingResponder)
 	for _, knownPeer := range t.conf.KnownPeers {
 		peerID := knownPeer.PeerId()
-		if _, ok := t.peerIDToPeer[peerID]; ok {
+		if vpnPeer, ok := t.peerIDToPeer[peerID]; ok {
+			vpnPeer.updateSettings(knownPeer, globalKillSwitch, t.conf.PeerGroups)
+			t.setPeerACL(vpnPeer, knownPeer, t.conf.PeerGroups)
 			continue
 		}
 		localIP := net.ParseIP(knownPeer.IPAddr).To4()
@@ -117,13 +214,20 @@ func (t *Tunnel) RefreshPeersList() {
 		}
 
 		vpnPeer := &VpnPeer{
-			peerID:     peerID,
-			localIP:    localIP,
-			inboundCh:  make(chan *vpn.Packet, packetHandlersChanCap),
-			outboundCh: make(chan *vpn.Packet, packetHandlersChanCap),
+			peerID:            peerID,
+			localIP:           localIP,
+			localIPv6:         t.conf.IPv6FromIPv4(localIP),
+			outboundQueue:     newPriorityQueue(packetHandlersChanCap, &t.qosCounters),
+			exitOutboundQueue: newPriorityQueue(packetHandlersChanCap, &t.qosCounters),
+			acl:               NewACL(),
 		}
+		vpnPeer.updateSettings(knownPeer, globalKillSwitch, t.conf.PeerGroups)
+		t.setPeerACL(vpnPeer, knownPeer, t.conf.PeerGroups)
 		t.peerIDToPeer[peerID] = vpnPeer
 		t.netIPToPeer[string(localIP)] = vpnPeer
+		if vpnPeer.localIPv6 != nil {
+			t.netIPToPeer[string(vpnPeer.localIPv6)] = vpnPeer
+		}
 		vpnPeer.Start(t)
 	}
 
@@ -135,10 +239,142 @@ func (t *Tunnel) RefreshPeersList() {
 		vpnPeer.Close(t)
 		delete(t.peerIDToPeer, vpnPeer.peerID)
 		delete(t.netIPToPeer, string(vpnPeer.localIP))
+		delete(t.netIPToPeer, string(vpnPeer.localIPv6))
+		t.flows.RemovePeer(vpnPeer.peerID.String())
+	}
+
+	t.exitPeer = nil
+	if knownPeer, exists := t.conf.KnownPeers[exitPeerID]; exists && knownPeer.AllowedUsingAsExitNode {
+		t.exitPeer = t.peerIDToPeer[knownPeer.PeerId()]
+	}
+
+	peerRoutes := subnetRoutes(t.conf, t.device.Network())
+	t.subnetRoutes = nil
+	for _, vpnPeer := range t.peerIDToPeer {
+		vpnPeer.subnetRoutes = peerRoutes[vpnPeer.peerID.String()]
+		for _, network := range vpnPeer.subnetRoutes {
+			t.subnetRoutes = append(t.subnetRoutes, subnetRoute{network: network, peer: vpnPeer})
+		}
 	}
+	t.advertisedRoutes = parseNetworks(t.conf.VPNConfig.AdvertisedRoutes)
+}
+
+func (t *Tunnel) setPeerACL(vpnPeer *VpnPeer, knownPeer config.KnownPeer, groups map[string]config.PeerGroup) {
+	err := vpnPeer.acl.SetRules(knownPeer.EffectiveACL(groups))
+	if err != nil {
+		t.logger.Errorf("Known peer %q has invalid acl in conf, all traffic with it is denied: %v", knownPeer.DisplayName(), err)
+	}
+}
+
+// RefreshPacketFilter applies packet filter from config to the interface reader.
+func (t *Tunnel) RefreshPacketFilter() {
+	t.conf.RLock()
+	filterConfig := t.conf.VPNConfig.PacketFilter
+	t.conf.RUnlock()
+
+	filter := vpn.PacketFilter{
+		DropIPv6:      filterConfig.DropIPv6,
+		DropMulticast: filterConfig.DropMulticast,
+	}
+	for _, proto := range filterConfig.DropProtocols {
+		if proto < 0 || proto > 255 {
+			t.logger.Errorf("Invalid IP protocol %d in packet filter in conf, it's ignored", proto)
+			continue
+		}
+		filter.DropProtocols = append(filter.DropProtocols, uint8(proto))
+	}
+	t.device.SetPacketFilter(filter)
+}
+
+// RefreshQoS applies QoS rules from config to packets sent to peers. Invalid rules are ignored.
+func (t *Tunnel) RefreshQoS() {
+	t.conf.RLock()
+	qosConfig := t.conf.VPNConfig.QoS
+	t.conf.RUnlock()
+
+	rules, err := compileQoSRules(qosConfig.Rules)
+	if err != nil {
+		t.logger.Errorf("Invalid QoS rules in conf, they are ignored: %v", err)
+	}
+	t.qos.Store(&qosClassifier{disabled: qosConfig.Disabled, rules: rules})
+}
+
+// QoSStats returns numbers of queued, sent and dropped packets to peers by priority from high to low.
+func (t *Tunnel) QoSStats() []QoSClassStats {
+	var queued [vpn.PriorityClasses]int
+	t.peersLock.RLock()
+	for _, vpnPeer := range t.peerIDToPeer {
+		vpnPeer.outboundQueue.queuedByClass(&queued)
+		vpnPeer.exitOutboundQueue.queuedByClass(&queued)
+	}
+	t.peersLock.RUnlock()
+
+	stats := make([]QoSClassStats, 0, vpn.PriorityClasses)
+	for p := vpn.PriorityHigh; ; p-- {
+		stats = append(stats, QoSClassStats{
+			Priority: p.String(),
+			Queued:   queued[p],
+			Sent:     t.qosCounters[p].sent.Load(),
+			Dropped:  t.qosCounters[p].dropped.Load(),
+		})
+		if p == vpn.PriorityLow {
+			break
+		}
+	}
+	return stats
+}
+
+// SetPaused brings the vpn interface down and drops its traffic until it's resumed, connections to peers are kept.
+// See vpn.Device.SetPaused.
+func (t *Tunnel) SetPaused(paused bool) error {
+	return t.device.SetPaused(paused)
+}
+
+// IsPaused returns true if the vpn interface is paused by SetPaused.
+func (t *Tunnel) IsPaused() bool {
+	return t.device.IsPaused()
+}
+
+// PacketFilterStats returns numbers of packets dropped by packet filter.
+func (t *Tunnel) PacketFilterStats() vpn.PacketFilterStats {
+	return t.device.PacketFilterStats()
+}
+
+// Flows returns active flows through the tunnel, the most recent first.
+func (t *Tunnel) Flows() []vpn.Flow {
+	return t.flows.Flows()
+}
+
+// Drain waits until packets queued for peers and for the interface are processed, so they aren't dropped by Close.
+// Packets are still accepted meanwhile, so under constant traffic it returns when ctx is done.
+func (t *Tunnel) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(tunnelDrainInterval)
+	defer ticker.Stop()
+	for t.queuedPackets() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (t *Tunnel) queuedPackets() int {
+	t.peersLock.RLock()
+	queued := 0
+	for _, vpnPeer := range t.peerIDToPeer {
+		queued += vpnPeer.outboundQueue.Len() + vpnPeer.exitOutboundQueue.Len()
+	}
+	t.peersLock.RUnlock()
+	for _, inboundQueue := range t.inboundQueues {
+		queued += inboundQueue.Len()
+	}
+	return queued
 }
 
 func (t *Tunnel) Close() {
+	t.cancel()
 	t.peersLock.Lock()
 	defer t.peersLock.Unlock()
 
@@ -146,36 +382,117 @@ func (t *Tunnel) Close() {
 		vpnPeer.Close(t)
 		delete(t.peerIDToPeer, vpnPeer.peerID)
 		delete(t.netIPToPeer, string(vpnPeer.localIP))
+		delete(t.netIPToPeer, string(vpnPeer.localIPv6))
+	}
+	t.exitPeer = nil
+	t.subnetRoutes = nil
+	for _, inboundQueue := range t.inboundQueues {
+		inboundQueue.Close(t.device.PutTempPacket)
 	}
 }
 
-func (t *Tunnel) backgroundReadPackets() {
+// backgroundReadPackets sends packets read from the interface to peers. Workers are run for each channel of
+// vpn.Device.OutboundChans.
+func (t *Tunnel) backgroundReadPackets(outboundCh <-chan *vpn.Packet) {
 	// TODO: batch read
-	for packet := range t.device.OutboundChan() {
+	for packet := range outboundCh {
 		t.peersLock.RLock()
 		vpnPeer, ok := t.netIPToPeer[string(packet.Dst)]
+		exit := false
+		if ok {
+			// reply from the internet or our subnet to the peer which uses us as exit node or router
+			exit = !t.device.IsLocalAddr(packet.Src) && vpnPeer.exitAllowed.Load()
+		} else if routePeer := t.routePeer(packet.Dst); routePeer != nil {
+			vpnPeer, ok, exit = routePeer, true, true
+		}
 		if !ok {
+			t.peersLock.RUnlock()
+			t.writeUnreachable(packet, vpn.ICMPCodeHostUnreachable)
+			t.device.PutTempPacket(packet)
+			continue
+		}
+		if vpnPeer.killSwitch.Load() && !t.isPathUp(vpnPeer) {
+			t.peersLock.RUnlock()
+			t.writeUnreachable(packet, vpn.ICMPCodeAdminProhibited)
 			t.device.PutTempPacket(packet)
+			continue
+		}
+		if !vpnPeer.acl.Allow(packet, true) {
 			t.peersLock.RUnlock()
+			t.writeUnreachable(packet, vpn.ICMPCodeAdminProhibited)
+			t.device.PutTempPacket(packet)
 			continue
 		}
+		vpnPeer.clampMSS(packet)
 
-		select {
-		case vpnPeer.outboundCh <- packet:
-		default:
-			t.device.PutTempPacket(packet)
+		t.flows.Track(packet, vpnPeer.peerID.String(), true)
+		outboundQueue := vpnPeer.outboundQueue
+		if exit {
+			outboundQueue = vpnPeer.exitOutboundQueue
+		}
+		// packets of lower priority are dropped while the queue of the peer is full
+		dropped := outboundQueue.Push(packet, t.qos.Load().classify(packet))
+		if dropped != nil {
+			t.device.PutTempPacket(dropped)
 		}
 		t.peersLock.RUnlock()
 	}
 }
 
-func (t *Tunnel) makeTunnelStream(ctx context.Context, peerID peer.ID) (network.Stream, error) {
+// routePeer returns the peer which advertises network with ip or our exit node for internet addresses,
+// should be called with peersLock.
+func (t *Tunnel) routePeer(ip net.IP) *VpnPeer {
+	if routePeer := t.subnetRoutePeer(ip); routePeer != nil {
+		return routePeer
+	}
+	if t.exitPeer != nil && t.device.IsExitDestination(ip) {
+		return t.exitPeer
+	}
+	return nil
+}
+
+// subnetRoutePeer returns the peer which advertises network with ip, should be called with peersLock.
+func (t *Tunnel) subnetRoutePeer(ip net.IP) *VpnPeer {
+	for _, route := range t.subnetRoutes {
+		if route.network.Contains(ip) {
+			return route.peer
+		}
+	}
+	return nil
+}
+
+// writeUnreachable notifies local stack that packet can't be delivered, because the peer is unknown, offline or blocked.
+func (t *Tunnel) writeUnreachable(packet *vpn.Packet, code uint8) {
+	err := t.device.WriteICMPUnreachable(packet, code)
+	if err != nil && !errors.Is(err, vpn.ErrInterfaceDown) {
+		t.logger.Warnf("write icmp unreachable for %s: %v", packet.Dst, err)
+	}
+}
+
+// isPathUp checks that the peer has confirmed our friendship and we have an authenticated connection to it.
+func (t *Tunnel) isPathUp(vpnPeer *VpnPeer) bool {
+	return vpnPeer.confirmed.Load() && t.p2p.IsConnected(vpnPeer.peerID)
+}
+
+func (t *Tunnel) onInterfaceStateChanged(up bool) {
+	if !up {
+		// packets queued for peers were read before the interface went down
+		t.peersLock.RLock()
+		for _, vpnPeer := range t.peerIDToPeer {
+			vpnPeer.drainOutbound(t)
+		}
+		t.peersLock.RUnlock()
+	}
+	_ = t.stateEmitter.Emit(awlevent.VPNInterfaceStateChanged{Up: up, Paused: t.device.IsPaused()})
+}
+
+func (t *Tunnel) makeTunnelStream(ctx context.Context, peerID peer.ID, method libp2pProtocol.ID) (network.Stream, error) {
 	err := t.p2p.ConnectPeer(ctx, peerID)
 	if err != nil {
 		return nil, err
 	}
 
-	stream, err := t.p2p.NewStream(ctx, peerID, protocol.TunnelPacketMethod)
+	stream, err := t.p2p.NewStream(ctx, peerID, method)
 	if err != nil {
 		return nil, err
 	}
@@ -183,53 +500,108 @@ func (t *Tunnel) makeTunnelStream(ctx context.Context, peerID peer.ID) (network.
 }
 
 type VpnPeer struct {
-	peerID     peer.ID
-	localIP    net.IP
-	inboundCh  chan *vpn.Packet
-	outboundCh chan *vpn.Packet // from us to remote
+	peerID        peer.ID
+	localIP       net.IP
+	localIPv6     net.IP
+	outboundQueue *priorityQueue // from us to remote
+	// exit queue is for packets to the internet when one of us is exit node,
+	// and to subnets advertised by one of us
+	exitOutboundQueue *priorityQueue
+	killSwitch        atomic.Bool
+	confirmed         atomic.Bool
+	// exitAllowed is true if we allow the peer to use us as exit node
+	exitAllowed atomic.Bool
+	// mtu of the path to the peer, zero if it's not limited
+	mtu atomic.Int32
+	// subnetRoutes are networks advertised by the peer, guarded by Tunnel.peersLock
+	subnetRoutes []*net.IPNet
+	// acl filters all traffic with the peer, including exit and subnet traffic
+	acl *ACL
+}
+
+func (vp *VpnPeer) updateSettings(knownPeer config.KnownPeer, globalKillSwitch bool, groups map[string]config.PeerGroup) {
+	vp.killSwitch.Store(globalKillSwitch || knownPeer.KillSwitch)
+	vp.confirmed.Store(knownPeer.Confirmed && !knownPeer.Declined)
+	vp.exitAllowed.Store(knownPeer.ExitNodeAllowed(groups))
+	vp.mtu.Store(int32(knownPeer.MTU))
+}
+
+// clampMSS fits TCP segments of connections with the peer to its path MTU.
+func (vp *VpnPeer) clampMSS(packet *vpn.Packet) {
+	if mtu := vp.mtu.Load(); mtu > 0 {
+		packet.ClampTCPMSS(int(mtu))
+	}
 }
 
 // TODO: remove Tunnel from VpnPeer dependencies
 func (vp *VpnPeer) Start(t *Tunnel) {
-	go vp.backgroundInboundHandler(t)
-	go vp.backgroundOutboundHandler(t)
+	go vp.backgroundOutboundHandler(t, vp.outboundQueue, false)
+	go vp.backgroundOutboundHandler(t, vp.exitOutboundQueue, true)
+}
+
+func (vp *VpnPeer) drainOutbound(t *Tunnel) {
+	vp.outboundQueue.Drain(t.device.PutTempPacket)
+	vp.exitOutboundQueue.Drain(t.device.PutTempPacket)
 }
 
 func (vp *VpnPeer) Close(t *Tunnel) {
-	close(vp.inboundCh)
-	close(vp.outboundCh)
-	for packet := range vp.inboundCh {
-		t.device.PutTempPacket(packet)
-	}
-	for packet := range vp.outboundCh {
-		t.device.PutTempPacket(packet)
-	}
+	vp.outboundQueue.Close(t.device.PutTempPacket)
+	vp.exitOutboundQueue.Close(t.device.PutTempPacket)
 }
 
-func (vp *VpnPeer) backgroundOutboundHandler(t *Tunnel) {
+// backgroundOutboundHandler sends packets from outboundQueue to the peer. Path events are emitted only for VPN traffic,
+// exit traffic goes through separate stream.
+func (vp *VpnPeer) backgroundOutboundHandler(t *Tunnel, outboundQueue *priorityQueue, exit bool) {
 	const (
 		maxPacketsPerStream = 1024 * 1024 * 8 / vpn.InterfaceMTU
+		maxCoalescedPackets = 64
 		idleStreamTimeout   = 10 * time.Second
 	)
 	var (
 		stream                  network.Stream
 		currentPacketsForStream int
+		method                  = protocol.TunnelPacketMethod
 	)
-	sendPacket := func(packet *vpn.Packet) (err error) {
+	if exit {
+		method = protocol.TunnelExitPacketMethod
+	}
+	// lastPath is the last emitted state, so reopened streams don't emit events until the path goes down or changes
+	var lastPath *awlevent.VPNPathChanged
+	emitPathChanged := func(stream network.Stream, err error) {
+		if exit {
+			return
+		}
+		evt := awlevent.VPNPathChanged{PeerID: vp.peerID.String(), Up: err == nil}
+		if stream != nil {
+			evt.ConnectionType = p2p.ConnectionType([]network.Conn{stream.Conn()})
+		}
+		if lastPath != nil && lastPath.Up == evt.Up && lastPath.ConnectionType == evt.ConnectionType {
+			return
+		}
+		if err != nil {
+			evt.Error = err.Error()
+		}
+		lastPath = &evt
+		_ = t.pathEmitter.Emit(evt)
+	}
+	buf := make([]byte, 0, tunnelStreamBufSize)
+	// sendPackets coalesces packets into one write
+	sendPackets := func(packets []*vpn.Packet) (err error) {
 		if stream == nil {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-			stream, err = t.makeTunnelStream(ctx, vp.peerID)
+			ctx, cancel := context.WithTimeout(t.ctx, time.Second)
+			stream, err = t.makeTunnelStream(ctx, vp.peerID, method)
 			cancel()
 			if err != nil {
+				emitPathChanged(nil, err)
 				return fmt.Errorf("make tunnel stream: %v", err)
 			}
+			emitPathChanged(stream, nil)
 		}
-		// TODO: write packet len and packet data in one stream.Write - probably it's much more efficient
-		err = protocol.WriteUint64(stream, uint64(len(packet.Packet)))
-		if err != nil {
-			return err
+		buf = buf[:0]
+		for _, packet := range packets {
+			buf = protocol.AppendTunnelPacket(buf, packet.Packet)
 		}
-		_, err = stream.Write(packet.Packet)
+		_, err = stream.Write(buf)
 		return err
 	}
 
@@ -244,47 +616,153 @@ func (vp *VpnPeer) backgroundOutboundHandler(t *Tunnel) {
 	defer closeStream()
 	idleTicker := time.NewTicker(idleStreamTimeout)
 	defer idleTicker.Stop()
+	pending := make([]*vpn.Packet, 0, maxCoalescedPackets)
 	for {
 		select {
-		case packet, open := <-vp.outboundCh:
+		case _, open := <-outboundQueue.Notify():
 			if !open {
 				return
 			}
-			if currentPacketsForStream == maxPacketsPerStream {
+		case <-idleTicker.C:
+			if outboundQueue.Len() == 0 {
 				closeStream()
 			}
-			currentPacketsForStream += 1
-			err := sendPacket(packet)
-			if err != nil {
-				t.logger.Warnf("send packet to peerID (%s) local ip (%s): %v", vp.peerID, vp.localIP, err)
+			continue
+		}
+
+		for {
+			// packets which are already queued are sent together, higher priorities first
+			pending = outboundQueue.Pop(pending[:0], maxCoalescedPackets, tunnelStreamBufSize)
+			if len(pending) == 0 {
+				break
+			}
+			pendingSize := 0
+			for _, packet := range pending {
+				pendingSize += len(packet.Packet)
+			}
+
+			// packets from the interface are dropped while queue of the peer is full, see backgroundReadPackets
+			_ = t.shaper.WaitUpload(t.ctx, vp.peerID, pendingSize)
+
+			if currentPacketsForStream >= maxPacketsPerStream {
 				closeStream()
 			}
-			t.device.PutTempPacket(packet)
-		case <-idleTicker.C:
-			if len(vp.outboundCh) == 0 {
+			currentPacketsForStream += len(pending)
+			hadStream := stream != nil
+			err := sendPackets(pending)
+			if err != nil {
+				t.logger.Warnf("send packets to peerID (%s) local ip (%s): %v", vp.peerID, vp.localIP, err)
 				closeStream()
 			}
+			for i, packet := range pending {
+				if err != nil && !hadStream {
+					// failed to open stream, so the peer is offline
+					t.writeUnreachable(packet, vpn.ICMPCodeHostUnreachable)
+				}
+				t.device.PutTempPacket(packet)
+				pending[i] = nil
+			}
 		}
 	}
 }
 
-func (vp *VpnPeer) backgroundInboundHandler(t *Tunnel) {
+// backgroundInboundWorker writes packets from peers to the interface, packets are parsed before.
+// Packets which are already queued are written together, see vpn.WriteBatch.
+func (t *Tunnel) backgroundInboundWorker(inboundQueue *fairQueue) {
+	batch := t.device.NewWriteBatch()
+	pending := make([]inboundPacket, 0, vpn.MaxWriteBatch)
+	added := make([]bool, 0, vpn.MaxWriteBatch)
 	for {
-		packet, open := <-vp.inboundCh
+		var open bool
+		pending, open = inboundQueue.Pop(pending[:0], vpn.MaxWriteBatch)
 		if !open {
 			return
 		}
-		ok := packet.Parse()
-		if !ok {
-			t.logger.Warnf("got invalid packet from peerID (%s) local ip (%s)", vp.peerID, vp.localIP)
-			t.device.PutTempPacket(packet)
-			continue
+
+		added = added[:0]
+		for _, inbound := range pending {
+			if inbound.exit {
+				added = append(added, inbound.peer.handleExitInbound(t, batch, inbound.packet))
+			} else {
+				added = append(added, inbound.peer.handleInbound(t, batch, inbound.packet))
+			}
 		}
-		err := t.device.WritePacket(packet, vp.localIP)
-		if err != nil {
-			t.logger.Warnf("write packet to vpn: %v", err)
+		err := batch.Flush()
+		if err != nil && !errors.Is(err, vpn.ErrInterfaceDown) {
+			t.logger.Warnf("write packets to vpn: %v", err)
+		}
+		for i, inbound := range pending {
+			if added[i] && err == nil {
+				t.flows.Track(inbound.packet, inbound.peer.peerID.String(), false)
+			}
+			t.device.PutTempPacket(inbound.packet)
+			pending[i] = inboundPacket{}
 		}
+	}
+}
 
-		t.device.PutTempPacket(packet)
+// handleInbound adds packet to batch, it returns false if the packet is dropped or answered, see pingResponder.
+func (vp *VpnPeer) handleInbound(t *Tunnel, batch *vpn.WriteBatch, packet *vpn.Packet) bool {
+	if !vp.acl.Allow(packet, false) {
+		return false
+	}
+	if t.pingResponder.Load() {
+		if reply := t.device.EchoReply(packet); reply != nil {
+			dropped := vp.outboundQueue.Push(reply, t.qos.Load().classify(reply))
+			if dropped != nil {
+				t.device.PutTempPacket(dropped)
+			}
+			return false
+		}
+	}
+	vp.clampMSS(packet)
+	err := batch.WritePacket(packet, vp.localIP, vp.localIPv6)
+	if err != nil && !errors.Is(err, vpn.ErrInterfaceDown) && !errors.Is(err, vpn.ErrIPv6Disabled) {
+		t.logger.Warnf("write packet to vpn: %v", err)
+	}
+	return err == nil
+}
+
+// handleExitInbound writes replies from the internet if the peer is our exit node or from the peer's subnets,
+// otherwise packets to our advertised subnets or to the internet if we allow the peer to use us as exit node and router.
+// Packets which we would route back to the peer are dropped as a routing loop, e.g. the peer accepts our public route
+// and uses us as exit node. Longer loops are stopped by TTL, see vpn.ErrTTLExpired.
+func (vp *VpnPeer) handleExitInbound(t *Tunnel, batch *vpn.WriteBatch, packet *vpn.Packet) bool {
+	if !vp.acl.Allow(packet, false) {
+		return false
+	}
+	vp.clampMSS(packet)
+
+	t.peersLock.RLock()
+	isExitPeer := t.exitPeer == vp
+	fromPeerSubnet := containsIP(vp.subnetRoutes, packet.Src)
+	toOurSubnet := containsIP(t.advertisedRoutes, packet.Dst)
+	loop := t.routePeer(packet.Dst) == vp
+	t.peersLock.RUnlock()
+	var err error
+	switch {
+	case isExitPeer || fromPeerSubnet:
+		err = batch.WriteExitReplyPacket(packet)
+	case !vp.exitAllowed.Load() || !toOurSubnet && !t.device.IsExitDestination(packet.Dst):
+		return false
+	case loop:
+		t.logger.Warnf("drop packet from peer %s to %s: routing loop, the peer routes it through us and we route it back",
+			vp.peerID, packet.Dst)
+		return false
+	default:
+		err = batch.WriteExitPacket(packet, vp.localIP)
+	}
+	if errors.Is(err, vpn.ErrTTLExpired) {
+		if reply := t.device.TimeExceeded(packet); reply != nil {
+			dropped := vp.exitOutboundQueue.Push(reply, t.qos.Load().classify(reply))
+			if dropped != nil {
+				t.device.PutTempPacket(dropped)
+			}
+		}
+		return false
+	}
+	if err != nil && !errors.Is(err, vpn.ErrInterfaceDown) && !errors.Is(err, vpn.ErrIPv6Disabled) {
+		t.logger.Warnf("write exit packet to vpn: %v", err)
 	}
+	return err == nil
 }
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"github.com/anywherelan/awl/storage"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	usageSampleInterval = time.Minute
+	usageBucketDuration = time.Hour
+	usageRetention      = 90 * 24 * time.Hour
+
+	usageStorageNamespace = "usage"
+	usageTrafficPrefix    = "/traffic"
+	usageSessionsPrefix   = "/sessions"
+)
+
+// TrafficRecord is traffic of the peer for one hour starting at Start.
+type TrafficRecord struct {
+	Start            time.Time
+	PeerID           string
+	BytesIn          int64
+	BytesOut         int64
+	ConnectedSeconds int64
+}
+
+// ConnectionSession is a period when the peer was connected. DisconnectedAt is zero if it is still connected.
+// Sessions are sampled every minute, so they are not precise.
+type ConnectionSession struct {
+	PeerID         string
+	ConnectedAt    time.Time
+	DisconnectedAt time.Time
+}
+
+type peerTraffic struct {
+	BytesIn          int64
+	BytesOut         int64
+	ConnectedSeconds int64
+}
+
+// Usage records hourly traffic and connection sessions of known peers to storage, so they could be exported for reports.
+type Usage struct {
+	p2p    P2p
+	conf   *config.Config
+	store  ds.Batching
+	logger *log.ZapEventLogger
+	now    func() time.Time
+
+	lock         sync.Mutex
+	lastCollect  time.Time
+	lastTotals   map[peer.ID]metrics.Stats
+	bucketStart  time.Time
+	bucket       map[string]peerTraffic
+	openSessions map[peer.ID]time.Time
+}
+
+func NewUsage(p2pService P2p, conf *config.Config, s storage.Storage) *Usage {
+	return &Usage{
+		p2p:          p2pService,
+		conf:         conf,
+		store:        storage.Namespace(s, usageStorageNamespace),
+		logger:       log.Logger("awl/service/usage"),
+		now:          time.Now,
+		lastTotals:   make(map[peer.ID]metrics.Stats),
+		bucket:       make(map[string]peerTraffic),
+		openSessions: make(map[peer.ID]time.Time),
+	}
+}
+
+func (u *Usage) BackgroundCollect(ctx context.Context) {
+	ticker := time.NewTicker(usageSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		u.collect()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stores open sessions as disconnected now, so they are not lost after restart.
+func (u *Usage) Close() {
+	u.collect()
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	now := u.now()
+	for peerID, connectedAt := range u.openSessions {
+		u.saveSession(ConnectionSession{PeerID: peerID.String(), ConnectedAt: connectedAt, DisconnectedAt: now})
+		delete(u.openSessions, peerID)
+	}
+}
+
+// Traffic returns hourly traffic records which overlap [from, to], ordered by time and peer.
+func (u *Usage) Traffic(from, to time.Time) ([]TrafficRecord, error) {
+	u.collect()
+
+	results, err := u.store.Query(context.Background(), dsq.Query{Prefix: usageTrafficPrefix})
+	if err != nil {
+		return nil, fmt.Errorf("query traffic: %v", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("query traffic: %v", err)
+	}
+
+	records := make([]TrafficRecord, 0)
+	for _, entry := range entries {
+		start, ok := parseUsageKeyTime(entry.Key)
+		if !ok || !overlaps(start, start.Add(usageBucketDuration-time.Second), from, to) {
+			continue
+		}
+		var bucket map[string]peerTraffic
+		err = json.Unmarshal(entry.Value, &bucket)
+		if err != nil {
+			u.logger.Warnf("decode traffic %s: %v", entry.Key, err)
+			continue
+		}
+		for peerID, traffic := range bucket {
+			records = append(records, TrafficRecord{
+				Start:            start,
+				PeerID:           peerID,
+				BytesIn:          traffic.BytesIn,
+				BytesOut:         traffic.BytesOut,
+				ConnectedSeconds: traffic.ConnectedSeconds,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Start.Equal(records[j].Start) {
+			return records[i].Start.Before(records[j].Start)
+		}
+		return records[i].PeerID < records[j].PeerID
+	})
+
+	return records, nil
+}
+
+// Sessions returns connection sessions which overlap [from, to], including currently open ones, ordered by time.
+func (u *Usage) Sessions(from, to time.Time) ([]ConnectionSession, error) {
+	u.collect()
+
+	results, err := u.store.Query(context.Background(), dsq.Query{Prefix: usageSessionsPrefix})
+	if err != nil {
+		return nil, fmt.Errorf("query sessions: %v", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("query sessions: %v", err)
+	}
+
+	sessions := make([]ConnectionSession, 0, len(entries))
+	for _, entry := range entries {
+		var session ConnectionSession
+		err = json.Unmarshal(entry.Value, &session)
+		if err != nil {
+			u.logger.Warnf("decode session %s: %v", entry.Key, err)
+			continue
+		}
+		if overlaps(session.ConnectedAt, session.DisconnectedAt, from, to) {
+			sessions = append(sessions, session)
+		}
+	}
+	u.lock.Lock()
+	for peerID, connectedAt := range u.openSessions {
+		if overlaps(connectedAt, time.Time{}, from, to) {
+			sessions = append(sessions, ConnectionSession{PeerID: peerID.String(), ConnectedAt: connectedAt})
+		}
+	}
+	u.lock.Unlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].ConnectedAt.Equal(sessions[j].ConnectedAt) {
+			return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+		}
+		return sessions[i].PeerID < sessions[j].PeerID
+	})
+
+	return sessions, nil
+}
+
+func (u *Usage) collect() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	now := u.now()
+	var connectedSeconds int64
+	if !u.lastCollect.IsZero() {
+		elapsed := now.Sub(u.lastCollect)
+		// the app was suspended or collection was delayed, don't count uncertain time
+		if elapsed > 2*usageSampleInterval {
+			elapsed = usageSampleInterval
+		}
+		connectedSeconds = int64(elapsed / time.Second)
+	}
+	u.lastCollect = now
+
+	bucketStart := now.Truncate(usageBucketDuration)
+	if !bucketStart.Equal(u.bucketStart) {
+		u.bucketStart = bucketStart
+		u.bucket = u.loadBucket(bucketStart)
+		u.prune(now)
+	}
+
+	known := make(map[peer.ID]struct{})
+	changed := false
+	for _, peerID := range u.conf.KnownPeersIds() {
+		known[peerID] = struct{}{}
+		stats := u.p2p.NetworkStatsForPeer(peerID)
+		last := u.lastTotals[peerID]
+		u.lastTotals[peerID] = stats
+		bytesIn, bytesOut := stats.TotalIn-last.TotalIn, stats.TotalOut-last.TotalOut
+		// counter was reset
+		if bytesIn < 0 || bytesOut < 0 {
+			bytesIn, bytesOut = stats.TotalIn, stats.TotalOut
+		}
+		connected := u.p2p.IsConnected(peerID)
+		u.updateSession(peerID, connected, now)
+		var peerConnectedSeconds int64
+		if connected {
+			peerConnectedSeconds = connectedSeconds
+		}
+		if bytesIn == 0 && bytesOut == 0 && peerConnectedSeconds == 0 {
+			continue
+		}
+
+		traffic := u.bucket[peerID.String()]
+		traffic.BytesIn += bytesIn
+		traffic.BytesOut += bytesOut
+		traffic.ConnectedSeconds += peerConnectedSeconds
+		u.bucket[peerID.String()] = traffic
+		changed = true
+	}
+
+	// peer was removed
+	for peerID := range u.lastTotals {
+		if _, exists := known[peerID]; !exists {
+			delete(u.lastTotals, peerID)
+			u.updateSession(peerID, false, now)
+		}
+	}
+
+	if changed {
+		u.saveBucket()
+	}
+}
+
+func (u *Usage) updateSession(peerID peer.ID, connected bool, now time.Time) {
+	connectedAt, open := u.openSessions[peerID]
+	switch {
+	case connected && !open:
+		u.openSessions[peerID] = now
+	case !connected && open:
+		delete(u.openSessions, peerID)
+		u.saveSession(ConnectionSession{PeerID: peerID.String(), ConnectedAt: connectedAt, DisconnectedAt: now})
+	}
+}
+
+func (u *Usage) loadBucket(start time.Time) map[string]peerTraffic {
+	bucket := make(map[string]peerTraffic)
+	data, err := u.store.Get(context.Background(), usageKey(usageTrafficPrefix, start))
+	if errors.Is(err, ds.ErrNotFound) {
+		return bucket
+	} else if err != nil {
+		u.logger.Errorf("load traffic: %v", err)
+		return bucket
+	}
+	err = json.Unmarshal(data, &bucket)
+	if err != nil {
+		u.logger.Errorf("decode traffic: %v", err)
+		return make(map[string]peerTraffic)
+	}
+	return bucket
+}
+
+func (u *Usage) saveBucket() {
+	data, err := json.Marshal(u.bucket)
+	if err != nil {
+		u.logger.Errorf("encode traffic: %v", err)
+		return
+	}
+	err = u.store.Put(context.Background(), usageKey(usageTrafficPrefix, u.bucketStart), data)
+	if err != nil {
+		u.logger.Errorf("save traffic: %v", err)
+	}
+}
+
+func (u *Usage) saveSession(session ConnectionSession) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		u.logger.Errorf("encode session: %v", err)
+		return
+	}
+	key := usageKey(usageSessionsPrefix, session.ConnectedAt).ChildString(session.PeerID)
+	err = u.store.Put(context.Background(), key, data)
+	if err != nil {
+		u.logger.Errorf("save session: %v", err)
+	}
+}
+
+// prune removes records older than retention period.
+func (u *Usage) prune(now time.Time) {
+	ctx := context.Background()
+	for _, prefix := range []string{usageTrafficPrefix, usageSessionsPrefix} {
+		results, err := u.store.Query(ctx, dsq.Query{Prefix: prefix, KeysOnly: true})
+		if err != nil {
+			u.logger.Errorf("query usage: %v", err)
+			continue
+		}
+		entries, err := results.Rest()
+		if err != nil {
+			u.logger.Errorf("query usage: %v", err)
+			continue
+		}
+		for _, entry := range entries {
+			t, ok := parseUsageKeyTime(entry.Key)
+			if ok && now.Sub(t) > usageRetention {
+				_ = u.store.Delete(ctx, ds.NewKey(entry.Key))
+			}
+		}
+	}
+}
+
+// usageKey keeps keys sorted by time, e.g. /traffic/000001700000000.
+func usageKey(prefix string, t time.Time) ds.Key {
+	return ds.NewKey(prefix).ChildString(fmt.Sprintf("%015d", t.Unix()))
+}
+
+func parseUsageKeyTime(key string) (time.Time, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	if len(parts) < 2 {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// overlaps reports whether period [start, end] overlaps [from, to]. Zero end, from and to are not limited.
+func overlaps(start, end, from, to time.Time) bool {
+	if !to.IsZero() && start.After(to) {
+		return false
+	}
+	if !from.IsZero() && !end.IsZero() && end.Before(from) {
+		return false
+	}
+	return true
+}
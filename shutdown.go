@@ -0,0 +1,159 @@
+package awl
+
+import (
+	"context"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+)
+
+// Stages of Application.Close are limited by these timeouts, context of the stage is cancelled if it isn't
+// finished in time. The next stage is started only after the previous one returns, since it depends on it.
+const (
+	shutdownStopAcceptingTimeout = 2 * time.Second
+	shutdownDrainTimeout         = 2 * time.Second
+	shutdownNotifyPeersTimeout   = 3 * time.Second
+	shutdownCloseTUNTimeout      = 3 * time.Second
+	shutdownCloseHostTimeout     = 5 * time.Second
+	shutdownSaveTimeout          = 3 * time.Second
+)
+
+type shutdownStage struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context)
+}
+
+// Close stops the app in stages, each stage depends on the previous ones being finished:
+// new api requests and streams are refused, queued packets are sent, sessions with peers are closed,
+// then the interface, the host and at last config and storage are saved, since they are used until the end.
+func (a *Application) Close() {
+	for _, stage := range a.shutdownStages() {
+		a.runShutdownStage(stage)
+	}
+}
+
+func (a *Application) shutdownStages() []shutdownStage {
+	return []shutdownStage{
+		{name: "stop accepting streams", timeout: shutdownStopAcceptingTimeout, run: a.stopAccepting},
+		{name: "drain forwarding", timeout: shutdownDrainTimeout, run: a.drainForwarding},
+		{name: "notify peers", timeout: shutdownNotifyPeersTimeout, run: a.notifyPeers},
+		{name: "close tun", timeout: shutdownCloseTUNTimeout, run: a.closeTUN},
+		{name: "close host", timeout: shutdownCloseHostTimeout, run: a.closeHost},
+		{name: "save config", timeout: shutdownSaveTimeout, run: a.saveState},
+	}
+}
+
+// runShutdownStage cancels context of the stage after its timeout and waits until the stage returns,
+// so stages don't run alongside each other.
+func (a *Application) runShutdownStage(stage shutdownStage) {
+	ctx, cancel := context.WithTimeout(context.Background(), stage.timeout)
+	defer cancel()
+	started := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stage.run(ctx)
+	}()
+
+	select {
+	case <-done:
+		a.logger.Debugf("shutdown: %s finished in %s", stage.name, time.Since(started).Round(time.Millisecond))
+		return
+	case <-ctx.Done():
+		a.logger.Warnf("shutdown: %s is not finished in %s, waiting for it to stop", stage.name, stage.timeout)
+	}
+	<-done
+	a.logger.Infof("shutdown: %s finished in %s", stage.name, time.Since(started).Round(time.Millisecond))
+}
+
+// stopAccepting stops background jobs and requests to peers, refuses new api requests, streams of peers and proxy connections.
+func (a *Application) stopAccepting(ctx context.Context) {
+	if a.ctxCancel != nil {
+		a.ctxCancel()
+	}
+	if a.Api != nil {
+		err := a.Api.Shutdown(ctx)
+		if err != nil {
+			a.logger.Errorf("closing api server: %v", err)
+		}
+	}
+	if a.Streams != nil {
+		// running tunnel handlers push packets to the interface, so they are finished before closeTUN
+		err := a.Streams.Close(ctx)
+		if err != nil {
+			a.logger.Warnf("waiting for stream handlers: %v", err)
+		}
+	}
+	if a.AuthStatus != nil {
+		a.AuthStatus.Close()
+	}
+	if config.SOCKS5Included && a.SOCKS5 != nil {
+		a.SOCKS5.Close()
+	}
+}
+
+// drainForwarding lets packets which are already queued reach peers and the interface.
+func (a *Application) drainForwarding(ctx context.Context) {
+	if a.Tunnel != nil {
+		err := a.Tunnel.Drain(ctx)
+		if err != nil {
+			a.logger.Warnf("draining tunnel: %v", err)
+		}
+	}
+}
+
+// notifyPeers closes streams of services, so peers see them finished instead of timed out,
+// and records connection sessions while peers are still connected.
+func (a *Application) notifyPeers(_ context.Context) {
+	if a.Usage != nil {
+		a.Usage.Close()
+	}
+	if a.SharedFolder != nil {
+		a.SharedFolder.Close()
+	}
+	if a.Management != nil {
+		a.Management.Close()
+	}
+	if config.FileTransferIncluded && a.FileTransfer != nil {
+		a.FileTransfer.Close()
+	}
+}
+
+func (a *Application) closeTUN(_ context.Context) {
+	if a.Dns != nil {
+		a.Dns.Close()
+	}
+	if a.Tunnel != nil {
+		a.Tunnel.Close()
+	}
+	if a.vpnDevice != nil {
+		err := a.vpnDevice.Close()
+		if err != nil {
+			a.logger.Errorf("closing vpn: %v", err)
+		}
+	}
+}
+
+func (a *Application) closeHost(_ context.Context) {
+	if a.P2p != nil {
+		err := a.P2p.Close()
+		if err != nil {
+			a.logger.Errorf("closing p2p server: %v", err)
+		}
+	}
+}
+
+// saveState is the last stage, since config and storage are changed by other stages, e.g. usage sessions.
+func (a *Application) saveState(_ context.Context) {
+	a.Conf.Save()
+	if a.Storage != nil {
+		err := a.Storage.Close()
+		if err != nil {
+			a.logger.Errorf("closing storage: %v", err)
+		}
+	}
+	if a.LogFile != nil {
+		_ = a.LogFile.Close()
+	}
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Filename of the storage in the app data directory.
+	Filename = "storage_awl.db"
+	// legacyFilename is the JSON file which was used before, it's imported by Open and removed.
+	legacyFilename = "storage_awl.json"
+
+	filesPerm = 0600
+	// openTimeout limits waiting for the file lock, e.g. if another instance of the app is running
+	openTimeout = 5 * time.Second
+)
+
+var bucketName = []byte("data")
+
+// Storage is a persistent key-value store for all awl state except the config.
+// Every service should use its own namespace, see Namespace.
+type Storage interface {
+	ds.Batching
+	// Backup writes all stored data to w, except data of LocalNamespace.
+	Backup(w io.Writer) error
+	// Restore replaces all stored data with data previously written by Backup, data of LocalNamespace is kept.
+	Restore(r io.Reader) error
+}
+
+// Namespace returns a view of the storage with all keys prefixed by name.
+func Namespace(s Storage, name string) ds.Batching {
+	return namespace.Wrap(s, ds.NewKey(name))
+}
+
+// localPrefix is the prefix of LocalNamespace keys.
+var localPrefix = []byte("/local/")
+
+// LocalNamespace is like Namespace, but its data is excluded from Backup and kept by Restore,
+// e.g. data of other peers which shouldn't be sent with our backups.
+func LocalNamespace(s Storage, name string) ds.Batching {
+	return namespace.Wrap(s, ds.NewKey("local").ChildString(name))
+}
+
+func isLocalKey(key []byte) bool {
+	return bytes.HasPrefix(key, localPrefix)
+}
+
+var (
+	_ Storage = (*BoltStorage)(nil)
+	_ Storage = (*MemoryStorage)(nil)
+)
+
+// BoltStorage keeps data in bbolt database file. Every change is committed in its own transaction,
+// so it isn't lost on crash and unchanged data isn't rewritten.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// Open opens storage in dataDir or creates an empty one. Data of the legacy JSON storage is imported.
+func Open(dataDir string) (*BoltStorage, error) {
+	path := filepath.Join(dataDir, Filename)
+	db, err := bolt.Open(path, filesPerm, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open storage file %s: %v", path, err)
+	}
+	config.ChownFileIfNeeded(path)
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init storage: %v", err)
+	}
+
+	s := &BoltStorage{db: db}
+	legacyPath := filepath.Join(dataDir, legacyFilename)
+	err = s.importLegacy(legacyPath)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("import storage file %s: %v", legacyPath, err)
+	}
+
+	return s, nil
+}
+
+func (s *BoltStorage) importLegacy(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = s.Restore(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s *BoltStorage) Get(_ context.Context, key ds.Key) (value []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketName).Get(key.Bytes())
+		if data == nil {
+			return ds.ErrNotFound
+		}
+		// data is valid only during the transaction
+		value = append([]byte{}, data...)
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStorage) Has(ctx context.Context, key ds.Key) (bool, error) {
+	_, err := s.GetSize(ctx, key)
+	if err == ds.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *BoltStorage) GetSize(_ context.Context, key ds.Key) (size int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketName).Get(key.Bytes())
+		if data == nil {
+			return ds.ErrNotFound
+		}
+		size = len(data)
+		return nil
+	})
+	return size, err
+}
+
+// Query collects entries with the prefix in one transaction, the rest of the query is applied in memory.
+func (s *BoltStorage) Query(_ context.Context, q dsq.Query) (dsq.Results, error) {
+	var prefix []byte
+	if cleaned := ds.NewKey(q.Prefix).String(); cleaned != "/" {
+		prefix = []byte(cleaned + "/")
+	}
+	var entries []dsq.Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(bucketName).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			entry := dsq.Entry{Key: string(key), Size: len(value)}
+			if !q.KeysOnly {
+				entry.Value = append([]byte{}, value...)
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dsq.NaiveQueryApply(q, dsq.ResultsWithEntries(q, entries)), nil
+}
+
+func (s *BoltStorage) Put(_ context.Context, key ds.Key, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put(key.Bytes(), value)
+	})
+}
+
+func (s *BoltStorage) Delete(_ context.Context, key ds.Key) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete(key.Bytes())
+	})
+}
+
+func (s *BoltStorage) Batch(_ context.Context) (ds.Batch, error) {
+	return ds.NewBasicBatch(s), nil
+}
+
+// Sync does nothing, since transactions are synced on commit.
+func (s *BoltStorage) Sync(_ context.Context, _ ds.Key) error {
+	return nil
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStorage) Backup(w io.Writer) error {
+	return writeBackup(s, w)
+}
+
+// Restore replaces data in one transaction, so it's either restored completely or not at all.
+func (s *BoltStorage) Restore(r io.Reader) error {
+	data, err := readBackup(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		var removed [][]byte
+		err := bucket.ForEach(func(key, _ []byte) error {
+			if !isLocalKey(key) {
+				removed = append(removed, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range removed {
+			err = bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		for key, value := range data {
+			err = bucket.Put([]byte(key), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MemoryStorage is never saved to disk.
+type MemoryStorage struct {
+	*dssync.MutexDatastore
+}
+
+// NewInMemory returns storage which is never saved to disk.
+func NewInMemory() *MemoryStorage {
+	return &MemoryStorage{MutexDatastore: dssync.MutexWrap(ds.NewMapDatastore())}
+}
+
+func (s *MemoryStorage) Backup(w io.Writer) error {
+	return writeBackup(s, w)
+}
+
+func (s *MemoryStorage) Restore(r io.Reader) error {
+	data, err := readBackup(r)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	ctx := context.Background()
+	mapDatastore := s.Children()[0]
+	results, err := mapDatastore.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !isLocalKey([]byte(entry.Key)) {
+			_ = mapDatastore.Delete(ctx, ds.RawKey(entry.Key))
+		}
+	}
+	for key, value := range data {
+		_ = mapDatastore.Put(ctx, ds.RawKey(key), value)
+	}
+	return nil
+}
+
+// writeBackup encodes entries except local ones as JSON object, the format is kept since backups are stored by peers.
+func writeBackup(d ds.Read, w io.Writer) error {
+	results, err := d.Query(context.Background(), dsq.Query{})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		if !isLocalKey([]byte(entry.Key)) {
+			data[entry.Key] = entry.Value
+		}
+	}
+	return json.NewEncoder(w).Encode(data)
+}
+
+// readBackup returns entries with cleaned keys, local ones are skipped.
+func readBackup(r io.Reader) (map[string][]byte, error) {
+	var data map[string][]byte
+	err := json.NewDecoder(r).Decode(&data)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %v", err)
+	}
+	result := make(map[string][]byte, len(data))
+	for key, value := range data {
+		if cleaned := ds.NewKey(key).String(); !isLocalKey([]byte(cleaned)) {
+			result[cleaned] = value
+		}
+	}
+	return result, nil
+}
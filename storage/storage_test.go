@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltStorage_Persistence(t *testing.T) {
+	a := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	a.NoError(err)
+	dht := Namespace(s, "dht")
+	a.NoError(dht.Put(ctx, ds.NewKey("key"), []byte("value")))
+	a.NoError(s.Close())
+
+	s, err = Open(dir)
+	a.NoError(err)
+	defer s.Close()
+	value, err := s.Get(ctx, ds.NewKey("/dht/key"))
+	a.NoError(err)
+	a.Equal([]byte("value"), value)
+	_, err = s.Get(ctx, ds.NewKey("/dht/other"))
+	a.ErrorIs(err, ds.ErrNotFound)
+}
+
+func TestBoltStorage_Query(t *testing.T) {
+	a := require.New(t)
+	ctx := context.Background()
+
+	s, err := Open(t.TempDir())
+	a.NoError(err)
+	defer s.Close()
+	a.NoError(s.Put(ctx, ds.NewKey("/dht/a"), []byte("1")))
+	a.NoError(s.Put(ctx, ds.NewKey("/dht/b"), []byte("2")))
+	a.NoError(s.Put(ctx, ds.NewKey("/dhtx/c"), []byte("3")))
+	a.NoError(s.Put(ctx, ds.NewKey("/usage/d"), []byte("4")))
+
+	results, err := Namespace(s, "dht").Query(ctx, dsq.Query{})
+	a.NoError(err)
+	entries, err := results.Rest()
+	a.NoError(err)
+	a.Len(entries, 2)
+	results, err = s.Query(ctx, dsq.Query{KeysOnly: true, Orders: []dsq.Order{dsq.OrderByKey{}}})
+	a.NoError(err)
+	entries, err = results.Rest()
+	a.NoError(err)
+	a.Len(entries, 4)
+	a.Equal("/dht/a", entries[0].Key)
+	a.Nil(entries[0].Value)
+}
+
+func TestBoltStorage_ImportLegacy(t *testing.T) {
+	a := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	legacy := NewInMemory()
+	a.NoError(legacy.Put(ctx, ds.NewKey("/usage/key"), []byte("value")))
+	buf := new(bytes.Buffer)
+	a.NoError(legacy.Backup(buf))
+	a.NoError(os.WriteFile(filepath.Join(dir, legacyFilename), buf.Bytes(), filesPerm))
+
+	s, err := Open(dir)
+	a.NoError(err)
+	defer s.Close()
+	value, err := s.Get(ctx, ds.NewKey("/usage/key"))
+	a.NoError(err)
+	a.Equal([]byte("value"), value)
+	a.NoFileExists(filepath.Join(dir, legacyFilename))
+
+	// backups have the same format
+	restored := NewInMemory()
+	buf.Reset()
+	a.NoError(s.Backup(buf))
+	a.NoError(restored.Restore(buf))
+	value, err = restored.Get(ctx, ds.NewKey("/usage/key"))
+	a.NoError(err)
+	a.Equal([]byte("value"), value)
+}
+
+func TestMemoryStorage_BackupRestore(t *testing.T) {
+	a := require.New(t)
+	ctx := context.Background()
+
+	src := NewInMemory()
+	a.NoError(src.Put(ctx, ds.NewKey("/a"), []byte("1")))
+	a.NoError(src.Put(ctx, ds.NewKey("/b/c"), []byte("2")))
+
+	buf := new(bytes.Buffer)
+	a.NoError(src.Backup(buf))
+
+	dst := NewInMemory()
+	a.NoError(dst.Put(ctx, ds.NewKey("/old"), []byte("0")))
+	a.NoError(dst.Restore(buf))
+
+	has, err := dst.Has(ctx, ds.NewKey("/old"))
+	a.NoError(err)
+	a.False(has)
+	value, err := dst.Get(ctx, ds.NewKey("/b/c"))
+	a.NoError(err)
+	a.Equal([]byte("2"), value)
+}
+
+func TestStorage_LocalNamespace(t *testing.T) {
+	a := require.New(t)
+	ctx := context.Background()
+
+	s, err := Open(t.TempDir())
+	a.NoError(err)
+	defer s.Close()
+	a.NoError(LocalNamespace(s, "received").Put(ctx, ds.NewKey("peer"), []byte("local")))
+	a.NoError(Namespace(s, "usage").Put(ctx, ds.NewKey("key"), []byte("value")))
+
+	buf := new(bytes.Buffer)
+	a.NoError(s.Backup(buf))
+	restored := NewInMemory()
+	a.NoError(restored.Restore(bytes.NewReader(buf.Bytes())))
+	_, err = LocalNamespace(restored, "received").Get(ctx, ds.NewKey("peer"))
+	a.ErrorIs(err, ds.ErrNotFound)
+
+	// local data is kept by restore
+	a.NoError(s.Put(ctx, ds.NewKey("/usage/other"), []byte("value")))
+	a.NoError(s.Restore(bytes.NewReader(buf.Bytes())))
+	value, err := LocalNamespace(s, "received").Get(ctx, ds.NewKey("peer"))
+	a.NoError(err)
+	a.Equal([]byte("local"), value)
+	has, err := s.Has(ctx, ds.NewKey("/usage/other"))
+	a.NoError(err)
+	a.False(has)
+}
@@ -0,0 +1,74 @@
+// Package sysservice registers awl as a system service: systemd unit on linux, launchd daemon on macOS
+// and Windows service. Services are started on boot and restarted after crashes.
+package sysservice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	Name        = "awl"
+	DisplayName = "Anywherelan"
+	Description = "Anywherelan p2p mesh vpn"
+	// LogFilename is the file in the data directory with output of the service on macOS and Windows,
+	// systemd keeps it in the journal.
+	LogFilename = "service.log"
+)
+
+var (
+	// ErrUnsupported is returned on platforms without supported service manager.
+	ErrUnsupported = errors.New("system service is not supported on this platform")
+	// ErrNotInstalled is returned when the service is started, stopped or uninstalled before it's installed.
+	ErrNotInstalled = errors.New("service is not installed")
+)
+
+// Options of the installed service.
+type Options struct {
+	// Executable is absolute path of awl binary
+	Executable string
+	// DataDir is absolute path of config directory, it's passed to the service in config.AppDataDirEnvKey
+	DataDir string
+}
+
+// Install registers the service to start on boot or replaces existing one, it isn't started by Install.
+func Install(opts Options) error {
+	if !filepath.IsAbs(opts.Executable) || !filepath.IsAbs(opts.DataDir) {
+		return fmt.Errorf("paths of executable and data directory should be absolute")
+	}
+	err := os.MkdirAll(opts.DataDir, 0750)
+	if err != nil {
+		return fmt.Errorf("create data directory: %v", err)
+	}
+	return install(opts)
+}
+
+// Uninstall stops the service and removes it.
+func Uninstall() error {
+	return uninstall()
+}
+
+func Start() error {
+	return start()
+}
+
+func Stop() error {
+	return stop()
+}
+
+// IsElevated reports whether the process has privileges to manage services.
+func IsElevated() bool {
+	return isElevated()
+}
+
+// DefaultDataDir returns the directory of the executable if it contains config,
+// otherwise system wide directory, e.g. /etc/anywherelan on linux.
+func DefaultDataDir(executable, configFilename string) string {
+	executableDir := filepath.Dir(executable)
+	if _, err := os.Stat(filepath.Join(executableDir, configFilename)); err == nil {
+		return executableDir
+	}
+	return defaultDataDir()
+}
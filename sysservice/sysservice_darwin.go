@@ -0,0 +1,105 @@
+package sysservice
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/anywherelan/awl/config"
+)
+
+const (
+	label     = "com.anywherelan." + Name
+	plistPath = "/Library/LaunchDaemons/" + label + ".plist"
+)
+
+const plistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>%s</key>
+		<string>%s</string>
+	</dict>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+func defaultDataDir() string {
+	return "/Library/Application Support/anywherelan"
+}
+
+func install(opts Options) error {
+	logPath := filepath.Join(opts.DataDir, LogFilename)
+	plist := fmt.Sprintf(plistTemplate, label, escapeXML(opts.Executable), config.AppDataDirEnvKey,
+		escapeXML(opts.DataDir), escapeXML(opts.DataDir), escapeXML(logPath), escapeXML(logPath))
+	//nolint:gosec
+	err := os.WriteFile(plistPath, []byte(plist), 0644)
+	if err != nil {
+		return fmt.Errorf("write launchd plist: %v", err)
+	}
+	return nil
+}
+
+// uninstall stops the service, bootout also unloads it, so it's not started again until boot or Start.
+func uninstall() error {
+	err := checkInstalled()
+	if err != nil {
+		return err
+	}
+	_ = stop()
+	err = os.Remove(plistPath)
+	if err != nil {
+		return fmt.Errorf("remove launchd plist: %v", err)
+	}
+	return nil
+}
+
+func start() error {
+	err := checkInstalled()
+	if err != nil {
+		return err
+	}
+	return runCommand("launchctl", "bootstrap", "system", plistPath)
+}
+
+func stop() error {
+	err := checkInstalled()
+	if err != nil {
+		return err
+	}
+	return runCommand("launchctl", "bootout", "system/"+label)
+}
+
+func checkInstalled() error {
+	if _, err := os.Stat(plistPath); errors.Is(err, os.ErrNotExist) {
+		return ErrNotInstalled
+	}
+	return nil
+}
+
+func escapeXML(value string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
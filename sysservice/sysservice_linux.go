@@ -0,0 +1,120 @@
+package sysservice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/anywherelan/awl/config"
+)
+
+const unitPath = "/etc/systemd/system/" + Name + ".service"
+
+// unitTemplate is the same as the unit of install.sh.
+const unitTemplate = `[Unit]
+Description=%s
+After=network-online.target nss-lookup.target
+Wants=network-online.target nss-lookup.target
+
+[Service]
+Type=simple
+Environment=%s
+WorkingDirectory=%s
+ExecStart=%s
+Restart=always
+RestartSec=5s
+LimitNOFILE=4000
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func defaultDataDir() string {
+	return "/etc/anywherelan"
+}
+
+func install(opts Options) error {
+	err := checkSystemd()
+	if err != nil {
+		return err
+	}
+	//nolint:gosec
+	err = os.WriteFile(unitPath, []byte(unitFile(opts)), 0644)
+	if err != nil {
+		return fmt.Errorf("write unit file: %v", err)
+	}
+	err = runCommand("systemctl", "daemon-reload")
+	if err != nil {
+		return err
+	}
+	return runCommand("systemctl", "enable", Name+".service")
+}
+
+// unitFile quotes the environment assignment and the command, WorkingDirectory= is written as is since systemd
+// takes the whole value as the path and doesn't unquote it.
+func unitFile(opts Options) string {
+	return fmt.Sprintf(unitTemplate, Description, quoteUnitArg(config.AppDataDirEnvKey+"="+opts.DataDir),
+		opts.DataDir, quoteUnitArg(opts.Executable))
+}
+
+func uninstall() error {
+	err := checkInstalled()
+	if err != nil {
+		return err
+	}
+	err = runCommand("systemctl", "disable", "--now", Name+".service")
+	if err != nil {
+		return err
+	}
+	err = os.Remove(unitPath)
+	if err != nil {
+		return fmt.Errorf("remove unit file: %v", err)
+	}
+	return runCommand("systemctl", "daemon-reload")
+}
+
+func start() error {
+	err := checkInstalled()
+	if err != nil {
+		return err
+	}
+	return runCommand("systemctl", "start", Name+".service")
+}
+
+func stop() error {
+	err := checkInstalled()
+	if err != nil {
+		return err
+	}
+	return runCommand("systemctl", "stop", Name+".service")
+}
+
+func checkSystemd() error {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return fmt.Errorf("%w: systemd is not found", ErrUnsupported)
+	}
+	return nil
+}
+
+func checkInstalled() error {
+	err := checkSystemd()
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(unitPath); errors.Is(err, os.ErrNotExist) {
+		return ErrNotInstalled
+	}
+	return nil
+}
+
+// quoteUnitArg quotes the argument of ExecStart= or Environment= if it contains spaces, quotes or backslashes,
+// e.g. paths of data directory.
+func quoteUnitArg(value string) string {
+	if !strings.ContainsAny(value, " \t\"'\\") {
+		return value
+	}
+	return strconv.Quote(value)
+}
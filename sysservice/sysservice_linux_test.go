@@ -0,0 +1,36 @@
+package sysservice
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitFile(t *testing.T) {
+	a := require.New(t)
+
+	unit := unitFile(Options{Executable: "/etc/anywherelan/awl", DataDir: "/etc/anywherelan"})
+	a.Contains(unit, "\nEnvironment=AWL_DATA_DIR=/etc/anywherelan\n")
+	a.Contains(unit, "\nWorkingDirectory=/etc/anywherelan\n")
+	a.Contains(unit, "\nExecStart=/etc/anywherelan/awl\n")
+
+	unit = unitFile(Options{Executable: "/opt/my apps/awl", DataDir: "/var/lib/my awl"})
+	a.Contains(unit, "\nEnvironment=\"AWL_DATA_DIR=/var/lib/my awl\"\n")
+	a.Contains(unit, "\nWorkingDirectory=/var/lib/my awl\n")
+	a.Contains(unit, "\nExecStart=\"/opt/my apps/awl\"\n")
+	a.False(strings.Contains(unit, "%!"))
+}
+
+func TestDefaultDataDir(t *testing.T) {
+	a := require.New(t)
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "awl")
+
+	a.Equal("/etc/anywherelan", DefaultDataDir(executable, "config_awl.json"))
+
+	a.NoError(os.WriteFile(filepath.Join(dir, "config_awl.json"), []byte("{}"), 0o600))
+	a.Equal(dir, DefaultDataDir(executable, "config_awl.json"))
+}
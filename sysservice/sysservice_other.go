@@ -0,0 +1,38 @@
+//go:build !linux && !darwin && !windows
+
+package sysservice
+
+import (
+	"errors"
+	"os"
+)
+
+func defaultDataDir() string {
+	return ""
+}
+
+func install(Options) error {
+	return ErrUnsupported
+}
+
+func uninstall() error {
+	return ErrUnsupported
+}
+
+func start() error {
+	return ErrUnsupported
+}
+
+func stop() error {
+	return ErrUnsupported
+}
+
+func isElevated() bool {
+	return true
+}
+
+func RunElevated(_ []string) error {
+	return errors.New("elevation is not supported on this platform")
+}
+
+func Run(_ chan<- os.Signal) {}
@@ -0,0 +1,45 @@
+//go:build linux || darwin
+
+package sysservice
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func isElevated() bool {
+	return os.Geteuid() == 0
+}
+
+// RunElevated runs the command with args under root with sudo and waits for it.
+func RunElevated(args []string) error {
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return fmt.Errorf("root privileges are required, run the command as root")
+	}
+	//nolint:gosec
+	cmd := exec.Command(sudo, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Run does nothing, systemd and launchd stop the service with SIGTERM and redirect its output themselves.
+func Run(_ chan<- os.Signal) {}
+
+func runCommand(name string, args ...string) error {
+	var output bytes.Buffer
+	//nolint:gosec
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
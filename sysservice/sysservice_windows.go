@@ -0,0 +1,202 @@
+package sysservice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/anywherelan/awl/config"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceKeyPath = `SYSTEM\CurrentControlSet\Services\` + Name
+	stopTimeout    = 10 * time.Second
+	restartDelay   = 5 * time.Second
+)
+
+func defaultDataDir() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, "anywherelan")
+}
+
+func isElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+// RunElevated is not supported, elevated process would run in a new console window.
+func RunElevated(_ []string) error {
+	return errors.New("administrator privileges are required, run the command from elevated command prompt")
+}
+
+func install(opts Options) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(Name)
+	if err == nil {
+		err = updateService(s, opts.Executable)
+	} else {
+		s, err = m.CreateService(Name, opts.Executable, mgr.Config{
+			DisplayName: DisplayName,
+			Description: Description,
+			StartType:   mgr.StartAutomatic,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("create service: %v", err)
+	}
+	defer s.Close()
+
+	err = s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: restartDelay}}, 0)
+	if err != nil {
+		return fmt.Errorf("set recovery actions: %v", err)
+	}
+	// services don't inherit environment, it's read from the key of the service
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceKeyPath, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("open registry key of service: %v", err)
+	}
+	defer key.Close()
+	err = key.SetStringsValue("Environment", []string{config.AppDataDirEnvKey + "=" + opts.DataDir})
+	if err != nil {
+		return fmt.Errorf("set environment of service: %v", err)
+	}
+	return nil
+}
+
+func updateService(s *mgr.Service, executable string) error {
+	c, err := s.Config()
+	if err != nil {
+		s.Close()
+		return err
+	}
+	c.BinaryPathName = syscall.EscapeArg(executable)
+	c.DisplayName = DisplayName
+	c.Description = Description
+	c.StartType = mgr.StartAutomatic
+	err = s.UpdateConfig(c)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func uninstall() error {
+	return withService(func(s *mgr.Service) error {
+		err := stopService(s)
+		if err != nil {
+			return err
+		}
+		return s.Delete()
+	})
+}
+
+func start() error {
+	return withService(func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+func stop() error {
+	return withService(stopService)
+}
+
+func withService(f func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(Name)
+	if err != nil {
+		return ErrNotInstalled
+	}
+	defer s.Close()
+	return f(s)
+}
+
+// stopService waits until the service is stopped, it does nothing if the service isn't running.
+func stopService(s *mgr.Service) error {
+	status, err := s.Query()
+	if err != nil {
+		return err
+	}
+	if status.State == svc.Stopped {
+		return nil
+	}
+	if status.State != svc.StopPending {
+		_, err = s.Control(svc.Stop)
+		if err != nil {
+			return fmt.Errorf("stop service: %v", err)
+		}
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for time.Now().Before(deadline) {
+		status, err = s.Query()
+		if err != nil {
+			return err
+		}
+		if status.State == svc.Stopped {
+			return nil
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+	return fmt.Errorf("service is not stopped in %s", stopTimeout)
+}
+
+// Run reports the app to the service manager when it's started as Windows service, stop requests are sent to stop
+// as os.Interrupt. Output is redirected to LogFilename in the data directory, services don't have console.
+// Run should be called before the logger is set up.
+func Run(stop chan<- os.Signal) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return
+	}
+	if dataDir := os.Getenv(config.AppDataDirEnvKey); dataDir != "" {
+		logFile, err := os.Create(filepath.Join(dataDir, LogFilename))
+		if err == nil {
+			os.Stdout = logFile
+			os.Stderr = logFile
+		}
+	}
+	go func() {
+		_ = svc.Run(Name, serviceHandler{stop: stop})
+	}()
+}
+
+type serviceHandler struct {
+	stop chan<- os.Signal
+}
+
+func (h serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending, WaitHint: uint32(stopTimeout / time.Millisecond)}
+			select {
+			case h.stop <- os.Interrupt:
+			default:
+			}
+			return false, 0
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,118 @@
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// SignatureExtension is the suffix of minisign signature assets, e.g. awl-linux-amd64.tar.gz.minisig
+const SignatureExtension = ".minisig"
+
+const (
+	trustedCommentPrefix = "trusted comment: "
+	keyIDSize            = 8
+)
+
+var (
+	signatureAlgorithm          = []byte("Ed")
+	signatureAlgorithmPrehashed = []byte("ED")
+
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// PublicKey is minisign ed25519 key of release files.
+type PublicKey struct {
+	keyID [keyIDSize]byte
+	key   ed25519.PublicKey
+}
+
+// ParsePublicKey accepts base64 public key as printed by minisign or the contents of minisign .pub file.
+func ParsePublicKey(s string) (PublicKey, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("decode public key: %v", err)
+	}
+	if len(data) != len(signatureAlgorithm)+keyIDSize+ed25519.PublicKeySize || !bytes.Equal(data[:2], signatureAlgorithm) {
+		return PublicKey{}, errors.New("unsupported public key format")
+	}
+
+	var pubKey PublicKey
+	copy(pubKey.keyID[:], data[2:2+keyIDSize])
+	pubKey.key = ed25519.PublicKey(data[2+keyIDSize:])
+	return pubKey, nil
+}
+
+// Verify checks minisign signature of the message and returns its trusted comment.
+// Both legacy and prehashed signatures are supported.
+func (k PublicKey) Verify(message io.Reader, signature []byte) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) != 4 {
+		return "", ErrInvalidSignature
+	}
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+	sigData, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sigData) != 2+keyIDSize+ed25519.SignatureSize {
+		return "", ErrInvalidSignature
+	}
+	if !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return "", ErrInvalidSignature
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return "", ErrInvalidSignature
+	}
+	algorithm, keyID, sig := sigData[:2], sigData[2:2+keyIDSize], sigData[2+keyIDSize:]
+	if !bytes.Equal(keyID, k.keyID[:]) {
+		return "", fmt.Errorf("%w: signed with another key", ErrInvalidSignature)
+	}
+
+	var signed []byte
+	switch {
+	case bytes.Equal(algorithm, signatureAlgorithm):
+		signed, err = io.ReadAll(message)
+		if err != nil {
+			return "", err
+		}
+	case bytes.Equal(algorithm, signatureAlgorithmPrehashed):
+		hash, _ := blake2b.New512(nil)
+		_, err = io.Copy(hash, message)
+		if err != nil {
+			return "", err
+		}
+		signed = hash.Sum(nil)
+	default:
+		return "", fmt.Errorf("%w: unsupported algorithm", ErrInvalidSignature)
+	}
+	if !ed25519.Verify(k.key, signed, sig) {
+		return "", ErrInvalidSignature
+	}
+
+	trustedComment := strings.TrimPrefix(lines[2], trustedCommentPrefix)
+	if !ed25519.Verify(k.key, append(append([]byte{}, sig...), trustedComment...), globalSig) {
+		return "", fmt.Errorf("%w: trusted comment is modified", ErrInvalidSignature)
+	}
+	return trustedComment, nil
+}
+
+// parseTrustedComment returns key:value fields of the trusted comment, e.g. minisign default one
+// "timestamp:1700000000\tfile:awl-linux-amd64.tar.gz\thashed" or "file:awl-linux-amd64.tar.gz version:v0.12.0".
+func parseTrustedComment(comment string) map[string]string {
+	fields := make(map[string]string)
+	for _, field := range strings.Fields(comment) {
+		key, value, ok := strings.Cut(field, ":")
+		if ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
@@ -0,0 +1,187 @@
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/GrigoryKrasnochub/updaterini"
+	"github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+type testKey struct {
+	keyID   []byte
+	private ed25519.PrivateKey
+	public  string
+}
+
+func newTestKey(t *testing.T) testKey {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyID := make([]byte, keyIDSize)
+	_, err = rand.Read(keyID)
+	require.NoError(t, err)
+
+	data := append(append([]byte("Ed"), keyID...), public...)
+	return testKey{
+		keyID:   keyID,
+		private: private,
+		public:  "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(data) + "\n",
+	}
+}
+
+// sign makes signature in the same format as minisign
+func (k testKey) sign(message []byte, prehashed bool, trustedComment string) []byte {
+	algorithm := []byte("Ed")
+	if prehashed {
+		algorithm = []byte("ED")
+		hash := blake2b.Sum512(message)
+		message = hash[:]
+	}
+	sig := ed25519.Sign(k.private, message)
+	globalSig := ed25519.Sign(k.private, append(append([]byte{}, sig...), trustedComment...))
+
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append(algorithm, k.keyID...), sig...)) + "\n" +
+		trustedCommentPrefix + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n")
+}
+
+func TestPublicKey_Verify(t *testing.T) {
+	key := newTestKey(t)
+	pubKey, err := ParsePublicKey(key.public)
+	require.NoError(t, err)
+	lastLine := strings.Split(strings.TrimSpace(key.public), "\n")[1]
+	_, err = ParsePublicKey(lastLine)
+	require.NoError(t, err)
+	_, err = ParsePublicKey("garbage")
+	require.Error(t, err)
+
+	message := []byte("awl release")
+	for _, prehashed := range []bool{false, true} {
+		signature := key.sign(message, prehashed, "timestamp:1700000000")
+		trustedComment, err := pubKey.Verify(bytes.NewReader(message), signature)
+		require.NoError(t, err)
+		require.Equal(t, "timestamp:1700000000", trustedComment)
+		_, err = pubKey.Verify(bytes.NewReader([]byte("awl release 2")), signature)
+		require.ErrorIs(t, err, ErrInvalidSignature)
+
+		tampered := bytes.Replace(signature, []byte("timestamp:1700000000"), []byte("timestamp:1800000000"), 1)
+		_, err = pubKey.Verify(bytes.NewReader(message), tampered)
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	}
+
+	otherKey := newTestKey(t)
+	_, err = pubKey.Verify(bytes.NewReader(message), otherKey.sign(message, true, "comment"))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = pubKey.Verify(bytes.NewReader(message), []byte("garbage"))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestUpdateService_verifyDir(t *testing.T) {
+	key := newTestKey(t)
+	pubKey, err := ParsePublicKey(key.public)
+	require.NoError(t, err)
+	uc := UpdateService{publicKey: &pubKey, currentVersion: "v0.2.0"}
+
+	dir := t.TempDir()
+	content := []byte("binary")
+	signatureFile := filepath.Join(dir, "awl-linux-amd64.tar.gz"+SignatureExtension)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "awl-linux-amd64.tar.gz"), content, 0600))
+	require.NoError(t, os.WriteFile(signatureFile, key.sign(content, true, "timestamp:1700000000\tfile:awl-linux-amd64.tar.gz\tversion:v0.3.0"), 0600))
+	filenames, err := uc.verifyDir(dir, "v0.3.0")
+	require.NoError(t, err)
+	require.Equal(t, []string{"awl-linux-amd64.tar.gz"}, filenames)
+
+	// signed files of another release
+	_, err = uc.verifyDir(dir, "v0.4.0")
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	require.NoError(t, os.WriteFile(signatureFile, key.sign(content, true, "file:awl-linux-arm64.tar.gz version:v0.3.0"), 0600))
+	_, err = uc.verifyDir(dir, "v0.3.0")
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	require.NoError(t, os.WriteFile(signatureFile, key.sign(content, true, "comment"), 0600))
+	_, err = uc.verifyDir(dir, "v0.3.0")
+	require.ErrorIs(t, err, ErrInvalidSignature)
+
+	// downgrade
+	require.NoError(t, os.WriteFile(signatureFile, key.sign(content, true, "file:awl-linux-amd64.tar.gz version:v0.1.0"), 0600))
+	_, err = uc.verifyDir(dir, "v0.1.0")
+	require.ErrorContains(t, err, "older than running")
+	require.NoError(t, os.WriteFile(signatureFile, key.sign(content, true, "file:awl-linux-amd64.tar.gz version:v0.2.0"), 0600))
+	_, err = uc.verifyDir(dir, "v0.2.0")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "awl-linux-amd64.zip"), content, 0600))
+	_, err = uc.verifyDir(dir, "v0.2.0")
+	require.ErrorContains(t, err, "not signed")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "awl-linux-amd64.zip"+SignatureExtension), key.sign([]byte("other"), false, "file:awl-linux-amd64.zip version:v0.2.0"), 0600))
+	_, err = uc.verifyDir(dir, "v0.2.0")
+	require.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestUpdateService_loadVerifiedVersion(t *testing.T) {
+	key := newTestKey(t)
+	pubKey, err := ParsePublicKey(key.public)
+	require.NoError(t, err)
+
+	filename := fmt.Sprintf("awl-%s-%s", runtime.GOOS, runtime.GOARCH)
+	content := []byte("binary")
+	files := map[string][]byte{
+		filename:                      content,
+		filename + SignatureExtension: key.sign(content, true, "file:"+filename+" version:v0.2.0"),
+	}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/releases.json", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintf(w, `[{"folder_url": "%s/files/", "name": "release", "version": "v0.2.0", "assets": [{"filename": "%s"}, {"filename": "%s"}]}]`,
+			server.URL, filename, filename+SignatureExtension)
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(files[strings.TrimPrefix(r.URL.Path, "/files/")])
+	})
+
+	appConf, err := updaterini.NewApplicationConfig("0.1.0", []updaterini.Channel{updaterini.NewReleaseChannel(true)},
+		[]*regexp.Regexp{awlFilenamesRegex})
+	require.NoError(t, err)
+	uc := UpdateService{
+		updConf: updaterini.UpdateConfig{
+			ApplicationConfig: appConf,
+			Sources:           []updaterini.UpdateSource{&updaterini.UpdateSourceServer{UpdatesMapURL: server.URL + "/releases.json"}},
+		},
+		logger:         log.Logger("awl/update"),
+		publicKey:      &pubKey,
+		currentVersion: "v0.1.0",
+	}
+	available, err := uc.CheckForUpdates()
+	require.NoError(t, err)
+	require.True(t, available)
+
+	verified, err := uc.loadVerifiedVersion()
+	require.NoError(t, err)
+	require.Equal(t, "v0.2.0", verified.version.VersionTag())
+	// files are served from disk, changes on the remote server are ignored
+	files[filename] = []byte("replaced")
+	dir := t.TempDir()
+	require.NoError(t, verified.updConf.LoadFilesToDir(verified.version, dir))
+	loaded, err := os.ReadFile(filepath.Join(dir, filename))
+	require.NoError(t, err)
+	require.Equal(t, content, loaded)
+	verified.Close()
+
+	_, err = uc.loadVerifiedVersion()
+	require.ErrorIs(t, err, ErrInvalidSignature)
+}
@@ -29,6 +29,10 @@ type UpdateService struct {
 	updConf    updaterini.UpdateConfig
 	NewVersion updaterini.Version
 	logger     *log.ZapEventLogger
+	// publicKey is nil if signatures aren't checked
+	publicKey *PublicKey
+	// currentVersion is the running version, older signed versions are rejected
+	currentVersion string
 }
 
 type ApplicationType int
@@ -86,23 +90,39 @@ func NewUpdateService(c *config.Config, logger *log.ZapEventLogger, appType Appl
 		return UpdateService{}, err
 	}
 	appConf.ShowPrepareVersionErr = true
+
+	var publicKey *PublicKey
+	if c.Update.PublicKey != "" {
+		key, err := ParsePublicKey(c.Update.PublicKey)
+		if err != nil {
+			return UpdateService{}, fmt.Errorf("update public key: %v", err)
+		}
+		publicKey = &key
+	}
+
+	sources := []updaterini.UpdateSource{
+		&updaterini.UpdateSourceServer{
+			UpdatesMapURL: c.Update.UpdateServerURL,
+		},
+	}
+	if !c.Update.DisableGitHubSource {
+		sources = append(sources, &updaterini.UpdateSourceGitRepo{
+			UserName:            gitUserName,
+			RepoName:            gitRepoName,
+			UseDraftVersions:    false,
+			PersonalAccessToken: "",
+		})
+	}
+
 	return UpdateService{
 		updConf: updaterini.UpdateConfig{
 			ApplicationConfig: appConf,
-			Sources: []updaterini.UpdateSource{
-				&updaterini.UpdateSourceServer{
-					UpdatesMapURL: c.Update.UpdateServerURL,
-				},
-				&updaterini.UpdateSourceGitRepo{
-					UserName:            gitUserName,
-					RepoName:            gitRepoName,
-					UseDraftVersions:    false,
-					PersonalAccessToken: "",
-				},
-			},
+			Sources:           sources,
 		},
-		NewVersion: nil,
-		logger:     logger,
+		NewVersion:     nil,
+		logger:         logger,
+		publicKey:      publicKey,
+		currentVersion: config.Version,
 	}, err
 }
 
@@ -140,10 +160,21 @@ func (uc *UpdateService) DoUpdate() (updaterini.UpdateResult, error) {
 	}
 	curFile = filepath.Base(curFile)
 
-	return uc.updConf.DoUpdate(uc.NewVersion, "", func(loadedFilename string) (updaterini.ReplacementFile, error) {
+	updConf, version := uc.updConf, uc.NewVersion
+	if uc.publicKey != nil {
+		verified, err := uc.loadVerifiedVersion()
+		if err != nil {
+			return updaterini.UpdateResult{}, err
+		}
+		defer verified.Close()
+		updConf, version = verified.updConf, verified.version
+	}
+
+	return updConf.DoUpdate(version, "", func(loadedFilename string) (updaterini.ReplacementFile, error) {
 		return updaterini.ReplacementFile{
-			FileName: curFile,
-			Mode:     updaterini.ReplacementFileInfoUseDefaultOrExistedFilePerm,
+			FileName:           curFile,
+			Mode:               updaterini.ReplacementFileInfoUseDefaultOrExistedFilePerm,
+			PreventFileLoading: strings.HasSuffix(loadedFilename, SignatureExtension),
 		}, nil
 	}, func() error {
 		return nil
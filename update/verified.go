@@ -0,0 +1,160 @@
+package update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GrigoryKrasnochub/updaterini"
+	"github.com/anywherelan/awl/config"
+)
+
+const verifiedVersionsPath = "/versions.json"
+
+// verifiedVersion serves release files, which were downloaded and verified, from local server.
+// Updaterini can't install files from disk, so the update is installed from this server instead of
+// downloading files again, which could be replaced between verification and installation.
+type verifiedVersion struct {
+	updConf updaterini.UpdateConfig
+	version updaterini.Version
+	dir     string
+	server  *http.Server
+}
+
+func (uc *UpdateService) loadVerifiedVersion() (_ *verifiedVersion, err error) {
+	dir, err := os.MkdirTemp("", "awl-update-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	err = uc.updConf.LoadFilesToDir(uc.NewVersion, dir)
+	if err != nil {
+		return nil, fmt.Errorf("load release files: %v", err)
+	}
+	filenames, err := uc.verifyDir(dir, uc.NewVersion.VersionTag())
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	data := updaterini.ServData{
+		VersionFolderUrl: fmt.Sprintf("http://%s/files/", listener.Addr()),
+		Name:             uc.NewVersion.VersionName(),
+		Description:      uc.NewVersion.VersionDescription(),
+		Version:          uc.NewVersion.VersionTag(),
+	}
+	for _, filename := range filenames {
+		data.Assets = append(data.Assets, struct {
+			Filename string `json:"filename"`
+		}{Filename: filename})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(verifiedVersionsPath, func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode([]updaterini.ServData{data})
+	})
+	mux.Handle("/files/", http.StripPrefix("/files/", http.FileServer(http.Dir(dir))))
+	server := &http.Server{Handler: mux}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	verified := &verifiedVersion{
+		updConf: updaterini.UpdateConfig{
+			ApplicationConfig: uc.updConf.ApplicationConfig,
+			Sources: []updaterini.UpdateSource{
+				&updaterini.UpdateSourceServer{
+					UpdatesMapURL: fmt.Sprintf("http://%s%s", listener.Addr(), verifiedVersionsPath),
+				},
+			},
+		},
+		dir:    dir,
+		server: server,
+	}
+	version, status := verified.updConf.CheckForUpdates()
+	if version == nil {
+		_ = server.Close()
+		return nil, fmt.Errorf("prepare verified release: status %d", status.Status)
+	}
+	verified.version = version
+
+	return verified, nil
+}
+
+// verifyDir checks signatures of all release files of the version and returns names of the files.
+func (uc *UpdateService) verifyDir(dir, version string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), SignatureExtension) {
+			continue
+		}
+		err = uc.verifyFile(filepath.Join(dir, entry.Name()), version)
+		if err != nil {
+			return nil, fmt.Errorf("verify release file %s: %w", entry.Name(), err)
+		}
+		filenames = append(filenames, entry.Name())
+	}
+	if len(filenames) == 0 {
+		return nil, errors.New("release has no files")
+	}
+
+	return filenames, nil
+}
+
+// verifyFile checks the signature of the file and its trusted comment. The comment should contain the file name and
+// the version, e.g. "file:awl-linux-amd64.tar.gz version:v0.12.0", so signed files of one release can't be served
+// as files of another one. Versions older than the running one are rejected to prevent downgrades.
+func (uc *UpdateService) verifyFile(path, version string) error {
+	signature, err := os.ReadFile(path + SignatureExtension)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("file is not signed")
+	} else if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	trustedComment, err := uc.publicKey.Verify(file, signature)
+	if err != nil {
+		return err
+	}
+	fields := parseTrustedComment(trustedComment)
+	if filename := filepath.Base(path); fields["file"] != filename {
+		return fmt.Errorf("%w: signed file name %q doesn't match %q", ErrInvalidSignature, fields["file"], filename)
+	}
+	if fields["version"] != version {
+		return fmt.Errorf("%w: signed version %q doesn't match %q", ErrInvalidSignature, fields["version"], version)
+	}
+	result, ok := config.CompareVersions(version, uc.currentVersion)
+	if !ok {
+		return fmt.Errorf("unknown version %s", version)
+	} else if result < 0 {
+		return fmt.Errorf("version %s is older than running %s", version, uc.currentVersion)
+	}
+
+	return nil
+}
+
+func (v *verifiedVersion) Close() {
+	_ = v.server.Close()
+	_ = os.RemoveAll(v.dir)
+}
@@ -0,0 +1,94 @@
+package vpn
+
+import (
+	"fmt"
+	"net"
+)
+
+// MaxWriteBatch is the maximum number of packets which should be added to WriteBatch before Flush.
+const MaxWriteBatch = 128
+
+// WriteBatch collects packets from peers and writes them to the interface with one call per interface queue,
+// so the system could coalesce them (GRO on linux). It's not safe for concurrent use.
+// Added packets shouldn't be reused before Flush.
+type WriteBatch struct {
+	device  *Device
+	packets []*Packet
+	// queueBufs are buffers of packets for every interface queue
+	queueBufs [][][]byte
+}
+
+func (d *Device) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{device: d}
+}
+
+// WritePacket is the same as Device.WritePacket, but packet is written on Flush.
+func (b *WriteBatch) WritePacket(data *Packet, senderIP, senderIPv6 net.IP) error {
+	if !data.IsIPv6 {
+		return b.add(data, senderIP, b.device.localIP)
+	}
+	if b.device.localIPv6 == nil || senderIPv6 == nil {
+		return ErrIPv6Disabled
+	}
+	return b.add(data, senderIPv6, b.device.localIPv6)
+}
+
+// add replaces source and destination if they are not nil.
+func (b *WriteBatch) add(data *Packet, src, dst net.IP) error {
+	if !b.device.IsUp() {
+		return ErrInterfaceDown
+	}
+	if src != nil {
+		copy(data.Src, src)
+	}
+	if dst != nil {
+		copy(data.Dst, dst)
+	}
+	data.translateICMPError(src, dst)
+	data.RecalculateChecksum()
+	b.packets = append(b.packets, data)
+
+	return nil
+}
+
+// Len returns number of packets added since the last Flush.
+func (b *WriteBatch) Len() int {
+	return len(b.packets)
+}
+
+// Flush writes added packets to the interface. Packets of one flow are written to the same queue in order.
+func (b *WriteBatch) Flush() error {
+	if len(b.packets) == 0 {
+		return nil
+	}
+	holder := b.device.tunDevice.Load()
+	queues := len(holder.queues) + 1
+	if len(b.queueBufs) < queues {
+		b.queueBufs = append(b.queueBufs, make([][][]byte, queues-len(b.queueBufs))...)
+	}
+	for _, data := range b.packets {
+		queue := 0
+		if queues > 1 {
+			queue = int(data.FlowHash() % uint32(queues))
+		}
+		b.queueBufs[queue] = append(b.queueBufs[queue], data.Buffer[:tunPacketOffset+len(data.Packet)])
+	}
+	b.packets = b.packets[:0]
+
+	var err error
+	for queue := 0; queue < queues; queue++ {
+		bufs := b.queueBufs[queue]
+		if len(bufs) == 0 {
+			continue
+		}
+		b.queueBufs[queue] = bufs[:0]
+		packetsCount, writeErr := holder.queue(queue).Write(bufs, tunPacketOffset)
+		if writeErr != nil {
+			err = fmt.Errorf("write packet to tun: %v", writeErr)
+		} else if packetsCount < len(bufs) {
+			b.device.logger.Warnf("wrote %d packets, len(bufs): %d", packetsCount, len(bufs))
+		}
+	}
+
+	return err
+}
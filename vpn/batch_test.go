@@ -0,0 +1,45 @@
+package vpn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBatch(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	batch := dev.NewWriteBatch()
+	a.NoError(batch.Flush())
+	senders := []net.IP{net.IPv4(10, 66, 0, 2).To4(), net.IPv4(10, 66, 0, 3).To4(), net.IPv4(10, 66, 0, 4).To4()}
+	for _, sender := range senders {
+		packet, _ := testUDPPacket()
+		a.NoError(batch.WritePacket(packet, sender, nil))
+	}
+	packet, _ := testUDPPacket()
+	a.NoError(batch.WriteExitReplyPacket(packet))
+	a.Equal(4, batch.Len())
+	select {
+	case <-fake.written:
+		a.Fail("packet was written before flush")
+	default:
+	}
+
+	a.NoError(batch.Flush())
+	a.Zero(batch.Len())
+	for _, sender := range append(senders, net.IPv4(10, 66, 0, 1).To4()) {
+		select {
+		case written := <-fake.written:
+			a.Equal(sender, net.IP(written[12:16]))
+			a.Equal(net.IPv4(10, 66, 0, 1).To4(), net.IP(written[16:20]))
+		case <-time.After(time.Second):
+			a.Fail("packet was not written")
+		}
+	}
+}
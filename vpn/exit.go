@@ -0,0 +1,217 @@
+package vpn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// ErrExitNodeUnsupported is returned when system routes or NAT for exit node can't be configured on this platform,
+// or the interface was created by the app and routes are managed by it.
+var ErrExitNodeUnsupported = errors.New("exit node is not supported on this platform")
+
+// exitSplitRoutes cover the whole IPv4 space and are more specific than default route, so it is kept untouched.
+var exitSplitRoutes = []string{"0.0.0.0/1", "128.0.0.0/1"}
+
+// WriteExitPacket writes packet which the peer sends to the internet through us.
+// Source is replaced with the peer address and destination is kept, so the system forwards it with NAT.
+// TTL is decremented as we are a hop for the packet, so packets looping between peers expire. Only IPv4 is supported.
+func (d *Device) WriteExitPacket(data *Packet, senderIP net.IP) error {
+	return d.writeOne(func(batch *WriteBatch) error {
+		return batch.WriteExitPacket(data, senderIP)
+	})
+}
+
+// WriteExitReplyPacket writes packet which came from the internet through our exit node.
+// Destination is replaced with our address and source is kept.
+func (d *Device) WriteExitReplyPacket(data *Packet) error {
+	return d.writeOne(func(batch *WriteBatch) error {
+		return batch.WriteExitReplyPacket(data)
+	})
+}
+
+// WriteExitPacket is the same as Device.WriteExitPacket, but packet is written on Flush.
+func (b *WriteBatch) WriteExitPacket(data *Packet, senderIP net.IP) error {
+	if data.IsIPv6 {
+		return ErrIPv6Disabled
+	}
+	if data.Packet[ipv4offsetTTL] <= 1 {
+		return ErrTTLExpired
+	}
+	data.Packet[ipv4offsetTTL]--
+	return b.add(data, senderIP, nil)
+}
+
+// WriteExitReplyPacket is the same as Device.WriteExitReplyPacket, but packet is written on Flush.
+func (b *WriteBatch) WriteExitReplyPacket(data *Packet) error {
+	if data.IsIPv6 {
+		return ErrIPv6Disabled
+	}
+	return b.add(data, nil, b.device.localIP)
+}
+
+// IsLocalAddr reports whether ip is our address in the VPN network.
+func (d *Device) IsLocalAddr(ip net.IP) bool {
+	return ip.Equal(d.localIP) || (d.localIPv6 != nil && ip.Equal(d.localIPv6))
+}
+
+// IsExitDestination reports whether packet to ip could be sent through exit node:
+// it should be IPv4 public unicast address outside the VPN network and networks of our interfaces.
+// Private, link-local and loopback addresses are never reached through exit node, so an exit peer can't reach our LAN.
+func (d *Device) IsExitDestination(ip net.IP) bool {
+	ip = ip.To4()
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLoopback() {
+		return false
+	}
+	vpnNet := d.Network()
+	if vpnNet.Contains(ip) || ip.Equal(directedBroadcast(d.localIP, d.ipMask)) {
+		return false
+	}
+	if local := d.localNetworks.Load(); local != nil {
+		for _, network := range *local {
+			if network.Contains(ip) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetLocalNetworks sets networks of our other interfaces, see IsExitDestination.
+func (d *Device) SetLocalNetworks(networks []*net.IPNet) {
+	d.localNetworks.Store(&networks)
+}
+
+// SetExitRoutes routes all IPv4 traffic to the interface, except bypass addresses which are used to reach exit node itself.
+// Routes are replaced if bypass addresses have changed.
+func (d *Device) SetExitRoutes(bypass []net.IP) error {
+	if !d.ownsInterface {
+		return ErrExitNodeUnsupported
+	}
+	d.routesLock.Lock()
+	defer d.routesLock.Unlock()
+	if d.exitRoutes && equalIPs(d.exitBypass, bypass) {
+		return nil
+	}
+
+	ifname, err := d.tun().Name()
+	if err != nil {
+		return fmt.Errorf("get interface name: %v", err)
+	}
+	if d.exitRoutes {
+		removeExitRoutes(ifname, d.exitBypass)
+		d.exitRoutes, d.exitBypass = false, nil
+	}
+	err = addExitRoutes(ifname, bypass)
+	if err != nil {
+		removeExitRoutes(ifname, bypass)
+		return err
+	}
+	d.exitRoutes, d.exitBypass = true, append([]net.IP(nil), bypass...)
+
+	return nil
+}
+
+// ClearExitRoutes removes routes added by SetExitRoutes.
+func (d *Device) ClearExitRoutes() {
+	d.routesLock.Lock()
+	defer d.routesLock.Unlock()
+	if !d.exitRoutes {
+		return
+	}
+	ifname, err := d.tun().Name()
+	if err != nil {
+		d.logger.Errorf("get interface name: %v", err)
+		return
+	}
+	removeExitRoutes(ifname, d.exitBypass)
+	d.exitRoutes, d.exitBypass = false, nil
+}
+
+// SetNAT enables forwarding and NAT for traffic from the VPN network, so peers could use us as exit node.
+func (d *Device) SetNAT(enabled bool) error {
+	if !d.ownsInterface {
+		if enabled {
+			return ErrExitNodeUnsupported
+		}
+		return nil
+	}
+	d.routesLock.Lock()
+	defer d.routesLock.Unlock()
+	if d.natEnabled == enabled {
+		return nil
+	}
+
+	ifname, err := d.tun().Name()
+	if err != nil {
+		return fmt.Errorf("get interface name: %v", err)
+	}
+	vpnNet := d.Network()
+	if enabled {
+		err = enableNAT(ifname, vpnNet)
+	} else {
+		err = disableNAT(ifname, vpnNet)
+	}
+	if err != nil {
+		return err
+	}
+	d.natEnabled = enabled
+
+	return nil
+}
+
+// parseIPRouteGet parses gateway and interface from `ip route get` output,
+// e.g. "1.1.1.1 via 192.168.1.1 dev eth0 src 192.168.1.5 uid 0".
+func parseIPRouteGet(output string) (gateway, dev string) {
+	fields := strings.Fields(output)
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "via":
+			gateway = fields[i+1]
+		case "dev":
+			dev = fields[i+1]
+		}
+	}
+	return gateway, dev
+}
+
+// parseRouteGet parses gateway and interface from bsd `route -n get` output.
+func parseRouteGet(output string) (gateway, dev string) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		switch key {
+		case "gateway":
+			gateway = strings.TrimSpace(value)
+		case "interface":
+			dev = strings.TrimSpace(value)
+		}
+	}
+	return gateway, dev
+}
+
+// runCommand runs the command and adds its output to the error.
+func runCommand(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+func equalIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package vpn
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRouteGet(t *testing.T) {
+	a := require.New(t)
+
+	gateway, dev := parseIPRouteGet("1.1.1.1 via 192.168.1.1 dev eth0 src 192.168.1.5 uid 0 \n    cache \n")
+	a.Equal("192.168.1.1", gateway)
+	a.Equal("eth0", dev)
+	gateway, dev = parseIPRouteGet("192.168.1.7 dev eth0 src 192.168.1.5 uid 0 \n    cache \n")
+	a.Empty(gateway)
+	a.Equal("eth0", dev)
+
+	gateway, dev = parseRouteGet(`   route to: one.one.one.one
+destination: default
+       mask: default
+    gateway: 192.168.1.1
+  interface: en0
+      flags: <UP,GATEWAY,DONE,STATIC,PRCLONING>
+`)
+	a.Equal("192.168.1.1", gateway)
+	a.Equal("en0", dev)
+}
+
+func TestDevice_ExitPackets(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	a.True(dev.IsExitDestination(net.IPv4(1, 1, 1, 1)))
+	a.False(dev.IsExitDestination(net.IPv4(192, 168, 1, 1)))
+	a.False(dev.IsExitDestination(net.IPv4(172, 16, 0, 1)))
+	a.False(dev.IsExitDestination(net.IPv4(169, 254, 1, 1)))
+	a.False(dev.IsExitDestination(net.IPv4(127, 0, 0, 1)))
+	a.False(dev.IsExitDestination(net.IPv4(10, 66, 0, 5)))
+	a.False(dev.IsExitDestination(net.IPv4(224, 0, 0, 251)))
+	a.False(dev.IsExitDestination(testPeerIPv6))
+	_, publicLAN, _ := net.ParseCIDR("203.0.113.0/24")
+	dev.SetLocalNetworks([]*net.IPNet{publicLAN})
+	a.False(dev.IsExitDestination(net.IPv4(203, 0, 113, 10)))
+	a.True(dev.IsExitDestination(net.IPv4(203, 0, 114, 10)))
+	a.True(dev.IsLocalAddr(net.IPv4(10, 66, 0, 1)))
+	a.True(dev.IsLocalAddr(testLocalIPv6))
+	a.False(dev.IsLocalAddr(net.IPv4(10, 66, 0, 2)))
+
+	// we are exit node: destination is kept
+	packet, _ := testUDPPacket()
+	copy(packet.Dst, net.IPv4(1, 1, 1, 1).To4())
+	a.NoError(dev.WriteExitPacket(packet, net.IPv4(10, 66, 0, 3).To4()))
+	written := <-fake.written
+	a.Equal(net.IPv4(10, 66, 0, 3).To4(), net.IP(written[12:16]))
+	a.Equal(net.IPv4(1, 1, 1, 1).To4(), net.IP(written[16:20]))
+	a.EqualValues(63, written[ipv4offsetTTL])
+	a.Zero(checksumIPv4Header(written[:20]))
+
+	// we are a hop for the packet, so it expires instead of looping between peers
+	packet, _ = testUDPPacket()
+	packet.Packet[ipv4offsetTTL] = 1
+	a.ErrorIs(dev.WriteExitPacket(packet, net.IPv4(10, 66, 0, 3).To4()), ErrTTLExpired)
+
+	// reply from exit node: source is kept
+	packet, _ = testUDPPacket()
+	copy(packet.Src, net.IPv4(1, 1, 1, 1).To4())
+	a.NoError(dev.WriteExitReplyPacket(packet))
+	written = <-fake.written
+	a.Equal(net.IPv4(1, 1, 1, 1).To4(), net.IP(written[12:16]))
+	a.Equal(net.IPv4(10, 66, 0, 1).To4(), net.IP(written[16:20]))
+
+	ipv6Packet, _ := testIPv6UDPPacket(0, nil)
+	a.ErrorIs(dev.WriteExitPacket(ipv6Packet, net.IPv4(10, 66, 0, 3).To4()), ErrIPv6Disabled)
+
+	// routes of existing interface are managed by the app which created it
+	a.ErrorIs(dev.SetExitRoutes([]net.IP{net.IPv4(1, 2, 3, 4)}), ErrExitNodeUnsupported)
+	a.ErrorIs(dev.SetNAT(true), ErrExitNodeUnsupported)
+	a.NoError(dev.SetNAT(false))
+}
@@ -0,0 +1,123 @@
+package vpn
+
+import (
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	ipv4offsetProtocol = 9
+	ipv6offsetNextHdr  = 6
+)
+
+// PacketFilter drops classes of packets read from the interface before they are parsed and routed to peers,
+// e.g. all IPv6 traffic or multicast noise of the OS.
+type PacketFilter struct {
+	DropIPv6 bool
+	// DropMulticast drops IPv4 and IPv6 multicast and IPv4 broadcast
+	DropMulticast bool
+	// DropProtocols are IP protocol numbers, e.g. 2 for IGMP or 58 for ICMPv6. IPv6 extension headers aren't followed
+	DropProtocols []uint8
+}
+
+// PacketFilterStats are numbers of packets dropped by PacketFilter and malformed packets since the start.
+type PacketFilterStats struct {
+	IPv6      int64
+	Multicast int64
+	Protocol  int64
+	Invalid   int64
+}
+
+type packetFilter struct {
+	dropIPv6      bool
+	dropMulticast bool
+	dropProtocols [256]bool
+	// broadcast is the directed broadcast address of our network
+	broadcast net.IP
+}
+
+type packetFilterCounters struct {
+	ipv6      atomic.Int64
+	multicast atomic.Int64
+	protocol  atomic.Int64
+	invalid   atomic.Int64
+}
+
+// SetPacketFilter replaces the filter of packets read from the interface, zero filter passes all packets.
+func (d *Device) SetPacketFilter(filter PacketFilter) {
+	compiled := &packetFilter{
+		dropIPv6:      filter.DropIPv6,
+		dropMulticast: filter.DropMulticast,
+	}
+	for _, proto := range filter.DropProtocols {
+		compiled.dropProtocols[proto] = true
+	}
+	if ip := d.localIP.To4(); ip != nil && len(d.ipMask) == net.IPv4len {
+		compiled.broadcast = make(net.IP, net.IPv4len)
+		for i := range ip {
+			compiled.broadcast[i] = ip[i] | ^d.ipMask[i]
+		}
+	}
+	d.filter.Store(compiled)
+}
+
+// PacketFilterStats returns numbers of dropped packets, see SetPacketFilter.
+func (d *Device) PacketFilterStats() PacketFilterStats {
+	return PacketFilterStats{
+		IPv6:      d.filterCounters.ipv6.Load(),
+		Multicast: d.filterCounters.multicast.Load(),
+		Protocol:  d.filterCounters.protocol.Load(),
+		Invalid:   d.filterCounters.invalid.Load(),
+	}
+}
+
+// pass reports whether the packet should be parsed and sent further. Only fixed header fields are checked,
+// so it's cheaper than parsing. Malformed packets are counted as well, they are dropped by Parse.
+func (f *packetFilter) pass(packet []byte, counters *packetFilterCounters) bool {
+	if len(packet) == 0 {
+		counters.invalid.Add(1)
+		return false
+	}
+	var proto byte
+	var multicast bool
+	switch packet[0] >> 4 {
+	case ipv4.Version:
+		if len(packet) < ipv4.HeaderLen {
+			counters.invalid.Add(1)
+			return false
+		}
+		proto = packet[ipv4offsetProtocol]
+		if f.dropMulticast {
+			dst := net.IP(packet[device.IPv4offsetDst : device.IPv4offsetDst+net.IPv4len])
+			multicast = dst.IsMulticast() || dst.Equal(net.IPv4bcast) || (f.broadcast != nil && dst.Equal(f.broadcast))
+		}
+	case ipv6.Version:
+		if len(packet) < ipv6.HeaderLen {
+			counters.invalid.Add(1)
+			return false
+		}
+		if f.dropIPv6 {
+			counters.ipv6.Add(1)
+			return false
+		}
+		proto = packet[ipv6offsetNextHdr]
+		multicast = f.dropMulticast && packet[device.IPv6offsetDst] == 0xff
+	default:
+		counters.invalid.Add(1)
+		return false
+	}
+
+	if multicast {
+		counters.multicast.Add(1)
+		return false
+	}
+	if f.dropProtocols[proto] {
+		counters.protocol.Add(1)
+		return false
+	}
+	return true
+}
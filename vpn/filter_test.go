@@ -0,0 +1,68 @@
+package vpn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevice_PacketFilter(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	_, udpData := testUDPPacket()
+	_, ipv6Data := testIPv6UDPPacket(0, nil)
+	withDst := func(dst net.IP) []byte {
+		data := append([]byte(nil), udpData...)
+		copy(data[16:20], dst.To4())
+		return data
+	}
+	igmpData := append([]byte(nil), udpData...)
+	igmpData[ipv4offsetProtocol] = 2
+	assertPacketRead := func(rawData []byte) {
+		fake.packets <- rawData
+		select {
+		case packet := <-dev.OutboundChans()[0]:
+			a.Equal(rawData, packet.Packet)
+			dev.PutTempPacket(packet)
+		case <-time.After(time.Second):
+			a.Fail("packet was not read")
+		}
+	}
+
+	// zero filter passes everything except malformed packets
+	assertPacketRead(ipv6Data)
+	assertPacketRead(igmpData)
+	fake.packets <- []byte{0x00, 0x01, 0x02}
+	assertPacketRead(udpData)
+	a.Equal(PacketFilterStats{Invalid: 1}, dev.PacketFilterStats())
+
+	dev.SetPacketFilter(PacketFilter{DropIPv6: true, DropMulticast: true, DropProtocols: []uint8{2}})
+	for _, dropped := range [][]byte{
+		ipv6Data,
+		withDst(net.IPv4(224, 0, 0, 251)),
+		withDst(net.IPv4bcast),
+		// directed broadcast of our network
+		withDst(net.IPv4(10, 66, 255, 255)),
+		igmpData,
+	} {
+		fake.packets <- dropped
+	}
+	// packets are read in order, so dropped ones would be received before it
+	assertPacketRead(udpData)
+	assertPacketRead(withDst(net.IPv4(10, 66, 0, 3)))
+	a.Equal(PacketFilterStats{IPv6: 1, Multicast: 3, Protocol: 1, Invalid: 1}, dev.PacketFilterStats())
+
+	dev.SetPacketFilter(PacketFilter{DropMulticast: true})
+	assertPacketRead(ipv6Data)
+	_, multicastIPv6Data := testIPv6UDPPacket(0, nil)
+	multicastIPv6Data[24] = 0xff
+	fake.packets <- multicastIPv6Data
+	assertPacketRead(udpData)
+	a.EqualValues(4, dev.PacketFilterStats().Multicast)
+}
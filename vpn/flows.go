@@ -0,0 +1,218 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	ipProtocolTCP    = 6
+	ipProtocolUDP    = 17
+	ipProtocolICMPv6 = 58
+
+	tcpFlowIdleTimeout   = 5 * time.Minute
+	otherFlowIdleTimeout = time.Minute
+	flowsCleanupInterval = 10 * time.Second
+	// MaxFlows limits memory used by the flow table, new flows are not tracked when it is full.
+	MaxFlows = 4096
+)
+
+type flowKey struct {
+	protocol   uint8
+	localPort  uint16
+	remoteIP   [net.IPv6len]byte
+	remotePort uint16
+}
+
+// Flow is a connection through the tunnel, addresses are from our side.
+type Flow struct {
+	Protocol   string
+	LocalAddr  string
+	RemoteAddr string
+	PeerID     string
+	// Outbound is true if the first packet of the flow was sent by us
+	Outbound   bool
+	BytesOut   int64
+	BytesIn    int64
+	PacketsOut int64
+	PacketsIn  int64
+	FirstSeen  time.Time
+	LastSeen   time.Time
+}
+
+// FlowTable tracks 5-tuple flows of packets passed through the tunnel. It is safe for concurrent use.
+type FlowTable struct {
+	lock        sync.Mutex
+	flows       map[flowKey]*Flow
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+func NewFlowTable() *FlowTable {
+	return &FlowTable{
+		flows: make(map[flowKey]*Flow),
+		now:   time.Now,
+	}
+}
+
+// Track accounts packet sent to (outbound) or received from the peer.
+// Inbound packet should be tracked after WritePacket, when its addresses are replaced with ours.
+func (t *FlowTable) Track(packet *Packet, peerID string, outbound bool) {
+	protocol, srcPort, dstPort, ok := parseTransport(packet)
+	if !ok {
+		return
+	}
+	localIP, localPort, remoteIP, remotePort := packet.Src, srcPort, packet.Dst, dstPort
+	if !outbound {
+		localIP, localPort, remoteIP, remotePort = packet.Dst, dstPort, packet.Src, srcPort
+	}
+	key := flowKey{protocol: protocol, localPort: localPort, remotePort: remotePort}
+	copy(key.remoteIP[:], remoteIP.To16())
+	size := int64(len(packet.Packet))
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := t.now()
+	if now.Sub(t.lastCleanup) > flowsCleanupInterval {
+		t.cleanupLocked(now)
+	}
+
+	flow, exists := t.flows[key]
+	if !exists {
+		if len(t.flows) >= MaxFlows {
+			return
+		}
+		flow = &Flow{
+			Protocol:   protocolName(protocol),
+			LocalAddr:  joinHostPort(localIP, localPort),
+			RemoteAddr: joinHostPort(remoteIP, remotePort),
+			PeerID:     peerID,
+			Outbound:   outbound,
+			FirstSeen:  now,
+		}
+		t.flows[key] = flow
+	}
+	flow.LastSeen = now
+	if outbound {
+		flow.BytesOut += size
+		flow.PacketsOut++
+	} else {
+		flow.BytesIn += size
+		flow.PacketsIn++
+	}
+}
+
+// Flows returns active flows, the most recent first.
+func (t *FlowTable) Flows() []Flow {
+	t.lock.Lock()
+	t.cleanupLocked(t.now())
+	result := make([]Flow, 0, len(t.flows))
+	for _, flow := range t.flows {
+		result = append(result, *flow)
+	}
+	t.lock.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeen.Equal(result[j].LastSeen) {
+			return result[i].LastSeen.After(result[j].LastSeen)
+		}
+		return result[i].RemoteAddr < result[j].RemoteAddr
+	})
+	return result
+}
+
+// RemovePeer removes flows of the peer, e.g. after the peer was removed.
+func (t *FlowTable) RemovePeer(peerID string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for key, flow := range t.flows {
+		if flow.PeerID == peerID {
+			delete(t.flows, key)
+		}
+	}
+}
+
+func (t *FlowTable) cleanupLocked(now time.Time) {
+	t.lastCleanup = now
+	for key, flow := range t.flows {
+		timeout := otherFlowIdleTimeout
+		if key.protocol == ipProtocolTCP {
+			timeout = tcpFlowIdleTimeout
+		}
+		if now.Sub(flow.LastSeen) > timeout {
+			delete(t.flows, key)
+		}
+	}
+}
+
+// Transport returns name of transport protocol and ports of the packet, see parseTransport.
+func (data *Packet) Transport() (protocol string, srcPort, dstPort uint16, ok bool) {
+	protocolNum, srcPort, dstPort, ok := parseTransport(data)
+	if !ok {
+		return "", 0, 0, false
+	}
+	return protocolName(protocolNum), srcPort, dstPort, true
+}
+
+// parseTransport returns transport protocol and ports. For ICMP echo identifier is used as both ports.
+// Non-first IPv6 fragments are skipped as they don't have transport header.
+func parseTransport(packet *Packet) (protocol uint8, srcPort, dstPort uint16, ok bool) {
+	data := packet.Packet
+	var headerLen int
+	if packet.IsIPv6 {
+		protocol, headerLen, _, ok = ipv6TransportHeader(data)
+		if !ok {
+			return 0, 0, 0, false
+		}
+	} else {
+		if len(data) < ipv4.HeaderLen {
+			return 0, 0, 0, false
+		}
+		protocol, headerLen = data[9], int(data[0]&0x0f)<<2
+	}
+	if len(data) < headerLen {
+		return 0, 0, 0, false
+	}
+	transport := data[headerLen:]
+
+	switch protocol {
+	case ipProtocolTCP, ipProtocolUDP:
+		if len(transport) < 4 {
+			return protocol, 0, 0, true
+		}
+		return protocol, binary.BigEndian.Uint16(transport[0:2]), binary.BigEndian.Uint16(transport[2:4]), true
+	case ipProtocolICMP, ipProtocolICMPv6:
+		if len(transport) < 6 {
+			return protocol, 0, 0, true
+		}
+		id := binary.BigEndian.Uint16(transport[4:6])
+		return protocol, id, id, true
+	default:
+		return protocol, 0, 0, true
+	}
+}
+
+func protocolName(protocol uint8) string {
+	switch protocol {
+	case ipProtocolTCP:
+		return "tcp"
+	case ipProtocolUDP:
+		return "udp"
+	case ipProtocolICMP:
+		return "icmp"
+	case ipProtocolICMPv6:
+		return "icmpv6"
+	default:
+		return strconv.Itoa(int(protocol))
+	}
+}
+
+func joinHostPort(ip net.IP, port uint16) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
@@ -0,0 +1,51 @@
+package vpn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlowTable(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewFlowTable()
+	table.now = func() time.Time { return now }
+
+	outbound, rawData := testUDPPacket()
+	table.Track(outbound, "peer", true)
+
+	// reply: addresses are replaced by WritePacket, ports are swapped by remote
+	reply, _ := testUDPPacket()
+	copy(reply.Src, net.IPv4(10, 66, 0, 2).To4())
+	copy(reply.Dst, net.IPv4(10, 66, 0, 1).To4())
+	reply.Packet[20], reply.Packet[21], reply.Packet[22], reply.Packet[23] =
+		reply.Packet[22], reply.Packet[23], reply.Packet[20], reply.Packet[21]
+	now = now.Add(time.Second)
+	table.Track(reply, "peer", false)
+
+	flows := table.Flows()
+	a.Len(flows, 1)
+	a.Equal(Flow{
+		Protocol:   "udp",
+		LocalAddr:  "10.66.0.1:43472",
+		RemoteAddr: "10.66.0.2:9090",
+		PeerID:     "peer",
+		Outbound:   true,
+		BytesOut:   int64(len(rawData)),
+		BytesIn:    int64(len(rawData)),
+		PacketsOut: 1,
+		PacketsIn:  1,
+		FirstSeen:  now.Add(-time.Second),
+		LastSeen:   now,
+	}, flows[0])
+
+	now = now.Add(otherFlowIdleTimeout + time.Second)
+	a.Empty(table.Flows())
+
+	table.Track(outbound, "peer", true)
+	table.RemovePeer("peer")
+	a.Empty(table.Flows())
+}
@@ -0,0 +1,352 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// ICMPCodeHostUnreachable is used when the destination peer is unknown or offline.
+	ICMPCodeHostUnreachable = 1
+	// ICMPCodeAdminProhibited is used when traffic to the peer is blocked by kill switch.
+	ICMPCodeAdminProhibited = 13
+
+	ipProtocolICMP = 1
+	icmpTTL        = 64
+	// rfc792: internet header + 64 bits of original data datagram
+	icmpOriginalDataLen = 8
+
+	icmpErrorsRate  = 20
+	icmpErrorsBurst = 50
+
+	// icmpHeaderLen is type, code, checksum and 4 bytes of rest of header, quoted datagram of errors follows it
+	icmpHeaderLen = 8
+
+	icmpv6CodeAdminProhibited    = 1
+	icmpv6CodeAddressUnreachable = 3
+	// rfc4443: ICMPv6 error message must not exceed the minimum IPv6 MTU
+	ipv6MinMTU = 1280
+)
+
+func newICMPLimiter() *rate.Limiter {
+	return rate.NewLimiter(icmpErrorsRate, icmpErrorsBurst)
+}
+
+// WriteICMPUnreachable writes ICMP Destination Unreachable for outbound packet back to the local stack,
+// so applications fail fast instead of waiting for timeout.
+// Codes are for ICMP, for IPv6 packets they are converted to ICMPv6 codes.
+// Errors are not generated for ICMP errors, non-first fragments and above the rate limit.
+func (d *Device) WriteICMPUnreachable(original *Packet, code uint8) error {
+	if original.IsIPv6 {
+		return d.writeICMPv6Unreachable(original, code)
+	}
+	if !d.isUnicastAddr(original.Dst) || !isICMPErrorAllowed(original.Packet) || !d.icmpLimiter.Allow() {
+		return nil
+	}
+	if !d.IsUp() {
+		return ErrInterfaceDown
+	}
+
+	data := original.Packet
+	ipHeaderLen := int(data[0]&0x0f) << 2
+	quoteLen := ipHeaderLen + icmpOriginalDataLen
+	if quoteLen > len(data) {
+		quoteLen = len(data)
+	}
+	message := icmp.Message{
+		Type: ipv4.ICMPTypeDestinationUnreachable,
+		Code: int(code),
+		Body: &icmp.DstUnreach{Data: data[:quoteLen]},
+	}
+	icmpData, err := message.Marshal(nil)
+	if err != nil {
+		return fmt.Errorf("marshal icmp: %v", err)
+	}
+
+	packet := d.GetTempPacket()
+	defer d.PutTempPacket(packet)
+	totalLen := ipv4.HeaderLen + len(icmpData)
+	packet.Packet = packet.Buffer[tunPacketOffset : tunPacketOffset+totalLen]
+	writeIPv4Header(packet.Packet[:ipv4.HeaderLen], totalLen, original.Dst, original.Src)
+	copy(packet.Packet[ipv4.HeaderLen:], icmpData)
+
+	bufs := [][]byte{packet.Buffer[:tunPacketOffset+totalLen]}
+	_, err = d.tun().Write(bufs, tunPacketOffset)
+	if err != nil {
+		return fmt.Errorf("write icmp to tun: %v", err)
+	}
+
+	return nil
+}
+
+func (d *Device) writeICMPv6Unreachable(original *Packet, code uint8) error {
+	if d.localIPv6 == nil || !isUnicastIPv6(original.Dst) || !isUnicastIPv6(original.Src) ||
+		!isICMPv6ErrorAllowed(original.Packet) || !d.icmpLimiter.Allow() {
+		return nil
+	}
+	if !d.IsUp() {
+		return ErrInterfaceDown
+	}
+
+	icmpCode := icmpv6CodeAddressUnreachable
+	if code == ICMPCodeAdminProhibited {
+		icmpCode = icmpv6CodeAdminProhibited
+	}
+	data := original.Packet
+	// ipv6 header + icmpv6 header with unused field
+	quoteLen := ipv6MinMTU - ipv6.HeaderLen - 8
+	if quoteLen > len(data) {
+		quoteLen = len(data)
+	}
+	message := icmp.Message{
+		Type: ipv6.ICMPTypeDestinationUnreachable,
+		Code: icmpCode,
+		Body: &icmp.DstUnreach{Data: data[:quoteLen]},
+	}
+	icmpData, err := message.Marshal(icmp.IPv6PseudoHeader(original.Dst, original.Src))
+	if err != nil {
+		return fmt.Errorf("marshal icmpv6: %v", err)
+	}
+
+	packet := d.GetTempPacket()
+	defer d.PutTempPacket(packet)
+	totalLen := ipv6.HeaderLen + len(icmpData)
+	packet.Packet = packet.Buffer[tunPacketOffset : tunPacketOffset+totalLen]
+	writeIPv6Header(packet.Packet[:ipv6.HeaderLen], len(icmpData), original.Dst, original.Src)
+	copy(packet.Packet[ipv6.HeaderLen:], icmpData)
+
+	bufs := [][]byte{packet.Buffer[:tunPacketOffset+totalLen]}
+	_, err = d.tun().Write(bufs, tunPacketOffset)
+	if err != nil {
+		return fmt.Errorf("write icmpv6 to tun: %v", err)
+	}
+
+	return nil
+}
+
+// EchoReply returns reply to ICMP or ICMPv6 echo request from the peer, nil if the packet isn't an echo request.
+// The reply should be sent back to the peer, so ping of our address works even if the system firewall drops it.
+// Addresses of the request are swapped, the peer replaces them with its own ones anyway.
+func (d *Device) EchoReply(request *Packet) *Packet {
+	var replyType byte
+	var offset int
+	if request.IsIPv6 {
+		protocol, icmpOffset, fragmented, ok := ipv6TransportHeader(request.Packet)
+		if !ok || fragmented || protocol != ipProtocolICMPv6 || len(request.Packet) < icmpOffset+icmpHeaderLen ||
+			ipv6.ICMPType(request.Packet[icmpOffset]) != ipv6.ICMPTypeEchoRequest {
+			return nil
+		}
+		replyType, offset = byte(ipv6.ICMPTypeEchoReply), icmpOffset
+	} else {
+		icmpOffset, ok := ipv4ICMPOffset(request.Packet)
+		if !ok || len(request.Packet) < icmpOffset+icmpHeaderLen ||
+			ipv4.ICMPType(request.Packet[icmpOffset]) != ipv4.ICMPTypeEcho {
+			return nil
+		}
+		replyType, offset = byte(ipv4.ICMPTypeEchoReply), icmpOffset
+	}
+
+	reply := d.GetTempPacket()
+	reply.Packet = reply.Buffer[tunPacketOffset : tunPacketOffset+len(request.Packet)]
+	copy(reply.Packet, request.Packet)
+	reply.Parse()
+	copy(reply.Src, request.Dst)
+	copy(reply.Dst, request.Src)
+	if reply.IsIPv6 {
+		reply.Packet[7] = icmpTTL
+	} else {
+		reply.Packet[8] = icmpTTL
+	}
+	reply.Packet[offset] = replyType
+	reply.RecalculateChecksum()
+
+	return reply
+}
+
+// TimeExceeded returns ICMP Time Exceeded for exit packet of the peer which TTL expired, see ErrTTLExpired,
+// nil if the error is not allowed. It should be sent back to the peer as exit packet: its source is the destination
+// of the expired packet, so the peer accepts it as reply from its exit node or our subnet.
+func (d *Device) TimeExceeded(original *Packet) *Packet {
+	if original.IsIPv6 || !d.isUnicastAddr(original.Src) || !isICMPErrorAllowed(original.Packet) || !d.icmpLimiter.Allow() {
+		return nil
+	}
+
+	data := original.Packet
+	ipHeaderLen := int(data[0]&0x0f) << 2
+	quoteLen := ipHeaderLen + icmpOriginalDataLen
+	if quoteLen > len(data) {
+		quoteLen = len(data)
+	}
+	message := icmp.Message{
+		Type: ipv4.ICMPTypeTimeExceeded,
+		Body: &icmp.TimeExceeded{Data: data[:quoteLen]},
+	}
+	icmpData, err := message.Marshal(nil)
+	if err != nil {
+		d.logger.Warnf("marshal icmp: %v", err)
+		return nil
+	}
+
+	reply := d.GetTempPacket()
+	totalLen := ipv4.HeaderLen + len(icmpData)
+	reply.Packet = reply.Buffer[tunPacketOffset : tunPacketOffset+totalLen]
+	writeIPv4Header(reply.Packet[:ipv4.HeaderLen], totalLen, original.Dst, original.Src)
+	copy(reply.Packet[ipv4.HeaderLen:], icmpData)
+	reply.Parse()
+
+	return reply
+}
+
+// translateICMPError replaces addresses of the datagram quoted in ICMP or ICMPv6 error, so the system matches
+// the error to its connection. The quoted datagram was sent in reverse direction: its destination is replaced with src
+// and its source with dst, nil addresses are kept. Checksum of the message should be recalculated after it.
+func (data *Packet) translateICMPError(src, dst net.IP) {
+	var quoted []byte
+	if data.IsIPv6 {
+		protocol, offset, fragmented, ok := ipv6TransportHeader(data.Packet)
+		// error messages have types 0-127
+		if !ok || fragmented || protocol != ipProtocolICMPv6 || len(data.Packet) < offset+icmpHeaderLen+ipv6.HeaderLen ||
+			data.Packet[offset] >= 128 {
+			return
+		}
+		quoted = data.Packet[offset+icmpHeaderLen:]
+		if quoted[0]>>4 != ipv6.Version {
+			return
+		}
+		if dst != nil {
+			copy(quoted[8:24], dst.To16())
+		}
+		if src != nil {
+			copy(quoted[24:40], src.To16())
+		}
+		return
+	}
+
+	offset, ok := ipv4ICMPOffset(data.Packet)
+	if !ok || len(data.Packet) < offset+icmpHeaderLen+ipv4.HeaderLen {
+		return
+	}
+	switch ipv4.ICMPType(data.Packet[offset]) {
+	case ipv4.ICMPTypeDestinationUnreachable, ipv4.ICMPTypeTimeExceeded, ipv4.ICMPTypeParameterProblem:
+	default:
+		return
+	}
+	quoted = data.Packet[offset+icmpHeaderLen:]
+	quotedHeaderLen := int(quoted[0]&0x0f) << 2
+	if quoted[0]>>4 != ipv4.Version || quotedHeaderLen < ipv4.HeaderLen || len(quoted) < quotedHeaderLen {
+		return
+	}
+	if dst != nil {
+		copy(quoted[12:16], dst.To4())
+	}
+	if src != nil {
+		copy(quoted[16:20], src.To4())
+	}
+	copy(quoted[ipv4offsetChecksum:], []byte{0, 0})
+	binary.BigEndian.PutUint16(quoted[ipv4offsetChecksum:], checksumIPv4Header(quoted[:quotedHeaderLen]))
+}
+
+// ipv4ICMPOffset returns offset of ICMP header, ok is false for other protocols and fragments,
+// since checksum of fragmented message can't be recalculated.
+func ipv4ICMPOffset(packet []byte) (offset int, ok bool) {
+	const fragmentMask = 0x3fff // more fragments flag and fragment offset
+	if len(packet) < ipv4.HeaderLen || packet[9] != ipProtocolICMP ||
+		binary.BigEndian.Uint16(packet[6:8])&fragmentMask != 0 {
+		return 0, false
+	}
+	offset = int(packet[0]&0x0f) << 2
+	return offset, len(packet) >= offset+4
+}
+
+func (d *Device) isUnicastAddr(ip net.IP) bool {
+	ip = ip.To4()
+	if ip == nil || ip.IsMulticast() || ip.Equal(net.IPv4bcast) || ip.IsUnspecified() {
+		return false
+	}
+	if len(d.ipMask) == net.IPv4len && ip.Equal(directedBroadcast(d.localIP, d.ipMask)) {
+		return false
+	}
+	return true
+}
+
+func directedBroadcast(ip net.IP, mask net.IPMask) net.IP {
+	ip = ip.To4()
+	if ip == nil {
+		return nil
+	}
+	result := make(net.IP, net.IPv4len)
+	for i := range result {
+		result[i] = ip[i] | ^mask[i]
+	}
+	return result
+}
+
+// isICMPErrorAllowed checks rfc1122 3.2.2: no ICMP errors about ICMP errors and non-first fragments.
+func isICMPErrorAllowed(packet []byte) bool {
+	if len(packet) < ipv4.HeaderLen {
+		return false
+	}
+	const fragmentOffsetMask = 0x1fff
+	if binary.BigEndian.Uint16(packet[6:8])&fragmentOffsetMask != 0 {
+		return false
+	}
+	if packet[9] != ipProtocolICMP {
+		return true
+	}
+	ipHeaderLen := int(packet[0]&0x0f) << 2
+	if len(packet) <= ipHeaderLen {
+		return false
+	}
+	switch ipv4.ICMPType(packet[ipHeaderLen]) {
+	case ipv4.ICMPTypeEcho, ipv4.ICMPTypeTimestamp:
+		return true
+	default:
+		return false
+	}
+}
+
+// isICMPv6ErrorAllowed checks rfc4443 2.4: no ICMPv6 errors about ICMPv6 errors and non-first fragments.
+func isICMPv6ErrorAllowed(packet []byte) bool {
+	protocol, offset, _, ok := ipv6TransportHeader(packet)
+	if !ok {
+		return false
+	}
+	if protocol != ipProtocolICMPv6 {
+		return true
+	}
+	if len(packet) <= offset {
+		return false
+	}
+	// error messages have types 0-127, informational are 128-255
+	return packet[offset] >= 128
+}
+
+func writeIPv6Header(header []byte, payloadLen int, src, dst net.IP) {
+	header[0] = ipv6.Version << 4
+	header[1], header[2], header[3] = 0, 0, 0
+	binary.BigEndian.PutUint16(header[4:6], uint16(payloadLen))
+	header[6] = ipProtocolICMPv6
+	header[7] = icmpTTL
+	copy(header[8:24], src.To16())
+	copy(header[24:40], dst.To16())
+}
+
+func writeIPv4Header(header []byte, totalLen int, src, dst net.IP) {
+	header[0] = ipv4.Version<<4 | ipv4.HeaderLen>>2
+	header[1] = 0
+	binary.BigEndian.PutUint16(header[2:4], uint16(totalLen))
+	binary.BigEndian.PutUint16(header[4:6], 0)
+	binary.BigEndian.PutUint16(header[6:8], 0)
+	header[8] = icmpTTL
+	header[9] = ipProtocolICMP
+	binary.BigEndian.PutUint16(header[ipv4offsetChecksum:], 0)
+	copy(header[12:16], src.To4())
+	copy(header[16:20], dst.To4())
+	binary.BigEndian.PutUint16(header[ipv4offsetChecksum:], checksumIPv4Header(header))
+}
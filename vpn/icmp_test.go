@@ -0,0 +1,200 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestDevice_WriteICMPUnreachable(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	packet, rawData := testUDPPacket()
+	err = dev.WriteICMPUnreachable(packet, ICMPCodeHostUnreachable)
+	a.NoError(err)
+
+	written := <-fake.written
+	header, err := ipv4.ParseHeader(written)
+	a.NoError(err)
+	a.Equal(len(written), header.TotalLen)
+	a.Equal(ipProtocolICMP, header.Protocol)
+	a.True(net.IPv4(10, 66, 0, 2).Equal(header.Src))
+	a.True(net.IPv4(10, 66, 0, 1).Equal(header.Dst))
+	a.Equal(0, int(checksumIPv4Header(written[:header.Len])))
+
+	message, err := icmp.ParseMessage(ipProtocolICMP, written[header.Len:])
+	a.NoError(err)
+	a.Equal(ipv4.ICMPTypeDestinationUnreachable, message.Type)
+	a.Equal(ICMPCodeHostUnreachable, message.Code)
+	body, ok := message.Body.(*icmp.DstUnreach)
+	a.True(ok)
+	a.Equal(rawData[:ipv4.HeaderLen+icmpOriginalDataLen], body.Data)
+
+	// no errors about icmp errors
+	errorPacket := new(Packet)
+	errorPacket.Packet = written
+	a.True(errorPacket.Parse())
+	a.NoError(dev.WriteICMPUnreachable(errorPacket, ICMPCodeHostUnreachable))
+
+	// no errors for broadcast
+	broadcastPacket, _ := testUDPPacket()
+	copy(broadcastPacket.Dst, net.IPv4(10, 66, 255, 255).To4())
+	a.NoError(dev.WriteICMPUnreachable(broadcastPacket, ICMPCodeHostUnreachable))
+	a.Len(fake.written, 0)
+}
+
+func TestDevice_EchoReply(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	echo := &icmp.Echo{ID: 7, Seq: 3, Data: []byte("ping")}
+	peerIP := net.IPv4(10, 66, 0, 2).To4()
+	request := testICMPPacket(peerIP, net.IPv4(10, 66, 0, 1).To4(), icmp.Message{Type: ipv4.ICMPTypeEcho, Body: echo})
+	reply := dev.EchoReply(request)
+	a.NotNil(reply)
+	header, err := ipv4.ParseHeader(reply.Packet)
+	a.NoError(err)
+	a.True(net.IPv4(10, 66, 0, 1).Equal(header.Src))
+	a.True(peerIP.Equal(header.Dst))
+	a.Zero(checksumIPv4Header(reply.Packet[:header.Len]))
+	a.Zero(tcpipChecksum(reply.Packet[header.Len:], 0))
+	message, err := icmp.ParseMessage(ipProtocolICMP, reply.Packet[header.Len:])
+	a.NoError(err)
+	a.Equal(ipv4.ICMPTypeEchoReply, message.Type)
+	a.Equal(echo, message.Body)
+	dev.PutTempPacket(reply)
+
+	request = testICMPPacket(testPeerIPv6, testLocalIPv6, icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: echo})
+	reply = dev.EchoReply(request)
+	a.NotNil(reply)
+	a.True(testLocalIPv6.Equal(reply.Src))
+	a.True(testPeerIPv6.Equal(reply.Dst))
+	a.Zero(checksumIPv6Upper(reply.Packet[ipv6.HeaderLen:], ipProtocolICMPv6, reply.Src, reply.Dst))
+	message, err = icmp.ParseMessage(ipProtocolICMPv6, reply.Packet[ipv6.HeaderLen:])
+	a.NoError(err)
+	a.Equal(ipv6.ICMPTypeEchoReply, message.Type)
+	a.Equal(echo, message.Body)
+	dev.PutTempPacket(reply)
+
+	// not an echo request
+	request = testICMPPacket(peerIP, net.IPv4(10, 66, 0, 1).To4(), icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: echo})
+	a.Nil(dev.EchoReply(request))
+	packet, _ := testUDPPacket()
+	a.Nil(dev.EchoReply(packet))
+}
+
+func TestDevice_TimeExceeded(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	packet, data := testUDPPacket()
+	copy(packet.Dst, net.IPv4(1, 1, 1, 1).To4())
+	packet.Packet[ipv4offsetTTL] = 1
+	reply := dev.TimeExceeded(packet)
+	a.NotNil(reply)
+	header, err := ipv4.ParseHeader(reply.Packet)
+	a.NoError(err)
+	a.True(net.IPv4(1, 1, 1, 1).Equal(header.Src))
+	a.True(net.IPv4(10, 66, 0, 1).Equal(header.Dst))
+	a.Zero(checksumIPv4Header(reply.Packet[:header.Len]))
+	message, err := icmp.ParseMessage(ipProtocolICMP, reply.Packet[header.Len:])
+	a.NoError(err)
+	a.Equal(ipv4.ICMPTypeTimeExceeded, message.Type)
+	quoted := message.Body.(*icmp.TimeExceeded).Data
+	a.Equal(data[12:16], quoted[12:16])
+	a.Equal(net.IPv4(1, 1, 1, 1).To4(), net.IP(quoted[16:20]))
+	// no errors about errors
+	a.Nil(dev.TimeExceeded(reply))
+	dev.PutTempPacket(reply)
+
+	ipv6Packet, _ := testIPv6UDPPacket(0, nil)
+	a.Nil(dev.TimeExceeded(ipv6Packet))
+}
+
+func TestWriteBatch_ICMPError(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	// the remote peer's system answers our udp packet with port unreachable, addresses from its point of view
+	_, rawData := testUDPPacket()
+	quoted := append([]byte(nil), rawData...)
+	copy(quoted[12:16], net.IPv4(10, 67, 0, 5).To4())
+	copy(quoted[16:20], net.IPv4(10, 67, 0, 6).To4())
+	packet := testICMPPacket(net.IPv4(10, 67, 0, 6).To4(), net.IPv4(10, 67, 0, 5).To4(), icmp.Message{
+		Type: ipv4.ICMPTypeDestinationUnreachable,
+		Code: 3,
+		Body: &icmp.DstUnreach{Data: quoted},
+	})
+	peerIP := net.IPv4(10, 66, 0, 2).To4()
+	a.NoError(dev.WritePacket(packet, peerIP, nil))
+
+	written := <-fake.written
+	header, err := ipv4.ParseHeader(written)
+	a.NoError(err)
+	a.True(peerIP.Equal(header.Src))
+	a.True(net.IPv4(10, 66, 0, 1).Equal(header.Dst))
+	a.Zero(checksumIPv4Header(written[:header.Len]))
+	a.Zero(tcpipChecksum(written[header.Len:], 0))
+	message, err := icmp.ParseMessage(ipProtocolICMP, written[header.Len:])
+	a.NoError(err)
+	body, ok := message.Body.(*icmp.DstUnreach)
+	a.True(ok)
+	// the quoted datagram matches the one we sent
+	a.Equal(rawData[:len(body.Data)], body.Data)
+}
+
+func testICMPPacket(src, dst net.IP, message icmp.Message) *Packet {
+	var data []byte
+	if ip4 := src.To4(); ip4 != nil {
+		body, err := message.Marshal(nil)
+		if err != nil {
+			panic(err)
+		}
+		data = make([]byte, ipv4.HeaderLen, ipv4.HeaderLen+len(body))
+		data[0] = ipv4.Version<<4 | ipv4.HeaderLen>>2
+		binary.BigEndian.PutUint16(data[2:4], uint16(ipv4.HeaderLen+len(body)))
+		data[8] = 64
+		data[9] = ipProtocolICMP
+		copy(data[12:16], ip4)
+		copy(data[16:20], dst.To4())
+		binary.BigEndian.PutUint16(data[ipv4offsetChecksum:], checksumIPv4Header(data))
+		data = append(data, body...)
+	} else {
+		body, err := message.Marshal(icmp.IPv6PseudoHeader(src, dst))
+		if err != nil {
+			panic(err)
+		}
+		data = make([]byte, ipv6.HeaderLen, ipv6.HeaderLen+len(body))
+		data[0] = ipv6.Version << 4
+		binary.BigEndian.PutUint16(data[4:6], uint16(len(body)))
+		data[6] = ipProtocolICMPv6
+		data[7] = 64
+		copy(data[8:24], src.To16())
+		copy(data[24:40], dst.To16())
+		data = append(data, body...)
+	}
+
+	packet := new(Packet)
+	_, _ = packet.ReadFrom(bytes.NewReader(data))
+	packet.Parse()
+	return packet
+}
@@ -4,36 +4,51 @@
 package vpn
 
 import (
-	"fmt"
+	"errors"
 	"net"
-	"os"
-	"strconv"
 
-	"golang.org/x/sys/unix"
 	"golang.zx2c4.com/wireguard/tun"
 )
 
-// TODO: refactor and remove this hack
-const TunFDEnvKey = "AWL_TUN_FD"
+// newTUN fails as only VpnService is allowed to create the interface, its descriptor is passed to NewTUNFromFD.
+func newTUN(_ string, _ int, _ net.IP, _ net.IPMask) (tun.Device, error) {
+	return nil, errors.New("interface is created by the app, pass its descriptor with NewTUNFromFD")
+}
 
-func newTUN(ifname string, mtu int, localIP net.IP, ipMask net.IPMask) (tun.Device, error) {
-	fdStr := os.Getenv(TunFDEnvKey)
-	tunFD, err := strconv.ParseInt(fdStr, 10, 32)
-	if err != nil || tunFD == 0 {
-		return nil, fmt.Errorf("invalid tun FD %s: %v", fdStr, err)
-	}
+// setIPv6 does nothing as addresses are set by the app when it creates the interface.
+func setIPv6(_ tun.Device, _ net.IP, _ net.IPMask) error {
+	return nil
+}
 
-	tunDevice, _, err := tun.CreateUnmonitoredTUNFromFD(int(tunFD))
-	if err != nil {
-		unix.Close(int(tunFD))
-		return nil, fmt.Errorf("CreateUnmonitoredTUNFromFD: %v", err)
-	}
+// Exit node routes are set by the app when it creates the interface, see ownsInterface.
+func addExitRoutes(_ string, _ []net.IP) error {
+	return ErrExitNodeUnsupported
+}
+
+func removeExitRoutes(_ string, _ []net.IP) {}
+
+// Subnet routes are set by the app when it creates the interface too.
+func addSubnetRoute(_ string, _ *net.IPNet) error {
+	return ErrSubnetRoutesUnsupported
+}
+
+func removeSubnetRoute(_ string, _ *net.IPNet) {}
+
+// setLinkUp does nothing as the interface is owned by VpnService. Traffic is dropped by Device while it's paused.
+func setLinkUp(_ string, _ bool) error {
+	return nil
+}
+
+func enableNAT(_ string, _ *net.IPNet) error {
+	return ErrExitNodeUnsupported
+}
 
-	return tunDevice, nil
+func disableNAT(_ string, _ *net.IPNet) error {
+	return nil
 }
 
 func (d *Device) InterfaceName() (string, error) {
-	interfaceName, err := d.tun.Name()
+	interfaceName, err := d.tun().Name()
 	if err != nil {
 		return "", err
 	}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"os/exec"
+	"strconv"
 
 	"golang.zx2c4.com/wireguard/tun"
 )
@@ -44,8 +45,98 @@ func newTUN(ifname string, mtu int, localIP net.IP, ipMask net.IPMask) (tun.Devi
 	return tunDevice, nil
 }
 
+func setIPv6(tunDevice tun.Device, localIPv6 net.IP, ipv6Mask net.IPMask) error {
+	ifname, err := tunDevice.Name()
+	if err != nil {
+		return fmt.Errorf("get interface name: %v", err)
+	}
+
+	ones, _ := ipv6Mask.Size()
+	err = exec.Command("ifconfig", ifname, "inet6", localIPv6.String(), "prefixlen", strconv.Itoa(ones), "alias").Run()
+	if err != nil {
+		return fmt.Errorf("unable to setup interface ipv6: %v", err)
+	}
+
+	ipNetMasked := &net.IPNet{
+		IP:   localIPv6.Mask(ipv6Mask),
+		Mask: ipv6Mask,
+	}
+	err = exec.Command("route", "-q", "-n", "add", "-inet6", ipNetMasked.String(), "-iface", ifname).Run()
+	if err != nil {
+		return fmt.Errorf("unable to setup interface ipv6 route: %v", err)
+	}
+
+	return nil
+}
+
+func addExitRoutes(ifname string, bypass []net.IP) error {
+	// bypass routes are resolved before traffic is routed to the interface
+	for _, ip := range bypass {
+		output, err := exec.Command("route", "-n", "get", "-inet", ip.String()).Output()
+		if err != nil {
+			return fmt.Errorf("get route to %s: %v", ip, err)
+		}
+		gateway, dev := parseRouteGet(string(output))
+		if dev == "" || dev == ifname {
+			continue
+		}
+		args := []string{"-q", "-n", "add", "-inet", "-host", ip.String()}
+		if gateway != "" {
+			args = append(args, gateway)
+		} else {
+			args = append(args, "-interface", dev)
+		}
+		err = runCommand("route", args...)
+		if err != nil {
+			return fmt.Errorf("add bypass route to %s: %v", ip, err)
+		}
+	}
+	for _, route := range exitSplitRoutes {
+		err := runCommand("route", "-q", "-n", "add", "-inet", route, "-interface", ifname)
+		if err != nil {
+			return fmt.Errorf("add route %s: %v", route, err)
+		}
+	}
+
+	return nil
+}
+
+func removeExitRoutes(ifname string, bypass []net.IP) {
+	for _, route := range exitSplitRoutes {
+		_ = runCommand("route", "-q", "-n", "delete", "-inet", route, "-interface", ifname)
+	}
+	for _, ip := range bypass {
+		_ = runCommand("route", "-q", "-n", "delete", "-inet", "-host", ip.String())
+	}
+}
+
+func addSubnetRoute(ifname string, route *net.IPNet) error {
+	return runCommand("route", "-q", "-n", "add", "-inet", "-net", route.String(), "-interface", ifname)
+}
+
+func removeSubnetRoute(ifname string, route *net.IPNet) {
+	_ = runCommand("route", "-q", "-n", "delete", "-inet", "-net", route.String(), "-interface", ifname)
+}
+
+func setLinkUp(ifname string, up bool) error {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	return runCommand("ifconfig", ifname, state)
+}
+
+// enableNAT is not implemented, it requires pf anchors and changes of system pf config.
+func enableNAT(_ string, _ *net.IPNet) error {
+	return ErrExitNodeUnsupported
+}
+
+func disableNAT(_ string, _ *net.IPNet) error {
+	return nil
+}
+
 func (d *Device) InterfaceName() (string, error) {
-	interfaceName, err := d.tun.Name()
+	interfaceName, err := d.tun().Name()
 	if err != nil {
 		return "", err
 	}
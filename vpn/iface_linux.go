@@ -4,10 +4,12 @@
 package vpn
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"os"
+	"os/exec"
 
-	"github.com/milosgajdos/tenus"
 	"golang.zx2c4.com/wireguard/tun"
 )
 
@@ -17,22 +19,21 @@ func newTUN(ifname string, mtu int, localIP net.IP, ipMask net.IPMask) (tun.Devi
 		Mask: ipMask,
 	}
 
-	tunDevice, err := tun.CreateTUN(ifname, mtu)
+	tunDevice, err := createMultiQueueTUN(ifname, mtu)
 	if err != nil {
-		return nil, fmt.Errorf("create tun: %v", err)
+		// multiqueue is unsupported by old kernels
+		tunDevice, err = tun.CreateTUN(ifname, mtu)
 	}
-
-	link, err := tenus.NewLinkFrom(ifname)
-	if nil != err {
-		return nil, fmt.Errorf("unable to get interface info: %v", err)
+	if err != nil {
+		return nil, fmt.Errorf("create tun: %v", err)
 	}
 
-	err = link.SetLinkIp(localIP, ipNet)
+	err = addInterfaceAddress(ifname, localIP, ipMask)
 	if err != nil {
 		return nil, fmt.Errorf("unable to set IP (%s) to (%v on interface): %v", localIP, ipNet, err)
 	}
 
-	err = link.SetLinkUp()
+	err = setInterfaceUp(ifname)
 	if err != nil {
 		return nil, fmt.Errorf("unable to UP interface: %v", err)
 	}
@@ -40,8 +41,143 @@ func newTUN(ifname string, mtu int, localIP net.IP, ipMask net.IPMask) (tun.Devi
 	return tunDevice, nil
 }
 
+func setIPv6(tunDevice tun.Device, localIPv6 net.IP, ipv6Mask net.IPMask) error {
+	ipNet := &net.IPNet{
+		IP:   localIPv6.Mask(ipv6Mask),
+		Mask: ipv6Mask,
+	}
+
+	ifname, err := tunDevice.Name()
+	if err != nil {
+		return fmt.Errorf("get interface name: %v", err)
+	}
+
+	err = addInterfaceAddress(ifname, localIPv6, ipv6Mask)
+	if err != nil {
+		return fmt.Errorf("unable to set IP (%s) to (%v on interface): %v", localIPv6, ipNet, err)
+	}
+
+	return nil
+}
+
+const ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+
+// ipForwardBefore is restored when NAT is disabled
+var ipForwardBefore []byte
+
+func addExitRoutes(ifname string, bypass []net.IP) error {
+	// bypass routes are resolved before traffic is routed to the interface
+	for _, ip := range bypass {
+		output, err := exec.Command("ip", "-4", "route", "get", ip.String()).Output()
+		if err != nil {
+			return fmt.Errorf("get route to %s: %v", ip, err)
+		}
+		gateway, dev := parseIPRouteGet(string(output))
+		if dev == "" || dev == ifname {
+			continue
+		}
+		args := []string{"-4", "route", "replace", ip.String() + "/32"}
+		if gateway != "" {
+			args = append(args, "via", gateway)
+		}
+		args = append(args, "dev", dev)
+		err = runCommand("ip", args...)
+		if err != nil {
+			return fmt.Errorf("add bypass route to %s: %v", ip, err)
+		}
+	}
+	for _, route := range exitSplitRoutes {
+		err := runCommand("ip", "-4", "route", "replace", route, "dev", ifname)
+		if err != nil {
+			return fmt.Errorf("add route %s: %v", route, err)
+		}
+	}
+
+	return nil
+}
+
+func removeExitRoutes(ifname string, bypass []net.IP) {
+	for _, route := range exitSplitRoutes {
+		_ = runCommand("ip", "-4", "route", "del", route, "dev", ifname)
+	}
+	for _, ip := range bypass {
+		_ = runCommand("ip", "-4", "route", "del", ip.String()+"/32")
+	}
+}
+
+func addSubnetRoute(ifname string, route *net.IPNet) error {
+	return runCommand("ip", "-4", "route", "replace", route.String(), "dev", ifname)
+}
+
+func removeSubnetRoute(ifname string, route *net.IPNet) {
+	_ = runCommand("ip", "-4", "route", "del", route.String(), "dev", ifname)
+}
+
+func setLinkUp(ifname string, up bool) error {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	return runCommand("ip", "link", "set", "dev", ifname, state)
+}
+
+// natRules returns table, chain and rule spec for each rule.
+func natRules(ifname string, vpnNet *net.IPNet) [][]string {
+	comment := []string{"-m", "comment", "--comment", "awl"}
+	return [][]string{
+		append([]string{"-t", "nat", "POSTROUTING", "-s", vpnNet.String(), "!", "-o", ifname, "-j", "MASQUERADE"}, comment...),
+		append([]string{"-t", "filter", "FORWARD", "-i", ifname, "-j", "ACCEPT"}, comment...),
+		append([]string{"-t", "filter", "FORWARD", "-o", ifname, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}, comment...),
+	}
+}
+
+func iptablesArgs(rule []string, action string) []string {
+	args := []string{rule[0], rule[1], action, rule[2]}
+	return append(args, rule[3:]...)
+}
+
+func enableNAT(ifname string, vpnNet *net.IPNet) error {
+	before, err := os.ReadFile(ipForwardPath)
+	if err != nil {
+		return fmt.Errorf("read ip forwarding: %v", err)
+	}
+	err = os.WriteFile(ipForwardPath, []byte("1"), 0644)
+	if err != nil {
+		return fmt.Errorf("enable ip forwarding: %v", err)
+	}
+	ipForwardBefore = bytes.TrimSpace(before)
+
+	for _, rule := range natRules(ifname, vpnNet) {
+		if runCommand("iptables", iptablesArgs(rule, "-C")...) == nil {
+			continue
+		}
+		err = runCommand("iptables", iptablesArgs(rule, "-A")...)
+		if err != nil {
+			_ = disableNAT(ifname, vpnNet)
+			return fmt.Errorf("add iptables rule: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func disableNAT(ifname string, vpnNet *net.IPNet) error {
+	for _, rule := range natRules(ifname, vpnNet) {
+		_ = runCommand("iptables", iptablesArgs(rule, "-D")...)
+	}
+	if len(ipForwardBefore) != 0 {
+		err := os.WriteFile(ipForwardPath, ipForwardBefore, 0644)
+		if err != nil {
+			return fmt.Errorf("restore ip forwarding: %v", err)
+		}
+		ipForwardBefore = nil
+	}
+
+	return nil
+}
+
 func (d *Device) InterfaceName() (string, error) {
-	interfaceName, err := d.tun.Name()
+	interfaceName, err := d.tun().Name()
 	if err != nil {
 		return "", err
 	}
@@ -23,8 +23,36 @@ func newTUN(ifname string, mtu int, localIP net.IP, ipMask net.IPMask) (tun.Devi
 	return tt.TUN(), nil
 }
 
+func setIPv6(_ tun.Device, _ net.IP, _ net.IPMask) error {
+	return nil
+}
+
+func addExitRoutes(_ string, _ []net.IP) error {
+	return ErrExitNodeUnsupported
+}
+
+func removeExitRoutes(_ string, _ []net.IP) {}
+
+func addSubnetRoute(_ string, _ *net.IPNet) error {
+	return ErrSubnetRoutesUnsupported
+}
+
+func removeSubnetRoute(_ string, _ *net.IPNet) {}
+
+func setLinkUp(_ string, _ bool) error {
+	return nil
+}
+
+func enableNAT(_ string, _ *net.IPNet) error {
+	return ErrExitNodeUnsupported
+}
+
+func disableNAT(_ string, _ *net.IPNet) error {
+	return nil
+}
+
 func (d *Device) InterfaceName() (string, error) {
-	interfaceName, err := d.tun.Name()
+	interfaceName, err := d.tun().Name()
 	if err != nil {
 		return "", err
 	}
@@ -59,8 +59,51 @@ func newTUN(ifname string, mtu int, localIP net.IP, ipMask net.IPMask) (tun.Devi
 	return tunDevice, nil
 }
 
+func setIPv6(tunDevice tun.Device, localIPv6 net.IP, ipv6Mask net.IPMask) error {
+	nativeTunDevice := tunDevice.(*tun.NativeTun)
+	luid := winipcfg.LUID(nativeTunDevice.LUID())
+
+	ones, _ := ipv6Mask.Size()
+	netipAddr := netip.MustParseAddr(localIPv6.String())
+	prefix := netip.PrefixFrom(netipAddr, ones)
+
+	err := luid.AddIPAddress(prefix)
+	if err != nil {
+		return fmt.Errorf("unable to setup interface IPv6: %v", err)
+	}
+
+	return nil
+}
+
+// TODO: implement exit node routes with winipcfg and NAT with WinNAT
+func addExitRoutes(_ string, _ []net.IP) error {
+	return ErrExitNodeUnsupported
+}
+
+func removeExitRoutes(_ string, _ []net.IP) {}
+
+// TODO: implement subnet routes with winipcfg
+func addSubnetRoute(_ string, _ *net.IPNet) error {
+	return ErrSubnetRoutesUnsupported
+}
+
+func removeSubnetRoute(_ string, _ *net.IPNet) {}
+
+// setLinkUp does nothing, disabled wintun adapter loses its addresses. Traffic is dropped by Device while it's paused.
+func setLinkUp(_ string, _ bool) error {
+	return nil
+}
+
+func enableNAT(_ string, _ *net.IPNet) error {
+	return ErrExitNodeUnsupported
+}
+
+func disableNAT(_ string, _ *net.IPNet) error {
+	return nil
+}
+
 func (d *Device) InterfaceName() (string, error) {
-	nativeTun := d.tun.(*tun.NativeTun)
+	nativeTun := d.tun().(*tun.NativeTun)
 	luid := winipcfg.LUID(nativeTun.LUID())
 	guid, err := luid.GUID()
 	if err != nil {
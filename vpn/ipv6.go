@@ -0,0 +1,97 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	ipv6HeaderHopByHop    = 0
+	ipv6HeaderRouting     = 43
+	ipv6HeaderFragment    = 44
+	ipv6HeaderDestOptions = 60
+	ipv6FragmentHeaderLen = 8
+	ipv6FragmentOffsetMax = 0xfff8
+)
+
+// ipv6TransportHeader skips extension headers and returns upper-layer protocol and offset of its header.
+// fragmented is true if the packet is a fragment, ok is false for malformed packets and non-first fragments.
+func ipv6TransportHeader(packet []byte) (protocol uint8, offset int, fragmented, ok bool) {
+	if len(packet) < ipv6.HeaderLen {
+		return 0, 0, false, false
+	}
+	protocol, offset = packet[6], ipv6.HeaderLen
+	for {
+		switch protocol {
+		case ipv6HeaderHopByHop, ipv6HeaderRouting, ipv6HeaderDestOptions:
+			if len(packet) < offset+2 {
+				return protocol, offset, fragmented, false
+			}
+			protocol, offset = packet[offset], offset+(int(packet[offset+1])+1)*8
+		case ipv6HeaderFragment:
+			if len(packet) < offset+ipv6FragmentHeaderLen {
+				return protocol, offset, fragmented, false
+			}
+			fragmented = true
+			if binary.BigEndian.Uint16(packet[offset+2:offset+4])&ipv6FragmentOffsetMax != 0 {
+				return protocol, offset, fragmented, false
+			}
+			protocol, offset = packet[offset], offset+ipv6FragmentHeaderLen
+		default:
+			return protocol, offset, fragmented, offset <= len(packet)
+		}
+	}
+}
+
+// checksumIPv6Upper calculates checksum of TCP, UDP or ICMPv6 with rfc8200 pseudo-header.
+func checksumIPv6Upper(headerAndPayload []byte, protocol uint8, srcIP net.IP, dstIP net.IP) uint16 {
+	var csum uint32
+	for i := 0; i < net.IPv6len; i += 2 {
+		csum += uint32(srcIP[i])<<8 + uint32(srcIP[i+1])
+		csum += uint32(dstIP[i])<<8 + uint32(dstIP[i+1])
+	}
+
+	totalLen := uint32(len(headerAndPayload))
+	csum += uint32(protocol)
+	csum += totalLen & 0xffff
+	csum += totalLen >> 16
+
+	return tcpipChecksum(headerAndPayload, csum)
+}
+
+func (data *Packet) recalculateChecksumIPv6() {
+	protocol, offset, fragmented, ok := ipv6TransportHeader(data.Packet)
+	// TODO: fragments need incremental checksum update as checksum covers the whole datagram
+	if !ok || fragmented {
+		return
+	}
+
+	var checksumOffset int
+	switch protocol {
+	case ipProtocolTCP:
+		checksumOffset = offset + 16
+	case ipProtocolUDP:
+		checksumOffset = offset + 6
+	case ipProtocolICMPv6:
+		checksumOffset = offset + 2
+	default:
+		return
+	}
+	if len(data.Packet) < checksumOffset+2 {
+		return
+	}
+
+	copy(data.Packet[checksumOffset:], []byte{0, 0})
+	checksum := checksumIPv6Upper(data.Packet[offset:], protocol, data.Src, data.Dst)
+	// rfc8200: zero udp checksum is transmitted as all ones
+	if checksum == 0 && protocol == ipProtocolUDP {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(data.Packet[checksumOffset:], checksum)
+}
+
+func isUnicastIPv6(ip net.IP) bool {
+	return len(ip) == net.IPv6len && ip.To4() == nil && !ip.IsMulticast() && !ip.IsUnspecified()
+}
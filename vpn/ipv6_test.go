@@ -0,0 +1,156 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	testLocalIPv6 = net.ParseIP("fd61:776c:6c00::a42:1")
+	testPeerIPv6  = net.ParseIP("fd61:776c:6c00::a42:2")
+)
+
+func TestIPv6TransportHeader(t *testing.T) {
+	a := require.New(t)
+
+	packet, _ := testIPv6UDPPacket(0, nil)
+	protocol, offset, fragmented, ok := ipv6TransportHeader(packet.Packet)
+	a.True(ok)
+	a.False(fragmented)
+	a.EqualValues(ipProtocolUDP, protocol)
+	a.Equal(ipv6.HeaderLen, offset)
+
+	// hop-by-hop options with padding
+	hopByHop := []byte{ipProtocolUDP, 0, 1, 4, 0, 0, 0, 0}
+	packet, _ = testIPv6UDPPacket(ipv6HeaderHopByHop, hopByHop)
+	protocol, offset, fragmented, ok = ipv6TransportHeader(packet.Packet)
+	a.True(ok)
+	a.False(fragmented)
+	a.EqualValues(ipProtocolUDP, protocol)
+	a.Equal(ipv6.HeaderLen+len(hopByHop), offset)
+
+	// first fragment
+	fragment := []byte{ipProtocolUDP, 0, 0, 1, 0, 0, 0, 1}
+	packet, _ = testIPv6UDPPacket(ipv6HeaderFragment, fragment)
+	protocol, _, fragmented, ok = ipv6TransportHeader(packet.Packet)
+	a.True(ok)
+	a.True(fragmented)
+	a.EqualValues(ipProtocolUDP, protocol)
+
+	// non-first fragment
+	binary.BigEndian.PutUint16(fragment[2:4], 8<<3)
+	packet, _ = testIPv6UDPPacket(ipv6HeaderFragment, fragment)
+	_, _, fragmented, ok = ipv6TransportHeader(packet.Packet)
+	a.False(ok)
+	a.True(fragmented)
+
+	// truncated extension header
+	_, _, _, ok = ipv6TransportHeader(packet.Packet[:ipv6.HeaderLen+4])
+	a.False(ok)
+}
+
+func TestDevice_WritePacketIPv6(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	for _, extHeader := range [][]byte{nil, {ipProtocolUDP, 0, 1, 4, 0, 0, 0, 0}} {
+		// addresses from the remote peer's point of view
+		packet, _ := testIPv6UDPPacket(ipv6HeaderHopByHop, extHeader)
+		copy(packet.Src, net.ParseIP("fd61:776c:6c00::a43:5"))
+		copy(packet.Dst, net.ParseIP("fd61:776c:6c00::a43:6"))
+		a.NoError(dev.WritePacket(packet, net.IPv4(10, 66, 0, 2).To4(), testPeerIPv6))
+
+		written := <-fake.written
+		header, err := ipv6.ParseHeader(written)
+		a.NoError(err)
+		a.True(testPeerIPv6.Equal(header.Src))
+		a.True(testLocalIPv6.Equal(header.Dst))
+		offset := ipv6.HeaderLen + len(extHeader)
+		a.Zero(checksumIPv6Upper(written[offset:], ipProtocolUDP, header.Src, header.Dst))
+		a.Equal([]byte("hello world!"), written[offset+8:])
+	}
+
+	packet, _ := testIPv6UDPPacket(0, nil)
+	a.ErrorIs(dev.WritePacket(packet, net.IPv4(10, 66, 0, 2).To4(), nil), ErrIPv6Disabled)
+	a.Len(fake.written, 0)
+}
+
+func TestDevice_WriteICMPv6Unreachable(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	packet, rawData := testIPv6UDPPacket(0, nil)
+	err = dev.WriteICMPUnreachable(packet, ICMPCodeAdminProhibited)
+	a.NoError(err)
+
+	written := <-fake.written
+	header, err := ipv6.ParseHeader(written)
+	a.NoError(err)
+	a.Equal(len(written)-ipv6.HeaderLen, header.PayloadLen)
+	a.Equal(ipProtocolICMPv6, header.NextHeader)
+	a.True(testPeerIPv6.Equal(header.Src))
+	a.True(testLocalIPv6.Equal(header.Dst))
+	a.Zero(checksumIPv6Upper(written[ipv6.HeaderLen:], ipProtocolICMPv6, header.Src, header.Dst))
+
+	message, err := icmp.ParseMessage(ipProtocolICMPv6, written[ipv6.HeaderLen:])
+	a.NoError(err)
+	a.Equal(ipv6.ICMPTypeDestinationUnreachable, message.Type)
+	a.Equal(icmpv6CodeAdminProhibited, message.Code)
+	body, ok := message.Body.(*icmp.DstUnreach)
+	a.True(ok)
+	a.Equal(rawData, body.Data)
+
+	// no errors about icmpv6 errors
+	errorPacket := new(Packet)
+	errorPacket.Packet = written
+	a.True(errorPacket.Parse())
+	a.NoError(dev.WriteICMPUnreachable(errorPacket, ICMPCodeHostUnreachable))
+
+	// no errors for multicast
+	multicastPacket, _ := testIPv6UDPPacket(0, nil)
+	copy(multicastPacket.Dst, net.ParseIP("ff02::1"))
+	a.NoError(dev.WriteICMPUnreachable(multicastPacket, ICMPCodeHostUnreachable))
+	a.Len(fake.written, 0)
+}
+
+// testIPv6UDPPacket returns UDP packet from testLocalIPv6 to testPeerIPv6 with optional extension header.
+func testIPv6UDPPacket(extHeaderType uint8, extHeader []byte) (*Packet, []byte) {
+	payload := []byte("hello world!")
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], 43472)
+	binary.BigEndian.PutUint16(udp[2:4], 9090)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], payload)
+	binary.BigEndian.PutUint16(udp[6:8], checksumIPv6Upper(udp, ipProtocolUDP, testLocalIPv6, testPeerIPv6))
+
+	data := make([]byte, ipv6.HeaderLen, ipv6.HeaderLen+len(extHeader)+len(udp))
+	data[0] = ipv6.Version << 4
+	binary.BigEndian.PutUint16(data[4:6], uint16(len(extHeader)+len(udp)))
+	data[6] = ipProtocolUDP
+	if len(extHeader) > 0 {
+		data[6] = extHeaderType
+	}
+	data[7] = 64
+	copy(data[8:24], testLocalIPv6)
+	copy(data[24:40], testPeerIPv6)
+	data = append(data, extHeader...)
+	data = append(data, udp...)
+
+	packet := new(Packet)
+	_, _ = packet.ReadFrom(bytes.NewReader(data))
+	packet.Parse()
+
+	return packet, data
+}
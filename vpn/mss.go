@@ -0,0 +1,74 @@
+package vpn
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// MinPeerMTU is the minimal MTU of a path to a peer, see KnownPeer.MTU. It's the minimal IPv4 datagram size from rfc791.
+	MinPeerMTU = 576
+
+	tcpHeaderLen    = 20
+	tcpFlagSYN      = 0x02
+	tcpOptionEnd    = 0
+	tcpOptionNOP    = 1
+	tcpOptionMSS    = 2
+	tcpOptionMSSLen = 4
+)
+
+// ClampTCPMSS lowers maximum segment size option of TCP SYN packet, so the receiver sends segments which fit mtu.
+// It should be applied to SYN packets in both directions to limit segments of the connection both ways.
+// Checksums are updated if the option was changed. Returns true if the packet was changed.
+func (data *Packet) ClampTCPMSS(mtu int) bool {
+	packet := data.Packet
+	var offset, maxMSS int
+	if data.IsIPv6 {
+		protocol, transportOffset, fragmented, ok := ipv6TransportHeader(packet)
+		if !ok || fragmented || protocol != ipProtocolTCP {
+			return false
+		}
+		offset, maxMSS = transportOffset, mtu-ipv6.HeaderLen-tcpHeaderLen
+	} else {
+		if len(packet) < ipv4.HeaderLen || packet[9] != ipProtocolTCP || binary.BigEndian.Uint16(packet[6:8])&0x1fff != 0 {
+			return false
+		}
+		offset, maxMSS = int(packet[0]&0x0f)<<2, mtu-ipv4.HeaderLen-tcpHeaderLen
+	}
+	if len(packet) < offset+tcpHeaderLen || packet[offset+13]&tcpFlagSYN == 0 {
+		return false
+	}
+	headerLen := int(packet[offset+12]>>4) << 2
+	if headerLen < tcpHeaderLen || len(packet) < offset+headerLen {
+		return false
+	}
+
+	options := packet[offset+tcpHeaderLen : offset+headerLen]
+	for i := 0; i < len(options); {
+		kind := options[i]
+		if kind == tcpOptionEnd {
+			return false
+		}
+		if kind == tcpOptionNOP {
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return false
+		}
+		length := int(options[i+1])
+		if kind == tcpOptionMSS && length == tcpOptionMSSLen {
+			mss := binary.BigEndian.Uint16(options[i+2 : i+4])
+			if int(mss) <= maxMSS {
+				return false
+			}
+			binary.BigEndian.PutUint16(options[i+2:i+4], uint16(maxMSS))
+			data.RecalculateChecksum()
+			return true
+		}
+		i += length
+	}
+	return false
+}
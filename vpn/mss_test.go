@@ -0,0 +1,89 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestPacket_ClampTCPMSS(t *testing.T) {
+	a := require.New(t)
+	mssOf := func(packet *Packet, offset int) uint16 {
+		return binary.BigEndian.Uint16(packet.Packet[offset+tcpHeaderLen+3:])
+	}
+	checksumValid := func(packet *Packet) bool {
+		data := append([]byte(nil), packet.Packet...)
+		packet.RecalculateChecksum()
+		return bytes.Equal(data, packet.Packet)
+	}
+
+	packet := testTCPPacket(false, tcpFlagSYN, 1460)
+	a.True(packet.ClampTCPMSS(1400))
+	a.EqualValues(1400-40, mssOf(packet, ipv4.HeaderLen))
+	a.True(checksumValid(packet))
+	// already small enough
+	a.False(packet.ClampTCPMSS(1400))
+	a.False(packet.ClampTCPMSS(3500))
+
+	packet = testTCPPacket(true, tcpFlagSYN|0x10, 1440)
+	a.True(packet.ClampTCPMSS(1280))
+	a.EqualValues(1280-60, mssOf(packet, ipv6.HeaderLen))
+	a.True(checksumValid(packet))
+
+	// only SYN packets are changed
+	packet = testTCPPacket(false, 0x10, 1460)
+	a.False(packet.ClampTCPMSS(1400))
+	a.EqualValues(1460, mssOf(packet, ipv4.HeaderLen))
+
+	udpPacket, _ := testUDPPacket()
+	a.False(udpPacket.ClampTCPMSS(MinPeerMTU))
+
+	// truncated options
+	packet = testTCPPacket(false, tcpFlagSYN, 1460)
+	packet.Packet = packet.Packet[:ipv4.HeaderLen+tcpHeaderLen+3]
+	a.False(packet.ClampTCPMSS(1400))
+}
+
+// testTCPPacket returns packet with NOP, MSS and SACK permitted options.
+func testTCPPacket(isIPv6 bool, flags byte, mss uint16) *Packet {
+	tcp := make([]byte, tcpHeaderLen, tcpHeaderLen+8)
+	binary.BigEndian.PutUint16(tcp[0:2], 43472)
+	binary.BigEndian.PutUint16(tcp[2:4], 22)
+	tcp[12] = (tcpHeaderLen + 8) / 4 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	tcp = append(tcp, tcpOptionNOP, tcpOptionMSS, tcpOptionMSSLen, 0, 0, 4, 2, tcpOptionEnd)
+	binary.BigEndian.PutUint16(tcp[tcpHeaderLen+3:], mss)
+
+	var data []byte
+	if isIPv6 {
+		data = make([]byte, ipv6.HeaderLen)
+		data[0] = ipv6.Version << 4
+		binary.BigEndian.PutUint16(data[4:6], uint16(len(tcp)))
+		data[6] = ipProtocolTCP
+		data[7] = 64
+		copy(data[8:24], testLocalIPv6)
+		copy(data[24:40], testPeerIPv6)
+	} else {
+		data = make([]byte, ipv4.HeaderLen)
+		data[0] = ipv4.Version<<4 | ipv4.HeaderLen/4
+		binary.BigEndian.PutUint16(data[2:4], uint16(ipv4.HeaderLen+len(tcp)))
+		data[8] = 64
+		data[9] = ipProtocolTCP
+		copy(data[12:16], net.IPv4(10, 66, 0, 1).To4())
+		copy(data[16:20], net.IPv4(10, 66, 0, 2).To4())
+	}
+	data = append(data, tcp...)
+
+	packet := new(Packet)
+	_, _ = packet.ReadFrom(bytes.NewReader(data))
+	packet.Parse()
+	packet.RecalculateChecksum()
+
+	return packet
+}
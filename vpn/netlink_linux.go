@@ -0,0 +1,136 @@
+//go:build linux && !android
+// +build linux,!android
+
+package vpn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// addInterfaceAddress assigns the address to the interface with rtnetlink, the same as 'ip addr replace'.
+// It uses only syscalls which are available on all linux architectures, e.g. riscv64.
+func addInterfaceAddress(ifname string, ip net.IP, mask net.IPMask) error {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return err
+	}
+	request, err := newAddrRequest(1, iface.Index, ip, mask)
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("open netlink socket: %v", err)
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+	kernel := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	err = unix.Sendto(fd, request, 0, kernel)
+	if err != nil {
+		return fmt.Errorf("send netlink request: %v", err)
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("receive netlink response: %v", err)
+		}
+		done, err := parseNetlinkAck(buf[:n], 1)
+		if done {
+			return err
+		}
+	}
+}
+
+// newAddrRequest makes RTM_NEWADDR message which replaces the address of the interface and requests ack.
+func newAddrRequest(seq uint32, ifindex int, ip net.IP, mask net.IPMask) ([]byte, error) {
+	family := unix.AF_INET6
+	if ip4 := ip.To4(); ip4 != nil {
+		family, ip = unix.AF_INET, ip4
+	} else if ip = ip.To16(); ip == nil {
+		return nil, fmt.Errorf("invalid ip address")
+	}
+	ones, bits := mask.Size()
+	if bits != len(ip)*8 {
+		return nil, fmt.Errorf("mask %s doesn't match ip %s", mask, ip)
+	}
+
+	attrLen := unix.SizeofRtAttr + len(ip)
+	attrSpace := (attrLen + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+	size := unix.SizeofNlMsghdr + unix.SizeofIfAddrmsg + 2*attrSpace
+	b := make([]byte, 0, size)
+
+	b = binary.NativeEndian.AppendUint32(b, uint32(size))
+	b = binary.NativeEndian.AppendUint16(b, unix.RTM_NEWADDR)
+	b = binary.NativeEndian.AppendUint16(b, unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_REPLACE)
+	b = binary.NativeEndian.AppendUint32(b, seq)
+	b = binary.NativeEndian.AppendUint32(b, 0)
+
+	b = append(b, byte(family), byte(ones), 0, unix.RT_SCOPE_UNIVERSE)
+	b = binary.NativeEndian.AppendUint32(b, uint32(ifindex))
+
+	for _, attrType := range []uint16{unix.IFA_LOCAL, unix.IFA_ADDRESS} {
+		b = binary.NativeEndian.AppendUint16(b, uint16(attrLen))
+		b = binary.NativeEndian.AppendUint16(b, attrType)
+		b = append(b, ip...)
+		b = append(b, make([]byte, attrSpace-attrLen)...)
+	}
+
+	return b, nil
+}
+
+// parseNetlinkAck returns true with the error of the request if the messages contain its ack.
+func parseNetlinkAck(b []byte, seq uint32) (bool, error) {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return true, fmt.Errorf("parse netlink response: %v", err)
+	}
+	for _, msg := range msgs {
+		if msg.Header.Seq != seq || msg.Header.Type != unix.NLMSG_ERROR {
+			continue
+		}
+		if len(msg.Data) < 4 {
+			return true, fmt.Errorf("netlink ack is too short")
+		}
+		errno := int32(binary.NativeEndian.Uint32(msg.Data[:4]))
+		if errno != 0 {
+			return true, syscall.Errno(-errno)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// setInterfaceUp brings the interface up with ioctl, the same as 'ip link set dev ifname up'.
+func setInterfaceUp(ifname string) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+	ifr, err := unix.NewIfreq(ifname)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr)
+	if err != nil {
+		return fmt.Errorf("get interface flags: %v", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	err = unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+	if err != nil {
+		return fmt.Errorf("set interface flags: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build linux && !android
+// +build linux,!android
+
+package vpn
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestNewAddrRequest(t *testing.T) {
+	a := require.New(t)
+
+	for _, tc := range []struct {
+		ip     net.IP
+		mask   net.IPMask
+		family uint8
+		ones   uint8
+	}{
+		{net.ParseIP("10.66.0.1"), net.CIDRMask(16, 32), unix.AF_INET, 16},
+		{net.ParseIP("fd66::1"), net.CIDRMask(64, 128), unix.AF_INET6, 64},
+	} {
+		request, err := newAddrRequest(7, 3, tc.ip, tc.mask)
+		a.NoError(err)
+		msgs, err := syscall.ParseNetlinkMessage(request)
+		a.NoError(err)
+		a.Len(msgs, 1)
+		msg := msgs[0]
+		a.EqualValues(len(request), msg.Header.Len)
+		a.EqualValues(unix.RTM_NEWADDR, msg.Header.Type)
+		a.EqualValues(7, msg.Header.Seq)
+		a.NotZero(msg.Header.Flags & unix.NLM_F_ACK)
+
+		a.Equal(tc.family, msg.Data[0])
+		a.Equal(tc.ones, msg.Data[1])
+		a.EqualValues(3, binary.NativeEndian.Uint32(msg.Data[4:8]))
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+		a.NoError(err)
+		a.Len(attrs, 2)
+		for _, attr := range attrs {
+			a.True(tc.ip.Equal(attr.Value), "attribute %d: %v", attr.Attr.Type, net.IP(attr.Value))
+		}
+	}
+
+	_, err := newAddrRequest(1, 3, net.ParseIP("10.66.0.1"), net.CIDRMask(64, 128))
+	a.Error(err)
+}
+
+func TestParseNetlinkAck(t *testing.T) {
+	a := require.New(t)
+
+	ack := func(seq uint32, errno int32) []byte {
+		b := binary.NativeEndian.AppendUint32(nil, unix.SizeofNlMsghdr+4)
+		b = binary.NativeEndian.AppendUint16(b, unix.NLMSG_ERROR)
+		b = binary.NativeEndian.AppendUint16(b, 0)
+		b = binary.NativeEndian.AppendUint32(b, seq)
+		b = binary.NativeEndian.AppendUint32(b, 0)
+		return binary.NativeEndian.AppendUint32(b, uint32(errno))
+	}
+
+	done, err := parseNetlinkAck(ack(1, 0), 1)
+	a.True(done)
+	a.NoError(err)
+
+	done, err = parseNetlinkAck(ack(1, -int32(unix.EPERM)), 1)
+	a.True(done)
+	a.ErrorIs(err, syscall.EPERM)
+
+	// ack of another request is skipped
+	done, err = parseNetlinkAck(ack(2, 0), 1)
+	a.False(done)
+	a.NoError(err)
+}
@@ -0,0 +1,99 @@
+package vpn
+
+import (
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Priority is the traffic class of packet, packets of higher classes are sent first when the link to the peer is saturated.
+type Priority uint8
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	// PriorityClasses is the number of priorities
+	PriorityClasses = 3
+)
+
+// DiffServ code points, see rfc4594 and rfc8622.
+const (
+	dscpLE  = 1
+	dscpCS1 = 8
+	dscpCS4 = 32
+	dscpCS5 = 40
+	dscpEF  = 46
+	dscpCS6 = 48
+	dscpCS7 = 56
+)
+
+// interactivePorts are ports of latency sensitive protocols with small packets: SSH, DNS, NTP, STUN and SIP.
+var interactivePorts = map[uint16]bool{
+	22:   true,
+	53:   true,
+	123:  true,
+	3478: true,
+	5060: true,
+	5061: true,
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// ParsePriority parses priority name: high, normal or low.
+func ParsePriority(name string) (Priority, bool) {
+	for p := Priority(0); p < PriorityClasses; p++ {
+		if p.String() == name {
+			return p, true
+		}
+	}
+	return PriorityNormal, false
+}
+
+// DSCP returns DiffServ code point of the packet, zero for malformed packets.
+func (data *Packet) DSCP() uint8 {
+	packet := data.Packet
+	switch {
+	case data.IsIPv6 && len(packet) >= ipv6.HeaderLen:
+		trafficClass := packet[0]<<4 | packet[1]>>4
+		return trafficClass >> 2
+	case !data.IsIPv6 && len(packet) >= ipv4.HeaderLen:
+		return packet[1] >> 2
+	default:
+		return 0
+	}
+}
+
+// DefaultPriority classifies packet by DSCP marks of applications, e.g. EF of VoIP or LE of background updates,
+// then ICMP and ports of interactive protocols are high priority. Classes don't depend on packet size,
+// so packets of one flow are in the same class and aren't reordered.
+func (data *Packet) DefaultPriority() Priority {
+	switch dscp := data.DSCP(); {
+	case dscp == dscpLE || dscp == dscpCS1:
+		return PriorityLow
+	case dscp >= dscpCS4 && dscp <= dscpEF, dscp == dscpCS6, dscp == dscpCS7:
+		return PriorityHigh
+	}
+
+	protocol, srcPort, dstPort, ok := parseTransport(data)
+	if !ok {
+		return PriorityNormal
+	}
+	switch protocol {
+	case ipProtocolICMP, ipProtocolICMPv6:
+		return PriorityHigh
+	case ipProtocolTCP, ipProtocolUDP:
+		if interactivePorts[srcPort] || interactivePorts[dstPort] {
+			return PriorityHigh
+		}
+	}
+	return PriorityNormal
+}
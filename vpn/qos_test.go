@@ -0,0 +1,43 @@
+package vpn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacket_DefaultPriority(t *testing.T) {
+	a := require.New(t)
+
+	packet, _ := testUDPPacket()
+	a.Zero(packet.DSCP())
+	a.Equal(PriorityNormal, packet.DefaultPriority())
+	packet.Packet[1] = dscpEF << 2
+	a.EqualValues(dscpEF, packet.DSCP())
+	a.Equal(PriorityHigh, packet.DefaultPriority())
+	packet.Packet[1] = dscpCS1 << 2
+	a.Equal(PriorityLow, packet.DefaultPriority())
+	packet.Packet[1] = 0
+	packet.Packet[ipv4offsetProtocol] = ipProtocolICMP
+	a.Equal(PriorityHigh, packet.DefaultPriority())
+
+	// ssh
+	a.Equal(PriorityHigh, testTCPPacket(false, tcpFlagSYN, 1460).DefaultPriority())
+	a.Equal(PriorityHigh, testTCPPacket(true, tcpFlagSYN, 1460).DefaultPriority())
+
+	packet, _ = testIPv6UDPPacket(0, nil)
+	a.Equal(PriorityNormal, packet.DefaultPriority())
+	// DSCP is in the upper bits of traffic class after the version
+	packet.Packet[0] |= dscpLE >> 2
+	packet.Packet[1] |= dscpLE << 6
+	a.EqualValues(dscpLE, packet.DSCP())
+	a.Equal(PriorityLow, packet.DefaultPriority())
+
+	for p := PriorityLow; p < PriorityClasses; p++ {
+		parsed, ok := ParsePriority(p.String())
+		a.True(ok)
+		a.Equal(p, parsed)
+	}
+	_, ok := ParsePriority("urgent")
+	a.False(ok)
+}
@@ -0,0 +1,65 @@
+package vpn
+
+import (
+	"runtime"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// MaxQueues limits number of interface queues and packet processing workers.
+const MaxQueues = 8
+
+// DefaultQueues returns number of queues to use on this machine: one per CPU, up to MaxQueues.
+func DefaultQueues() int {
+	return min(runtime.GOMAXPROCS(0), MaxQueues)
+}
+
+type tunHolder struct {
+	tun.Device
+	// queues are additional queues of multiqueue interface, see openTUNQueues
+	queues []tun.Device
+}
+
+// queue returns nil if the interface has less queues than i+1.
+func (h *tunHolder) queue(i int) tun.Device {
+	if i == 0 {
+		return h.Device
+	}
+	if i-1 < len(h.queues) {
+		return h.queues[i-1]
+	}
+	return nil
+}
+
+func (h *tunHolder) close() error {
+	for _, queue := range h.queues {
+		_ = queue.Close()
+	}
+	return h.Device.Close()
+}
+
+// FlowHash returns the same hash for all packets of the flow in one direction.
+// Packet should be parsed before.
+func (data *Packet) FlowHash() uint32 {
+	// FNV-1a
+	const (
+		offset = 2166136261
+		prime  = 16777619
+	)
+	hash := uint32(offset)
+	add := func(b byte) {
+		hash ^= uint32(b)
+		hash *= prime
+	}
+	for _, b := range data.Src {
+		add(b)
+	}
+	for _, b := range data.Dst {
+		add(b)
+	}
+	protocol, srcPort, dstPort, _ := parseTransport(data)
+	for _, b := range [...]byte{protocol, byte(srcPort >> 8), byte(srcPort), byte(dstPort >> 8), byte(dstPort)} {
+		add(b)
+	}
+	return hash
+}
@@ -0,0 +1,81 @@
+//go:build linux && !android
+// +build linux,!android
+
+package vpn
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const cloneDevicePath = "/dev/net/tun"
+
+// createMultiQueueTUN is the same as tun.CreateTUN, but the interface could have additional queues, see openTUNQueues.
+func createMultiQueueTUN(name string, mtu int) (tun.Device, error) {
+	fd, err := openTUNQueue(name)
+	if err != nil {
+		return nil, err
+	}
+	return tun.CreateTUNFromFile(os.NewFile(uintptr(fd), cloneDevicePath), mtu)
+}
+
+// openTUNQueues opens additional queues of multiqueue interface, the kernel spreads packets between queues by flows.
+func openTUNQueues(tunDevice tun.Device, count int) ([]tun.Device, error) {
+	name, err := tunDevice.Name()
+	if err != nil {
+		return nil, fmt.Errorf("get interface name: %v", err)
+	}
+
+	queues := make([]tun.Device, 0, count)
+	closeQueues := func() {
+		for _, queue := range queues {
+			_ = queue.Close()
+		}
+	}
+	for i := 0; i < count; i++ {
+		fd, err := openTUNQueue(name)
+		if err != nil {
+			closeQueues()
+			return nil, err
+		}
+		// events are delivered to the first queue
+		queue, _, err := tun.CreateUnmonitoredTUNFromFD(fd)
+		if err != nil {
+			_ = unix.Close(fd)
+			closeQueues()
+			return nil, fmt.Errorf("create queue: %v", err)
+		}
+		queues = append(queues, queue)
+	}
+
+	return queues, nil
+}
+
+func openTUNQueue(name string) (int, error) {
+	fd, err := unix.Open(cloneDevicePath, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("open %s: %v", cloneDevicePath, err)
+	}
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		_ = unix.Close(fd)
+		return -1, err
+	}
+	// IFF_VNET_HDR enables offloads, the same as in tun.CreateTUN
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI | unix.IFF_VNET_HDR | unix.IFF_MULTI_QUEUE)
+	err = unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr)
+	if err != nil {
+		_ = unix.Close(fd)
+		return -1, fmt.Errorf("set interface flags: %v", err)
+	}
+	err = unix.SetNonblock(fd, true)
+	if err != nil {
+		_ = unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux || android
+// +build !linux android
+
+package vpn
+
+import (
+	"errors"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+func openTUNQueues(tun.Device, int) ([]tun.Device, error) {
+	return nil, errors.New("multiqueue interface is supported only on linux")
+}
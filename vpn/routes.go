@@ -0,0 +1,157 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// MaxSubnetRoutes limits the number of routes advertised by a single peer.
+const MaxSubnetRoutes = 32
+
+// ErrSubnetRoutesUnsupported is returned when routes to subnets of peers can't be set on this platform,
+// or the interface was created by the app and routes are managed by it.
+var ErrSubnetRoutesUnsupported = errors.New("subnet routes are not supported on this platform")
+
+// Network returns the VPN network.
+func (d *Device) Network() *net.IPNet {
+	return &net.IPNet{IP: d.localIP.Mask(d.ipMask), Mask: d.ipMask}
+}
+
+// SetSubnetRoutes routes traffic to the networks to the interface, e.g. LANs advertised by peers.
+// Routes which were set before and are not in networks are removed. All routes are tried even if some of them fail.
+func (d *Device) SetSubnetRoutes(networks []*net.IPNet) error {
+	if !d.ownsInterface {
+		if len(networks) == 0 {
+			return nil
+		}
+		return ErrSubnetRoutesUnsupported
+	}
+	d.routesLock.Lock()
+	defer d.routesLock.Unlock()
+
+	ifname, err := d.tun().Name()
+	if err != nil {
+		return fmt.Errorf("get interface name: %v", err)
+	}
+	var result []*net.IPNet
+	for _, route := range d.subnetRoutes {
+		if containsNetwork(networks, route) {
+			result = append(result, route)
+		} else {
+			removeSubnetRoute(ifname, route)
+		}
+	}
+	var firstErr error
+	for _, network := range networks {
+		if containsNetwork(result, network) {
+			continue
+		}
+		err = addSubnetRoute(ifname, network)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("add route %s: %v", network, err)
+			}
+			continue
+		}
+		result = append(result, network)
+	}
+	d.subnetRoutes = result
+
+	return firstErr
+}
+
+// ClearSubnetRoutes removes routes added by SetSubnetRoutes.
+func (d *Device) ClearSubnetRoutes() {
+	err := d.SetSubnetRoutes(nil)
+	if err != nil {
+		d.logger.Errorf("clear subnet routes: %v", err)
+	}
+}
+
+// MinSubnetRouteBits is the shortest prefix of the peer's subnet route accepted by default,
+// wider networks could take over a big part of the address space.
+const MinSubnetRouteBits = 16
+
+// IsPrivateSubnetRoute reports whether network is inside private IPv4 ranges and not wider than MinSubnetRouteBits.
+func IsPrivateSubnetRoute(network *net.IPNet) bool {
+	ones, bits := network.Mask.Size()
+	return bits == 8*net.IPv4len && ones >= MinSubnetRouteBits && network.IP.IsPrivate()
+}
+
+// ValidSubnetRoutes returns unique IPv4 networks in canonical form, e.g. 192.168.10.0/24 for 192.168.10.1/24.
+// Invalid routes, default route and ones above MaxSubnetRoutes are skipped.
+func ValidSubnetRoutes(routes []string) []string {
+	result := make([]string, 0, len(routes))
+	seen := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		_, network, err := net.ParseCIDR(route)
+		if err != nil || network.IP.To4() == nil {
+			continue
+		}
+		if ones, _ := network.Mask.Size(); ones == 0 {
+			continue
+		}
+		route = network.String()
+		if seen[route] {
+			continue
+		}
+		seen[route] = true
+		result = append(result, route)
+		if len(result) == MaxSubnetRoutes {
+			break
+		}
+	}
+	return result
+}
+
+// LocalNetwork is IPv4 network of a local interface, e.g. LAN of the host.
+type LocalNetwork struct {
+	Interface string
+	Network   *net.IPNet
+}
+
+// LocalNetworks returns IPv4 networks of interfaces which are up, except loopback and excludeInterface,
+// which is usually the vpn interface.
+func LocalNetworks(excludeInterface string) ([]LocalNetwork, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var result []LocalNetwork
+	for _, iface := range interfaces {
+		if iface.Name == excludeInterface || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
+				mask := ipNet.Mask
+				if len(mask) == net.IPv6len {
+					mask = mask[net.IPv6len-net.IPv4len:]
+				}
+				network := &net.IPNet{IP: ipNet.IP.To4().Mask(mask), Mask: mask}
+				result = append(result, LocalNetwork{Interface: iface.Name, Network: network})
+			}
+		}
+	}
+	return result, nil
+}
+
+// NetworksOverlap reports whether networks have common addresses.
+func NetworksOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func containsNetwork(networks []*net.IPNet, network *net.IPNet) bool {
+	for _, n := range networks {
+		if n.String() == network.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package vpn
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidSubnetRoutes(t *testing.T) {
+	a := require.New(t)
+
+	routes := ValidSubnetRoutes([]string{"192.168.1.5/24", "192.168.1.0/24", "0.0.0.0/0", "fd00::/64", "invalid", "10.1.0.0/16"})
+	a.Equal([]string{"192.168.1.0/24", "10.1.0.0/16"}, routes)
+
+	many := make([]string, 0, MaxSubnetRoutes+1)
+	for i := 0; i <= MaxSubnetRoutes; i++ {
+		many = append(many, (&net.IPNet{IP: net.IPv4(10, byte(i), 0, 0), Mask: net.CIDRMask(16, 32)}).String())
+	}
+	a.Len(ValidSubnetRoutes(many), MaxSubnetRoutes)
+}
+
+func TestIsPrivateSubnetRoute(t *testing.T) {
+	a := require.New(t)
+
+	for route, expected := range map[string]bool{
+		"192.168.10.0/24": true,
+		"172.16.0.0/16":   true,
+		"10.1.2.3/32":     true,
+		"10.0.0.0/8":      false,
+		"172.16.0.0/12":   false,
+		"8.8.8.0/24":      false,
+		"100.64.0.0/16":   false,
+	} {
+		_, network, err := net.ParseCIDR(route)
+		a.NoError(err)
+		a.Equal(expected, IsPrivateSubnetRoute(network), route)
+	}
+}
+
+func TestNetworksOverlap(t *testing.T) {
+	a := require.New(t)
+
+	_, vpnNet, _ := net.ParseCIDR("10.66.0.0/16")
+	_, inside, _ := net.ParseCIDR("10.66.1.0/24")
+	_, outside, _ := net.ParseCIDR("10.67.0.0/16")
+	_, wide, _ := net.ParseCIDR("10.0.0.0/8")
+	a.True(NetworksOverlap(vpnNet, inside))
+	a.True(NetworksOverlap(vpnNet, wide))
+	a.False(NetworksOverlap(vpnNet, outside))
+}
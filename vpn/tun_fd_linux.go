@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package vpn
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// NewTUNFromFD wraps the interface which was created by the embedding app, e.g. by VpnService on android.
+// The device owns the descriptor, it's closed on error as well. Pass the device to NewDevice.
+func NewTUNFromFD(fd int) (tun.Device, error) {
+	if fd <= 0 {
+		return nil, fmt.Errorf("invalid tun fd %d", fd)
+	}
+	tunDevice, _, err := tun.CreateUnmonitoredTUNFromFD(fd)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("create tun from fd: %v", err)
+	}
+
+	return tunDevice, nil
+}
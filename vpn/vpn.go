@@ -9,10 +9,12 @@ import (
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-log/v2"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
 	"golang.zx2c4.com/wireguard/device"
 	"golang.zx2c4.com/wireguard/tun"
 )
@@ -23,27 +25,100 @@ const (
 	outboundChCap  = 50
 	// internal tun header. see offset in tun_darwin (4) and tun_linux (virtioNetHdrLen, currently 10)
 	tunPacketOffset    = 14
+	ipv4offsetTTL      = 8
 	ipv4offsetChecksum = 10
+	// interface down events are not delivered on all platforms, so read is retried with backoff
+	minReadRetryInterval = 100 * time.Millisecond
+	maxReadRetryInterval = 5 * time.Second
 )
 
-type Device struct {
-	tun        tun.Device
-	mtu        int64
-	localIP    net.IP
-	outboundCh chan *Packet
+var (
+	// ErrInterfaceDown is returned on write when the interface is down. Packets are dropped until it is up again.
+	ErrInterfaceDown = errors.New("interface is down")
+	// ErrIPv6Disabled is returned on write of IPv6 packet when we or the peer don't have IPv6 address.
+	ErrIPv6Disabled = errors.New("ipv6 is disabled")
+	// ErrTTLExpired is returned on write of exit packet which TTL expired, so the packet is not forwarded anymore.
+	ErrTTLExpired = errors.New("ttl expired")
+	// ErrRecreateUnsupported is returned by Recreate if the interface was created outside, e.g. by android app.
+	ErrRecreateUnsupported = errors.New("recreating interface is unsupported")
+)
 
-	packetsPool sync.Pool
-	logger      *log.ZapEventLogger
+type Device struct {
+	tunDevice atomic.Pointer[tunHolder]
+	mtu       int64
+	localIP   net.IP
+	ipMask    net.IPMask
+	localIPv6 net.IP
+	ipv6Mask  net.IPMask
+	// ownsInterface is true if we have created the interface, so we are allowed to change system routes
+	ownsInterface bool
+	routesLock    sync.Mutex
+	exitBypass    []net.IP
+	exitRoutes    bool
+	subnetRoutes  []*net.IPNet
+	natEnabled    bool
+	// localNetworks are networks of our other interfaces, they are never reached through exit node
+	localNetworks atomic.Pointer[[]*net.IPNet]
+
+	packetsPool    sync.Pool
+	logger         *log.ZapEventLogger
+	icmpLimiter    *rate.Limiter
+	filter         atomic.Pointer[packetFilter]
+	filterCounters packetFilterCounters
+
+	up             atomic.Bool
+	paused         atomic.Bool
+	stateLock      sync.Mutex
+	upCh           chan struct{} // closed while interface is up
+	stateCallbacks []func(up bool)
+	closedCh       chan struct{}
+	closeOnce      sync.Once
+
+	// createTUN creates the interface again, it's nil if the interface wasn't created by us
+	createTUN   func() (tun.Device, error)
+	recreateMu  sync.Mutex
+	readFailing atomic.Int64 // unix nano time of the first failed read in a row, zero if the last read succeeded
+
+	// tunQueues is number of interface queues, each one has its own packets reader
+	tunQueues int
+	readers   sync.WaitGroup
+	// outboundChs are packets read from the interface sharded by flows, see OutboundChans
+	outboundChs []chan *Packet
 }
 
-func NewDevice(existingTun tun.Device, interfaceName string, localIP net.IP, ipMask net.IPMask) (*Device, error) {
+// NewDevice creates the device. IPv6 is disabled if localIPv6 is nil or the address couldn't be set to the interface.
+// Packets are processed by queues workers, interface has the same number of queues if it's created by us on linux.
+func NewDevice(existingTun tun.Device, interfaceName string, localIP net.IP, ipMask net.IPMask, localIPv6 net.IP, ipv6Mask net.IPMask, queues int) (*Device, error) {
+	logger := log.Logger("awl/vpn")
 	var tunDevice tun.Device
+	var createTUN func() (tun.Device, error)
 	var err error
-	if existingTun == nil {
+	ownsInterface := existingTun == nil
+	if ownsInterface {
 		tunDevice, err = newTUN(interfaceName, InterfaceMTU, localIP, ipMask)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create TUN device: %v", err)
 		}
+		if localIPv6 != nil {
+			err = setIPv6(tunDevice, localIPv6, ipv6Mask)
+			if err != nil {
+				logger.Warnf("IPv6 is disabled, failed to set address %s: %v", localIPv6, err)
+				localIPv6 = nil
+			}
+		}
+		createTUN = func() (tun.Device, error) {
+			tunDevice, err := newTUN(interfaceName, InterfaceMTU, localIP, ipMask)
+			if err != nil {
+				return nil, err
+			}
+			if localIPv6 != nil {
+				err = setIPv6(tunDevice, localIPv6, ipv6Mask)
+				if err != nil {
+					logger.Warnf("failed to set IPv6 address %s to recreated interface: %v", localIPv6, err)
+				}
+			}
+			return tunDevice, nil
+		}
 	} else {
 		tunDevice = existingTun
 	}
@@ -52,24 +127,123 @@ func NewDevice(existingTun tun.Device, interfaceName string, localIP net.IP, ipM
 	if err != nil {
 		return nil, fmt.Errorf("failed to get TUN mtu: %v", err)
 	}
+	queues = max(1, min(queues, MaxQueues))
+	holder := &tunHolder{Device: tunDevice}
+	if ownsInterface && queues > 1 {
+		holder.queues, err = openTUNQueues(tunDevice, queues-1)
+		if err != nil {
+			logger.Debugf("using single interface queue: %v", err)
+		}
+	}
 
 	dev := &Device{
-		tun:        tunDevice,
-		mtu:        int64(realMtu),
-		localIP:    localIP,
-		outboundCh: make(chan *Packet, outboundChCap),
+		mtu:           int64(realMtu),
+		localIP:       localIP,
+		ipMask:        ipMask,
+		localIPv6:     localIPv6,
+		ipv6Mask:      ipv6Mask,
+		outboundChs:   make([]chan *Packet, queues),
+		ownsInterface: ownsInterface,
 		packetsPool: sync.Pool{
 			New: func() interface{} {
 				return new(Packet)
 			}},
-		logger: log.Logger("awl/vpn"),
+		logger:      logger,
+		icmpLimiter: newICMPLimiter(),
+		upCh:        make(chan struct{}),
+		closedCh:    make(chan struct{}),
+		createTUN:   createTUN,
+		tunQueues:   len(holder.queues) + 1,
+	}
+	for i := range dev.outboundChs {
+		dev.outboundChs[i] = make(chan *Packet, outboundChCap)
 	}
-	go dev.tunEventsReader()
-	go dev.tunPacketsReader()
+	dev.SetPacketFilter(PacketFilter{})
+	dev.tunDevice.Store(holder)
+	dev.up.Store(true)
+	close(dev.upCh)
+	go dev.tunEventsReader(tunDevice)
+	dev.readers.Add(dev.tunQueues)
+	for i := 0; i < dev.tunQueues; i++ {
+		go dev.tunPacketsReader(i)
+	}
+	go func() {
+		dev.readers.Wait()
+		for _, ch := range dev.outboundChs {
+			close(ch)
+		}
+	}()
 
 	return dev, nil
 }
 
+func (d *Device) tun() tun.Device {
+	return d.tunDevice.Load().Device
+}
+
+// ReadFailingSince returns time of the first failed read from the interface since the last successful one.
+// It's zero if reads succeed.
+func (d *Device) ReadFailingSince() time.Time {
+	failing := d.readFailing.Load()
+	if failing == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, failing)
+}
+
+// CanRecreate returns true if the interface was created by us, see Recreate.
+func (d *Device) CanRecreate() bool {
+	return d.createTUN != nil
+}
+
+// Recreate closes the interface and creates it again with the same addresses. Routes and NAT are removed,
+// they should be set again by the caller.
+func (d *Device) Recreate() error {
+	if d.createTUN == nil {
+		return ErrRecreateUnsupported
+	}
+	d.recreateMu.Lock()
+	defer d.recreateMu.Unlock()
+	select {
+	case <-d.closedCh:
+		return os.ErrClosed
+	default:
+	}
+
+	d.ClearExitRoutes()
+	d.ClearSubnetRoutes()
+	err := d.SetNAT(false)
+	if err != nil {
+		d.logger.Warnf("disable nat: %v", err)
+	}
+	// interface with the same name can't be created until the old one is closed
+	err = d.tunDevice.Load().close()
+	if err != nil {
+		d.logger.Warnf("close interface: %v", err)
+	}
+	tunDevice, err := d.createTUN()
+	if err != nil {
+		return fmt.Errorf("create TUN device: %v", err)
+	}
+	mtu, err := tunDevice.MTU()
+	if err == nil {
+		atomic.StoreInt64(&d.mtu, int64(mtu))
+	}
+	holder := &tunHolder{Device: tunDevice}
+	if d.tunQueues > 1 {
+		holder.queues, err = openTUNQueues(tunDevice, d.tunQueues-1)
+		if err != nil {
+			d.logger.Warnf("open interface queues: %v", err)
+		}
+	}
+	d.tunDevice.Store(holder)
+	go d.tunEventsReader(tunDevice)
+	// wakes up the packets reader which waits for the old interface
+	d.setState(true)
+
+	return nil
+}
+
 func (d *Device) GetTempPacket() *Packet {
 	return d.packetsPool.Get().(*Packet)
 }
@@ -79,40 +253,193 @@ func (d *Device) PutTempPacket(data *Packet) {
 	d.packetsPool.Put(data)
 }
 
-// TODO: batch write
-func (d *Device) WritePacket(data *Packet, senderIP net.IP) error {
-	if data.IsIPv6 {
-		// TODO: implement. We need to set Device.localIP ipv6 instead of ipv4
-		return nil
-	} else {
-		copy(data.Src, senderIP)
-		copy(data.Dst, d.localIP)
+// WritePacket writes packet received from the peer to the interface.
+// Source and destination are replaced with the peer address and ours.
+func (d *Device) WritePacket(data *Packet, senderIP, senderIPv6 net.IP) error {
+	return d.writeOne(func(batch *WriteBatch) error {
+		return batch.WritePacket(data, senderIP, senderIPv6)
+	})
+}
+
+// writeOne writes packet added by add without waiting for other packets.
+func (d *Device) writeOne(add func(batch *WriteBatch) error) error {
+	batch := d.NewWriteBatch()
+	err := add(batch)
+	if err != nil {
+		return err
+	}
+	return batch.Flush()
+}
+
+// OutboundChans returns packets read from the interface. Packets of one flow are always in the same channel,
+// so channels could be processed in parallel without reordering.
+func (d *Device) OutboundChans() []<-chan *Packet {
+	result := make([]<-chan *Packet, 0, len(d.outboundChs))
+	for _, ch := range d.outboundChs {
+		result = append(result, ch)
 	}
-	data.RecalculateChecksum()
+	return result
+}
 
-	bufs := [][]byte{data.Buffer[:tunPacketOffset+len(data.Packet)]}
-	packetsCount, err := d.tun.Write(bufs, tunPacketOffset)
+func (d *Device) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closedCh)
+	})
+	d.recreateMu.Lock()
+	defer d.recreateMu.Unlock()
+	d.ClearExitRoutes()
+	d.ClearSubnetRoutes()
+	err := d.SetNAT(false)
 	if err != nil {
-		return fmt.Errorf("write packet to tun: %v", err)
-	} else if packetsCount < len(bufs) {
-		d.logger.Warnf("wrote %d packets, len(bufs): %d", packetsCount, len(bufs))
+		d.logger.Errorf("disable nat: %v", err)
+	}
+	return d.tunDevice.Load().close()
+}
+
+// IsUp returns false after the interface was brought down until it is up again.
+func (d *Device) IsUp() bool {
+	return d.up.Load()
+}
+
+// SubscribeStateChanges registers callback which is called when the interface goes down or up.
+func (d *Device) SubscribeStateChanges(callback func(up bool)) {
+	d.stateLock.Lock()
+	d.stateCallbacks = append(d.stateCallbacks, callback)
+	d.stateLock.Unlock()
+}
+
+// SetPaused brings the interface down and drops traffic until it's resumed, connections to peers are kept.
+// Routes and NAT are removed on pause, they should be set again by the caller after resume.
+func (d *Device) SetPaused(paused bool) error {
+	d.recreateMu.Lock()
+	defer d.recreateMu.Unlock()
+	select {
+	case <-d.closedCh:
+		return os.ErrClosed
+	default:
+	}
+	if d.paused.Swap(paused) == paused {
+		return nil
 	}
 
+	if paused {
+		d.ClearExitRoutes()
+		d.ClearSubnetRoutes()
+		err := d.SetNAT(false)
+		if err != nil {
+			d.logger.Warnf("disable nat: %v", err)
+		}
+		d.logger.Infof("Pausing interface")
+		d.setState(false)
+	}
+	if d.ownsInterface {
+		ifname, err := d.tun().Name()
+		if err == nil {
+			err = setLinkUp(ifname, !paused)
+		}
+		if err != nil {
+			d.logger.Warnf("set interface up %v: %v", !paused, err)
+		}
+		// addresses are flushed from the interface when it goes down on some platforms
+		if !paused && d.localIPv6 != nil {
+			err = setIPv6(d.tun(), d.localIPv6, d.ipv6Mask)
+			if err != nil {
+				d.logger.Debugf("set IPv6 address after resume: %v", err)
+			}
+		}
+	}
+	if !paused {
+		d.logger.Infof("Resuming interface")
+		d.setState(true)
+	}
 	return nil
 }
 
-func (d *Device) OutboundChan() <-chan *Packet {
-	return d.outboundCh
+// IsPaused returns true if the interface is paused by SetPaused.
+func (d *Device) IsPaused() bool {
+	return d.paused.Load()
 }
 
-func (d *Device) Close() error {
-	return d.tun.Close()
+func (d *Device) setState(up bool) {
+	d.stateLock.Lock()
+	// the interface stays down while it's paused even if the system brings it up
+	if up && d.paused.Load() {
+		d.stateLock.Unlock()
+		return
+	}
+	if d.up.Load() == up {
+		d.stateLock.Unlock()
+		return
+	}
+	d.up.Store(up)
+	if up {
+		close(d.upCh)
+	} else {
+		d.upCh = make(chan struct{})
+	}
+	callbacks := append([]func(up bool){}, d.stateCallbacks...)
+	d.stateLock.Unlock()
+
+	if up {
+		d.logger.Infof("Interface is up, resuming")
+	} else {
+		d.logger.Infof("Interface is down, pausing")
+		d.drainOutbound()
+	}
+	for _, callback := range callbacks {
+		callback(up)
+	}
+}
+
+// waitUp blocks until the interface is up, the device is closed or timeout is passed.
+func (d *Device) waitUp(timeout time.Duration) {
+	d.stateLock.Lock()
+	upCh := d.upCh
+	d.stateLock.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-upCh:
+	case <-d.closedCh:
+	case <-timer.C:
+	}
+}
+
+// sleep returns false if the device is closed before timeout.
+func (d *Device) sleep(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-d.closedCh:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// drainOutbound drops packets read before the interface went down, they are stale when it is up again.
+func (d *Device) drainOutbound() {
+	for _, ch := range d.outboundChs {
+	drain:
+		for {
+			select {
+			case packet, open := <-ch:
+				if !open {
+					break drain
+				}
+				d.PutTempPacket(packet)
+			default:
+				break drain
+			}
+		}
+	}
 }
 
-func (d *Device) tunEventsReader() {
-	for event := range d.tun.Events() {
+func (d *Device) tunEventsReader(tunDevice tun.Device) {
+	for event := range tunDevice.Events() {
 		if event&tun.EventMTUUpdate != 0 {
-			mtu, err := d.tun.MTU()
+			mtu, err := tunDevice.MTU()
 			if err != nil {
 				d.logger.Errorf("Failed to load updated MTU of device: %v", err)
 				continue
@@ -132,23 +459,47 @@ func (d *Device) tunEventsReader() {
 			}
 		}
 
-		// TODO: check for event&tun.EventUp
 		if event&tun.EventDown != 0 {
-			d.logger.Infof("Interface down requested")
-			// TODO
+			d.setState(false)
+		}
+		if event&tun.EventUp != 0 {
+			d.setState(true)
 		}
 	}
 }
 
-func (d *Device) tunPacketsReader() {
-	defer close(d.outboundCh)
+// tunPacketsReader reads packets from interface queue. Only the first queue reader changes interface state on errors.
+func (d *Device) tunPacketsReader(queue int) {
+	defer d.readers.Done()
 
-	batchSize := d.tun.BatchSize()
+	tunDevice := d.tunDevice.Load().queue(queue)
+	batchSize := 1
+	if tunDevice != nil {
+		batchSize = tunDevice.BatchSize()
+	}
 	packets := make([]*Packet, batchSize)
 	bufs := make([][]byte, batchSize)
 	sizes := make([]int, batchSize)
+	retryInterval := minReadRetryInterval
 
 	for {
+		// the interface could be recreated, see Recreate
+		if current := d.tunDevice.Load().queue(queue); current != tunDevice {
+			tunDevice = current
+			if tunDevice != nil && tunDevice.BatchSize() > batchSize {
+				batchSize = tunDevice.BatchSize()
+				packets = append(packets, make([]*Packet, batchSize-len(packets))...)
+				bufs = make([][]byte, batchSize)
+				sizes = make([]int, batchSize)
+			}
+		}
+		if tunDevice == nil {
+			// recreated interface has less queues
+			if !d.sleep(maxReadRetryInterval) {
+				return
+			}
+			continue
+		}
 		for i := range packets {
 			if packets[i] == nil {
 				packets[i] = d.GetTempPacket()
@@ -159,7 +510,22 @@ func (d *Device) tunPacketsReader() {
 			sizes[i] = 0
 		}
 
-		packetsCount, err := d.tun.Read(bufs, sizes, tunPacketOffset)
+		packetsCount, err := tunDevice.Read(bufs, sizes, tunPacketOffset)
+		if err == nil && queue == 0 {
+			d.readFailing.Store(0)
+			retryInterval = minReadRetryInterval
+			// some platforms don't send EventUp
+			if !d.IsUp() {
+				d.setState(true)
+			}
+		} else if err == nil {
+			retryInterval = minReadRetryInterval
+		}
+		if d.paused.Load() {
+			// the interface can't be brought down on some platforms, see SetPaused
+			packetsCount = 0
+		}
+		filter := d.filter.Load()
 		for i := 0; i < packetsCount; i++ {
 			size := sizes[i]
 			if size == 0 || size > maxContentSize {
@@ -168,22 +534,46 @@ func (d *Device) tunPacketsReader() {
 
 			data := packets[i]
 			data.Packet = data.Buffer[tunPacketOffset : size+tunPacketOffset]
+			// unwanted packets are dropped before parsing, see SetPacketFilter
+			if !filter.pass(data.Packet, &d.filterCounters) {
+				continue
+			}
 			okay := data.Parse()
 			if !okay {
 				continue
 			}
 
-			d.outboundCh <- data
+			d.outboundChs[data.FlowHash()%uint32(len(d.outboundChs))] <- data
 			packets[i] = nil
 		}
 
 		if errors.Is(err, tun.ErrTooManySegments) {
 			continue
-		} else if errors.Is(err, os.ErrClosed) {
+		} else if errors.Is(err, os.ErrClosed) && d.createTUN == nil {
 			return
 		} else if err != nil {
-			d.logger.Errorf("Failed to read packets from TUN device: %v", err)
-			return
+			select {
+			case <-d.closedCh:
+				return
+			default:
+			}
+			if queue == 0 {
+				d.readFailing.CompareAndSwap(0, time.Now().UnixNano())
+				if d.IsUp() {
+					d.logger.Warnf("Failed to read packets from TUN device, waiting for interface up: %v", err)
+					d.setState(false)
+				}
+			}
+			if queue == 0 || !d.IsUp() {
+				d.waitUp(retryInterval)
+			} else if !d.sleep(minReadRetryInterval) {
+				// other queues fail while the interface is recreated
+				return
+			}
+			retryInterval *= 2
+			if retryInterval > maxReadRetryInterval {
+				retryInterval = maxReadRetryInterval
+			}
 		}
 	}
 }
@@ -250,7 +640,7 @@ func (data *Packet) RecalculateChecksum() {
 	)
 
 	if data.IsIPv6 {
-		// TODO
+		data.recalculateChecksumIPv6()
 	} else {
 		ipHeaderLen := int(data.Packet[0]&0x0f) << 2
 		copy(data.Packet[ipv4offsetChecksum:], []byte{0, 0})
@@ -268,6 +658,15 @@ func (data *Packet) RecalculateChecksum() {
 			copy(data.Packet[udpOffsetChecksum:], []byte{0, 0})
 			checksum := checksumIPv4TCPUDP(data.Packet[ipHeaderLen:], uint32(protocol), data.Src, data.Dst)
 			binary.BigEndian.PutUint16(data.Packet[udpOffsetChecksum:], checksum)
+		case ipProtocolICMP:
+			// icmp checksum doesn't cover addresses, but quoted datagram of errors is translated, see translateICMPError
+			icmpOffset, ok := ipv4ICMPOffset(data.Packet)
+			if !ok {
+				return
+			}
+			copy(data.Packet[icmpOffset+2:], []byte{0, 0})
+			checksum := tcpipChecksum(data.Packet[icmpOffset:], 0)
+			binary.BigEndian.PutUint16(data.Packet[icmpOffset+2:], checksum)
 		}
 	}
 }
@@ -2,10 +2,17 @@ package vpn
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/tun"
 )
 
 // TODO: also test tcp packets, ip packets with variable header size
@@ -16,6 +23,185 @@ func TestPacket_RecalculateChecksum(t *testing.T) {
 	a.Equal(rawData, packet.Packet)
 }
 
+func TestDevice_InterfaceDownUp(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	var states []bool
+	statesCh := make(chan bool, 10)
+	dev.SubscribeStateChanges(func(up bool) {
+		statesCh <- up
+	})
+	_, rawData := testUDPPacket()
+	assertPacketRead := func() {
+		fake.packets <- rawData
+		select {
+		case packet := <-dev.OutboundChans()[0]:
+			a.Equal(rawData, packet.Packet)
+			dev.PutTempPacket(packet)
+		case <-time.After(time.Second):
+			a.Fail("packet was not read")
+		}
+	}
+	assertPacketRead()
+
+	// down event
+	fake.down.Store(true)
+	fake.events <- tun.EventDown
+	states = append(states, <-statesCh)
+	a.False(dev.IsUp())
+	packet, _ := testUDPPacket()
+	a.ErrorIs(dev.WritePacket(packet, net.IPv4(10, 66, 0, 2).To4(), nil), ErrInterfaceDown)
+
+	fake.down.Store(false)
+	fake.events <- tun.EventUp
+	states = append(states, <-statesCh)
+	a.True(dev.IsUp())
+	assertPacketRead()
+
+	// read errors without events
+	fake.down.Store(true)
+	fake.packets <- rawData
+	states = append(states, <-statesCh)
+	a.False(dev.IsUp())
+	fake.down.Store(false)
+	fake.packets <- rawData
+	states = append(states, <-statesCh)
+	a.True(dev.IsUp())
+	select {
+	case packet := <-dev.OutboundChans()[0]:
+		dev.PutTempPacket(packet)
+	case <-time.After(time.Second):
+		a.Fail("packet was not read")
+	}
+	assertPacketRead()
+
+	a.Equal([]bool{false, true, false, true}, states)
+}
+
+func TestDevice_Paused(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+
+	statesCh := make(chan bool, 10)
+	dev.SubscribeStateChanges(func(up bool) {
+		statesCh <- up
+	})
+	a.NoError(dev.SetPaused(true))
+	a.False(<-statesCh)
+	a.True(dev.IsPaused())
+	a.False(dev.IsUp())
+	a.NoError(dev.SetPaused(true))
+
+	// packets are dropped and the interface isn't brought up by reads or events while it's paused
+	_, rawData := testUDPPacket()
+	fake.packets <- rawData
+	fake.events <- tun.EventUp
+	select {
+	case <-dev.OutboundChans()[0]:
+		a.Fail("packet was read while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	a.False(dev.IsUp())
+	packet, _ := testUDPPacket()
+	a.ErrorIs(dev.WritePacket(packet, net.IPv4(10, 66, 0, 2).To4(), nil), ErrInterfaceDown)
+
+	a.NoError(dev.SetPaused(false))
+	a.True(<-statesCh)
+	a.False(dev.IsPaused())
+	a.True(dev.IsUp())
+	fake.packets <- rawData
+	select {
+	case packet := <-dev.OutboundChans()[0]:
+		a.Equal(rawData, packet.Packet)
+		dev.PutTempPacket(packet)
+	case <-time.After(time.Second):
+		a.Fail("packet was not read after resume")
+	}
+	a.Empty(statesCh)
+}
+
+func TestDevice_Recreate(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), 1)
+	a.NoError(err)
+	defer dev.Close()
+	a.ErrorIs(dev.Recreate(), ErrRecreateUnsupported)
+
+	recreated := newFakeTUN()
+	dev.createTUN = func() (tun.Device, error) {
+		return recreated, nil
+	}
+	a.True(dev.ReadFailingSince().IsZero())
+	fake.down.Store(true)
+	a.Eventually(func() bool {
+		return !dev.ReadFailingSince().IsZero()
+	}, time.Second, 10*time.Millisecond)
+	a.False(dev.IsUp())
+
+	a.NoError(dev.Recreate())
+	a.True(dev.IsUp())
+	_, rawData := testUDPPacket()
+	recreated.packets <- rawData
+	select {
+	case packet := <-dev.OutboundChans()[0]:
+		a.Equal(rawData, packet.Packet)
+		dev.PutTempPacket(packet)
+	case <-time.After(time.Second):
+		a.Fail("packet was not read from recreated interface")
+	}
+	a.True(dev.ReadFailingSince().IsZero())
+
+	packet, _ := testUDPPacket()
+	a.NoError(dev.WritePacket(packet, net.IPv4(10, 66, 0, 2).To4(), nil))
+	select {
+	case <-recreated.written:
+	case <-time.After(time.Second):
+		a.Fail("packet was not written to recreated interface")
+	}
+}
+
+func TestDevice_OutboundChans(t *testing.T) {
+	a := require.New(t)
+	fake := newFakeTUN()
+	const queues = 4
+	dev, err := NewDevice(fake, "", net.IPv4(10, 66, 0, 1).To4(), net.CIDRMask(16, 32), testLocalIPv6, net.CIDRMask(112, 128), queues)
+	a.NoError(err)
+	defer dev.Close()
+	outboundChs := dev.OutboundChans()
+	a.Len(outboundChs, queues)
+
+	usedChs := make(map[int]bool)
+	for port := 1000; port < 1064; port++ {
+		packet, rawData := testUDPPacket()
+		// different source ports are different flows
+		binary.BigEndian.PutUint16(rawData[20:], uint16(port))
+		binary.BigEndian.PutUint16(packet.Packet[20:], uint16(port))
+		expected := int(packet.FlowHash() % queues)
+		usedChs[expected] = true
+
+		// packets of the flow are always in the same channel
+		for i := 0; i < 2; i++ {
+			fake.packets <- rawData
+			select {
+			case read := <-outboundChs[expected]:
+				a.Equal(rawData, read.Packet)
+				dev.PutTempPacket(read)
+			case <-time.After(time.Second):
+				a.Fail("packet was not read")
+			}
+		}
+	}
+	a.Greater(len(usedChs), 1)
+}
+
 // TODO: bench with bigger packet
 func BenchmarkPacket_RecalculateChecksum(b *testing.B) {
 	packet, _ := testUDPPacket()
@@ -37,3 +223,57 @@ func testUDPPacket() (*Packet, []byte) {
 
 	return packet, data
 }
+
+var errFakeTUNDown = errors.New("fake tun is down")
+
+type fakeTUN struct {
+	packets chan []byte
+	written chan []byte
+	events  chan tun.Event
+	closed  chan struct{}
+	down    atomic.Bool
+}
+
+func newFakeTUN() *fakeTUN {
+	return &fakeTUN{
+		packets: make(chan []byte),
+		written: make(chan []byte, 10),
+		events:  make(chan tun.Event),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (f *fakeTUN) File() *os.File { return nil }
+
+func (f *fakeTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	if f.down.Load() {
+		return 0, errFakeTUNDown
+	}
+	select {
+	case <-f.closed:
+		return 0, os.ErrClosed
+	case msg := <-f.packets:
+		if f.down.Load() {
+			return 0, errFakeTUNDown
+		}
+		sizes[0] = copy(bufs[0][offset:], msg)
+		return 1, nil
+	}
+}
+
+func (f *fakeTUN) Write(bufs [][]byte, offset int) (int, error) {
+	for _, buf := range bufs {
+		f.written <- append([]byte(nil), buf[offset:]...)
+	}
+	return len(bufs), nil
+}
+
+func (f *fakeTUN) MTU() (int, error)        { return InterfaceMTU, nil }
+func (f *fakeTUN) Name() (string, error)    { return "fake", nil }
+func (f *fakeTUN) Events() <-chan tun.Event { return f.events }
+func (f *fakeTUN) BatchSize() int           { return 1 }
+func (f *fakeTUN) Close() error {
+	close(f.closed)
+	close(f.events)
+	return nil
+}
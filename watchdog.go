@@ -0,0 +1,157 @@
+package awl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+)
+
+const (
+	watchdogCheckInterval = 10 * time.Second
+	// watchdogMinBackoff is the delay before the second restart of a subsystem which keeps failing, it's doubled
+	// after each restart up to watchdogMaxBackoff
+	watchdogMinBackoff = 30 * time.Second
+	watchdogMaxBackoff = 10 * time.Minute
+	// tunFailureTimeout is how long reads from TUN should fail before the interface is recreated.
+	// Reads also fail while the interface is down for a while, e.g. during sleep or network reconfiguration.
+	tunFailureTimeout = time.Minute
+)
+
+// SubsystemStatus describes a subsystem watched by Watchdog.
+type SubsystemStatus struct {
+	Name     string
+	Healthy  bool
+	Restarts int
+	// LastRestart is zero if the subsystem wasn't restarted
+	LastRestart time.Time
+	// LastError is the error of the last restart, empty if it succeeded
+	LastError string
+}
+
+// Watchdog restarts individual subsystems of the app after unrecoverable errors, so the process doesn't
+// have to be restarted. Subsystems which keep failing are restarted with exponential backoff.
+type Watchdog struct {
+	logger *log.ZapEventLogger
+
+	lock       sync.Mutex
+	subsystems []*watchedSubsystem
+}
+
+type watchedSubsystem struct {
+	failed  func() bool
+	restart func() error
+
+	status      SubsystemStatus
+	backoff     time.Duration
+	nextRestart time.Time
+}
+
+func NewWatchdog() *Watchdog {
+	return &Watchdog{
+		logger: log.Logger("awl/watchdog"),
+	}
+}
+
+// Watch adds the subsystem. failed should return true only if the subsystem can't recover by itself.
+func (w *Watchdog) Watch(name string, failed func() bool, restart func() error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.subsystems = append(w.subsystems, &watchedSubsystem{
+		failed:  failed,
+		restart: restart,
+		status:  SubsystemStatus{Name: name, Healthy: true},
+	})
+}
+
+// Status returns statuses of the subsystems in order they were added.
+func (w *Watchdog) Status() []SubsystemStatus {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	statuses := make([]SubsystemStatus, 0, len(w.subsystems))
+	for _, subsystem := range w.subsystems {
+		statuses = append(statuses, subsystem.status)
+	}
+	return statuses
+}
+
+func (w *Watchdog) BackgroundRun(ctx context.Context) {
+	ticker := time.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx, time.Now())
+		}
+	}
+}
+
+// check restarts failed subsystems, it's stopped between restarts if ctx is done, e.g. the app is closing.
+func (w *Watchdog) check(ctx context.Context, now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, subsystem := range w.subsystems {
+		if ctx.Err() != nil {
+			return
+		}
+		status := &subsystem.status
+		if !subsystem.failed() {
+			if !status.Healthy {
+				w.logger.Infof("%s has recovered", status.Name)
+			}
+			status.Healthy = true
+			// backoff is reset only if the subsystem works for a while after the restart
+			if now.Sub(status.LastRestart) > watchdogMaxBackoff {
+				subsystem.backoff = 0
+			}
+			continue
+		}
+
+		status.Healthy = false
+		if now.Before(subsystem.nextRestart) {
+			continue
+		}
+		w.logger.Warnf("%s has failed, restarting it", status.Name)
+		err := subsystem.restart()
+		status.Restarts++
+		status.LastRestart = now
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+			w.logger.Errorf("restart %s: %v", status.Name, err)
+		}
+
+		subsystem.backoff = min(max(2*subsystem.backoff, watchdogMinBackoff), watchdogMaxBackoff)
+		subsystem.nextRestart = now.Add(subsystem.backoff)
+	}
+}
+
+// watchSubsystems registers parts of the app, which could be restarted separately, in the watchdog.
+func (a *Application) watchSubsystems() {
+	if listenAddrs := a.Conf.GetListenAddresses(); len(listenAddrs) > 0 {
+		a.Watchdog.Watch("p2p host", func() bool {
+			return !a.P2p.IsListening()
+		}, func() error {
+			return a.P2p.Relisten(a.Conf.GetListenAddresses())
+		})
+	}
+	if a.vpnDevice.CanRecreate() {
+		a.Watchdog.Watch("TUN interface", func() bool {
+			failingSince := a.vpnDevice.ReadFailingSince()
+			return !a.vpnDevice.IsPaused() && !failingSince.IsZero() && time.Since(failingSince) > tunFailureTimeout
+		}, func() error {
+			err := a.vpnDevice.Recreate()
+			if err != nil {
+				return err
+			}
+			a.Routing.Refresh()
+			a.Dns.reapplyOSConfig()
+			return nil
+		})
+	}
+	a.Watchdog.Watch("api server", a.Api.Failed, a.Api.Restart)
+	a.watchDNS()
+}